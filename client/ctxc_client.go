@@ -308,6 +308,9 @@ type rpcProgress struct {
 	HighestBlock  hexutil.Uint64
 	PulledStates  hexutil.Uint64
 	KnownStates   hexutil.Uint64
+	SampledSeals  hexutil.Uint64
+	VerifiedSeals hexutil.Uint64
+	KnownSeals    hexutil.Uint64
 }
 
 // SyncProgress retrieves the current progress of the sync algorithm. If there's
@@ -332,6 +335,9 @@ func (ec *Client) SyncProgress(ctx context.Context) (*cortex.SyncProgress, error
 		HighestBlock:  uint64(progress.HighestBlock),
 		PulledStates:  uint64(progress.PulledStates),
 		KnownStates:   uint64(progress.KnownStates),
+		SampledSeals:  uint64(progress.SampledSeals),
+		VerifiedSeals: uint64(progress.VerifiedSeals),
+		KnownSeals:    uint64(progress.KnownSeals),
 	}, nil
 }
 
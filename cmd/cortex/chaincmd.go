@@ -23,6 +23,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -37,7 +38,10 @@ import (
 	"github.com/CortexFoundation/CortexTheseus/ctxcdb"
 	"github.com/CortexFoundation/CortexTheseus/event"
 	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/params"
 	"github.com/CortexFoundation/CortexTheseus/trie"
+	"github.com/CortexFoundation/inference/synapse"
+	"github.com/CortexFoundation/torrentfs"
 	"gopkg.in/urfave/cli.v1"
 )
 
@@ -80,6 +84,8 @@ The dumpgenesis command dumps the genesis block configuration in JSON format to
 			utils.CacheFlag,
 			utils.SyncModeFlag,
 			utils.GCModeFlag,
+			utils.StateDiffsFlag,
+			utils.StateDiffCheckpointFlag,
 			utils.CacheDatabaseFlag,
 			utils.CacheGCFlag,
 			utils.MetricsEnabledFlag,
@@ -93,14 +99,25 @@ The dumpgenesis command dumps the genesis block configuration in JSON format to
 			utils.MetricsInfluxDBPasswordFlag,
 			utils.MetricsInfluxDBTagsFlag,
 			utils.TxLookupLimitFlag,
+			utils.AncientFlag,
+			utils.ImportNoFetchFlag,
 		},
 		Category: "BLOCKCHAIN COMMANDS",
 		Description: `
 The import command imports blocks from an RLP-encoded form. The form can be one file
-with several RLP-encoded blocks, or several files can be used.
+with several RLP-encoded blocks, or several files can be used. Files ending with .gz
+are gunzipped.
 
 If only one file is used, import error will result in failure. If several files are used,
-processing will proceed even if an individual RLP-file import failure occurs.`,
+processing will proceed even if an individual RLP-file import failure occurs.
+
+The import progress is persisted after every batch of blocks, so an interrupted import
+resumes where it stopped when it is run again with the same file.
+
+The model and input files uploaded by the imported blocks are downloaded by the storage
+layer during the import, starting with the ones listed in the manifest written by the
+export command next to the file. Use --import.nofetch to rely on the locally available
+files only.`,
 	}
 	exportCommand = cli.Command{
 		Action:    utils.MigrateFlags(exportChain),
@@ -109,6 +126,7 @@ processing will proceed even if an individual RLP-file import failure occurs.`,
 		ArgsUsage: "<filename> [<blockNumFirst> <blockNumLast>]",
 		Flags: []cli.Flag{
 			utils.DataDirFlag,
+			utils.AncientFlag,
 			utils.CacheFlag,
 			utils.SyncModeFlag,
 		},
@@ -118,7 +136,11 @@ Requires a first argument of the file to write to.
 Optional second and third arguments control the first and
 last block to write. In this mode, the file will be appended
 if already existing. If the file ends with .gz, the output will
-be gzipped.`,
+be gzipped.
+
+A manifest of the model and input files uploaded by the exported
+blocks is written next to the file (<filename>.manifest.json), so
+they can be fetched up front when the blocks are imported.`,
 	}
 	importPreimagesCommand = cli.Command{
 		Action:    utils.MigrateFlags(importPreimages),
@@ -147,12 +169,65 @@ be gzipped.`,
 		Category: "BLOCKCHAIN COMMANDS",
 		Description: `
 The export-preimages command export hash preimages to an RLP encoded stream`,
+	}
+	importHistoryCommand = cli.Command{
+		Action:    utils.MigrateFlags(importHistory),
+		Name:      "import-history",
+		Usage:     "Import an era archive of historical blocks and receipts",
+		ArgsUsage: "<dir>",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.CacheFlag,
+			utils.TxLookupLimitFlag,
+		},
+		Category: "BLOCKCHAIN COMMANDS",
+		Description: `
+The import-history command imports the era archives of the network found in the
+given directory straight into the ancient store, without executing the blocks.
+Every archive is verified against checksums.txt (if present) and its accumulator
+root before being imported.
+
+The state of the imported blocks is not available afterwards, it has to be
+synced from the network with --syncmode fast or snap.`,
+	}
+	exportHistoryCommand = cli.Command{
+		Action:    utils.MigrateFlags(exportHistory),
+		Name:      "export-history",
+		Usage:     "Export historical blocks and receipts into era archives",
+		ArgsUsage: "<dir> <first> <last>",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.CacheFlag,
+		},
+		Category: "BLOCKCHAIN COMMANDS",
+		Description: `
+The export-history command exports the canonical blocks first to last (inclusive)
+into the given directory, as era archives of 8192 blocks each. The first block
+has to be at the start of an epoch. The checksums of the archives are written into
+checksums.txt.`,
+	}
+	verifyHistoryCommand = cli.Command{
+		Action:    utils.MigrateFlags(verifyHistory),
+		Name:      "verify-history",
+		Usage:     "Verify the era archives of historical blocks and receipts",
+		ArgsUsage: "<dir>",
+		Flags: []cli.Flag{
+			utils.BernardFlag,
+			utils.DoloresFlag,
+		},
+		Category: "BLOCKCHAIN COMMANDS",
+		Description: `
+The verify-history command checks the era archives of the network found in the
+given directory without a database: the checksums, the accumulator roots and the
+consistency of the blocks, receipts and total difficulties.`,
 	}
 	copydbCommand = cli.Command{
 		Action:    utils.MigrateFlags(copyDb),
 		Name:      "copydb",
 		Usage:     "Create a local chain from a target chaindata folder",
-		ArgsUsage: "<sourceChaindataDir>",
+		ArgsUsage: "<sourceChaindataDir> [<sourceAncientDir>]",
 		Flags: []cli.Flag{
 			utils.DataDirFlag,
 			utils.CacheFlag,
@@ -164,7 +239,9 @@ The export-preimages command export hash preimages to an RLP encoded stream`,
 		},
 		Category: "BLOCKCHAIN COMMANDS",
 		Description: `
-The first argument must be the directory containing the blockchain to download from`,
+The first argument must be the directory containing the blockchain to download from.
+The optional second argument is the directory of its ancient store, defaulting to
+the "ancient" folder inside the chaindata.`,
 	}
 	removedbCommand = cli.Command{
 		Action:    utils.MigrateFlags(removeDB),
@@ -185,13 +262,17 @@ Remove blockchain and state databases`,
 		ArgsUsage: "[<blockHash> | <blockNum>]...",
 		Flags: []cli.Flag{
 			utils.DataDirFlag,
+			utils.AncientFlag,
 			utils.CacheFlag,
 			utils.SyncModeFlag,
+			utils.RemoteDBFlag,
 		},
 		Category: "BLOCKCHAIN COMMANDS",
 		Description: `
 The arguments are interpreted as block numbers or hashes.
-Use "cortex dump 0" to dump the genesis block.`,
+Use "cortex dump 0" to dump the genesis block.
+
+With --remotedb the state is read from a running node started with --rpc.dbapi.`,
 	}
 	inspectCommand = cli.Command{
 		Action:    utils.MigrateFlags(inspect),
@@ -203,8 +284,11 @@ Use "cortex dump 0" to dump the genesis block.`,
 			utils.AncientFlag,
 			utils.CacheFlag,
 			utils.SyncModeFlag,
+			utils.RemoteDBFlag,
 		},
 		Category: "BLOCKCHAIN COMMANDS",
+		Description: `
+With --remotedb the database of a running node started with --rpc.dbapi is inspected.`,
 	}
 )
 
@@ -253,14 +337,57 @@ func dumpGenesis(ctx *cli.Context) error {
 	return nil
 }
 
+// startImportEngine sets up the storage layer and the inference engine used by
+// the virtual machine while processing the imported blocks, returning a function
+// tearing them down. The storage layer is stopped along with the engine.
+func startImportEngine(ctx *cli.Context, cfg *cortexConfig) (func(), error) {
+	var storage torrentfs.CortexStorage
+	if ctx.GlobalBool(utils.StorageEnabledFlag.Name) || !strings.HasPrefix(ctx.GlobalString(utils.InferDeviceTypeFlag.Name), "remote") {
+		fs, err := torrentfs.New(&cfg.TorrentFs, true, false, false)
+		if err != nil {
+			return nil, err
+		}
+		storage = fs
+		if ctx.GlobalBool(utils.ImportNoFetchFlag.Name) {
+			storage = utils.NoFetchStorage{CortexStorage: fs}
+		}
+	}
+	engine := synapse.New(&synapse.Config{
+		DeviceType:     cfg.Cortex.InferDeviceType,
+		DeviceId:       cfg.Cortex.InferDeviceId,
+		MaxMemoryUsage: cfg.Cortex.InferMemoryUsage,
+		IsRemoteInfer:  cfg.Cortex.InferURI != "",
+		InferURI:       cfg.Cortex.InferURI,
+		IsNotCache:     false,
+		Storagefs:      storage,
+	})
+	return engine.Close, nil
+}
+
+// prefetchManifest schedules the download of the files listed in the manifest
+// of the given export file, unless fetching was disabled.
+func prefetchManifest(ctx *cli.Context, fn string) {
+	if ctx.GlobalBool(utils.ImportNoFetchFlag.Name) {
+		return
+	}
+	utils.PrefetchManifest(synapse.Engine(), fn)
+}
+
 func importChain(ctx *cli.Context) error {
 	if len(ctx.Args()) < 1 {
 		utils.Fatalf("This command requires an argument.")
 	}
-	stack := makeFullNode(ctx)
+	stack, cfg := makeConfigNode(ctx)
 	chain, chainDb := utils.MakeChain(ctx, stack, false)
 	defer chainDb.Close()
 
+	// Set up the inference engine needed to process the imported blocks
+	stopEngine, err := startImportEngine(ctx, &cfg)
+	if err != nil {
+		utils.Fatalf("Failed to start the inference engine: %v", err)
+	}
+	defer stopEngine()
+
 	// Start periodically gathering memory profiles
 	var peakMemAlloc, peakMemSys uint64
 	go func() {
@@ -280,15 +407,24 @@ func importChain(ctx *cli.Context) error {
 	start := time.Now()
 
 	var importErr error
-
 	if len(ctx.Args()) == 1 {
-		if err := utils.ImportChain(chain, ctx.Args().First()); err != nil {
+		prefetchManifest(ctx, ctx.Args().First())
+		name, err := utils.ImportJournalName(ctx.Args().First())
+		if err != nil {
+			utils.Fatalf("Failed to resolve import journal: %v", err)
+		}
+		if err := utils.ImportChain(chain, ctx.Args().First(), stack.ResolvePath(name)); err != nil {
 			importErr = err
 			log.Error("Import error", "err", err)
 		}
 	} else {
 		for _, arg := range ctx.Args() {
-			if err := utils.ImportChain(chain, arg); err != nil {
+			prefetchManifest(ctx, arg)
+			name, err := utils.ImportJournalName(arg)
+			if err != nil {
+				utils.Fatalf("Failed to resolve import journal: %v", err)
+			}
+			if err := utils.ImportChain(chain, arg, stack.ResolvePath(name)); err != nil {
 				importErr = err
 				log.Error("Import error", "file", arg, "err", err)
 			}
@@ -300,19 +436,8 @@ func importChain(ctx *cli.Context) error {
 	// Output pre-compaction stats mostly to see the import trashing
 	db := chainDb.(ctxcdb.Database)
 
-	//stats, err := db.LDB().GetProperty("leveldb.stats")
-	stats, err := db.Stat("leveldb.stats")
-	if err != nil {
-		utils.Fatalf("Failed to read database stats: %v", err)
-	}
-	fmt.Println(stats)
-
-	//ioStats, err := db.LDB().GetProperty("leveldb.iostats")
-	ioStats, err := db.Stat("leveldb.iostats")
-	if err != nil {
-		utils.Fatalf("Failed to read database iostats: %v", err)
-	}
-	fmt.Println(ioStats)
+	backend := rawdb.PreexistingDatabase(stack.ResolvePath("chaindata"))
+	showDBStats(db, backend)
 
 	//fmt.Printf("Trie cache misses:  %d\n", trie.CacheMisses())
 	//fmt.Printf("Trie cache unloads: %d\n\n", trie.CacheUnloads())
@@ -338,17 +463,7 @@ func importChain(ctx *cli.Context) error {
 	}
 	fmt.Printf("Compaction done in %v.\n\n", time.Since(start))
 
-	stats, err = db.Stat("leveldb.stats")
-	if err != nil {
-		utils.Fatalf("Failed to read database stats: %v", err)
-	}
-	fmt.Println(stats)
-
-	ioStats, err = db.Stat("leveldb.iostats")
-	if err != nil {
-		utils.Fatalf("Failed to read database iostats: %v", err)
-	}
-	fmt.Println(ioStats)
+	showDBStats(db, backend)
 	return importErr
 }
 
@@ -356,27 +471,35 @@ func exportChain(ctx *cli.Context) error {
 	if len(ctx.Args()) < 1 {
 		utils.Fatalf("This command requires an argument.")
 	}
-	stack := makeFullNode(ctx)
+	stack, _ := makeConfigNode(ctx)
 	chain, _ := utils.MakeChain(ctx, stack, true)
 	start := time.Now()
 
-	var err error
-	fp := ctx.Args().First()
-	if len(ctx.Args()) < 3 {
+	var (
+		err      error
+		fp       = ctx.Args().First()
+		first    = uint64(0)
+		last     = chain.CurrentBlock().NumberU64()
+		appended = len(ctx.Args()) >= 3
+	)
+	if !appended {
 		err = utils.ExportChain(chain, fp)
 	} else {
 		// This can be improved to allow for numbers larger than 9223372036854775807
-		first, ferr := strconv.ParseInt(ctx.Args().Get(1), 10, 64)
-		last, lerr := strconv.ParseInt(ctx.Args().Get(2), 10, 64)
+		firstArg, ferr := strconv.ParseInt(ctx.Args().Get(1), 10, 64)
+		lastArg, lerr := strconv.ParseInt(ctx.Args().Get(2), 10, 64)
 		if ferr != nil || lerr != nil {
 			utils.Fatalf("Export error in parsing parameters: block number not an integer\n")
 		}
-		if first < 0 || last < 0 {
+		if firstArg < 0 || lastArg < 0 {
 			utils.Fatalf("Export error: block number must be greater than 0\n")
 		}
-		err = utils.ExportAppendChain(chain, fp, uint64(first), uint64(last))
+		first, last = uint64(firstArg), uint64(lastArg)
+		err = utils.ExportAppendChain(chain, fp, first, last)
+	}
+	if err == nil {
+		err = utils.ExportManifest(chain, fp, first, last, appended)
 	}
-
 	if err != nil {
 		utils.Fatalf("Export error: %v\n", err)
 	}
@@ -416,14 +539,73 @@ func exportPreimages(ctx *cli.Context) error {
 	return nil
 }
 
+func importHistory(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		utils.Fatalf("This command requires an argument.")
+	}
+	stack, _ := makeConfigNode(ctx)
+	chain, db := utils.MakeChain(ctx, stack, false)
+	defer db.Close()
+
+	start := time.Now()
+	if err := utils.ImportHistory(chain, ctx.Args().First()); err != nil {
+		utils.Fatalf("Import error: %v\n", err)
+	}
+	chain.Stop()
+	fmt.Printf("Import done in %v\n", time.Since(start))
+	return nil
+}
+
+func exportHistory(ctx *cli.Context) error {
+	if len(ctx.Args()) != 3 {
+		utils.Fatalf("This command requires three arguments.")
+	}
+	stack, _ := makeConfigNode(ctx)
+	chain, db := utils.MakeChain(ctx, stack, true)
+	defer db.Close()
+
+	first, ferr := strconv.ParseUint(ctx.Args().Get(1), 10, 64)
+	last, lerr := strconv.ParseUint(ctx.Args().Get(2), 10, 64)
+	if ferr != nil || lerr != nil {
+		utils.Fatalf("Export error in parsing parameters: block number not an integer\n")
+	}
+	start := time.Now()
+	if err := utils.ExportHistory(chain, db, ctx.Args().First(), first, last); err != nil {
+		utils.Fatalf("Export error: %v\n", err)
+	}
+	fmt.Printf("Export done in %v\n", time.Since(start))
+	return nil
+}
+
+func verifyHistory(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		utils.Fatalf("This command requires an argument.")
+	}
+	genesis := params.MainnetGenesisHash
+	switch {
+	case ctx.GlobalBool(utils.BernardFlag.Name):
+		genesis = params.BernardGenesisHash
+	case ctx.GlobalBool(utils.DoloresFlag.Name):
+		genesis = params.DoloresGenesisHash
+	}
+	start := time.Now()
+	if err := utils.VerifyHistory(ctx.Args().First(), genesis); err != nil {
+		utils.Fatalf("Verification error: %v\n", err)
+	}
+	fmt.Printf("Verification done in %v\n", time.Since(start))
+	return nil
+}
+
 func copyDb(ctx *cli.Context) error {
 	// Ensure we have a source chain directory to copy
-	if len(ctx.Args()) < 1 {
+	if len(ctx.Args()) < 1 || ctx.Args().First() == "" {
 		utils.Fatalf("Source chaindata directory path argument missing")
 	}
-
-	if len(ctx.Args()) < 2 {
-		utils.Fatalf("Source ancient chain directory path argument missing")
+	// Default the source freezer to its location inside the chaindata, the same
+	// as the node does, so frozen blocks are never silently left out of the copy
+	ancient := ctx.Args().Get(1)
+	if ancient == "" {
+		ancient = filepath.Join(ctx.Args().First(), "ancient")
 	}
 	// Initialize a new chain for the running node to sync into
 	stack := makeFullNode(ctx)
@@ -434,11 +616,16 @@ func copyDb(ctx *cli.Context) error {
 	if syncMode == downloader.FastSync {
 		syncBloom = trie.NewSyncBloom(uint64(ctx.GlobalInt(utils.CacheFlag.Name)/2), chainDb)
 	}
-	dl := downloader.New(0, chainDb, syncBloom, new(event.TypeMux), chain, nil)
+	dl := downloader.New(0, chainDb, syncBloom, new(event.TypeMux), chain, nil, nil)
 
 	// Create a source peer to satisfy downloader requests from
 	//db, err := ctxcdb.NewLevelDatabase(ctx.Args().First(), ctx.GlobalInt(utils.CacheFlag.Name), 256)
-	db, err := rawdb.NewLevelDBDatabaseWithFreezer(ctx.Args().First(), ctx.GlobalInt(utils.CacheFlag.Name)/2, 256, ctx.Args().Get(1), "")
+	db, err := rawdb.Open(rawdb.OpenOptions{
+		Directory:         ctx.Args().First(),
+		AncientsDirectory: ancient,
+		Cache:             ctx.GlobalInt(utils.CacheFlag.Name) / 2,
+		Handles:           256,
+	})
 	if err != nil {
 		return err
 	}
@@ -534,15 +721,24 @@ func confirmAndRemoveDB(database string, kind string) {
 }
 
 func dump(ctx *cli.Context) error {
-	stack := makeFullNode(ctx)
-	chain, chainDb := utils.MakeChain(ctx, stack, true)
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	// Read the blocks straight from the database, the chain isn't needed and
+	// setting it up would fail on a read-only remote database.
+	chainDb := utils.MakeChainDatabase(ctx, stack)
 	for _, arg := range ctx.Args() {
 		var block *types.Block
 		if hashish(arg) {
-			block = chain.GetBlockByHash(common.HexToHash(arg))
+			hash := common.HexToHash(arg)
+			if number := rawdb.ReadHeaderNumber(chainDb, hash); number != nil {
+				block = rawdb.ReadBlock(chainDb, hash, *number)
+			}
 		} else {
 			num, _ := strconv.Atoi(arg)
-			block = chain.GetBlockByNumber(uint64(num))
+			if hash := rawdb.ReadCanonicalHash(chainDb, uint64(num)); hash != (common.Hash{}) {
+				block = rawdb.ReadBlock(chainDb, hash, uint64(num))
+			}
 		}
 		if block == nil {
 			fmt.Println("{}")
@@ -563,7 +759,7 @@ func inspect(ctx *cli.Context) error {
 	node, _ := makeConfigNode(ctx)
 	defer node.Close()
 
-	_, chainDb := utils.MakeChain(ctx, node, true)
+	chainDb := utils.MakeChainDatabase(ctx, node)
 	defer chainDb.Close()
 
 	return rawdb.InspectDatabase(chainDb)
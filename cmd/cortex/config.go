@@ -171,6 +171,11 @@ func makeFullNode(ctx *cli.Context) *node.Node {
 
 	utils.RegisterCortexService(stack, &cfg.Cortex)
 
+	// Configure GraphQL if requested
+	if ctx.GlobalIsSet(utils.GraphQLEnabledFlag.Name) {
+		utils.RegisterGraphQLService(stack, cfg.Node.GraphQLCors, cfg.Node.GraphQLVirtualHosts)
+	}
+
 	// if ctx.GlobalBool(utils.DashboardEnabledFlag.Name) {
 	// 	utils.RegisterDashboardService(stack, &cfg.Dashboard, gitCommit)
 	// }
@@ -0,0 +1,918 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of CortexFoundation.
+//
+// CortexFoundation is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// CortexFoundation is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with CortexFoundation. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/cmd/utils"
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
+	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
+	"github.com/CortexFoundation/CortexTheseus/core/state/snapshot"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/ctxcdb"
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/node"
+	"github.com/CortexFoundation/CortexTheseus/rlp"
+	"github.com/CortexFoundation/CortexTheseus/trie"
+	"github.com/olekukonko/tablewriter"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	dbCommand = cli.Command{
+		Name:        "db",
+		Usage:       "Low level database operations",
+		ArgsUsage:   "",
+		Category:    "DATABASE COMMANDS",
+		Description: "",
+		Subcommands: []cli.Command{
+			dbGetCmd,
+			dbDeleteCmd,
+			dbPutCmd,
+			dbStatCmd,
+			dbCompactCmd,
+			dbDumpTrieCmd,
+			dbMetadataCmd,
+			dbFreezerIndexCmd,
+			dbFreezerCommand,
+		},
+	}
+	dbGetCmd = cli.Command{
+		Action:    utils.MigrateFlags(dbGet),
+		Name:      "get",
+		Usage:     "Show the value of a database key",
+		ArgsUsage: "<hex-encoded key>",
+		Category:  "DATABASE COMMANDS",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.BernardFlag,
+			utils.RemoteDBFlag,
+		},
+		Description: `
+cortex db get <key>
+will print the raw value stored under the given key, which is either hex encoded
+with a 0x prefix or taken as a plain string otherwise.`,
+	}
+	dbDeleteCmd = cli.Command{
+		Action:    utils.MigrateFlags(dbDelete),
+		Name:      "delete",
+		Usage:     "Delete a database key (WARNING: may corrupt your database)",
+		ArgsUsage: "<hex-encoded key>",
+		Category:  "DATABASE COMMANDS",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.BernardFlag,
+		},
+		Description: `
+cortex db delete <key>
+will delete the given key from the database, printing the value it had. This
+command bypasses all consistency checks and may corrupt the database.`,
+	}
+	dbPutCmd = cli.Command{
+		Action:    utils.MigrateFlags(dbPut),
+		Name:      "put",
+		Usage:     "Set the value of a database key (WARNING: may corrupt your database)",
+		ArgsUsage: "<hex-encoded key> <hex-encoded value>",
+		Category:  "DATABASE COMMANDS",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.BernardFlag,
+		},
+		Description: `
+cortex db put <key> <value>
+will store the given value under the given key, printing the previous value if
+there was one. This command bypasses all consistency checks and may corrupt the
+database.`,
+	}
+	dbStatCmd = cli.Command{
+		Action:   utils.MigrateFlags(dbStats),
+		Name:     "stats",
+		Usage:    "Print the internal statistics of the key-value store",
+		Category: "DATABASE COMMANDS",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.BernardFlag,
+		},
+		Description: `
+cortex db stats
+will print the compaction, level and IO statistics of the database engine.`,
+	}
+	dbCompactCmd = cli.Command{
+		Action:    utils.MigrateFlags(dbCompact),
+		Name:      "compact",
+		Usage:     "Compact the key-value store (WARNING: may take a very long time)",
+		ArgsUsage: "[<hex-encoded start> [<hex-encoded end>]]",
+		Category:  "DATABASE COMMANDS",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.BernardFlag,
+			utils.CacheFlag,
+			utils.CacheDatabaseFlag,
+		},
+		Description: `
+cortex db compact [<start> [<end>]]
+will compact the given key range of the database, or the entire database if no
+range is given, printing the database statistics before and after.`,
+	}
+	dbDumpTrieCmd = cli.Command{
+		Action:    utils.MigrateFlags(dbDumpTrie),
+		Name:      "dumptrie",
+		Usage:     "Show the leaves of a trie",
+		ArgsUsage: "<hex-encoded root> [<hex-encoded start> [<max elements>]]",
+		Category:  "DATABASE COMMANDS",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.BernardFlag,
+			utils.RemoteDBFlag,
+		},
+		Description: `
+cortex db dumptrie <root> [<start> [<max>]]
+will print the keys and values of the leaves of the trie with the given root,
+optionally starting at the given key and stopping after the given number of
+leaves. The keys of the state and storage tries are hashes.`,
+	}
+	dbMetadataCmd = cli.Command{
+		Action:   utils.MigrateFlags(dbMetadata),
+		Name:     "metadata",
+		Usage:    "Show the metadata of the chain database",
+		Category: "DATABASE COMMANDS",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.BernardFlag,
+			utils.RemoteDBFlag,
+		},
+		Description: `
+cortex db metadata
+will print the database schema version, the head pointers of the chain, the
+status of the state snapshot and the transaction index, as well as the number
+of items in the freezer.`,
+	}
+	dbFreezerIndexCmd = cli.Command{
+		Action:    utils.MigrateFlags(dbFreezerIndex),
+		Name:      "freezer-index",
+		Usage:     "Dump the index entries of a freezer table",
+		ArgsUsage: "<table> <first> <last>",
+		Category:  "DATABASE COMMANDS",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.BernardFlag,
+		},
+		Description: `
+cortex db freezer-index <table> <first> <last>
+will print the data file and the end offset of the items first to last
+(inclusive) of the given freezer table (headers, hashes, bodies, receipts or
+diffs). The node must not be running.`,
+	}
+	dbFreezerCommand = cli.Command{
+		Name:        "freezer",
+		Usage:       "Verify, repair and relocate the ancient chain data",
+		ArgsUsage:   "",
+		Category:    "DATABASE COMMANDS",
+		Description: "",
+		Subcommands: []cli.Command{
+			{
+				Name:      "check",
+				Usage:     "Verify the consistency of the freezer tables",
+				ArgsUsage: "",
+				Action:    utils.MigrateFlags(freezerCheck),
+				Category:  "DATABASE COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.AncientFlag,
+					utils.BernardFlag,
+				},
+				Description: `
+cortex db freezer check
+will print the statistics of every freezer table and verify the ancient chain
+data item by item: the index and data files of all tables must be consistent,
+the header hashes and the parent links must match, the transactions, uncles and
+receipts must match the roots in the headers and the total difficulties must add
+up. The freezer is also cross-checked against the key-value store, which must
+share its genesis and continue where the freezer left off.
+
+The node must not be running while the freezer is checked.
+`,
+			},
+			{
+				Name:      "repair",
+				Usage:     "Truncate the freezer to its last consistent item",
+				ArgsUsage: "",
+				Action:    utils.MigrateFlags(freezerRepair),
+				Category:  "DATABASE COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.AncientFlag,
+					utils.BernardFlag,
+				},
+				Description: `
+cortex db freezer repair
+will run the same verification as the check command and truncate all freezer
+tables to the last item preceding the first corruption found. If the chain head
+in the key-value store is not contiguous with the freezer any more, it is rewound
+to the last ancient block, so the node re-downloads the dropped blocks when it is
+started again.
+`,
+			},
+			{
+				Name:      "export-range",
+				Usage:     "Export a range of ancient blocks into a file",
+				ArgsUsage: "<first> <last> <filename>",
+				Action:    utils.MigrateFlags(freezerExportRange),
+				Category:  "DATABASE COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.AncientFlag,
+					utils.BernardFlag,
+				},
+				Description: `
+cortex db freezer export-range <first> <last> <filename>
+will export the ancient blocks first to last (inclusive) as a sequence of RLP
+encoded blocks, reading them directly from the freezer files. If the file ends
+with .gz, the output is gzipped.
+`,
+			},
+			{
+				Name:      "move",
+				Usage:     "Relocate the freezer into a new directory",
+				ArgsUsage: "<directory>",
+				Action:    utils.MigrateFlags(freezerMove),
+				Category:  "DATABASE COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.AncientFlag,
+					utils.BernardFlag,
+				},
+				Description: `
+cortex db freezer move <directory>
+will move the freezer into the given directory, which must not exist yet, e.g.
+to put the ancient chain data onto a cheaper disk. Afterwards the node must be
+started with --datadir.ancient pointing to the new location.
+`,
+			},
+		},
+	}
+)
+
+// freezerDir returns the directory of the chain freezer, either the one set via
+// the ancient flag or the default one inside the chain database.
+func freezerDir(ctx *cli.Context, stack *node.Node) string {
+	dir := ctx.GlobalString(utils.AncientFlag.Name)
+	switch {
+	case dir == "":
+		dir = filepath.Join(stack.ResolvePath("chaindata"), "ancient")
+	case !filepath.IsAbs(dir):
+		dir = stack.ResolvePath(dir)
+	}
+	return dir
+}
+
+// openKeyValueDatabase opens the chain database of the node without attaching
+// the freezer, so the ancient data can be operated on separately.
+func openKeyValueDatabase(ctx *cli.Context, stack *node.Node) ctxcdb.Database {
+	var (
+		cache   = ctx.GlobalInt(utils.CacheFlag.Name) * ctx.GlobalInt(utils.CacheDatabaseFlag.Name) / 100
+		handles = utils.MakeDatabaseHandles()
+	)
+	db, err := stack.OpenDatabase("chaindata", cache, handles, "")
+	if err != nil {
+		utils.Fatalf("Could not open database: %v", err)
+	}
+	return db
+}
+
+// printFreezerTables prints the statistics and issues of all freezer tables.
+func printFreezerTables(reader *rawdb.FreezerReader) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Table", "Compressed", "Tail", "Items", "Files", "Size"})
+	for _, info := range reader.Tables() {
+		table.Append([]string{info.Name, strconv.FormatBool(info.Compressed), strconv.FormatUint(info.Tail, 10), strconv.FormatUint(info.Items, 10), strconv.Itoa(info.Files), common.StorageSize(info.Size).String()})
+	}
+	table.Render()
+
+	for _, info := range reader.Tables() {
+		for _, issue := range info.Issues {
+			log.Warn("Freezer table inconsistent", "table", info.Name, "issue", issue)
+		}
+	}
+}
+
+// verifyFreezer verifies the content of all the consistently stored items of
+// the freezer, returning the number of items preceding the first corrupted one.
+func verifyFreezer(reader *rawdb.FreezerReader, db ctxcdb.Database) (uint64, error) {
+	var (
+		tail   = reader.Tail()
+		frozen = reader.Ancients()
+
+		parent common.Hash
+		td     *big.Int
+
+		start  = time.Now()
+		logged = time.Now()
+	)
+	if frozen == 0 || frozen == tail {
+		return frozen, nil
+	}
+	for number := tail; number < frozen; number++ {
+		if err := verifyAncient(reader, number, &parent, &td); err != nil {
+			log.Error("Corrupted ancient block", "number", number, "err", err)
+			return number, nil
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Verifying ancient blocks", "number", number, "frozen", frozen, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	log.Info("Verified ancient blocks", "count", frozen-tail, "elapsed", common.PrettyDuration(time.Since(start)))
+
+	// Ensure the key-value store belongs to the same chain and continues where the
+	// freezer left off
+	if kvgenesis := rawdb.ReadCanonicalHash(db, 0); kvgenesis != (common.Hash{}) && tail == 0 {
+		frgenesis, err := reader.Ancient("hashes", 0)
+		if err != nil {
+			return 0, err
+		}
+		if common.BytesToHash(frgenesis) != kvgenesis {
+			return 0, fmt.Errorf("genesis mismatch: %x (key-value store) != %x (freezer)", kvgenesis, frgenesis)
+		}
+	}
+	if hash := rawdb.ReadCanonicalHash(db, frozen); hash != (common.Hash{}) {
+		header := rawdb.ReadHeader(db, hash, frozen)
+		if header == nil {
+			log.Warn("Missing first header after the freezer", "number", frozen, "hash", hash)
+		} else if header.ParentHash != parent {
+			log.Warn("Key-value store not contiguous with the freezer", "number", frozen, "parent", header.ParentHash, "ancient", parent)
+		}
+	} else if head := rawdb.ReadHeadHeaderHash(db); head != (common.Hash{}) {
+		if number := rawdb.ReadHeaderNumber(db, head); number != nil && *number >= frozen {
+			log.Warn("Gap between the freezer and the key-value store", "frozen", frozen, "head", *number)
+		}
+	}
+	return frozen, nil
+}
+
+// verifyAncient verifies a single ancient block against the hash and the total
+// difficulty of its parent, updating them to its own.
+func verifyAncient(reader *rawdb.FreezerReader, number uint64, parent *common.Hash, td **big.Int) error {
+	hash, err := reader.Ancient("hashes", number)
+	if err != nil {
+		return fmt.Errorf("hash: %v", err)
+	}
+	blob, err := reader.Ancient("headers", number)
+	if err != nil {
+		return fmt.Errorf("header: %v", err)
+	}
+	header := new(types.Header)
+	if err := rlp.DecodeBytes(blob, header); err != nil {
+		return fmt.Errorf("header: %v", err)
+	}
+	if header.Number.Uint64() != number {
+		return fmt.Errorf("header number mismatch: %d", header.Number)
+	}
+	if have := crypto.Keccak256Hash(blob); have != common.BytesToHash(hash) {
+		return fmt.Errorf("header hash mismatch: have %x, want %x", have, hash)
+	}
+	if *parent != (common.Hash{}) && header.ParentHash != *parent {
+		return fmt.Errorf("parent hash mismatch: have %x, want %x", header.ParentHash, *parent)
+	}
+	// Verify the body and the receipts against the roots in the header
+	if blob, err = reader.Ancient("bodies", number); err != nil {
+		return fmt.Errorf("body: %v", err)
+	}
+	body := new(types.Body)
+	if err := rlp.DecodeBytes(blob, body); err != nil {
+		return fmt.Errorf("body: %v", err)
+	}
+	if have := types.DeriveSha(types.Transactions(body.Transactions), trie.NewStackTrie(nil)); have != header.TxHash {
+		return fmt.Errorf("transaction root mismatch: have %x, want %x", have, header.TxHash)
+	}
+	if have := types.CalcUncleHash(body.Uncles); have != header.UncleHash {
+		return fmt.Errorf("uncle hash mismatch: have %x, want %x", have, header.UncleHash)
+	}
+	if blob, err = reader.Ancient("receipts", number); err != nil {
+		return fmt.Errorf("receipts: %v", err)
+	}
+	var stored []*types.ReceiptForStorage
+	if err := rlp.DecodeBytes(blob, &stored); err != nil {
+		return fmt.Errorf("receipts: %v", err)
+	}
+	receipts := make(types.Receipts, len(stored))
+	for i, receipt := range stored {
+		receipts[i] = (*types.Receipt)(receipt)
+		receipts[i].Bloom = types.CreateBloom(types.Receipts{receipts[i]})
+	}
+	if have := types.DeriveSha(receipts, trie.NewStackTrie(nil)); have != header.ReceiptHash {
+		return fmt.Errorf("receipt root mismatch: have %x, want %x", have, header.ReceiptHash)
+	}
+	// Verify the total difficulty against the parent's
+	if blob, err = reader.Ancient("diffs", number); err != nil {
+		return fmt.Errorf("total difficulty: %v", err)
+	}
+	have := new(big.Int)
+	if err := rlp.DecodeBytes(blob, have); err != nil {
+		return fmt.Errorf("total difficulty: %v", err)
+	}
+	if *td != nil {
+		if want := new(big.Int).Add(*td, header.Difficulty); have.Cmp(want) != 0 {
+			return fmt.Errorf("total difficulty mismatch: have %v, want %v", have, want)
+		}
+	} else if number == 0 && have.Cmp(header.Difficulty) != 0 {
+		return fmt.Errorf("genesis difficulty mismatch: have %v, want %v", have, header.Difficulty)
+	}
+	*parent, *td = common.BytesToHash(hash), have
+	return nil
+}
+
+func freezerCheck(ctx *cli.Context) error {
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	db := openKeyValueDatabase(ctx, stack)
+	defer db.Close()
+
+	reader, err := rawdb.OpenFreezerReader(freezerDir(ctx, stack))
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	printFreezerTables(reader)
+
+	frozen := reader.Ancients()
+	valid, err := verifyFreezer(reader, db)
+	if err != nil {
+		return err
+	}
+	for _, info := range reader.Tables() {
+		if len(info.Issues) > 0 || info.Items != frozen {
+			return fmt.Errorf("freezer tables inconsistent, %d valid items", valid)
+		}
+	}
+	if valid != frozen {
+		return fmt.Errorf("freezer corrupted at item %d", valid)
+	}
+	log.Info("Freezer is consistent", "items", frozen)
+	return nil
+}
+
+func freezerRepair(ctx *cli.Context) error {
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	db := openKeyValueDatabase(ctx, stack)
+	defer db.Close()
+
+	dir := freezerDir(ctx, stack)
+	reader, err := rawdb.OpenFreezerReader(dir)
+	if err != nil {
+		return err
+	}
+	printFreezerTables(reader)
+
+	valid, err := verifyFreezer(reader, db)
+	if err != nil {
+		reader.Close()
+		return err
+	}
+	consistent := valid == reader.Ancients()
+	for _, info := range reader.Tables() {
+		if len(info.Issues) > 0 || info.Items != valid {
+			consistent = false
+		}
+	}
+	if consistent {
+		reader.Close()
+		log.Info("Freezer is consistent, nothing to repair", "items", valid)
+		return nil
+	}
+	if valid == 0 || valid == reader.Tail() {
+		reader.Close()
+		return errors.New("no valid ancient blocks left, please resync")
+	}
+	blob, err := reader.Ancient("hashes", valid-1)
+	reader.Close()
+	if err != nil {
+		return err
+	}
+	// Truncate the freezer and reconnect the chain head to it if needed
+	frozen, err := rawdb.RepairFreezer(dir, valid)
+	if err != nil {
+		return err
+	}
+	log.Info("Repaired freezer", "items", frozen)
+
+	if rawdb.ReadCanonicalHash(db, frozen) != (common.Hash{}) {
+		return nil
+	}
+	head := rawdb.ReadHeadHeaderHash(db)
+	if number := rawdb.ReadHeaderNumber(db, head); number != nil && *number >= frozen {
+		hash := common.BytesToHash(blob)
+
+		batch := db.NewBatch()
+		for n := frozen; n <= *number; n++ {
+			rawdb.DeleteCanonicalHash(batch, n)
+		}
+		rawdb.WriteHeadHeaderHash(batch, hash)
+		rawdb.WriteHeadBlockHash(batch, hash)
+		rawdb.WriteHeadFastBlockHash(batch, hash)
+		if err := batch.Write(); err != nil {
+			return err
+		}
+		log.Warn("Rewound chain head to the freezer", "number", frozen-1, "hash", hash, "dropped", *number-frozen+1)
+	}
+	return nil
+}
+
+func freezerExportRange(ctx *cli.Context) error {
+	if len(ctx.Args()) != 3 {
+		utils.Fatalf("This command requires three arguments.")
+	}
+	first, ferr := strconv.ParseUint(ctx.Args().Get(0), 10, 64)
+	last, lerr := strconv.ParseUint(ctx.Args().Get(1), 10, 64)
+	if ferr != nil || lerr != nil {
+		utils.Fatalf("Export error in parsing parameters: block number not an integer\n")
+	}
+	if first > last {
+		utils.Fatalf("Export error: first block must not be greater than the last one\n")
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	reader, err := rawdb.OpenFreezerReader(freezerDir(ctx, stack))
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if tail, frozen := reader.Tail(), reader.Ancients(); first < tail || last >= frozen {
+		return fmt.Errorf("range #%d-#%d not in the freezer, available #%d-#%d", first, last, tail, frozen-1)
+	}
+	fn := ctx.Args().Get(2)
+	log.Info("Exporting ancient blocks", "first", first, "last", last, "file", fn)
+
+	fh, err := os.OpenFile(fn, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	var writer io.Writer = fh
+	if strings.HasSuffix(fn, ".gz") {
+		writer = gzip.NewWriter(writer)
+		defer writer.(*gzip.Writer).Close()
+	}
+	start := time.Now()
+	for number := first; number <= last; number++ {
+		blob, err := reader.Ancient("headers", number)
+		if err != nil {
+			return fmt.Errorf("header #%d: %v", number, err)
+		}
+		header := new(types.Header)
+		if err := rlp.DecodeBytes(blob, header); err != nil {
+			return fmt.Errorf("header #%d: %v", number, err)
+		}
+		if blob, err = reader.Ancient("bodies", number); err != nil {
+			return fmt.Errorf("body #%d: %v", number, err)
+		}
+		body := new(types.Body)
+		if err := rlp.DecodeBytes(blob, body); err != nil {
+			return fmt.Errorf("body #%d: %v", number, err)
+		}
+		block := types.NewBlockWithHeader(header).WithBody(body.Transactions, body.Uncles)
+		if err := rlp.Encode(writer, block); err != nil {
+			return err
+		}
+	}
+	log.Info("Exported ancient blocks", "count", last-first+1, "file", fn, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+func freezerMove(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		utils.Fatalf("This command requires an argument.")
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	src := freezerDir(ctx, stack)
+	dst, err := filepath.Abs(ctx.Args().First())
+	if err != nil {
+		return err
+	}
+	log.Info("Moving freezer", "src", src, "dst", dst)
+	if err := rawdb.MoveFreezer(src, dst); err != nil {
+		return err
+	}
+	log.Info("Moved freezer, start the node with the new location", "flag", fmt.Sprintf("--%s=%s", utils.AncientFlag.Name, dst))
+	return nil
+}
+
+// parseHexOrString tries to hex-decode the input if it has a 0x prefix,
+// otherwise it's taken as a plain string.
+func parseHexOrString(str string) ([]byte, error) {
+	if strings.HasPrefix(str, "0x") || strings.HasPrefix(str, "0X") {
+		return hexutil.Decode(str)
+	}
+	return []byte(str), nil
+}
+
+// showDBStats prints the internal statistics of the key-value store. The
+// property names are backend specific: leveldb exposes separate general and
+// io stats, while pebble reports all its metrics through a single property.
+func showDBStats(db ctxcdb.Stater, backend string) {
+	var properties []string
+	switch backend {
+	case "leveldb":
+		properties = []string{"leveldb.stats", "leveldb.iostats"}
+	case "pebble":
+		properties = []string{"pebble.metrics"}
+	default:
+		log.Warn("Database stats not supported for backend", "backend", backend)
+		return
+	}
+	for _, property := range properties {
+		if stats, err := db.Stat(property); err != nil {
+			log.Warn("Failed to read database stats", "property", property, "error", err)
+		} else {
+			fmt.Println(stats)
+		}
+	}
+}
+
+func dbGet(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return fmt.Errorf("required arguments: %v", ctx.Command.ArgsUsage)
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	db := utils.MakeChainDatabase(ctx, stack)
+	defer db.Close()
+
+	key, err := parseHexOrString(ctx.Args().Get(0))
+	if err != nil {
+		log.Info("Could not decode the key", "error", err)
+		return err
+	}
+	data, err := db.Get(key)
+	if err != nil {
+		log.Info("Get operation failed", "key", fmt.Sprintf("%#x", key), "error", err)
+		return err
+	}
+	fmt.Printf("key %#x: %#x\n", key, data)
+	return nil
+}
+
+func dbDelete(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return fmt.Errorf("required arguments: %v", ctx.Command.ArgsUsage)
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	db := utils.MakeChainDatabase(ctx, stack)
+	defer db.Close()
+
+	key, err := parseHexOrString(ctx.Args().Get(0))
+	if err != nil {
+		log.Info("Could not decode the key", "error", err)
+		return err
+	}
+	data, err := db.Get(key)
+	if err == nil {
+		fmt.Printf("Previous value: %#x\n", data)
+	}
+	if err = db.Delete(key); err != nil {
+		log.Info("Delete operation returned an error", "key", fmt.Sprintf("%#x", key), "error", err)
+		return err
+	}
+	return nil
+}
+
+func dbPut(ctx *cli.Context) error {
+	if ctx.NArg() != 2 {
+		return fmt.Errorf("required arguments: %v", ctx.Command.ArgsUsage)
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	db := utils.MakeChainDatabase(ctx, stack)
+	defer db.Close()
+
+	key, err := parseHexOrString(ctx.Args().Get(0))
+	if err != nil {
+		log.Info("Could not decode the key", "error", err)
+		return err
+	}
+	value, err := hexutil.Decode(ctx.Args().Get(1))
+	if err != nil {
+		log.Info("Could not decode the value", "error", err)
+		return err
+	}
+	data, err := db.Get(key)
+	if err == nil {
+		fmt.Printf("Previous value: %#x\n", data)
+	}
+	return db.Put(key, value)
+}
+
+func dbStats(ctx *cli.Context) error {
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	db := utils.MakeChainDatabase(ctx, stack)
+	defer db.Close()
+
+	showDBStats(db, rawdb.PreexistingDatabase(stack.ResolvePath("chaindata")))
+	return nil
+}
+
+func dbCompact(ctx *cli.Context) error {
+	if ctx.NArg() > 2 {
+		return fmt.Errorf("too many arguments: %v", ctx.Command.ArgsUsage)
+	}
+	var start, end []byte
+	if ctx.NArg() > 0 {
+		var err error
+		if start, err = parseHexOrString(ctx.Args().Get(0)); err != nil {
+			log.Info("Could not decode the start key", "error", err)
+			return err
+		}
+	}
+	if ctx.NArg() > 1 {
+		var err error
+		if end, err = parseHexOrString(ctx.Args().Get(1)); err != nil {
+			log.Info("Could not decode the end key", "error", err)
+			return err
+		}
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	db := utils.MakeChainDatabase(ctx, stack)
+	defer db.Close()
+
+	log.Info("Stats before compaction")
+	showDBStats(db, rawdb.PreexistingDatabase(stack.ResolvePath("chaindata")))
+
+	log.Info("Triggering compaction", "start", fmt.Sprintf("%#x", start), "end", fmt.Sprintf("%#x", end))
+	if err := db.Compact(start, end); err != nil {
+		log.Info("Compact err", "error", err)
+		return err
+	}
+	log.Info("Stats after compaction")
+	showDBStats(db, rawdb.PreexistingDatabase(stack.ResolvePath("chaindata")))
+	return nil
+}
+
+func dbDumpTrie(ctx *cli.Context) error {
+	if ctx.NArg() < 1 || ctx.NArg() > 3 {
+		return fmt.Errorf("required arguments: %v", ctx.Command.ArgsUsage)
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	db := utils.MakeChainDatabase(ctx, stack)
+	defer db.Close()
+
+	var (
+		root  []byte
+		start []byte
+		max   = int64(-1)
+		err   error
+	)
+	if root, err = hexutil.Decode(ctx.Args().Get(0)); err != nil {
+		log.Info("Could not decode the root", "error", err)
+		return err
+	}
+	if ctx.NArg() > 1 {
+		if start, err = hexutil.Decode(ctx.Args().Get(1)); err != nil {
+			log.Info("Could not decode the seek position", "error", err)
+			return err
+		}
+	}
+	if ctx.NArg() > 2 {
+		if max, err = strconv.ParseInt(ctx.Args().Get(2), 10, 64); err != nil {
+			log.Info("Could not decode the max count", "error", err)
+			return err
+		}
+	}
+	t, err := trie.New(common.BytesToHash(root), trie.NewDatabase(db))
+	if err != nil {
+		return err
+	}
+	var count int64
+	it := trie.NewIterator(t.NodeIterator(start))
+	for it.Next() {
+		if max > 0 && count == max {
+			fmt.Printf("Exiting after %d values\n", count)
+			break
+		}
+		fmt.Printf("  %d. key %#x: %#x\n", count, it.Key, it.Value)
+		count++
+	}
+	return it.Err
+}
+
+func dbMetadata(ctx *cli.Context) error {
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	db := utils.MakeChainDatabase(ctx, stack)
+	defer db.Close()
+
+	pp := func(val *uint64) string {
+		if val == nil {
+			return "<nil>"
+		}
+		return fmt.Sprintf("%d (0x%x)", *val, *val)
+	}
+	ph := func(hash common.Hash) string {
+		if hash == (common.Hash{}) {
+			return "<nil>"
+		}
+		if number := rawdb.ReadHeaderNumber(db, hash); number != nil {
+			return fmt.Sprintf("%v (#%d)", hash, *number)
+		}
+		return hash.String()
+	}
+	data := [][]string{
+		{"databaseVersion", pp(rawdb.ReadDatabaseVersion(db))},
+		{"headHeaderHash", ph(rawdb.ReadHeadHeaderHash(db))},
+		{"headBlockHash", ph(rawdb.ReadHeadBlockHash(db))},
+		{"headFastBlockHash", ph(rawdb.ReadHeadFastBlockHash(db))},
+		{"lastPivotNumber", pp(rawdb.ReadLastPivotNumber(db))},
+		{"fastTrieProgress", fmt.Sprintf("%d", rawdb.ReadFastTrieProgress(db))},
+		{"snapshotRoot", fmt.Sprintf("%v", rawdb.ReadSnapshotRoot(db))},
+		{"snapshotRecoveryNumber", pp(rawdb.ReadSnapshotRecoveryNumber(db))},
+		{"snapshotGenerator", snapshot.ParseGeneratorStatus(rawdb.ReadSnapshotGenerator(db))},
+		{"snapshotJournal", common.StorageSize(len(rawdb.ReadSnapshotJournal(db))).String()},
+		{"snapshotSyncStatus", fmt.Sprintf("%d bytes", len(rawdb.ReadSnapshotSyncStatus(db)))},
+		{"txIndexTail", pp(rawdb.ReadTxIndexTail(db))},
+		{"fastTxLookupLimit", pp(rawdb.ReadFastTxLookupLimit(db))},
+	}
+	if frozen, err := db.Ancients(); err == nil {
+		data = append(data, []string{"frozen", fmt.Sprintf("%d items", frozen)})
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Field", "Value"})
+	table.SetAutoWrapText(false)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.AppendBulk(data)
+	table.Render()
+	return nil
+}
+
+func dbFreezerIndex(ctx *cli.Context) error {
+	if ctx.NArg() != 3 {
+		return fmt.Errorf("required arguments: %v", ctx.Command.ArgsUsage)
+	}
+	first, ferr := strconv.ParseUint(ctx.Args().Get(1), 10, 64)
+	last, lerr := strconv.ParseUint(ctx.Args().Get(2), 10, 64)
+	if ferr != nil || lerr != nil {
+		return errors.New("item number not an integer")
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	reader, err := rawdb.OpenFreezerReader(freezerDir(ctx, stack))
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return reader.DumpIndex(os.Stdout, ctx.Args().Get(0), first, last)
+}
@@ -62,6 +62,7 @@ var (
 		// utils.BootnodesV5Flag,
 		utils.DataDirFlag,
 		utils.AncientFlag,
+		utils.DBEngineFlag,
 		utils.MinFreeDiskSpaceFlag,
 		utils.KeyStoreDirFlag,
 		utils.ExternalSignerFlag,
@@ -78,8 +79,14 @@ var (
 		utils.TxPoolAccountQueueFlag,
 		utils.TxPoolGlobalQueueFlag,
 		utils.TxPoolLifetimeFlag,
+		utils.TxPropPeersFlag,
+		utils.TxPropMaxSizeFlag,
+		utils.TxPropBroadcastUploadsFlag,
+		utils.TxPropPeerBudgetFlag,
 		utils.SyncModeFlag,
 		utils.GCModeFlag,
+		utils.StateDiffsFlag,
+		utils.StateDiffCheckpointFlag,
 		utils.SnapshotFlag,
 		utils.TxLookupLimitFlag,
 		utils.WhitelistFlag,
@@ -93,6 +100,8 @@ var (
 		utils.CacheNoPrefetchFlag,
 		utils.CachePreimagesFlag,
 		utils.TrieCacheGenFlag,
+		utils.LightServeFlag,
+		utils.LightMaxPeersFlag,
 		utils.ListenPortFlag,
 		utils.MaxPeersFlag,
 		utils.MaxPendingPeersFlag,
@@ -117,6 +126,7 @@ var (
 		utils.NATFlag,
 		utils.NoDiscoverFlag,
 		utils.DiscoveryV5Flag,
+		utils.DialRatioFlag,
 		utils.NetrestrictFlag,
 		utils.NodeKeyFileFlag,
 		utils.NodeKeyHexFlag,
@@ -130,6 +140,18 @@ var (
 		utils.NetworkIdFlag,
 		utils.RPCCORSDomainFlag,
 		utils.RPCVirtualHostsFlag,
+		utils.RPCJWTSecretFlag,
+		utils.RPCAuditLogFlag,
+		utils.RPCBatchLimitFlag,
+		utils.RPCResponseLimitFlag,
+		utils.RPCRateLimitFlag,
+		utils.RPCRateBurstFlag,
+		utils.RPCExpensiveRateLimitFlag,
+		utils.RPCExpensiveRateBurstFlag,
+		utils.RPCExpensiveMethodsFlag,
+		utils.GraphQLEnabledFlag,
+		utils.GraphQLCORSDomainFlag,
+		utils.GraphQLVirtualHostsFlag,
 		// utils.CortexStatsURLFlag,
 		// utils.MetricsEnabledFlag,
 		// utils.FakePoWFlag,
@@ -181,6 +203,7 @@ var (
 		utils.InsecureUnlockAllowedFlag,
 		utils.RPCGlobalGasCapFlag,
 		utils.RPCGlobalTxFeeCapFlag,
+		utils.RPCDatabaseAPIFlag,
 	}
 
 	whisperFlags = []cli.Flag{
@@ -211,17 +234,24 @@ func init() {
 	app.Commands = []cli.Command{
 		// See chaincmd.go:
 		initCommand,
-		// importCommand,
-		// exportCommand,
-		// importPreimagesCommand,
-		// exportPreimagesCommand,
-		// copydbCommand,
+		importCommand,
+		exportCommand,
+		importPreimagesCommand,
+		exportPreimagesCommand,
+		importHistoryCommand,
+		exportHistoryCommand,
+		verifyHistoryCommand,
+		copydbCommand,
 		removedbCommand,
-		// dumpCommand,
+		dumpCommand,
 		dumpGenesisCommand,
 		inspectCommand,
 		// See monitorcmd.go:
 		// monitorCommand,
+		// See snapshot.go
+		snapshotCommand,
+		// See dbcmd.go:
+		dbCommand,
 		// See accountcmd.go:
 		accountCommand,
 
@@ -376,6 +406,16 @@ func startNode(ctx *cli.Context, stack *node.Node) {
 		}
 		stateReader := ctxcclient.NewClient(rpcClient)
 
+		// Set contract backend for the Cortex service if the local node
+		// is serving LES requests.
+		if ctx.GlobalInt(utils.LightServeFlag.Name) > 0 {
+			var cortex *ctxc.Cortex
+			if err := stack.Service(&cortex); err != nil {
+				utils.Fatalf("Failed to retrieve cortex service: %v", err)
+			}
+			cortex.SetContractBackend(stateReader)
+		}
+
 		// Open any wallets already attached
 		for _, wallet := range stack.AccountManager().Wallets() {
 			if err := wallet.Open(""); err != nil {
@@ -410,6 +450,9 @@ func startNode(ctx *cli.Context, stack *node.Node) {
 	// if ctx.GlobalBool(utils.MiningEnabledFlag.Name) || ctx.GlobalBool(utils.DeveloperFlag.Name) {
 	if ctx.GlobalBool(utils.MiningEnabledFlag.Name) {
 		// Mining only makes sense if a full Cortex node is running
+		if ctx.GlobalString(utils.SyncModeFlag.Name) == "light" {
+			utils.Fatalf("Light clients do not support mining")
+		}
 		var cortex *ctxc.Cortex
 		if err := stack.Service(&cortex); err != nil {
 			utils.Fatalf("Cortex service not running: %v", err)
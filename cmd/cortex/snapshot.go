@@ -0,0 +1,488 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of CortexFoundation.
+//
+// CortexFoundation is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// CortexFoundation is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with CortexFoundation. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/cmd/utils"
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
+	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
+	"github.com/CortexFoundation/CortexTheseus/core/state"
+	"github.com/CortexFoundation/CortexTheseus/core/state/pruner"
+	"github.com/CortexFoundation/CortexTheseus/core/state/snapshot"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/ctxcdb"
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/rlp"
+	"github.com/CortexFoundation/CortexTheseus/trie"
+	torrentfs "github.com/CortexFoundation/torrentfs/types"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	// emptyRoot is the known root hash of an empty trie.
+	emptyRoot = common.HexToHash("56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
+
+	// emptyCode is the known hash of the empty CVM bytecode.
+	emptyCode = crypto.Keccak256(nil)
+)
+
+var (
+	snapshotCommand = cli.Command{
+		Name:        "snapshot",
+		Usage:       "A set of commands based on the snapshot",
+		Category:    "MISCELLANEOUS COMMANDS",
+		Description: "",
+		Subcommands: []cli.Command{
+			{
+				Name:      "prune-state",
+				Usage:     "Prune stale cortex state data based on the snapshot",
+				ArgsUsage: "<root>",
+				Action:    utils.MigrateFlags(pruneState),
+				Category:  "MISCELLANEOUS COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.AncientFlag,
+					utils.BernardFlag,
+					utils.CacheTrieJournalFlag,
+					utils.BloomFilterSizeFlag,
+				},
+				Description: `
+cortex snapshot prune-state <state-root>
+will prune historical state data with the help of the state snapshot.
+All trie nodes and contract codes that do not belong to the specified
+version state will be deleted from the database. After pruning, only
+two version states are available: genesis and the specific one.
+
+The default pruning target is the HEAD-127 state.
+
+WARNING: It's necessary to delete the trie clean cache after the pruning.
+If you specify another directory for the trie clean cache via "--cache.trie.journal"
+during the use of Cortex, please also specify it here for correct deletion. Otherwise
+the trie clean cache with default directory will be deleted.
+
+The pruning is resumable: if it is interrupted after the bloom filter of the
+live state has been written, it is completed on the next run of this command
+or the next start of the node.
+`,
+			},
+			{
+				Name:      "verify-state",
+				Usage:     "Recalculate state hash based on the snapshot for verification",
+				ArgsUsage: "<root>",
+				Action:    utils.MigrateFlags(verifyState),
+				Category:  "MISCELLANEOUS COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.AncientFlag,
+					utils.BernardFlag,
+				},
+				Description: `
+cortex snapshot verify-state <state-root>
+will traverse the whole accounts and storages set based on the specified
+snapshot and recalculate the root hash of state for verification.
+In other words, this command does the snapshot to trie conversion.
+`,
+			},
+			{
+				Name:      "traverse-state",
+				Usage:     "Traverse the state with given root hash for verification",
+				ArgsUsage: "<root>",
+				Action:    utils.MigrateFlags(traverseState),
+				Category:  "MISCELLANEOUS COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.AncientFlag,
+					utils.BernardFlag,
+				},
+				Description: `
+cortex snapshot traverse-state <state-root>
+will traverse the whole state from the given state root and will abort if any
+referenced trie node or contract code is missing. This command can be used for
+state integrity verification, e.g. after an unclean shutdown. The default
+checking target is the HEAD state.
+`,
+			},
+			{
+				Name:      "dump",
+				Usage:     "Dump the state of a specific block from the snapshot",
+				ArgsUsage: "[? <blockHash> | <blockNum>]",
+				Action:    utils.MigrateFlags(dumpState),
+				Category:  "MISCELLANEOUS COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.AncientFlag,
+					utils.BernardFlag,
+					utils.ExcludeCodeFlag,
+					utils.ExcludeStorageFlag,
+					utils.StartKeyFlag,
+					utils.DumpLimitFlag,
+				},
+				Description: `
+cortex snapshot dump [? <blockHash> | <blockNum>]
+will dump the state of the given block (the head block by default) from the
+snapshot as JSON lines, one account per line. Model and input meta data stored
+in contract code are decoded into the "model" and "input" fields.
+`,
+			},
+		},
+	}
+)
+
+func pruneState(ctx *cli.Context) error {
+	stack, config := makeConfigNode(ctx)
+	defer stack.Close()
+
+	chaindb := utils.MakeChainDatabase(ctx, stack)
+	defer chaindb.Close()
+
+	pruner, err := pruner.NewPruner(chaindb, stack.ResolvePath(""), stack.ResolvePath(config.Cortex.TrieCleanCacheJournal), ctx.GlobalUint64(utils.BloomFilterSizeFlag.Name))
+	if err != nil {
+		log.Error("Failed to open snapshot tree", "error", err)
+		return err
+	}
+	if ctx.NArg() > 1 {
+		log.Error("Too many arguments given")
+		return errors.New("too many arguments")
+	}
+	var targetRoot common.Hash
+	if ctx.NArg() == 1 {
+		targetRoot, err = parseRoot(ctx.Args()[0])
+		if err != nil {
+			log.Error("Failed to resolve state root", "error", err)
+			return err
+		}
+	}
+	if err = pruner.Prune(targetRoot); err != nil {
+		log.Error("Failed to prune state", "error", err)
+		return err
+	}
+	return nil
+}
+
+func parseRoot(input string) (common.Hash, error) {
+	var h common.Hash
+	if err := h.UnmarshalText([]byte(input)); err != nil {
+		return h, err
+	}
+	return h, nil
+}
+
+// openSnapshot opens the snapshot tree of the head state without regenerating
+// it if missing.
+func openSnapshot(chaindb ctxcdb.Database) (*snapshot.Tree, common.Hash, error) {
+	headBlock := rawdb.ReadHeadBlock(chaindb)
+	if headBlock == nil {
+		return nil, common.Hash{}, errors.New("no head block")
+	}
+	snaptree, err := snapshot.New(chaindb, trie.NewDatabase(chaindb), 256, headBlock.Root(), false, false, false)
+	if err != nil {
+		return nil, common.Hash{}, err
+	}
+	return snaptree, headBlock.Root(), nil
+}
+
+// verifyState regenerates the state trie root from the snapshot and compares
+// it against the given state root, or the head state root if none is given.
+func verifyState(ctx *cli.Context) error {
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	chaindb := utils.MakeChainDatabase(ctx, stack)
+	defer chaindb.Close()
+
+	snaptree, root, err := openSnapshot(chaindb)
+	if err != nil {
+		log.Error("Failed to open snapshot tree", "error", err)
+		return err
+	}
+	if ctx.NArg() > 1 {
+		log.Error("Too many arguments given")
+		return errors.New("too many arguments")
+	}
+	if ctx.NArg() == 1 {
+		root, err = parseRoot(ctx.Args()[0])
+		if err != nil {
+			log.Error("Failed to resolve state root", "error", err)
+			return err
+		}
+	}
+	if err := snapshot.VerifyState(snaptree, root); err != nil {
+		log.Error("Failed to verify state", "root", root, "error", err)
+		return err
+	}
+	log.Info("Verified the state", "root", root)
+	return nil
+}
+
+// traverseState is a helper function used for pruning verification.
+// Basically it iterates every trie node in the state, checking that all
+// referenced storage tries and contract codes are present.
+func traverseState(ctx *cli.Context) error {
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	chaindb := utils.MakeChainDatabase(ctx, stack)
+	defer chaindb.Close()
+
+	headBlock := rawdb.ReadHeadBlock(chaindb)
+	if headBlock == nil {
+		log.Error("Failed to load head block")
+		return errors.New("no head block")
+	}
+	if ctx.NArg() > 1 {
+		log.Error("Too many arguments given")
+		return errors.New("too many arguments")
+	}
+	var (
+		root common.Hash
+		err  error
+	)
+	if ctx.NArg() == 1 {
+		root, err = parseRoot(ctx.Args()[0])
+		if err != nil {
+			log.Error("Failed to resolve state root", "error", err)
+			return err
+		}
+		log.Info("Start traversing the state", "root", root)
+	} else {
+		root = headBlock.Root()
+		log.Info("Start traversing the state", "root", root, "number", headBlock.NumberU64())
+	}
+	triedb := trie.NewDatabase(chaindb)
+	t, err := trie.NewSecure(root, triedb)
+	if err != nil {
+		log.Error("Failed to open trie", "root", root, "error", err)
+		return err
+	}
+	var (
+		nodes      int
+		accounts   int
+		slots      int
+		codes      int
+		lastReport time.Time
+		start      = time.Now()
+	)
+	accIter := t.NodeIterator(nil)
+	for accIter.Next(true) {
+		nodes += 1
+		if !accIter.Leaf() {
+			continue
+		}
+		accounts += 1
+		var acc state.Account
+		if err := rlp.DecodeBytes(accIter.LeafBlob(), &acc); err != nil {
+			log.Error("Invalid account encountered during traversal", "error", err)
+			return err
+		}
+		if acc.Root != emptyRoot {
+			storageTrie, err := trie.NewSecure(acc.Root, triedb)
+			if err != nil {
+				log.Error("Failed to open storage trie", "root", acc.Root, "error", err)
+				return err
+			}
+			storageIter := storageTrie.NodeIterator(nil)
+			for storageIter.Next(true) {
+				nodes += 1
+				if storageIter.Leaf() {
+					slots += 1
+				}
+			}
+			if storageIter.Error() != nil {
+				log.Error("Failed to traverse storage trie", "root", acc.Root, "error", storageIter.Error())
+				return storageIter.Error()
+			}
+		}
+		if !bytes.Equal(acc.CodeHash, emptyCode) {
+			code := rawdb.ReadCode(chaindb, common.BytesToHash(acc.CodeHash))
+			if len(code) == 0 {
+				log.Error("Code is missing", "hash", common.BytesToHash(acc.CodeHash))
+				return errors.New("missing code")
+			}
+			codes += 1
+		}
+		if time.Since(lastReport) > time.Second*8 {
+			log.Info("Traversing state", "nodes", nodes, "accounts", accounts, "slots", slots, "codes", codes, "elapsed", common.PrettyDuration(time.Since(start)))
+			lastReport = time.Now()
+		}
+	}
+	if accIter.Error() != nil {
+		log.Error("Failed to traverse state trie", "root", root, "error", accIter.Error())
+		return accIter.Error()
+	}
+	log.Info("State is complete", "nodes", nodes, "accounts", accounts, "slots", slots, "codes", codes, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// dumpAccount is a single line of the snapshot dump.
+type dumpAccount struct {
+	Key      common.Hash                   `json:"key"`
+	Address  *common.Address               `json:"address,omitempty"` // Only present if the preimage is known
+	Balance  string                        `json:"balance"`
+	Nonce    uint64                        `json:"nonce"`
+	Upload   string                        `json:"upload"`
+	Num      string                        `json:"num"`
+	Root     common.Hash                   `json:"root"`
+	CodeHash common.Hash                   `json:"codeHash"`
+	Code     hexutil.Bytes                 `json:"code,omitempty"`
+	Model    *torrentfs.ModelMeta          `json:"model,omitempty"`
+	Input    *torrentfs.InputMeta          `json:"input,omitempty"`
+	Storage  map[common.Hash]hexutil.Bytes `json:"storage,omitempty"`
+}
+
+// dumpState is similar to the dump command of other clients, but uses the
+// snapshot for faster iteration and streams the accounts as JSON lines.
+func dumpState(ctx *cli.Context) error {
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	chaindb := utils.MakeChainDatabase(ctx, stack)
+	defer chaindb.Close()
+
+	snaptree, root, err := openSnapshot(chaindb)
+	if err != nil {
+		log.Error("Failed to open snapshot tree", "error", err)
+		return err
+	}
+	if ctx.NArg() > 1 {
+		log.Error("Too many arguments given")
+		return errors.New("too many arguments")
+	}
+	if ctx.NArg() == 1 {
+		arg := ctx.Args()[0]
+		var header *types.Header
+		if hashish(arg) {
+			hash := common.HexToHash(arg)
+			if number := rawdb.ReadHeaderNumber(chaindb, hash); number != nil {
+				header = rawdb.ReadHeader(chaindb, hash, *number)
+			}
+		} else {
+			number, _ := strconv.ParseUint(arg, 10, 64)
+			header = rawdb.ReadHeader(chaindb, rawdb.ReadCanonicalHash(chaindb, number), number)
+		}
+		if header == nil {
+			log.Error("Block not found", "block", arg)
+			return errors.New("block not found")
+		}
+		root = header.Root
+	}
+	var (
+		start  common.Hash
+		limit  = ctx.GlobalUint64(utils.DumpLimitFlag.Name)
+		nocode = ctx.GlobalBool(utils.ExcludeCodeFlag.Name)
+		nostor = ctx.GlobalBool(utils.ExcludeStorageFlag.Name)
+	)
+	if ctx.GlobalIsSet(utils.StartKeyFlag.Name) {
+		if start, err = parseRoot(ctx.GlobalString(utils.StartKeyFlag.Name)); err != nil {
+			log.Error("Failed to resolve start key", "error", err)
+			return err
+		}
+	}
+	accIt, err := snaptree.AccountIterator(root, start)
+	if err != nil {
+		return err
+	}
+	defer accIt.Release()
+
+	out := json.NewEncoder(os.Stdout)
+	if err := out.Encode(struct {
+		Root common.Hash `json:"root"`
+	}{root}); err != nil {
+		return err
+	}
+	var (
+		count            uint64
+		missingPreimages uint64
+		lastReport       time.Time
+		begin            = time.Now()
+	)
+	for accIt.Next() {
+		account, err := snapshot.FullAccount(accIt.Account())
+		if err != nil {
+			return err
+		}
+		da := &dumpAccount{
+			Key:      accIt.Hash(),
+			Balance:  account.Balance.String(),
+			Nonce:    account.Nonce,
+			Upload:   account.Upload.String(),
+			Num:      account.Num.String(),
+			Root:     common.BytesToHash(account.Root),
+			CodeHash: common.BytesToHash(account.CodeHash),
+		}
+		if preimage := rawdb.ReadPreimage(chaindb, accIt.Hash()); len(preimage) == common.AddressLength {
+			addr := common.BytesToAddress(preimage)
+			da.Address = &addr
+		} else {
+			missingPreimages++
+		}
+		if !bytes.Equal(account.CodeHash, emptyCode) {
+			code := rawdb.ReadCode(chaindb, da.CodeHash)
+			if !nocode {
+				da.Code = code
+			}
+			// Models and inputs are stored as specially prefixed contract code
+			if meta, err := torrentfs.ParseModelMeta(code); err == nil {
+				da.Model = meta
+			} else if meta, err := torrentfs.ParseInputMeta(code); err == nil {
+				da.Input = meta
+			}
+		}
+		if !nostor && da.Root != emptyRoot {
+			da.Storage = make(map[common.Hash]hexutil.Bytes)
+			stIt, err := snaptree.StorageIterator(root, accIt.Hash(), common.Hash{})
+			if err != nil {
+				return err
+			}
+			for stIt.Next() {
+				da.Storage[stIt.Hash()] = common.CopyBytes(stIt.Slot())
+			}
+			err = stIt.Error()
+			stIt.Release()
+			if err != nil {
+				return err
+			}
+		}
+		if err := out.Encode(da); err != nil {
+			return err
+		}
+		count++
+		if time.Since(lastReport) > time.Second*8 {
+			log.Info("Snapshot dumping in progress", "at", accIt.Hash(), "accounts", count, "elapsed", common.PrettyDuration(time.Since(begin)))
+			lastReport = time.Now()
+		}
+		if limit > 0 && count >= limit {
+			break
+		}
+	}
+	if err := accIt.Error(); err != nil {
+		return err
+	}
+	if missingPreimages > 0 {
+		log.Warn("Dump is missing addresses due to missing preimages", "missing", missingPreimages)
+	}
+	log.Info("Snapshot dumping complete", "accounts", count, "elapsed", common.PrettyDuration(time.Since(begin)))
+	return nil
+}
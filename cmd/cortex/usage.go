@@ -70,6 +70,7 @@ var AppHelpFlagGroups = []flagGroup{
 			configFileFlag,
 			utils.DataDirFlag,
 			utils.AncientFlag,
+			utils.DBEngineFlag,
 			utils.MinFreeDiskSpaceFlag,
 			utils.KeyStoreDirFlag,
 			// utils.NoUSBFlag,
@@ -78,6 +79,8 @@ var AppHelpFlagGroups = []flagGroup{
 			// utils.LazynetFlag,
 			utils.SyncModeFlag,
 			utils.GCModeFlag,
+			utils.StateDiffsFlag,
+			utils.StateDiffCheckpointFlag,
 			utils.TxLookupLimitFlag,
 			utils.WhitelistFlag,
 			// utils.CortexStatsURLFlag,
@@ -118,6 +121,15 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.TxPoolLifetimeFlag,
 		},
 	},
+	{
+		Name: "TRANSACTION PROPAGATION",
+		Flags: []cli.Flag{
+			utils.TxPropPeersFlag,
+			utils.TxPropMaxSizeFlag,
+			utils.TxPropBroadcastUploadsFlag,
+			utils.TxPropPeerBudgetFlag,
+		},
+	},
 	{
 		Name: "PERFORMANCE TUNING",
 		Flags: []cli.Flag{
@@ -158,13 +170,33 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.IPCPathFlag,
 			utils.RPCCORSDomainFlag,
 			utils.RPCVirtualHostsFlag,
+			utils.RPCJWTSecretFlag,
+			utils.RPCAuditLogFlag,
+			utils.RPCBatchLimitFlag,
+			utils.RPCResponseLimitFlag,
+			utils.RPCRateLimitFlag,
+			utils.RPCRateBurstFlag,
+			utils.RPCExpensiveRateLimitFlag,
+			utils.RPCExpensiveRateBurstFlag,
+			utils.RPCExpensiveMethodsFlag,
+			utils.GraphQLEnabledFlag,
+			utils.GraphQLCORSDomainFlag,
+			utils.GraphQLVirtualHostsFlag,
 			utils.RPCGlobalGasCapFlag,
 			utils.RPCGlobalTxFeeCapFlag,
+			utils.RPCDatabaseAPIFlag,
 			utils.JSpathFlag,
 			utils.ExecFlag,
 			utils.PreloadJSFlag,
 		},
 	},
+	{
+		Name: "LIGHT CLIENT",
+		Flags: []cli.Flag{
+			utils.LightServeFlag,
+			utils.LightMaxPeersFlag,
+		},
+	},
 	{
 		Name: "NETWORKING",
 		Flags: []cli.Flag{
@@ -177,6 +209,7 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.NATFlag,
 			utils.NoDiscoverFlag,
 			utils.DiscoveryV5Flag,
+			utils.DialRatioFlag,
 			utils.NetrestrictFlag,
 			utils.NodeKeyFileFlag,
 			utils.NodeKeyHexFlag,
@@ -73,7 +73,7 @@ var (
 		Name:   "crawl",
 		Usage:  "Updates a nodes.json file with random nodes found in the DHT",
 		Action: discv4Crawl,
-		Flags:  []cli.Flag{bootnodesFlag, crawlTimeoutFlag},
+		Flags:  []cli.Flag{bootnodesFlag, crawlTimeoutFlag, crawlFilterFlag},
 	}
 )
 
@@ -99,6 +99,10 @@ var (
 		Usage: "Time limit for the crawl.",
 		Value: 30 * time.Minute,
 	}
+	crawlFilterFlag = cli.StringFlag{
+		Name:  "filter",
+		Usage: "Node set filters applied to the crawl result (e.g. \"-ctxc-network mainnet\")",
+	}
 	testPatternFlag = cli.StringFlag{
 		Name:  "run",
 		Usage: "Pattern of test suite(s) to run",
@@ -189,16 +193,29 @@ func discv4Crawl(ctx *cli.Context) error {
 	if common.FileExist(nodesFile) {
 		inputSet = loadNodesJSON(nodesFile)
 	}
+	filter, err := crawlFilter(ctx)
+	if err != nil {
+		return err
+	}
 
 	disc := startV4(ctx)
 	defer disc.Close()
 	c := newCrawler(inputSet, disc, disc.RandomNodes())
 	c.revalidateInterval = 10 * time.Minute
 	output := c.run(ctx.Duration(crawlTimeoutFlag.Name))
-	writeNodesJSON(nodesFile, output)
+	writeNodesJSON(nodesFile, output.filter(filter))
 	return nil
 }
 
+// crawlFilter parses the node filters given by crawlFilterFlag.
+func crawlFilter(ctx *cli.Context) (nodeFilter, error) {
+	filter, err := andFilter(strings.Fields(ctx.String(crawlFilterFlag.Name)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid crawl filter: %v", err)
+	}
+	return filter, nil
+}
+
 // startV4 starts an ephemeral discovery V4 node.
 func startV4(ctx *cli.Context) *discover.UDPv4 {
 	ln, config := makeDiscoveryConfig(ctx)
@@ -56,7 +56,7 @@ var (
 		Name:   "crawl",
 		Usage:  "Updates a nodes.json file with random nodes found in the DHT",
 		Action: discv5Crawl,
-		Flags:  []cli.Flag{bootnodesFlag, crawlTimeoutFlag},
+		Flags:  []cli.Flag{bootnodesFlag, crawlTimeoutFlag, crawlFilterFlag},
 	}
 	discv5TestCommand = cli.Command{
 		Name:   "test",
@@ -104,13 +104,17 @@ func discv5Crawl(ctx *cli.Context) error {
 	if common.FileExist(nodesFile) {
 		inputSet = loadNodesJSON(nodesFile)
 	}
+	filter, err := crawlFilter(ctx)
+	if err != nil {
+		return err
+	}
 
 	disc := startV5(ctx)
 	defer disc.Close()
 	c := newCrawler(inputSet, disc, disc.RandomNodes())
 	c.revalidateInterval = 10 * time.Minute
 	output := c.run(ctx.Duration(crawlTimeoutFlag.Name))
-	writeNodesJSON(nodesFile, output)
+	writeNodesJSON(nodesFile, output.filter(filter))
 	return nil
 }
 
@@ -0,0 +1,94 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of CortexTheseus.
+//
+// CortexTheseus is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// CortexTheseus is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with CortexTheseus. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/CortexFoundation/CortexTheseus/p2p/dnsdisc"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// maxTXTStringLength is the maximum size of a character-string in a TXT record.
+const maxTXTStringLength = 255
+
+// dnsToZonefile peforms dnsZonefileCommand.
+func dnsToZonefile(ctx *cli.Context) error {
+	if ctx.NArg() < 1 {
+		return fmt.Errorf("need tree definition directory as argument")
+	}
+	output := ctx.Args().Get(1)
+	if output == "" {
+		output = "-" // default to stdout
+	}
+	domain, t, err := loadTreeDefinitionForExport(ctx.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	if output == "-" {
+		return writeZonefile(os.Stdout, domain, t)
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if err := writeZonefile(f, domain, t); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeZonefile writes the TXT records of the tree as a BIND zone file. Record
+// names are relative to the tree domain, which becomes the zone origin.
+func writeZonefile(w io.Writer, domain string, t *dnsdisc.Tree) error {
+	records := t.ToTXT("")
+	names := make([]string, 0, len(records))
+	for name := range records {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "; DNS discovery tree, seq %d\n", t.Seq())
+	fmt.Fprintf(&b, "$ORIGIN %s.\n", strings.TrimSuffix(domain, "."))
+	fmt.Fprintf(&b, "@ %d IN TXT %s\n", rootTTL, zoneTXT(records[""]))
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s %d IN TXT %s\n", name, treeNodeTTL, zoneTXT(records[name]))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// zoneTXT encodes value as a list of quoted character-strings, splitting it
+// where it exceeds the maximum string length.
+func zoneTXT(value string) string {
+	var parts []string
+	for len(value) > maxTXTStringLength {
+		parts = append(parts, strconv.Quote(value[:maxTXTStringLength]))
+		value = value[maxTXTStringLength:]
+	}
+	parts = append(parts, strconv.Quote(value))
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,150 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of CortexTheseus.
+//
+// CortexTheseus is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// CortexTheseus is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with CortexTheseus. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/p2p/dnsdisc"
+	"github.com/CortexFoundation/CortexTheseus/p2p/enode"
+	"github.com/CortexFoundation/CortexTheseus/p2p/enr"
+)
+
+// This test checks that a tree written as a zone file can be synced by the
+// DNS discovery client.
+func TestZonefileSync(t *testing.T) {
+	const domain = "nodes.example.org"
+	var nodes []*enode.Node
+	for i := 0; i < 5; i++ {
+		key, _ := crypto.GenerateKey()
+		var r enr.Record
+		r.Set(enr.IP(net.IP{127, 0, 0, byte(i + 1)}))
+		r.Set(enr.UDP(30303))
+		if err := enode.SignV4(&r, key); err != nil {
+			t.Fatal(err)
+		}
+		n, err := enode.New(enode.ValidSchemes, &r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, n)
+	}
+	tree, err := dnsdisc.MakeTree(1, nodes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := crypto.GenerateKey()
+	url, err := tree.Sign(key, domain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var zone strings.Builder
+	if err := writeZonefile(&zone, domain, tree); err != nil {
+		t.Fatal(err)
+	}
+	records, err := parseZonefile(zone.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(records, mapResolver(tree.ToTXT(domain))) {
+		t.Fatalf("wrong records in zone file:\n%s", zone.String())
+	}
+
+	client := dnsdisc.NewClient(dnsdisc.Config{Resolver: records})
+	synced, err := client.SyncTree(url)
+	if err != nil {
+		t.Fatal("sync error:", err)
+	}
+	if want, have := sortedIDs(nodes), sortedIDs(synced.Nodes()); !reflect.DeepEqual(have, want) {
+		t.Fatalf("wrong nodes synced: %v, want %v", have, want)
+	}
+}
+
+func TestZoneTXTSplit(t *testing.T) {
+	value := strings.Repeat("a", maxTXTStringLength) + strings.Repeat("b", 10)
+	want := `"` + strings.Repeat("a", maxTXTStringLength) + `" "bbbbbbbbbb"`
+	if have := zoneTXT(value); have != want {
+		t.Fatalf("wrong split:\nhave %s\nwant %s", have, want)
+	}
+}
+
+var zoneStringRE = regexp.MustCompile(`"(?:[^"\\]|\\.)*"`)
+
+// parseZonefile reads the TXT records of a zone file created by writeZonefile.
+func parseZonefile(zone string) (mapResolver, error) {
+	var (
+		origin  string
+		records = make(mapResolver)
+		scanner = bufio.NewScanner(strings.NewReader(zone))
+	)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0 || strings.HasPrefix(line, ";"):
+			continue
+		case fields[0] == "$ORIGIN":
+			origin = strings.TrimSuffix(fields[1], ".")
+			continue
+		case len(fields) < 5 || fields[2] != "IN" || fields[3] != "TXT":
+			return nil, fmt.Errorf("invalid record %q", line)
+		}
+		name := fields[0] + "." + origin
+		if fields[0] == "@" {
+			name = origin
+		}
+		var value strings.Builder
+		for _, s := range zoneStringRE.FindAllString(line, -1) {
+			part, err := strconv.Unquote(s)
+			if err != nil {
+				return nil, err
+			}
+			value.WriteString(part)
+		}
+		records[name] = value.String()
+	}
+	return records, scanner.Err()
+}
+
+type mapResolver map[string]string
+
+func (mr mapResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if record, ok := mr[name]; ok {
+		return []string{record}, nil
+	}
+	return nil, fmt.Errorf("no such host: %s", name)
+}
+
+func sortedIDs(nodes []*enode.Node) []string {
+	ids := make([]string, len(nodes))
+	for i, n := range nodes {
+		ids[i] = n.ID().String()
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -43,6 +43,7 @@ var (
 			dnsTXTCommand,
 			dnsCloudflareCommand,
 			dnsRoute53Command,
+			dnsZonefileCommand,
 		},
 	}
 	dnsSyncCommand = cli.Command{
@@ -79,6 +80,12 @@ var (
 		Action:    dnsToRoute53,
 		Flags:     []cli.Flag{route53AccessKeyFlag, route53AccessSecretFlag, route53ZoneIDFlag},
 	}
+	dnsZonefileCommand = cli.Command{
+		Name:      "to-zonefile",
+		Usage:     "Create a BIND zone file for a discovery tree",
+		ArgsUsage: "<tree-directory> <output-file>",
+		Action:    dnsToZonefile,
+	}
 )
 
 var (
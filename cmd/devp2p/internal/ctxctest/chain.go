@@ -0,0 +1,191 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of CortexTheseus.
+//
+// CortexTheseus is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// CortexTheseus is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with CortexTheseus. If not, see <http://www.gnu.org/licenses/>.
+
+package ctxctest
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core"
+	"github.com/CortexFoundation/CortexTheseus/core/forkid"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/params"
+	"github.com/CortexFoundation/CortexTheseus/rlp"
+)
+
+// Chain is the block chain the node under test is expected to follow, starting
+// at the genesis block.
+type Chain struct {
+	blocks      []*types.Block
+	chainConfig *params.ChainConfig
+}
+
+// Len returns the number of blocks in the chain, including the genesis block.
+func (c *Chain) Len() int {
+	return len(c.blocks)
+}
+
+// Head returns the last block of the chain.
+func (c *Chain) Head() *types.Block {
+	return c.blocks[len(c.blocks)-1]
+}
+
+// Genesis returns the genesis block of the chain.
+func (c *Chain) Genesis() *types.Block {
+	return c.blocks[0]
+}
+
+// TD returns the total difficulty of the chain head.
+func (c *Chain) TD() *big.Int {
+	return c.TDAt(c.Len() - 1)
+}
+
+// TDAt returns the total difficulty of the block at the given height.
+func (c *Chain) TDAt(number int) *big.Int {
+	sum := new(big.Int)
+	for _, block := range c.blocks[:number+1] {
+		sum.Add(sum, block.Difficulty())
+	}
+	return sum
+}
+
+// ForkID returns the fork identifier of the chain head.
+func (c *Chain) ForkID() forkid.ID {
+	return forkid.NewID(c.chainConfig, c.Genesis().Hash(), uint64(c.Len()-1))
+}
+
+// status creates the status message announcing the chain head.
+func (c *Chain) status(version uint, network uint64) *Status {
+	return &Status{
+		ProtocolVersion: uint32(version),
+		NetworkID:       network,
+		TD:              c.TD(),
+		Head:            c.Head().Hash(),
+		Genesis:         c.Genesis().Hash(),
+		ForkID:          c.ForkID(),
+	}
+}
+
+// Shorten returns a copy of the chain containing only the given number of
+// blocks.
+func (c *Chain) Shorten(length int) *Chain {
+	blocks := make([]*types.Block, length)
+	copy(blocks, c.blocks[:length])
+
+	return &Chain{
+		blocks:      blocks,
+		chainConfig: c.chainConfig,
+	}
+}
+
+// GetHeaders answers a header query against the chain, the same way the node
+// under test would.
+func (c *Chain) GetHeaders(req *GetBlockHeaders) (BlockHeaders, error) {
+	if req.Amount < 1 {
+		return nil, fmt.Errorf("no block headers requested")
+	}
+	var number uint64
+	if req.Origin.Hash != (common.Hash{}) {
+		found := false
+		for _, block := range c.blocks {
+			if block.Hash() == req.Origin.Hash {
+				number, found = block.NumberU64(), true
+				break
+			}
+		}
+		if !found {
+			return nil, nil
+		}
+	} else {
+		number = req.Origin.Number
+	}
+	var headers BlockHeaders
+	for uint64(len(headers)) < req.Amount && number < uint64(c.Len()) {
+		headers = append(headers, c.blocks[number].Header())
+		if req.Reverse {
+			if number < req.Skip+1 {
+				break
+			}
+			number -= req.Skip + 1
+		} else {
+			number += req.Skip + 1
+		}
+	}
+	return headers, nil
+}
+
+// loadChain reads the genesis specification and the RLP encoded blocks of a
+// chain export. Compressed exports are accepted if the file name ends in .gz.
+func loadChain(chainfile string, genesisfile string) (*Chain, error) {
+	data, err := ioutil.ReadFile(genesisfile)
+	if err != nil {
+		return nil, err
+	}
+	var genesis core.Genesis
+	if err := json.Unmarshal(data, &genesis); err != nil {
+		return nil, fmt.Errorf("invalid genesis file: %v", err)
+	}
+	if genesis.Config == nil {
+		return nil, fmt.Errorf("genesis file has no chain configuration")
+	}
+	blocks := []*types.Block{genesis.ToBlock(nil)}
+
+	fh, err := os.Open(chainfile)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	var reader io.Reader = fh
+	if strings.HasSuffix(chainfile, ".gz") {
+		if reader, err = gzip.NewReader(reader); err != nil {
+			return nil, err
+		}
+	}
+	stream := rlp.NewStream(reader, 0)
+	for i := 0; ; i++ {
+		var block types.Block
+		if err := stream.Decode(&block); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("at block index %d: %v", i, err)
+		}
+		// Exports starting at the genesis block contain it as the first entry
+		if block.NumberU64() == 0 {
+			if block.Hash() != blocks[0].Hash() {
+				return nil, fmt.Errorf("genesis mismatch: chain %x, genesis file %x", block.Hash(), blocks[0].Hash())
+			}
+			continue
+		}
+		parent := blocks[len(blocks)-1]
+		if block.NumberU64() != parent.NumberU64()+1 || block.ParentHash() != parent.Hash() {
+			return nil, fmt.Errorf("block #%d [%x] does not extend #%d [%x]", block.NumberU64(), block.Hash().Bytes()[:4], parent.NumberU64(), parent.Hash().Bytes()[:4])
+		}
+		blocks = append(blocks, &block)
+	}
+	return &Chain{
+		blocks:      blocks,
+		chainConfig: genesis.Config,
+	}, nil
+}
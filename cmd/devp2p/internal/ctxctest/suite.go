@@ -0,0 +1,659 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of CortexTheseus.
+//
+// CortexTheseus is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// CortexTheseus is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with CortexTheseus. If not, see <http://www.gnu.org/licenses/>.
+
+package ctxctest
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core/forkid"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/internal/utesting"
+	"github.com/CortexFoundation/CortexTheseus/p2p"
+	"github.com/CortexFoundation/CortexTheseus/p2p/enode"
+	"github.com/CortexFoundation/CortexTheseus/p2p/rlpx"
+	"github.com/CortexFoundation/CortexTheseus/rlp"
+	"github.com/CortexFoundation/CortexTheseus/trie"
+)
+
+// PendingBlocks is the number of blocks at the end of the test chain which the
+// node under test must not have imported. They are propagated to the node by
+// the block broadcast test and provide the transactions of the transaction
+// tests.
+const PendingBlocks = 2
+
+// This is the time within which a message must not be propagated.
+const propagationWait = 3 * time.Second
+
+// Suite is the ctxc protocol test suite.
+type Suite struct {
+	Dest *enode.Node
+
+	chain     *Chain // chain as known by the node under test
+	fullChain *Chain // chain including the pending blocks
+}
+
+// NewSuite creates a test suite against the given node. The node must run with
+// the given genesis and must have imported all blocks of the chain file except
+// the last PendingBlocks.
+func NewSuite(dest *enode.Node, chainfile string, genesisfile string) (*Suite, error) {
+	chain, err := loadChain(chainfile, genesisfile)
+	if err != nil {
+		return nil, err
+	}
+	if chain.Len() < PendingBlocks+2 {
+		return nil, fmt.Errorf("chain too short: have %d blocks, need at least %d", chain.Len()-1, PendingBlocks+1)
+	}
+	return &Suite{
+		Dest:      dest,
+		chain:     chain.Shorten(chain.Len() - PendingBlocks),
+		fullChain: chain,
+	}, nil
+}
+
+func (s *Suite) AllTests() []utesting.Test {
+	return []utesting.Test{
+		{Name: "Status", Fn: s.TestStatus},
+		{Name: "Status_66", Fn: s.TestStatus66},
+		{Name: "GetBlockHeaders", Fn: s.TestGetBlockHeaders},
+		{Name: "GetBlockHeaders_66", Fn: s.TestGetBlockHeaders66},
+		{Name: "GetBlockBodies", Fn: s.TestGetBlockBodies},
+		{Name: "GetBlockBodies_66", Fn: s.TestGetBlockBodies66},
+		{Name: "GetReceipts", Fn: s.TestGetReceipts},
+		{Name: "GetReceipts_66", Fn: s.TestGetReceipts66},
+		{Name: "GetNodeData", Fn: s.TestGetNodeData},
+		{Name: "GetNodeData_66", Fn: s.TestGetNodeData66},
+		{Name: "SimultaneousRequests_66", Fn: s.TestSimultaneousRequests66},
+		{Name: "UnsolicitedResponse_66", Fn: s.TestUnsolicitedResponse66},
+		{Name: "Broadcast", Fn: s.TestBroadcast},
+		{Name: "LargeAnnounce", Fn: s.TestLargeAnnounce},
+		{Name: "Transaction", Fn: s.TestTransaction},
+		{Name: "MaliciousTransactions", Fn: s.TestMaliciousTransactions},
+		{Name: "MaliciousHandshake", Fn: s.TestMaliciousHandshake},
+		{Name: "MaliciousStatus", Fn: s.TestMaliciousStatus},
+		{Name: "MalformedMessages", Fn: s.TestMalformedMessages},
+	}
+}
+
+// dial opens a connection to the node under test and performs the RLPx
+// handshake, advertising the given ctxc protocol versions.
+func (s *Suite) dial(versions ...uint) (*Conn, error) {
+	fd, err := net.Dial("tcp", fmt.Sprintf("%v:%d", s.Dest.IP(), s.Dest.TCP()))
+	if err != nil {
+		return nil, err
+	}
+	conn := &Conn{conn: rlpx.NewConn(fd, s.Dest.Pubkey())}
+	if conn.ourKey, err = crypto.GenerateKey(); err != nil {
+		fd.Close()
+		return nil, err
+	}
+	if _, err := conn.conn.Handshake(conn.ourKey); err != nil {
+		fd.Close()
+		return nil, err
+	}
+	for _, version := range versions {
+		conn.caps = append(conn.caps, p2p.Cap{Name: "ctxc", Version: version})
+	}
+	return conn, nil
+}
+
+// setupConnection dials the node under test and executes both the devp2p and
+// the ctxc protocol handshakes. Versions below ctxc/66 are negotiated unless
+// ctxc/66 is requested explicitly.
+func (s *Suite) setupConnection(t *utesting.T, version uint) *Conn {
+	versions := []uint{64, 65}
+	if version >= 66 {
+		versions = []uint{66}
+	}
+	conn, err := s.dial(versions...)
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+	if err := conn.handshake(); err != nil {
+		conn.Close()
+		t.Fatalf("devp2p handshake failed: %v", err)
+	}
+	if _, err := conn.statusExchange(s.chain); err != nil {
+		conn.Close()
+		t.Fatalf("status exchange failed: %v", err)
+	}
+	return conn
+}
+
+// This test performs the ctxc handshake, checking the status message of the
+// node, including its fork ID, against the test chain.
+func (s *Suite) TestStatus(t *utesting.T) {
+	conn := s.setupConnection(t, 65)
+	defer conn.Close()
+
+	if conn.ctxcVersion >= 66 {
+		t.Fatalf("negotiated ctxc/%d, advertised up to ctxc/65", conn.ctxcVersion)
+	}
+}
+
+// This test performs the ctxc/66 handshake.
+func (s *Suite) TestStatus66(t *utesting.T) {
+	conn := s.setupConnection(t, 66)
+	defer conn.Close()
+
+	if conn.ctxcVersion != 66 {
+		t.Fatalf("negotiated ctxc/%d, want ctxc/66", conn.ctxcVersion)
+	}
+}
+
+func (s *Suite) TestGetBlockHeaders(t *utesting.T)   { s.testGetBlockHeaders(t, 65) }
+func (s *Suite) TestGetBlockHeaders66(t *utesting.T) { s.testGetBlockHeaders(t, 66) }
+
+// testGetBlockHeaders requests headers by number and hash, in both directions
+// and with skips, and checks them against the test chain.
+func (s *Suite) testGetBlockHeaders(t *utesting.T, version uint) {
+	conn := s.setupConnection(t, version)
+	defer conn.Close()
+
+	head := uint64(s.chain.Len() - 1)
+	queries := []*GetBlockHeaders{
+		{Origin: hashOrNumber{Number: 1}, Amount: 3},
+		{Origin: hashOrNumber{Number: 0}, Amount: 3, Skip: 1},
+		{Origin: hashOrNumber{Hash: s.chain.Head().Hash()}, Amount: 3, Reverse: true},
+		{Origin: hashOrNumber{Number: head}, Amount: 3, Skip: 1, Reverse: true},
+		{Origin: hashOrNumber{Number: head - 1}, Amount: 5},
+		{Origin: hashOrNumber{Hash: common.Hash{0xff}}, Amount: 1},
+	}
+	for i, query := range queries {
+		want, err := s.chain.GetHeaders(query)
+		if err != nil {
+			t.Fatalf("query %d: %v", i, err)
+		}
+		switch msg := conn.request(s.chain, query, BlockHeaders{}).(type) {
+		case *BlockHeaders:
+			if err := checkHeaders(*msg, want); err != nil {
+				t.Fatalf("query %d: %v", i, err)
+			}
+		default:
+			t.Fatalf("query %d: unexpected response: %s", i, describe(msg))
+		}
+	}
+}
+
+func (s *Suite) TestGetBlockBodies(t *utesting.T)   { s.testGetBlockBodies(t, 65) }
+func (s *Suite) TestGetBlockBodies66(t *utesting.T) { s.testGetBlockBodies(t, 66) }
+
+// testGetBlockBodies requests the bodies of known and unknown blocks and checks
+// them against the header commitments of the test chain.
+func (s *Suite) testGetBlockBodies(t *utesting.T, version uint) {
+	conn := s.setupConnection(t, version)
+	defer conn.Close()
+
+	blocks := []*types.Block{s.chain.blocks[1], s.chain.Head()}
+	req := GetBlockBodies{blocks[0].Hash(), {0xff}, blocks[1].Hash()}
+
+	switch msg := conn.request(s.chain, req, BlockBodies{}).(type) {
+	case *BlockBodies:
+		if len(*msg) != len(blocks) {
+			t.Fatalf("wrong number of bodies: have %d, want %d", len(*msg), len(blocks))
+		}
+		for i, body := range *msg {
+			if hash := types.DeriveSha(types.Transactions(body.Transactions), trie.NewStackTrie(nil)); hash != blocks[i].TxHash() {
+				t.Errorf("body %d: transaction root mismatch: have %x, want %x", i, hash, blocks[i].TxHash())
+			}
+			if hash := types.CalcUncleHash(body.Uncles); hash != blocks[i].UncleHash() {
+				t.Errorf("body %d: uncle hash mismatch: have %x, want %x", i, hash, blocks[i].UncleHash())
+			}
+		}
+	default:
+		t.Fatalf("unexpected response: %s", describe(msg))
+	}
+}
+
+func (s *Suite) TestGetReceipts(t *utesting.T)   { s.testGetReceipts(t, 65) }
+func (s *Suite) TestGetReceipts66(t *utesting.T) { s.testGetReceipts(t, 66) }
+
+// testGetReceipts requests the receipts of known blocks and checks them against
+// the receipt roots of the test chain.
+func (s *Suite) testGetReceipts(t *utesting.T, version uint) {
+	conn := s.setupConnection(t, version)
+	defer conn.Close()
+
+	blocks := []*types.Block{s.chain.blocks[1], s.chain.Head()}
+	req := GetReceipts{blocks[0].Hash(), blocks[1].Hash()}
+
+	switch msg := conn.request(s.chain, req, Receipts{}).(type) {
+	case *Receipts:
+		if len(*msg) != len(blocks) {
+			t.Fatalf("wrong number of receipt lists: have %d, want %d", len(*msg), len(blocks))
+		}
+		for i, receipts := range *msg {
+			if hash := types.DeriveSha(types.Receipts(receipts), trie.NewStackTrie(nil)); hash != blocks[i].ReceiptHash() {
+				t.Errorf("receipts %d: receipt root mismatch: have %x, want %x", i, hash, blocks[i].ReceiptHash())
+			}
+		}
+	default:
+		t.Fatalf("unexpected response: %s", describe(msg))
+	}
+}
+
+func (s *Suite) TestGetNodeData(t *utesting.T)   { s.testGetNodeData(t, 65) }
+func (s *Suite) TestGetNodeData66(t *utesting.T) { s.testGetNodeData(t, 66) }
+
+// testGetNodeData requests the state root node of the head block, which every
+// node keeps around, and checks the hash of the returned data.
+func (s *Suite) testGetNodeData(t *utesting.T, version uint) {
+	conn := s.setupConnection(t, version)
+	defer conn.Close()
+
+	root := s.chain.Head().Root()
+	switch msg := conn.request(s.chain, GetNodeData{root}, NodeData{}).(type) {
+	case *NodeData:
+		if len(*msg) != 1 {
+			t.Fatalf("wrong number of state entries: have %d, want 1", len(*msg))
+		}
+		if hash := crypto.Keccak256Hash((*msg)[0]); hash != root {
+			t.Fatalf("state entry hash mismatch: have %x, want %x", hash, root)
+		}
+	default:
+		t.Fatalf("unexpected response: %s", describe(msg))
+	}
+}
+
+// This test sends two header requests at once and checks that the responses
+// are routed back by their request IDs.
+func (s *Suite) TestSimultaneousRequests66(t *utesting.T) {
+	conn := s.setupConnection(t, 66)
+	defer conn.Close()
+
+	queries := map[uint64]*GetBlockHeaders{
+		111: {Origin: hashOrNumber{Number: 1}, Amount: 2},
+		222: {Origin: hashOrNumber{Hash: s.chain.Head().Hash()}, Amount: 2, Reverse: true},
+	}
+	for id, query := range queries {
+		if err := conn.WritePacket(id, query); err != nil {
+			t.Fatalf("could not write to connection: %v", err)
+		}
+	}
+	for len(queries) > 0 {
+		id, msg := conn.readAndServe(s.chain, timeout)
+		switch msg := msg.(type) {
+		case *BlockHeaders:
+			query, ok := queries[id]
+			if !ok {
+				t.Fatalf("unexpected request id %d", id)
+			}
+			want, _ := s.chain.GetHeaders(query)
+			if err := checkHeaders(*msg, want); err != nil {
+				t.Fatalf("request %d: %v", id, err)
+			}
+			delete(queries, id)
+		case *Error, *Disconnect:
+			t.Fatalf("unexpected response: %s", describe(msg))
+		}
+	}
+}
+
+// This test sends a response nobody asked for, which must be dropped without
+// affecting the connection.
+func (s *Suite) TestUnsolicitedResponse66(t *utesting.T) {
+	conn := s.setupConnection(t, 66)
+	defer conn.Close()
+
+	if err := conn.WritePacket(12345, BlockHeaders{s.chain.Head().Header()}); err != nil {
+		t.Fatalf("could not write to connection: %v", err)
+	}
+	query := &GetBlockHeaders{Origin: hashOrNumber{Number: 1}, Amount: 1}
+	switch msg := conn.request(s.chain, query, BlockHeaders{}).(type) {
+	case *BlockHeaders:
+		if err := checkHeaders(*msg, BlockHeaders{s.chain.blocks[1].Header()}); err != nil {
+			t.Fatal(err)
+		}
+	default:
+		t.Fatalf("unexpected response: %s", describe(msg))
+	}
+}
+
+// This test propagates the next block of the test chain on one connection and
+// expects the node to import it and relay it on another connection.
+func (s *Suite) TestBroadcast(t *utesting.T) {
+	if s.chain.Len() >= s.fullChain.Len() {
+		t.Fatalf("no pending blocks left to broadcast")
+	}
+	sendConn, recvConn := s.setupConnection(t, 65), s.setupConnection(t, 65)
+	defer sendConn.Close()
+	defer recvConn.Close()
+
+	block := s.fullChain.blocks[s.chain.Len()]
+	if err := sendConn.Write(&NewBlock{Block: block, TD: s.fullChain.TDAt(int(block.NumberU64()))}); err != nil {
+		t.Fatalf("could not write to connection: %v", err)
+	}
+	s.waitAnnounce(t, recvConn, block)
+
+	// The block was imported, update the chain the remaining tests expect
+	s.chain = s.fullChain.Shorten(s.chain.Len() + 1)
+
+	// Blocks are relayed before being imported, wait until the node serves it
+	query := &GetBlockHeaders{Origin: hashOrNumber{Hash: block.Hash()}, Amount: 1}
+	for deadline := time.Now().Add(timeout); ; time.Sleep(100 * time.Millisecond) {
+		switch msg := recvConn.request(s.chain, query, BlockHeaders{}).(type) {
+		case *BlockHeaders:
+			if len(*msg) > 0 {
+				if err := checkHeaders(*msg, BlockHeaders{block.Header()}); err != nil {
+					t.Fatalf("propagated block not served: %v", err)
+				}
+				return
+			}
+		default:
+			t.Fatalf("unexpected response: %s", describe(msg))
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("propagated block #%d not imported", block.NumberU64())
+		}
+	}
+}
+
+// waitAnnounce waits until the node propagates or announces the given block.
+func (s *Suite) waitAnnounce(t *utesting.T, conn *Conn, block *types.Block) {
+	for deadline := time.Now().Add(timeout); ; {
+		_, msg := conn.readAndServe(s.fullChain, time.Until(deadline))
+		switch msg := msg.(type) {
+		case *NewBlock:
+			if msg.Block.Hash() == block.Hash() {
+				return
+			}
+		case *NewBlockHashes:
+			for _, announce := range *msg {
+				if announce.Hash == block.Hash() {
+					return
+				}
+			}
+		case *Error, *Disconnect:
+			t.Fatalf("block #%d not propagated: %s", block.NumberU64(), describe(msg))
+		}
+	}
+}
+
+// This test propagates blocks with an impossibly large total difficulty and
+// with a malformed encoding, both of which must get the connection dropped.
+func (s *Suite) TestLargeAnnounce(t *utesting.T) {
+	block := s.fullChain.blocks[s.chain.Len()-1]
+	if s.chain.Len() < s.fullChain.Len() {
+		block = s.fullChain.blocks[s.chain.Len()]
+	}
+	td := new(big.Int).Lsh(big.NewInt(1), 128)
+
+	conn := s.setupConnection(t, 65)
+	defer conn.Close()
+	if err := conn.Write(&NewBlock{Block: block, TD: td}); err != nil {
+		t.Fatalf("could not write to connection: %v", err)
+	}
+	s.expectDisconnect(t, conn)
+
+	conn = s.setupConnection(t, 65)
+	defer conn.Close()
+	payload, _ := rlp.EncodeToBytes([]interface{}{block.Header(), td})
+	if err := conn.WriteRaw(uint64((NewBlock{}).Code()), payload); err != nil {
+		t.Fatalf("could not write to connection: %v", err)
+	}
+	s.expectDisconnect(t, conn)
+}
+
+// This test sends the first transaction of the next block of the test chain and
+// expects the node to relay it on another connection. The node only accepts
+// transactions once it imported a propagated block, so this test has to run
+// after the broadcast test.
+func (s *Suite) TestTransaction(t *utesting.T) {
+	tx := s.nextTransaction(t)
+
+	sendConn, recvConn := s.setupConnection(t, 65), s.setupConnection(t, 65)
+	defer sendConn.Close()
+	defer recvConn.Close()
+
+	if err := sendConn.Write(Transactions{tx}); err != nil {
+		t.Fatalf("could not write to connection: %v", err)
+	}
+	for deadline := time.Now().Add(timeout); ; {
+		_, msg := recvConn.readAndServe(s.chain, time.Until(deadline))
+		switch msg := msg.(type) {
+		case *Transactions:
+			for _, have := range *msg {
+				if have.Hash() == tx.Hash() {
+					return
+				}
+			}
+		case *NewPooledTransactionHashes:
+			for _, hash := range *msg {
+				if hash == tx.Hash() {
+					return
+				}
+			}
+		case *Error, *Disconnect:
+			t.Fatalf("transaction %x not propagated: %s", tx.Hash(), describe(msg))
+		}
+	}
+}
+
+// nextTransaction returns a transaction which is valid on top of the chain of
+// the node under test.
+func (s *Suite) nextTransaction(t *utesting.T) *types.Transaction {
+	if s.chain.Len() >= s.fullChain.Len() {
+		t.Fatalf("no pending blocks left to take transactions from")
+	}
+	txs := s.fullChain.blocks[s.chain.Len()].Transactions()
+	if len(txs) == 0 {
+		t.Fatalf("pending block #%d contains no transactions", s.chain.Len())
+	}
+	return txs[0]
+}
+
+// This test sends transactions which the node must reject and checks that none
+// of them is relayed.
+func (s *Suite) TestMaliciousTransactions(t *utesting.T) {
+	var included *types.Transaction
+	for _, block := range s.chain.blocks {
+		if len(block.Transactions()) > 0 {
+			included = block.Transactions()[0]
+			break
+		}
+	}
+	if included == nil {
+		t.Fatalf("test chain contains no transactions")
+	}
+	// Transactions of a sender without funds, one of them exceeding the block
+	// gas limit as well
+	key, _ := crypto.GenerateKey()
+	signer := types.MakeSigner(s.chain.chainConfig, s.chain.Head().Number())
+	unfunded, _ := types.SignTx(types.NewTransaction(0, common.Address{0x01}, big.NewInt(1), 21000, big.NewInt(1), nil), signer, key)
+	oversized, _ := types.SignTx(types.NewTransaction(1, common.Address{0x01}, big.NewInt(1), s.chain.Head().GasLimit()+1, big.NewInt(1), nil), signer, key)
+
+	invalid := map[common.Hash]bool{included.Hash(): true, unfunded.Hash(): true, oversized.Hash(): true}
+
+	sendConn, recvConn := s.setupConnection(t, 65), s.setupConnection(t, 65)
+	defer sendConn.Close()
+	defer recvConn.Close()
+
+	if err := sendConn.Write(Transactions{included, unfunded, oversized}); err != nil {
+		t.Fatalf("could not write to connection: %v", err)
+	}
+	for deadline := time.Now().Add(propagationWait); time.Now().Before(deadline); {
+		_, msg := recvConn.readAndServe(s.chain, time.Until(deadline))
+		switch msg := msg.(type) {
+		case *Transactions:
+			for _, tx := range *msg {
+				if invalid[tx.Hash()] {
+					t.Fatalf("invalid transaction %x propagated", tx.Hash())
+				}
+			}
+		case *NewPooledTransactionHashes:
+			for _, hash := range *msg {
+				if invalid[hash] {
+					t.Fatalf("invalid transaction %x announced", hash)
+				}
+			}
+		case *Disconnect:
+			t.Fatalf("unexpected disconnect: %v", msg.Reason)
+		}
+	}
+}
+
+// This test sends devp2p handshakes with an identity not matching the RLPx
+// session and without any common protocol, expecting a disconnect for each.
+func (s *Suite) TestMaliciousHandshake(t *utesting.T) {
+	key, _ := crypto.GenerateKey()
+	hellos := []func(c *Conn) *Hello{
+		func(c *Conn) *Hello {
+			return &Hello{Version: 5, Caps: c.caps, ID: crypto.FromECDSAPub(&key.PublicKey)[1:]}
+		},
+		func(c *Conn) *Hello {
+			return &Hello{Version: 5, Caps: c.caps, ID: crypto.FromECDSAPub(&c.ourKey.PublicKey)[1:32]}
+		},
+		func(c *Conn) *Hello {
+			return &Hello{Version: 5, Caps: []p2p.Cap{{Name: "bogus", Version: 1}}, ID: crypto.FromECDSAPub(&c.ourKey.PublicKey)[1:]}
+		},
+	}
+	for i, hello := range hellos {
+		conn, err := s.dial(64, 65)
+		if err != nil {
+			t.Fatalf("could not dial: %v", err)
+		}
+		if err := conn.Write(hello(conn)); err != nil {
+			conn.Close()
+			t.Fatalf("handshake %d: could not write to connection: %v", i, err)
+		}
+		if err := s.waitDisconnect(conn); err != nil {
+			t.Errorf("handshake %d: %v", i, err)
+		}
+		conn.Close()
+	}
+}
+
+// This test answers the status message of the node with mismatching fields,
+// expecting a disconnect for each of them.
+func (s *Suite) TestMaliciousStatus(t *utesting.T) {
+	bad := []func(status *Status){
+		func(status *Status) { status.ProtocolVersion++ },
+		func(status *Status) { status.NetworkID++ },
+		func(status *Status) { status.Genesis = common.Hash{0x01} },
+		func(status *Status) { status.ForkID = forkid.ID{Hash: [4]byte{0xba, 0xdf, 0x00, 0xd5}} },
+	}
+	for i, modify := range bad {
+		conn, err := s.dial(64, 65)
+		if err != nil {
+			t.Fatalf("could not dial: %v", err)
+		}
+		if err := conn.handshake(); err != nil {
+			conn.Close()
+			t.Fatalf("status %d: devp2p handshake failed: %v", i, err)
+		}
+		remote, err := conn.readStatus(s.chain)
+		if err != nil {
+			conn.Close()
+			t.Fatalf("status %d: %v", i, err)
+		}
+		status := s.chain.status(conn.ctxcVersion, remote.NetworkID)
+		modify(status)
+		if err := conn.Write(status); err != nil {
+			conn.Close()
+			t.Fatalf("status %d: could not write to connection: %v", i, err)
+		}
+		if err := s.waitDisconnect(conn); err != nil {
+			t.Errorf("status %d: %v", i, err)
+		}
+		conn.Close()
+	}
+}
+
+// This test sends messages which are invalid after the handshake, expecting a
+// disconnect for each of them.
+func (s *Suite) TestMalformedMessages(t *utesting.T) {
+	garbage := []byte{0xc3, 0x01, 0x02}
+	status, _ := rlp.EncodeToBytes(s.chain.status(65, 0))
+
+	messages := []struct {
+		name    string
+		code    uint64
+		payload []byte
+	}{
+		{"extra status", uint64((Status{}).Code()), status},
+		{"undecodable header request", uint64((GetBlockHeaders{}).Code()), garbage},
+		{"undecodable block announcement", uint64((NewBlockHashes{}).Code()), garbage},
+		{"unknown message code", baseProtocolLength + 0x0b, []byte{0xc0}},
+		{"message code out of range", baseProtocolLength + 0x40, []byte{0xc0}},
+	}
+	for _, msg := range messages {
+		conn := s.setupConnection(t, 65)
+		if err := conn.WriteRaw(msg.code, msg.payload); err != nil {
+			conn.Close()
+			t.Fatalf("%s: could not write to connection: %v", msg.name, err)
+		}
+		if err := s.waitDisconnect(conn); err != nil {
+			t.Errorf("%s: %v", msg.name, err)
+		}
+		conn.Close()
+	}
+}
+
+// expectDisconnect fails the test unless the node drops the connection.
+func (s *Suite) expectDisconnect(t *utesting.T, conn *Conn) {
+	if err := s.waitDisconnect(conn); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitDisconnect waits until the node sends a disconnect message or closes the
+// connection, returning an error if it stays connected.
+func (s *Suite) waitDisconnect(conn *Conn) error {
+	for deadline := time.Now().Add(timeout); ; {
+		_, msg := conn.readAndServe(s.chain, time.Until(deadline))
+		switch msg := msg.(type) {
+		case *Disconnect:
+			return nil
+		case *Error:
+			var netErr net.Error
+			if errors.As(msg, &netErr) && netErr.Timeout() {
+				return fmt.Errorf("node did not disconnect")
+			}
+			return nil
+		}
+	}
+}
+
+// checkHeaders compares a header response with the expected headers.
+func checkHeaders(have, want BlockHeaders) error {
+	if len(have) != len(want) {
+		return fmt.Errorf("wrong number of headers: have %d, want %d", len(have), len(want))
+	}
+	for i := range have {
+		if have[i].Hash() != want[i].Hash() {
+			return fmt.Errorf("header %d mismatch: have #%d [%x], want #%d [%x]", i, have[i].Number, have[i].Hash(), want[i].Number, want[i].Hash())
+		}
+	}
+	return nil
+}
+
+// describe renders a message for test failure reports.
+func describe(msg Message) string {
+	switch msg := msg.(type) {
+	case *Error:
+		return msg.Error()
+	case *Disconnect:
+		return fmt.Sprintf("disconnect: %v", msg.Reason)
+	default:
+		return fmt.Sprintf("%T", msg)
+	}
+}
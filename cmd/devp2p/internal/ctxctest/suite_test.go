@@ -0,0 +1,133 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of CortexTheseus.
+//
+// CortexTheseus is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// CortexTheseus is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with CortexTheseus. If not, see <http://www.gnu.org/licenses/>.
+
+package ctxctest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/consensus/cuckoo"
+	"github.com/CortexFoundation/CortexTheseus/core"
+	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/ctxc"
+	"github.com/CortexFoundation/CortexTheseus/ctxc/downloader"
+	"github.com/CortexFoundation/CortexTheseus/internal/utesting"
+	"github.com/CortexFoundation/CortexTheseus/node"
+	"github.com/CortexFoundation/CortexTheseus/p2p"
+	"github.com/CortexFoundation/CortexTheseus/params"
+	"github.com/CortexFoundation/CortexTheseus/rlp"
+)
+
+var (
+	testKey, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	testAddress = crypto.PubkeyToAddress(testKey.PublicKey)
+)
+
+// Tests the suite against a local node on the loopback interface.
+func TestCtxcSuite(t *testing.T) {
+	dir := t.TempDir()
+	chainfile, genesisfile := filepath.Join(dir, "chain.rlp"), filepath.Join(dir, "genesis.json")
+	genesis, blocks := generateTestChain(t, chainfile, genesisfile, 16)
+
+	n := runNode(t, genesis, blocks[:len(blocks)-PendingBlocks])
+	suite, err := NewSuite(n.Server().Self(), chainfile, genesisfile)
+	if err != nil {
+		t.Fatalf("could not create test suite: %v", err)
+	}
+	for _, test := range suite.AllTests() {
+		t.Run(test.Name, func(t *testing.T) {
+			result := utesting.RunTests([]utesting.Test{test}, os.Stdout)
+			if result[0].Failed {
+				t.Fatal()
+			}
+		})
+	}
+}
+
+// generateTestChain creates a chain with a value transfer in every block and
+// writes it to the given files.
+func generateTestChain(t *testing.T, chainfile, genesisfile string, length int) (*core.Genesis, []*types.Block) {
+	genesis := &core.Genesis{
+		Config:     params.TestChainConfig,
+		Alloc:      core.GenesisAlloc{testAddress: {Balance: big.NewInt(params.Cortex)}},
+		GasLimit:   params.GenesisGasLimit,
+		Difficulty: params.GenesisDifficulty,
+		Supply:     params.CTXC_INIT,
+	}
+	db := rawdb.NewMemoryDatabase()
+	signer := types.NewEIP155Signer(params.TestChainConfig.ChainID)
+	blocks, _ := core.GenerateChain(params.TestChainConfig, genesis.MustCommit(db), cuckoo.NewFaker(), db, length, func(i int, gen *core.BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(gen.TxNonce(testAddress), common.Address{0xaa}, big.NewInt(1), params.TxGas, big.NewInt(params.GWei), nil), signer, testKey)
+		gen.AddTx(tx)
+	})
+	data, err := json.Marshal(genesis)
+	if err != nil {
+		t.Fatalf("could not encode genesis: %v", err)
+	}
+	if err := ioutil.WriteFile(genesisfile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.Create(chainfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	for _, block := range blocks {
+		if err := rlp.Encode(out, block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return genesis, blocks
+}
+
+// runNode starts a node listening on the loopback interface and imports the
+// given blocks.
+func runNode(t *testing.T, genesis *core.Genesis, blocks []*types.Block) *node.Node {
+	n, err := node.New(&node.Config{
+		P2P: p2p.Config{
+			ListenAddr:  "127.0.0.1:0",
+			NoDiscovery: true,
+			MaxPeers:    10,
+		},
+	})
+	if err != nil {
+		t.Fatalf("could not create node: %v", err)
+	}
+	var service *ctxc.Cortex
+	n.Register(func(ctx *node.ServiceContext) (node.Service, error) {
+		config := &ctxc.Config{Genesis: genesis, NetworkId: 4242, SyncMode: downloader.FullSync}
+		config.Cuckoo.PowMode = cuckoo.ModeFake
+		service, err = ctxc.New(ctx, config)
+		return service, err
+	})
+	if err := n.Start(); err != nil {
+		t.Fatalf("could not start node: %v", err)
+	}
+	t.Cleanup(func() { n.Close() })
+
+	if _, err := service.BlockChain().InsertChain(blocks); err != nil {
+		t.Fatalf("could not import blocks: %v", err)
+	}
+	return n
+}
@@ -0,0 +1,494 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of CortexTheseus.
+//
+// CortexTheseus is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// CortexTheseus is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with CortexTheseus. If not, see <http://www.gnu.org/licenses/>.
+
+package ctxctest
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core/forkid"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/p2p"
+	"github.com/CortexFoundation/CortexTheseus/p2p/rlpx"
+	"github.com/CortexFoundation/CortexTheseus/rlp"
+)
+
+// This is the response timeout used in tests.
+const timeout = 20 * time.Second
+
+// baseProtocolLength is the number of message codes reserved by the devp2p
+// base protocol. The ctxc message codes follow right after it.
+const baseProtocolLength = 16
+
+// Message is a devp2p or ctxc protocol message.
+type Message interface {
+	Code() int
+}
+
+// Error represents an error during message reading.
+// This exists to facilitate type-switching on the result of Conn.Read.
+type Error struct {
+	err error
+}
+
+func (e *Error) Unwrap() error  { return e.err }
+func (e *Error) Error() string  { return e.err.Error() }
+func (e *Error) Code() int      { return -1 }
+func (e *Error) String() string { return e.Error() }
+
+func errorf(format string, args ...interface{}) *Error {
+	return &Error{fmt.Errorf(format, args...)}
+}
+
+// Hello is the RLP structure of the devp2p protocol handshake.
+type Hello struct {
+	Version    uint64
+	Name       string
+	Caps       []p2p.Cap
+	ListenPort uint64
+	ID         []byte // secp256k1 public key
+
+	// Ignore additional fields (for forward compatibility).
+	Rest []rlp.RawValue `rlp:"tail"`
+}
+
+func (h Hello) Code() int { return 0x00 }
+
+// Disconnect is the RLP structure for a disconnect message.
+type Disconnect struct {
+	Reason p2p.DiscReason
+}
+
+func (d Disconnect) Code() int { return 0x01 }
+
+type Ping struct{}
+
+func (p Ping) Code() int { return 0x02 }
+
+type Pong struct{}
+
+func (p Pong) Code() int { return 0x03 }
+
+// Status is the network packet for the status message.
+type Status struct {
+	ProtocolVersion uint32
+	NetworkID       uint64
+	TD              *big.Int
+	Head            common.Hash
+	Genesis         common.Hash
+	ForkID          forkid.ID
+}
+
+func (s Status) Code() int { return 16 }
+
+// NewBlockHashes is the network packet for the block announcements.
+type NewBlockHashes []struct {
+	Hash   common.Hash // Hash of one particular block being announced
+	Number uint64      // Number of one particular block being announced
+}
+
+func (nbh NewBlockHashes) Code() int { return 17 }
+
+// Transactions is the network packet for transaction propagation.
+type Transactions []*types.Transaction
+
+func (t Transactions) Code() int { return 18 }
+
+// GetBlockHeaders represents a block header query.
+type GetBlockHeaders struct {
+	Origin  hashOrNumber // Block from which to retrieve headers
+	Amount  uint64       // Maximum number of headers to retrieve
+	Skip    uint64       // Blocks to skip between consecutive headers
+	Reverse bool         // Query direction (false = rising towards latest, true = falling towards genesis)
+}
+
+func (g GetBlockHeaders) Code() int { return 19 }
+
+// BlockHeaders is the network packet for block header responses.
+type BlockHeaders []*types.Header
+
+func (bh BlockHeaders) Code() int { return 20 }
+
+// GetBlockBodies represents a block body query.
+type GetBlockBodies []common.Hash
+
+func (gbb GetBlockBodies) Code() int { return 21 }
+
+// BlockBody represents the data content of a single block.
+type BlockBody struct {
+	Transactions []*types.Transaction // Transactions contained within a block
+	Uncles       []*types.Header      // Uncles contained within a block
+}
+
+// BlockBodies is the network packet for block content distribution.
+type BlockBodies []*BlockBody
+
+func (bb BlockBodies) Code() int { return 22 }
+
+// NewBlock is the network packet for the block propagation message.
+type NewBlock struct {
+	Block *types.Block
+	TD    *big.Int
+}
+
+func (nb NewBlock) Code() int { return 23 }
+
+// NewPooledTransactionHashes is the network packet for the transaction
+// announcements of ctxc/65 and above.
+type NewPooledTransactionHashes []common.Hash
+
+func (nb NewPooledTransactionHashes) Code() int { return 24 }
+
+// GetNodeData represents a state trie node and contract code query.
+type GetNodeData []common.Hash
+
+func (gnd GetNodeData) Code() int { return 29 }
+
+// NodeData is the network packet for state trie node and contract code
+// responses.
+type NodeData [][]byte
+
+func (nd NodeData) Code() int { return 30 }
+
+// GetReceipts represents a block receipts query.
+type GetReceipts []common.Hash
+
+func (gr GetReceipts) Code() int { return 31 }
+
+// Receipts is the network packet for block receipts responses.
+type Receipts [][]*types.Receipt
+
+func (r Receipts) Code() int { return 32 }
+
+// hashOrNumber is a combined field for specifying an origin block.
+type hashOrNumber struct {
+	Hash   common.Hash // Block hash from which to retrieve headers (excludes Number)
+	Number uint64      // Block hash from which to retrieve headers (excludes Hash)
+}
+
+// EncodeRLP is a specialized encoder for hashOrNumber to encode only one of the
+// two contained union fields.
+func (hn *hashOrNumber) EncodeRLP(w io.Writer) error {
+	if hn.Hash == (common.Hash{}) {
+		return rlp.Encode(w, hn.Number)
+	}
+	if hn.Number != 0 {
+		return fmt.Errorf("both origin hash (%x) and number (%d) provided", hn.Hash, hn.Number)
+	}
+	return rlp.Encode(w, hn.Hash)
+}
+
+// DecodeRLP is a specialized decoder for hashOrNumber to decode the contents
+// into either a block hash or a block number.
+func (hn *hashOrNumber) DecodeRLP(s *rlp.Stream) error {
+	_, size, _ := s.Kind()
+	origin, err := s.Raw()
+	if err == nil {
+		switch {
+		case size == 32:
+			err = rlp.DecodeBytes(origin, &hn.Hash)
+		case size <= 8:
+			err = rlp.DecodeBytes(origin, &hn.Number)
+		default:
+			err = fmt.Errorf("invalid input size %d for origin", size)
+		}
+	}
+	return err
+}
+
+// packet66 is the ctxc/66 envelope of requests and responses, binding each of
+// them to a request identifier.
+type packet66 struct {
+	RequestId uint64
+	Data      rlp.RawValue
+}
+
+// isRequest66 reports whether messages with the given code are wrapped into a
+// request envelope on ctxc/66.
+func isRequest66(code int) bool {
+	switch code - baseProtocolLength {
+	case 0x03, 0x04, 0x05, 0x06, 0x09, 0x0a, 0x0d, 0x0e, 0x0f, 0x10:
+		return true
+	}
+	return false
+}
+
+// Conn represents an individual connection with a peer.
+type Conn struct {
+	conn        *rlpx.Conn
+	ourKey      *ecdsa.PrivateKey
+	caps        []p2p.Cap
+	ctxcVersion uint // negotiated ctxc protocol version
+	lastReqID   uint64
+}
+
+// Close closes the underlying network connection.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// SetDeadline sets the read and write deadline of the connection.
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+// Read reads a message from the connection, stripping the ctxc/66 request
+// envelope if present.
+func (c *Conn) Read() Message {
+	_, msg := c.ReadPacket()
+	return msg
+}
+
+// ReadPacket reads a message from the connection. The request identifier is
+// only set for requests and responses of ctxc/66.
+func (c *Conn) ReadPacket() (uint64, Message) {
+	code, rawData, _, err := c.conn.Read()
+	if err != nil {
+		return 0, &Error{fmt.Errorf("could not read from connection: %w", err)}
+	}
+	var reqID uint64
+	if c.ctxcVersion >= 66 && isRequest66(int(code)) {
+		var packet packet66
+		if err := rlp.DecodeBytes(rawData, &packet); err != nil {
+			return 0, errorf("could not decode request envelope: %v", err)
+		}
+		reqID, rawData = packet.RequestId, packet.Data
+	}
+	var msg Message
+	switch int(code) {
+	case (Hello{}).Code():
+		msg = new(Hello)
+	case (Disconnect{}).Code():
+		msg = new(Disconnect)
+	case (Ping{}).Code():
+		msg = new(Ping)
+	case (Pong{}).Code():
+		msg = new(Pong)
+	case (Status{}).Code():
+		msg = new(Status)
+	case (NewBlockHashes{}).Code():
+		msg = new(NewBlockHashes)
+	case (Transactions{}).Code():
+		msg = new(Transactions)
+	case (GetBlockHeaders{}).Code():
+		msg = new(GetBlockHeaders)
+	case (BlockHeaders{}).Code():
+		msg = new(BlockHeaders)
+	case (GetBlockBodies{}).Code():
+		msg = new(GetBlockBodies)
+	case (BlockBodies{}).Code():
+		msg = new(BlockBodies)
+	case (NewBlock{}).Code():
+		msg = new(NewBlock)
+	case (NewPooledTransactionHashes{}).Code():
+		msg = new(NewPooledTransactionHashes)
+	case (GetNodeData{}).Code():
+		msg = new(GetNodeData)
+	case (NodeData{}).Code():
+		msg = new(NodeData)
+	case (GetReceipts{}).Code():
+		msg = new(GetReceipts)
+	case (Receipts{}).Code():
+		msg = new(Receipts)
+	default:
+		return reqID, errorf("invalid message code: %d", code)
+	}
+	if err := rlp.DecodeBytes(rawData, msg); err != nil {
+		return reqID, errorf("could not rlp decode message: %v", err)
+	}
+	return reqID, msg
+}
+
+// Write writes a message to the connection. Requests and responses are sent
+// with a zero request identifier on ctxc/66.
+func (c *Conn) Write(msg Message) error {
+	return c.WritePacket(0, msg)
+}
+
+// WritePacket writes a message to the connection, wrapping requests and
+// responses into a request envelope with the given identifier on ctxc/66.
+func (c *Conn) WritePacket(reqID uint64, msg Message) error {
+	payload, err := rlp.EncodeToBytes(msg)
+	if err != nil {
+		return err
+	}
+	if c.ctxcVersion >= 66 && isRequest66(msg.Code()) {
+		if payload, err = rlp.EncodeToBytes(&packet66{RequestId: reqID, Data: payload}); err != nil {
+			return err
+		}
+	}
+	return c.WriteRaw(uint64(msg.Code()), payload)
+}
+
+// WriteRaw writes an arbitrary payload under the given message code, allowing
+// malformed messages to be sent.
+func (c *Conn) WriteRaw(code uint64, payload []byte) error {
+	_, err := c.conn.Write(code, payload)
+	return err
+}
+
+// handshake performs the devp2p protocol handshake and negotiates the ctxc
+// protocol version.
+func (c *Conn) handshake() error {
+	defer c.SetDeadline(time.Time{})
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+
+	pub := crypto.FromECDSAPub(&c.ourKey.PublicKey)[1:]
+	if err := c.Write(&Hello{Version: 5, Caps: c.caps, ID: pub}); err != nil {
+		return fmt.Errorf("write to connection failed: %v", err)
+	}
+	switch msg := c.Read().(type) {
+	case *Hello:
+		// Snappy compression is enabled right after the hello exchange
+		if msg.Version >= 5 {
+			c.conn.SetSnappy(true)
+		}
+		return c.negotiateCtxcProtocol(msg.Caps)
+	case *Disconnect:
+		return fmt.Errorf("disconnect received: %v", msg.Reason)
+	case *Error:
+		return msg
+	default:
+		return fmt.Errorf("bad handshake: %#v", msg)
+	}
+}
+
+// negotiateCtxcProtocol picks the highest ctxc protocol version supported by
+// both sides.
+func (c *Conn) negotiateCtxcProtocol(caps []p2p.Cap) error {
+	var highest uint
+	for _, capability := range caps {
+		if capability.Name != "ctxc" || capability.Version <= highest {
+			continue
+		}
+		for _, ours := range c.caps {
+			if ours == capability {
+				highest = capability.Version
+			}
+		}
+	}
+	if highest == 0 {
+		return fmt.Errorf("no common ctxc protocol version, remote caps %v", caps)
+	}
+	c.ctxcVersion = highest
+	return nil
+}
+
+// readStatus reads the status message of the node under test and checks it
+// against the given chain.
+func (c *Conn) readStatus(chain *Chain) (*Status, error) {
+	defer c.SetDeadline(time.Time{})
+	c.SetDeadline(time.Now().Add(timeout))
+
+	for {
+		switch msg := c.Read().(type) {
+		case *Status:
+			if have, want := msg.ProtocolVersion, uint32(c.ctxcVersion); have != want {
+				return nil, fmt.Errorf("wrong protocol version: have %d, want %d", have, want)
+			}
+			if have, want := msg.Genesis, chain.Genesis().Hash(); have != want {
+				return nil, fmt.Errorf("wrong genesis block: have %x, want %x", have, want)
+			}
+			if have, want := msg.Head, chain.Head().Hash(); have != want {
+				return nil, fmt.Errorf("wrong head block: have %x, want %x (#%d)", have, want, chain.Head().NumberU64())
+			}
+			if have, want := msg.TD, chain.TD(); have.Cmp(want) != 0 {
+				return nil, fmt.Errorf("wrong total difficulty: have %v, want %v", have, want)
+			}
+			if have, want := msg.ForkID, chain.ForkID(); have != want {
+				return nil, fmt.Errorf("wrong fork ID: have %v, want %v", have, want)
+			}
+			return msg, nil
+		case *Disconnect:
+			return nil, fmt.Errorf("disconnect received: %v", msg.Reason)
+		case *Ping:
+			c.Write(&Pong{})
+		case *Error:
+			return nil, msg
+		default:
+			return nil, fmt.Errorf("bad status message: %#v", msg)
+		}
+	}
+}
+
+// statusExchange reads the status message of the node under test and replies
+// with a matching status derived from the given chain.
+func (c *Conn) statusExchange(chain *Chain) (*Status, error) {
+	remote, err := c.readStatus(chain)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Write(chain.status(c.ctxcVersion, remote.NetworkID)); err != nil {
+		return nil, fmt.Errorf("write to connection failed: %v", err)
+	}
+	return remote, nil
+}
+
+// readAndServe reads the next message which is not a request of the node under
+// test. Header requests are answered from the given chain, pings are answered
+// with pongs.
+func (c *Conn) readAndServe(chain *Chain, timeout time.Duration) (uint64, Message) {
+	defer c.SetDeadline(time.Time{})
+	c.SetDeadline(time.Now().Add(timeout))
+
+	for {
+		reqID, msg := c.ReadPacket()
+		switch msg := msg.(type) {
+		case *Ping:
+			c.Write(&Pong{})
+		case *GetBlockHeaders:
+			headers, err := chain.GetHeaders(msg)
+			if err != nil {
+				return 0, errorf("could not serve header request: %v", err)
+			}
+			if err := c.WritePacket(reqID, headers); err != nil {
+				return 0, errorf("could not write to connection: %v", err)
+			}
+		default:
+			return reqID, msg
+		}
+	}
+}
+
+// request sends a request to the node under test and waits for the response
+// with the same message code as the given one. On ctxc/66 the request carries
+// a fresh identifier which the response must echo.
+func (c *Conn) request(chain *Chain, req Message, resp Message) Message {
+	c.lastReqID++
+	id := c.lastReqID
+	if err := c.WritePacket(id, req); err != nil {
+		return errorf("could not write to connection: %v", err)
+	}
+	for {
+		reqID, msg := c.readAndServe(chain, timeout)
+		switch {
+		case msg.Code() == resp.Code():
+			if c.ctxcVersion >= 66 && reqID != id {
+				return errorf("request id mismatch: have %d, want %d", reqID, id)
+			}
+			return msg
+		case msg.Code() < 0, msg.Code() == (Disconnect{}).Code():
+			return msg
+		}
+		// Skip announcements and propagated transactions
+	}
+}
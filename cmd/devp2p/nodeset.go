@@ -89,6 +89,17 @@ func (ns nodeSet) add(nodes ...*enode.Node) {
 	}
 }
 
+// filter returns the nodes of the set matched by f.
+func (ns nodeSet) filter(f nodeFilter) nodeSet {
+	result := make(nodeSet)
+	for id, n := range ns {
+		if f(n) {
+			result[id] = n
+		}
+	}
+	return result
+}
+
 func (ns nodeSet) verify() error {
 	for id, n := range ns {
 		if n.N.ID() != id {
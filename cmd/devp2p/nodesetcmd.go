@@ -17,8 +17,11 @@
 package main
 
 import (
+	"bytes"
+	"encoding/hex"
 	"fmt"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/CortexFoundation/CortexTheseus/core/forkid"
@@ -73,13 +76,7 @@ func nodesetFilter(ctx *cli.Context) error {
 		return err
 	}
 
-	result := make(nodeSet)
-	for id, n := range ns {
-		if filter(n) {
-			result[id] = n
-		}
-	}
-	writeNodesJSON("-", result)
+	writeNodesJSON("-", ns.filter(filter))
 	return nil
 }
 
@@ -91,11 +88,12 @@ type nodeFilterC struct {
 }
 
 var filterFlags = map[string]nodeFilterC{
-	"-ip":          {1, ipFilter},
-	"-min-age":     {1, minAgeFilter},
-	"-eth-network": {1, ethFilter},
-	"-les-server":  {0, lesFilter},
-	"-snap":        {0, snapFilter},
+	"-ip":           {1, ipFilter},
+	"-min-age":      {1, minAgeFilter},
+	"-ctxc-network": {1, ctxcFilter},
+	"-ctxc-forkid":  {1, ctxcForkIDFilter},
+	"-les-server":   {0, lesFilter},
+	"-snap":         {0, snapFilter},
 }
 
 func parseFilters(args []string) ([]nodeFilter, error) {
@@ -155,24 +153,65 @@ func minAgeFilter(args []string) (nodeFilter, error) {
 	return f, nil
 }
 
-func ethFilter(args []string) (nodeFilter, error) {
-	var filter forkid.Filter
-	switch args[0] {
+// ctxcEntry is the "ctxc" ENR entry advertised by Cortex nodes. Older nodes only
+// advertise their fork ID, newer ones follow it with their network ID.
+type ctxcEntry struct {
+	ForkID forkid.ID
+	Rest   []rlp.RawValue `rlp:"tail"`
+}
+
+// loadCtxcEntry returns the fork ID and the network ID (zero if not advertised)
+// announced in the node's "ctxc" ENR entry.
+func loadCtxcEntry(n nodeJSON) (forkid.ID, uint64, bool) {
+	var ctxc ctxcEntry
+	if n.N.Load(enr.WithEntry("ctxc", &ctxc)) != nil {
+		return forkid.ID{}, 0, false
+	}
+	var networkID uint64
+	if len(ctxc.Rest) > 0 && rlp.DecodeBytes(ctxc.Rest[0], &networkID) != nil {
+		return forkid.ID{}, 0, false
+	}
+	return ctxc.ForkID, networkID, true
+}
+
+// networkFilter returns the static fork ID filter and the network ID of a known
+// Cortex network.
+func networkFilter(network string) (forkid.Filter, uint64, error) {
+	switch network {
 	case "mainnet":
-		filter = forkid.NewStaticFilter(params.MainnetChainConfig, params.MainnetGenesisHash)
+		return forkid.NewStaticFilter(params.MainnetChainConfig, params.MainnetGenesisHash), 21, nil
+	case "bernard":
+		return forkid.NewStaticFilter(params.BernardChainConfig, params.BernardGenesisHash), 42, nil
+	case "dolores":
+		return forkid.NewStaticFilter(params.DoloresChainConfig, params.DoloresGenesisHash), 43, nil
 	default:
-		return nil, fmt.Errorf("unknown network %q", args[0])
+		return nil, 0, fmt.Errorf("unknown network %q", network)
 	}
+}
 
+func ctxcFilter(args []string) (nodeFilter, error) {
+	filter, network, err := networkFilter(args[0])
+	if err != nil {
+		return nil, err
+	}
 	f := func(n nodeJSON) bool {
-		var eth struct {
-			ForkID forkid.ID
-			_      []rlp.RawValue `rlp:"tail"`
-		}
-		if n.N.Load(enr.WithEntry("eth", &eth)) != nil {
+		id, networkID, ok := loadCtxcEntry(n)
+		if !ok || (networkID != 0 && networkID != network) {
 			return false
 		}
-		return filter(eth.ForkID) == nil
+		return filter(id) == nil
+	}
+	return f, nil
+}
+
+func ctxcForkIDFilter(args []string) (nodeFilter, error) {
+	hash, err := hex.DecodeString(strings.TrimPrefix(args[0], "0x"))
+	if err != nil || len(hash) != 4 {
+		return nil, fmt.Errorf("invalid fork hash %q", args[0])
+	}
+	f := func(n nodeJSON) bool {
+		id, _, ok := loadCtxcEntry(n)
+		return ok && bytes.Equal(id.Hash[:], hash)
 	}
 	return f, nil
 }
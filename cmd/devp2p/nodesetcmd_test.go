@@ -0,0 +1,77 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of CortexTheseus.
+//
+// CortexTheseus is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// CortexTheseus is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with CortexTheseus. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/CortexFoundation/CortexTheseus/core/forkid"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/p2p/enode"
+	"github.com/CortexFoundation/CortexTheseus/p2p/enr"
+	"github.com/CortexFoundation/CortexTheseus/params"
+)
+
+// Tests that the network filter checks both the fork ID and the network ID
+// advertised in the "ctxc" ENR entry, accepting entries of older nodes without
+// a network ID.
+func TestCtxcNetworkFilter(t *testing.T) {
+	mainnet := forkid.NewID(params.MainnetChainConfig, params.MainnetGenesisHash, 0)
+	dolores := forkid.NewID(params.DoloresChainConfig, params.DoloresGenesisHash, 0)
+
+	makeNode := func(entry interface{}) nodeJSON {
+		key, _ := crypto.GenerateKey()
+		var r enr.Record
+		if entry != nil {
+			r.Set(enr.WithEntry("ctxc", entry))
+		}
+		if err := enode.SignV4(&r, key); err != nil {
+			t.Fatal(err)
+		}
+		n, err := enode.New(enode.ValidSchemes, &r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return nodeJSON{N: n}
+	}
+	type legacyEntry struct {
+		ForkID forkid.ID
+	}
+	type entry struct {
+		ForkID    forkid.ID
+		NetworkID uint64
+	}
+	tests := []struct {
+		node nodeJSON
+		want bool
+	}{
+		{makeNode(nil), false},
+		{makeNode(legacyEntry{mainnet}), true},
+		{makeNode(entry{mainnet, 21}), true},
+		{makeNode(entry{mainnet, 43}), false},
+		{makeNode(entry{dolores, 21}), false},
+	}
+	filter, err := ctxcFilter([]string{"mainnet"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tt := range tests {
+		if have := filter(tt.node); have != tt.want {
+			t.Errorf("test %d: filter mismatch: have %v, want %v", i, have, tt.want)
+		}
+	}
+}
@@ -19,9 +19,13 @@ package main
 import (
 	"fmt"
 	"net"
+	"os"
 
+	"github.com/CortexFoundation/CortexTheseus/cmd/devp2p/internal/ctxctest"
 	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
 	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/internal/utesting"
+	"github.com/CortexFoundation/CortexTheseus/log"
 	"github.com/CortexFoundation/CortexTheseus/p2p"
 	"github.com/CortexFoundation/CortexTheseus/p2p/rlpx"
 	"github.com/CortexFoundation/CortexTheseus/rlp"
@@ -34,6 +38,7 @@ var (
 		Usage: "RLPx Commands",
 		Subcommands: []cli.Command{
 			rlpxPingCommand,
+			rlpxCtxcTestCommand,
 		},
 	}
 	rlpxPingCommand = cli.Command{
@@ -42,6 +47,13 @@ var (
 		ArgsUsage: "<node>",
 		Action:    rlpxPing,
 	}
+	rlpxCtxcTestCommand = cli.Command{
+		Name:      "ctxc-test",
+		Usage:     "Runs ctxc protocol tests against a node",
+		ArgsUsage: "<node> <chain.rlp> <genesis.json>",
+		Action:    rlpxCtxcTest,
+		Flags:     []cli.Flag{testPatternFlag},
+	}
 )
 
 func rlpxPing(ctx *cli.Context) error {
@@ -82,6 +94,36 @@ func rlpxPing(ctx *cli.Context) error {
 	return nil
 }
 
+func rlpxCtxcTest(ctx *cli.Context) error {
+	if ctx.NArg() < 3 {
+		exit("need node, chain.rlp and genesis.json as command-line arguments")
+	}
+	// Disable logging unless explicitly enabled.
+	if !ctx.GlobalIsSet("verbosity") && !ctx.GlobalIsSet("vmodule") {
+		log.Root().SetHandler(log.DiscardHandler())
+	}
+
+	// Filter and run test cases.
+	n, err := parseNode(ctx.Args()[0])
+	if err != nil {
+		exit(err)
+	}
+	suite, err := ctxctest.NewSuite(n, ctx.Args()[1], ctx.Args()[2])
+	if err != nil {
+		exit(err)
+	}
+	tests := suite.AllTests()
+	if ctx.IsSet(testPatternFlag.Name) {
+		tests = utesting.MatchTests(tests, ctx.String(testPatternFlag.Name))
+	}
+	results := utesting.RunTests(tests, os.Stdout)
+	if fails := utesting.CountFailures(results); fails > 0 {
+		return fmt.Errorf("%v/%v tests passed.", len(tests)-fails, len(tests))
+	}
+	fmt.Printf("%v/%v passed\n", len(tests), len(tests))
+	return nil
+}
+
 // devp2pHandshake is the RLP structure of the devp2p protocol handshake.
 type devp2pHandshake struct {
 	Version    uint64
@@ -26,6 +26,7 @@ import (
 	"os"
 	"strings"
 
+	"github.com/CortexFoundation/CortexTheseus/ctxcdb/remotedb"
 	"github.com/CortexFoundation/CortexTheseus/rlp"
 )
 
@@ -33,15 +34,20 @@ var (
 	hexMode = flag.String("hex", "", "dump given hex data")
 	noASCII = flag.Bool("noascii", false, "don't print ASCII strings readably")
 	single  = flag.Bool("single", false, "print only the first element, discard the rest")
+
+	remoteDB = flag.String("remotedb", "", "URL of a node exposing the debug_db* RPC methods")
+	dbKey    = flag.String("key", "", "hex encoded database key to dump from the remote node")
 )
 
 func init() {
 	flag.Usage = func() {
-		fmt.Fprintln(os.Stderr, "Usage:", os.Args[0], "[-noascii] [-hex <data>] [filename]")
+		fmt.Fprintln(os.Stderr, "Usage:", os.Args[0], "[-noascii] [-hex <data>] [-remotedb <url> -key <key>] [filename]")
 		flag.PrintDefaults()
 		fmt.Fprintln(os.Stderr, `
 Dumps RLP data from the given file in readable form.
-If the filename is omitted, data is read from stdin.`)
+If the filename is omitted, data is read from stdin.
+With -remotedb, the value stored under -key in the database of a running
+node is dumped instead.`)
 	}
 }
 
@@ -57,6 +63,23 @@ func main() {
 		}
 		r = bytes.NewReader(data)
 
+	case *remoteDB != "":
+		key, err := hex.DecodeString(strings.TrimPrefix(*dbKey, "0x"))
+		if err != nil {
+			die(err)
+		}
+		db, err := remotedb.Dial(*remoteDB)
+		if err != nil {
+			die(err)
+		}
+		defer db.Close()
+
+		data, err := db.Get(key)
+		if err != nil {
+			die(err)
+		}
+		r = bytes.NewReader(data)
+
 	case flag.NArg() == 0:
 		r = os.Stdin
 
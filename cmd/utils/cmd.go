@@ -19,11 +19,18 @@ package utils
 
 import (
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -36,14 +43,21 @@ import (
 	"github.com/CortexFoundation/CortexTheseus/ctxc"
 	"github.com/CortexFoundation/CortexTheseus/ctxcdb"
 	"github.com/CortexFoundation/CortexTheseus/internal/debug"
+	"github.com/CortexFoundation/CortexTheseus/internal/era"
 	"github.com/CortexFoundation/CortexTheseus/log"
 	"github.com/CortexFoundation/CortexTheseus/node"
+	"github.com/CortexFoundation/CortexTheseus/params"
 	"github.com/CortexFoundation/CortexTheseus/rlp"
+	torrentfs "github.com/CortexFoundation/torrentfs/types"
 	"gopkg.in/urfave/cli.v1"
 )
 
 const (
 	importBatchSize = 2500
+
+	// historyCheckFrequency is the frequency of the seal verifications of the
+	// headers imported from era archives.
+	historyCheckFrequency = 100
 )
 
 // Fatalf formats a message to standard error and exits the program.
@@ -115,7 +129,73 @@ func monitorFreeDiskSpace(sigc chan os.Signal, path string, freeDiskSpaceCritica
 	}
 }
 
-func ImportChain(chain *core.BlockChain, fn string) error {
+// importJournal is the progress of a chain import, persisted after every batch
+// so an interrupted import can be resumed without decoding and checking all the
+// already imported blocks again.
+type importJournal struct {
+	File    string      `json:"file"`    // Absolute path of the file being imported
+	Size    int64       `json:"size"`    // Size of the file when the import started
+	ModTime int64       `json:"modtime"` // Modification time of the file in unix nanoseconds
+	Items   uint64      `json:"items"`   // Number of RLP blocks processed from the file
+	Number  uint64      `json:"number"`  // Number of the last processed block
+	Hash    common.Hash `json:"hash"`    // Hash of the last processed block
+}
+
+// ImportJournalName returns the name of the import progress journal of the given
+// file. Every file gets its own journal, keyed by the hash of its absolute path,
+// so importing several files doesn't mix up their progress.
+func ImportJournalName(fn string) (string, error) {
+	path, err := filepath.Abs(fn)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256([]byte(path))
+	return fmt.Sprintf("import-progress-%x.json", hash[:8]), nil
+}
+
+// loadImportJournal loads the progress of a previous import of the given file,
+// returning nil if there's none, if the file was modified since or if it's not
+// consistent with the chain.
+func loadImportJournal(chain *core.BlockChain, journal string, fn string, info os.FileInfo) *importJournal {
+	blob, err := ioutil.ReadFile(journal)
+	if err != nil {
+		return nil
+	}
+	progress := new(importJournal)
+	if err := json.Unmarshal(blob, progress); err != nil {
+		log.Warn("Failed to decode import progress", "journal", journal, "err", err)
+		return nil
+	}
+	if progress.File != fn {
+		return nil
+	}
+	if progress.Size != info.Size() || progress.ModTime != info.ModTime().UnixNano() {
+		log.Warn("Discarding import progress of modified file", "file", fn)
+		return nil
+	}
+	if !chain.HasBlock(progress.Hash, progress.Number) {
+		log.Warn("Discarding stale import progress", "number", progress.Number, "hash", progress.Hash)
+		return nil
+	}
+	return progress
+}
+
+// storeImportJournal persists the progress of an import.
+func storeImportJournal(journal string, progress *importJournal) error {
+	blob, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(journal+".tmp", blob, 0644); err != nil {
+		return err
+	}
+	return os.Rename(journal+".tmp", journal)
+}
+
+// ImportChain imports the RLP encoded blocks of the given file into the chain,
+// which is optionally gzipped. If a journal is given, the import progress is
+// persisted in it, allowing an interrupted import to resume where it stopped.
+func ImportChain(chain *core.BlockChain, fn string, journal string) error {
 	// Watch for Ctrl-C while the import is running.
 	// If a signal is received, the import will stop at the next batch.
 	interrupt := make(chan os.Signal, 1)
@@ -147,6 +227,10 @@ func ImportChain(chain *core.BlockChain, fn string) error {
 	}
 	defer fh.Close()
 
+	info, err := fh.Stat()
+	if err != nil {
+		return err
+	}
 	var reader io.Reader = fh
 	if strings.HasSuffix(fn, ".gz") {
 		if reader, err = gzip.NewReader(reader); err != nil {
@@ -155,6 +239,24 @@ func ImportChain(chain *core.BlockChain, fn string) error {
 	}
 	stream := rlp.NewStream(reader, 0)
 
+	// Skip over the blocks already imported by a previous run
+	path, err := filepath.Abs(fn)
+	if err != nil {
+		return err
+	}
+	progress := &importJournal{File: path, Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+	if journal != "" {
+		if resumed := loadImportJournal(chain, journal, path, info); resumed != nil {
+			log.Info("Resuming chain import", "items", resumed.Items, "number", resumed.Number, "hash", resumed.Hash)
+			for progress.Items < resumed.Items {
+				if _, err := stream.Raw(); err != nil {
+					return fmt.Errorf("failed to skip block %d: %v", progress.Items, err)
+				}
+				progress.Items++
+			}
+			progress.Number, progress.Hash = resumed.Number, resumed.Hash
+		}
+	}
 	// Run actual the import.
 	blocks := make(types.Blocks, importBatchSize)
 	n := 0
@@ -171,6 +273,8 @@ func ImportChain(chain *core.BlockChain, fn string) error {
 			} else if err != nil {
 				return fmt.Errorf("at block %d: %v", n, err)
 			}
+			progress.Items++
+
 			// don't import first block
 			if b.NumberU64() == 0 {
 				i--
@@ -189,11 +293,19 @@ func ImportChain(chain *core.BlockChain, fn string) error {
 		missing := missingBlocks(chain, blocks[:i])
 		if len(missing) == 0 {
 			log.Info("Skipping batch as all blocks present", "batch", batch, "first", blocks[0].Hash(), "last", blocks[i-1].Hash())
-			continue
-		}
-		if _, err := chain.InsertChain(missing); err != nil {
+		} else if _, err := chain.InsertChain(missing); err != nil {
 			return fmt.Errorf("invalid block %d: %v", n, err)
 		}
+		progress.Number, progress.Hash = blocks[i-1].NumberU64(), blocks[i-1].Hash()
+		if journal != "" {
+			if err := storeImportJournal(journal, progress); err != nil {
+				log.Warn("Failed to store import progress", "journal", journal, "err", err)
+			}
+		}
+	}
+	// The whole file has been imported, there's nothing to resume any more
+	if journal != "" {
+		os.Remove(journal)
 	}
 	return nil
 }
@@ -267,6 +379,454 @@ func ExportAppendChain(blockchain *core.BlockChain, fn string, first uint64, las
 	return nil
 }
 
+// ChainManifest lists the model and input files uploaded within an exported
+// chain segment. The files have to be available for the inferences of the
+// segment to be replayed when it's imported, so they can be fetched up front.
+type ChainManifest struct {
+	First uint64              `json:"first"`
+	Last  uint64              `json:"last"`
+	Files []ChainManifestFile `json:"files"`
+}
+
+// ChainManifestFile is a single model or input file of a chain manifest.
+type ChainManifestFile struct {
+	Kind    string      `json:"kind"` // Either "model" or "input"
+	Hash    string      `json:"infohash"`
+	RawSize uint64      `json:"rawSize"`
+	Number  uint64      `json:"number"` // Number of the block uploading the file
+	TxHash  common.Hash `json:"txHash"`
+}
+
+// ManifestPath returns the path of the sidecar manifest of an exported chain file.
+func ManifestPath(fn string) string {
+	return fn + ".manifest.json"
+}
+
+// ExportManifest writes the sidecar manifest of the model and input files
+// uploaded by the blocks first to last (inclusive) of the chain. If the blocks
+// were appended to the exported file, they are appended to its manifest too.
+func ExportManifest(blockchain *core.BlockChain, fn string, first uint64, last uint64, appended bool) error {
+	manifest := &ChainManifest{First: first, Last: last, Files: []ChainManifestFile{}}
+	if appended {
+		prev, err := LoadManifest(fn)
+		if err != nil {
+			return err
+		}
+		if prev != nil {
+			manifest.Files = prev.Files
+			if prev.First < first {
+				manifest.First = prev.First
+			}
+			if prev.Last > last {
+				manifest.Last = prev.Last
+			}
+		}
+	}
+	for nr := first; nr <= last; nr++ {
+		block := blockchain.GetBlockByNumber(nr)
+		if block == nil {
+			return fmt.Errorf("export failed on #%d: not found", nr)
+		}
+		for _, tx := range block.Transactions() {
+			if tx.To() != nil {
+				continue
+			}
+			entry := ChainManifestFile{Number: nr, TxHash: tx.Hash()}
+			if meta, err := torrentfs.ParseModelMeta(tx.Data()); err == nil {
+				entry.Kind, entry.Hash, entry.RawSize = "model", meta.Hash.Hex(), meta.RawSize
+			} else if meta, err := torrentfs.ParseInputMeta(tx.Data()); err == nil {
+				entry.Kind, entry.Hash, entry.RawSize = "input", meta.Hash.Hex(), meta.RawSize
+			} else {
+				continue
+			}
+			manifest.Files = append(manifest.Files, entry)
+		}
+	}
+	blob, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(ManifestPath(fn), blob, 0644); err != nil {
+		return err
+	}
+	log.Info("Exported chain manifest", "file", ManifestPath(fn), "files", len(manifest.Files))
+	return nil
+}
+
+// LoadManifest loads the sidecar manifest of an exported chain file, returning
+// nil if there's none.
+func LoadManifest(fn string) (*ChainManifest, error) {
+	blob, err := ioutil.ReadFile(ManifestPath(fn))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	manifest := new(ChainManifest)
+	if err := json.Unmarshal(blob, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// HistoryNetwork returns the network name used in the era archive file names of
+// the chain with the given genesis.
+func HistoryNetwork(genesis common.Hash) string {
+	switch genesis {
+	case params.MainnetGenesisHash:
+		return "mainnet"
+	case params.BernardGenesisHash:
+		return "bernard"
+	case params.DoloresGenesisHash:
+		return "dolores"
+	default:
+		return "custom"
+	}
+}
+
+// ExportHistory exports the canonical blocks first to last (inclusive) of the
+// chain into era archives in the given directory, one archive per epoch. The
+// blocks are exported in the raw form stored by the database, without being
+// decoded. Archives of the same epochs already in the directory are replaced,
+// and the exported epochs have to be adjacent to the other ones. The checksums
+// of all the archives are written into checksums.txt.
+func ExportHistory(blockchain *core.BlockChain, db ctxcdb.Database, dir string, first, last uint64) error {
+	log.Info("Exporting blockchain history", "dir", dir)
+	if head := blockchain.CurrentFastBlock().NumberU64(); head < last {
+		log.Warn("Last block beyond the chain head, truncating", "head", head, "last", last)
+		last = head
+	}
+	if first%era.MaxEra1Size != 0 {
+		return fmt.Errorf("first block %d is not at the start of an epoch (multiple of %d)", first, era.MaxEra1Size)
+	}
+	if first > last {
+		return fmt.Errorf("invalid range: first (%d) is greater than last (%d)", first, last)
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("error creating output directory: %w", err)
+	}
+	network := HistoryNetwork(blockchain.Genesis().Hash())
+
+	// Collect the checksums of the archives already exported, they are merged
+	// with the new ones
+	entries, err := era.ReadDir(dir, network)
+	if err != nil {
+		return err
+	}
+	checksums, err := readHistoryChecksums(dir, entries)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]string)
+	for i, name := range entries {
+		if checksums != nil {
+			known[name] = checksums[i]
+		}
+	}
+	if len(entries) > 0 {
+		low, high := historyEpoch(entries[0]), historyEpoch(entries[len(entries)-1])
+		if from, to := int(first/era.MaxEra1Size), int(last/era.MaxEra1Size); from > high+1 || to+1 < low {
+			return fmt.Errorf("exported epochs %d-%d not adjacent to archived epochs %d-%d", from, to, low, high)
+		}
+	}
+	var (
+		start    = time.Now()
+		reported = time.Now()
+		exported = 0
+	)
+	for epochStart := first; epochStart <= last; epochStart += era.MaxEra1Size {
+		epoch := int(epochStart / era.MaxEra1Size)
+		err := func() error {
+			f, err := ioutil.TempFile(dir, "export-*.era1")
+			if err != nil {
+				return err
+			}
+			defer os.Remove(f.Name())
+			defer f.Close()
+
+			builder := era.NewBuilder(f)
+			for nr := epochStart; nr < epochStart+era.MaxEra1Size && nr <= last; nr++ {
+				hash := rawdb.ReadCanonicalHash(db, nr)
+				if hash == (common.Hash{}) {
+					return fmt.Errorf("export failed on #%d: canonical hash not found", nr)
+				}
+				var (
+					header   = rawdb.ReadHeaderRLP(db, hash, nr)
+					body     = rawdb.ReadBodyRLP(db, hash, nr)
+					receipts = rawdb.ReadReceiptsRLP(db, hash, nr)
+					td       = rawdb.ReadTd(db, hash, nr)
+				)
+				if len(header) == 0 || len(body) == 0 || len(receipts) == 0 || td == nil {
+					return fmt.Errorf("export failed on #%d: block data not found", nr)
+				}
+				if err := builder.AddRLP(header, body, receipts, nr, hash, td); err != nil {
+					return err
+				}
+				if time.Since(reported) >= 8*time.Second {
+					log.Info("Exporting blocks", "exported", nr-first, "elapsed", common.PrettyDuration(time.Since(start)))
+					reported = time.Now()
+				}
+			}
+			root, err := builder.Finalize()
+			if err != nil {
+				return fmt.Errorf("export failed to finalize epoch %d: %w", epoch, err)
+			}
+			if err := f.Sync(); err != nil {
+				return err
+			}
+			checksum, err := historyChecksum(f.Name())
+			if err != nil {
+				return err
+			}
+			// Drop the previous archive of the epoch, it's replaced
+			for _, name := range entries {
+				if historyEpoch(name) == epoch {
+					if err := os.Remove(filepath.Join(dir, name)); err != nil {
+						return err
+					}
+					delete(known, name)
+				}
+			}
+			name := era.Filename(network, epoch, root)
+			if err := os.Rename(f.Name(), filepath.Join(dir, name)); err != nil {
+				return err
+			}
+			known[name] = checksum
+			return nil
+		}()
+		if err != nil {
+			return err
+		}
+		exported++
+	}
+	// Write the checksums of all the archives, computing the ones missing from
+	// an earlier checksums.txt
+	if entries, err = era.ReadDir(dir, network); err != nil {
+		return err
+	}
+	checksums = make([]string, len(entries))
+	for i, name := range entries {
+		if checksum, ok := known[name]; ok {
+			checksums[i] = checksum
+			continue
+		}
+		log.Warn("Archive without checksum, computing it", "file", name)
+		if checksums[i], err = historyChecksum(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "checksums.txt"), []byte(strings.Join(checksums, "\n")), os.ModePerm); err != nil {
+		return err
+	}
+	log.Info("Exported blockchain history", "dir", dir, "epochs", exported, "archived", len(entries), "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// historyEpoch returns the epoch of an era archive listed by era.ReadDir.
+func historyEpoch(name string) int {
+	epoch, _ := strconv.Atoi(strings.Split(name, "-")[1])
+	return epoch
+}
+
+// historyChecksum returns the sha256 checksum of an era archive.
+func historyChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return common.BytesToHash(h.Sum(nil)).Hex(), nil
+}
+
+// readHistoryChecksums reads the checksums of the era archives in the given
+// directory, returning nil if there are none.
+func readHistoryChecksums(dir string, entries []string) ([]string, error) {
+	blob, err := ioutil.ReadFile(filepath.Join(dir, "checksums.txt"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	checksums := strings.Split(strings.TrimSpace(string(blob)), "\n")
+	if len(checksums) != len(entries) {
+		return nil, fmt.Errorf("checksum count mismatch: have %d, want %d", len(checksums), len(entries))
+	}
+	return checksums, nil
+}
+
+// openVerifiedEra opens an era archive, verifying it against the checksum (if
+// given) and the accumulator root. The archives of the epochs having a trusted
+// accumulator root must be complete and match it.
+func openVerifiedEra(path string, checksum string, trusted []common.Hash) (*era.Era, error) {
+	if checksum != "" {
+		have, err := historyChecksum(path)
+		if err != nil {
+			return nil, err
+		}
+		if have != checksum {
+			return nil, fmt.Errorf("checksum mismatch: have %s, want %s", have, checksum)
+		}
+	}
+	e, err := era.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	root, err := e.Verify()
+	if err != nil {
+		e.Close()
+		return nil, err
+	}
+	if epoch := e.Start() / era.MaxEra1Size; epoch < uint64(len(trusted)) {
+		if e.Count() != era.MaxEra1Size || root != trusted[epoch] {
+			e.Close()
+			return nil, fmt.Errorf("untrusted accumulator of epoch %d: have %x (%d blocks), want %x", epoch, root, e.Count(), trusted[epoch])
+		}
+	}
+	log.Info("Verified era archive", "file", filepath.Base(path), "blocks", e.Count(), "accumulator", root)
+	return e, nil
+}
+
+// VerifyHistory verifies all the era archives of the network with the given
+// genesis in a directory, without importing them.
+func VerifyHistory(dir string, genesis common.Hash) error {
+	var (
+		network = HistoryNetwork(genesis)
+		trusted = params.HistoryAccumulators[genesis]
+	)
+	entries, err := era.ReadDir(dir, network)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", dir, err)
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("no %s era archives found in %s", network, dir)
+	}
+	checksums, err := readHistoryChecksums(dir, entries)
+	if err != nil {
+		return err
+	}
+	for i, filename := range entries {
+		var checksum string
+		if checksums != nil {
+			checksum = checksums[i]
+		}
+		e, err := openVerifiedEra(filepath.Join(dir, filename), checksum, trusted)
+		if err != nil {
+			return fmt.Errorf("error verifying %s: %w", filename, err)
+		}
+		e.Close()
+	}
+	return nil
+}
+
+// ImportHistory imports the era archives in the given directory into the ancient
+// store of the chain, without executing the blocks. Every archive is verified
+// against its checksum (if a checksums.txt is present) and accumulator root, and
+// its blocks against their headers, before being imported. The archives have to
+// continue the history already present in the chain.
+func ImportHistory(chain *core.BlockChain, dir string) error {
+	var (
+		network = HistoryNetwork(chain.Genesis().Hash())
+		trusted = params.HistoryAccumulators[chain.Genesis().Hash()]
+	)
+	entries, err := era.ReadDir(dir, network)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", dir, err)
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("no %s era archives found in %s", network, dir)
+	}
+	checksums, err := readHistoryChecksums(dir, entries)
+	if err != nil {
+		return err
+	}
+	var (
+		start    = time.Now()
+		reported = time.Now()
+		imported = 0
+	)
+	for i, filename := range entries {
+		err := func() error {
+			var checksum string
+			if checksums != nil {
+				checksum = checksums[i]
+			}
+			e, err := openVerifiedEra(filepath.Join(dir, filename), checksum, trusted)
+			if err != nil {
+				return err
+			}
+			defer e.Close()
+
+			if head := chain.CurrentFastBlock().NumberU64(); e.Start() > head+1 {
+				return fmt.Errorf("history gap: archive starts at block %d, chain head is %d", e.Start(), head)
+			}
+
+			for nr := e.Start(); nr < e.Start()+e.Count(); nr += importBatchSize {
+				var (
+					headers  []*types.Header
+					blocks   types.Blocks
+					receipts []types.Receipts
+					td       *big.Int
+				)
+				for n := nr; n < nr+importBatchSize && n < e.Start()+e.Count(); n++ {
+					block, err := e.GetBlockByNumber(n)
+					if err != nil {
+						return fmt.Errorf("error reading block %d: %w", n, err)
+					}
+					if n == 0 {
+						if block.Hash() != chain.Genesis().Hash() {
+							return fmt.Errorf("genesis mismatch: have %x, want %x", block.Hash(), chain.Genesis().Hash())
+						}
+						continue
+					}
+					// Skip the blocks already imported by a previous run
+					if n <= chain.CurrentFastBlock().NumberU64() && chain.HasBlock(block.Hash(), n) {
+						continue
+					}
+					rs, err := e.GetReceiptsByNumber(n)
+					if err != nil {
+						return fmt.Errorf("error reading receipts %d: %w", n, err)
+					}
+					if td, err = e.GetTotalDifficulty(n); err != nil {
+						return fmt.Errorf("error reading total difficulty %d: %w", n, err)
+					}
+					headers, blocks, receipts = append(headers, block.Header()), append(blocks, block), append(receipts, rs)
+				}
+				if len(blocks) == 0 {
+					continue
+				}
+				if _, err := chain.InsertHeaderChain(headers, historyCheckFrequency); err != nil {
+					return fmt.Errorf("error inserting headers: %w", err)
+				}
+				last := blocks[len(blocks)-1]
+				if have := chain.GetTd(last.Hash(), last.NumberU64()); have == nil || have.Cmp(td) != 0 {
+					return fmt.Errorf("total difficulty mismatch at block %d: have %v, want %v", last.NumberU64(), have, td)
+				}
+				if _, err := chain.InsertReceiptChain(blocks, receipts, math.MaxUint64); err != nil {
+					return fmt.Errorf("error inserting blocks: %w", err)
+				}
+				imported += len(blocks)
+				if time.Since(reported) >= 8*time.Second {
+					log.Info("Importing era archives", "file", filename, "imported", imported, "elapsed", common.PrettyDuration(time.Since(start)))
+					reported = time.Now()
+				}
+			}
+			return nil
+		}()
+		if err != nil {
+			return fmt.Errorf("error importing %s: %w", filename, err)
+		}
+	}
+	log.Info("Imported blockchain history", "dir", dir, "blocks", imported, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
 // ImportPreimages imports a batch of exported hash preimages into the database.
 func ImportPreimages(db ctxcdb.Database, fn string) error {
 	log.Info("Importing preimages", "file", fn)
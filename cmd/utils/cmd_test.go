@@ -0,0 +1,444 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of CortexFoundation.
+//
+// CortexFoundation is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// CortexFoundation is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with CortexFoundation. If not, see <http://www.gnu.org/licenses/>.
+
+package utils
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/consensus/cuckoo"
+	"github.com/CortexFoundation/CortexTheseus/core"
+	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/core/vm"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/ctxcdb"
+	"github.com/CortexFoundation/CortexTheseus/internal/era"
+	"github.com/CortexFoundation/CortexTheseus/params"
+	"github.com/CortexFoundation/inference/synapse"
+	"github.com/CortexFoundation/torrentfs"
+	torrenttypes "github.com/CortexFoundation/torrentfs/types"
+)
+
+// newTestChain creates a blockchain with the genesis committed and the given
+// blocks inserted.
+func newTestChain(t *testing.T, gspec *core.Genesis, blocks []*types.Block) *core.BlockChain {
+	return newTestChainWithDB(t, rawdb.NewMemoryDatabase(), gspec, blocks)
+}
+
+// newTestChainWithDB creates a blockchain on top of the given database, with the
+// genesis committed and the given blocks inserted.
+func newTestChainWithDB(t *testing.T, db ctxcdb.Database, gspec *core.Genesis, blocks []*types.Block) *core.BlockChain {
+	gspec.MustCommit(db)
+
+	chain, err := core.NewBlockChain(db, nil, gspec.Config, cuckoo.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatal(err)
+	}
+	return chain
+}
+
+// Tests that a chain export can be imported, resuming from the progress journal
+// of an interrupted import.
+func TestImportChainResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		gspec   = &core.Genesis{Config: params.TestChainConfig, Supply: params.CTXC_INIT}
+		genesis = gspec.MustCommit(rawdb.NewMemoryDatabase())
+	)
+	blocks, _ := core.GenerateChain(gspec.Config, genesis, cuckoo.NewFaker(), rawdb.NewMemoryDatabase(), 10, func(int, *core.BlockGen) {})
+
+	source := newTestChain(t, gspec, blocks)
+	defer source.Stop()
+
+	fn := filepath.Join(dir, "chain.rlp.gz")
+	if err := ExportChain(source, fn); err != nil {
+		t.Fatalf("failed to export chain: %v", err)
+	}
+	if err := ExportManifest(source, fn, 0, 10, false); err != nil {
+		t.Fatalf("failed to export manifest: %v", err)
+	}
+	manifest, err := LoadManifest(fn)
+	if err != nil {
+		t.Fatalf("failed to load manifest: %v", err)
+	}
+	if manifest.First != 0 || manifest.Last != 10 || len(manifest.Files) != 0 {
+		t.Fatalf("manifest mismatch: have %+v", manifest)
+	}
+	// Import into a chain having the first half of the blocks, with a journal
+	// claiming that the whole file was already processed. Nothing should be
+	// imported as all the items are skipped.
+	path, _ := filepath.Abs(fn)
+	info, err := os.Stat(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := ImportJournalName(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal := filepath.Join(dir, name)
+
+	chain := newTestChain(t, gspec, blocks[:5])
+	defer chain.Stop()
+
+	if err := storeImportJournal(journal, &importJournal{File: path, Size: info.Size(), ModTime: info.ModTime().UnixNano(), Items: 11, Number: 5, Hash: blocks[4].Hash()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ImportChain(chain, fn, journal); err != nil {
+		t.Fatalf("failed to import chain: %v", err)
+	}
+	if head := chain.CurrentBlock().NumberU64(); head != 5 {
+		t.Fatalf("head mismatch after skipping everything: have %d, want %d", head, 5)
+	}
+	if _, err := os.Stat(journal); !os.IsNotExist(err) {
+		t.Fatalf("journal not removed after import: %v", err)
+	}
+	// Resume an import from the middle of the file
+	if err := storeImportJournal(journal, &importJournal{File: path, Size: info.Size(), ModTime: info.ModTime().UnixNano(), Items: 6, Number: 5, Hash: blocks[4].Hash()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ImportChain(chain, fn, journal); err != nil {
+		t.Fatalf("failed to resume import: %v", err)
+	}
+	if head := chain.CurrentBlock().Hash(); head != blocks[9].Hash() {
+		t.Fatalf("head mismatch after resuming: have %x, want %x", head, blocks[9].Hash())
+	}
+	// A journal of a block unknown to the chain must be discarded
+	if err := storeImportJournal(journal, &importJournal{File: path, Size: info.Size(), ModTime: info.ModTime().UnixNano(), Items: 6, Number: 5, Hash: blocks[4].Hash()}); err != nil {
+		t.Fatal(err)
+	}
+	empty := newTestChain(t, gspec, nil)
+	defer empty.Stop()
+
+	if progress := loadImportJournal(empty, journal, path, info); progress != nil {
+		t.Fatalf("stale journal loaded: %+v", progress)
+	}
+	if progress := loadImportJournal(chain, journal, path, info); progress == nil {
+		t.Fatalf("valid journal discarded")
+	}
+	// A journal of a file rewritten since must be discarded too
+	if err := storeImportJournal(journal, &importJournal{File: path, Size: info.Size() + 1, ModTime: info.ModTime().UnixNano(), Items: 6, Number: 5, Hash: blocks[4].Hash()}); err != nil {
+		t.Fatal(err)
+	}
+	if progress := loadImportJournal(chain, journal, path, info); progress != nil {
+		t.Fatalf("journal of modified file loaded: %+v", progress)
+	}
+	// Different files must not share a journal
+	if other, _ := ImportJournalName(filepath.Join(dir, "other.rlp.gz")); other == name {
+		t.Fatalf("journal name collision: %s", name)
+	}
+}
+
+// testStorage is a storage layer without any files, recording the downloads
+// requested by the inference engine.
+type testStorage struct {
+	requests map[string]uint64
+	lock     sync.Mutex
+}
+
+func newTestStorage() *testStorage {
+	return &testStorage{requests: make(map[string]uint64)}
+}
+
+func (s *testStorage) GetFileWithSize(ctx context.Context, ih string, rawSize uint64, path string) ([]byte, error) {
+	return nil, errors.New("not available")
+}
+
+func (s *testStorage) Download(ctx context.Context, ih string, request uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.requests[ih] = request
+	return nil
+}
+
+func (s *testStorage) Stop() error { return nil }
+
+// switchStorage forwards to a storage layer which can be replaced between the
+// steps of a test, as the inference engine can only be set up once.
+type switchStorage struct {
+	torrentfs.CortexStorage
+}
+
+// Tests that the model and input files uploaded by an exported chain are listed
+// in its manifest, and are fetched up front or not at all during the import.
+func TestImportManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storage := &switchStorage{newTestStorage()}
+	engine := synapse.New(&synapse.Config{Storagefs: storage})
+
+	var (
+		key, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr   = crypto.PubkeyToAddress(key.PublicKey)
+		gspec  = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  core.GenesisAlloc{addr: {Balance: big.NewInt(1000000000000000000)}},
+			Supply: params.CTXC_INIT,
+		}
+		gendb   = rawdb.NewMemoryDatabase()
+		genesis = gspec.MustCommit(gendb)
+		signer  = types.NewEIP155Signer(params.TestChainConfig.ChainID)
+
+		model = &torrenttypes.ModelMeta{
+			Hash:          common.HexToAddress("0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"),
+			RawSize:       1024,
+			InputShape:    []uint64{1, 28, 28},
+			OutputShape:   []uint64{10},
+			AuthorAddress: addr,
+		}
+		input = &torrenttypes.InputMeta{
+			Hash:    common.HexToAddress("0xcccccccccccccccccccccccccccccccccccccccc"),
+			RawSize: 784,
+			Shape:   []uint64{1, 28, 28},
+		}
+	)
+	modelCode, err := model.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputCode, err := input.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks, _ := core.GenerateChain(gspec.Config, genesis, cuckoo.NewFaker(), gendb, 4, func(i int, gen *core.BlockGen) {
+		var code []byte
+		switch i {
+		case 1:
+			code = append([]byte{0, 1}, modelCode...)
+		case 2:
+			code = append([]byte{0, 2}, inputCode...)
+		default:
+			return
+		}
+		tx, _ := types.SignTx(types.NewContractCreation(gen.TxNonce(addr), new(big.Int), 1000000, big.NewInt(1), code), signer, key)
+		gen.AddTx(tx)
+	})
+	source := newTestChain(t, gspec, blocks)
+	defer source.Stop()
+
+	fn := filepath.Join(dir, "chain.rlp.gz")
+	if err := ExportChain(source, fn); err != nil {
+		t.Fatalf("failed to export chain: %v", err)
+	}
+	if err := ExportManifest(source, fn, 0, 4, false); err != nil {
+		t.Fatalf("failed to export manifest: %v", err)
+	}
+	manifest, err := LoadManifest(fn)
+	if err != nil {
+		t.Fatalf("failed to load manifest: %v", err)
+	}
+	want := []ChainManifestFile{
+		{Kind: "model", Hash: model.Hash.Hex(), RawSize: model.RawSize, Number: 2, TxHash: blocks[1].Transactions()[0].Hash()},
+		{Kind: "input", Hash: input.Hash.Hex(), RawSize: input.RawSize, Number: 3, TxHash: blocks[2].Transactions()[0].Hash()},
+	}
+	if len(manifest.Files) != len(want) {
+		t.Fatalf("manifest file count mismatch: have %d, want %d", len(manifest.Files), len(want))
+	}
+	for i := range want {
+		if manifest.Files[i] != want[i] {
+			t.Errorf("manifest file %d mismatch: have %+v, want %+v", i, manifest.Files[i], want[i])
+		}
+	}
+	// Prefetching must request the whole files listed in the manifest
+	fetcher := newTestStorage()
+	storage.CortexStorage = fetcher
+
+	PrefetchManifest(engine, fn)
+	for _, f := range want {
+		ih := strings.ToLower(strings.TrimPrefix(f.Hash, "0x"))
+		if request, ok := fetcher.requests[ih]; !ok || request != f.RawSize {
+			t.Errorf("%s %s: prefetch mismatch: have %d (requested %v), want %d", f.Kind, ih, request, ok, f.RawSize)
+		}
+	}
+	// Importing without fetching must process the uploads, without requesting
+	// any of the files from the network
+	fetcher = newTestStorage()
+	storage.CortexStorage = NoFetchStorage{fetcher}
+
+	chain := newTestChain(t, gspec, nil)
+	defer chain.Stop()
+
+	if err := ImportChain(chain, fn, filepath.Join(dir, "nofetch.json")); err != nil {
+		t.Fatalf("failed to import chain without fetching: %v", err)
+	}
+	if head := chain.CurrentBlock().Hash(); head != blocks[3].Hash() {
+		t.Fatalf("head mismatch after import: have %x, want %x", head, blocks[3].Hash())
+	}
+	if len(fetcher.requests) != 0 {
+		t.Fatalf("files requested without fetching: %v", fetcher.requests)
+	}
+	// A regular import must schedule the download of the uploaded files
+	fetcher = newTestStorage()
+	storage.CortexStorage = fetcher
+
+	chain = newTestChain(t, gspec, nil)
+	defer chain.Stop()
+
+	if err := ImportChain(chain, fn, filepath.Join(dir, "fetch.json")); err != nil {
+		t.Fatalf("failed to import chain: %v", err)
+	}
+	for _, f := range want {
+		if _, ok := fetcher.requests[strings.ToLower(strings.TrimPrefix(f.Hash, "0x"))]; !ok {
+			t.Errorf("%s %s: not fetched during import", f.Kind, f.Hash)
+		}
+	}
+}
+
+// Tests that the history of a chain can be exported into era archives and
+// imported straight into the ancient store of a fresh node.
+func TestHistoryImportAndExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		key, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr   = crypto.PubkeyToAddress(key.PublicKey)
+		gspec  = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  core.GenesisAlloc{addr: {Balance: big.NewInt(1000000000000000000)}},
+			Supply: params.CTXC_INIT,
+		}
+		gendb   = rawdb.NewMemoryDatabase()
+		genesis = gspec.MustCommit(gendb)
+		signer  = types.NewEIP155Signer(params.TestChainConfig.ChainID)
+	)
+	blocks, _ := core.GenerateChain(gspec.Config, genesis, cuckoo.NewFaker(), gendb, 20, func(i int, gen *core.BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(gen.TxNonce(addr), common.Address{0x01}, big.NewInt(1), params.TxGas, big.NewInt(1), nil), signer, key)
+		gen.AddTx(tx)
+	})
+	db := rawdb.NewMemoryDatabase()
+	source := newTestChainWithDB(t, db, gspec, blocks)
+	defer source.Stop()
+
+	archive := filepath.Join(dir, "era")
+	if err := ExportHistory(source, db, archive, 1, 10); err == nil {
+		t.Fatalf("exported history not starting at an epoch")
+	}
+	// Export a part of the epoch first, the full export must replace it while
+	// keeping the checksums of the other archives in the directory
+	if err := ExportHistory(source, db, archive, 0, 10); err != nil {
+		t.Fatalf("failed to export partial history: %v", err)
+	}
+	other := filepath.Join(archive, "custom-00001-deadbeef.era1")
+	if err := ioutil.WriteFile(other, []byte("other"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	blob, err := ioutil.ReadFile(filepath.Join(archive, "checksums.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSum := common.Hash{0xde, 0xad}.Hex()
+	if err := ioutil.WriteFile(filepath.Join(archive, "checksums.txt"), append(blob, []byte("\n"+otherSum)...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ExportHistory(source, db, archive, 0, 20); err != nil {
+		t.Fatalf("failed to export history: %v", err)
+	}
+	entries, err := era.ReadDir(archive, "custom")
+	if err != nil {
+		t.Fatalf("failed to read archives: %v", err)
+	}
+	checksums, err := readHistoryChecksums(archive, entries)
+	if err != nil {
+		t.Fatalf("failed to read checksums: %v", err)
+	}
+	if len(entries) != 2 || entries[1] != filepath.Base(other) {
+		t.Fatalf("archives mismatch: have %v", entries)
+	}
+	if checksums[1] != otherSum {
+		t.Fatalf("checksum of other archive lost: have %s, want %s", checksums[1], otherSum)
+	}
+	if err := os.Remove(other); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(archive, "checksums.txt"), []byte(checksums[0]), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyHistory(archive, genesis.Hash()); err != nil {
+		t.Fatalf("failed to verify history: %v", err)
+	}
+	// Archives not matching the trusted accumulators must be rejected
+	params.HistoryAccumulators[genesis.Hash()] = []common.Hash{{0x01}}
+	err = VerifyHistory(archive, genesis.Hash())
+	delete(params.HistoryAccumulators, genesis.Hash())
+	if err == nil {
+		t.Fatalf("verified history not matching the trusted accumulators")
+	}
+	// Import the history into a node backed by a freezer
+	ancient := filepath.Join(dir, "ancient")
+	fdb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), ancient, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fdb.Close()
+
+	chain := newTestChainWithDB(t, fdb, gspec, nil)
+	defer chain.Stop()
+
+	if err := ImportHistory(chain, archive); err != nil {
+		t.Fatalf("failed to import history: %v", err)
+	}
+	if frozen, _ := fdb.Ancients(); frozen != 21 {
+		t.Fatalf("ancients mismatch: have %d, want %d", frozen, 21)
+	}
+	if head := chain.CurrentFastBlock().Hash(); head != blocks[19].Hash() {
+		t.Fatalf("fast block head mismatch: have %x, want %x", head, blocks[19].Hash())
+	}
+	for _, block := range blocks {
+		if receipts := chain.GetReceiptsByHash(block.Hash()); len(receipts) != 1 {
+			t.Fatalf("block %d: receipts missing", block.NumberU64())
+		}
+		if td, want := chain.GetTd(block.Hash(), block.NumberU64()), source.GetTd(block.Hash(), block.NumberU64()); td.Cmp(want) != 0 {
+			t.Fatalf("block %d: total difficulty mismatch: have %v, want %v", block.NumberU64(), td, want)
+		}
+	}
+	// Importing again must be a no-op, tampered archives must be rejected
+	if err := ImportHistory(chain, archive); err != nil {
+		t.Fatalf("failed to reimport history: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(archive, "checksums.txt"), []byte(common.Hash{}.Hex()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyHistory(archive, genesis.Hash()); err == nil {
+		t.Fatalf("verified history with mismatching checksum")
+	}
+}
@@ -39,6 +39,7 @@ import (
 	"github.com/CortexFoundation/CortexTheseus/consensus/clique"
 	"github.com/CortexFoundation/CortexTheseus/consensus/cuckoo"
 	"github.com/CortexFoundation/CortexTheseus/core"
+	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
 	whisper "github.com/CortexFoundation/CortexTheseus/whisper/whisperv6"
 	//"github.com/CortexFoundation/CortexTheseus/core/state"
 	"github.com/CortexFoundation/CortexTheseus/core/vm"
@@ -47,7 +48,10 @@ import (
 	"github.com/CortexFoundation/CortexTheseus/ctxc/downloader"
 	"github.com/CortexFoundation/CortexTheseus/ctxc/gasprice"
 	"github.com/CortexFoundation/CortexTheseus/ctxcdb"
+	"github.com/CortexFoundation/CortexTheseus/ctxcdb/remotedb"
+	"github.com/CortexFoundation/CortexTheseus/graphql"
 	// "github.com/CortexFoundation/CortexTheseus/stats"
+	"github.com/CortexFoundation/CortexTheseus/les"
 	"github.com/CortexFoundation/CortexTheseus/log"
 	"github.com/CortexFoundation/CortexTheseus/metrics"
 	"github.com/CortexFoundation/CortexTheseus/metrics/exp"
@@ -58,6 +62,7 @@ import (
 	"github.com/CortexFoundation/CortexTheseus/p2p/nat"
 	"github.com/CortexFoundation/CortexTheseus/p2p/netutil"
 	"github.com/CortexFoundation/CortexTheseus/params"
+	"github.com/CortexFoundation/CortexTheseus/rpc"
 	"github.com/CortexFoundation/inference/synapse"
 	"github.com/CortexFoundation/torrentfs"
 	"gopkg.in/urfave/cli.v1"
@@ -137,6 +142,10 @@ var (
 		Name:  "datadir.ancient",
 		Usage: "Data directory for ancient chain segments (default = inside chaindata)",
 	}
+	DBEngineFlag = cli.StringFlag{
+		Name:  "db.engine",
+		Usage: "Backing database implementation to use ('leveldb' or 'pebble', default = autodetect or leveldb)",
+	}
 	MinFreeDiskSpaceFlag = DirectoryFlag{
 		Name:  "datadir.minfreedisk",
 		Usage: "Minimum free disk space in MB, once reached triggers auto shut down (default = --cache.gc converted to MB, 0 = disabled)",
@@ -182,7 +191,7 @@ var (
 	defaultSyncMode = ctxc.DefaultConfig.SyncMode
 	SyncModeFlag    = TextMarshalerFlag{
 		Name:  "syncmode",
-		Usage: `Blockchain sync mode ("full")`,
+		Usage: `Blockchain sync mode ("full", "fast", "snap", "checkpoint" or "light")`,
 		Value: &defaultSyncMode,
 	}
 	GCModeFlag = cli.StringFlag{
@@ -190,16 +199,50 @@ var (
 		Usage: `Blockchain garbage collection mode ("full", "archive")`,
 		Value: "full",
 	}
+	StateDiffsFlag = cli.BoolFlag{
+		Name:  "statediffs",
+		Usage: "Store per-block state diffs to serve historical state without an archive node",
+	}
+	StateDiffCheckpointFlag = cli.Uint64Flag{
+		Name:  "statediffs.checkpoint",
+		Usage: "Block interval at which full states are persisted as state diff replay bases",
+		Value: core.DefaultStateDiffCheckpoint,
+	}
 
 	SnapshotFlag = cli.BoolFlag{
 		Name:  "snapshot",
 		Usage: `Enables snapshot-database mode -- experimental work in progress feature`,
 	}
+	BloomFilterSizeFlag = cli.Uint64Flag{
+		Name:  "bloomfilter.size",
+		Usage: "Megabytes of memory allocated to bloom-filter for pruning",
+		Value: 2048,
+	}
+	ExcludeCodeFlag = cli.BoolFlag{
+		Name:  "nocode",
+		Usage: "Exclude contract code (save db lookups)",
+	}
+	ExcludeStorageFlag = cli.BoolFlag{
+		Name:  "nostorage",
+		Usage: "Exclude storage entries (save db lookups)",
+	}
+	StartKeyFlag = cli.StringFlag{
+		Name:  "start",
+		Usage: "Start position (account hash) of the dump",
+	}
+	DumpLimitFlag = cli.Uint64Flag{
+		Name:  "limit",
+		Usage: "Max number of accounts to dump (default = no limit)",
+	}
 	TxLookupLimitFlag = cli.Int64Flag{
 		Name:  "txlookuplimit",
 		Usage: "Number of recent blocks to maintain transactions index by-hash for (default = index all blocks)",
 		Value: 0,
 	}
+	ImportNoFetchFlag = cli.BoolFlag{
+		Name:  "import.nofetch",
+		Usage: "Skip downloading the model and input files of the imported blocks (files must be available locally)",
+	}
 
 	WhitelistFlag = cli.StringFlag{
 		Name:  "whitelist",
@@ -352,6 +395,26 @@ var (
 		Usage: "Maximum amount of time non-executable transaction are queued",
 		Value: ctxc.DefaultConfig.TxPool.Lifetime,
 	}
+	// Transaction propagation settings
+	TxPropPeersFlag = cli.IntFlag{
+		Name:  "txprop.peers",
+		Usage: "Maximum number of peers a transaction is broadcast to in full (0 = square root of the peer count)",
+		Value: ctxc.DefaultConfig.TxPropagation.BroadcastPeers,
+	}
+	TxPropMaxSizeFlag = cli.Uint64Flag{
+		Name:  "txprop.maxsize",
+		Usage: "Size in bytes above which transactions are only announced to peers (0 = no limit)",
+		Value: uint64(ctxc.DefaultConfig.TxPropagation.MaxBroadcastSize),
+	}
+	TxPropBroadcastUploadsFlag = cli.BoolFlag{
+		Name:  "txprop.broadcastuploads",
+		Usage: "Broadcast file upload progress transactions in full instead of only announcing them",
+	}
+	TxPropPeerBudgetFlag = cli.Uint64Flag{
+		Name:  "txprop.peerbudget",
+		Usage: "Bytes of full transactions broadcast to a single peer per second (0 = no limit)",
+		Value: uint64(ctxc.DefaultConfig.TxPropagation.PeerBudget),
+	}
 	// Performance tuning settings
 	CacheFlag = cli.IntFlag{
 		Name:  "cache",
@@ -541,6 +604,14 @@ var (
 		Usage: "Sets a cap on transaction fee (in ctxc) that can be sent via the RPC APIs (0 = no cap)",
 		Value: ctxc.DefaultConfig.RPCTxFeeCap,
 	}
+	RPCDatabaseAPIFlag = cli.BoolFlag{
+		Name:  "rpc.dbapi",
+		Usage: "Expose the raw chain database read-only over the debug_db* RPC methods",
+	}
+	RemoteDBFlag = cli.StringFlag{
+		Name:  "remotedb",
+		Usage: "URL of a node exposing the debug_db* RPC methods to read the chain database from",
+	}
 
 	VMEnableDebugFlag = cli.BoolFlag{
 		Name:  "vmdebug",
@@ -589,6 +660,65 @@ var (
 		Usage: "API's offered over the HTTP-RPC interface",
 		Value: "",
 	}
+	RPCJWTSecretFlag = cli.StringFlag{
+		Name:  "rpc.jwtsecret",
+		Usage: "Path to a JWT secret used to authenticate HTTP and WS RPC requests (generated if missing)",
+		Value: "",
+	}
+	RPCAuditLogFlag = cli.StringFlag{
+		Name:  "rpc.auditlog",
+		Usage: "Path of the file recording rejected RPC requests",
+		Value: "",
+	}
+	RPCBatchLimitFlag = cli.IntFlag{
+		Name:  "rpc.batchlimit",
+		Usage: "Maximum number of requests in a HTTP/WS RPC batch (0 = unlimited)",
+		Value: 0,
+	}
+	RPCResponseLimitFlag = cli.IntFlag{
+		Name:  "rpc.responselimit",
+		Usage: "Maximum size in bytes of a HTTP/WS RPC response (0 = unlimited)",
+		Value: 0,
+	}
+	RPCRateLimitFlag = cli.Float64Flag{
+		Name:  "rpc.ratelimit",
+		Usage: "Sustained HTTP/WS RPC requests per second allowed per client IP or token (0 = unlimited)",
+		Value: 0,
+	}
+	RPCRateBurstFlag = cli.IntFlag{
+		Name:  "rpc.rateburst",
+		Usage: "Maximum burst of HTTP/WS RPC requests allowed per client IP or token",
+		Value: 0,
+	}
+	RPCExpensiveRateLimitFlag = cli.Float64Flag{
+		Name:  "rpc.expensiveratelimit",
+		Usage: "Sustained expensive calls (call, estimateGas, traces, infer) per second allowed per client (0 = unlimited)",
+		Value: 0,
+	}
+	RPCExpensiveRateBurstFlag = cli.IntFlag{
+		Name:  "rpc.expensiveburst",
+		Usage: "Maximum burst of expensive calls allowed per client",
+		Value: 0,
+	}
+	RPCExpensiveMethodsFlag = cli.StringFlag{
+		Name:  "rpc.expensivemethods",
+		Usage: "Comma separated list of methods counted as expensive calls, '*' matches any prefix or suffix",
+		Value: strings.Join(rpc.DefaultExpensiveMethods, ","),
+	}
+	GraphQLEnabledFlag = cli.BoolFlag{
+		Name:  "graphql",
+		Usage: "Enable GraphQL on the HTTP-RPC server. Note that GraphQL can only be started if an HTTP server is started as well.",
+	}
+	GraphQLCORSDomainFlag = cli.StringFlag{
+		Name:  "graphql.corsdomain",
+		Usage: "Comma separated list of domains from which to accept cross origin requests (browser enforced)",
+		Value: "",
+	}
+	GraphQLVirtualHostsFlag = cli.StringFlag{
+		Name:  "graphql.vhosts",
+		Usage: "Comma separated list of virtual hostnames from which to accept requests (server enforced). Accepts '*' wildcard.",
+		Value: strings.Join(node.DefaultConfig.GraphQLVirtualHosts, ","),
+	}
 	IPCDisabledFlag = cli.BoolFlag{
 		Name:  "ipcdisable",
 		Usage: "Disable the IPC-RPC server",
@@ -632,6 +762,17 @@ var (
 	}
 
 	// Network Settings
+	// Light server and client settings
+	LightServeFlag = cli.IntFlag{
+		Name:  "light.serve",
+		Usage: "Maximum percentage of time allowed for serving LES requests (multi-threaded processing allows values over 100)",
+		Value: ctxc.DefaultConfig.LightServ,
+	}
+	LightMaxPeersFlag = cli.IntFlag{
+		Name:  "light.maxpeers",
+		Usage: "Maximum number of light clients to serve, or light servers to attach to",
+		Value: ctxc.DefaultConfig.LightPeers,
+	}
 	MaxPeersFlag = cli.IntFlag{
 		Name:  "maxpeers",
 		Usage: "Maximum number of network peers (network disabled if set to 0)",
@@ -681,7 +822,11 @@ var (
 	}
 	DiscoveryV5Flag = cli.BoolFlag{
 		Name:  "v5disc",
-		Usage: "Enables the experimental RLPx V5 (Topic Discovery) mechanism",
+		Usage: "Enables the V5 discovery mechanism alongside V4 (on by default, --v5disc=false disables it)",
+	}
+	DialRatioFlag = cli.StringFlag{
+		Name:  "discovery.dialratio",
+		Usage: "Comma separated protocol=weight pairs weighing the dial candidates of a protocol against random discovery nodes (e.g. ctxc=3)",
 	}
 	DNSDiscoveryFlag = cli.StringFlag{
 		Name:  "discovery.dns",
@@ -993,6 +1138,54 @@ func setHTTP(ctx *cli.Context, cfg *node.Config) {
 	}
 }
 
+// setRPCAuth configures the authentication of the HTTP and WebSocket RPC
+// endpoints from the set command line flags.
+func setRPCAuth(ctx *cli.Context, cfg *node.Config) {
+	if ctx.GlobalIsSet(RPCJWTSecretFlag.Name) {
+		cfg.JWTSecret = ctx.GlobalString(RPCJWTSecretFlag.Name)
+	}
+	if ctx.GlobalIsSet(RPCAuditLogFlag.Name) {
+		cfg.RPCAuditLog = ctx.GlobalString(RPCAuditLogFlag.Name)
+	}
+}
+
+// setRPCLimits configures the resource limits of the HTTP and WebSocket RPC
+// endpoints from the set command line flags.
+func setRPCLimits(ctx *cli.Context, cfg *node.Config) {
+	if ctx.GlobalIsSet(RPCBatchLimitFlag.Name) {
+		cfg.RPCLimits.BatchItems = ctx.GlobalInt(RPCBatchLimitFlag.Name)
+	}
+	if ctx.GlobalIsSet(RPCResponseLimitFlag.Name) {
+		cfg.RPCLimits.ResponseSize = ctx.GlobalInt(RPCResponseLimitFlag.Name)
+	}
+	if ctx.GlobalIsSet(RPCRateLimitFlag.Name) {
+		cfg.RPCLimits.RequestRate = ctx.GlobalFloat64(RPCRateLimitFlag.Name)
+	}
+	if ctx.GlobalIsSet(RPCRateBurstFlag.Name) {
+		cfg.RPCLimits.RequestBurst = ctx.GlobalInt(RPCRateBurstFlag.Name)
+	}
+	if ctx.GlobalIsSet(RPCExpensiveRateLimitFlag.Name) {
+		cfg.RPCLimits.ExpensiveRate = ctx.GlobalFloat64(RPCExpensiveRateLimitFlag.Name)
+	}
+	if ctx.GlobalIsSet(RPCExpensiveRateBurstFlag.Name) {
+		cfg.RPCLimits.ExpensiveBurst = ctx.GlobalInt(RPCExpensiveRateBurstFlag.Name)
+	}
+	if ctx.GlobalIsSet(RPCExpensiveMethodsFlag.Name) {
+		cfg.RPCLimits.ExpensiveMethods = splitAndTrim(ctx.GlobalString(RPCExpensiveMethodsFlag.Name))
+	}
+}
+
+// setGraphQL creates the GraphQL CORS and virtual host settings from the set
+// command line flags.
+func setGraphQL(ctx *cli.Context, cfg *node.Config) {
+	if ctx.GlobalIsSet(GraphQLCORSDomainFlag.Name) {
+		cfg.GraphQLCors = splitAndTrim(ctx.GlobalString(GraphQLCORSDomainFlag.Name))
+	}
+	if ctx.GlobalIsSet(GraphQLVirtualHostsFlag.Name) {
+		cfg.GraphQLVirtualHosts = splitAndTrim(ctx.GlobalString(GraphQLVirtualHostsFlag.Name))
+	}
+}
+
 // setWS creates the WebSocket RPC listener interface string from the set
 // command line flags, returning empty if the HTTP endpoint is disabled.
 func setWS(ctx *cli.Context, cfg *node.Config) {
@@ -1026,9 +1219,9 @@ func setIPC(ctx *cli.Context, cfg *node.Config) {
 	}
 }
 
-// makeDatabaseHandles raises out the number of allowed file handles per process
+// MakeDatabaseHandles raises out the number of allowed file handles per process
 // for Ctxc and returns half of the allowance to assign to the database.
-func makeDatabaseHandles() int {
+func MakeDatabaseHandles() int {
 	limit, err := fdlimit.Current()
 	if err != nil {
 		Fatalf("Failed to retrieve file descriptor allowance: %v", err)
@@ -1108,6 +1301,23 @@ func MakePasswordList(ctx *cli.Context) []string {
 	return lines
 }
 
+// parseDialRatios parses a comma separated list of protocol=weight pairs.
+func parseDialRatios(input string) (map[string]int, error) {
+	ratios := make(map[string]int)
+	for _, pair := range splitAndTrim(input) {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid dial ratio %q, want protocol=weight", pair)
+		}
+		weight, err := strconv.Atoi(parts[1])
+		if err != nil || weight < 1 {
+			return nil, fmt.Errorf("invalid weight %q for protocol %s", parts[1], parts[0])
+		}
+		ratios[parts[0]] = weight
+	}
+	return ratios, nil
+}
+
 func SetP2PConfig(ctx *cli.Context, cfg *p2p.Config) {
 	setNodeKey(ctx, cfg)
 	setNAT(ctx, cfg)
@@ -1115,11 +1325,35 @@ func SetP2PConfig(ctx *cli.Context, cfg *p2p.Config) {
 	setBootstrapNodes(ctx, cfg)
 	setBootstrapNodesV5(ctx, cfg)
 
+	lightClient := ctx.GlobalString(SyncModeFlag.Name) == "light"
+	lightServer := ctx.GlobalInt(LightServeFlag.Name) != 0
+
+	lightPeers := ctx.GlobalInt(LightMaxPeersFlag.Name)
+	if lightClient && !ctx.GlobalIsSet(LightMaxPeersFlag.Name) {
+		// dynamic default - for clients we use 1/10th of the default for servers
+		lightPeers /= 10
+	}
 	if ctx.GlobalIsSet(MaxPeersFlag.Name) {
 		cfg.MaxPeers = ctx.GlobalInt(MaxPeersFlag.Name)
+		if lightServer && !ctx.GlobalIsSet(LightMaxPeersFlag.Name) {
+			cfg.MaxPeers += lightPeers
+		}
+	} else {
+		if lightServer {
+			cfg.MaxPeers += lightPeers
+		}
+		if lightClient && ctx.GlobalIsSet(LightMaxPeersFlag.Name) && cfg.MaxPeers < lightPeers {
+			cfg.MaxPeers = lightPeers
+		}
 	}
-	ctxcPeers := cfg.MaxPeers
-	log.Info("Maximum peer count", "Cortex", ctxcPeers, "total", cfg.MaxPeers)
+	if !(lightClient || lightServer) {
+		lightPeers = 0
+	}
+	ctxcPeers := cfg.MaxPeers - lightPeers
+	if lightClient {
+		ctxcPeers = 0
+	}
+	log.Info("Maximum peer count", "Cortex", ctxcPeers, "LES", lightPeers, "total", cfg.MaxPeers)
 
 	if ctx.GlobalIsSet(MaxPendingPeersFlag.Name) {
 		cfg.MaxPendingPeers = ctx.GlobalInt(MaxPendingPeersFlag.Name)
@@ -1128,14 +1362,20 @@ func SetP2PConfig(ctx *cli.Context, cfg *p2p.Config) {
 		cfg.NoDiscovery = true
 	}
 
-	// if we're running a light client or server, force enable the v5 peer discovery
-	// unless it is explicitly disabled with --nodiscover note that explicitly specifying
-	// --v5disc overrides --nodiscover, in which case the later only disables v4 discovery
-	//forceV5Discovery := (lightClient || lightServer) && !ctx.GlobalBool(NoDiscoverFlag.Name)
+	// V5 discovery runs by default next to V4, sharing its socket. Unless it is
+	// explicitly enabled with --v5disc, --nodiscover disables it as well; in that
+	// case the former overrides the latter, which only disables V4 discovery.
 	if ctx.GlobalIsSet(DiscoveryV5Flag.Name) {
 		cfg.DiscoveryV5 = ctx.GlobalBool(DiscoveryV5Flag.Name)
-		//} else if forceV5Discovery {
-		//        cfg.DiscoveryV5 = true
+	} else if ctx.GlobalIsSet(NoDiscoverFlag.Name) {
+		cfg.DiscoveryV5 = false
+	}
+	if ctx.GlobalIsSet(DialRatioFlag.Name) {
+		ratios, err := parseDialRatios(ctx.GlobalString(DialRatioFlag.Name))
+		if err != nil {
+			Fatalf("Option %q: %v", DialRatioFlag.Name, err)
+		}
+		cfg.ProtocolDialRatios = ratios
 	}
 
 	if netrestrict := ctx.GlobalString(NetrestrictFlag.Name); netrestrict != "" {
@@ -1152,11 +1392,26 @@ func SetNodeConfig(ctx *cli.Context, cfg *node.Config) {
 	SetP2PConfig(ctx, &cfg.P2P)
 	setIPC(ctx, cfg)
 	setHTTP(ctx, cfg)
+	setRPCAuth(ctx, cfg)
+	setRPCLimits(ctx, cfg)
+	setGraphQL(ctx, cfg)
 	setWS(ctx, cfg)
 	setNodeUserIdent(ctx, cfg)
 
 	cfg.DataDir = MakeDataDir(ctx)
 
+	if ctx.GlobalIsSet(DBEngineFlag.Name) {
+		dbEngine := ctx.GlobalString(DBEngineFlag.Name)
+		if dbEngine != "leveldb" && dbEngine != "pebble" {
+			Fatalf("Invalid choice for db.engine '%s', allowed 'leveldb' or 'pebble'", dbEngine)
+		}
+		if dbEngine == "pebble" && !rawdb.PebbleEnabled {
+			Fatalf("Database engine 'pebble' not supported on this platform")
+		}
+		log.Info(fmt.Sprintf("Using %s as db engine", dbEngine))
+		cfg.DBEngine = dbEngine
+	}
+
 	// switch {
 	// case ctx.GlobalIsSet(DataDirFlag.Name):
 	// 	cfg.DataDir = ctx.GlobalString(DataDirFlag.Name)
@@ -1184,7 +1439,12 @@ func SetNodeConfig(ctx *cli.Context, cfg *node.Config) {
 	}
 }
 
-func setGPO(ctx *cli.Context, cfg *gasprice.Config) {
+func setGPO(ctx *cli.Context, cfg *gasprice.Config, light bool) {
+	// If we are running the light client, apply another group
+	// settings for gas oracle.
+	if light {
+		*cfg = ctxc.DefaultLightGPOConfig
+	}
 	if ctx.GlobalIsSet(GpoBlocksFlag.Name) {
 		cfg.Blocks = ctx.GlobalInt(GpoBlocksFlag.Name)
 	}
@@ -1196,6 +1456,21 @@ func setGPO(ctx *cli.Context, cfg *gasprice.Config) {
 	}
 }
 
+func setTxPropagation(ctx *cli.Context, cfg *ctxc.TxPropagationConfig) {
+	if ctx.GlobalIsSet(TxPropPeersFlag.Name) {
+		cfg.BroadcastPeers = ctx.GlobalInt(TxPropPeersFlag.Name)
+	}
+	if ctx.GlobalIsSet(TxPropMaxSizeFlag.Name) {
+		cfg.MaxBroadcastSize = common.StorageSize(ctx.GlobalUint64(TxPropMaxSizeFlag.Name))
+	}
+	if ctx.GlobalIsSet(TxPropBroadcastUploadsFlag.Name) {
+		cfg.AnnounceUploads = !ctx.GlobalBool(TxPropBroadcastUploadsFlag.Name)
+	}
+	if ctx.GlobalIsSet(TxPropPeerBudgetFlag.Name) {
+		cfg.PeerBudget = common.StorageSize(ctx.GlobalUint64(TxPropPeerBudgetFlag.Name))
+	}
+}
+
 func setTxPool(ctx *cli.Context, cfg *core.TxPoolConfig) {
 	if ctx.GlobalIsSet(TxPoolLocalsFlag.Name) {
 		locals := strings.Split(ctx.GlobalString(TxPoolLocalsFlag.Name), ",")
@@ -1363,20 +1638,27 @@ func SetCortexConfig(ctx *cli.Context, stack *node.Node, cfg *ctxc.Config) {
 		ks = keystores[0].(*keystore.KeyStore)
 	}
 	setCoinbase(ctx, ks, cfg)
-	setGPO(ctx, &cfg.GPO)
+	setGPO(ctx, &cfg.GPO, ctx.GlobalString(SyncModeFlag.Name) == "light")
 	setTxPool(ctx, &cfg.TxPool)
+	setTxPropagation(ctx, &cfg.TxPropagation)
 	setWhitelist(ctx, cfg)
 
 	if ctx.GlobalIsSet(SyncModeFlag.Name) {
 		cfg.SyncMode = *GlobalTextMarshaler(ctx, SyncModeFlag.Name).(*downloader.SyncMode)
 	}
+	if ctx.GlobalIsSet(LightServeFlag.Name) {
+		cfg.LightServ = ctx.GlobalInt(LightServeFlag.Name)
+	}
+	if ctx.GlobalIsSet(LightMaxPeersFlag.Name) {
+		cfg.LightPeers = ctx.GlobalInt(LightMaxPeersFlag.Name)
+	}
 	if ctx.GlobalIsSet(NetworkIdFlag.Name) {
 		cfg.NetworkId = ctx.GlobalUint64(NetworkIdFlag.Name)
 	}
 	if ctx.GlobalIsSet(CacheFlag.Name) || ctx.GlobalIsSet(CacheDatabaseFlag.Name) {
 		cfg.DatabaseCache = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheDatabaseFlag.Name) / 100
 	}
-	cfg.DatabaseHandles = makeDatabaseHandles()
+	cfg.DatabaseHandles = MakeDatabaseHandles()
 	if ctx.GlobalIsSet(AncientFlag.Name) {
 		cfg.DatabaseFreezer = ctx.GlobalString(AncientFlag.Name)
 	}
@@ -1390,6 +1672,12 @@ func SetCortexConfig(ctx *cli.Context, stack *node.Node, cfg *ctxc.Config) {
 	if ctx.GlobalIsSet(CacheNoPrefetchFlag.Name) {
 		cfg.NoPrefetch = ctx.GlobalBool(CacheNoPrefetchFlag.Name)
 	}
+	if ctx.GlobalIsSet(StateDiffsFlag.Name) {
+		cfg.StateDiffs = ctx.GlobalBool(StateDiffsFlag.Name)
+	}
+	if ctx.GlobalIsSet(StateDiffCheckpointFlag.Name) {
+		cfg.StateDiffCheckpoint = ctx.GlobalUint64(StateDiffCheckpointFlag.Name)
+	}
 	// Read the value from the flag no matter if it's set or not.
 	cfg.Preimages = ctx.GlobalBool(CachePreimagesFlag.Name)
 	if cfg.NoPruning && !cfg.Preimages {
@@ -1473,6 +1761,10 @@ func SetCortexConfig(ctx *cli.Context, stack *node.Node, cfg *ctxc.Config) {
 	if ctx.GlobalIsSet(RPCGlobalGasCapFlag.Name) {
 		cfg.RPCGasCap = ctx.GlobalUint64(RPCGlobalGasCapFlag.Name)
 	}
+	if ctx.GlobalIsSet(RPCDatabaseAPIFlag.Name) {
+		cfg.DatabaseAPI = ctx.GlobalBool(RPCDatabaseAPIFlag.Name)
+		log.Warn("Exposing the raw chain database over the debug namespace")
+	}
 	if cfg.RPCGasCap != 0 {
 		log.Info("Set global gas cap", "cap", cfg.RPCGasCap)
 	} else {
@@ -1536,11 +1828,13 @@ func SetCortexConfig(ctx *cli.Context, stack *node.Node, cfg *ctxc.Config) {
 			cfg.NetworkId = 42
 		}
 		cfg.Genesis = core.DefaultBernardGenesisBlock()
+		setDNSDiscoveryDefaults(cfg, params.BernardGenesisHash)
 	case ctx.GlobalBool(DoloresFlag.Name):
 		if !ctx.GlobalIsSet(NetworkIdFlag.Name) {
 			cfg.NetworkId = 43
 		}
 		cfg.Genesis = core.DefaultDoloresGenesisBlock()
+		setDNSDiscoveryDefaults(cfg, params.DoloresGenesisHash)
 		//case ctx.GlobalBool(TestnetFlag.Name):
 		//	if !ctx.GlobalIsSet(NetworkIdFlag.Name) {
 		//		cfg.NetworkId = 28
@@ -1646,15 +1940,45 @@ func SetTorrentFsConfig(ctx *cli.Context, cfg *torrentfs.Config) {
 // RegisterCortexService adds an Cortex client to the stack.
 func RegisterCortexService(stack *node.Node, cfg *ctxc.Config) {
 	var err error
-	err = stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
-		fullNode, err := ctxc.New(ctx, cfg)
-		return fullNode, err
-	})
+	if cfg.SyncMode == downloader.LightSync {
+		err = stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
+			return les.New(ctx, cfg)
+		})
+	} else {
+		err = stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
+			fullNode, err := ctxc.New(ctx, cfg)
+			if fullNode != nil && cfg.LightServ > 0 {
+				ls, err := les.NewLesServer(fullNode, cfg)
+				if err != nil {
+					return nil, err
+				}
+				fullNode.AddLesServer(ls)
+			}
+			return fullNode, err
+		})
+	}
 	if err != nil {
 		Fatalf("Failed to register the Cortex service: %v", err)
 	}
 }
 
+// RegisterGraphQLService is a utility function to construct a new service and register it against a node.
+func RegisterGraphQLService(stack *node.Node, cors, vhosts []string) {
+	if stack.Config().HTTPHost == "" {
+		Fatalf("GraphQL requires the HTTP-RPC server to be enabled (--%s)", RPCEnabledFlag.Name)
+	}
+	if err := stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
+		// Try to construct the GraphQL service backed by a full node
+		var ctxcServ *ctxc.Cortex
+		if err := ctx.Service(&ctxcServ); err != nil {
+			return nil, err
+		}
+		return graphql.New(ctx, ctxcServ.APIBackend, cors, vhosts)
+	}); err != nil {
+		Fatalf("Failed to register the GraphQL service: %v", err)
+	}
+}
+
 // RegisterShhService configures Whisper and adds it to the given node.
 func RegisterShhService(stack *node.Node, cfg *whisper.Config) {
 	if err := stack.Register(func(n *node.ServiceContext) (node.Service, error) {
@@ -1741,8 +2065,15 @@ func SplitTagsFlag(tagsFlag string) map[string]string {
 func MakeChainDatabase(ctx *cli.Context, stack *node.Node) ctxcdb.Database {
 	var (
 		cache   = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheDatabaseFlag.Name) / 100
-		handles = makeDatabaseHandles()
+		handles = MakeDatabaseHandles()
 	)
+	if url := ctx.GlobalString(RemoteDBFlag.Name); url != "" {
+		chainDb, err := remotedb.Dial(url)
+		if err != nil {
+			Fatalf("Could not connect to remote database: %v", err)
+		}
+		return chainDb
+	}
 	name := "chaindata"
 	//chainDb, err := stack.OpenDatabase(name, cache, handles)
 	chainDb, err := stack.OpenDatabaseWithFreezer(name, cache, handles, ctx.GlobalString(AncientFlag.Name), "")
@@ -1796,6 +2127,8 @@ func MakeChain(ctx *cli.Context, stack *node.Node, readOnly bool) (chain *core.B
 		TrieTimeLimit:       ctxc.DefaultConfig.TrieTimeout,
 		SnapshotLimit:       ctxc.DefaultConfig.SnapshotCache,
 		Preimages:           ctx.GlobalBool(CachePreimagesFlag.Name),
+		StateDiffs:          ctx.GlobalBool(StateDiffsFlag.Name),
+		StateDiffCheckpoint: ctx.GlobalUint64(StateDiffCheckpointFlag.Name),
 	}
 	if cache.TrieDirtyDisabled && !cache.Preimages {
 		cache.Preimages = true
@@ -0,0 +1,56 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of CortexFoundation.
+//
+// CortexFoundation is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// CortexFoundation is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with CortexFoundation. If not, see <http://www.gnu.org/licenses/>.
+
+package utils
+
+import (
+	"context"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/inference/synapse"
+	"github.com/CortexFoundation/torrentfs"
+)
+
+// NoFetchStorage is a storage layer only serving the locally available files,
+// without scheduling the download of the missing ones.
+type NoFetchStorage struct {
+	torrentfs.CortexStorage
+}
+
+// Download implements torrentfs.CortexStorage, ignoring the request.
+func (s NoFetchStorage) Download(ctx context.Context, ih string, request uint64) error {
+	return nil
+}
+
+// PrefetchManifest schedules the download of the model and input files listed
+// in the manifest of the given export file, if one is present.
+func PrefetchManifest(engine *synapse.Synapse, fn string) {
+	manifest, err := LoadManifest(fn)
+	if err != nil {
+		log.Warn("Failed to load import manifest", "file", ManifestPath(fn), "err", err)
+		return
+	}
+	if manifest == nil {
+		return
+	}
+	log.Info("Fetching files of import manifest", "file", ManifestPath(fn), "count", len(manifest.Files))
+	for _, f := range manifest.Files {
+		if err := engine.Download(common.StorageEntry{Hash: f.Hash, Size: f.RawSize}); err != nil {
+			log.Warn("Failed to fetch manifest file", "kind", f.Kind, "infohash", f.Hash, "err", err)
+		}
+	}
+}
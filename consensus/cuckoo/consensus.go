@@ -81,7 +81,7 @@ var (
 	errDanglingUncle     = errors.New("uncle's parent is not ancestor")
 	errInvalidDifficulty = errors.New("non-positive difficulty")
 	errInvalidMixDigest  = errors.New("invalid mix digest")
-	errInvalidPoW        = errors.New("invalid proof-of-work")
+	errInvalidPoW        = consensus.ErrInvalidPoW
 )
 
 // Author implements consensus.Engine, returning the header's coinbase as the
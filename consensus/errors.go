@@ -34,4 +34,7 @@ var (
 	// ErrInvalidNumber is returned if a block's number doesn't equal it's parent's
 	// plus one.
 	ErrInvalidNumber = errors.New("invalid block number")
+
+	// ErrInvalidPoW is returned if a block's seal is not a valid proof-of-work.
+	ErrInvalidPoW = errors.New("invalid proof-of-work")
 )
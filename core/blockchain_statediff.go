@@ -0,0 +1,124 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"fmt"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
+	"github.com/CortexFoundation/CortexTheseus/core/state"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/rlp"
+)
+
+// DefaultStateDiffCheckpoint is the default block interval at which full states
+// are flushed to disk when state diffs are tracked.
+const DefaultStateDiffCheckpoint = 1024
+
+// stateDiffCheckpoint returns the configured state checkpoint interval.
+func (bc *BlockChain) stateDiffCheckpoint() uint64 {
+	if bc.cacheConfig.StateDiffCheckpoint == 0 {
+		return DefaultStateDiffCheckpoint
+	}
+	return bc.cacheConfig.StateDiffCheckpoint
+}
+
+// StateDiffsEnabled returns whether the chain persists per-block state diffs,
+// in which case any state committed via WriteBlockWithState should be tracking
+// its changes.
+func (bc *BlockChain) StateDiffsEnabled() bool {
+	return bc.cacheConfig.StateDiffs
+}
+
+// GetStateDiff retrieves the state diff of a block from the database, or nil
+// if none was stored for it.
+func (bc *BlockChain) GetStateDiff(hash common.Hash, number uint64) *state.StateDiff {
+	blob := rawdb.ReadStateDiff(bc.db, hash, number)
+	if len(blob) == 0 {
+		return nil
+	}
+	diff := new(state.StateDiff)
+	if err := rlp.DecodeBytes(blob, diff); err != nil {
+		log.Error("Invalid state diff RLP", "hash", hash, "number", number, "err", err)
+		return nil
+	}
+	return diff
+}
+
+// HistoricState returns the post-state of the given header. If the state is no
+// longer available in the database, it is reconstructed by replaying the stored
+// state diffs on top of the closest ancestor state still present, which is at
+// most one checkpoint interval away. The returned state is not committed, every
+// intermediate root is verified against the respective header.
+//
+// Reconstructed states are cached by root, and a cached ancestor state is used
+// as the replay base in place of the database one, so walking the history block
+// by block only needs to replay a single diff per call.
+func (bc *BlockChain) HistoricState(header *types.Header) (*state.StateDB, error) {
+	statedb, err := bc.StateAt(header.Root)
+	if err == nil || !bc.cacheConfig.StateDiffs {
+		return statedb, err
+	}
+	if cached, ok := bc.historicState.Get(header.Root); ok {
+		return cached.(*state.StateDB).Copy(), nil
+	}
+	// Gather the headers to replay until an available ancestor state is found
+	var (
+		limit   = bc.stateDiffCheckpoint()
+		headers []*types.Header
+		current = header
+	)
+	for {
+		headers = append(headers, current)
+		if uint64(len(headers)) > limit {
+			return nil, fmt.Errorf("no state available within %d blocks of #%d", limit, header.Number)
+		}
+		parent := bc.GetHeader(current.ParentHash, current.Number.Uint64()-1)
+		if parent == nil {
+			return nil, fmt.Errorf("missing parent #%d [%x]", current.Number.Uint64()-1, current.ParentHash)
+		}
+		if cached, ok := bc.historicState.Get(parent.Root); ok {
+			statedb = cached.(*state.StateDB).Copy()
+			break
+		}
+		if statedb, err = state.New(parent.Root, bc.stateCache, nil); err == nil {
+			break
+		}
+		current = parent
+	}
+	// Replay the diffs from the oldest to the requested block
+	for i := len(headers) - 1; i >= 0; i-- {
+		var (
+			number = headers[i].Number.Uint64()
+			hash   = headers[i].Hash()
+		)
+		diff := bc.GetStateDiff(hash, number)
+		if diff == nil {
+			return nil, fmt.Errorf("missing state diff #%d [%x]", number, hash)
+		}
+		if err := statedb.ApplyDiff(diff); err != nil {
+			return nil, fmt.Errorf("failed to apply state diff #%d [%x]: %v", number, hash, err)
+		}
+		if root := statedb.IntermediateRoot(false); root != headers[i].Root {
+			return nil, fmt.Errorf("state diff root mismatch #%d [%x]: have %x, want %x", number, hash, root, headers[i].Root)
+		}
+	}
+	bc.historicState.Add(header.Root, statedb.Copy())
+	return statedb, nil
+}
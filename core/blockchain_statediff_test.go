@@ -0,0 +1,127 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/consensus/cuckoo"
+	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/core/vm"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/params"
+)
+
+// Tests that pruned historical states can be reconstructed from the stored
+// state diffs and the periodic checkpoints.
+func TestHistoricStateFromDiffs(t *testing.T) {
+	testHistoricStateFromDiffs(t, true)
+}
+
+// Tests that pruned historical states are unavailable without state diffs.
+func TestHistoricStateWithoutDiffs(t *testing.T) {
+	testHistoricStateFromDiffs(t, false)
+}
+
+func testHistoricStateFromDiffs(t *testing.T, diffs bool) {
+	var (
+		key, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr   = crypto.PubkeyToAddress(key.PublicKey)
+		recv   = common.Address{0xaa}
+		gendb  = rawdb.NewMemoryDatabase()
+		signer = types.NewEIP155Signer(params.TestChainConfig.ChainID)
+		gspec  = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  GenesisAlloc{addr: {Balance: big.NewInt(1000000000000000000)}},
+			Supply: params.CTXC_INIT,
+		}
+		genesis = gspec.MustCommit(gendb)
+	)
+	blocks, _ := GenerateChain(gspec.Config, genesis, cuckoo.NewFaker(), gendb, 3*int(TriesInMemory), func(i int, gen *BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(gen.TxNonce(addr), recv, big.NewInt(1), params.TxGas, big.NewInt(1), nil), signer, key)
+		gen.AddTx(tx)
+		gen.SetExtra([]byte{byte(i)})
+	})
+	db := rawdb.NewMemoryDatabase()
+	gspec.MustCommit(db)
+
+	chain, err := NewBlockChain(db, &CacheConfig{
+		TrieCleanLimit:      256,
+		TrieDirtyLimit:      256,
+		TrieTimeLimit:       5 * time.Minute,
+		StateDiffs:          diffs,
+		StateDiffCheckpoint: 64,
+	}, params.TestChainConfig, cuckoo.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert block %d: %v", n, err)
+	}
+	for _, number := range []uint64{1, 63, 64, 100, 255} {
+		header := chain.GetHeaderByNumber(number)
+		if number%64 != 0 {
+			if _, err := chain.StateAt(header.Root); err == nil {
+				t.Fatalf("state #%d not pruned", number)
+			}
+		}
+		statedb, err := chain.HistoricState(header)
+		if !diffs {
+			if err == nil && number%64 != 0 {
+				t.Fatalf("state #%d available without diffs", number)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("failed to retrieve state #%d: %v", number, err)
+		}
+		if have, want := statedb.GetBalance(recv), new(big.Int).SetUint64(number); have.Cmp(want) != 0 {
+			t.Fatalf("balance #%d mismatch: have %v, want %v", number, have, want)
+		}
+		if have := statedb.GetNonce(addr); have != number {
+			t.Fatalf("nonce #%d mismatch: have %d, want %d", number, have, number)
+		}
+	}
+	if !diffs {
+		return
+	}
+	// Drop the diffs leading up to the last reconstructed state, it and its child
+	// must still be available through the cache of reconstructed states
+	for number := uint64(65); number <= 100; number++ {
+		rawdb.DeleteStateDiff(db, chain.GetHeaderByNumber(number).Hash(), number)
+	}
+	for _, number := range []uint64{100, 101} {
+		statedb, err := chain.HistoricState(chain.GetHeaderByNumber(number))
+		if err != nil {
+			t.Fatalf("failed to retrieve state #%d from cache: %v", number, err)
+		}
+		if have, want := statedb.GetBalance(recv), new(big.Int).SetUint64(number); have.Cmp(want) != 0 {
+			t.Fatalf("balance #%d mismatch: have %v, want %v", number, have, want)
+		}
+		// Modifying the returned state must not leak into the cached one
+		statedb.SetNonce(addr, 0)
+	}
+	if statedb, _ := chain.HistoricState(chain.GetHeaderByNumber(101)); statedb.GetNonce(addr) != 101 {
+		t.Fatalf("cached state #101 modified")
+	}
+}
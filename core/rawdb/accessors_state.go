@@ -94,3 +94,30 @@ func DeleteTrieNode(db ctxcdb.KeyValueWriter, hash common.Hash) {
 		log.Crit("Failed to delete trie node", "err", err)
 	}
 }
+
+// ReadStateDiff retrieves the RLP encoded state diff of the block with the
+// provided hash and number.
+func ReadStateDiff(db ctxcdb.KeyValueReader, hash common.Hash, number uint64) []byte {
+	data, _ := db.Get(stateDiffKey(number, hash))
+	return data
+}
+
+// HasStateDiff verifies the existence of the state diff of a block.
+func HasStateDiff(db ctxcdb.KeyValueReader, hash common.Hash, number uint64) bool {
+	ok, _ := db.Has(stateDiffKey(number, hash))
+	return ok
+}
+
+// WriteStateDiff stores the RLP encoded state diff of a block into the database.
+func WriteStateDiff(db ctxcdb.KeyValueWriter, hash common.Hash, number uint64, diff []byte) {
+	if err := db.Put(stateDiffKey(number, hash), diff); err != nil {
+		log.Crit("Failed to store state diff", "err", err)
+	}
+}
+
+// DeleteStateDiff removes the state diff of a block from the database.
+func DeleteStateDiff(db ctxcdb.KeyValueWriter, hash common.Hash, number uint64) {
+	if err := db.Delete(stateDiffKey(number, hash)); err != nil {
+		log.Crit("Failed to delete state diff", "err", err)
+	}
+}
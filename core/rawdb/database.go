@@ -21,6 +21,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"sync/atomic"
 	"time"
 
@@ -238,6 +239,77 @@ func NewLevelDBDatabaseWithFreezer(file string, cache int, handles int, freezer
 	return frdb, nil
 }
 
+const (
+	dbPebble  = "pebble"
+	dbLeveldb = "leveldb"
+)
+
+// PreexistingDatabase checks the given data directory whether a database is already
+// instantiated at that location, and if so, returns the type of database (or the
+// empty string).
+func PreexistingDatabase(path string) string {
+	if _, err := os.Stat(filepath.Join(path, "CURRENT")); err != nil {
+		return "" // No pre-existing db
+	}
+	if matches, err := filepath.Glob(filepath.Join(path, "OPTIONS*")); len(matches) > 0 || err != nil {
+		if err != nil {
+			panic(err) // only possible if the pattern is malformed
+		}
+		return dbPebble
+	}
+	return dbLeveldb
+}
+
+// OpenOptions contains the options to apply when opening a database.
+type OpenOptions struct {
+	Type              string // Database engine, "leveldb" or "pebble" (empty = autodetect)
+	Directory         string // Directory of the key-value store
+	AncientsDirectory string // Directory of the chain freezer (empty = no freezer)
+	Namespace         string // Prefix for the database metrics
+	Cache             int    // Capacity (in megabytes) of the data caching
+	Handles           int    // Number of files to be open simultaneously
+	ReadOnly          bool   // Whether to open the database read only (pebble only)
+}
+
+// openKeyValueDatabase opens a disk-based key-value database, e.g. leveldb or
+// pebble. If no engine is requested, the one of a pre-existing database is used,
+// defaulting to leveldb for fresh data directories. Requesting an engine other
+// than that of the pre-existing database is an error.
+func openKeyValueDatabase(o OpenOptions) (ctxcdb.KeyValueStore, error) {
+	existingDb := PreexistingDatabase(o.Directory)
+	if len(existingDb) != 0 && len(o.Type) != 0 && o.Type != existingDb {
+		return nil, fmt.Errorf("db.engine choice was %v but found pre-existing %v database in specified data directory", o.Type, existingDb)
+	}
+	if o.Type == dbPebble || existingDb == dbPebble {
+		log.Info("Using pebble as the backing database")
+		return newPebbleKeyValueStore(o.Directory, o.Cache, o.Handles, o.Namespace, o.ReadOnly)
+	}
+	if len(o.Type) != 0 && o.Type != dbLeveldb {
+		return nil, fmt.Errorf("unknown db.engine %v", o.Type)
+	}
+	log.Info("Using leveldb as the backing database")
+	return leveldb.New(o.Directory, o.Cache, o.Handles, o.Namespace)
+}
+
+// Open opens a disk-based key-value database such as leveldb or pebble, also
+// attaching a chain freezer to it if an ancients directory has been set in the
+// provided options.
+func Open(o OpenOptions) (ctxcdb.Database, error) {
+	kvdb, err := openKeyValueDatabase(o)
+	if err != nil {
+		return nil, err
+	}
+	if len(o.AncientsDirectory) == 0 {
+		return NewDatabase(kvdb), nil
+	}
+	frdb, err := NewDatabaseWithFreezer(kvdb, o.AncientsDirectory, o.Namespace)
+	if err != nil {
+		kvdb.Close()
+		return nil, err
+	}
+	return frdb, nil
+}
+
 type counter uint64
 
 func (c counter) String() string {
@@ -294,6 +366,7 @@ func InspectDatabase(db ctxcdb.Database) error {
 		preimages       stat
 		bloomBits       stat
 		cliqueSnaps     stat
+		stateDiffs      stat
 
 		// Ancient store statistics
 		ancientHeadersSize  common.StorageSize
@@ -339,6 +412,8 @@ func InspectDatabase(db ctxcdb.Database) error {
 			codes.Add(size)
 		case bytes.HasPrefix(key, txLookupPrefix) && len(key) == (len(txLookupPrefix)+common.HashLength):
 			txLookups.Add(size)
+		case bytes.HasPrefix(key, stateDiffPrefix) && len(key) == (len(stateDiffPrefix)+8+common.HashLength):
+			stateDiffs.Add(size)
 		case bytes.HasPrefix(key, SnapshotAccountPrefix) && len(key) == (len(SnapshotAccountPrefix)+common.HashLength):
 			accountSnaps.Add(size)
 		case bytes.HasPrefix(key, SnapshotStoragePrefix) && len(key) == (len(SnapshotStoragePrefix)+2*common.HashLength):
@@ -400,6 +475,7 @@ func InspectDatabase(db ctxcdb.Database) error {
 		{"Key-Value store", "Trie preimages", preimages.Size(), preimages.Count()},
 		{"Key-Value store", "Account snapshot", accountSnaps.Size(), accountSnaps.Count()},
 		{"Key-Value store", "Storage snapshot", storageSnaps.Size(), storageSnaps.Count()},
+		{"Key-Value store", "State diffs", stateDiffs.Size(), stateDiffs.Count()},
 		{"Key-Value store", "Clique snapshots", cliqueSnaps.Size(), cliqueSnaps.Count()},
 		{"Key-Value store", "Singleton metadata", metadata.Size(), metadata.Count()},
 		{"Ancient store", "Headers", ancientHeadersSize.String(), ancients.String()},
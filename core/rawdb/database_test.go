@@ -0,0 +1,67 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"testing"
+)
+
+// Tests that the engine of a pre-existing database is detected and used when
+// no explicit choice is made, and that conflicting choices are rejected.
+func TestOpenDetectsEngine(t *testing.T) {
+	engines := []string{dbLeveldb}
+	if PebbleEnabled {
+		engines = append(engines, dbPebble)
+	}
+	for _, engine := range engines {
+		dir := t.TempDir()
+		if have := PreexistingDatabase(dir); have != "" {
+			t.Fatalf("%s: engine detected in empty directory: %q", engine, have)
+		}
+		db, err := Open(OpenOptions{Type: engine, Directory: dir})
+		if err != nil {
+			t.Fatalf("%s: failed to create database: %v", engine, err)
+		}
+		if err := db.Put([]byte("key"), []byte("value")); err != nil {
+			t.Fatalf("%s: failed to write: %v", engine, err)
+		}
+		db.Close()
+
+		if have := PreexistingDatabase(dir); have != engine {
+			t.Fatalf("engine mismatch: have %q, want %q", have, engine)
+		}
+		// Reopen without an explicit engine, the existing one should be used
+		db, err = Open(OpenOptions{Directory: dir})
+		if err != nil {
+			t.Fatalf("%s: failed to reopen database: %v", engine, err)
+		}
+		if val, err := db.Get([]byte("key")); err != nil || string(val) != "value" {
+			t.Fatalf("%s: value mismatch: have %q, %v", engine, val, err)
+		}
+		db.Close()
+
+		// Reopen with a different engine, which should be refused
+		other := dbPebble
+		if engine == dbPebble {
+			other = dbLeveldb
+		}
+		if db, err := Open(OpenOptions{Type: other, Directory: dir}); err == nil {
+			db.Close()
+			t.Fatalf("%s: opened with conflicting engine %s", engine, other)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !arm64 && !amd64
+// +build !arm64,!amd64
+
+package rawdb
+
+import (
+	"errors"
+
+	"github.com/CortexFoundation/CortexTheseus/ctxcdb"
+)
+
+// PebbleEnabled reports whether the pebble database engine is available on
+// the current platform.
+const PebbleEnabled = false
+
+// errPebbleUnsupported is returned when a pebble database is requested on a
+// platform the engine doesn't support.
+var errPebbleUnsupported = errors.New("db.engine 'pebble' not supported on this platform")
+
+// NewPebbleDBDatabase is unavailable on this platform, always failing.
+func NewPebbleDBDatabase(file string, cache int, handles int, namespace string, readonly bool) (ctxcdb.Database, error) {
+	return nil, errPebbleUnsupported
+}
+
+// newPebbleKeyValueStore is unavailable on this platform, always failing.
+func newPebbleKeyValueStore(file string, cache int, handles int, namespace string, readonly bool) (ctxcdb.KeyValueStore, error) {
+	return nil, errPebbleUnsupported
+}
@@ -137,6 +137,11 @@ func newFreezer(datadir string, namespace string) (*freezer, error) {
 // Close terminates the chain freezer, unmapping all the data files.
 func (f *freezer) Close() error {
 	f.quit <- struct{}{}
+	return f.closeIdle()
+}
+
+// closeIdle closes a freezer whose background freezing loop was never started.
+func (f *freezer) closeIdle() error {
 	var errs []error
 	for _, table := range f.tables {
 		if err := table.Close(); err != nil {
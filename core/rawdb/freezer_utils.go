@@ -0,0 +1,460 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/golang/snappy"
+	"github.com/ucwong/tsdb/fileutil"
+)
+
+// FreezerTableInfo contains the statistics and the structural issues of a
+// single chain freezer table.
+type FreezerTableInfo struct {
+	Name       string   // Name of the table (headers, hashes, bodies, receipts, diffs)
+	Compressed bool     // Whether the items are snappy compressed
+	Items      uint64   // Number of consistently stored items, including the ones deleted from the tail
+	Tail       uint64   // Number of items deleted from the tail
+	Files      int      // Number of data files
+	Size       uint64   // Total size of the index and data files
+	Issues     []string // Structural problems found in the index and data files
+}
+
+// freezerTableReader is a read-only view of a freezer table, operating on the
+// raw files without repairing (i.e. modifying) them like the live tables do
+// when opened.
+type freezerTableReader struct {
+	info    *FreezerTableInfo
+	path    string
+	index   []indexEntry        // Index entries of the consistently stored items
+	entries int                 // Number of index entries in the index file
+	files   map[uint32]*os.File // Open data files of the table
+}
+
+// openFreezerTableReader opens a freezer table read-only, validating the index
+// against the data files and recording any inconsistency found.
+func openFreezerTableReader(path string, name string, noCompression bool) (*freezerTableReader, error) {
+	t := &freezerTableReader{
+		info:  &FreezerTableInfo{Name: name, Compressed: !noCompression},
+		path:  path,
+		files: make(map[uint32]*os.File),
+	}
+	issue := func(format string, args ...interface{}) {
+		t.info.Issues = append(t.info.Issues, fmt.Sprintf(format, args...))
+	}
+	// Load and sanity check the entire index
+	blob, err := ioutil.ReadFile(filepath.Join(path, t.indexName()))
+	if os.IsNotExist(err) {
+		issue("missing index file")
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.info.Size += uint64(len(blob))
+	if overflow := len(blob) % indexEntrySize; overflow != 0 {
+		issue("%d dangling bytes at the end of the index", overflow)
+		blob = blob[:len(blob)-overflow]
+	}
+	if len(blob) == 0 {
+		issue("empty index file")
+		return t, nil
+	}
+	index := make([]indexEntry, len(blob)/indexEntrySize)
+	for i := range index {
+		index[i].unmarshalBinary(blob[i*indexEntrySize:])
+	}
+	t.entries = len(index)
+
+	// The first entry carries the tail position, all subsequent ones must point
+	// monotonically forward either within a data file or into the next one
+	t.info.Tail = uint64(index[0].offset)
+	for i := 2; i < len(index); i++ {
+		prev, cur := index[i-1], index[i]
+		if cur.filenum == prev.filenum && cur.offset >= prev.offset {
+			continue
+		}
+		if cur.filenum == prev.filenum+1 {
+			continue
+		}
+		issue("index entry %d (file %d, offset %d) out of order after file %d, offset %d", i, cur.filenum, cur.offset, prev.filenum, prev.offset)
+		index = index[:i]
+		break
+	}
+	// Open all the data files and ensure they contain the indexed data
+	tailID, headID := index[0].filenum, index[len(index)-1].filenum
+	ends := make(map[uint32]uint32)
+	for _, entry := range index[1:] {
+		ends[entry.filenum] = entry.offset
+	}
+	for num := tailID; num <= headID; num++ {
+		f, err := os.Open(filepath.Join(path, t.dataName(num)))
+		if err != nil {
+			issue("missing data file %d", num)
+			index = t.truncateBefore(index, num)
+			break
+		}
+		t.files[num] = f
+		t.info.Files++
+
+		stat, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		t.info.Size += uint64(stat.Size())
+		if end := int64(ends[num]); stat.Size() < end {
+			issue("data file %d truncated, %d bytes indexed but %d stored", num, end, stat.Size())
+			index = t.truncateAfter(index, num, uint32(stat.Size()))
+			break
+		} else if stat.Size() > end && num == headID {
+			issue("%d dangling bytes at the end of data file %d", stat.Size()-end, num)
+		}
+	}
+	// Report any leftover data files beyond the head
+	for num := headID + 1; ; num++ {
+		if _, err := os.Stat(filepath.Join(path, t.dataName(num))); err != nil {
+			break
+		}
+		issue("dangling data file %d beyond the head", num)
+	}
+	t.index = index
+	t.info.Items = t.info.Tail + uint64(len(index)-1)
+	return t, nil
+}
+
+// indexName returns the file name of the table's index.
+func (t *freezerTableReader) indexName() string {
+	if t.info.Compressed {
+		return fmt.Sprintf("%s.cidx", t.info.Name)
+	}
+	return fmt.Sprintf("%s.ridx", t.info.Name)
+}
+
+// dataName returns the file name of the table's data file with the given number.
+func (t *freezerTableReader) dataName(num uint32) string {
+	if t.info.Compressed {
+		return fmt.Sprintf("%s.%04d.cdat", t.info.Name, num)
+	}
+	return fmt.Sprintf("%s.%04d.rdat", t.info.Name, num)
+}
+
+// truncateBefore drops all the index entries pointing into the given data file
+// or any later one.
+func (t *freezerTableReader) truncateBefore(index []indexEntry, num uint32) []indexEntry {
+	for i := 1; i < len(index); i++ {
+		if index[i].filenum >= num {
+			return index[:i]
+		}
+	}
+	return index
+}
+
+// truncateAfter drops all the index entries pointing beyond the given size of
+// the given data file, or into any later one.
+func (t *freezerTableReader) truncateAfter(index []indexEntry, num uint32, size uint32) []indexEntry {
+	for i := 1; i < len(index); i++ {
+		if index[i].filenum > num || (index[i].filenum == num && index[i].offset > size) {
+			return index[:i]
+		}
+	}
+	return index
+}
+
+// retrieve returns the decompressed item with the given number.
+func (t *freezerTableReader) retrieve(item uint64) ([]byte, error) {
+	if item < t.info.Tail || item >= t.info.Items {
+		return nil, errOutOfBounds
+	}
+	pos := item - t.info.Tail
+	var (
+		start uint32
+		end   = t.index[pos+1]
+	)
+	if pos > 0 && t.index[pos].filenum == end.filenum {
+		start = t.index[pos].offset
+	}
+	f, ok := t.files[end.filenum]
+	if !ok {
+		return nil, fmt.Errorf("missing data file %d", end.filenum)
+	}
+	blob := make([]byte, end.offset-start)
+	if _, err := f.ReadAt(blob, int64(start)); err != nil {
+		return nil, err
+	}
+	if !t.info.Compressed {
+		return blob, nil
+	}
+	return snappy.Decode(nil, blob)
+}
+
+// close releases all the open data files of the table.
+func (t *freezerTableReader) close() {
+	for _, f := range t.files {
+		f.Close()
+	}
+}
+
+// FreezerReader is a read-only view of a chain freezer, used for offline
+// inspection and verification of the ancient data. Contrary to the live
+// freezer, it never repairs (i.e. modifies) the files when opened.
+type FreezerReader struct {
+	tables map[string]*freezerTableReader
+	lock   fileutil.Releaser
+}
+
+// OpenFreezerReader opens the chain freezer in the given directory read-only.
+// The freezer is locked while open, so it fails if a node is running on it.
+func OpenFreezerReader(dir string) (*FreezerReader, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	lock, _, err := fileutil.Flock(filepath.Join(dir, "FLOCK"))
+	if err != nil {
+		return nil, err
+	}
+	reader := &FreezerReader{
+		tables: make(map[string]*freezerTableReader),
+		lock:   lock,
+	}
+	for name, disableSnappy := range freezerNoSnappy {
+		table, err := openFreezerTableReader(dir, name, disableSnappy)
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+		reader.tables[name] = table
+	}
+	return reader, nil
+}
+
+// Tables returns the statistics and structural issues of all the tables,
+// ordered by name.
+func (f *FreezerReader) Tables() []*FreezerTableInfo {
+	infos := make([]*FreezerTableInfo, 0, len(f.tables))
+	for _, table := range f.tables {
+		infos = append(infos, table.info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// Ancients returns the number of items consistently stored across all tables.
+func (f *FreezerReader) Ancients() uint64 {
+	items := uint64(math.MaxUint64)
+	for _, table := range f.tables {
+		if table.info.Items < items {
+			items = table.info.Items
+		}
+	}
+	return items
+}
+
+// Tail returns the number of items deleted from the tail of any table.
+func (f *FreezerReader) Tail() uint64 {
+	var tail uint64
+	for _, table := range f.tables {
+		if table.info.Tail > tail {
+			tail = table.info.Tail
+		}
+	}
+	return tail
+}
+
+// Ancient retrieves an ancient binary blob from the given table.
+func (f *FreezerReader) Ancient(kind string, number uint64) ([]byte, error) {
+	table := f.tables[kind]
+	if table == nil {
+		return nil, errUnknownTable
+	}
+	return table.retrieve(number)
+}
+
+// DumpIndex writes the index entries of the given table for the items start to
+// end (inclusive) into w, in the form of the data file and the end offset of
+// each item within it.
+func (f *FreezerReader) DumpIndex(w io.Writer, kind string, start, end uint64) error {
+	table := f.tables[kind]
+	if table == nil {
+		return errUnknownTable
+	}
+	if start < table.info.Tail || end >= table.info.Items || start > end {
+		return errOutOfBounds
+	}
+	fmt.Fprintf(w, "| number | fileno | offset |\n")
+	fmt.Fprintf(w, "|--------|--------|--------|\n")
+	for item := start; item <= end; item++ {
+		entry := table.index[item-table.info.Tail+1]
+		fmt.Fprintf(w, "| %06d | %06d | %08d |\n", item, entry.filenum, entry.offset)
+	}
+	return nil
+}
+
+// Close releases all the open files and the lock of the freezer.
+func (f *FreezerReader) Close() error {
+	for _, table := range f.tables {
+		table.close()
+	}
+	return f.lock.Release()
+}
+
+// RepairFreezer truncates all tables of the chain freezer in the given directory
+// to the given number of items (or less, if not that many are consistently
+// stored), dropping any corrupted or dangling data. The number of items left in
+// the freezer is returned.
+func RepairFreezer(dir string, items uint64) (uint64, error) {
+	reader, err := OpenFreezerReader(dir)
+	if err != nil {
+		return 0, err
+	}
+	if frozen := reader.Ancients(); items > frozen {
+		items = frozen
+	}
+	if tail := reader.Tail(); items < tail {
+		reader.Close()
+		return 0, fmt.Errorf("cannot truncate below the tail of the freezer: %d < %d", items, tail)
+	}
+	// Drop the index entries beyond the requested length, as well as all the data
+	// files not referenced any more. The freezer repair on open takes care of the
+	// dangling data at the end of the new head files.
+	for _, table := range reader.tables {
+		if table.entries == 0 {
+			continue
+		}
+		keep := items - table.info.Tail + 1
+		if uint64(table.entries) > keep {
+			log.Warn("Truncating freezer table", "table", table.info.Name, "items", table.info.Tail+uint64(table.entries)-1, "limit", items)
+			if err := os.Truncate(filepath.Join(dir, table.indexName()), int64(keep*indexEntrySize)); err != nil {
+				reader.Close()
+				return 0, err
+			}
+		}
+		head := table.index[keep-1].filenum
+		for num := head + 1; ; num++ {
+			name := filepath.Join(dir, table.dataName(num))
+			if _, err := os.Stat(name); err != nil {
+				break
+			}
+			log.Warn("Removing dangling freezer data file", "table", table.info.Name, "file", num)
+			if err := os.Remove(name); err != nil {
+				reader.Close()
+				return 0, err
+			}
+		}
+	}
+	if err := reader.Close(); err != nil {
+		return 0, err
+	}
+	// Open the live freezer to run its own repair, then release it again
+	frdb, err := newFreezer(dir, "")
+	if err != nil {
+		return 0, err
+	}
+	frozen, _ := frdb.Ancients()
+	return frozen, frdb.closeIdle()
+}
+
+// MoveFreezer relocates the chain freezer from the src directory into the dst
+// one, which must not exist yet. The directory is renamed if possible, or else
+// copied over and removed afterwards.
+func MoveFreezer(src string, dst string) error {
+	if _, err := os.Stat(dst); err == nil {
+		return fmt.Errorf("destination %s already exists", dst)
+	}
+	lock, _, err := fileutil.Flock(filepath.Join(src, "FLOCK"))
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	err = os.Rename(src, dst)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	// The destination is on a different device, copy the files over
+	log.Info("Copying freezer to a different device", "src", src, "dst", dst)
+	files, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.IsDir() {
+			return fmt.Errorf("unexpected directory %s in freezer", file.Name())
+		}
+		if file.Name() == "FLOCK" {
+			continue
+		}
+		if err := copyFreezerFile(filepath.Join(src, file.Name()), filepath.Join(dst, file.Name())); err != nil {
+			return err
+		}
+	}
+	// All files copied and flushed, delete the originals
+	for _, file := range files {
+		if file.Name() == "FLOCK" {
+			continue
+		}
+		if err := os.Remove(filepath.Join(src, file.Name())); err != nil {
+			return err
+		}
+	}
+	lock.Release()
+	if err := os.Remove(filepath.Join(src, "FLOCK")); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// copyFreezerFile copies a single freezer file, flushing it to disk.
+func copyFreezerFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
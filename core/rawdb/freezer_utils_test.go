@@ -0,0 +1,181 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestFreezer creates a chain freezer in a temporary directory and fills it
+// with the given number of items.
+func newTestFreezer(t *testing.T, items int) string {
+	dir, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := newFreezer(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < items; i++ {
+		if err := f.AppendAncient(uint64(i), getChunk(32, i), getChunk(20, i), getChunk(30, i), getChunk(10, i), getChunk(1, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.closeIdle(); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// Tests that the freezer reader retrieves all the items of a healthy freezer
+// without reporting any issues.
+func TestFreezerReader(t *testing.T) {
+	dir := newTestFreezer(t, 16)
+	defer os.RemoveAll(dir)
+
+	reader, err := OpenFreezerReader(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	if n := reader.Ancients(); n != 16 {
+		t.Fatalf("ancients mismatch: have %d, want %d", n, 16)
+	}
+	for _, table := range reader.Tables() {
+		if len(table.Issues) != 0 {
+			t.Errorf("table %s: unexpected issues: %v", table.Name, table.Issues)
+		}
+	}
+	for i := 0; i < 16; i++ {
+		blob, err := reader.Ancient(freezerBodiesTable, uint64(i))
+		if err != nil {
+			t.Fatalf("item %d: failed to retrieve body: %v", i, err)
+		}
+		if !bytes.Equal(blob, getChunk(30, i)) {
+			t.Fatalf("item %d: body mismatch: have %x, want %x", i, blob, getChunk(30, i))
+		}
+	}
+	if _, err := reader.Ancient(freezerBodiesTable, 16); err != errOutOfBounds {
+		t.Fatalf("out of bounds retrieval error mismatch: have %v, want %v", err, errOutOfBounds)
+	}
+	// Ensure the index is dumped with the data file offsets
+	var dump bytes.Buffer
+	if err := reader.DumpIndex(&dump, freezerHashTable, 1, 2); err != nil {
+		t.Fatalf("failed to dump index: %v", err)
+	}
+	if !strings.Contains(dump.String(), "| 000001 | 000000 | 00000064 |") || !strings.Contains(dump.String(), "| 000002 | 000000 | 00000096 |") {
+		t.Fatalf("index dump mismatch:\n%s", dump.String())
+	}
+	// Ensure the freezer is locked while the reader is open
+	if _, err := newFreezer(dir, ""); err == nil {
+		t.Fatalf("opened freezer locked by the reader")
+	}
+}
+
+// Tests that a truncated data file is detected by the reader and that repairing
+// the freezer truncates all the tables to a consistent length.
+func TestFreezerRepair(t *testing.T) {
+	dir := newTestFreezer(t, 16)
+	defer os.RemoveAll(dir)
+
+	// Chop off the last two and a half hashes
+	name := filepath.Join(dir, "hashes.0000.rdat")
+	stat, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(name, stat.Size()-2*32-10); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := OpenFreezerReader(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := reader.Ancients(); n != 13 {
+		t.Fatalf("ancients mismatch: have %d, want %d", n, 13)
+	}
+	for _, table := range reader.Tables() {
+		if table.Name == freezerHashTable && len(table.Issues) == 0 {
+			t.Errorf("table %s: missing truncation issue", table.Name)
+		}
+		if table.Name != freezerHashTable && len(table.Issues) != 0 {
+			t.Errorf("table %s: unexpected issues: %v", table.Name, table.Issues)
+		}
+	}
+	reader.Close()
+
+	// Repair the freezer to an even shorter length and ensure it's consistent
+	frozen, err := RepairFreezer(dir, 10)
+	if err != nil {
+		t.Fatalf("failed to repair freezer: %v", err)
+	}
+	if frozen != 10 {
+		t.Fatalf("repaired ancients mismatch: have %d, want %d", frozen, 10)
+	}
+	if reader, err = OpenFreezerReader(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	for _, table := range reader.Tables() {
+		if table.Items != 10 {
+			t.Errorf("table %s: items mismatch: have %d, want %d", table.Name, table.Items, 10)
+		}
+		if len(table.Issues) != 0 {
+			t.Errorf("table %s: unexpected issues: %v", table.Name, table.Issues)
+		}
+	}
+}
+
+// Tests that the freezer can be moved into a new directory.
+func TestMoveFreezer(t *testing.T) {
+	dir := newTestFreezer(t, 4)
+	defer os.RemoveAll(dir)
+
+	dst, err := ioutil.TempDir("", "freezer-moved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	if err := MoveFreezer(dir, dst); err == nil {
+		t.Fatalf("moved freezer into existing directory")
+	}
+	dst = filepath.Join(dst, "ancient")
+	if err := MoveFreezer(dir, dst); err != nil {
+		t.Fatalf("failed to move freezer: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("source freezer still present: %v", err)
+	}
+	reader, err := OpenFreezerReader(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	if n := reader.Ancients(); n != 4 {
+		t.Fatalf("ancients mismatch: have %d, want %d", n, 4)
+	}
+}
@@ -90,8 +90,9 @@ type (
 		account *common.Address
 	}
 	resetObjectChange struct {
-		prev         *stateObject
-		prevdestruct bool
+		prev             *stateObject
+		prevdestruct     bool
+		prevdiffdestruct bool
 	}
 	suicideChange struct {
 		account     *common.Address
@@ -164,6 +165,9 @@ func (ch resetObjectChange) revert(s *StateDB) {
 	if !ch.prevdestruct && s.snap != nil {
 		delete(s.snapDestructs, ch.prev.addrHash)
 	}
+	if !ch.prevdiffdestruct && s.diffDestructs != nil {
+		delete(s.diffDestructs, ch.prev.address)
+	}
 }
 
 func (ch resetObjectChange) dirtied() *common.Address {
@@ -0,0 +1,127 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package pruner
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
+	"github.com/CortexFoundation/CortexTheseus/core/state"
+	"github.com/CortexFoundation/CortexTheseus/core/state/snapshot"
+	"github.com/CortexFoundation/CortexTheseus/ctxcdb"
+)
+
+// commitState applies the given balances and codes on top of root and flushes
+// the resulting state into the database.
+func commitState(t *testing.T, db ctxcdb.Database, root common.Hash, balances map[common.Address]int64, codes map[common.Address][]byte) common.Hash {
+	sdb := state.NewDatabase(db)
+	statedb, err := state.New(root, sdb, nil)
+	if err != nil {
+		t.Fatalf("failed to open state: %v", err)
+	}
+	for addr, balance := range balances {
+		statedb.SetBalance(addr, big.NewInt(balance))
+	}
+	for addr, code := range codes {
+		statedb.SetCode(addr, code)
+	}
+	root, err = statedb.Commit(false)
+	if err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	if err := sdb.TrieDB().Commit(root, false, nil); err != nil {
+		t.Fatalf("failed to flush state: %v", err)
+	}
+	return root
+}
+
+// Tests that pruning deletes the trie nodes of stale states while keeping the
+// target state, including its contract code, fully accessible.
+func TestPrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pruner-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		db    = rawdb.NewMemoryDatabase()
+		alice = common.HexToAddress("0x01")
+		bob   = common.HexToAddress("0x02")
+		code  = []byte{0x60, 0x00, 0x60, 0x00, 0xf3}
+	)
+	stale := commitState(t, db, common.Hash{}, map[common.Address]int64{alice: 1, bob: 2}, nil)
+	live := commitState(t, db, stale, map[common.Address]int64{alice: 3}, map[common.Address][]byte{bob: code})
+
+	snaptree, err := snapshot.New(db, state.NewDatabase(db).TrieDB(), 16, live, false, true, false)
+	if err != nil {
+		t.Fatalf("failed to create snapshot: %v", err)
+	}
+	bloom, err := newStateBloomWithSize(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := snapshot.GenerateTrie(snaptree, live, db, bloom); err != nil {
+		t.Fatalf("failed to generate trie: %v", err)
+	}
+	bloomPath := bloomFilterName(dir, live)
+	if err := bloom.Commit(bloomPath, bloomPath+stateBloomFileTempSuffix); err != nil {
+		t.Fatalf("failed to commit bloom: %v", err)
+	}
+	if path, root, _ := findBloomFilter(dir); path != bloomPath || root != live {
+		t.Fatalf("bloom filter lookup mismatch: have %s %x, want %s %x", path, root, bloomPath, live)
+	}
+	if err := prune(snaptree, live, db, bloom, bloomPath, nil, time.Now()); err != nil {
+		t.Fatalf("failed to prune: %v", err)
+	}
+	if blob := rawdb.ReadTrieNode(db, stale); len(blob) != 0 {
+		t.Errorf("stale state root not pruned")
+	}
+	if _, err := os.Stat(filepath.Join(dir, filepath.Base(bloomPath))); !os.IsNotExist(err) {
+		t.Errorf("bloom filter not removed after pruning: %v", err)
+	}
+	statedb, err := state.New(live, state.NewDatabase(db), nil)
+	if err != nil {
+		t.Fatalf("failed to open pruned state: %v", err)
+	}
+	if balance := statedb.GetBalance(alice); balance.Int64() != 3 {
+		t.Errorf("balance mismatch: have %v, want 3", balance)
+	}
+	if balance := statedb.GetBalance(bob); balance.Int64() != 2 {
+		t.Errorf("balance mismatch: have %v, want 2", balance)
+	}
+	if have := statedb.GetCode(bob); !bytes.Equal(have, code) {
+		t.Errorf("code mismatch: have %x, want %x", have, code)
+	}
+	tr, err := statedb.Database().OpenTrie(live)
+	if err != nil {
+		t.Fatalf("failed to open pruned trie: %v", err)
+	}
+	it := tr.NodeIterator(nil)
+	for it.Next(true) {
+	}
+	if err := it.Error(); err != nil {
+		t.Errorf("pruned state incomplete: %v", err)
+	}
+}
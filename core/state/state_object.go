@@ -355,6 +355,15 @@ func (s *stateObject) updateTrie(db Database) Trie {
 			}
 			storage[crypto.HashData(hasher, key[:])] = v // v will be nil if value is 0x00
 		}
+		// If state diffing is active, track the raw slot change
+		if s.db.diffStorage != nil {
+			slots := s.db.diffStorage[s.address]
+			if slots == nil {
+				slots = make(map[common.Hash]common.Hash)
+				s.db.diffStorage[s.address] = slots
+			}
+			slots[key] = value
+		}
 		usedStorage = append(usedStorage, common.CopyBytes(key[:])) // Copy needed for closure
 	}
 	if s.db.prefetcher != nil {
@@ -79,6 +79,11 @@ type StateDB struct {
 	snapAccounts  map[common.Hash][]byte
 	snapStorage   map[common.Hash]map[common.Hash][]byte
 
+	// State diff tracking, only active if enabled via EnableDiffs
+	diffDestructs map[common.Address]struct{}
+	diffAccounts  map[common.Address]*DiffAccount
+	diffStorage   map[common.Address]map[common.Hash]common.Hash
+
 	// This map holds 'live' objects, which will get modified while processing a state transition.
 	stateObjects        map[common.Address]*stateObject
 	stateObjectsPending map[common.Address]struct{} // State objects finalized but not yet written to the trie
@@ -600,6 +605,10 @@ func (s *StateDB) updateStateObject(obj *stateObject) {
 	if s.snap != nil {
 		s.snapAccounts[obj.addrHash] = snapshot.SlimAccountRLP(obj.data.Nonce, obj.data.Balance, obj.data.Root, obj.data.CodeHash, obj.data.Upload, obj.data.Num)
 	}
+	// If state diffing is active, track the new account data too
+	if s.diffAccounts != nil {
+		s.diffAccounts[addr] = newDiffAccount(addr, &obj.data)
+	}
 }
 
 // deleteStateObject removes the given object from the state trie.
@@ -707,19 +716,25 @@ func (s *StateDB) GetOrNewStateObject(addr common.Address) *stateObject {
 func (s *StateDB) createObject(addr common.Address) (newobj, prev *stateObject) {
 	prev = s.getDeletedStateObject(addr) // Note, prev might have been deleted, we need that!
 
-	var prevdestruct bool
+	var prevdestruct, prevdiffdestruct bool
 	if s.snap != nil && prev != nil {
 		_, prevdestruct = s.snapDestructs[prev.addrHash]
 		if !prevdestruct {
 			s.snapDestructs[prev.addrHash] = struct{}{}
 		}
 	}
+	if s.diffDestructs != nil && prev != nil {
+		_, prevdiffdestruct = s.diffDestructs[addr]
+		if !prevdiffdestruct {
+			s.diffDestructs[addr] = struct{}{}
+		}
+	}
 	newobj = newObject(s, addr, Account{})
 	newobj.setNonce(0) // sets the object to dirty
 	if prev == nil {
 		s.journal.append(createObjectChange{account: &addr})
 	} else {
-		s.journal.append(resetObjectChange{prev: prev, prevdestruct: prevdestruct})
+		s.journal.append(resetObjectChange{prev: prev, prevdestruct: prevdestruct, prevdiffdestruct: prevdiffdestruct})
 	}
 	s.setStateObject(newobj)
 	if prev != nil && !prev.deleted {
@@ -848,6 +863,11 @@ func (s *StateDB) Copy() *StateDB {
 	if s.prefetcher != nil {
 		state.prefetcher = s.prefetcher.copy()
 	}
+	// If state diffing is active, carry over the changes tracked so far, since
+	// the copy is the one that will be committed (e.g. by the miner).
+	if s.diffDestructs != nil {
+		state.copyDiffs(s)
+	}
 	return state
 }
 
@@ -908,6 +928,13 @@ func (s *StateDB) Finalise(deleteEmptyObjects bool) {
 				delete(s.snapAccounts, obj.addrHash)       // Clear out any previously updated account data (may be recreated via a ressurrect)
 				delete(s.snapStorage, obj.addrHash)        // Clear out any previously updated storage data (may be recreated via a ressurrect)
 			}
+			// Same for the state diff, which needs to wipe the account before
+			// any ressurrection is applied on top.
+			if s.diffDestructs != nil {
+				s.diffDestructs[addr] = struct{}{}
+				delete(s.diffAccounts, addr)
+				delete(s.diffStorage, addr)
+			}
 		} else {
 			obj.finalise(true) // Prefetch slots in the background
 		}
@@ -0,0 +1,158 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+)
+
+// StateDiff is the compact set of state changes made by a single block. Applied
+// on top of the parent state, it reproduces the post-state of the block without
+// having to re-execute any of its transactions.
+type StateDiff struct {
+	Destructs []common.Address // Accounts wiped by the block (may be recreated below)
+	Accounts  []DiffAccount    // Accounts updated (or recreated) by the block
+}
+
+// DiffAccount is the post-state of an account modified within a block, along
+// with the storage slots that changed.
+type DiffAccount struct {
+	Address  common.Address
+	Nonce    uint64
+	Balance  *big.Int
+	CodeHash common.Hash
+	Upload   *big.Int
+	Num      *big.Int
+	Storage  []DiffSlot
+}
+
+// DiffSlot is a single storage slot changed within a block. A zero value means
+// the slot was cleared.
+type DiffSlot struct {
+	Key   common.Hash
+	Value common.Hash
+}
+
+// newDiffAccount creates a diff entry from the consensus fields of an account.
+func newDiffAccount(addr common.Address, data *Account) *DiffAccount {
+	return &DiffAccount{
+		Address:  addr,
+		Nonce:    data.Nonce,
+		Balance:  new(big.Int).Set(data.Balance),
+		CodeHash: common.BytesToHash(data.CodeHash),
+		Upload:   new(big.Int).Set(data.Upload),
+		Num:      new(big.Int).Set(data.Num),
+	}
+}
+
+// EnableDiffs starts tracking the account and storage changes made to the state,
+// which can be retrieved after commit via Diff.
+func (s *StateDB) EnableDiffs() {
+	s.diffDestructs = make(map[common.Address]struct{})
+	s.diffAccounts = make(map[common.Address]*DiffAccount)
+	s.diffStorage = make(map[common.Address]map[common.Hash]common.Hash)
+}
+
+// copyDiffs deep copies the tracked state changes of another state.
+func (s *StateDB) copyDiffs(src *StateDB) {
+	s.EnableDiffs()
+	for addr := range src.diffDestructs {
+		s.diffDestructs[addr] = struct{}{}
+	}
+	for addr, account := range src.diffAccounts {
+		cpy := *account
+		s.diffAccounts[addr] = &cpy
+	}
+	for addr, slots := range src.diffStorage {
+		cpy := make(map[common.Hash]common.Hash, len(slots))
+		for key, value := range slots {
+			cpy[key] = value
+		}
+		s.diffStorage[addr] = cpy
+	}
+}
+
+// Diff returns the state changes tracked since EnableDiffs was called, or nil
+// if diffing is not enabled. It should be called after the state was committed
+// (or at least hashed), since changes are only tracked when flushed into the
+// tries. The entries are sorted, so the encoding of the diff is deterministic.
+func (s *StateDB) Diff() *StateDiff {
+	if s.diffDestructs == nil {
+		return nil
+	}
+	diff := &StateDiff{
+		Destructs: make([]common.Address, 0, len(s.diffDestructs)),
+		Accounts:  make([]DiffAccount, 0, len(s.diffAccounts)),
+	}
+	for addr := range s.diffDestructs {
+		diff.Destructs = append(diff.Destructs, addr)
+	}
+	sort.Slice(diff.Destructs, func(i, j int) bool {
+		return bytes.Compare(diff.Destructs[i][:], diff.Destructs[j][:]) < 0
+	})
+	for addr, account := range s.diffAccounts {
+		entry := *account
+		for key, value := range s.diffStorage[addr] {
+			entry.Storage = append(entry.Storage, DiffSlot{Key: key, Value: value})
+		}
+		sort.Slice(entry.Storage, func(i, j int) bool {
+			return bytes.Compare(entry.Storage[i].Key[:], entry.Storage[j].Key[:]) < 0
+		})
+		diff.Accounts = append(diff.Accounts, entry)
+	}
+	sort.Slice(diff.Accounts, func(i, j int) bool {
+		return bytes.Compare(diff.Accounts[i].Address[:], diff.Accounts[j].Address[:]) < 0
+	})
+	return diff
+}
+
+// ApplyDiff applies the changes of a block on top of the state, which must be
+// the post-state of its parent. The result is finalised but not committed; the
+// caller is expected to verify IntermediateRoot against the block's root.
+func (s *StateDB) ApplyDiff(diff *StateDiff) error {
+	// Wipe all the destructed accounts first, the updates might ressurrect them
+	for _, addr := range diff.Destructs {
+		s.Suicide(addr)
+	}
+	s.Finalise(false)
+
+	for _, account := range diff.Accounts {
+		obj := s.GetOrNewStateObject(account.Address)
+		obj.SetNonce(account.Nonce)
+		obj.SetBalance(account.Balance)
+		obj.SetUpload(account.Upload)
+		obj.SetNum(account.Num)
+
+		if !bytes.Equal(obj.CodeHash(), account.CodeHash[:]) {
+			code, err := s.db.ContractCode(obj.addrHash, account.CodeHash)
+			if err != nil {
+				return fmt.Errorf("missing code %x of %x: %v", account.CodeHash, account.Address, err)
+			}
+			obj.SetCode(account.CodeHash, code)
+		}
+		for _, slot := range account.Storage {
+			obj.SetState(s.db, slot.Key, slot.Value)
+		}
+	}
+	s.Finalise(false)
+	return s.Error()
+}
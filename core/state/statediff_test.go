@@ -0,0 +1,120 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexFoundation library.
+//
+// The CortexFoundation library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexFoundation library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexFoundation library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
+	"github.com/CortexFoundation/CortexTheseus/rlp"
+)
+
+// Tests that the state diff tracked while modifying a state reproduces the
+// same post-state root when applied on top of the pre-state.
+func TestStateDiffRoundtrip(t *testing.T) {
+	var (
+		db    = NewDatabase(rawdb.NewMemoryDatabase())
+		addr1 = common.Address{0x01}
+		addr2 = common.Address{0x02}
+		addr3 = common.Address{0x03}
+	)
+	// Create a pre-state with a few accounts and storage slots
+	base, _ := New(common.Hash{}, db, nil)
+	base.SetBalance(addr1, big.NewInt(100))
+	base.SetState(addr1, common.Hash{0x01}, common.Hash{0x11})
+	base.SetState(addr1, common.Hash{0x02}, common.Hash{0x22})
+	base.SetBalance(addr2, big.NewInt(200))
+	base.SetState(addr2, common.Hash{0x01}, common.Hash{0x33})
+	root, err := base.Commit(false)
+	if err != nil {
+		t.Fatalf("failed to commit pre-state: %v", err)
+	}
+	db.TrieDB().Commit(root, false, nil)
+
+	// Modify the state in a few "transactions", tracking the changes
+	state, _ := New(root, db, nil)
+	state.EnableDiffs()
+
+	state.SetNonce(addr1, 1)
+	state.SetState(addr1, common.Hash{0x01}, common.Hash{})
+	state.SetState(addr1, common.Hash{0x03}, common.Hash{0x44})
+	state.SetUpload(addr1, big.NewInt(1024))
+	state.SetNum(addr1, big.NewInt(7))
+	state.Finalise(true)
+
+	state.Suicide(addr2)
+	state.Finalise(true)
+
+	state.SetBalance(addr2, big.NewInt(5)) // resurrect without the old storage
+	state.SetCode(addr3, []byte{0x60, 0x00})
+	state.SetState(addr3, common.Hash{0x05}, common.Hash{0x55})
+	want, err := state.Commit(true)
+	if err != nil {
+		t.Fatalf("failed to commit post-state: %v", err)
+	}
+	diff := state.Diff()
+	if len(diff.Destructs) != 1 || diff.Destructs[0] != addr2 {
+		t.Fatalf("destructs mismatch: have %x, want [%x]", diff.Destructs, addr2)
+	}
+	if len(diff.Accounts) != 3 {
+		t.Fatalf("account count mismatch: have %d, want 3", len(diff.Accounts))
+	}
+	// Push the diff through an encoding roundtrip and apply it on the pre-state
+	blob, err := rlp.EncodeToBytes(diff)
+	if err != nil {
+		t.Fatalf("failed to encode diff: %v", err)
+	}
+	var dec StateDiff
+	if err := rlp.DecodeBytes(blob, &dec); err != nil {
+		t.Fatalf("failed to decode diff: %v", err)
+	}
+	replay, _ := New(root, db, nil)
+	if err := replay.ApplyDiff(&dec); err != nil {
+		t.Fatalf("failed to apply diff: %v", err)
+	}
+	if have := replay.IntermediateRoot(false); have != want {
+		t.Fatalf("root mismatch: have %x, want %x", have, want)
+	}
+	if have := replay.GetState(addr2, common.Hash{0x01}); have != (common.Hash{}) {
+		t.Fatalf("resurrected storage not wiped: %x", have)
+	}
+	if have := replay.GetUpload(addr1); have.Cmp(big.NewInt(1024)) != 0 {
+		t.Fatalf("upload mismatch: have %v, want 1024", have)
+	}
+}
+
+// Tests that state diffs tracked before a copy are carried over into it.
+func TestStateDiffCopy(t *testing.T) {
+	state, _ := New(common.Hash{}, NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	state.EnableDiffs()
+
+	state.SetBalance(common.Address{0x01}, big.NewInt(1))
+	state.IntermediateRoot(false)
+
+	cpy := state.Copy()
+	cpy.SetBalance(common.Address{0x02}, big.NewInt(2))
+	cpy.IntermediateRoot(false)
+
+	if have := len(cpy.Diff().Accounts); have != 2 {
+		t.Fatalf("copied diff account count mismatch: have %d, want 2", have)
+	}
+	if have := len(state.Diff().Accounts); have != 1 {
+		t.Fatalf("original diff account count mismatch: have %d, want 1", have)
+	}
+}
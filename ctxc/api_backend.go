@@ -127,7 +127,7 @@ func (b *CortexAPIBackend) StateAndHeaderByNumber(ctx context.Context, blockNr r
 	if header == nil {
 		return nil, nil, errors.New("header not found")
 	}
-	stateDb, err := b.ctxc.BlockChain().StateAt(header.Root)
+	stateDb, err := b.ctxc.BlockChain().HistoricState(header)
 	if err != nil {
 		fmt.Println("StateAndHeaderByNumber error: ", err)
 	}
@@ -243,6 +243,14 @@ func (b *CortexAPIBackend) SuggestPrice(ctx context.Context) (*big.Int, error) {
 	return b.gpo.SuggestPrice(ctx)
 }
 
+func (b *CortexAPIBackend) SuggestPrices(ctx context.Context) (*gasprice.PriceSuggestion, error) {
+	return b.gpo.SuggestPrices(ctx)
+}
+
+func (b *CortexAPIBackend) FeeHistory(ctx context.Context, blockCount int, lastBlock rpc.BlockNumber, rewardPercentiles []float64) (*big.Int, [][]*big.Int, []float64, []float64, error) {
+	return b.gpo.FeeHistory(ctx, blockCount, lastBlock, rewardPercentiles)
+}
+
 func (b *CortexAPIBackend) ChainDb() ctxcdb.Database {
 	return b.ctxc.ChainDb()
 }
@@ -0,0 +1,114 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package ctxc
+
+import (
+	"errors"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
+	"github.com/CortexFoundation/CortexTheseus/ctxcdb"
+)
+
+// maxDatabaseRangeItems is the maximum number of entries returned by a single
+// database iteration request.
+const maxDatabaseRangeItems = 1024
+
+var errDatabaseKeyNotFound = errors.New("not found")
+
+// PrivateDatabaseAPI exposes read-only access to the raw key-value store and
+// the chain freezer of the node over the private debugging endpoint, allowing
+// inspection tools to operate on the database of a running node.
+type PrivateDatabaseAPI struct {
+	db ctxcdb.Database
+}
+
+// NewPrivateDatabaseAPI creates a new API definition for the read-only raw
+// database access methods.
+func NewPrivateDatabaseAPI(db ctxcdb.Database) *PrivateDatabaseAPI {
+	return &PrivateDatabaseAPI{db: db}
+}
+
+// DatabaseRange is a batch of consecutive database entries returned by the
+// iteration method. Next is the key (relative to the prefix) to continue the
+// iteration from, omitted if the iteration is exhausted.
+type DatabaseRange struct {
+	Keys   []hexutil.Bytes `json:"keys"`
+	Values []hexutil.Bytes `json:"values"`
+	Next   hexutil.Bytes   `json:"next,omitempty"`
+}
+
+// DbHas returns whether the given key is present in the key-value store.
+func (api *PrivateDatabaseAPI) DbHas(key hexutil.Bytes) (bool, error) {
+	return api.db.Has(key)
+}
+
+// DbGet returns the raw value of the given key in the key-value store.
+func (api *PrivateDatabaseAPI) DbGet(key hexutil.Bytes) (hexutil.Bytes, error) {
+	has, err := api.db.Has(key)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		return nil, errDatabaseKeyNotFound
+	}
+	return api.db.Get(key)
+}
+
+// DbIterate returns at most limit consecutive entries of the key-value store
+// having the given prefix, starting at the given key (relative to the prefix).
+func (api *PrivateDatabaseAPI) DbIterate(prefix hexutil.Bytes, start hexutil.Bytes, limit int) (*DatabaseRange, error) {
+	if limit <= 0 || limit > maxDatabaseRangeItems {
+		limit = maxDatabaseRangeItems
+	}
+	it := api.db.NewIterator(prefix, start)
+	defer it.Release()
+
+	res := &DatabaseRange{
+		Keys:   []hexutil.Bytes{},
+		Values: []hexutil.Bytes{},
+	}
+	for it.Next() {
+		if len(res.Keys) == limit {
+			res.Next = common.CopyBytes(it.Key()[len(prefix):])
+			break
+		}
+		res.Keys = append(res.Keys, common.CopyBytes(it.Key()))
+		res.Values = append(res.Values, common.CopyBytes(it.Value()))
+	}
+	if err := it.Error(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// DbAncient returns the raw ancient data of the given kind and number from the
+// chain freezer.
+func (api *PrivateDatabaseAPI) DbAncient(kind string, number uint64) (hexutil.Bytes, error) {
+	return api.db.Ancient(kind, number)
+}
+
+// DbAncients returns the number of items in the chain freezer.
+func (api *PrivateDatabaseAPI) DbAncients() (uint64, error) {
+	return api.db.Ancients()
+}
+
+// DbAncientSize returns the size of the given ancient data kind in the chain
+// freezer.
+func (api *PrivateDatabaseAPI) DbAncientSize(kind string) (uint64, error) {
+	return api.db.AncientSize(kind)
+}
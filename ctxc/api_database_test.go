@@ -0,0 +1,88 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package ctxc
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
+	"github.com/CortexFoundation/CortexTheseus/ctxcdb/remotedb"
+	"github.com/CortexFoundation/CortexTheseus/rpc"
+)
+
+// Tests that a remote database proxies reads and iterations to the database
+// API of a node, refusing all writes.
+func TestRemoteDatabase(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	for i := 0; i < 2500; i++ {
+		db.Put([]byte(fmt.Sprintf("a-%05d", i)), []byte(fmt.Sprintf("value-%d", i)))
+	}
+	db.Put([]byte("b-key"), []byte("b-value"))
+
+	server := rpc.NewServer()
+	defer server.Stop()
+	if err := server.RegisterName("debug", NewPrivateDatabaseAPI(db)); err != nil {
+		t.Fatalf("failed to register API: %v", err)
+	}
+	remote := remotedb.New(rpc.DialInProc(server))
+	defer remote.Close()
+
+	// Check single key lookups
+	if has, err := remote.Has([]byte("b-key")); err != nil || !has {
+		t.Fatalf("existing key not found: %v, %v", has, err)
+	}
+	if has, err := remote.Has([]byte("c-key")); err != nil || has {
+		t.Fatalf("missing key found: %v, %v", has, err)
+	}
+	if val, err := remote.Get([]byte("b-key")); err != nil || !bytes.Equal(val, []byte("b-value")) {
+		t.Fatalf("value mismatch: have %q, %v, want %q", val, err, "b-value")
+	}
+	if _, err := remote.Get([]byte("c-key")); err == nil {
+		t.Fatalf("missing key retrieved")
+	}
+	// Check that iterations spanning multiple batches are complete and ordered
+	it := remote.NewIterator([]byte("a-"), []byte("00100"))
+	count := 100
+	for it.Next() {
+		if want := fmt.Sprintf("a-%05d", count); string(it.Key()) != want {
+			t.Fatalf("key %d mismatch: have %q, want %q", count, it.Key(), want)
+		}
+		if want := fmt.Sprintf("value-%d", count); string(it.Value()) != want {
+			t.Fatalf("value %d mismatch: have %q, want %q", count, it.Value(), want)
+		}
+		count++
+	}
+	if err := it.Error(); err != nil {
+		t.Fatalf("iteration failed: %v", err)
+	}
+	it.Release()
+	if count != 2500 {
+		t.Fatalf("iterated item count mismatch: have %d, want %d", count, 2500)
+	}
+	// Check that the database can't be modified
+	if err := remote.Put([]byte("c-key"), []byte("c-value")); err == nil {
+		t.Fatalf("remote database write succeeded")
+	}
+	if err := remote.Delete([]byte("b-key")); err == nil {
+		t.Fatalf("remote database delete succeeded")
+	}
+	if has, _ := db.Has([]byte("b-key")); !has {
+		t.Fatalf("remote database modified the served one")
+	}
+}
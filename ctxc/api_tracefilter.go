@@ -0,0 +1,385 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexFoundation library.
+//
+// The CortexFoundation library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexFoundation library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexFoundation library. If not, see <http://www.gnu.org/licenses/>.
+
+package ctxc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
+	"github.com/CortexFoundation/CortexTheseus/core"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/core/vm"
+	"github.com/CortexFoundation/CortexTheseus/ctxc/tracers"
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/rpc"
+)
+
+const (
+	// maxTraceFilterBlocks is the maximum number of blocks a single trace filter
+	// request may span.
+	maxTraceFilterBlocks = 10000
+
+	// traceCacheBlocks is the maximum number of blocks whose traces are kept in
+	// the trace cache, the lowest ones being evicted first.
+	traceCacheBlocks = 4 * maxTraceFilterBlocks
+
+	// traceCacheWindow is the distance below the chain head beyond which the
+	// cached traces are pruned.
+	traceCacheWindow = 90000
+)
+
+var errTraceFilterRange = errors.New("invalid trace filter block range")
+
+// TraceFilterArgs holds the criteria of a trace filter request. A trace matches
+// if its sender is in FromAddress and its recipient is in ToAddress, empty
+// lists matching any address. After and Count paginate the matching traces.
+type TraceFilterArgs struct {
+	FromBlock   *rpc.BlockNumber `json:"fromBlock"`
+	ToBlock     *rpc.BlockNumber `json:"toBlock"`
+	FromAddress []common.Address `json:"fromAddress"`
+	ToAddress   []common.Address `json:"toAddress"`
+	After       *uint64          `json:"after"`
+	Count       *uint64          `json:"count"`
+	Reexec      *uint64          `json:"reexec"`
+}
+
+// TraceFilterResult is a single call frame matched by a trace filter, along
+// with its position in the chain.
+type TraceFilterResult struct {
+	*tracers.CallFrame
+	BlockNumber         hexutil.Uint64 `json:"blockNumber"`
+	BlockHash           common.Hash    `json:"blockHash"`
+	TransactionHash     common.Hash    `json:"transactionHash"`
+	TransactionPosition hexutil.Uint64 `json:"transactionPosition"`
+}
+
+// txCallTraces are the call frames of a single transaction.
+type txCallTraces struct {
+	TxHash common.Hash          `json:"txHash"`
+	Frames []*tracers.CallFrame `json:"frames"`
+}
+
+// TraceFilter runs the native call tracer over all transactions in the given
+// block range and returns the internal calls matching the address filters.
+// Traces of individual blocks are cached on disk, so repeated queries over the
+// same range are served without re-executing the blocks.
+func (api *PrivateDebugAPI) TraceFilter(ctx context.Context, args TraceFilterArgs) ([]*TraceFilterResult, error) {
+	head := api.ctxc.blockchain.CurrentBlock().NumberU64()
+	resolve := func(number *rpc.BlockNumber, def uint64) uint64 {
+		if number == nil || *number == rpc.LatestBlockNumber || *number == rpc.PendingBlockNumber {
+			return def
+		}
+		return uint64(*number)
+	}
+	from, to := resolve(args.FromBlock, head), resolve(args.ToBlock, head)
+	if from > to || to > head {
+		return nil, fmt.Errorf("%w: from %d, to %d, head %d", errTraceFilterRange, from, to, head)
+	}
+	if to-from >= maxTraceFilterBlocks {
+		return nil, fmt.Errorf("%w: at most %d blocks may be traced at once", errTraceFilterRange, maxTraceFilterBlocks)
+	}
+	reexec := defaultTraceReexec
+	if args.Reexec != nil {
+		reexec = *args.Reexec
+	}
+	var (
+		fromSet = make(map[common.Address]struct{}, len(args.FromAddress))
+		toSet   = make(map[common.Address]struct{}, len(args.ToAddress))
+		after   uint64
+		count   uint64 = math.MaxUint64
+	)
+	for _, addr := range args.FromAddress {
+		fromSet[addr] = struct{}{}
+	}
+	for _, addr := range args.ToAddress {
+		toSet[addr] = struct{}{}
+	}
+	if args.After != nil {
+		after = *args.After
+	}
+	if args.Count != nil {
+		count = *args.Count
+	}
+	results := []*TraceFilterResult{}
+	for number := from; number <= to && uint64(len(results)) < count; number++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		block := api.ctxc.blockchain.GetBlockByNumber(number)
+		if block == nil {
+			return nil, fmt.Errorf("block #%d not found", number)
+		}
+		traces, err := api.blockCallTraces(block, reexec)
+		if err != nil {
+			return nil, err
+		}
+		for i, tx := range traces {
+			for _, frame := range tx.Frames {
+				if !matchAddress(fromSet, frame.From) || !matchAddress(toSet, frame.To) {
+					continue
+				}
+				if after > 0 {
+					after--
+					continue
+				}
+				if uint64(len(results)) == count {
+					break
+				}
+				results = append(results, &TraceFilterResult{
+					CallFrame:           frame,
+					BlockNumber:         hexutil.Uint64(number),
+					BlockHash:           block.Hash(),
+					TransactionHash:     tx.TxHash,
+					TransactionPosition: hexutil.Uint64(i),
+				})
+			}
+		}
+	}
+	return results, nil
+}
+
+// matchAddress reports whether addr is in set, an empty set matching any address.
+func matchAddress(set map[common.Address]struct{}, addr common.Address) bool {
+	if len(set) == 0 {
+		return true
+	}
+	_, ok := set[addr]
+	return ok
+}
+
+// blockCallTraces returns the call frames of all transactions in a block,
+// either from the trace cache or by executing the block with the native call
+// tracer.
+func (api *PrivateDebugAPI) blockCallTraces(block *types.Block, reexec uint64) ([]*txCallTraces, error) {
+	if len(block.Transactions()) == 0 {
+		return nil, nil
+	}
+	if traces := api.ctxc.traceCache.get(block.NumberU64(), block.Hash()); traces != nil {
+		return traces, nil
+	}
+	parent := api.ctxc.blockchain.GetBlock(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return nil, fmt.Errorf("parent %x not found", block.ParentHash())
+	}
+	statedb, err := api.computeStateDB(parent, reexec)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		signer   = types.MakeSigner(api.config, block.Number())
+		blockCtx = core.NewCVMBlockContext(block.Header(), api.ctxc.blockchain, nil)
+		traces   = make([]*txCallTraces, 0, len(block.Transactions()))
+	)
+	for i, tx := range block.Transactions() {
+		msg, err := tx.AsMessage(signer)
+		if err != nil {
+			return nil, err
+		}
+		tracer := tracers.NewCallTracer()
+		statedb.Prepare(tx.Hash(), block.Hash(), i)
+
+		vmenv := vm.NewCVM(blockCtx, core.NewCVMTxContext(msg), statedb, api.config, vm.Config{Debug: true, Tracer: tracer})
+		if _, _, _, _, err := core.ApplyMessage(vmenv, msg, new(core.GasPool).AddGas(msg.Gas()), new(core.QuotaPool).AddQuota(math.MaxUint64)); err != nil {
+			return nil, fmt.Errorf("tracing transaction %x failed: %v", tx.Hash(), err)
+		}
+		// Finalize the state so any modifications are visible to the next transaction
+		statedb.Finalise(true)
+
+		traces = append(traces, &txCallTraces{TxHash: tx.Hash(), Frames: tracer.Frames()})
+	}
+	api.ctxc.traceCache.put(block.NumberU64(), block.Hash(), traces, api.ctxc.blockchain.CurrentBlock().NumberU64())
+	return traces, nil
+}
+
+// traceCache stores the call traces of blocks on disk, one file per block keyed
+// by block number and hash. Block contents never change for a given hash, so
+// entries never need to be invalidated, but the cache is capped in size and
+// traces of blocks too far below the head are pruned.
+type traceCache struct {
+	dir    string // Directory holding the cached traces, empty if caching is disabled
+	limit  int    // Maximum number of cached blocks
+	window uint64 // Distance below the head beyond which cached traces are pruned
+
+	entries map[uint64][]common.Hash // Cached blocks by number, nil until the directory is loaded
+	count   int                      // Number of cached blocks
+	pruned  uint64                   // Head the cache was last pruned at
+	lock    sync.Mutex
+}
+
+// newTraceCache creates a trace cache in the given directory. An empty path
+// disables caching.
+func newTraceCache(dir string, limit int, window uint64) *traceCache {
+	return &traceCache{dir: dir, limit: limit, window: window}
+}
+
+func (c *traceCache) path(number uint64, hash common.Hash) string {
+	return filepath.Join(c.dir, fmt.Sprintf("%d-%s.json", number, hash.Hex()))
+}
+
+// load indexes the entries already in the cache directory. The lock must be
+// held by the caller.
+func (c *traceCache) load() {
+	if c.entries != nil {
+		return
+	}
+	c.entries = make(map[uint64][]common.Hash)
+
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(file.Name(), ".json")
+		parts := strings.SplitN(name, "-", 2)
+		if len(parts) != 2 || name == file.Name() {
+			continue
+		}
+		number, err := strconv.ParseUint(parts[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		c.entries[number] = append(c.entries[number], common.HexToHash(parts[1]))
+		c.count++
+	}
+}
+
+// get returns the cached traces of a block, or nil if not cached.
+func (c *traceCache) get(number uint64, hash common.Hash) []*txCallTraces {
+	if c == nil || c.dir == "" {
+		return nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	blob, err := ioutil.ReadFile(c.path(number, hash))
+	if err != nil {
+		return nil
+	}
+	var traces []*txCallTraces
+	if err := json.Unmarshal(blob, &traces); err != nil {
+		log.Warn("Dropping corrupted trace cache entry", "number", number, "hash", hash, "err", err)
+		c.load()
+		c.remove(number, hash)
+		return nil
+	}
+	return traces
+}
+
+// put stores the traces of a block in the cache, pruning the entries beyond the
+// window below the given head and evicting the lowest ones above the limit.
+func (c *traceCache) put(number uint64, hash common.Hash, traces []*txCallTraces, head uint64) {
+	if c == nil || c.dir == "" {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.load()
+	c.prune(head)
+	if number+c.window < head {
+		return
+	}
+	blob, err := json.Marshal(traces)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		log.Warn("Failed to create trace cache", "dir", c.dir, "err", err)
+		return
+	}
+	// Write to a temporary file first so readers never see partial entries
+	path := c.path(number, hash)
+	if err := ioutil.WriteFile(path+".tmp", blob, 0600); err != nil {
+		log.Warn("Failed to write trace cache entry", "number", number, "hash", hash, "err", err)
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		log.Warn("Failed to write trace cache entry", "number", number, "hash", hash, "err", err)
+		os.Remove(path + ".tmp")
+		return
+	}
+	for _, cached := range c.entries[number] {
+		if cached == hash {
+			return
+		}
+	}
+	c.entries[number] = append(c.entries[number], hash)
+	c.count++
+
+	if c.count > c.limit {
+		numbers := make([]uint64, 0, len(c.entries))
+		for n := range c.entries {
+			numbers = append(numbers, n)
+		}
+		sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+		for _, n := range numbers {
+			if c.count <= c.limit {
+				break
+			}
+			for _, h := range c.entries[n] {
+				c.remove(n, h)
+			}
+		}
+	}
+}
+
+// prune deletes the cached traces of the blocks beyond the window below the
+// head. The lock must be held by the caller.
+func (c *traceCache) prune(head uint64) {
+	if head < c.window || head == c.pruned {
+		return
+	}
+	c.pruned = head
+	for n, hashes := range c.entries {
+		if n+c.window < head {
+			for _, h := range hashes {
+				c.remove(n, h)
+			}
+		}
+	}
+}
+
+// remove deletes a cached entry. The lock must be held by the caller.
+func (c *traceCache) remove(number uint64, hash common.Hash) {
+	os.Remove(c.path(number, hash))
+
+	hashes := c.entries[number]
+	for i, cached := range hashes {
+		if cached == hash {
+			hashes = append(hashes[:i], hashes[i+1:]...)
+			c.count--
+			break
+		}
+	}
+	if len(hashes) == 0 {
+		delete(c.entries, number)
+	} else {
+		c.entries[number] = hashes
+	}
+}
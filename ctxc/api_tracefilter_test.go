@@ -0,0 +1,63 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package ctxc
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+)
+
+// Tests that the trace cache evicts the lowest blocks above its limit, prunes
+// the blocks beyond its window below the head, and reloads its index from disk.
+func TestTraceCacheEviction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tracecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	traces := []*txCallTraces{{TxHash: common.Hash{0x01}}}
+	cache := newTraceCache(dir, 3, 100)
+	for n := uint64(1); n <= 4; n++ {
+		cache.put(n, common.Hash{byte(n)}, traces, 10)
+	}
+	if cache.get(1, common.Hash{1}) != nil {
+		t.Fatalf("lowest block not evicted")
+	}
+	for n := uint64(2); n <= 4; n++ {
+		if cache.get(n, common.Hash{byte(n)}) == nil {
+			t.Fatalf("block %d evicted", n)
+		}
+	}
+	// A reopened cache must know about the existing entries
+	cache = newTraceCache(dir, 3, 100)
+	cache.put(50, common.Hash{50}, traces, 103)
+	if cache.get(2, common.Hash{2}) != nil || cache.get(3, common.Hash{3}) == nil {
+		t.Fatalf("blocks beyond the window not pruned")
+	}
+	// Blocks beyond the window must not be cached at all
+	cache.put(1, common.Hash{1}, traces, 103)
+	if cache.get(1, common.Hash{1}) != nil {
+		t.Fatalf("block beyond the window cached")
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 3 {
+		t.Fatalf("cache file count mismatch: have %d, want 3", len(files))
+	}
+}
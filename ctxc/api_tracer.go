@@ -481,6 +481,13 @@ func (api *PrivateDebugAPI) computeStateDB(block *types.Block, reexec uint64) (*
 	if err == nil {
 		return statedb, nil
 	}
+	// If state diffs are tracked, replay them instead of the transactions
+	if api.ctxc.blockchain.StateDiffsEnabled() {
+		if statedb, err = api.ctxc.blockchain.HistoricState(block.Header()); err == nil {
+			return statedb, nil
+		}
+		log.Debug("Failed to replay state diffs, reexecuting", "number", block.NumberU64(), "err", err)
+	}
 	// Otherwise try to reexec blocks until we find a state or reach our limit
 	origin := block.NumberU64()
 	database := state.NewDatabaseWithConfig(api.ctxc.ChainDb(), &trie.Config{Cache: 16, Preimages: true})
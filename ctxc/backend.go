@@ -26,6 +26,7 @@ import (
 	"time"
 
 	"github.com/CortexFoundation/CortexTheseus/accounts"
+	"github.com/CortexFoundation/CortexTheseus/accounts/abi/bind"
 	"github.com/CortexFoundation/CortexTheseus/common"
 	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
 	"github.com/CortexFoundation/CortexTheseus/consensus"
@@ -34,11 +35,14 @@ import (
 	"github.com/CortexFoundation/CortexTheseus/core"
 	"github.com/CortexFoundation/CortexTheseus/core/bloombits"
 	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
+	"github.com/CortexFoundation/CortexTheseus/core/state/pruner"
 	"github.com/CortexFoundation/CortexTheseus/core/types"
 	"github.com/CortexFoundation/CortexTheseus/core/vm"
 	"github.com/CortexFoundation/CortexTheseus/ctxc/downloader"
 	"github.com/CortexFoundation/CortexTheseus/ctxc/filters"
 	"github.com/CortexFoundation/CortexTheseus/ctxc/gasprice"
+	"github.com/CortexFoundation/CortexTheseus/ctxc/protocols/avail"
+	"github.com/CortexFoundation/CortexTheseus/ctxc/protocols/snap"
 	"github.com/CortexFoundation/CortexTheseus/ctxcdb"
 	"github.com/CortexFoundation/CortexTheseus/event"
 	"github.com/CortexFoundation/CortexTheseus/internal/ctxcapi"
@@ -55,6 +59,14 @@ import (
 	"github.com/CortexFoundation/torrentfs"
 )
 
+// LesServer is the light protocol server attached to a full node.
+type LesServer interface {
+	Start(srvr *p2p.Server)
+	Stop()
+	APIs() []rpc.API
+	Protocols() []p2p.Protocol
+}
+
 // Cortex implements the Cortex full node service.
 type Cortex struct {
 	config      *Config
@@ -67,8 +79,9 @@ type Cortex struct {
 	txPool          *core.TxPool
 	blockchain      *core.BlockChain
 	protocolManager *ProtocolManager
+	lesServer       LesServer
 
-	dialCandidates enode.Iterator
+	dialCandidates *enode.FairMix
 
 	// DB interfaces
 	chainDb ctxcdb.Database // Block chain database
@@ -83,11 +96,16 @@ type Cortex struct {
 
 	APIBackend *CortexAPIBackend
 
+	traceCache *traceCache // On-disk cache of the per block call traces
+
 	miner    *miner.Miner
 	synapse  *synapse.Synapse
 	gasPrice *big.Int
 	coinbase common.Address
 
+	availTracker *avail.Tracker // Peers holding model and input files, nil without local storage
+	availStorage availStorage   // Local file store advertised to peers
+
 	networkID     uint64
 	netRPCService *ctxcapi.PublicNetAPI
 
@@ -121,6 +139,11 @@ func New(ctx *node.ServiceContext, config *Config) (*Cortex, error) {
 	if err != nil {
 		return nil, err
 	}
+	// Try to recover offline state pruning, it must finish before the chain
+	// touches any state again.
+	if err := pruner.RecoverPruning(ctx.ResolvePath(""), chainDb, ctx.ResolvePath(config.TrieCleanCacheJournal)); err != nil {
+		log.Error("Failed to recover state", "error", err)
+	}
 	chainConfig, genesisHash, genesisErr := core.SetupGenesisBlock(chainDb, config.Genesis)
 	if _, ok := genesisErr.(*params.ConfigCompatError); genesisErr != nil && !ok {
 		return nil, genesisErr
@@ -140,6 +163,7 @@ func New(ctx *node.ServiceContext, config *Config) (*Cortex, error) {
 		coinbase:          config.Coinbase,
 		bloomRequests:     make(chan chan *bloombits.Retrieval),
 		bloomIndexer:      NewBloomIndexer(chainDb, params.BloomBitsBlocks, params.BloomConfirms),
+		traceCache:        newTraceCache(ctx.ResolvePath("tracecache"), traceCacheBlocks, traceCacheWindow),
 	}
 
 	bcVersion := rawdb.ReadDatabaseVersion(chainDb)
@@ -159,6 +183,13 @@ func New(ctx *node.ServiceContext, config *Config) (*Cortex, error) {
 		}
 	}
 
+	// Advertise the local model and input files to peers, scheduling the
+	// download of the missing ones from connected peers holding them
+	storage := torrentfs.GetStorage()
+	if fs, ok := storage.(*torrentfs.TorrentFS); ok && fs != nil {
+		ctxc.availTracker, ctxc.availStorage = avail.NewTracker(), fs
+		storage = avail.NewStorage(fs, ctxc.availTracker, availFetchTimeout)
+	}
 	ctxc.synapse = synapse.New(&synapse.Config{
 		DeviceType:     config.InferDeviceType,
 		DeviceId:       config.InferDeviceId,
@@ -166,7 +197,7 @@ func New(ctx *node.ServiceContext, config *Config) (*Cortex, error) {
 		IsRemoteInfer:  config.InferURI != "",
 		InferURI:       config.InferURI,
 		IsNotCache:     false,
-		Storagefs:      torrentfs.GetStorage(), //torrentfs.Torrentfs_handle,
+		Storagefs:      storage, //torrentfs.Torrentfs_handle,
 	})
 
 	var (
@@ -187,6 +218,9 @@ func New(ctx *node.ServiceContext, config *Config) (*Cortex, error) {
 
 			SnapshotLimit: config.SnapshotCache,
 			Preimages:     config.Preimages,
+
+			StateDiffs:          config.StateDiffs,
+			StateDiffCheckpoint: config.StateDiffCheckpoint,
 		}
 	)
 	ctxc.blockchain, err = core.NewBlockChain(chainDb, cacheConfig, ctxc.chainConfig, ctxc.engine, vmConfig, ctxc.shouldPreserve, &config.TxLookupLimit)
@@ -208,9 +242,16 @@ func New(ctx *node.ServiceContext, config *Config) (*Cortex, error) {
 
 	cacheLimit := cacheConfig.TrieCleanLimit + cacheConfig.TrieDirtyLimit + cacheConfig.SnapshotLimit
 
-	if ctxc.protocolManager, err = NewProtocolManager(ctxc.chainConfig, config.SyncMode, config.NetworkId, ctxc.eventMux, ctxc.txPool, ctxc.engine, ctxc.blockchain, chainDb, cacheLimit, config.Whitelist); err != nil {
+	// Permit the downloader to use the trusted checkpoint shipped with the
+	// client, unless an explicitly configured one is given
+	checkpoint := config.Checkpoint
+	if checkpoint == nil {
+		checkpoint = params.TrustedCheckpoints[ctxc.blockchain.Genesis().Hash()]
+	}
+	if ctxc.protocolManager, err = NewProtocolManager(ctxc.chainConfig, config.SyncMode, config.NetworkId, ctxc.eventMux, ctxc.txPool, ctxc.engine, ctxc.blockchain, chainDb, cacheLimit, config.Whitelist, checkpoint); err != nil {
 		return nil, err
 	}
+	ctxc.protocolManager.txPropagation = config.TxPropagation
 
 	ctxc.miner = miner.New(ctxc, &config.Miner, ctxc.chainConfig, ctxc.EventMux(), ctxc.engine, ctxc.isLocalBlock)
 	ctxc.miner.SetExtra(makeExtraData(config.Miner.ExtraData))
@@ -299,9 +340,33 @@ func CreateConsensusEngine(ctx *node.ServiceContext, chainConfig *params.ChainCo
 func (s *Cortex) APIs() []rpc.API {
 	apis := ctxcapi.GetAPIs(s.APIBackend, vm.Config{})
 
+	// Append any APIs exposed by the light server
+	if s.lesServer != nil {
+		apis = append(apis, s.lesServer.APIs()...)
+	}
+
+	// Append the file availability API if local storage is advertised
+	if s.availTracker != nil {
+		apis = append(apis, rpc.API{
+			Namespace: "avail",
+			Version:   "1.0",
+			Service:   avail.NewPublicAvailAPI(s.availTracker),
+			Public:    true,
+		})
+	}
+
 	// Append any APIs exposed explicitly by the consensus engine
 	apis = append(apis, s.engine.APIs(s.BlockChain())...)
 
+	// Expose the raw database read-only if explicitly requested
+	if s.config.DatabaseAPI {
+		apis = append(apis, rpc.API{
+			Namespace: "debug",
+			Version:   "1.0",
+			Service:   NewPrivateDatabaseAPI(s.chainDb),
+		})
+	}
+
 	// Append all the local APIs and return
 	return append(apis, []rpc.API{
 		{
@@ -373,6 +438,22 @@ func (s *Cortex) APIs() []rpc.API {
 	}...)
 }
 
+// AddLesServer attaches a light protocol server, which is started and stopped
+// together with the full node.
+func (s *Cortex) AddLesServer(ls LesServer) {
+	s.lesServer = ls
+}
+
+// SetContractBackend sets the contract backend of the light server, used to
+// access the checkpoint oracle.
+func (s *Cortex) SetContractBackend(backend bind.ContractBackend) {
+	if ls, ok := s.lesServer.(interface {
+		SetContractBackend(bind.ContractBackend)
+	}); ok {
+		ls.SetContractBackend(backend)
+	}
+}
+
 func (s *Cortex) ResetWithGenesisBlock(gb *types.Block) {
 	s.blockchain.ResetWithGenesisBlock(gb)
 }
@@ -527,6 +608,7 @@ func (s *Cortex) TxPool() *core.TxPool               { return s.txPool }
 func (s *Cortex) EventMux() *event.TypeMux           { return s.eventMux }
 func (s *Cortex) Engine() consensus.Engine           { return s.engine }
 func (s *Cortex) ChainDb() ctxcdb.Database           { return s.chainDb }
+func (s *Cortex) BloomIndexer() *core.ChainIndexer   { return s.bloomIndexer }
 func (s *Cortex) IsListening() bool                  { return true } // Always listening
 func (s *Cortex) CortexVersion() int                 { return int(ProtocolVersions[0]) }
 func (s *Cortex) NetVersion() uint64                 { return s.networkID }
@@ -545,6 +627,18 @@ func (s *Cortex) Protocols() []p2p.Protocol {
 		protos[i].Attributes = []enr.Entry{s.currentCtxcEntry()}
 		protos[i].DialCandidates = s.dialCandidates
 	}
+	// Run the snap protocol to serve the state if snapshots are maintained, or
+	// to retrieve it if snap sync was granted. Without snapshots requests are
+	// answered empty, marking the local node as stateless for remote syncers.
+	if s.config.SnapshotCache > 0 || atomic.LoadUint32(&s.protocolManager.snapSync) == 1 {
+		protos = append(protos, snap.MakeProtocols((*snapHandler)(s.protocolManager))...)
+	}
+	if s.availTracker != nil {
+		protos = append(protos, avail.MakeProtocols((*availHandler)(s))...)
+	}
+	if s.lesServer != nil {
+		protos = append(protos, s.lesServer.Protocols()...)
+	}
 	return protos
 }
 
@@ -552,6 +646,7 @@ func (s *Cortex) Protocols() []p2p.Protocol {
 // Cortex protocol implementation.
 func (s *Cortex) Start(srvr *p2p.Server) error {
 	s.startCtxcEntryUpdate(srvr.LocalNode())
+	s.startDiscoveryV5(srvr)
 	// Start the bloom bits servicing goroutines
 	s.startBloomHandlers(params.BloomBitsBlocks)
 
@@ -560,8 +655,17 @@ func (s *Cortex) Start(srvr *p2p.Server) error {
 
 	// Figure out a max peers count based on the server limits
 	maxPeers := srvr.MaxPeers
+	if s.config.LightServ > 0 {
+		if s.config.LightPeers >= srvr.MaxPeers {
+			return fmt.Errorf("invalid peer config: light peer count (%d) >= total peer count (%d)", s.config.LightPeers, srvr.MaxPeers)
+		}
+		maxPeers -= s.config.LightPeers
+	}
 	// Start the networking layer and the light server if requested
 	s.protocolManager.Start(maxPeers)
+	if s.lesServer != nil {
+		s.lesServer.Start(srvr)
+	}
 	return nil
 }
 
@@ -572,6 +676,9 @@ func (s *Cortex) Stop() error {
 		s.synapse.Close()
 	}
 	s.protocolManager.Stop()
+	if s.lesServer != nil {
+		s.lesServer.Stop()
+	}
 	// Then stop everything else.
 	s.bloomIndexer.Close()
 	close(s.closeBloomHandler)
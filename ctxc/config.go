@@ -33,16 +33,20 @@ import (
 
 // DefaultFullGPOConfig contains default gasprice oracle settings for full node.
 var DefaultFullGPOConfig = gasprice.Config{
-	Blocks:     20,
-	Percentile: 60,
-	MaxPrice:   gasprice.DefaultMaxPrice,
+	Blocks:           20,
+	Percentile:       60,
+	MaxHeaderHistory: 1024,
+	MaxBlockHistory:  1024,
+	MaxPrice:         gasprice.DefaultMaxPrice,
 }
 
 // DefaultLightGPOConfig contains default gasprice oracle settings for light client.
 var DefaultLightGPOConfig = gasprice.Config{
-	Blocks:     2,
-	Percentile: 60,
-	MaxPrice:   gasprice.DefaultMaxPrice,
+	Blocks:           2,
+	Percentile:       60,
+	MaxHeaderHistory: 300,
+	MaxBlockHistory:  5,
+	MaxPrice:         gasprice.DefaultMaxPrice,
 }
 
 // DefaultConfig contains default settings for use on the Cortex main net.
@@ -50,6 +54,7 @@ var DefaultConfig = Config{
 	SyncMode:                downloader.FullSync,
 	Cuckoo:                  cuckoo.Config{},
 	NetworkId:               21,
+	LightPeers:              100,
 	DatabaseCache:           512,
 	TrieCleanCache:          154,
 	TrieCleanCacheJournal:   "triecache",
@@ -64,10 +69,11 @@ var DefaultConfig = Config{
 		Recommit: 3 * time.Second,
 	},
 
-	TxPool:      core.DefaultTxPoolConfig,
-	RPCGasCap:   25000000,
-	GPO:         DefaultFullGPOConfig,
-	RPCTxFeeCap: 1, // 1 ctxc
+	TxPool:        core.DefaultTxPoolConfig,
+	TxPropagation: DefaultTxPropagationConfig,
+	RPCGasCap:     25000000,
+	GPO:           DefaultFullGPOConfig,
+	RPCTxFeeCap:   1, // 1 ctxc
 }
 
 func init() {
@@ -95,11 +101,16 @@ type Config struct {
 
 	Whitelist map[uint64]common.Hash `toml:"-"`
 
+	// Light client options
+	LightServ  int `toml:",omitempty"` // Maximum percentage of time allowed for serving LES requests
+	LightPeers int `toml:",omitempty"` // Maximum number of LES client peers
+
 	// Database options
 	SkipBcVersionCheck      bool `toml:"-"`
 	DatabaseHandles         int  `toml:"-"`
 	DatabaseCache           int
 	DatabaseFreezer         string
+	DatabaseAPI             bool `toml:",omitempty"` // Serve the raw database read-only over the debug_db* RPC methods
 	TrieCleanCache          int
 	TrieCleanCacheJournal   string        `toml:",omitempty"` // Disk journal directory for trie cache to survive node restarts
 	TrieCleanCacheRejournal time.Duration `toml:",omitempty"` // Time interval to regenerate the journal for clean cache
@@ -108,6 +119,10 @@ type Config struct {
 	SnapshotCache           int
 	Preimages               bool
 
+	// State diff options
+	StateDiffs          bool   `toml:",omitempty"` // Store per-block state diffs for historical state access
+	StateDiffCheckpoint uint64 `toml:",omitempty"` // Block interval of the full states the diffs are replayed on
+
 	// Mining options
 	Miner miner.Config
 
@@ -122,6 +137,9 @@ type Config struct {
 	// Transaction pool options
 	TxPool core.TxPoolConfig
 
+	// Transaction propagation options
+	TxPropagation TxPropagationConfig
+
 	// Gas Price Oracle options
 	GPO gasprice.Config
 
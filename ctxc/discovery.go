@@ -17,18 +17,26 @@
 package ctxc
 
 import (
+	"time"
+
 	"github.com/CortexFoundation/CortexTheseus/core"
 	"github.com/CortexFoundation/CortexTheseus/core/forkid"
 	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/p2p"
 	"github.com/CortexFoundation/CortexTheseus/p2p/dnsdisc"
 	"github.com/CortexFoundation/CortexTheseus/p2p/enode"
 	"github.com/CortexFoundation/CortexTheseus/rlp"
 )
 
+// discmixTimeout is how long the discovery mix waits for a node from the
+// fairly-chosen source before taking one from any other.
+const discmixTimeout = 5 * time.Second
+
 // ctxcEntry is the "ctxc" ENR entry which advertises ctxc protocol
 // on the discovery network.
 type ctxcEntry struct {
-	ForkID forkid.ID // Fork identifier per EIP-2124
+	ForkID    forkid.ID // Fork identifier per EIP-2124
+	NetworkID uint64    // Network identifier, zero if not advertised
 
 	// Ignore additional fields (for forward compatibility).
 	Rest []rlp.RawValue `rlp:"tail"`
@@ -39,6 +47,32 @@ func (e ctxcEntry) ENRKey() string {
 	return "ctxc"
 }
 
+// DecodeRLP implements rlp.Decoder, accepting the entries of older nodes which
+// only advertise their fork ID.
+func (e *ctxcEntry) DecodeRLP(s *rlp.Stream) error {
+	if _, err := s.List(); err != nil {
+		return err
+	}
+	if err := s.Decode(&e.ForkID); err != nil {
+		return err
+	}
+	e.NetworkID, e.Rest = 0, nil
+	if err := s.Decode(&e.NetworkID); err != nil && err != rlp.EOL {
+		return err
+	}
+	for {
+		raw, err := s.Raw()
+		if err == rlp.EOL {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		e.Rest = append(e.Rest, raw)
+	}
+	return s.ListEnd()
+}
+
 // startCtxcEntryUpdate starts the ENR updater loop.
 func (ctxc *Cortex) startCtxcEntryUpdate(ln *enode.LocalNode) {
 	var newHead = make(chan core.ChainHeadEvent, 10)
@@ -61,15 +95,51 @@ func (ctxc *Cortex) startCtxcEntryUpdate(ln *enode.LocalNode) {
 }
 
 func (ctxc *Cortex) currentCtxcEntry() *ctxcEntry {
-	return &ctxcEntry{ForkID: forkid.NewID(ctxc.blockchain.Config(), ctxc.blockchain.Genesis().Hash(),
-		ctxc.blockchain.CurrentHeader().Number.Uint64())}
+	return &ctxcEntry{
+		ForkID: forkid.NewID(ctxc.blockchain.Config(), ctxc.blockchain.Genesis().Hash(),
+			ctxc.blockchain.CurrentHeader().Number.Uint64()),
+		NetworkID: ctxc.networkID,
+	}
+}
+
+// nodeFilter returns a check accepting the nodes whose "ctxc" ENR entry carries
+// a fork ID compatible with the local chain and, if present, our network ID.
+func (ctxc *Cortex) nodeFilter() func(*enode.Node) bool {
+	filter := forkid.NewFilter(ctxc.blockchain)
+	return func(n *enode.Node) bool {
+		var entry ctxcEntry
+		if err := n.Load(&entry); err != nil {
+			return false
+		}
+		if entry.NetworkID != 0 && entry.NetworkID != ctxc.networkID {
+			return false
+		}
+		return filter(entry.ForkID) == nil
+	}
+}
+
+// setupDiscovery creates the node discovery source for the ctxc protocol. It
+// mixes the DNS discovery trees with the V5 discovery table, which is only
+// added by startDiscoveryV5 once the p2p server is running.
+func (ctxc *Cortex) setupDiscovery() (*enode.FairMix, error) {
+	mix := enode.NewFairMix(discmixTimeout)
+	if len(ctxc.config.DiscoveryURLs) > 0 {
+		client := dnsdisc.NewClient(dnsdisc.Config{})
+		it, err := client.NewIterator(ctxc.config.DiscoveryURLs...)
+		if err != nil {
+			mix.Close()
+			return nil, err
+		}
+		mix.AddSource(it)
+	}
+	return mix, nil
 }
 
-// setupDiscovery creates the node discovery source for the ctxc protocol.
-func (ctxc *Cortex) setupDiscovery() (enode.Iterator, error) {
-	if len(ctxc.config.DiscoveryURLs) == 0 {
-		return nil, nil
+// startDiscoveryV5 adds the nodes of the V5 discovery table which advertise a
+// compatible ctxc entry to the dial candidates.
+func (ctxc *Cortex) startDiscoveryV5(srvr *p2p.Server) {
+	if srvr.DiscV5 == nil {
+		return
 	}
-	client := dnsdisc.NewClient(dnsdisc.Config{})
-	return client.NewIterator(ctxc.config.DiscoveryURLs...)
+	ctxc.dialCandidates.AddSource(enode.Filter(srvr.DiscV5.RandomNodes(), ctxc.nodeFilter()))
 }
@@ -0,0 +1,98 @@
+// Copyright 2020 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package ctxc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/CortexFoundation/CortexTheseus/core/forkid"
+	"github.com/CortexFoundation/CortexTheseus/ctxc/downloader"
+	"github.com/CortexFoundation/CortexTheseus/p2p/enode"
+	"github.com/CortexFoundation/CortexTheseus/p2p/enr"
+	"github.com/CortexFoundation/CortexTheseus/rlp"
+)
+
+// Tests that ctxc ENR entries of older nodes without a network ID still decode,
+// and that unknown trailing fields are retained.
+func TestCtxcEntryDecode(t *testing.T) {
+	id := forkid.ID{Hash: [4]byte{1, 2, 3, 4}, Next: 5}
+
+	legacy, _ := rlp.EncodeToBytes(struct{ ForkID forkid.ID }{id})
+	extended, _ := rlp.EncodeToBytes(struct {
+		ForkID    forkid.ID
+		NetworkID uint64
+		Extra     uint64
+	}{id, 21, 7})
+	extra, _ := rlp.EncodeToBytes(uint64(7))
+
+	tests := []struct {
+		input []byte
+		want  ctxcEntry
+	}{
+		{legacy, ctxcEntry{ForkID: id}},
+		{extended, ctxcEntry{ForkID: id, NetworkID: 21, Rest: []rlp.RawValue{extra}}},
+	}
+	for i, tt := range tests {
+		var have ctxcEntry
+		if err := rlp.DecodeBytes(tt.input, &have); err != nil {
+			t.Fatalf("test %d: decode failed: %v", i, err)
+		}
+		if !reflect.DeepEqual(have, tt.want) {
+			t.Errorf("test %d: entry mismatch: have %+v, want %+v", i, have, tt.want)
+		}
+	}
+	// Check that a freshly encoded entry survives a round trip.
+	entry := ctxcEntry{ForkID: id, NetworkID: 21}
+	enc, _ := rlp.EncodeToBytes(&entry)
+	var dec ctxcEntry
+	if err := rlp.DecodeBytes(enc, &dec); err != nil || !reflect.DeepEqual(dec, entry) {
+		t.Errorf("round trip mismatch: have %+v, want %+v (err %v)", dec, entry, err)
+	}
+}
+
+// Tests that discovered nodes are only accepted if they advertise a compatible
+// fork ID and network ID.
+func TestNodeFilter(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	defer pm.Stop()
+
+	ctxc := &Cortex{blockchain: pm.blockchain, networkID: DefaultConfig.NetworkId}
+	local := ctxc.currentCtxcEntry()
+	stale := forkid.ID{Hash: [4]byte{0xde, 0xad, 0xbe, 0xef}}
+
+	tests := []struct {
+		entry enr.Entry
+		want  bool
+	}{
+		{nil, false},
+		{local, true},
+		{&ctxcEntry{ForkID: local.ForkID}, true},
+		{&ctxcEntry{ForkID: local.ForkID, NetworkID: local.NetworkID + 1}, false},
+		{&ctxcEntry{ForkID: stale, NetworkID: local.NetworkID}, false},
+	}
+	filter := ctxc.nodeFilter()
+	for i, tt := range tests {
+		var r enr.Record
+		if tt.entry != nil {
+			r.Set(tt.entry)
+		}
+		if have := filter(enode.SignNull(&r, enode.ID{byte(i)})); have != tt.want {
+			t.Errorf("test %d: filter mismatch: have %v, want %v", i, have, tt.want)
+		}
+	}
+}
@@ -27,13 +27,16 @@ import (
 
 	cortex "github.com/CortexFoundation/CortexTheseus"
 	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/consensus"
 	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
 	"github.com/CortexFoundation/CortexTheseus/core/types"
 	"github.com/CortexFoundation/CortexTheseus/core/vm"
+	"github.com/CortexFoundation/CortexTheseus/ctxc/protocols/snap"
 	"github.com/CortexFoundation/CortexTheseus/ctxcdb"
 	"github.com/CortexFoundation/CortexTheseus/event"
 	"github.com/CortexFoundation/CortexTheseus/log"
 	"github.com/CortexFoundation/CortexTheseus/metrics"
+	"github.com/CortexFoundation/CortexTheseus/p2p"
 	"github.com/CortexFoundation/CortexTheseus/params"
 	"github.com/CortexFoundation/CortexTheseus/trie"
 )
@@ -95,6 +98,21 @@ var (
 	errNoAncestorFound         = errors.New("no common ancestor found")
 )
 
+// PeerFault maps the reason a peer was dropped for to the conduct it's to be
+// penalised for. Peers dropped for reasons that aren't their fault, such as
+// lagging behind or running an old protocol version, are not penalised.
+func PeerFault(reason error) (p2p.Behaviour, bool) {
+	switch {
+	case errors.Is(reason, errTimeout), errors.Is(reason, errStallingPeer):
+		return p2p.Timeout, true
+	case errors.Is(reason, errInvalidChain), errors.Is(reason, errInvalidBody), errors.Is(reason, errInvalidReceipt):
+		return p2p.BadBlock, true
+	case errors.Is(reason, errBadPeer), errors.Is(reason, errEmptyHeaderSet), errors.Is(reason, errInvalidAncestor):
+		return p2p.InvalidResponse, true
+	}
+	return 0, false
+}
+
 type Downloader struct {
 	// WARNING: The `rttEstimate` and `rttConfidence` fields are accessed atomically.
 	// On 32 bit platforms, only 64-bit aligned fields can be atomic. The struct is
@@ -109,6 +127,11 @@ type Downloader struct {
 	checkpoint uint64 // Checkpoint block number to enforce head against (e.g. fast sync)
 	genesis    uint64
 
+	snapSync   bool         // Whether to run state sync over the snap protocol
+	SnapSyncer *snap.Syncer // Snapshot sync scheduler to retrieve the state with
+
+	checkpointSync bool // Whether to only sample the seals below the checkpoint during fast sync
+
 	queue      *queue   // Scheduler for selecting the hashes to download
 	peers      *peerSet // Set of active peers from which download can proceed
 	stateDB    ctxcdb.Database
@@ -118,8 +141,10 @@ type Downloader struct {
 	syncStatsChainOrigin uint64 // Origin block number where syncing started at
 	syncStatsChainHeight uint64 // Highest block number known when syncing started
 	syncStatsState       stateSyncStats
+	syncStatsSeals       sealSyncStats
 	syncStatsLock        sync.RWMutex // Lock protecting the sync stats fields
 
+	lightchain LightChain
 	blockchain BlockChain
 
 	// Callbacks
@@ -164,7 +189,8 @@ type Downloader struct {
 	chainInsertHook  func([]*fetchResult)  // Method to call upon inserting a chain of blocks (possibly in multiple invocations)
 }
 
-type BlockChain interface {
+// LightChain encapsulates functions required to synchronise a light chain.
+type LightChain interface {
 	// HasHeader verifies a header's presence in the local chain.
 	HasHeader(common.Hash, uint64) bool
 
@@ -182,6 +208,11 @@ type BlockChain interface {
 
 	// SetHead rewinds the local chain to a new head.
 	SetHead(uint64) error
+}
+
+// BlockChain encapsulates functions required to sync a (full or fast) blockchain.
+type BlockChain interface {
+	LightChain
 
 	HasFastBlock(common.Hash, uint64) bool
 
@@ -205,10 +236,16 @@ type BlockChain interface {
 
 	// InsertReceiptChain inserts a batch of receipts into the local chain.
 	InsertReceiptChain(types.Blocks, []types.Receipts, uint64) (int, error)
+
+	// Engine retrieves the consensus engine verifying the chain's seals.
+	Engine() consensus.Engine
 }
 
 // New creates a new downloader to fetch hashes and blocks from remote peers.
-func New(checkpoint uint64, stateDb ctxcdb.Database, stateBloom *trie.SyncBloom, mux *event.TypeMux, chain BlockChain, dropPeer peerDropFn) *Downloader {
+func New(checkpoint uint64, stateDb ctxcdb.Database, stateBloom *trie.SyncBloom, mux *event.TypeMux, chain BlockChain, lightchain LightChain, dropPeer peerDropFn) *Downloader {
+	if lightchain == nil {
+		lightchain = chain
+	}
 
 	dl := &Downloader{
 		stateDB:        stateDb,
@@ -220,6 +257,8 @@ func New(checkpoint uint64, stateDb ctxcdb.Database, stateBloom *trie.SyncBloom,
 		rttEstimate:    uint64(rttMaxEstimate),
 		rttConfidence:  uint64(1000000),
 		blockchain:     chain,
+		lightchain:     lightchain,
+		SnapSyncer:     snap.NewSyncer(stateDb, stateBloom),
 		dropPeer:       dropPeer,
 		headerCh:       make(chan dataPack, 1),
 		bodyCh:         make(chan dataPack, 1),
@@ -259,6 +298,8 @@ func (d *Downloader) Progress() cortex.SyncProgress {
 		current = d.blockchain.CurrentBlock().NumberU64()
 	case d.blockchain != nil && mode == FastSync:
 		current = d.blockchain.CurrentFastBlock().NumberU64()
+	case d.lightchain != nil:
+		current = d.lightchain.CurrentHeader().Number.Uint64()
 	default:
 		log.Error("Unknown downloader chain/mode combo", "full", d.blockchain != nil, "mode", mode)
 	}
@@ -268,6 +309,9 @@ func (d *Downloader) Progress() cortex.SyncProgress {
 		HighestBlock:  d.syncStatsChainHeight,
 		PulledStates:  d.syncStatsState.processed,
 		KnownStates:   d.syncStatsState.processed + d.syncStatsState.pending,
+		SampledSeals:  atomic.LoadUint64(&d.syncStatsSeals.sampled),
+		VerifiedSeals: atomic.LoadUint64(&d.syncStatsSeals.verified),
+		KnownSeals:    atomic.LoadUint64(&d.syncStatsSeals.known),
 	}
 }
 
@@ -334,7 +378,7 @@ func (d *Downloader) Synchronise(id string, head common.Hash, td *big.Int, mode
 			// Timeouts can occur if e.g. compaction hits at the wrong time, and can be ignored
 			log.Warn("Downloader wants to drop peer, but peerdrop-function is not set", "peer", id)
 		} else {
-			d.dropPeer(id)
+			d.dropPeer(id, err)
 		}
 		return err
 	}
@@ -364,6 +408,26 @@ func (d *Downloader) synchronise(id string, hash common.Hash, td *big.Int, mode
 	if mode == FullSync && d.stateBloom != nil {
 		d.stateBloom.Close()
 	}
+	// Snap sync retrieves the chain the same way as fast sync does, only the
+	// state at the pivot is downloaded differently, so run it as fast sync
+	if mode == SnapSync {
+		if !d.snapSync {
+			log.Info("Enabling snapshot based state sync")
+			d.snapSync = true
+		}
+		mode = FastSync
+	}
+	// Checkpoint sync is fast sync, only verifying the seals below the trusted
+	// checkpoint through sampling, so run it as fast sync too. The mode may be
+	// different in the next cycle, so sampling is only enabled for this one.
+	checkpointSync := mode == CheckpointSync
+	if checkpointSync && !d.checkpointSync {
+		log.Info("Enabling seal sampling below the checkpoint", "checkpoint", d.checkpoint)
+	}
+	d.checkpointSync = checkpointSync
+	if checkpointSync {
+		mode = FastSync
+	}
 	// Reset the queue, peer set and wake channels to clean any internal leftover state
 	d.queue.Reset(blockCacheMaxItems, blockCacheInitialItems)
 	d.peers.Reset()
@@ -422,7 +486,7 @@ func (d *Downloader) syncWithPeer(p *peerConnection, hash common.Hash, td *big.I
 		if err != nil {
 			d.mux.Post(FailedEvent{err})
 		} else {
-			latest := d.blockchain.CurrentHeader()
+			latest := d.lightchain.CurrentHeader()
 			d.mux.Post(DoneEvent{latest})
 		}
 	}()
@@ -518,6 +582,12 @@ func (d *Downloader) syncWithPeer(p *peerConnection, hash common.Hash, td *big.I
 			}
 		}
 	}
+	// If headers below the checkpoint are to be imported, sample their seals
+	var seals *sealVerifier
+	if mode == FastSync && d.checkpointSync && d.checkpoint > origin {
+		seals = newSealVerifier(d.blockchain.Engine(), &d.syncStatsSeals)
+		defer seals.close()
+	}
 	// Initiate the sync using a concurrent header and content retrieval algorithm
 	d.queue.Prepare(origin+1, mode)
 	if d.syncInitHook != nil {
@@ -527,7 +597,7 @@ func (d *Downloader) syncWithPeer(p *peerConnection, hash common.Hash, td *big.I
 		func() error { return d.fetchHeaders(p, origin+1) }, // Headers are always retrieved
 		func() error { return d.fetchBodies(origin + 1) },   // Bodies are retrieved during normal and fast sync
 		func() error { return d.fetchReceipts(origin + 1) }, // Receipts are retrieved during fast sync
-		func() error { return d.processHeaders(origin+1, td) },
+		func() error { return d.processHeaders(origin+1, td, seals) },
 	}
 	if mode == FastSync {
 		d.pivotLock.Lock()
@@ -648,7 +718,7 @@ func (d *Downloader) fetchHead(p *peerConnection) (head *types.Header, pivot *ty
 			// and request. If only 1 header was returned, make sure there's no pivot
 			// or there was not one requested.
 			head := headers[0]
-			if mode == FastSync && head.Number.Uint64() < d.checkpoint {
+			if (mode == FastSync || mode == LightSync) && head.Number.Uint64() < d.checkpoint {
 				return nil, nil, fmt.Errorf("%w: remote head %d below checkpoint %d", errUnsyncedPeer, head.Number, d.checkpoint)
 			}
 			if len(headers) == 1 {
@@ -734,7 +804,7 @@ func (d *Downloader) findAncestor(p *peerConnection, remoteHeader *types.Header)
 	case FastSync:
 		localHeight = d.blockchain.CurrentFastBlock().NumberU64()
 	default:
-		localHeight = d.blockchain.CurrentHeader().Number.Uint64()
+		localHeight = d.lightchain.CurrentHeader().Number.Uint64()
 	}
 	p.log.Debug("Looking for common ancestor", "local", localHeight, "remote", remoteHeight)
 
@@ -743,6 +813,25 @@ func (d *Downloader) findAncestor(p *peerConnection, remoteHeader *types.Header)
 		// We're above the max reorg threshold, find the earliest fork point
 		floor = int64(localHeight - fullMaxForkAncestry)
 	}
+	// If we're doing a light sync, ensure the floor doesn't go below the CHT, as
+	// all headers before that point will be missing.
+	if mode == LightSync {
+		// If we don't know the current CHT position, find it
+		if d.genesis == 0 {
+			header := d.lightchain.CurrentHeader()
+			for header != nil {
+				d.genesis = header.Number.Uint64()
+				if floor >= int64(d.genesis)-1 {
+					break
+				}
+				header = d.lightchain.GetHeaderByHash(header.ParentHash)
+			}
+		}
+		// We already know the "genesis" block number, cap floor to that
+		if floor < int64(d.genesis)-1 {
+			floor = int64(d.genesis) - 1
+		}
+	}
 
 	ancestor, err := d.findAncestorSpanSearch(p, mode, remoteHeight, localHeight, floor)
 	if err == nil {
@@ -818,7 +907,7 @@ func (d *Downloader) findAncestorSpanSearch(p *peerConnection, mode SyncMode, re
 				case FastSync:
 					known = d.blockchain.HasFastBlock(h, n)
 				default:
-					known = d.blockchain.HasHeader(h, n)
+					known = d.lightchain.HasHeader(h, n)
 				}
 				if known {
 					number, hash = n, h
@@ -896,13 +985,13 @@ func (d *Downloader) findAncestorBinarySearch(p *peerConnection, mode SyncMode,
 				case FastSync:
 					known = d.blockchain.HasFastBlock(h, n)
 				default:
-					known = d.blockchain.HasHeader(h, n)
+					known = d.lightchain.HasHeader(h, n)
 				}
 				if !known {
 					end = check
 					break
 				}
-				header := d.blockchain.GetHeaderByHash(h) // Independent of sync mode, header surely exists
+				header := d.lightchain.GetHeaderByHash(h) // Independent of sync mode, header surely exists
 				if header.Number.Uint64() != check {
 					p.log.Warn("Received non requested header", "number", header.Number, "hash", header.Hash(), "request", check)
 					return 0, fmt.Errorf("%w: non-requested header (%d)", errBadPeer, header.Number)
@@ -1081,9 +1170,13 @@ func (d *Downloader) fetchHeaders(p *peerConnection, from uint64) error {
 				if n := len(headers); n > 0 {
 					// Retrieve the current head we're at
 					head := uint64(0)
-					head = d.blockchain.CurrentFastBlock().NumberU64()
-					if full := d.blockchain.CurrentBlock().NumberU64(); head < full {
-						head = full
+					if d.getMode() == LightSync {
+						head = d.lightchain.CurrentHeader().Number.Uint64()
+					} else {
+						head = d.blockchain.CurrentFastBlock().NumberU64()
+						if full := d.blockchain.CurrentBlock().NumberU64(); head < full {
+							head = full
+						}
 					}
 					if head < ancestor {
 						head = ancestor
@@ -1137,7 +1230,7 @@ func (d *Downloader) fetchHeaders(p *peerConnection, from uint64) error {
 			// Header retrieval timed out, consider the peer bad and drop
 			p.log.Debug("Header request timed out", "elapsed", ttl)
 			headerTimeoutMeter.Mark(1)
-			d.dropPeer(p.id)
+			d.dropPeer(p.id, errTimeout)
 
 			// Finish the sync gracefully instead of dumping the gathered data though
 			for _, ch := range []chan bool{d.bodyWakeCh, d.receiptWakeCh} {
@@ -1362,7 +1455,7 @@ func (d *Downloader) fetchParts(deliveryCh chan dataPack, deliver func(dataPack)
 							// Timeouts can occur if e.g. compaction hits at the wrong time, and can be ignored
 							peer.log.Warn("Downloader wants to drop peer, but peerdrop-function is not set", "peer", pid)
 						} else {
-							d.dropPeer(pid)
+							d.dropPeer(pid, errStallingPeer)
 
 							// If this peer was the master peer, abort sync immediately
 							d.cancelLock.RLock()
@@ -1442,8 +1535,10 @@ func (d *Downloader) fetchParts(deliveryCh chan dataPack, deliver func(dataPack)
 
 // processHeaders takes batches of retrieved headers from an input channel and
 // keeps processing and scheduling them into the header chain and downloader's
-// queue until the stream ends or a failure occurs.
-func (d *Downloader) processHeaders(origin uint64, td *big.Int) error {
+// queue until the stream ends or a failure occurs. If a seal verifier is given,
+// the seals of the headers below the checkpoint are only sampled on import and
+// fully verified in the background.
+func (d *Downloader) processHeaders(origin uint64, td *big.Int, seals *sealVerifier) error {
 	// Keep a count of uncertain headers to roll back
 	var (
 		rollback    uint64 // Zero means no rollback (fine as you can't unroll the genesis)
@@ -1452,22 +1547,42 @@ func (d *Downloader) processHeaders(origin uint64, td *big.Int) error {
 	)
 	defer func() {
 		if rollback > 0 {
-			lastHeader, lastFastBlock, lastBlock := d.blockchain.CurrentHeader().Number, common.Big0, common.Big0
-			lastFastBlock = d.blockchain.CurrentFastBlock().Number()
-			lastBlock = d.blockchain.CurrentBlock().Number()
-			if err := d.blockchain.SetHead(rollback - 1); err != nil { // -1 to target the parent of the first uncertain block
+			lastHeader, lastFastBlock, lastBlock := d.lightchain.CurrentHeader().Number, common.Big0, common.Big0
+			if mode != LightSync {
+				lastFastBlock = d.blockchain.CurrentFastBlock().Number()
+				lastBlock = d.blockchain.CurrentBlock().Number()
+			}
+			if err := d.lightchain.SetHead(rollback - 1); err != nil { // -1 to target the parent of the first uncertain block
 				// We're already unwinding the stack, only print the error to make it more visible
 				log.Error("Failed to roll back chain segment", "head", rollback-1, "err", err)
 			}
 			curFastBlock, curBlock := common.Big0, common.Big0
-			curFastBlock = d.blockchain.CurrentFastBlock().Number()
-			curBlock = d.blockchain.CurrentBlock().Number()
+			if mode != LightSync {
+				curFastBlock = d.blockchain.CurrentFastBlock().Number()
+				curBlock = d.blockchain.CurrentBlock().Number()
+			}
 			log.Warn("Rolled back chain segment",
-				"header", fmt.Sprintf("%d->%d", lastHeader, d.blockchain.CurrentHeader().Number),
+				"header", fmt.Sprintf("%d->%d", lastHeader, d.lightchain.CurrentHeader().Number),
 				"fast", fmt.Sprintf("%d->%d", lastFastBlock, curFastBlock),
 				"block", fmt.Sprintf("%d->%d", lastBlock, curBlock), "reason", rollbackErr)
 		}
 	}()
+	// sealFailure aborts the sync if the background seal verification found an
+	// invalid header, rolling back to before it
+	sealFailure := func() error {
+		if seals == nil {
+			return nil
+		}
+		header, err := seals.failure()
+		if err == nil {
+			return nil
+		}
+		if number := header.Number.Uint64(); rollback == 0 || number < rollback {
+			rollback = number
+		}
+		rollbackErr = err
+		return fmt.Errorf("%w: header #%d [%x…]: %v", errInvalidChain, header.Number, header.Hash().Bytes()[:4], err)
+	}
 	// Wait for batches of headers to process
 	gotHeaders := false
 
@@ -1499,9 +1614,11 @@ func (d *Downloader) processHeaders(origin uint64, td *big.Int) error {
 				// L: Sync begins, and finds common ancestor at 11
 				// L: Request new headers up from 11 (R's TD was higher, it must have something)
 				// R: Nothing to give
-				head := d.blockchain.CurrentBlock()
-				if !gotHeaders && td.Cmp(d.blockchain.GetTd(head.Hash(), head.NumberU64())) > 0 {
-					return errStallingPeer
+				if mode != LightSync {
+					head := d.blockchain.CurrentBlock()
+					if !gotHeaders && td.Cmp(d.blockchain.GetTd(head.Hash(), head.NumberU64())) > 0 {
+						return errStallingPeer
+					}
 				}
 				// If fast or light syncing, ensure promised headers are indeed delivered. This is
 				// needed to detect scenarios where an attacker feeds a bad pivot and then bails out
@@ -1510,12 +1627,22 @@ func (d *Downloader) processHeaders(origin uint64, td *big.Int) error {
 				// This check cannot be executed "as is" for full imports, since blocks may still be
 				// queued for processing when the header download completes. However, as long as the
 				// peer gave us something useful, we're already happy/progressed (above check).
-				if mode == FastSync {
-					head := d.blockchain.CurrentHeader()
-					if td.Cmp(d.blockchain.GetTd(head.Hash(), head.Number.Uint64())) > 0 {
+				if mode == FastSync || mode == LightSync {
+					head := d.lightchain.CurrentHeader()
+					if td.Cmp(d.lightchain.GetTd(head.Hash(), head.Number.Uint64())) > 0 {
 						return errStallingPeer
 					}
 				}
+				// If seals were sampled, wait for their full verification to finish
+				if seals != nil {
+					if err := seals.wait(d.cancelCh); err != nil {
+						rollbackErr = err
+						return err
+					}
+					if err := sealFailure(); err != nil {
+						return err
+					}
+				}
 				// Disable any rollback and return
 				rollback = 0
 				return nil
@@ -1530,6 +1657,9 @@ func (d *Downloader) processHeaders(origin uint64, td *big.Int) error {
 					return errCanceled
 				default:
 				}
+				if err := sealFailure(); err != nil {
+					return err
+				}
 				// Select the next chunk of headers to import
 				limit := maxHeadersProcess
 				if limit > len(headers) {
@@ -1538,7 +1668,7 @@ func (d *Downloader) processHeaders(origin uint64, td *big.Int) error {
 				chunk := headers[:limit]
 
 				// In case of header only syncing, validate the chunk immediately
-				if mode == FastSync {
+				if mode == FastSync || mode == LightSync {
 					// If we're importing pure headers, verify based on their recentness
 					var pivot uint64
 
@@ -1552,7 +1682,18 @@ func (d *Downloader) processHeaders(origin uint64, td *big.Int) error {
 					if chunk[len(chunk)-1].Number.Uint64()+uint64(fsHeaderForceVerify) > pivot {
 						frequency = 1
 					}
-					if n, err := d.blockchain.InsertHeaderChain(chunk, frequency); err != nil {
+					// Below the checkpoint, verify a sample of the seals up front and leave
+					// the rest to the background verifier
+					sampled := seals != nil && frequency > 1 && chunk[len(chunk)-1].Number.Uint64() <= d.checkpoint
+					if sampled {
+						if err := seals.sample(chunk); err != nil {
+							rollbackErr = err
+							log.Warn("Invalid header seal encountered", "err", err)
+							return fmt.Errorf("%w: %v", errInvalidChain, err)
+						}
+						frequency = 0
+					}
+					if n, err := d.lightchain.InsertHeaderChain(chunk, frequency); err != nil {
 						rollbackErr = err
 
 						// If some headers were inserted, track them as uncertain
@@ -1562,6 +1703,12 @@ func (d *Downloader) processHeaders(origin uint64, td *big.Int) error {
 						log.Warn("Invalid header encountered", "number", chunk[n].Number, "hash", chunk[n].Hash(), "parent", chunk[n].ParentHash, "err", err)
 						return fmt.Errorf("%w: %v", errInvalidChain, err)
 					}
+					if sampled {
+						if err := seals.schedule(chunk, d.cancelCh); err != nil {
+							rollbackErr = err
+							return err
+						}
+					}
 					// All verifications passed, track all headers within the alloted limits
 					if mode == FastSync {
 						head := chunk[len(chunk)-1].Number.Uint64()
@@ -1880,6 +2027,32 @@ func (d *Downloader) DeliverNodeData(id string, data [][]byte) (err error) {
 	return d.deliver(id, d.stateCh, &statePack{id, data}, stateInMeter, stateDropMeter)
 }
 
+// DeliverSnapPacket is invoked from a peer's message handler when it transmits a
+// data packet for the local node to consume.
+func (d *Downloader) DeliverSnapPacket(peer *snap.Peer, packet snap.Packet) error {
+	switch packet := packet.(type) {
+	case *snap.AccountRangePacket:
+		hashes, accounts, err := packet.Unpack()
+		if err != nil {
+			return err
+		}
+		return d.SnapSyncer.OnAccounts(peer, packet.ID, hashes, accounts, packet.Proof)
+
+	case *snap.StorageRangesPacket:
+		hashset, slotset := packet.Unpack()
+		return d.SnapSyncer.OnStorage(peer, packet.ID, hashset, slotset, packet.Proof)
+
+	case *snap.ByteCodesPacket:
+		return d.SnapSyncer.OnByteCodes(peer, packet.ID, packet.Codes)
+
+	case *snap.TrieNodesPacket:
+		return d.SnapSyncer.OnTrieNodes(peer, packet.ID, packet.Nodes)
+
+	default:
+		return fmt.Errorf("unexpected snap packet type: %T", packet)
+	}
+}
+
 // deliver injects a new batch of data received from a remote node.
 func (d *Downloader) deliver(id string, destCh chan dataPack, packet dataPack, inMeter, dropMeter metrics.Meter) (err error) {
 	// Update the delivery metrics for both good and failed deliveries
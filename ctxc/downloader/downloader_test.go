@@ -21,6 +21,8 @@ import (
 	"fmt"
 	cortex "github.com/CortexFoundation/CortexTheseus"
 	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/consensus"
+	"github.com/CortexFoundation/CortexTheseus/consensus/cuckoo"
 	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
 	"github.com/CortexFoundation/CortexTheseus/core/types"
 	"github.com/CortexFoundation/CortexTheseus/ctxcdb"
@@ -61,6 +63,8 @@ type downloadTester struct {
 	ancientReceipts map[common.Hash]types.Receipts // Ancient receipts belonging to the tester
 	ancientChainTd  map[common.Hash]*big.Int       // Ancient total difficulties of the blocks in the local chain
 
+	engine consensus.Engine // Consensus engine verifying the seals of the synced headers
+
 	lock sync.RWMutex
 }
 
@@ -81,11 +85,13 @@ func newTester() *downloadTester {
 		ancientBlocks:   map[common.Hash]*types.Block{testGenesis.Hash(): testGenesis},
 		ancientReceipts: map[common.Hash]types.Receipts{testGenesis.Hash(): nil},
 		ancientChainTd:  map[common.Hash]*big.Int{testGenesis.Hash(): testGenesis.Difficulty()},
+
+		engine: cuckoo.NewFaker(),
 	}
 	tester.stateDb = rawdb.NewMemoryDatabase()
 	tester.stateDb.Put(testGenesis.Root().Bytes(), []byte{0x00})
 
-	tester.downloader = New(0, tester.stateDb, trie.NewSyncBloom(1, tester.stateDb), new(event.TypeMux), tester, tester.dropPeer)
+	tester.downloader = New(0, tester.stateDb, trie.NewSyncBloom(1, tester.stateDb), new(event.TypeMux), tester, nil, tester.dropPeer)
 	return tester
 }
 
@@ -335,6 +341,11 @@ func (dl *downloadTester) InsertReceiptChain(blocks types.Blocks, receipts []typ
 	return len(blocks), nil
 }
 
+// Engine retrieves the consensus engine verifying the simulated chain's seals.
+func (dl *downloadTester) Engine() consensus.Engine {
+	return dl.engine
+}
+
 // SetHead rewinds the local chain to a new head.
 func (dl *downloadTester) SetHead(head uint64) error {
 	dl.lock.Lock()
@@ -394,7 +405,7 @@ func (dl *downloadTester) newPeer(id string, version int, chain *testChain) erro
 }
 
 // dropPeer simulates a hard peer removal from the connection pool.
-func (dl *downloadTester) dropPeer(id string) {
+func (dl *downloadTester) dropPeer(id string, reason error) {
 	dl.lock.Lock()
 	defer dl.lock.Unlock()
 
@@ -495,6 +506,9 @@ func assertOwnForkedChain(t *testing.T, tester *downloadTester, common int, leng
 		blocks += length - common
 		receipts += length - common
 	}
+	if tester.downloader.getMode() == LightSync {
+		blocks, receipts = 1, 1
+	}
 	if hs := len(tester.ownHeaders) + len(tester.ancientHeaders) - 1; hs != headers {
 		t.Fatalf("synchronised headers mismatch: have %v, want %v", hs, headers)
 	}
@@ -514,6 +528,11 @@ func assertOwnForkedChain(t *testing.T, tester *downloadTester, common int, leng
 //func TestCanonicalSynchronisation63Fast(t *testing.T) { testCanonicalSynchronisation(t, 63, FastSync) }
 func TestCanonicalSynchronisation64Full(t *testing.T) { testCanonicalSynchronisation(t, 64, FullSync) }
 func TestCanonicalSynchronisation64Fast(t *testing.T) { testCanonicalSynchronisation(t, 64, FastSync) }
+func TestCanonicalSynchronisation64Light(t *testing.T) {
+	testCanonicalSynchronisation(t, 64, LightSync)
+}
+func TestCanonicalSynchronisation66Full(t *testing.T) { testCanonicalSynchronisation(t, 66, FullSync) }
+func TestCanonicalSynchronisation66Fast(t *testing.T) { testCanonicalSynchronisation(t, 66, FastSync) }
 
 func testCanonicalSynchronisation(t *testing.T, protocol int, mode SyncMode) {
 	t.Parallel()
@@ -623,6 +642,7 @@ func testThrottling(t *testing.T, protocol int, mode SyncMode) {
 //func TestForkedSync63Fast(t *testing.T) { testForkedSync(t, 63, FastSync) }
 func TestForkedSync64Full(t *testing.T) { testForkedSync(t, 64, FullSync) }
 func TestForkedSync64Fast(t *testing.T) { testForkedSync(t, 64, FastSync) }
+func TestForkedSync64Light(t *testing.T) { testForkedSync(t, 64, LightSync) }
 
 func testForkedSync(t *testing.T, protocol int, mode SyncMode) {
 	t.Parallel()
@@ -1638,6 +1658,9 @@ func TestRemoteHeaderRequestSpan(t *testing.T) {
 //func TestCheckpointEnforcement63Fast(t *testing.T) { testCheckpointEnforcement(t, 63, FastSync) }
 func TestCheckpointEnforcement64Full(t *testing.T) { testCheckpointEnforcement(t, 64, FullSync) }
 func TestCheckpointEnforcement64Fast(t *testing.T) { testCheckpointEnforcement(t, 64, FastSync) }
+func TestCheckpointEnforcement64Light(t *testing.T) {
+	testCheckpointEnforcement(t, 64, LightSync)
+}
 
 func testCheckpointEnforcement(t *testing.T, protocol int, mode SyncMode) {
 	t.Parallel()
@@ -1653,15 +1676,102 @@ func testCheckpointEnforcement(t *testing.T, protocol int, mode SyncMode) {
 	tester.newPeer("peer", protocol, chain)
 
 	var expect error
-	if mode == FastSync {
+	if mode == FastSync || mode == LightSync {
 		expect = errUnsyncedPeer
 	}
 	if err := tester.sync("peer", nil, mode); !errors.Is(err, expect) {
 		t.Fatalf("block sync error mismatch: have %v, want %v", err, expect)
 	}
-	if mode == FastSync {
+	if mode == FastSync || mode == LightSync {
 		assertOwnChain(t, tester, 1)
 	} else {
 		assertOwnChain(t, tester, chain.len())
 	}
 }
+
+// Tests that checkpoint sync imports the headers below the checkpoint with only
+// a sample of their seals checked, and fully verifies them in the background.
+func TestCheckpointSync64(t *testing.T) { testCheckpointSync(t, 64) }
+func TestCheckpointSync65(t *testing.T) { testCheckpointSync(t, 65) }
+func TestCheckpointSync66(t *testing.T) { testCheckpointSync(t, 66) }
+
+func testCheckpointSync(t *testing.T, protocol int) {
+	t.Parallel()
+
+	tester := newTester()
+	defer tester.terminate()
+
+	chain := testChainBase.shorten(blockCacheMaxItems - 15)
+	tester.downloader.checkpoint = uint64(chain.len() / 2)
+	tester.newPeer("peer", protocol, chain)
+
+	if err := tester.sync("peer", nil, CheckpointSync); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	assertOwnChain(t, tester, chain.len())
+
+	progress := tester.downloader.Progress()
+	if progress.KnownSeals == 0 || progress.KnownSeals > tester.downloader.checkpoint {
+		t.Errorf("known seal count mismatch: have %d, want in (0, %d]", progress.KnownSeals, tester.downloader.checkpoint)
+	}
+	if progress.VerifiedSeals != progress.KnownSeals {
+		t.Errorf("verified seal count mismatch: have %d, want %d", progress.VerifiedSeals, progress.KnownSeals)
+	}
+	if progress.SampledSeals == 0 || progress.SampledSeals >= progress.KnownSeals {
+		t.Errorf("sampled seal count mismatch: have %d, want in (0, %d)", progress.SampledSeals, progress.KnownSeals)
+	}
+}
+
+// Tests that seal sampling is only enabled for the sync cycles running in
+// checkpoint mode, and not for the fast syncs after them.
+func TestCheckpointSyncNotSticky(t *testing.T) {
+	t.Parallel()
+
+	tester := newTester()
+	defer tester.terminate()
+
+	chain := testChainBase.shorten(blockCacheMaxItems - 15)
+	tester.downloader.checkpoint = uint64(chain.len() / 8)
+	tester.newPeer("peer", 65, chain.shorten(chain.len()/4))
+
+	if err := tester.sync("peer", nil, CheckpointSync); err != nil {
+		t.Fatalf("failed to checkpoint sync: %v", err)
+	}
+	if !tester.downloader.checkpointSync {
+		t.Fatalf("seal sampling disabled during checkpoint sync")
+	}
+	tester.newPeer("longer", 65, chain)
+	if err := tester.sync("longer", nil, FastSync); err != nil {
+		t.Fatalf("failed to fast sync: %v", err)
+	}
+	if tester.downloader.checkpointSync {
+		t.Fatalf("seal sampling still enabled during fast sync")
+	}
+	assertOwnChain(t, tester, chain.len())
+}
+
+// Tests that an invalid seal below the checkpoint fails a checkpoint sync, whether
+// it is caught by the sampling or only by the background verification, and that
+// the imported headers are rolled back.
+func TestCheckpointSyncInvalidSeal64(t *testing.T) { testCheckpointSyncInvalidSeal(t, 64) }
+func TestCheckpointSyncInvalidSeal65(t *testing.T) { testCheckpointSyncInvalidSeal(t, 65) }
+func TestCheckpointSyncInvalidSeal66(t *testing.T) { testCheckpointSyncInvalidSeal(t, 66) }
+
+func testCheckpointSyncInvalidSeal(t *testing.T, protocol int) {
+	t.Parallel()
+
+	tester := newTester()
+	defer tester.terminate()
+
+	chain := testChainBase.shorten(blockCacheMaxItems - 15)
+	tester.downloader.checkpoint = uint64(chain.len() / 2)
+	tester.engine = cuckoo.NewFakeFailer(tester.downloader.checkpoint / 2)
+	tester.newPeer("peer", protocol, chain)
+
+	if err := tester.sync("peer", nil, CheckpointSync); !errors.Is(err, errInvalidChain) {
+		t.Fatalf("sync error mismatch: have %v, want %v", err, errInvalidChain)
+	}
+	if head := tester.CurrentHeader().Number.Uint64(); head >= tester.downloader.checkpoint/2 {
+		t.Errorf("invalid header not rolled back: head %d, invalid %d", head, tester.downloader.checkpoint/2)
+	}
+}
@@ -23,12 +23,15 @@ import "fmt"
 type SyncMode uint32
 
 const (
-	FullSync SyncMode = iota // Synchronise the entire blockchain history from full blocks
-	FastSync                 // Quickly download the headers, full sync only at the chain head
+	FullSync       SyncMode = iota // Synchronise the entire blockchain history from full blocks
+	FastSync                       // Quickly download the headers, full sync only at the chain head
+	SnapSync                       // Download the chain and the state via compact snapshots
+	LightSync                      // Download only the headers and terminate afterwards
+	CheckpointSync                 // Fast sync sampling the seals below the trusted checkpoint
 )
 
 func (mode SyncMode) IsValid() bool {
-	return mode >= FullSync && mode <= FastSync
+	return mode >= FullSync && mode <= CheckpointSync
 }
 
 // String implements the stringer interface.
@@ -38,6 +41,12 @@ func (mode SyncMode) String() string {
 		return "full"
 	case FastSync:
 		return "fast"
+	case SnapSync:
+		return "snap"
+	case CheckpointSync:
+		return "checkpoint"
+	case LightSync:
+		return "light"
 	default:
 		return "unknown"
 	}
@@ -49,6 +58,12 @@ func (mode SyncMode) MarshalText() ([]byte, error) {
 		return []byte("full"), nil
 	case FastSync:
 		return []byte("fast"), nil
+	case SnapSync:
+		return []byte("snap"), nil
+	case CheckpointSync:
+		return []byte("checkpoint"), nil
+	case LightSync:
+		return []byte("light"), nil
 	default:
 		return nil, fmt.Errorf("unknown sync mode %d", mode)
 	}
@@ -60,8 +75,14 @@ func (mode *SyncMode) UnmarshalText(text []byte) error {
 		*mode = FullSync
 	case "fast":
 		*mode = FastSync
+	case "snap":
+		*mode = SnapSync
+	case "checkpoint":
+		*mode = CheckpointSync
+	case "light":
+		*mode = LightSync
 	default:
-		return fmt.Errorf(`unknown sync mode %q, want "full", "fast"`, text)
+		return fmt.Errorf(`unknown sync mode %q, want "full", "fast", "snap", "checkpoint" or "light"`, text)
 	}
 	return nil
 }
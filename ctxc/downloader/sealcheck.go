@@ -0,0 +1,213 @@
+// Copyright 2020 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package downloader
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/consensus"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/log"
+)
+
+var (
+	sealSampleSize = 32 // Number of seals verified in every header batch before importing it
+	sealQueueSize  = 16 // Number of imported header batches allowed to wait for full seal verification
+)
+
+// sealSyncStats is the seal verification progress of checkpoint syncs. The
+// fields are accessed atomically.
+type sealSyncStats struct {
+	sampled  uint64 // Number of seals verified before importing their batch
+	verified uint64 // Number of seals fully verified in the background
+	known    uint64 // Number of seals scheduled for full verification
+}
+
+// sealTask is a batch of imported headers waiting for full seal verification.
+// A task without headers is a barrier, closing done once all the batches
+// scheduled before it have been verified.
+type sealTask struct {
+	headers []*types.Header
+	done    chan struct{}
+}
+
+// sealVerifier checks the Cuckaroo seals of the headers imported below the
+// trusted checkpoint during a checkpoint sync. Since every seal check is an
+// expensive cgo call, only a random sample of each batch is verified before
+// the batch is imported, while all the seals are verified by a background task
+// as the sync proceeds.
+type sealVerifier struct {
+	engine consensus.Engine
+	stats  *sealSyncStats
+	rand   *rand.Rand // Only used by the header processor, no locking needed
+
+	tasks chan *sealTask
+	quit  chan struct{}
+	done  chan struct{}
+
+	failed *types.Header // First header found with an invalid seal
+	err    error         // Verification failure of the failed header
+	lock   sync.Mutex    // Protects the failure fields
+}
+
+// newSealVerifier creates a seal verifier and starts its background task.
+func newSealVerifier(engine consensus.Engine, stats *sealSyncStats) *sealVerifier {
+	v := &sealVerifier{
+		engine: engine,
+		stats:  stats,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		tasks:  make(chan *sealTask, sealQueueSize),
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go v.loop()
+	return v
+}
+
+// close terminates the background verification, abandoning any queued batches.
+func (v *sealVerifier) close() {
+	close(v.quit)
+	<-v.done
+}
+
+// sample verifies the seals of a random subset of a header batch in parallel,
+// always including the last header to avoid importing junk.
+func (v *sealVerifier) sample(headers []*types.Header) error {
+	if len(headers) == 0 {
+		return nil
+	}
+	picks := v.rand.Perm(len(headers) - 1)
+	if len(picks) > sealSampleSize-1 {
+		picks = picks[:sealSampleSize-1]
+	}
+	sample := make([]*types.Header, 0, len(picks)+1)
+	for _, index := range picks {
+		sample = append(sample, headers[index])
+	}
+	sample = append(sample, headers[len(headers)-1])
+
+	if index, err := verifySeals(v.engine, sample, &v.stats.sampled); err != nil {
+		return fmt.Errorf("header #%d [%x…]: %v", sample[index].Number, sample[index].Hash().Bytes()[:4], err)
+	}
+	return nil
+}
+
+// schedule queues an imported header batch for full seal verification. It only
+// blocks if the background task fell too far behind the import.
+func (v *sealVerifier) schedule(headers []*types.Header, cancel <-chan struct{}) error {
+	atomic.AddUint64(&v.stats.known, uint64(len(headers)))
+	select {
+	case v.tasks <- &sealTask{headers: headers}:
+		return nil
+	case <-cancel:
+		return errCanceled
+	}
+}
+
+// wait blocks until all the scheduled batches have been verified.
+func (v *sealVerifier) wait(cancel <-chan struct{}) error {
+	barrier := &sealTask{done: make(chan struct{})}
+	select {
+	case v.tasks <- barrier:
+	case <-cancel:
+		return errCanceled
+	}
+	select {
+	case <-barrier.done:
+		return nil
+	case <-cancel:
+		return errCanceled
+	}
+}
+
+// failure returns the first header the background verification found with an
+// invalid seal, along with the reason, if any.
+func (v *sealVerifier) failure() (*types.Header, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	return v.failed, v.err
+}
+
+// loop is the background task fully verifying the scheduled header batches.
+// After a failure the remaining batches are skipped, as the sync is aborted.
+func (v *sealVerifier) loop() {
+	defer close(v.done)
+
+	for {
+		select {
+		case task := <-v.tasks:
+			if len(task.headers) > 0 {
+				if header, _ := v.failure(); header == nil {
+					if index, err := verifySeals(v.engine, task.headers, &v.stats.verified); err != nil {
+						header := task.headers[index]
+						log.Warn("Invalid header seal found in background", "number", header.Number, "hash", header.Hash(), "err", err)
+
+						v.lock.Lock()
+						v.failed, v.err = header, err
+						v.lock.Unlock()
+					}
+				}
+			}
+			if task.done != nil {
+				close(task.done)
+			}
+		case <-v.quit:
+			return
+		}
+	}
+}
+
+// verifySeals checks the seals of a batch of headers concurrently, counting the
+// valid ones in progress. It returns the index of the lowest invalid header
+// along with its error, or -1 if all of them check out.
+func verifySeals(engine consensus.Engine, headers []*types.Header, progress *uint64) (int, error) {
+	workers := runtime.GOMAXPROCS(0)
+	if len(headers) < workers {
+		workers = len(headers)
+	}
+	var (
+		errs = make([]error, len(headers))
+		next = int32(-1)
+		wg   sync.WaitGroup
+	)
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for index := int(atomic.AddInt32(&next, 1)); index < len(headers); index = int(atomic.AddInt32(&next, 1)) {
+				// Cuckoo seals are self-contained, no chain context is needed
+				if errs[index] = engine.VerifySeal(nil, headers[index]); errs[index] == nil {
+					atomic.AddUint64(progress, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for index, err := range errs {
+		if err != nil {
+			return index, err
+		}
+	}
+	return -1, nil
+}
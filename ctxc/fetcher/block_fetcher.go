@@ -19,6 +19,7 @@ package fetcher
 
 import (
 	"errors"
+	"fmt"
 	"math/rand"
 	"time"
 
@@ -68,8 +69,9 @@ type chainHeightFn func() uint64
 // chainInsertFn is a callback type to insert a batch of blocks into the local chain.
 type chainInsertFn func(types.Blocks) (int, error)
 
-// peerDropFn is a callback type for dropping a peer detected as malicious.
-type peerDropFn func(id string)
+// peerDropFn is a callback type for dropping a peer detected as malicious, along
+// with the reason it's dropped for.
+type peerDropFn func(id string, reason error)
 
 // announce is the hash notification of the availability of a new block in the
 // network.
@@ -466,7 +468,7 @@ func (f *Fetcher) loop() {
 					// If the delivered header does not match the promised number, drop the announcer
 					if header.Number.Uint64() != announce.number {
 						log.Trace("Invalid block number fetched", "peer", announce.origin, "hash", header.Hash(), "announced", announce.number, "provided", header.Number)
-						f.dropPeer(announce.origin)
+						f.dropPeer(announce.origin, fmt.Errorf("%w: announced %d, provided %d", consensus.ErrInvalidNumber, announce.number, header.Number))
 						f.forgetHash(hash)
 						continue
 					}
@@ -688,7 +690,7 @@ func (f *Fetcher) insert(peer string, block *types.Block) {
 		default:
 			// Something went very wrong, drop the peer
 			log.Debug("Propagated block verification failed", "peer", peer, "number", block.Number(), "hash", hash, "err", err)
-			f.dropPeer(peer)
+			f.dropPeer(peer, err)
 			return
 		}
 		// Run the actual import and log any issues
@@ -0,0 +1,252 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexFoundation library.
+//
+// The CortexFoundation library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexFoundation library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexFoundation library. If not, see <http://www.gnu.org/licenses/>.
+
+package gasprice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync/atomic"
+
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/rpc"
+)
+
+// maxBlockFetchers is the maximum number of goroutines used to retrieve the
+// blocks of a fee history request.
+const maxBlockFetchers = 4
+
+var (
+	errInvalidPercentile = errors.New("invalid reward percentile")
+	errRequestBeyondHead = errors.New("request beyond head block")
+)
+
+// blockFees represents a single block for processing
+type blockFees struct {
+	// set by the caller
+	blockNumber uint64
+	header      *types.Header
+	parent      *types.Header
+	block       *types.Block // only set if reward percentiles are requested
+	receipts    types.Receipts
+	// filled by processBlock
+	reward                       []*big.Int
+	gasUsedRatio, quotaUsedRatio float64
+	err                          error
+}
+
+// txGasAndReward is sorted in ascending order based on reward
+type (
+	txGasAndReward struct {
+		gasUsed uint64
+		reward  *big.Int
+	}
+	sortGasAndReward []txGasAndReward
+)
+
+func (s sortGasAndReward) Len() int           { return len(s) }
+func (s sortGasAndReward) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s sortGasAndReward) Less(i, j int) bool { return s[i].reward.Cmp(s[j].reward) < 0 }
+
+// processBlock takes a blockFees structure with the blockNumber, the header, its
+// parent and optionally the block and receipts filled in, and calculates the rest
+// of the fields.
+//
+// Cortex headers track the quota cumulatively, so the quota usage of a single
+// block is derived from the difference to its parent.
+func (gpo *Oracle) processBlock(bf *blockFees, percentiles []float64) {
+	if bf.header.GasLimit > 0 {
+		bf.gasUsedRatio = float64(bf.header.GasUsed) / float64(bf.header.GasLimit)
+	}
+	if bf.parent != nil && bf.header.Quota > bf.parent.QuotaUsed {
+		available := bf.header.Quota - bf.parent.QuotaUsed
+		bf.quotaUsedRatio = float64(bf.header.QuotaUsed-bf.parent.QuotaUsed) / float64(available)
+	}
+	if len(percentiles) == 0 {
+		// rewards were not requested, return null
+		return
+	}
+	if bf.block == nil || (bf.receipts == nil && len(bf.block.Transactions()) != 0) {
+		log.Error("Block or receipts are missing while reward percentiles are requested")
+		return
+	}
+
+	bf.reward = make([]*big.Int, len(percentiles))
+	if len(bf.block.Transactions()) == 0 {
+		// return an all zero row if there are no transactions to gather data from
+		for i := range bf.reward {
+			bf.reward[i] = new(big.Int)
+		}
+		return
+	}
+
+	sorter := make(sortGasAndReward, len(bf.block.Transactions()))
+	for i, tx := range bf.block.Transactions() {
+		sorter[i] = txGasAndReward{gasUsed: bf.receipts[i].GasUsed, reward: tx.GasPrice()}
+	}
+	sort.Sort(sorter)
+
+	var txIndex int
+	sumGasUsed := sorter[0].gasUsed
+
+	for i, p := range percentiles {
+		thresholdGasUsed := uint64(float64(bf.block.GasUsed()) * p / 100)
+		for sumGasUsed < thresholdGasUsed && txIndex < len(bf.block.Transactions())-1 {
+			txIndex++
+			sumGasUsed += sorter[txIndex].gasUsed
+		}
+		bf.reward[i] = sorter[txIndex].reward
+	}
+}
+
+// resolveBlockRange resolves the specified block range to absolute block numbers
+// while also enforcing backend specific limitations. The pending block is not
+// supported since its header is only known by the miner.
+// Note: an error is only returned if retrieving the head header has failed. If
+// there are no retrievable blocks in the specified range then zero block count
+// is returned with no error.
+func (gpo *Oracle) resolveBlockRange(ctx context.Context, lastBlock rpc.BlockNumber, blocks int) (uint64, int, error) {
+	if lastBlock == rpc.PendingBlockNumber {
+		lastBlock = rpc.LatestBlockNumber
+	}
+	head, err := gpo.backend.HeaderByNumber(ctx, rpc.LatestBlockNumber)
+	if err != nil {
+		return 0, 0, err
+	}
+	if head == nil {
+		return 0, 0, errors.New("head header not found")
+	}
+	headBlock := head.Number.Uint64()
+	if lastBlock == rpc.LatestBlockNumber {
+		lastBlock = rpc.BlockNumber(headBlock)
+	} else if uint64(lastBlock) > headBlock {
+		return 0, 0, fmt.Errorf("%w: requested %d, head %d", errRequestBeyondHead, lastBlock, headBlock)
+	}
+	// ensure not trying to retrieve before genesis
+	if rpc.BlockNumber(blocks) > lastBlock+1 {
+		blocks = int(lastBlock + 1)
+	}
+	return uint64(lastBlock), blocks, nil
+}
+
+// FeeHistory returns data relevant for fee estimation based on the specified range of blocks.
+// The range can be specified either with absolute block numbers or ending with the latest
+// block. Blocks are processed concurrently and the results are returned in ascending
+// order. The following data is returned for each block:
+//   - the gas used ratio, i.e. the fraction of the block gas limit that was spent
+//   - the quota used ratio, i.e. the fraction of the available upload quota that was consumed
+//   - optionally, the gas price paid at the given percentiles of the total gas used
+//     in the block, weighted by the gas used by each transaction
+//
+// Note: reward percentiles should be specified in ascending order and between 0 and 100.
+// The number of blocks processed is limited by the MaxHeaderHistory setting and, if
+// percentiles are requested, by MaxBlockHistory.
+func (gpo *Oracle) FeeHistory(ctx context.Context, blocks int, unresolvedLastBlock rpc.BlockNumber, rewardPercentiles []float64) (*big.Int, [][]*big.Int, []float64, []float64, error) {
+	if blocks < 1 {
+		return new(big.Int), nil, nil, nil, nil // returning with no data and no error means there are no retrievable blocks
+	}
+	maxHistory := gpo.maxHeaderHistory
+	if len(rewardPercentiles) != 0 {
+		maxHistory = gpo.maxBlockHistory
+	}
+	if blocks > maxHistory {
+		log.Warn("Sanitizing fee history length", "requested", blocks, "truncated", maxHistory)
+		blocks = maxHistory
+	}
+	for i, p := range rewardPercentiles {
+		if p < 0 || p > 100 {
+			return new(big.Int), nil, nil, nil, fmt.Errorf("%w: %f", errInvalidPercentile, p)
+		}
+		if i > 0 && p < rewardPercentiles[i-1] {
+			return new(big.Int), nil, nil, nil, fmt.Errorf("%w: #%d:%f > #%d:%f", errInvalidPercentile, i-1, rewardPercentiles[i-1], i, p)
+		}
+	}
+	lastBlock, blocks, err := gpo.resolveBlockRange(ctx, unresolvedLastBlock, blocks)
+	if err != nil || blocks == 0 {
+		return new(big.Int), nil, nil, nil, err
+	}
+	oldestBlock := lastBlock + 1 - uint64(blocks)
+
+	var (
+		next    = oldestBlock
+		results = make(chan *blockFees, blocks)
+	)
+	for i := 0; i < maxBlockFetchers && i < blocks; i++ {
+		go func() {
+			for {
+				// Retrieve the next block number to fetch with this goroutine
+				blockNumber := atomic.AddUint64(&next, 1) - 1
+				if blockNumber > lastBlock {
+					return
+				}
+
+				fees := &blockFees{blockNumber: blockNumber}
+				if len(rewardPercentiles) != 0 {
+					fees.block, fees.err = gpo.backend.BlockByNumber(ctx, rpc.BlockNumber(blockNumber))
+					if fees.block != nil && fees.err == nil {
+						fees.header = fees.block.Header()
+						fees.receipts, fees.err = gpo.backend.GetReceipts(ctx, fees.block.Hash())
+					}
+				} else {
+					fees.header, fees.err = gpo.backend.HeaderByNumber(ctx, rpc.BlockNumber(blockNumber))
+				}
+				if fees.header != nil && fees.err == nil && blockNumber > 0 {
+					fees.parent, fees.err = gpo.backend.HeaderByNumber(ctx, rpc.BlockNumber(blockNumber-1))
+				}
+				if fees.header != nil && fees.err == nil {
+					gpo.processBlock(fees, rewardPercentiles)
+				}
+				// send to results even if empty to guarantee that blocks items are sent in total
+				results <- fees
+			}
+		}()
+	}
+	var (
+		reward         = make([][]*big.Int, blocks)
+		gasUsedRatio   = make([]float64, blocks)
+		quotaUsedRatio = make([]float64, blocks)
+		firstMissing   = blocks
+	)
+	for ; blocks > 0; blocks-- {
+		fees := <-results
+		if fees.err != nil {
+			return new(big.Int), nil, nil, nil, fees.err
+		}
+		i := int(fees.blockNumber - oldestBlock)
+		if fees.header != nil {
+			reward[i], gasUsedRatio[i], quotaUsedRatio[i] = fees.reward, fees.gasUsedRatio, fees.quotaUsedRatio
+		} else {
+			// getting no block and no error means we are requesting into the future (might happen because of a reorg)
+			if i < firstMissing {
+				firstMissing = i
+			}
+		}
+	}
+	if firstMissing == 0 {
+		return new(big.Int), nil, nil, nil, nil
+	}
+	if len(rewardPercentiles) != 0 {
+		reward = reward[:firstMissing]
+	} else {
+		reward = nil
+	}
+	gasUsedRatio, quotaUsedRatio = gasUsedRatio[:firstMissing], quotaUsedRatio[:firstMissing]
+	return new(big.Int).SetUint64(oldestBlock), reward, gasUsedRatio, quotaUsedRatio, nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexFoundation library.
+//
+// The CortexFoundation library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexFoundation library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexFoundation library. If not, see <http://www.gnu.org/licenses/>.
+
+package gasprice
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/CortexFoundation/CortexTheseus/params"
+	"github.com/CortexFoundation/CortexTheseus/rpc"
+)
+
+func TestFeeHistory(t *testing.T) {
+	var cases = []struct {
+		maxHeader, maxBlock int
+		count               int
+		last                rpc.BlockNumber
+		percent             []float64
+		expFirst            uint64
+		expCount            int
+		expErr              error
+	}{
+		{1000, 1000, 10, 30, nil, 21, 10, nil},
+		{1000, 1000, 10, 30, []float64{0, 10}, 21, 10, nil},
+		{1000, 1000, 10, 30, []float64{20, 10}, 0, 0, errInvalidPercentile},
+		{1000, 1000, 1000000000, 30, nil, 0, 31, nil},
+		{1000, 1000, 1000000000, rpc.LatestBlockNumber, nil, 0, 33, nil},
+		{1000, 1000, 10, 40, nil, 0, 0, errRequestBeyondHead},
+		{20, 2, 100, rpc.LatestBlockNumber, nil, 13, 20, nil},
+		{20, 2, 100, rpc.LatestBlockNumber, []float64{0, 10}, 31, 2, nil},
+		{20, 2, 100, 32, []float64{0, 10}, 31, 2, nil},
+		{1000, 1000, 1, rpc.PendingBlockNumber, nil, 32, 1, nil},
+		{1000, 1000, 2, rpc.PendingBlockNumber, []float64{0, 10}, 31, 2, nil},
+	}
+	for i, c := range cases {
+		config := Config{
+			MaxHeaderHistory: c.maxHeader,
+			MaxBlockHistory:  c.maxBlock,
+		}
+		backend := newTestBackend(t)
+		oracle := NewOracle(backend, config)
+
+		first, reward, ratio, quota, err := oracle.FeeHistory(context.Background(), c.count, c.last, c.percent)
+
+		expReward := c.expCount
+		if len(c.percent) == 0 {
+			expReward = 0
+		}
+		if first.Uint64() != c.expFirst {
+			t.Fatalf("Test case %d: first block mismatch, want %d, got %d", i, c.expFirst, first)
+		}
+		if len(reward) != expReward {
+			t.Fatalf("Test case %d: reward array length mismatch, want %d, got %d", i, expReward, len(reward))
+		}
+		if len(ratio) != c.expCount {
+			t.Fatalf("Test case %d: gasUsedRatio array length mismatch, want %d, got %d", i, c.expCount, len(ratio))
+		}
+		if len(quota) != c.expCount {
+			t.Fatalf("Test case %d: quotaUsedRatio array length mismatch, want %d, got %d", i, c.expCount, len(quota))
+		}
+		if err != c.expErr && !errors.Is(err, c.expErr) {
+			t.Fatalf("Test case %d: error mismatch, want %v, got %v", i, c.expErr, err)
+		}
+	}
+}
+
+func TestFeeHistoryRewards(t *testing.T) {
+	backend := newTestBackend(t)
+	oracle := NewOracle(backend, Config{MaxHeaderHistory: 1024, MaxBlockHistory: 1024})
+
+	first, reward, ratio, _, err := oracle.FeeHistory(context.Background(), 3, rpc.LatestBlockNumber, []float64{0, 100})
+	if err != nil {
+		t.Fatalf("Failed to retrieve fee history: %v", err)
+	}
+	if first.Uint64() != 30 {
+		t.Fatalf("First block mismatch, want %d, got %d", 30, first)
+	}
+	for i := range reward {
+		number := int64(i) + 30
+		block := backend.chain.GetBlockByNumber(uint64(number))
+
+		// Every block contains the cheapest transfer at 1x and the most expensive
+		// upload at 3x the block number.
+		if want := big.NewInt(number * params.GWei); reward[i][0].Cmp(want) != 0 {
+			t.Errorf("block %d: lowest reward mismatch, want %v, got %v", number, want, reward[i][0])
+		}
+		if want := big.NewInt(3 * number * params.GWei); reward[i][1].Cmp(want) != 0 {
+			t.Errorf("block %d: highest reward mismatch, want %v, got %v", number, want, reward[i][1])
+		}
+		if want := float64(block.GasUsed()) / float64(block.GasLimit()); ratio[i] != want {
+			t.Errorf("block %d: gas used ratio mismatch, want %v, got %v", number, want, ratio[i])
+		}
+	}
+}
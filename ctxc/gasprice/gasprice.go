@@ -23,6 +23,7 @@ import (
 	"sync"
 
 	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core/state"
 	"github.com/CortexFoundation/CortexTheseus/core/types"
 	"github.com/CortexFoundation/CortexTheseus/log"
 	"github.com/CortexFoundation/CortexTheseus/params"
@@ -32,31 +33,87 @@ import (
 var DefaultMaxPrice = big.NewInt(500 * params.GWei)
 
 type Config struct {
-	Blocks     int
-	Percentile int
-	Default    *big.Int `toml:",omitempty"`
-	MaxPrice   *big.Int `toml:",omitempty"`
+	Blocks           int
+	Percentile       int
+	MaxHeaderHistory int
+	MaxBlockHistory  int
+	Default          *big.Int `toml:",omitempty"`
+	MaxPrice         *big.Int `toml:",omitempty"`
+}
+
+// TxClass identifies the resource a transaction mostly competes for. Plain
+// transfers and contract calls compete for block gas, while upload progress
+// transactions compete for the block quota.
+type TxClass int
+
+const (
+	TransferTx TxClass = iota // Value transfer without payload
+	CallTx                    // Contract call or contract creation
+	UploadTx                  // Model or input upload progress transaction
+
+	numTxClasses
+)
+
+// String implements fmt.Stringer.
+func (c TxClass) String() string {
+	switch c {
+	case TransferTx:
+		return "transfer"
+	case CallTx:
+		return "call"
+	case UploadTx:
+		return "upload"
+	default:
+		return "unknown"
+	}
+}
+
+// ClassifyTx returns the class of a transaction. Like in the state transition,
+// an empty, zero value call is an upload if its recipient has an upload in
+// progress, as reported by the uploading callback. Without a callback, all such
+// calls are classified as transfers.
+func ClassifyTx(tx *types.Transaction, uploading func(common.Address) bool) TxClass {
+	if tx.To() == nil || len(tx.Data()) > 0 {
+		return CallTx
+	}
+	// Uploads are charged the upload intrinsic gas, skip the state lookup for
+	// transactions which couldn't afford it
+	if tx.Value().Sign() == 0 && tx.Gas() >= params.UploadGas && uploading != nil && uploading(*tx.To()) {
+		return UploadTx
+	}
+	return TransferTx
+}
+
+// PriceSuggestion contains the recommended gas prices per transaction class.
+type PriceSuggestion struct {
+	Transfer *big.Int
+	Call     *big.Int
+	Upload   *big.Int
 }
 
 // OracleBackend includes all necessary background APIs for oracle.
 type OracleBackend interface {
 	HeaderByNumber(ctx context.Context, number rpc.BlockNumber) (*types.Header, error)
 	BlockByNumber(ctx context.Context, number rpc.BlockNumber) (*types.Block, error)
+	GetReceipts(ctx context.Context, hash common.Hash) (types.Receipts, error)
+	StateAndHeaderByNumber(ctx context.Context, number rpc.BlockNumber) (*state.StateDB, *types.Header, error)
 	ChainConfig() *params.ChainConfig
 }
 
 // Oracle recommends gas prices based on the content of recent
 // blocks. Suitable for both light and full clients.
 type Oracle struct {
-	backend   OracleBackend
-	lastHead  common.Hash
-	lastPrice *big.Int
-	maxPrice  *big.Int
-	cacheLock sync.RWMutex
-	fetchLock sync.Mutex
-
-	checkBlocks, maxEmpty, maxBlocks int
-	percentile                       int
+	backend    OracleBackend
+	lastHead   common.Hash
+	lastPrice  *big.Int
+	lastPrices [numTxClasses]*big.Int
+	maxPrice   *big.Int
+	cacheLock  sync.RWMutex
+	fetchLock  sync.Mutex
+
+	checkBlocks, maxEmpty, maxBlocks  int
+	percentile                        int
+	maxHeaderHistory, maxBlockHistory int
 }
 
 // NewOracle returns a new oracle.
@@ -80,28 +137,64 @@ func NewOracle(backend OracleBackend, params Config) *Oracle {
 		maxPrice = DefaultMaxPrice
 		log.Warn("Sanitizing invalid gasprice oracle price cap", "provided", params.MaxPrice, "updated", maxPrice)
 	}
-	return &Oracle{
-		backend:     backend,
-		lastPrice:   params.Default,
-		maxPrice:    maxPrice,
-		checkBlocks: blocks,
-		maxEmpty:    blocks / 2,
-		maxBlocks:   blocks * 5,
-		percentile:  percent,
+	maxHeaderHistory := params.MaxHeaderHistory
+	if maxHeaderHistory < 1 {
+		maxHeaderHistory = 1
+		log.Warn("Sanitizing invalid gasprice oracle max header history", "provided", params.MaxHeaderHistory, "updated", maxHeaderHistory)
+	}
+	maxBlockHistory := params.MaxBlockHistory
+	if maxBlockHistory < 1 {
+		maxBlockHistory = 1
+		log.Warn("Sanitizing invalid gasprice oracle max block history", "provided", params.MaxBlockHistory, "updated", maxBlockHistory)
 	}
+	oracle := &Oracle{
+		backend:          backend,
+		lastPrice:        params.Default,
+		maxPrice:         maxPrice,
+		checkBlocks:      blocks,
+		maxEmpty:         blocks / 2,
+		maxBlocks:        blocks * 5,
+		percentile:       percent,
+		maxHeaderHistory: maxHeaderHistory,
+		maxBlockHistory:  maxBlockHistory,
+	}
+	for i := range oracle.lastPrices {
+		oracle.lastPrices[i] = params.Default
+	}
+	return oracle
 }
 
 // SuggestPrice returns the recommended gas price.
 func (gpo *Oracle) SuggestPrice(ctx context.Context) (*big.Int, error) {
+	price, _, err := gpo.suggestPrices(ctx)
+	return price, err
+}
+
+// SuggestPrices returns the recommended gas prices for plain transfers, contract
+// calls and upload transactions separately.
+func (gpo *Oracle) SuggestPrices(ctx context.Context) (*PriceSuggestion, error) {
+	_, prices, err := gpo.suggestPrices(ctx)
+	return &PriceSuggestion{
+		Transfer: prices[TransferTx],
+		Call:     prices[CallTx],
+		Upload:   prices[UploadTx],
+	}, err
+}
+
+// suggestPrices samples the recent blocks and returns the overall recommended
+// gas price along with the per class recommendations. The results are cached
+// until the chain head changes.
+func (gpo *Oracle) suggestPrices(ctx context.Context) (*big.Int, [numTxClasses]*big.Int, error) {
 	gpo.cacheLock.RLock()
 	lastHead := gpo.lastHead
 	lastPrice := gpo.lastPrice
+	lastPrices := gpo.lastPrices
 	gpo.cacheLock.RUnlock()
 
 	head, _ := gpo.backend.HeaderByNumber(ctx, rpc.LatestBlockNumber)
 	headHash := head.Hash()
 	if headHash == lastHead {
-		return lastPrice, nil
+		return lastPrice, lastPrices, nil
 	}
 
 	gpo.fetchLock.Lock()
@@ -111,16 +204,20 @@ func (gpo *Oracle) SuggestPrice(ctx context.Context) (*big.Int, error) {
 	gpo.cacheLock.RLock()
 	lastHead = gpo.lastHead
 	lastPrice = gpo.lastPrice
+	lastPrices = gpo.lastPrices
 	gpo.cacheLock.RUnlock()
 	if headHash == lastHead {
-		return lastPrice, nil
+		return lastPrice, lastPrices, nil
 	}
 
 	blockNum := head.Number.Uint64()
 	ch := make(chan getBlockPricesResult, gpo.checkBlocks)
 	sent := 0
 	exp := 0
-	var blockPrices []*big.Int
+	var (
+		blockPrices []*big.Int
+		classPrices [numTxClasses][]*big.Int
+	)
 	for sent < gpo.checkBlocks && blockNum > 0 {
 		go gpo.getBlockPrices(ctx, types.MakeSigner(gpo.backend.ChainConfig(), big.NewInt(int64(blockNum))), blockNum, ch)
 		sent++
@@ -131,9 +228,14 @@ func (gpo *Oracle) SuggestPrice(ctx context.Context) (*big.Int, error) {
 	for exp > 0 {
 		res := <-ch
 		if res.err != nil {
-			return lastPrice, res.err
+			return lastPrice, lastPrices, res.err
 		}
 		exp--
+		for class, price := range res.classPrices {
+			if price != nil {
+				classPrices[class] = append(classPrices[class], price)
+			}
+		}
 		if res.price != nil {
 			blockPrices = append(blockPrices, res.price)
 			continue
@@ -149,25 +251,40 @@ func (gpo *Oracle) SuggestPrice(ctx context.Context) (*big.Int, error) {
 			blockNum--
 		}
 	}
-	price := lastPrice
-	if len(blockPrices) > 0 {
-		sort.Sort(bigIntArray(blockPrices))
-		price = blockPrices[(len(blockPrices)-1)*gpo.percentile/100]
-	}
-	if price.Cmp(gpo.maxPrice) > 0 {
-		price = new(big.Int).Set(gpo.maxPrice)
+	price := gpo.pick(blockPrices, lastPrice)
+
+	var prices [numTxClasses]*big.Int
+	for class := range prices {
+		prices[class] = gpo.pick(classPrices[class], lastPrices[class])
 	}
 
 	gpo.cacheLock.Lock()
 	gpo.lastHead = headHash
 	gpo.lastPrice = price
+	gpo.lastPrices = prices
 	gpo.cacheLock.Unlock()
-	return price, nil
+	return price, prices, nil
+}
+
+// pick returns the configured percentile of the sampled prices, falling back to
+// the given price if nothing was sampled. The result is capped at the maximum
+// price of the oracle.
+func (gpo *Oracle) pick(samples []*big.Int, fallback *big.Int) *big.Int {
+	price := fallback
+	if len(samples) > 0 {
+		sort.Sort(bigIntArray(samples))
+		price = samples[(len(samples)-1)*gpo.percentile/100]
+	}
+	if price != nil && price.Cmp(gpo.maxPrice) > 0 {
+		price = new(big.Int).Set(gpo.maxPrice)
+	}
+	return price
 }
 
 type getBlockPricesResult struct {
-	price *big.Int
-	err   error
+	price       *big.Int
+	classPrices [numTxClasses]*big.Int
+	err         error
 }
 
 type transactionsByGasPrice []*types.Transaction
@@ -176,12 +293,13 @@ func (t transactionsByGasPrice) Len() int           { return len(t) }
 func (t transactionsByGasPrice) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
 func (t transactionsByGasPrice) Less(i, j int) bool { return t[i].GasPriceCmp(t[j]) < 0 }
 
-// getBlockPrices calculates the lowest transaction gas price in a given block
-// and sends it to the result channel. If the block is empty, price is nil.
+// getBlockPrices calculates the lowest transaction gas price in a given block,
+// both overall and per transaction class, and sends it to the result channel.
+// If the block is empty, price is nil.
 func (gpo *Oracle) getBlockPrices(ctx context.Context, signer types.Signer, blockNum uint64, ch chan getBlockPricesResult) {
 	block, err := gpo.backend.BlockByNumber(ctx, rpc.BlockNumber(blockNum))
 	if block == nil {
-		ch <- getBlockPricesResult{nil, err}
+		ch <- getBlockPricesResult{err: err}
 		return
 	}
 
@@ -190,14 +308,41 @@ func (gpo *Oracle) getBlockPrices(ctx context.Context, signer types.Signer, bloc
 	copy(txs, blockTxs)
 	sort.Sort(transactionsByGasPrice(txs))
 
+	// The upload state of the recipients is looked up in the parent state, which
+	// is only opened if the block contains upload shaped transactions
+	var (
+		parent  *state.StateDB
+		missing bool
+	)
+	uploading := func(addr common.Address) bool {
+		if parent == nil && !missing {
+			statedb, _, err := gpo.backend.StateAndHeaderByNumber(ctx, rpc.BlockNumber(blockNum-1))
+			if statedb == nil || err != nil {
+				missing = true
+				return false
+			}
+			parent = statedb
+		}
+		return parent != nil && parent.Uploading(addr)
+	}
+	var res getBlockPricesResult
 	for _, tx := range txs {
+		class := ClassifyTx(tx, uploading)
+		if res.price != nil && res.classPrices[class] != nil {
+			continue
+		}
 		sender, err := types.Sender(signer, tx)
-		if err == nil && sender != block.Coinbase() {
-			ch <- getBlockPricesResult{tx.GasPrice(), nil}
-			return
+		if err != nil || sender == block.Coinbase() {
+			continue
+		}
+		if res.price == nil {
+			res.price = tx.GasPrice()
+		}
+		if res.classPrices[class] == nil {
+			res.classPrices[class] = tx.GasPrice()
 		}
 	}
-	ch <- getBlockPricesResult{nil, nil}
+	ch <- res
 }
 
 type bigIntArray []*big.Int
@@ -18,6 +18,7 @@ package gasprice
 
 import (
 	"context"
+	"errors"
 	"math"
 	"math/big"
 	"testing"
@@ -26,6 +27,7 @@ import (
 	"github.com/CortexFoundation/CortexTheseus/consensus/cuckoo"
 	"github.com/CortexFoundation/CortexTheseus/core"
 	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
+	"github.com/CortexFoundation/CortexTheseus/core/state"
 	"github.com/CortexFoundation/CortexTheseus/core/types"
 	"github.com/CortexFoundation/CortexTheseus/core/vm"
 	"github.com/CortexFoundation/CortexTheseus/crypto"
@@ -33,6 +35,10 @@ import (
 	"github.com/CortexFoundation/CortexTheseus/rpc"
 )
 
+// testUploader is the recipient of the upload transactions of the test chain,
+// reported by the test backend as having an upload in progress.
+var testUploader = common.HexToAddress("cafebabe")
+
 type testBackend struct {
 	chain *core.BlockChain
 }
@@ -51,6 +57,23 @@ func (b *testBackend) BlockByNumber(ctx context.Context, number rpc.BlockNumber)
 	return b.chain.GetBlockByNumber(uint64(number)), nil
 }
 
+func (b *testBackend) GetReceipts(ctx context.Context, hash common.Hash) (types.Receipts, error) {
+	return b.chain.GetReceiptsByHash(hash), nil
+}
+
+func (b *testBackend) StateAndHeaderByNumber(ctx context.Context, number rpc.BlockNumber) (*state.StateDB, *types.Header, error) {
+	header, _ := b.HeaderByNumber(ctx, number)
+	if header == nil {
+		return nil, nil, errors.New("header not found")
+	}
+	statedb, err := b.chain.StateAt(header.Root)
+	if err != nil {
+		return nil, nil, err
+	}
+	statedb.SetUpload(testUploader, big.NewInt(1))
+	return statedb, header, nil
+}
+
 func (b *testBackend) ChainConfig() *params.ChainConfig {
 	return b.chain.Config()
 }
@@ -61,7 +84,8 @@ func newTestBackend(t *testing.T) *testBackend {
 		addr   = crypto.PubkeyToAddress(key.PublicKey)
 		gspec  = &core.Genesis{
 			Config: params.TestChainConfig,
-			Alloc:  core.GenesisAlloc{addr: {Balance: big.NewInt(math.MaxInt64)}},
+			Supply: params.CTXC_INIT,
+			Alloc:  core.GenesisAlloc{addr: {Balance: new(big.Int).Mul(big.NewInt(math.MaxInt64), big.NewInt(params.GWei))}},
 		}
 		signer = types.NewEIP155Signer(gspec.Config.ChainID)
 	)
@@ -69,14 +93,23 @@ func newTestBackend(t *testing.T) *testBackend {
 	db := rawdb.NewMemoryDatabase()
 	genesis, _ := gspec.Commit(db)
 
-	// Generate testing blocks
+	// Generate testing blocks, each containing a plain transfer, a contract call
+	// and an upload to the test uploader priced at 1x, 2x and 3x the block number.
 	blocks, _ := core.GenerateChain(params.TestChainConfig, genesis, engine, db, 32, func(i int, b *core.BlockGen) {
 		b.SetCoinbase(common.Address{1})
-		tx, err := types.SignTx(types.NewTransaction(b.TxNonce(addr), common.HexToAddress("deadbeef"), big.NewInt(100), 21000, big.NewInt(int64(i+1)*params.GWei), nil), signer, key)
-		if err != nil {
-			t.Fatalf("failed to create tx: %v", err)
+		price := big.NewInt(int64(i+1) * params.GWei)
+		txs := []*types.Transaction{
+			types.NewTransaction(b.TxNonce(addr), common.HexToAddress("deadbeef"), big.NewInt(100), 21000, price, nil),
+			types.NewTransaction(b.TxNonce(addr)+1, common.HexToAddress("deadbeef"), big.NewInt(0), 25000, new(big.Int).Mul(price, big.NewInt(2)), []byte{0x01}),
+			types.NewTransaction(b.TxNonce(addr)+2, testUploader, big.NewInt(0), params.UploadGas, new(big.Int).Mul(price, big.NewInt(3)), nil),
+		}
+		for _, tx := range txs {
+			signed, err := types.SignTx(tx, signer, key)
+			if err != nil {
+				t.Fatalf("failed to create tx: %v", err)
+			}
+			b.AddTx(signed)
 		}
-		b.AddTx(tx)
 	})
 	// Construct testing chain
 	diskdb := rawdb.NewMemoryDatabase()
@@ -85,7 +118,9 @@ func newTestBackend(t *testing.T) *testBackend {
 	if err != nil {
 		t.Fatalf("Failed to create local chain, %v", err)
 	}
-	chain.InsertChain(blocks)
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("Failed to insert test chain, %v", err)
+	}
 	return &testBackend{chain: chain}
 }
 
@@ -98,13 +133,68 @@ func TestSuggestPrice(t *testing.T) {
 	backend := newTestBackend(t)
 	oracle := NewOracle(backend, config)
 
-	// The gas price sampled is: 32G, 31G, 30G, 29G, 28G, 27G
+	// The gas price sampled is: 32G, 31G, 30G. This used to expect the 1G default,
+	// which was only returned because the test chain was never inserted.
 	got, err := oracle.SuggestPrice(context.Background())
 	if err != nil {
 		t.Fatalf("Failed to retrieve recommended gas price: %v", err)
 	}
-	expect := big.NewInt(params.GWei * int64(1))
+	expect := big.NewInt(params.GWei * int64(31))
 	if got.Cmp(expect) != 0 {
 		t.Fatalf("Gas price mismatch, want %d, got %d", expect, got)
 	}
 }
+
+func TestSuggestPrices(t *testing.T) {
+	config := Config{
+		Blocks:     3,
+		Percentile: 60,
+		Default:    big.NewInt(params.GWei),
+	}
+	backend := newTestBackend(t)
+	oracle := NewOracle(backend, config)
+
+	got, err := oracle.SuggestPrices(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to retrieve recommended gas prices: %v", err)
+	}
+	var tests = []struct {
+		class  TxClass
+		got    *big.Int
+		expect int64
+	}{
+		{TransferTx, got.Transfer, 31},
+		{CallTx, got.Call, 62},
+		{UploadTx, got.Upload, 93},
+	}
+	for _, tt := range tests {
+		if expect := big.NewInt(params.GWei * tt.expect); tt.got.Cmp(expect) != 0 {
+			t.Errorf("%v gas price mismatch, want %d, got %d", tt.class, expect, tt.got)
+		}
+	}
+}
+
+func TestClassifyTx(t *testing.T) {
+	var (
+		to        = common.HexToAddress("deadbeef")
+		uploading = func(addr common.Address) bool { return addr == testUploader }
+	)
+	var tests = []struct {
+		tx     *types.Transaction
+		expect TxClass
+	}{
+		{types.NewTransaction(0, to, big.NewInt(1), 21000, big.NewInt(1), nil), TransferTx},
+		{types.NewTransaction(0, to, big.NewInt(0), 21000, big.NewInt(1), nil), TransferTx},
+		{types.NewTransaction(0, to, big.NewInt(0), 100000, big.NewInt(1), []byte{0x01}), CallTx},
+		{types.NewContractCreation(0, big.NewInt(0), 100000, big.NewInt(1), nil), CallTx},
+		{types.NewTransaction(0, to, big.NewInt(0), params.UploadGas, big.NewInt(1), nil), TransferTx},
+		{types.NewTransaction(0, testUploader, big.NewInt(0), params.UploadGas, big.NewInt(1), nil), UploadTx},
+		{types.NewTransaction(0, testUploader, big.NewInt(0), 21000, big.NewInt(1), nil), TransferTx},
+		{types.NewTransaction(0, testUploader, big.NewInt(1), params.UploadGas, big.NewInt(1), nil), TransferTx},
+	}
+	for i, tt := range tests {
+		if got := ClassifyTx(tt.tx, uploading); got != tt.expect {
+			t.Errorf("test %d: class mismatch, want %v, got %v", i, tt.expect, got)
+		}
+	}
+}
@@ -25,10 +25,13 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		NoPrefetch              bool
 		TxLookupLimit           uint64                 `toml:",omitempty"`
 		Whitelist               map[uint64]common.Hash `toml:"-"`
+		LightServ               int                    `toml:",omitempty"`
+		LightPeers              int                    `toml:",omitempty"`
 		SkipBcVersionCheck      bool                   `toml:"-"`
 		DatabaseHandles         int                    `toml:"-"`
 		DatabaseCache           int
 		DatabaseFreezer         string
+		DatabaseAPI             bool `toml:",omitempty"`
 		TrieCleanCache          int
 		TrieCleanCacheJournal   string        `toml:",omitempty"`
 		TrieCleanCacheRejournal time.Duration `toml:",omitempty"`
@@ -36,6 +39,8 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		TrieTimeout             time.Duration
 		SnapshotCache           int
 		Preimages               bool
+		StateDiffs              bool   `toml:",omitempty"`
+		StateDiffCheckpoint     uint64 `toml:",omitempty"`
 		Miner                   miner.Config
 		Coinbase                common.Address `toml:",omitempty"`
 		InferDeviceType         string
@@ -43,6 +48,7 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		InferMemoryUsage        int64
 		Cuckoo                  cuckoo.Config
 		TxPool                  core.TxPoolConfig
+		TxPropagation           TxPropagationConfig
 		GPO                     gasprice.Config
 		EnablePreimageRecording bool
 		CWASMInterpreter        string
@@ -64,10 +70,13 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.NoPrefetch = c.NoPrefetch
 	enc.TxLookupLimit = c.TxLookupLimit
 	enc.Whitelist = c.Whitelist
+	enc.LightServ = c.LightServ
+	enc.LightPeers = c.LightPeers
 	enc.SkipBcVersionCheck = c.SkipBcVersionCheck
 	enc.DatabaseHandles = c.DatabaseHandles
 	enc.DatabaseCache = c.DatabaseCache
 	enc.DatabaseFreezer = c.DatabaseFreezer
+	enc.DatabaseAPI = c.DatabaseAPI
 	enc.TrieCleanCache = c.TrieCleanCache
 	enc.TrieCleanCacheJournal = c.TrieCleanCacheJournal
 	enc.TrieCleanCacheRejournal = c.TrieCleanCacheRejournal
@@ -75,6 +84,8 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.TrieTimeout = c.TrieTimeout
 	enc.SnapshotCache = c.SnapshotCache
 	enc.Preimages = c.Preimages
+	enc.StateDiffs = c.StateDiffs
+	enc.StateDiffCheckpoint = c.StateDiffCheckpoint
 	enc.Miner = c.Miner
 	enc.Coinbase = c.Coinbase
 	enc.InferDeviceType = c.InferDeviceType
@@ -82,6 +93,7 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.InferMemoryUsage = c.InferMemoryUsage
 	enc.Cuckoo = c.Cuckoo
 	enc.TxPool = c.TxPool
+	enc.TxPropagation = c.TxPropagation
 	enc.GPO = c.GPO
 	enc.EnablePreimageRecording = c.EnablePreimageRecording
 	enc.CWASMInterpreter = c.CWASMInterpreter
@@ -107,10 +119,13 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		NoPrefetch              *bool
 		TxLookupLimit           *uint64                `toml:",omitempty"`
 		Whitelist               map[uint64]common.Hash `toml:"-"`
+		LightServ               *int                   `toml:",omitempty"`
+		LightPeers              *int                   `toml:",omitempty"`
 		SkipBcVersionCheck      *bool                  `toml:"-"`
 		DatabaseHandles         *int                   `toml:"-"`
 		DatabaseCache           *int
 		DatabaseFreezer         *string
+		DatabaseAPI             *bool `toml:",omitempty"`
 		TrieCleanCache          *int
 		TrieCleanCacheJournal   *string        `toml:",omitempty"`
 		TrieCleanCacheRejournal *time.Duration `toml:",omitempty"`
@@ -118,6 +133,8 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		TrieTimeout             *time.Duration
 		SnapshotCache           *int
 		Preimages               *bool
+		StateDiffs              *bool   `toml:",omitempty"`
+		StateDiffCheckpoint     *uint64 `toml:",omitempty"`
 		Miner                   *miner.Config
 		Coinbase                *common.Address `toml:",omitempty"`
 		InferDeviceType         *string
@@ -125,6 +142,7 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		InferMemoryUsage        *int64
 		Cuckoo                  *cuckoo.Config
 		TxPool                  *core.TxPoolConfig
+		TxPropagation           *TxPropagationConfig
 		GPO                     *gasprice.Config
 		EnablePreimageRecording *bool
 		CWASMInterpreter        *string
@@ -165,6 +183,12 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.Whitelist != nil {
 		c.Whitelist = dec.Whitelist
 	}
+	if dec.LightServ != nil {
+		c.LightServ = *dec.LightServ
+	}
+	if dec.LightPeers != nil {
+		c.LightPeers = *dec.LightPeers
+	}
 	if dec.SkipBcVersionCheck != nil {
 		c.SkipBcVersionCheck = *dec.SkipBcVersionCheck
 	}
@@ -177,6 +201,9 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.DatabaseFreezer != nil {
 		c.DatabaseFreezer = *dec.DatabaseFreezer
 	}
+	if dec.DatabaseAPI != nil {
+		c.DatabaseAPI = *dec.DatabaseAPI
+	}
 	if dec.TrieCleanCache != nil {
 		c.TrieCleanCache = *dec.TrieCleanCache
 	}
@@ -198,6 +225,12 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.Preimages != nil {
 		c.Preimages = *dec.Preimages
 	}
+	if dec.StateDiffs != nil {
+		c.StateDiffs = *dec.StateDiffs
+	}
+	if dec.StateDiffCheckpoint != nil {
+		c.StateDiffCheckpoint = *dec.StateDiffCheckpoint
+	}
 	if dec.Miner != nil {
 		c.Miner = *dec.Miner
 	}
@@ -219,6 +252,9 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.TxPool != nil {
 		c.TxPool = *dec.TxPool
 	}
+	if dec.TxPropagation != nil {
+		c.TxPropagation = *dec.TxPropagation
+	}
 	if dec.GPO != nil {
 		c.GPO = *dec.GPO
 	}
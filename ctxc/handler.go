@@ -17,6 +17,7 @@
 package ctxc
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -27,6 +28,7 @@ import (
 	"time"
 
 	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/common/mclock"
 	"github.com/CortexFoundation/CortexTheseus/consensus"
 	"github.com/CortexFoundation/CortexTheseus/core"
 	"github.com/CortexFoundation/CortexTheseus/core/forkid"
@@ -57,15 +59,17 @@ var (
 )
 
 func errResp(code errCode, format string, v ...interface{}) error {
-	return fmt.Errorf("%v - %v", code, fmt.Sprintf(format, v...))
+	return fmt.Errorf("%w - %v", code, fmt.Sprintf(format, v...))
 }
 
 type ProtocolManager struct {
 	networkID  uint64
 	forkFilter forkid.Filter // Fork ID filter, constant across the lifetime of the node
 
-	fastSync  uint32 // Flag whether fast sync is enabled (gets disabled if we already have blocks)
-	acceptTxs uint32 // Flag whether we're considered synchronised (enables transaction processing)
+	fastSync       uint32 // Flag whether fast sync is enabled (gets disabled if we already have blocks)
+	snapSync       uint32 // Flag whether the fast sync state is retrieved over the snap protocol
+	checkpointSync uint32 // Flag whether the seals below the checkpoint are only sampled during fast sync
+	acceptTxs      uint32 // Flag whether we're considered synchronised (enables transaction processing)
 
 	checkpointNumber uint64      // Block number for the sync progress validator to cross reference
 	checkpointHash   common.Hash // Block hash for the sync progress validator to cross reference
@@ -96,12 +100,14 @@ type ProtocolManager struct {
 	chainSync                *chainSyncer
 	wg                       sync.WaitGroup
 	peerWG                   sync.WaitGroup
-	broadcastTxAnnouncesOnly bool // Testing field, disable transaction propagation
+	txPropagation            TxPropagationConfig // Policy deciding which transactions are broadcast in full
+	uploads                  *uploadStatus       // Upload status of transaction recipients at the head block
+	broadcastTxAnnouncesOnly bool                // Testing field, disable transaction propagation
 }
 
 // NewProtocolManager returns a new Cortex sub protocol manager. The Cortex sub protocol manages peers capable
 // with the Cortex network.
-func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, networkID uint64, mux *event.TypeMux, txpool txPool, engine consensus.Engine, blockchain *core.BlockChain, chaindb ctxcdb.Database, cacheLimit int, whitelist map[uint64]common.Hash) (*ProtocolManager, error) {
+func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, networkID uint64, mux *event.TypeMux, txpool txPool, engine consensus.Engine, blockchain *core.BlockChain, chaindb ctxcdb.Database, cacheLimit int, whitelist map[uint64]common.Hash, checkpoint *params.TrustedCheckpoint) (*ProtocolManager, error) {
 	// Create the protocol manager with the base fields
 	manager := &ProtocolManager{
 		networkID:  networkID,
@@ -114,6 +120,7 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 		whitelist:  whitelist,
 		txsyncCh:   make(chan *txsync),
 		quitSync:   make(chan struct{}),
+		uploads:    newUploadStatus(blockchain),
 	}
 	// Figure out whether to allow fast sync or not
 	//if mode == downloader.FastSync && blockchain.CurrentBlock().NumberU64() == 0 {
@@ -143,24 +150,35 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 		} else {
 			// If fast sync was requested and our database is empty, grant it
 			manager.fastSync = uint32(1)
+			if mode == downloader.SnapSync {
+				manager.snapSync = uint32(1)
+			}
 		}
 	}
 
 	// If we have trusted checkpoints, enforce them on the chain
-	if checkpoint, ok := params.TrustedCheckpoints[blockchain.Genesis().Hash()]; ok {
+	if checkpoint != nil {
 		manager.checkpointNumber = (checkpoint.SectionIndex+1)*params.CHTFrequency - 1
 		manager.checkpointHash = checkpoint.SectionHead
 		manager.checkpointName = checkpoint.Name
-		log.Info("Check point", "section", checkpoint.SectionIndex, "number", manager.checkpointNumber, "hash", manager.checkpointHash, "genesis", blockchain.Genesis().Hash(), "ok", ok)
+		log.Info("Check point", "section", checkpoint.SectionIndex, "number", manager.checkpointNumber, "hash", manager.checkpointHash, "genesis", blockchain.Genesis().Hash())
 	} else {
 		log.Warn("No check point found", "genesis", blockchain.Genesis().Hash())
 	}
+	// Seals may only be sampled if the headers are anchored to a trusted checkpoint
+	if mode == downloader.CheckpointSync && atomic.LoadUint32(&manager.fastSync) == 1 {
+		if manager.checkpointHash == (common.Hash{}) {
+			log.Warn("No trusted checkpoint, checkpoint sync falls back to fast sync")
+		} else {
+			manager.checkpointSync = uint32(1)
+		}
+	}
 	// Initiate a sub-protocol for every implemented version we can handle
 	var stateBloom *trie.SyncBloom
 	if atomic.LoadUint32(&manager.fastSync) == 1 {
 		stateBloom = trie.NewSyncBloom(uint64(cacheLimit), chaindb)
 	}
-	manager.downloader = downloader.New(manager.checkpointNumber, chaindb, stateBloom, manager.eventMux, blockchain, manager.removePeer)
+	manager.downloader = downloader.New(manager.checkpointNumber, chaindb, stateBloom, manager.eventMux, blockchain, nil, manager.dropSyncPeer)
 
 	// Construct the fetcher (short sync)
 	validator := func(header *types.Header) error {
@@ -195,7 +213,7 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 		}
 		return n, err
 	}
-	manager.fetcher = fetcher.New(blockchain.GetBlockByHash, validator, manager.BroadcastBlock, heighter, inserter, manager.removePeer)
+	manager.fetcher = fetcher.New(blockchain.GetBlockByHash, validator, manager.BroadcastBlock, heighter, inserter, manager.dropFetchPeer)
 
 	fetchTx := func(peer string, hashes []common.Hash) error {
 		p := manager.peers.Peer(peer)
@@ -204,7 +222,10 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 		}
 		return p.RequestTxs(hashes)
 	}
-	manager.txFetcher = fetcher.NewTxFetcher(txpool.Has, txpool.AddRemotes, fetchTx)
+	spamTxs := func(peer string) {
+		manager.reportPeer(peer, p2p.SpamTransaction)
+	}
+	manager.txFetcher = fetcher.NewTxFetcher(txpool.Has, txpool.AddRemotes, fetchTx, spamTxs)
 	manager.chainSync = newChainSyncer(manager)
 
 	return manager, nil
@@ -235,6 +256,31 @@ func (pm *ProtocolManager) makeProtocol(version uint) p2p.Protocol {
 	}
 }
 
+// reportPeer records a conduct of a peer, adjusting its reputation.
+func (pm *ProtocolManager) reportPeer(id string, b p2p.Behaviour) {
+	if peer := pm.peers.Peer(id); peer != nil {
+		peer.Report(b)
+	}
+}
+
+// dropSyncPeer penalises and removes a peer the downloader found misbehaving.
+func (pm *ProtocolManager) dropSyncPeer(id string, reason error) {
+	if b, ok := downloader.PeerFault(reason); ok {
+		pm.reportPeer(id, b)
+	}
+	pm.removePeer(id)
+}
+
+// dropFetchPeer penalises and removes a peer that propagated an invalid block.
+func (pm *ProtocolManager) dropFetchPeer(id string, reason error) {
+	if errors.Is(reason, consensus.ErrInvalidPoW) {
+		pm.reportPeer(id, p2p.InvalidSeal)
+	} else {
+		pm.reportPeer(id, p2p.BadBlock)
+	}
+	pm.removePeer(id)
+}
+
 func (pm *ProtocolManager) removePeer(id string) {
 	// Short circuit if the peer was already removed
 	peer := pm.peers.Peer(id)
@@ -298,7 +344,9 @@ func (pm *ProtocolManager) Stop() {
 }
 
 func (pm *ProtocolManager) newPeer(pv uint, p *p2p.Peer, rw p2p.MsgReadWriter, getPooledTx func(hash common.Hash) *types.Transaction) *peer {
-	return newPeer(pv, p, rw, getPooledTx)
+	peer := newPeer(pv, p, rw, getPooledTx)
+	peer.txBudget = newTxBudget(pm.txPropagation.PeerBudget, mclock.System{})
+	return peer
 }
 
 func (pm *ProtocolManager) runPeer(p *peer) error {
@@ -351,7 +399,7 @@ func (pm *ProtocolManager) handle(p *peer) error {
 	// If we have a trusted CHT, reject all peers below that (avoid fast sync eclipse)
 	if pm.checkpointHash != (common.Hash{}) {
 		// Request the peer's checkpoint header for chain height/weight validation
-		if err := p.RequestHeadersByNumber(pm.checkpointNumber, 1, 0, false); err != nil {
+		if err := p.requestValidationHeader(pm.checkpointNumber, ownerCheckpoint); err != nil {
 			return err
 		}
 
@@ -369,7 +417,7 @@ func (pm *ProtocolManager) handle(p *peer) error {
 	}
 	// If we have any explicit whitelist block hashes, request them
 	for number := range pm.whitelist {
-		if err := p.RequestHeadersByNumber(number, 1, 0, false); err != nil {
+		if err := p.requestValidationHeader(number, ownerWhitelist); err != nil {
 			return err
 		}
 	}
@@ -377,6 +425,12 @@ func (pm *ProtocolManager) handle(p *peer) error {
 	for {
 		if err := pm.handleMsg(p); err != nil {
 			p.Log().Debug("Cortex message handling failed", "err", err)
+
+			// Remember protocol violations, network failures aren't the peer's fault
+			var code errCode
+			if errors.As(err, &code) {
+				p.Report(p2p.InvalidResponse)
+			}
 			return err
 		}
 	}
@@ -395,6 +449,30 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 	}
 	defer msg.Discard()
 
+	// Since ctxc/66 requests and responses are wrapped into an envelope binding
+	// them to a request identifier. Unwrap them, so the handlers below operate on
+	// the same payloads for all protocol versions, and route responses back to
+	// the subsystem which requested them.
+	var (
+		reqID uint64
+		owner = ownerUnknown
+	)
+	if p.version >= ctxc66 && (isRequestMsg(msg.Code) || isResponseMsg(msg.Code)) {
+		var packet packet66
+		if err := msg.Decode(&packet); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+		reqID = packet.RequestId
+		msg.Payload, msg.Size = bytes.NewReader(packet.Data), uint32(len(packet.Data))
+
+		if isResponseMsg(msg.Code) {
+			var ok bool
+			if owner, ok = p.resolveRequest(reqID, msg.Code); !ok {
+				p.Log().Debug("Dropping unsolicited response", "code", msg.Code, "reqid", reqID)
+				return nil
+			}
+		}
+	}
 	// Handle the message depending on its contents
 	switch {
 	case msg.Code == StatusMsg:
@@ -487,7 +565,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				query.Origin.Number += query.Skip + 1
 			}
 		}
-		return p.SendBlockHeaders(headers)
+		return p.SendBlockHeaders(reqID, headers)
 
 	case msg.Code == BlockHeadersMsg:
 		// A batch of headers arrived to one of our previous requests
@@ -495,7 +573,24 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		if err := msg.Decode(&headers); err != nil {
 			return errResp(ErrDecode, "msg %v: %v", msg, err)
 		}
+		// Responses of ctxc/66 peers are routed by request identifier
+		switch owner {
+		case ownerCheckpoint, ownerWhitelist:
+			return pm.validateHeaders(p, owner, headers)
 
+		case ownerFetcher:
+			pm.fetcher.FilterHeaders(p.id, headers, time.Now())
+			return nil
+
+		case ownerDownloader:
+			if err := pm.downloader.DeliverHeaders(p.id, headers); err != nil {
+				log.Debug("Failed to deliver headers", "err", err)
+			} else if len(headers) > 0 {
+				p.Report(p2p.UsefulResponse)
+			}
+			return nil
+		}
+		// Legacy peers don't identify the requests, sort the responses out heuristically
 		if len(headers) == 0 && p.syncDrop != nil {
 			// Stop the timer either way, decide later to drop or not
 			p.syncDrop.Stop()
@@ -539,6 +634,8 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			err := pm.downloader.DeliverHeaders(p.id, headers)
 			if err != nil {
 				log.Debug("Failed to deliver headers", "err", err)
+			} else if len(headers) > 0 {
+				p.Report(p2p.UsefulResponse)
 			}
 		}
 
@@ -567,7 +664,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				bytes += len(data)
 			}
 		}
-		return p.SendBlockBodiesRLP(bodies)
+		return p.SendBlockBodiesRLP(reqID, bodies)
 
 	case msg.Code == BlockBodiesMsg:
 		// A batch of block bodies arrived to one of our previous requests
@@ -583,6 +680,20 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			transactions[i] = body.Transactions
 			uncles[i] = body.Uncles
 		}
+		// Responses of ctxc/66 peers are routed by request identifier
+		switch owner {
+		case ownerFetcher:
+			pm.fetcher.FilterBodies(p.id, transactions, uncles, time.Now())
+			return nil
+
+		case ownerDownloader:
+			if err := pm.downloader.DeliverBodies(p.id, transactions, uncles); err != nil {
+				log.Debug("Failed to deliver bodies", "err", err)
+			} else if len(request) > 0 {
+				p.Report(p2p.UsefulResponse)
+			}
+			return nil
+		}
 		// Filter out any explicitly requested bodies, deliver the rest to the downloader
 		filter := len(transactions) > 0 || len(uncles) > 0
 		if filter {
@@ -592,6 +703,8 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			err := pm.downloader.DeliverBodies(p.id, transactions, uncles)
 			if err != nil {
 				log.Debug("Failed to deliver bodies", "err", err)
+			} else if len(transactions) > 0 {
+				p.Report(p2p.UsefulResponse)
 			}
 		}
 
@@ -631,7 +744,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				bytes += len(entry)
 			}
 		}
-		return p.SendNodeData(data)
+		return p.SendNodeData(reqID, data)
 
 	case msg.Code == NodeDataMsg:
 		// A batch of node state data arrived to one of our previous requests
@@ -642,6 +755,8 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		// Deliver all to the downloader
 		if err := pm.downloader.DeliverNodeData(p.id, data); err != nil {
 			log.Debug("Failed to deliver node state data", "err", err)
+		} else if len(data) > 0 {
+			p.Report(p2p.UsefulResponse)
 		}
 
 	case msg.Code == GetReceiptsMsg:
@@ -678,7 +793,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				bytes += len(encoded)
 			}
 		}
-		return p.SendReceiptsRLP(receipts)
+		return p.SendReceiptsRLP(reqID, receipts)
 
 	case msg.Code == ReceiptsMsg:
 		// A batch of receipts arrived to one of our previous requests
@@ -689,6 +804,8 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		// Deliver all to the downloader
 		if err := pm.downloader.DeliverReceipts(p.id, receipts); err != nil {
 			log.Debug("Failed to deliver receipts", "err", err)
+		} else if len(receipts) > 0 {
+			p.Report(p2p.UsefulResponse)
 		}
 
 	case msg.Code == NewBlockHashesMsg:
@@ -709,7 +826,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			//	}
 			//}
 			//for _, block := range unknown {
-			pm.fetcher.Notify(p.id, block.Hash, block.Number, time.Now(), p.RequestOneHeader, p.RequestBodies)
+			pm.fetcher.Notify(p.id, block.Hash, block.Number, time.Now(), p.RequestOneHeader, p.RequestAnnouncedBodies)
 		}
 
 	case msg.Code == NewBlockMsg:
@@ -799,7 +916,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				bytes += len(encoded)
 			}
 		}
-		return p.SendPooledTransactionsRLP(hashes, txs)
+		return p.SendPooledTransactionsRLP(reqID, hashes, txs)
 
 	case msg.Code == TransactionMsg || (msg.Code == PooledTransactionsMsg && p.version >= ctxc65):
 		// Transactions arrived, make sure we have a valid and fresh chain to handle them
@@ -826,6 +943,43 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 	return nil
 }
 
+// validateHeaders checks the response of a ctxc/66 peer to the header request
+// of the sync progress checkpoint or of a whitelisted block.
+func (pm *ProtocolManager) validateHeaders(p *peer, owner requestOwner, headers []*types.Header) error {
+	if owner == ownerCheckpoint {
+		// Stop the timer either way, decide now to drop or not
+		if p.syncDrop != nil {
+			p.syncDrop.Stop()
+			p.syncDrop = nil
+		}
+		// If we're doing a fast sync, we must enforce the checkpoint block to avoid
+		// eclipse attacks. Unsynced nodes are welcome to connect after we're done
+		// joining the network
+		if len(headers) == 0 {
+			if atomic.LoadUint32(&pm.fastSync) == 1 {
+				p.Log().Warn("Dropping unsynced node during fast sync", "addr", p.RemoteAddr(), "type", p.Name())
+				return errors.New("unsynced node cannot serve fast sync")
+			}
+			return nil
+		}
+		if len(headers) != 1 || headers[0].Number.Uint64() != pm.checkpointNumber || headers[0].Hash() != pm.checkpointHash {
+			return errors.New("checkpoint hash mismatch")
+		}
+		return nil
+	}
+	// Whitelisted blocks unknown to the peer are fine, mismatching ones are not
+	for _, header := range headers {
+		if want, ok := pm.whitelist[header.Number.Uint64()]; ok {
+			if hash := header.Hash(); want != hash {
+				p.Log().Info("Whitelist mismatch, dropping peer", "number", header.Number.Uint64(), "hash", hash, "want", want)
+				return errors.New("whitelist block mismatch")
+			}
+			p.Log().Debug("Whitelist block verified", "number", header.Number.Uint64(), "hash", want)
+		}
+	}
+	return nil
+}
+
 // BroadcastBlock will either propagate a block to a subset of it's peers, or
 // will only announce it's availability (depending what's requested).
 func (pm *ProtocolManager) BroadcastBlock(block *types.Block, propagate bool) {
@@ -866,15 +1020,25 @@ func (pm *ProtocolManager) BroadcastTransactions(txs types.Transactions, propaga
 		txset = make(map[*peer][]common.Hash)
 		annos = make(map[*peer][]common.Hash)
 	)
-	// Broadcast transactions to a batch of peers not knowing about it
+	// Broadcast transactions to a batch of peers not knowing about it. Transactions
+	// rejected by the propagation policy are only announced in the second round.
 	if propagate {
+		broadcastable := pm.broadcastFilter()
 		for _, tx := range txs {
+			if !broadcastable(tx) {
+				continue
+			}
 			peers := pm.peers.PeersWithoutTx(tx.Hash())
 
-			// Send the block to a subset of our peers
-			transfer := peers[:int(math.Sqrt(float64(len(peers))))]
+			// Send the transaction to a subset of our peers with budget left
+			transfer := peers[:pm.txPropagation.broadcastPeers(len(peers))]
 			for _, peer := range transfer {
+				if !peer.txBudget.take(tx.Size()) {
+					txPolicyOverBudgetMeter.Mark(1)
+					continue
+				}
 				txset[peer] = append(txset[peer], tx.Hash())
+				txPolicyBroadcastMeter.Mark(1)
 			}
 			log.Trace("Broadcast transaction", "hash", tx.Hash(), "recipients", len(peers))
 		}
@@ -0,0 +1,86 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package ctxc
+
+import (
+	"context"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/ctxc/protocols/avail"
+	"github.com/CortexFoundation/CortexTheseus/p2p/enode"
+)
+
+const (
+	// availLookupTimeout is the maximum time to spend checking the local
+	// availability of a single file queried by a remote peer.
+	availLookupTimeout = time.Second
+
+	// availFetchTimeout is the maximum time spent in the background looking up
+	// the connected peers holding a file missing locally, and handing them over
+	// to its download. Inference never waits for it.
+	availFetchTimeout = 10 * time.Second
+)
+
+// availStorage is the local torrent file store whose content is advertised over
+// the `avail` protocol.
+type availStorage interface {
+	Available(ctx context.Context, infohash string, rawSize uint64) (bool, error)
+	LocalPort() int
+}
+
+// availHandler implements the avail.Backend interface to handle the various
+// network packets that are sent as replies or broadcasts.
+type availHandler Cortex
+
+// Genesis retrieves the genesis hash of the chain the files belong to.
+func (h *availHandler) Genesis() common.Hash { return h.blockchain.Genesis().Hash() }
+
+// TorrentPort retrieves the port the local torrent client listens on.
+func (h *availHandler) TorrentPort() uint64 { return uint64(h.availStorage.LocalPort()) }
+
+// Available reports whether a file is fully available in the local store.
+func (h *availHandler) Available(entry avail.Entry) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), availLookupTimeout)
+	defer cancel()
+
+	ok, err := h.availStorage.Available(ctx, entry.Hash.Hex(), entry.Size)
+	return err == nil && ok
+}
+
+// RunPeer is invoked when a peer joins on the `avail` protocol. The peer is
+// tracked as a potential file source for as long as the connection is alive.
+func (h *availHandler) RunPeer(peer *avail.Peer, hand avail.Handler) error {
+	if err := h.availTracker.Register(peer); err != nil {
+		peer.Log().Error("Failed to register peer in avail tracker", "err", err)
+		return err
+	}
+	defer h.availTracker.Unregister(peer.ID())
+
+	return hand(peer)
+}
+
+// PeerInfo retrieves all known `avail` information about a peer.
+func (h *availHandler) PeerInfo(id enode.ID) interface{} {
+	return nil
+}
+
+// Handle is invoked from a peer's message handler when it receives a new remote
+// message that the handler couldn't consume and serve itself.
+func (h *availHandler) Handle(peer *avail.Peer, packet avail.Packet) error {
+	return h.availTracker.Deliver(peer, packet)
+}
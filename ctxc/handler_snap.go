@@ -0,0 +1,53 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package ctxc
+
+import (
+	"github.com/CortexFoundation/CortexTheseus/core"
+	"github.com/CortexFoundation/CortexTheseus/ctxc/protocols/snap"
+	"github.com/CortexFoundation/CortexTheseus/p2p/enode"
+)
+
+// snapHandler implements the snap.Backend interface to handle the various network
+// packets that are sent as replies or broadcasts.
+type snapHandler ProtocolManager
+
+// Chain retrieves the chain the `snap` protocol serves state from.
+func (h *snapHandler) Chain() *core.BlockChain { return h.blockchain }
+
+// RunPeer is invoked when a peer joins on the `snap` protocol. The peer is made
+// available to the snapshot syncer for as long as the connection is alive.
+func (h *snapHandler) RunPeer(peer *snap.Peer, hand snap.Handler) error {
+	if err := h.downloader.SnapSyncer.Register(peer); err != nil {
+		peer.Log().Error("Failed to register peer in snap syncer", "err", err)
+		return err
+	}
+	defer h.downloader.SnapSyncer.Unregister(peer.ID())
+
+	return hand(peer)
+}
+
+// PeerInfo retrieves all known `snap` information about a peer.
+func (h *snapHandler) PeerInfo(id enode.ID) interface{} {
+	return nil
+}
+
+// Handle is invoked from a peer's message handler when it receives a new remote
+// message that the handler couldn't consume and serve itself.
+func (h *snapHandler) Handle(peer *snap.Peer, packet snap.Packet) error {
+	return h.downloader.DeliverSnapPacket(peer, packet)
+}
@@ -58,6 +58,12 @@ var (
 	propAnnounceAllInMeter = metrics.NewRegisteredMeter("ctxc/announces/block/in/all", nil)
 	// All incoming block broadcasts
 	propBroadcastAllInMeter = metrics.NewRegisteredMeter("ctxc/broadcasts/block/in/all", nil)
+
+	// Transaction propagation policy decisions
+	txPolicyBroadcastMeter  = metrics.NewRegisteredMeter("ctxc/prop/txns/policy/broadcast", nil)  // Transactions broadcast in full (per peer)
+	txPolicyOversizedMeter  = metrics.NewRegisteredMeter("ctxc/prop/txns/policy/oversized", nil)  // Transactions announced only due to their size
+	txPolicyUploadMeter     = metrics.NewRegisteredMeter("ctxc/prop/txns/policy/upload", nil)     // Upload progress transactions announced only
+	txPolicyOverBudgetMeter = metrics.NewRegisteredMeter("ctxc/prop/txns/policy/overbudget", nil) // Broadcasts skipped due to the peer byte budget
 )
 
 // meteredMsgReadWriter is a wrapper around a p2p.MsgReadWriter, capable of
@@ -26,6 +26,7 @@ import (
 	"github.com/CortexFoundation/CortexTheseus/rlp"
 	mapset "github.com/ucwong/golang-set"
 	"math/big"
+	"math/rand"
 	"sync"
 	"time"
 )
@@ -53,6 +54,10 @@ const (
 	// that might cover uncles should be enough.
 	maxQueuedBlocks = 4
 
+	// pendingRequestTimeout is the time after which a ctxc/66 request without any
+	// response is forgotten, dropping its response should it arrive later on.
+	pendingRequestTimeout = time.Minute
+
 	// maxQueuedBlockAnns is the maximum number of block announcements to queue up before
 	// dropping broadcasts. Similarly to block propagations, there's no point to queue
 	// above some healthy uncle limit, so use that.
@@ -77,6 +82,26 @@ type PeerInfo struct {
 	Head       string   `json:"head"`       // SHA3 hash of the peer's best owned block
 }
 
+// requestOwner identifies the subsystem a request was issued for, so that the
+// response can be routed back to it on ctxc/66 without any guesswork.
+type requestOwner int
+
+const (
+	ownerUnknown    requestOwner = iota // Response from a legacy peer, owner unknown
+	ownerDownloader                     // Chain and state synchronisation
+	ownerFetcher                        // Announced block retrieval
+	ownerTxFetcher                      // Announced transaction retrieval
+	ownerCheckpoint                     // Sync progress checkpoint challenge
+	ownerWhitelist                      // Whitelisted block verification
+)
+
+// pendingRequest is a ctxc/66 request still waiting for its response.
+type pendingRequest struct {
+	code  uint64       // Message code of the expected response
+	owner requestOwner // Subsystem to route the response to
+	sent  time.Time    // Time the request was sent, to expire lost ones
+}
+
 // propEvent is a block propagation, waiting for its turn in the broadcast queue.
 type propEvent struct {
 	block *types.Block
@@ -104,6 +129,11 @@ type peer struct {
 	txBroadcast chan []common.Hash                   // Channel used to queue transaction propagation requests
 	txAnnounce  chan []common.Hash                   // Channel used to queue transaction announcement requests
 	getPooledTx func(common.Hash) *types.Transaction // Callback used to retrieve transaction from txpool
+	txBudget    *txBudget                            // Byte budget for full transaction broadcasts
+
+	reqID    uint64                     // Last request identifier handed out (ctxc/66)
+	requests map[uint64]*pendingRequest // Requests waiting for their responses (ctxc/66)
+	reqLock  sync.Mutex
 
 	term chan struct{} // Termination channel to stop the broadcaster
 }
@@ -121,6 +151,8 @@ func newPeer(version uint, p *p2p.Peer, rw p2p.MsgReadWriter, getPooledTx func(h
 		txBroadcast:     make(chan []common.Hash),
 		txAnnounce:      make(chan []common.Hash),
 		getPooledTx:     getPooledTx,
+		reqID:           rand.Uint64(),
+		requests:        make(map[uint64]*pendingRequest),
 		term:            make(chan struct{}),
 	}
 }
@@ -421,7 +453,7 @@ func (p *peer) AsyncSendPooledTransactionHashes(hashes []common.Hash) {
 //
 // Note, the method assumes the hashes are correct and correspond to the list of
 // transactions being sent.
-func (p *peer) SendPooledTransactionsRLP(hashes []common.Hash, txs []rlp.RawValue) error {
+func (p *peer) SendPooledTransactionsRLP(id uint64, hashes []common.Hash, txs []rlp.RawValue) error {
 	// Mark all the transactions as known, but ensure we don't overflow our limits
 	for p.knownTxs.Cardinality() > max(0, maxKnownTxs-len(hashes)) {
 		p.knownTxs.Pop()
@@ -429,7 +461,7 @@ func (p *peer) SendPooledTransactionsRLP(hashes []common.Hash, txs []rlp.RawValu
 	for _, hash := range hashes {
 		p.knownTxs.Add(hash)
 	}
-	return p2p.Send(p.rw, PooledTransactionsMsg, txs)
+	return p.reply(PooledTransactionsMsg, id, txs)
 }
 
 // SendNewBlockHashes announces the availability of a number of blocks through
@@ -492,78 +524,149 @@ func (p *peer) AsyncSendNewBlock(block *types.Block, td *big.Int) {
 }
 
 // SendBlockHeaders sends a batch of block headers to the remote peer.
-func (p *peer) SendBlockHeaders(headers []*types.Header) error {
-	return p2p.Send(p.rw, BlockHeadersMsg, headers)
+func (p *peer) SendBlockHeaders(id uint64, headers []*types.Header) error {
+	return p.reply(BlockHeadersMsg, id, headers)
 }
 
 // SendBlockBodies sends a batch of block contents to the remote peer.
-func (p *peer) SendBlockBodies(bodies []*blockBody) error {
-	return p2p.Send(p.rw, BlockBodiesMsg, blockBodiesData(bodies))
+func (p *peer) SendBlockBodies(id uint64, bodies []*blockBody) error {
+	return p.reply(BlockBodiesMsg, id, blockBodiesData(bodies))
 }
 
 // SendBlockBodiesRLP sends a batch of block contents to the remote peer from
 // an already RLP encoded format.
-func (p *peer) SendBlockBodiesRLP(bodies []rlp.RawValue) error {
-	return p2p.Send(p.rw, BlockBodiesMsg, bodies)
+func (p *peer) SendBlockBodiesRLP(id uint64, bodies []rlp.RawValue) error {
+	return p.reply(BlockBodiesMsg, id, bodies)
 }
 
 // SendNodeDataRLP sends a batch of arbitrary internal data, corresponding to the
 // hashes requested.
-func (p *peer) SendNodeData(data [][]byte) error {
-	return p2p.Send(p.rw, NodeDataMsg, data)
+func (p *peer) SendNodeData(id uint64, data [][]byte) error {
+	return p.reply(NodeDataMsg, id, data)
 }
 
 // SendReceiptsRLP sends a batch of transaction receipts, corresponding to the
 // ones requested from an already RLP encoded format.
-func (p *peer) SendReceiptsRLP(receipts []rlp.RawValue) error {
-	return p2p.Send(p.rw, ReceiptsMsg, receipts)
+func (p *peer) SendReceiptsRLP(id uint64, receipts []rlp.RawValue) error {
+	return p.reply(ReceiptsMsg, id, receipts)
+}
+
+// reply sends the response to a request of the remote peer. On ctxc/66 the
+// response is tagged with the identifier of the request, which is otherwise
+// ignored.
+func (p *peer) reply(code uint64, id uint64, data interface{}) error {
+	if p.version < ctxc66 {
+		return p2p.Send(p.rw, code, data)
+	}
+	return p2p.Send(p.rw, code, []interface{}{id, data})
 }
 
 // RequestOneHeader is a wrapper around the header query functions to fetch a
 // single header. It is used solely by the fetcher.
 func (p *peer) RequestOneHeader(hash common.Hash) error {
 	p.Log().Debug("Fetching single header", "hash", hash)
-	return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Hash: hash}, Amount: uint64(1), Skip: uint64(0), Reverse: false})
+	return p.request(GetBlockHeadersMsg, ownerFetcher, &getBlockHeadersData{Origin: hashOrNumber{Hash: hash}, Amount: uint64(1), Skip: uint64(0), Reverse: false})
+}
+
+// requestValidationHeader fetches the single header of a block the remote peer
+// is validated against, e.g. the sync checkpoint or a whitelisted block.
+func (p *peer) requestValidationHeader(number uint64, owner requestOwner) error {
+	p.Log().Debug("Fetching validation header", "number", number)
+	return p.request(GetBlockHeadersMsg, owner, &getBlockHeadersData{Origin: hashOrNumber{Number: number}, Amount: uint64(1), Skip: uint64(0), Reverse: false})
 }
 
 // RequestHeadersByHash fetches a batch of blocks' headers corresponding to the
 // specified header query, based on the hash of an origin block.
 func (p *peer) RequestHeadersByHash(origin common.Hash, amount int, skip int, reverse bool) error {
 	p.Log().Debug("Fetching batch of headers", "count", amount, "fromhash", origin, "skip", skip, "reverse", reverse)
-	return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Hash: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
+	return p.request(GetBlockHeadersMsg, ownerDownloader, &getBlockHeadersData{Origin: hashOrNumber{Hash: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
 }
 
 // RequestHeadersByNumber fetches a batch of blocks' headers corresponding to the
 // specified header query, based on the number of an origin block.
 func (p *peer) RequestHeadersByNumber(origin uint64, amount int, skip int, reverse bool) error {
 	p.Log().Debug("Fetching batch of headers", "count", amount, "fromnum", origin, "skip", skip, "reverse", reverse)
-	return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Number: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
+	return p.request(GetBlockHeadersMsg, ownerDownloader, &getBlockHeadersData{Origin: hashOrNumber{Number: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
 }
 
 // RequestBodies fetches a batch of blocks' bodies corresponding to the hashes
 // specified.
 func (p *peer) RequestBodies(hashes []common.Hash) error {
 	p.Log().Debug("Fetching batch of block bodies", "count", len(hashes))
-	return p2p.Send(p.rw, GetBlockBodiesMsg, hashes)
+	return p.request(GetBlockBodiesMsg, ownerDownloader, hashes)
+}
+
+// RequestAnnouncedBodies fetches the bodies of a batch of announced blocks. It
+// is used solely by the fetcher.
+func (p *peer) RequestAnnouncedBodies(hashes []common.Hash) error {
+	p.Log().Debug("Fetching announced block bodies", "count", len(hashes))
+	return p.request(GetBlockBodiesMsg, ownerFetcher, hashes)
 }
 
 // RequestNodeData fetches a batch of arbitrary data from a node's known state
 // data, corresponding to the specified hashes.
 func (p *peer) RequestNodeData(hashes []common.Hash) error {
 	p.Log().Debug("Fetching batch of state data", "count", len(hashes))
-	return p2p.Send(p.rw, GetNodeDataMsg, hashes)
+	return p.request(GetNodeDataMsg, ownerDownloader, hashes)
 }
 
 // RequestReceipts fetches a batch of transaction receipts from a remote node.
 func (p *peer) RequestReceipts(hashes []common.Hash) error {
 	p.Log().Debug("Fetching batch of receipts", "count", len(hashes))
-	return p2p.Send(p.rw, GetReceiptsMsg, hashes)
+	return p.request(GetReceiptsMsg, ownerDownloader, hashes)
 }
 
 // RequestTxs fetches a batch of transactions from a remote node.
 func (p *peer) RequestTxs(hashes []common.Hash) error {
 	p.Log().Debug("Fetching batch of transactions", "count", len(hashes))
-	return p2p.Send(p.rw, GetPooledTransactionsMsg, hashes)
+	return p.request(GetPooledTransactionsMsg, ownerTxFetcher, hashes)
+}
+
+// request sends a request to the remote peer. On ctxc/66 the request is tagged
+// with a fresh identifier and tracked until the response arrives, so that it
+// can be routed back to the subsystem which issued it.
+func (p *peer) request(code uint64, owner requestOwner, data interface{}) error {
+	if p.version < ctxc66 {
+		return p2p.Send(p.rw, code, data)
+	}
+	id := p.trackRequest(responseMsgs[code], owner)
+	return p2p.Send(p.rw, code, []interface{}{id, data})
+}
+
+// trackRequest registers a new pending request expecting a response with the
+// given message code, returning the identifier assigned to it.
+func (p *peer) trackRequest(code uint64, owner requestOwner) uint64 {
+	p.reqLock.Lock()
+	defer p.reqLock.Unlock()
+
+	now := time.Now()
+	for id, req := range p.requests {
+		// The downloader keeps at most one request of each kind in flight to any
+		// peer, so a previous one was abandoned (timed out). Forget about it, so
+		// a late response is not mistaken for the answer to the new request.
+		if (owner == ownerDownloader && req.owner == owner && req.code == code) || now.Sub(req.sent) > pendingRequestTimeout {
+			delete(p.requests, id)
+		}
+	}
+	p.reqID++
+	p.requests[p.reqID] = &pendingRequest{code: code, owner: owner, sent: now}
+	return p.reqID
+}
+
+// resolveRequest retrieves and forgets the pending request answered by the
+// response with the given identifier and message code, returning the subsystem
+// it needs to be routed to. False is returned for unsolicited responses, or
+// ones to requests already expired or superseded.
+func (p *peer) resolveRequest(id uint64, code uint64) (requestOwner, bool) {
+	p.reqLock.Lock()
+	defer p.reqLock.Unlock()
+
+	req, ok := p.requests[id]
+	if !ok || req.code != code {
+		return ownerUnknown, false
+	}
+	delete(p.requests, id)
+	return req.owner, true
 }
 
 // Handshake executes the ctxc protocol handshake, negotiating version number,
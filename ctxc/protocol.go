@@ -35,16 +35,17 @@ const (
 	//ctxc63 = 63
 	ctxc64 = 64
 	ctxc65 = 65
+	ctxc66 = 66
 )
 
 // ProtocolName is the official short name of the protocol used during capability negotiation.
 var protocolName = "ctxc"
 
 // ProtocolVersions are the upported versions of the ctxc protocol (first is primary).
-var ProtocolVersions = []uint{ctxc65, ctxc64} //, ctxc63} //, ctxc62}
+var ProtocolVersions = []uint{ctxc66, ctxc65, ctxc64} //, ctxc63} //, ctxc62}
 
 // ProtocolLengths are the number of implemented message corresponding to different protocol versions.
-var protocolLengths = map[uint]uint64{ctxc65: 17, ctxc64: 17} //, ctxc63: 17, ctxc62: 8}
+var protocolLengths = map[uint]uint64{ctxc66: 17, ctxc65: 17, ctxc64: 17} //, ctxc63: 17, ctxc62: 8}
 
 const protocolMaxMsgSize = 10 * 1024 * 1024 // Maximum cap on the size of a protocol message
 
@@ -71,6 +72,33 @@ const (
 	PooledTransactionsMsg         = 0x0a
 )
 
+// responseMsgs maps the codes of the requests tagged with an identifier since
+// ctxc/66 to the codes of the responses answering them.
+var responseMsgs = map[uint64]uint64{
+	GetBlockHeadersMsg:       BlockHeadersMsg,
+	GetBlockBodiesMsg:        BlockBodiesMsg,
+	GetNodeDataMsg:           NodeDataMsg,
+	GetReceiptsMsg:           ReceiptsMsg,
+	GetPooledTransactionsMsg: PooledTransactionsMsg,
+}
+
+// isRequestMsg reports whether the message code is a request carrying a request
+// identifier on ctxc/66.
+func isRequestMsg(code uint64) bool {
+	_, ok := responseMsgs[code]
+	return ok
+}
+
+// isResponseMsg reports whether the message code is a response carrying the
+// identifier of the request it answers on ctxc/66.
+func isResponseMsg(code uint64) bool {
+	switch code {
+	case BlockHeadersMsg, BlockBodiesMsg, NodeDataMsg, ReceiptsMsg, PooledTransactionsMsg:
+		return true
+	}
+	return false
+}
+
 type errCode int
 
 const (
@@ -89,6 +117,10 @@ func (e errCode) String() string {
 	return errorToString[int(e)]
 }
 
+func (e errCode) Error() string {
+	return e.String()
+}
+
 // XXX change once legacy code is out
 var errorToString = map[int]string{
 	ErrMsgTooLarge:             "Message too long",
@@ -123,6 +155,13 @@ type txPool interface {
 	SubscribeNewTxsEvent(chan<- core.NewTxsEvent) event.Subscription
 }
 
+// packet66 is the ctxc/66 envelope of requests and responses, binding each of
+// them to a request identifier. The payload is the legacy message content.
+type packet66 struct {
+	RequestId uint64
+	Data      rlp.RawValue
+}
+
 // statusData63 is the network packet for the status message for eth/63.
 type statusData63 struct {
 	ProtocolVersion uint32
@@ -192,6 +192,7 @@ func TestStatusMsgErrors64(t *testing.T) {
 //func TestRecvTransactions63(t *testing.T) { testRecvTransactions(t, 63) }
 func TestRecvTransactions64(t *testing.T) { testRecvTransactions(t, 64) }
 func TestRecvTransactions65(t *testing.T) { testRecvTransactions(t, 65) }
+func TestRecvTransactions66(t *testing.T) { testRecvTransactions(t, 66) }
 
 func testRecvTransactions(t *testing.T, protocol int) {
 	txAdded := make(chan []*types.Transaction)
@@ -221,6 +222,7 @@ func testRecvTransactions(t *testing.T, protocol int) {
 //func TestSendTransactions63(t *testing.T) { testSendTransactions(t, 63) }
 func TestSendTransactions64(t *testing.T) { testSendTransactions(t, 64) }
 func TestSendTransactions65(t *testing.T) { testSendTransactions(t, 65) }
+func TestSendTransactions66(t *testing.T) { testSendTransactions(t, 66) }
 
 func testSendTransactions(t *testing.T, protocol int) {
 	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
@@ -270,7 +272,7 @@ func testSendTransactions(t *testing.T, protocol int) {
 						callback(tx.Hash())
 					}
 				}
-			case 65:
+			case 65, 66:
 				msg, err := p.app.ReadMsg()
 				if err != nil {
 					t.Errorf("%v: read error: %v", p.Peer, err)
@@ -401,3 +403,81 @@ func TestGetBlockHeadersDataEncodeDecode(t *testing.T) {
 		}
 	}
 }
+
+// Tests that ctxc/66 requests are answered with responses tagged with the same
+// request identifier.
+func TestGetBlockHeaders66(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 16, nil, nil)
+	peer, _ := newTestPeer("peer", ctxc66, pm, true)
+	defer pm.Stop()
+	defer peer.close()
+
+	query := &getBlockHeadersData{Origin: hashOrNumber{Number: 1}, Amount: 4}
+	if err := p2p.Send(peer.app, GetBlockHeadersMsg, []interface{}{uint64(1234), query}); err != nil {
+		t.Fatalf("failed to send header query: %v", err)
+	}
+	headers := make([]*types.Header, 0, query.Amount)
+	for i := uint64(1); i <= query.Amount; i++ {
+		headers = append(headers, pm.blockchain.GetHeaderByNumber(i))
+	}
+	if err := p2p.ExpectMsg(peer.app, BlockHeadersMsg, []interface{}{uint64(1234), headers}); err != nil {
+		t.Fatalf("headers mismatch: %v", err)
+	}
+}
+
+// Tests that unsolicited ctxc/66 responses are dropped without disconnecting
+// the peer, and that the connection remains functional afterwards.
+func TestUnsolicitedResponse66(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 4, nil, nil)
+	peer, errc := newTestPeer("peer", ctxc66, pm, true)
+	defer pm.Stop()
+	defer peer.close()
+
+	headers := []*types.Header{pm.blockchain.GetHeaderByNumber(1)}
+	if err := p2p.Send(peer.app, BlockHeadersMsg, []interface{}{uint64(1), headers}); err != nil {
+		t.Fatalf("failed to send unsolicited headers: %v", err)
+	}
+	query := &getBlockHeadersData{Origin: hashOrNumber{Number: 0}, Amount: 1}
+	if err := p2p.Send(peer.app, GetBlockHeadersMsg, []interface{}{uint64(2), query}); err != nil {
+		t.Fatalf("failed to send header query: %v", err)
+	}
+	if err := p2p.ExpectMsg(peer.app, BlockHeadersMsg, []interface{}{uint64(2), []*types.Header{pm.blockchain.Genesis().Header()}}); err != nil {
+		t.Fatalf("headers mismatch: %v", err)
+	}
+	select {
+	case err := <-errc:
+		t.Fatalf("peer dropped: %v", err)
+	default:
+	}
+}
+
+// Tests that the ctxc/66 request tracker routes responses to the subsystem which
+// issued the request, and that abandoned downloader requests are superseded.
+func TestRequestTracking66(t *testing.T) {
+	p := newPeer(ctxc66, p2p.NewPeer(enode.ID{}, "peer", nil), nil, nil)
+
+	// A new downloader request supersedes the previous one of the same kind
+	stale := p.trackRequest(BlockHeadersMsg, ownerDownloader)
+	live := p.trackRequest(BlockHeadersMsg, ownerDownloader)
+	bodies := p.trackRequest(BlockBodiesMsg, ownerDownloader)
+	if _, ok := p.resolveRequest(stale, BlockHeadersMsg); ok {
+		t.Fatalf("superseded request resolved")
+	}
+	if owner, ok := p.resolveRequest(live, BlockHeadersMsg); !ok || owner != ownerDownloader {
+		t.Fatalf("live request mismatch: have %v/%v, want %v/true", owner, ok, ownerDownloader)
+	}
+	if _, ok := p.resolveRequest(live, BlockHeadersMsg); ok {
+		t.Fatalf("request resolved twice")
+	}
+	if _, ok := p.resolveRequest(bodies, BlockHeadersMsg); ok {
+		t.Fatalf("request resolved with mismatching response")
+	}
+	// Concurrent fetcher requests are all kept alive
+	first := p.trackRequest(BlockHeadersMsg, ownerFetcher)
+	second := p.trackRequest(BlockHeadersMsg, ownerFetcher)
+	for _, id := range []uint64{first, second} {
+		if owner, ok := p.resolveRequest(id, BlockHeadersMsg); !ok || owner != ownerFetcher {
+			t.Fatalf("fetcher request %d mismatch: have %v/%v, want %v/true", id, owner, ok, ownerFetcher)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package avail
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
+)
+
+// holdersTimeout is the maximum time to wait for connected peers to answer an
+// availability query issued through the API.
+const holdersTimeout = 5 * time.Second
+
+// HolderInfo describes a connected peer holding a file, containing everything
+// needed to reach its torrent client directly.
+type HolderInfo struct {
+	ID          string `json:"id"`          // Unique node identifier
+	Name        string `json:"name"`        // Name of the node, including client type, version, OS, custom data
+	IP          string `json:"ip"`          // IP address the peer is connected from
+	TorrentPort uint64 `json:"torrentPort"` // Port the torrent client of the peer listens on
+}
+
+// PublicAvailAPI provides an API to query which connected peers hold model and
+// input files.
+type PublicAvailAPI struct {
+	tracker *Tracker
+}
+
+// NewPublicAvailAPI creates a new availability API.
+func NewPublicAvailAPI(tracker *Tracker) *PublicAvailAPI {
+	return &PublicAvailAPI{tracker: tracker}
+}
+
+// Holders returns the connected peers holding the file with the given info hash
+// and raw size.
+func (api *PublicAvailAPI) Holders(ctx context.Context, infohash common.Address, size hexutil.Uint64) []*HolderInfo {
+	ctx, cancel := context.WithTimeout(ctx, holdersTimeout)
+	defer cancel()
+
+	peers := api.tracker.Holders(ctx, Entry{Hash: infohash, Size: uint64(size)})
+
+	holders := make([]*HolderInfo, 0, len(peers))
+	for _, peer := range peers {
+		info := &HolderInfo{
+			ID:          peer.Peer.ID().String(),
+			Name:        peer.Name(),
+			TorrentPort: peer.TorrentPort(),
+		}
+		if addr, ok := peer.RemoteAddr().(*net.TCPAddr); ok {
+			info.IP = addr.IP.String()
+		}
+		holders = append(holders, info)
+	}
+	return holders
+}
+
+// Local returns the files announced to peers as available locally.
+func (api *PublicAvailAPI) Local() []Entry {
+	return api.tracker.Local()
+}
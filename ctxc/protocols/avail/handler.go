@@ -0,0 +1,168 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package avail
+
+import (
+	"fmt"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/p2p"
+	"github.com/CortexFoundation/CortexTheseus/p2p/enode"
+)
+
+// Handler is a callback to invoke from an outside runner after the boilerplate
+// exchanges have passed.
+type Handler func(peer *Peer) error
+
+// Backend defines the data retrieval methods to serve remote requests and the
+// callback methods to invoke on remote deliveries.
+type Backend interface {
+	// Genesis retrieves the genesis hash of the chain the files belong to.
+	Genesis() common.Hash
+
+	// TorrentPort retrieves the port the local torrent client listens on.
+	TorrentPort() uint64
+
+	// Available reports whether a file is fully available locally.
+	Available(entry Entry) bool
+
+	// RunPeer is invoked when a peer joins on the `avail` protocol. The handler
+	// should do any peer maintenance work, handshakes and validations. If all
+	// is passed, control should be given back to the `handler` to process the
+	// inbound messages going forward.
+	RunPeer(peer *Peer, handler Handler) error
+
+	// PeerInfo retrieves all known `avail` information about a peer.
+	PeerInfo(id enode.ID) interface{}
+
+	// Handle is a callback to be invoked when a data packet is received from
+	// the remote peer. Only packets not consumed by the protocol handler will
+	// be forwarded to the backend.
+	Handle(peer *Peer, packet Packet) error
+}
+
+// MakeProtocols constructs the P2P protocol definitions for `avail`.
+func MakeProtocols(backend Backend) []p2p.Protocol {
+	protocols := make([]p2p.Protocol, len(ProtocolVersions))
+	for i, version := range ProtocolVersions {
+		version := version // Closure
+
+		protocols[i] = p2p.Protocol{
+			Name:    ProtocolName,
+			Version: version,
+			Length:  protocolLengths[version],
+			Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+				peer := newPeer(version, p, rw)
+				if err := peer.Handshake(backend.Genesis(), backend.TorrentPort()); err != nil {
+					peer.Log().Debug("Handshake failed in `avail`", "err", err)
+					return err
+				}
+				return backend.RunPeer(peer, func(peer *Peer) error {
+					return handle(backend, peer)
+				})
+			},
+			NodeInfo: func() interface{} {
+				return nodeInfo(backend)
+			},
+			PeerInfo: func(id enode.ID) interface{} {
+				return backend.PeerInfo(id)
+			},
+		}
+	}
+	return protocols
+}
+
+// handle is the callback invoked to manage the life cycle of an `avail` peer.
+// When this function terminates, the peer is disconnected.
+func handle(backend Backend, peer *Peer) error {
+	for {
+		if err := handleMessage(backend, peer); err != nil {
+			peer.Log().Debug("Message handling failed in `avail`", "err", err)
+			return err
+		}
+	}
+}
+
+// handleMessage is invoked whenever an inbound message is received from a
+// remote peer on the `avail` protocol. The remote connection is torn down upon
+// returning any error.
+func handleMessage(backend Backend, peer *Peer) error {
+	// Read the next message from the remote peer, and ensure it's fully consumed
+	msg, err := peer.rw.ReadMsg()
+	if err != nil {
+		return err
+	}
+	if msg.Size > maxMessageSize {
+		return fmt.Errorf("%w: %v > %v", errMsgTooLarge, msg.Size, maxMessageSize)
+	}
+	defer msg.Discard()
+
+	// Handle the message depending on its contents
+	switch msg.Code {
+	case AnnounceMsg:
+		// A batch of files became available at the remote peer
+		var ann AnnouncePacket
+		if err := msg.Decode(&ann); err != nil {
+			return fmt.Errorf("%w: message %v: %v", errDecode, msg, err)
+		}
+		if len(ann) > maxEntries {
+			return fmt.Errorf("%w: %d files announced (> %d)", errBadRequest, len(ann), maxEntries)
+		}
+		peer.markAvailable(ann)
+		return backend.Handle(peer, &ann)
+
+	case GetAvailabilityMsg:
+		// Decode the availability query and check each file locally
+		var req GetAvailabilityPacket
+		if err := msg.Decode(&req); err != nil {
+			return fmt.Errorf("%w: message %v: %v", errDecode, msg, err)
+		}
+		if len(req.Entries) > maxEntries {
+			return fmt.Errorf("%w: %d files queried (> %d)", errBadRequest, len(req.Entries), maxEntries)
+		}
+		available := make([]bool, len(req.Entries))
+		for i, entry := range req.Entries {
+			available[i] = backend.Available(entry)
+		}
+		return p2p.Send(peer.rw, AvailabilityMsg, &AvailabilityPacket{
+			ID:        req.ID,
+			Available: available,
+		})
+
+	case AvailabilityMsg:
+		// An availability response arrived to one of our previous queries
+		res := new(AvailabilityPacket)
+		if err := msg.Decode(res); err != nil {
+			return fmt.Errorf("%w: message %v: %v", errDecode, msg, err)
+		}
+		return backend.Handle(peer, res)
+
+	default:
+		return fmt.Errorf("%w: %v", errInvalidMsgCode, msg.Code)
+	}
+}
+
+// NodeInfo represents a short summary of the `avail` sub-protocol metadata
+// known about the host peer.
+type NodeInfo struct {
+	TorrentPort uint64 `json:"torrentPort"` // Port the local torrent client listens on
+}
+
+// nodeInfo retrieves some `avail` protocol metadata about the running host node.
+func nodeInfo(backend Backend) *NodeInfo {
+	return &NodeInfo{TorrentPort: backend.TorrentPort()}
+}
@@ -0,0 +1,254 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package avail
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/p2p"
+	"github.com/CortexFoundation/CortexTheseus/p2p/enode"
+)
+
+// testBackend is a mock implementation of the `avail` backend with an in memory
+// file store.
+type testBackend struct {
+	genesis common.Hash
+	tracker *Tracker
+
+	files map[string][]byte // Files available locally, keyed by info hash
+	lock  sync.Mutex
+}
+
+func newTestBackend(genesis common.Hash) *testBackend {
+	return &testBackend{
+		genesis: genesis,
+		tracker: NewTracker(),
+		files:   make(map[string][]byte),
+	}
+}
+
+func (b *testBackend) Genesis() common.Hash { return b.genesis }
+func (b *testBackend) TorrentPort() uint64  { return 40404 }
+
+func (b *testBackend) Available(entry Entry) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	_, ok := b.files[entry.Hash.Hex()]
+	return ok
+}
+
+func (b *testBackend) RunPeer(peer *Peer, handler Handler) error {
+	if err := b.tracker.Register(peer); err != nil {
+		return err
+	}
+	defer b.tracker.Unregister(peer.ID())
+
+	return handler(peer)
+}
+
+func (b *testBackend) PeerInfo(id enode.ID) interface{}       { return nil }
+func (b *testBackend) Handle(peer *Peer, packet Packet) error { return b.tracker.Deliver(peer, packet) }
+
+// GetFileWithSize, Download and Stop implement FileStore, with every download
+// completing instantly.
+func (b *testBackend) GetFileWithSize(ctx context.Context, infohash string, rawSize uint64, path string) ([]byte, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.files[common.HexToAddress(infohash).Hex()], nil
+}
+
+func (b *testBackend) Download(ctx context.Context, infohash string, request uint64) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.files[common.HexToAddress(infohash).Hex()] = []byte("fetched")
+	return nil
+}
+
+func (b *testBackend) Stop() error { return nil }
+
+// connect runs an `avail` connection between two backends, returning once both
+// sides registered the other one.
+func connect(t *testing.T, a, b *testBackend) (errc chan error) {
+	app, net := p2p.MsgPipe()
+	t.Cleanup(func() {
+		app.Close()
+		net.Close()
+	})
+	var aID, bID enode.ID
+	rand.Read(aID[:])
+	rand.Read(bID[:])
+
+	errc = make(chan error, 2)
+	run := func(backend *testBackend, id enode.ID, rw p2p.MsgReadWriter) {
+		peer := newPeer(avail1, p2p.NewPeer(id, "test", nil), rw)
+		if err := peer.Handshake(backend.Genesis(), backend.TorrentPort()); err != nil {
+			errc <- err
+			return
+		}
+		errc <- backend.RunPeer(peer, func(peer *Peer) error { return handle(backend, peer) })
+	}
+	go run(a, bID, app)
+	go run(b, aID, net)
+
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if len(a.tracker.Peers()) > 0 && len(b.tracker.Peers()) > 0 {
+			return errc
+		}
+		select {
+		case err := <-errc:
+			t.Fatalf("connection failed: %v", err)
+		default:
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("avail handshake timed out")
+		}
+	}
+}
+
+// Tests that peers on different chains are rejected during the handshake.
+func TestHandshakeGenesisMismatch(t *testing.T) {
+	app, net := p2p.MsgPipe()
+	defer app.Close()
+	defer net.Close()
+
+	errc := make(chan error, 2)
+	go func() {
+		errc <- newPeer(avail1, p2p.NewPeer(enode.ID{1}, "a", nil), app).Handshake(common.Hash{0x01}, 1)
+	}()
+	go func() {
+		errc <- newPeer(avail1, p2p.NewPeer(enode.ID{2}, "b", nil), net).Handshake(common.Hash{0x02}, 1)
+	}()
+	if err := <-errc; !errors.Is(err, errGenesisMismatch) {
+		t.Fatalf("handshake error mismatch: have %v, want %v", err, errGenesisMismatch)
+	}
+}
+
+// Tests that the local files are announced on connect and the ones becoming
+// available later are announced to all peers.
+func TestAnnounce(t *testing.T) {
+	a, b := newTestBackend(common.Hash{}), newTestBackend(common.Hash{})
+
+	early, late := Entry{Hash: common.Address{0x01}, Size: 1}, Entry{Hash: common.Address{0x02}, Size: 2}
+	a.tracker.Announce(early)
+	connect(t, a, b)
+
+	peer := b.tracker.Peers()[0]
+	if peer.TorrentPort() != 40404 {
+		t.Errorf("torrent port mismatch: have %d, want %d", peer.TorrentPort(), 40404)
+	}
+	a.tracker.Announce(late)
+	for start := time.Now(); !peer.Has(early.Hash) || !peer.Has(late.Hash); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("announcements not received: early %v, late %v", peer.Has(early.Hash), peer.Has(late.Hash))
+		}
+	}
+}
+
+// Tests that files not announced are looked up by querying the peers.
+func TestHolders(t *testing.T) {
+	a, b, c := newTestBackend(common.Hash{}), newTestBackend(common.Hash{}), newTestBackend(common.Hash{})
+
+	entry := Entry{Hash: common.Address{0x01}, Size: 1}
+	b.files[entry.Hash.Hex()] = []byte("data")
+	connect(t, a, b)
+	connect(t, a, c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	holders := a.tracker.Holders(ctx, entry)
+	if len(holders) != 1 {
+		t.Fatalf("holder count mismatch: have %d, want %d", len(holders), 1)
+	}
+	if !holders[0].Has(entry.Hash) {
+		t.Errorf("holder not marked as having the file")
+	}
+	if holders := a.tracker.Holders(ctx, Entry{Hash: common.Address{0x02}, Size: 2}); len(holders) != 0 {
+		t.Errorf("holders found for missing file: %d", len(holders))
+	}
+}
+
+// Tests that oversized queries get the remote peer dropped.
+func TestOversizedQuery(t *testing.T) {
+	app, net := p2p.MsgPipe()
+	defer app.Close()
+	defer net.Close()
+
+	backend := newTestBackend(common.Hash{})
+	peer := newPeer(avail1, p2p.NewPeer(enode.ID{1}, "test", nil), net)
+
+	errc := make(chan error, 1)
+	go func() { errc <- handle(backend, peer) }()
+
+	if err := p2p.Send(app, GetAvailabilityMsg, &GetAvailabilityPacket{ID: 1, Entries: make([]Entry, maxEntries+1)}); err != nil {
+		t.Fatalf("failed to send query: %v", err)
+	}
+	if err := <-errc; !errors.Is(err, errBadRequest) {
+		t.Fatalf("handler error mismatch: have %v, want %v", err, errBadRequest)
+	}
+}
+
+// Tests that the storage wrapper announces local files, and fetches missing ones
+// in the background if a connected peer holds them.
+func TestStorage(t *testing.T) {
+	a, b := newTestBackend(common.Hash{}), newTestBackend(common.Hash{})
+
+	local, remote, missing := common.Address{0x01}, common.Address{0x02}, common.Address{0x03}
+	a.files[local.Hex()] = []byte("local")
+	b.files[remote.Hex()] = []byte("remote")
+	connect(t, a, b)
+
+	storage := NewStorage(a, a.tracker, time.Second)
+	if data, err := storage.GetFileWithSize(context.Background(), local.Hex(), 5, "data"); err != nil || string(data) != "local" {
+		t.Fatalf("local file mismatch: have %q, %v", data, err)
+	}
+	if entries := a.tracker.Local(); len(entries) != 1 || entries[0].Hash != local {
+		t.Errorf("local file not announced: %v", entries)
+	}
+	// Reading a remote file must not wait for its download
+	if data, err := storage.GetFileWithSize(context.Background(), remote.Hex(), 6, "data"); err != nil || data != nil {
+		t.Fatalf("remote file mismatch before fetching: have %q, %v", data, err)
+	}
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		data, err := storage.GetFileWithSize(context.Background(), remote.Hex(), 6, "data")
+		if err != nil {
+			t.Fatalf("failed to read remote file: %v", err)
+		}
+		if string(data) == "fetched" {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("remote file not fetched in the background")
+		}
+	}
+	if data, err := storage.GetFileWithSize(context.Background(), missing.Hex(), 7, "data"); err != nil || data != nil {
+		t.Fatalf("missing file mismatch: have %q, %v", data, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if data, _ := a.GetFileWithSize(context.Background(), missing.Hex(), 7, "data"); data != nil {
+		t.Fatalf("file without holders fetched: %q", data)
+	}
+}
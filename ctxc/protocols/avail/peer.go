@@ -0,0 +1,190 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package avail
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/p2p"
+	mapset "github.com/ucwong/golang-set"
+)
+
+const (
+	// maxKnownEntries is the maximum number of files to remember as available
+	// at a single peer (prevent DOS).
+	maxKnownEntries = 16384
+
+	// handshakeTimeout is the maximum allowed time for the `avail` handshake to
+	// complete before dropping the connection.
+	handshakeTimeout = 5 * time.Second
+)
+
+// Peer is a collection of relevant information we have about an `avail` peer.
+type Peer struct {
+	id string // Unique ID for the peer, cached
+
+	*p2p.Peer                   // The embedded P2P package peer
+	rw        p2p.MsgReadWriter // Input/output streams for avail
+	version   uint              // Protocol version negotiated
+
+	torrentPort uint64     // Port the torrent client of the peer listens on
+	known       mapset.Set // Set of info hashes known to be available at the peer
+
+	logger log.Logger // Contextual logger with the peer id injected
+}
+
+// newPeer create a wrapper for a network connection and negotiated protocol
+// version.
+func newPeer(version uint, p *p2p.Peer, rw p2p.MsgReadWriter) *Peer {
+	id := fmt.Sprintf("%x", p.ID().Bytes()[:8])
+	return &Peer{
+		id:      id,
+		Peer:    p,
+		rw:      rw,
+		version: version,
+		known:   mapset.NewSet(),
+		logger:  log.New("peer", id),
+	}
+}
+
+// ID retrieves the peer's unique identifier, matching the one used by the
+// ctxc protocol and the downloader.
+func (p *Peer) ID() string {
+	return p.id
+}
+
+// Version retrieves the peer's negotiated `avail` protocol version.
+func (p *Peer) Version() uint {
+	return p.version
+}
+
+// Log overrides the P2P logger with the higher level one containing only the id.
+func (p *Peer) Log() log.Logger {
+	return p.logger
+}
+
+// TorrentPort retrieves the port the torrent client of the peer listens on, as
+// advertised during the handshake.
+func (p *Peer) TorrentPort() uint64 {
+	return p.torrentPort
+}
+
+// TorrentAddr returns the address the torrent client of the peer can be reached
+// at, or nil if it's unknown.
+func (p *Peer) TorrentAddr() *net.TCPAddr {
+	addr, ok := p.RemoteAddr().(*net.TCPAddr)
+	if !ok || p.torrentPort == 0 {
+		return nil
+	}
+	return &net.TCPAddr{IP: addr.IP, Port: int(p.torrentPort)}
+}
+
+// Has returns whether the file with the given info hash is known to be
+// available at the peer.
+func (p *Peer) Has(hash common.Address) bool {
+	return p.known.Contains(hash)
+}
+
+// markAvailable marks files as available at the peer, ensuring that the set
+// of known files never grows beyond the configured limit.
+func (p *Peer) markAvailable(entries []Entry) {
+	for p.known.Cardinality() > maxKnownEntries-len(entries) && p.known.Cardinality() > 0 {
+		p.known.Pop()
+	}
+	for _, entry := range entries {
+		p.known.Add(entry.Hash)
+	}
+}
+
+// Handshake executes the `avail` protocol handshake, exchanging the genesis
+// hash and the torrent port with the remote peer.
+func (p *Peer) Handshake(genesis common.Hash, torrentPort uint64) error {
+	errc := make(chan error, 2)
+
+	var status StatusPacket // safe to read after two values have been received from errc
+	go func() {
+		errc <- p2p.Send(p.rw, StatusMsg, &StatusPacket{
+			ProtocolVersion: uint32(p.version),
+			Genesis:         genesis,
+			TorrentPort:     torrentPort,
+		})
+	}()
+	go func() {
+		errc <- p.readStatus(&status, genesis)
+	}()
+	timeout := time.NewTimer(handshakeTimeout)
+	defer timeout.Stop()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errc:
+			if err != nil {
+				return err
+			}
+		case <-timeout.C:
+			return p2p.DiscReadTimeout
+		}
+	}
+	p.torrentPort = status.TorrentPort
+	return nil
+}
+
+// readStatus reads the remote handshake message and validates it against the
+// local chain.
+func (p *Peer) readStatus(status *StatusPacket, genesis common.Hash) error {
+	msg, err := p.rw.ReadMsg()
+	if err != nil {
+		return err
+	}
+	defer msg.Discard()
+
+	if msg.Code != StatusMsg {
+		return fmt.Errorf("%w: first msg has code %x (!= %x)", errNoStatusMsg, msg.Code, StatusMsg)
+	}
+	if msg.Size > maxMessageSize {
+		return fmt.Errorf("%w: %v > %v", errMsgTooLarge, msg.Size, maxMessageSize)
+	}
+	if err := msg.Decode(status); err != nil {
+		return fmt.Errorf("%w: message %v: %v", errDecode, msg, err)
+	}
+	if uint(status.ProtocolVersion) != p.version {
+		return fmt.Errorf("%w: %d (!= %d)", errProtocolVersion, status.ProtocolVersion, p.version)
+	}
+	if status.Genesis != genesis {
+		return fmt.Errorf("%w: %x (!= %x)", errGenesisMismatch, status.Genesis, genesis)
+	}
+	return nil
+}
+
+// AnnounceAvailability notifies the peer about files that are available
+// locally.
+func (p *Peer) AnnounceAvailability(entries []Entry) error {
+	p.logger.Trace("Announcing available files", "count", len(entries))
+	return p2p.Send(p.rw, AnnounceMsg, AnnouncePacket(entries))
+}
+
+// RequestAvailability queries whether the peer holds a batch of files.
+func (p *Peer) RequestAvailability(id uint64, entries []Entry) error {
+	p.logger.Trace("Querying file availability", "reqid", id, "count", len(entries))
+	return p2p.Send(p.rw, GetAvailabilityMsg, &GetAvailabilityPacket{
+		ID:      id,
+		Entries: entries,
+	})
+}
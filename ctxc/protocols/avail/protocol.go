@@ -0,0 +1,115 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package avail
+
+import (
+	"errors"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+)
+
+// Constants to match up protocol versions and messages
+const (
+	avail1 = 1
+)
+
+// ProtocolName is the official short name of the `avail` protocol used during
+// devp2p capability negotiation.
+const ProtocolName = "avail"
+
+// ProtocolVersions are the supported versions of the `avail` protocol (first
+// is primary).
+var ProtocolVersions = []uint{avail1}
+
+// protocolLengths are the number of implemented message corresponding to
+// different protocol versions.
+var protocolLengths = map[uint]uint64{avail1: 4}
+
+// maxMessageSize is the maximum cap on the size of a protocol message.
+const maxMessageSize = 1024 * 1024
+
+// maxEntries is the maximum number of files announced or queried in a single
+// message.
+const maxEntries = 1024
+
+// avail protocol message codes
+const (
+	StatusMsg          = 0x00
+	AnnounceMsg        = 0x01
+	GetAvailabilityMsg = 0x02
+	AvailabilityMsg    = 0x03
+)
+
+var (
+	errMsgTooLarge     = errors.New("message too long")
+	errDecode          = errors.New("invalid message")
+	errInvalidMsgCode  = errors.New("invalid message code")
+	errBadRequest      = errors.New("bad request")
+	errNoStatusMsg     = errors.New("no status message")
+	errGenesisMismatch = errors.New("genesis mismatch")
+	errProtocolVersion = errors.New("protocol version mismatch")
+)
+
+// Packet represents a p2p message in the `avail` protocol.
+type Packet interface {
+	Name() string // Name returns a string corresponding to the message type.
+	Kind() byte   // Kind returns the message type.
+}
+
+// Entry identifies a model or input file by its torrent info hash and the raw
+// size recorded on chain.
+type Entry struct {
+	Hash common.Address // Info hash of the torrent holding the file
+	Size uint64         // Raw size of the file in bytes
+}
+
+// StatusPacket is the handshake message of the `avail` protocol.
+type StatusPacket struct {
+	ProtocolVersion uint32
+	Genesis         common.Hash // Genesis of the chain the files belong to
+	TorrentPort     uint64      // Port the torrent client of the peer listens on
+}
+
+// AnnouncePacket is the network packet for announcing files that became
+// available locally.
+type AnnouncePacket []Entry
+
+// GetAvailabilityPacket represents a query whether the peer holds the given
+// files.
+type GetAvailabilityPacket struct {
+	ID      uint64  // Request ID to match up responses with
+	Entries []Entry // Files to check the availability of
+}
+
+// AvailabilityPacket is the response to a GetAvailabilityPacket, flagging each
+// queried file in order.
+type AvailabilityPacket struct {
+	ID        uint64 // ID of the request this is a response for
+	Available []bool // Whether the file at the same index is available
+}
+
+func (*StatusPacket) Name() string { return "Status" }
+func (*StatusPacket) Kind() byte   { return StatusMsg }
+
+func (*AnnouncePacket) Name() string { return "Announce" }
+func (*AnnouncePacket) Kind() byte   { return AnnounceMsg }
+
+func (*GetAvailabilityPacket) Name() string { return "GetAvailability" }
+func (*GetAvailabilityPacket) Kind() byte   { return GetAvailabilityMsg }
+
+func (*AvailabilityPacket) Name() string { return "Availability" }
+func (*AvailabilityPacket) Kind() byte   { return AvailabilityMsg }
@@ -0,0 +1,150 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package avail
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/log"
+)
+
+// pollInterval is the time to wait between attempts to hand the holders of a
+// file over to its download, which the file store registers asynchronously.
+const pollInterval = 100 * time.Millisecond
+
+// FileStore is the local store of model and input files, as used by the
+// inference engine.
+type FileStore interface {
+	GetFileWithSize(ctx context.Context, infohash string, rawSize uint64, path string) ([]byte, error)
+	Download(ctx context.Context, infohash string, request uint64) error
+	Stop() error
+}
+
+// PeerAdder is implemented by the file stores able to download a file from given
+// torrent peers, besides the ones they discover themselves.
+type PeerAdder interface {
+	AddPeers(infohash string, addrs []*net.TCPAddr) error
+}
+
+// Storage wraps a local file store, announcing every file read from it to the
+// connected peers. Files missing locally are not waited for: the read fails
+// right away, while the connected peers holding the file are looked up in the
+// background and the download is scheduled from them.
+type Storage struct {
+	FileStore
+
+	tracker  *Tracker
+	wait     time.Duration
+	fetching map[common.Address]struct{} // Files currently looked up at peers
+	lock     sync.Mutex
+}
+
+// NewStorage wraps a local file store with peer availability tracking. The wait
+// bounds the background lookup of a missing file, zero disables fetching from
+// peers, only announcing local files.
+func NewStorage(store FileStore, tracker *Tracker, wait time.Duration) *Storage {
+	return &Storage{
+		FileStore: store,
+		tracker:   tracker,
+		wait:      wait,
+		fetching:  make(map[common.Address]struct{}),
+	}
+}
+
+// GetFileWithSize retrieves a file from the local store. If the file is not
+// available yet, its download from the connected peers holding it is scheduled
+// in the background and the local result is returned without waiting.
+func (s *Storage) GetFileWithSize(ctx context.Context, infohash string, rawSize uint64, path string) ([]byte, error) {
+	data, err := s.FileStore.GetFileWithSize(ctx, infohash, rawSize, path)
+	if !common.IsHexAddress(infohash) {
+		return data, err
+	}
+	entry := Entry{Hash: common.HexToAddress(infohash), Size: rawSize}
+	if err == nil && data != nil {
+		s.tracker.Announce(entry)
+		return data, nil
+	}
+	if s.wait != 0 {
+		s.fetch(infohash, entry)
+	}
+	return data, err
+}
+
+// fetch looks up the connected peers holding a file in the background, and
+// schedules its download with them added as torrent peers. Only one lookup is
+// running for a file at a time.
+func (s *Storage) fetch(infohash string, entry Entry) {
+	s.lock.Lock()
+	if _, ok := s.fetching[entry.Hash]; ok {
+		s.lock.Unlock()
+		return
+	}
+	s.fetching[entry.Hash] = struct{}{}
+	s.lock.Unlock()
+
+	go func() {
+		defer func() {
+			s.lock.Lock()
+			delete(s.fetching, entry.Hash)
+			s.lock.Unlock()
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), s.wait)
+		defer cancel()
+
+		holders := s.tracker.Holders(ctx, entry)
+		if len(holders) == 0 {
+			return
+		}
+		log.Debug("Fetching file from connected peers", "ih", infohash, "size", common.StorageSize(entry.Size), "holders", len(holders))
+		if err := s.Download(ctx, infohash, entry.Size); err != nil {
+			log.Debug("Failed to request file download", "ih", infohash, "err", err)
+			return
+		}
+		adder, ok := s.FileStore.(PeerAdder)
+		if !ok {
+			return
+		}
+		var addrs []*net.TCPAddr
+		for _, peer := range holders {
+			if addr := peer.TorrentAddr(); addr != nil {
+				addrs = append(addrs, addr)
+			}
+		}
+		if len(addrs) == 0 {
+			return
+		}
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			err := adder.AddPeers(infohash, addrs)
+			if err == nil {
+				log.Debug("Added file holders as torrent peers", "ih", infohash, "peers", len(addrs))
+				return
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				log.Debug("Failed to add file holders as torrent peers", "ih", infohash, "err", err)
+				return
+			}
+		}
+	}()
+}
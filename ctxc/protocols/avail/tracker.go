@@ -0,0 +1,269 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package avail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/log"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// maxLocalEntries is the maximum number of locally available files to remember
+// and announce to newly connected peers.
+const maxLocalEntries = 4096
+
+// request is a pending availability query sent to a single peer.
+type request struct {
+	peer    *Peer          // Peer the query was sent to
+	entries []Entry        // Files queried, to validate the response against
+	deliver chan *response // Channel to deliver the answer on
+}
+
+// response is the answer of a peer to an availability query. The flags are
+// nil if the query failed.
+type response struct {
+	peer      *Peer  // Peer that answered the query
+	available []bool // Availability flags for the queried files
+}
+
+// Tracker keeps track of which connected `avail` peers hold which model and
+// input files, announcing the locally available ones to them and querying them
+// on demand.
+type Tracker struct {
+	peers    map[string]*Peer    // Currently connected peers
+	requests map[uint64]*request // Availability queries currently in flight
+	nextID   uint64              // Request ID of the next query
+
+	local *lru.Cache // Locally available files, announced to new peers
+
+	lock sync.RWMutex
+}
+
+// NewTracker creates an empty availability tracker.
+func NewTracker() *Tracker {
+	local, _ := lru.New(maxLocalEntries)
+	return &Tracker{
+		peers:    make(map[string]*Peer),
+		requests: make(map[uint64]*request),
+		local:    local,
+	}
+}
+
+// Register injects a new `avail` peer into the tracker and announces all the
+// locally available files to it.
+func (t *Tracker) Register(peer *Peer) error {
+	id := peer.ID()
+
+	t.lock.Lock()
+	if _, ok := t.peers[id]; ok {
+		log.Error("Avail peer already registered", "id", id)
+
+		t.lock.Unlock()
+		return errors.New("already registered")
+	}
+	t.peers[id] = peer
+	t.lock.Unlock()
+
+	if entries := t.Local(); len(entries) > 0 {
+		go func() {
+			for len(entries) > 0 {
+				batch := entries
+				if len(batch) > maxEntries {
+					batch = batch[:maxEntries]
+				}
+				if err := peer.AnnounceAvailability(batch); err != nil {
+					return
+				}
+				entries = entries[len(batch):]
+			}
+		}()
+	}
+	return nil
+}
+
+// Unregister removes a peer from the tracker, failing any queries still waiting
+// for its response.
+func (t *Tracker) Unregister(id string) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if _, ok := t.peers[id]; !ok {
+		log.Error("Avail peer not registered", "id", id)
+		return errors.New("not registered")
+	}
+	delete(t.peers, id)
+
+	for reqid, req := range t.requests {
+		if req.peer.ID() == id {
+			delete(t.requests, reqid)
+			req.deliver <- &response{peer: req.peer}
+		}
+	}
+	return nil
+}
+
+// Peers retrieves all the currently connected `avail` peers.
+func (t *Tracker) Peers() []*Peer {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	peers := make([]*Peer, 0, len(t.peers))
+	for _, peer := range t.peers {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+// Local retrieves the files known to be available locally, oldest first.
+func (t *Tracker) Local() []Entry {
+	keys := t.local.Keys()
+	entries := make([]Entry, 0, len(keys))
+	for _, key := range keys {
+		if size, ok := t.local.Peek(key); ok {
+			entries = append(entries, Entry{Hash: key.(common.Address), Size: size.(uint64)})
+		}
+	}
+	return entries
+}
+
+// Announce marks files as available locally and notifies all connected peers
+// about the ones not announced before.
+func (t *Tracker) Announce(entries ...Entry) {
+	var fresh []Entry
+	for _, entry := range entries {
+		if ok, _ := t.local.ContainsOrAdd(entry.Hash, entry.Size); !ok {
+			fresh = append(fresh, entry)
+		}
+	}
+	if len(fresh) == 0 {
+		return
+	}
+	for _, peer := range t.Peers() {
+		go peer.AnnounceAvailability(fresh)
+	}
+}
+
+// Deliver is invoked by the `avail` handler for packets not consumed by the
+// protocol itself, matching availability responses to pending queries.
+func (t *Tracker) Deliver(peer *Peer, packet Packet) error {
+	switch packet := packet.(type) {
+	case *AnnouncePacket:
+		// The protocol handler already marked the files on the peer
+		peer.Log().Trace("Files announced as available", "count", len(*packet))
+		return nil
+
+	case *AvailabilityPacket:
+		t.lock.Lock()
+		req, ok := t.requests[packet.ID]
+		if !ok || req.peer != peer {
+			t.lock.Unlock()
+			peer.Log().Debug("Unrequested availability response", "reqid", packet.ID)
+			return nil
+		}
+		delete(t.requests, packet.ID)
+		t.lock.Unlock()
+
+		if len(packet.Available) != len(req.entries) {
+			req.deliver <- &response{peer: peer}
+			return fmt.Errorf("%w: %d flags for %d files", errBadRequest, len(packet.Available), len(req.entries))
+		}
+		var held []Entry
+		for i, ok := range packet.Available {
+			if ok {
+				held = append(held, req.entries[i])
+			}
+		}
+		peer.markAvailable(held)
+		req.deliver <- &response{peer: peer, available: packet.Available}
+		return nil
+
+	default:
+		return fmt.Errorf("unexpected avail packet type: %T", packet)
+	}
+}
+
+// Holders returns the connected peers holding the given file. Peers already
+// known to hold it are returned right away, otherwise all peers are queried and
+// the ones answering positively before the context expires are returned.
+func (t *Tracker) Holders(ctx context.Context, entry Entry) []*Peer {
+	var (
+		holders []*Peer
+		pending = make(map[uint64]*Peer)
+	)
+	t.lock.Lock()
+	for _, peer := range t.peers {
+		if peer.Has(entry.Hash) {
+			holders = append(holders, peer)
+		}
+	}
+	if len(holders) > 0 {
+		t.lock.Unlock()
+		return holders
+	}
+	deliver := make(chan *response, len(t.peers))
+	for _, peer := range t.peers {
+		reqid := t.nextID
+		t.nextID++
+
+		t.requests[reqid] = &request{peer: peer, entries: []Entry{entry}, deliver: deliver}
+		pending[reqid] = peer
+
+		go func(peer *Peer, reqid uint64) {
+			if err := peer.RequestAvailability(reqid, []Entry{entry}); err != nil {
+				t.fail(reqid)
+			}
+		}(peer, reqid)
+	}
+	t.lock.Unlock()
+
+	// Collect the answers until all peers responded or the context expires
+	defer func() {
+		t.lock.Lock()
+		for reqid := range pending {
+			delete(t.requests, reqid)
+		}
+		t.lock.Unlock()
+	}()
+	for range pending {
+		select {
+		case res := <-deliver:
+			if len(res.available) == 1 && res.available[0] {
+				holders = append(holders, res.peer)
+			}
+		case <-ctx.Done():
+			return holders
+		}
+	}
+	return holders
+}
+
+// fail drops a pending query, signalling its requester that no answer will
+// arrive.
+func (t *Tracker) fail(reqid uint64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if req, ok := t.requests[reqid]; ok {
+		delete(t.requests, reqid)
+		req.deliver <- &response{peer: req.peer}
+	}
+}
@@ -0,0 +1,1150 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package snap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
+	"github.com/CortexFoundation/CortexTheseus/core/state"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/ctxcdb"
+	"github.com/CortexFoundation/CortexTheseus/ctxcdb/memorydb"
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/rlp"
+	"github.com/CortexFoundation/CortexTheseus/trie"
+)
+
+var (
+	// emptyRoot is the known root hash of an empty trie.
+	emptyRoot = common.HexToHash("56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
+
+	// emptyCode is the known hash of the empty EVM bytecode.
+	emptyCode = crypto.Keccak256Hash(nil)
+
+	// maxHash is the last hash of the key space, used as the upper bound of the
+	// account and storage ranges.
+	maxHash = common.HexToHash("0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff")
+)
+
+const (
+	// maxRequestSize is the maximum number of bytes to request from a remote peer.
+	maxRequestSize = 512 * 1024
+
+	// maxStorageSetRequestCount is the maximum number of contracts to request the
+	// storage of in a single query. If this number is too low, we're not filling
+	// responses fully and waste round trip times. If it's too high, we're capping
+	// responses and waste bandwidth.
+	maxStorageSetRequestCount = maxRequestSize / 1024
+
+	// maxCodeRequestCount is the maximum number of bytecode blobs to request in a
+	// single query. If this number is too low, we're not filling responses fully
+	// and waste round trip times. If it's too high, we're capping responses and
+	// waste bandwidth.
+	maxCodeRequestCount = maxRequestSize / (24 * 1024) * 4
+
+	// maxTrieRequestCount is the maximum number of trie node blobs to request in
+	// a single query. If this number is too low, we're not filling responses fully
+	// and waste round trip times. If it's too high, we're capping responses and
+	// waste bandwidth.
+	maxTrieRequestCount = 512
+
+	// requestTimeout is the maximum time a peer is allowed to spend on serving
+	// a single network request.
+	requestTimeout = 10 * time.Second
+
+	// accountConcurrency is the number of chunks to split the account trie into
+	// to allow concurrent retrievals.
+	accountConcurrency = 16
+
+	// progressInterval is the frequency at which the sync progress is logged.
+	progressInterval = 8 * time.Second
+)
+
+// ErrCancelled is returned from snap syncing if the operation was prematurely
+// terminated.
+var ErrCancelled = errors.New("sync cancelled")
+
+// SyncPeer abstracts out the methods required for a peer to be synced against
+// with the goal of allowing the construction of mock peers without the full
+// blown networking.
+type SyncPeer interface {
+	// ID retrieves the peer's unique identifier.
+	ID() string
+
+	// RequestAccountRange fetches a batch of accounts rooted in a specific account
+	// trie, starting with the origin.
+	RequestAccountRange(id uint64, root, origin, limit common.Hash, bytes uint64) error
+
+	// RequestStorageRanges fetches a batch of storage slots belonging to one or
+	// more accounts. If slots from only one account is requested, an origin marker
+	// may also be used to retrieve from there.
+	RequestStorageRanges(id uint64, root common.Hash, accounts []common.Hash, origin, limit []byte, bytes uint64) error
+
+	// RequestByteCodes fetches a batch of bytecodes by hash.
+	RequestByteCodes(id uint64, hashes []common.Hash, bytes uint64) error
+
+	// RequestTrieNodes fetches a batch of account or storage trie nodes rooted in
+	// a specific state trie.
+	RequestTrieNodes(id uint64, root common.Hash, paths []TrieNodePathSet, bytes uint64) error
+
+	// Log retrieves the peer's own contextual logger.
+	Log() log.Logger
+}
+
+// request is a single data retrieval request sent to a remote peer. Depending
+// on its kind, only a subset of the fields are filled in.
+type request struct {
+	id   uint64 // Request ID of this request
+	peer string // Peer to which this request is assigned
+	kind byte   // Message code of the request packet
+
+	timeout *time.Timer   // Timer to track delivery timeout
+	stale   chan struct{} // Channel to signal the sync cycle was dropped
+
+	task   *accountTask // Account task the request belongs to (nil for healing)
+	origin common.Hash  // First account or storage slot requested
+	limit  common.Hash  // Last account requested
+
+	accounts []common.Hash // Accounts to download the storage of
+	roots    []common.Hash // Storage roots to verify the returned slots against
+
+	hashes []common.Hash   // Code or trie node hashes to validate responses against
+	paths  []trie.SyncPath // Trie node paths for identifying trie nodes
+	heal   bool            // Whether the request is part of the healing phase
+}
+
+// response is a delivered or failed reply to a previously issued request.
+type response struct {
+	req    *request // Original request to match up with
+	failed bool     // Whether the request timed out or its peer dropped
+
+	hashes   []common.Hash // Account hashes in the returned range
+	accounts [][]byte      // Expanded accounts in the returned range
+
+	slotHashes [][]common.Hash // Storage slot hashes in the returned ranges
+	slots      [][][]byte      // Storage slot values in the returned ranges
+
+	proof [][]byte // Merkle proof for the (last) returned range
+	blobs [][]byte // Returned bytecodes or trie nodes
+}
+
+// accountChunk is a verified account range waiting for the storage and code of
+// its accounts to be retrieved before its trie nodes can be persisted.
+type accountChunk struct {
+	hashes   []common.Hash    // Account hashes in the retrieved range
+	accounts []*state.Account // Expanded accounts in the retrieved range
+
+	trie  *trie.Trie               // Account trie reconstructed from the range
+	nodes ctxcdb.KeyValueStore     // Trie nodes reconstructed from the range
+	skip  map[common.Hash]struct{} // Boundary and overflow nodes not to persist
+	cont  bool                     // Whether the account range has a continuation
+}
+
+// accountTask represents the sync task for a chunk of the account snapshot.
+type accountTask struct {
+	Next common.Hash // Next account to sync in this interval
+	Last common.Hash // Last account to sync in this interval
+	done bool        // Flag whether the interval is fully synced
+
+	req bool          // Flag whether an account range request is in flight
+	res *accountChunk // Verified range waiting for its dependencies
+
+	stateTasks    map[common.Hash]common.Hash // Storage roots still to download, keyed by account
+	stateNext     map[common.Hash]common.Hash // Continuation markers of large storage tries
+	stateInFlight map[common.Hash]struct{}    // Accounts with storage requests in flight
+	codeTasks     map[common.Hash]struct{}    // Code hashes still to download
+	codeInFlight  map[common.Hash]struct{}    // Code hashes with requests in flight
+	needHeal      map[common.Hash]struct{}    // Accounts with storage tries containing gaps
+}
+
+// reset drops any pending range of the task, rewinding it to the first account
+// that was not yet persisted.
+func (task *accountTask) reset() {
+	task.req = false
+	task.res = nil
+	task.stateTasks = make(map[common.Hash]common.Hash)
+	task.stateNext = make(map[common.Hash]common.Hash)
+	task.stateInFlight = make(map[common.Hash]struct{})
+	task.codeTasks = make(map[common.Hash]struct{})
+	task.codeInFlight = make(map[common.Hash]struct{})
+	task.needHeal = make(map[common.Hash]struct{})
+}
+
+// healTask represents the sync task for healing the snap-synced chunk boundaries.
+type healTask struct {
+	scheduler *trie.Sync // State trie sync scheduler defining the tasks
+
+	trieTasks map[common.Hash]trie.SyncPath // Set of trie node tasks currently queued for retrieval
+	codeTasks map[common.Hash]struct{}      // Set of byte code tasks currently queued for retrieval
+}
+
+// Syncer is a Cortex account and storage trie syncer based on snapshots and
+// the snap protocol. Its purpose is to download all the accounts and storage
+// slots from remote peers and reassemble chunks of the state trie, on top of
+// which a state sync can be run to fix any gaps / overlaps.
+//
+// Every network request has a variety of failure events:
+//   - The peer disconnects after task assignment, failing to send the request
+//   - The peer disconnects after sending the request, before delivering on it
+//   - The peer remains connected, but does not deliver a response in time
+//   - The peer delivers a stale response after a previous timeout
+//   - The peer delivers a refusal to serve the requested state
+type Syncer struct {
+	db    ctxcdb.KeyValueStore // Database to store the trie nodes into (and dedup)
+	bloom *trie.SyncBloom      // Bloom filter to deduplicate nodes for state fixup
+
+	root   common.Hash    // Current state trie root being synced
+	tasks  []*accountTask // Current account task set being synced
+	healer *healTask      // Current state healing task being executed
+
+	peers     map[string]SyncPeer // Currently active peers to download from
+	busy      map[string]struct{} // Peers with a request currently in flight
+	stateless map[string]struct{} // Peers that failed to deliver the current state
+	requests  map[uint64]*request // Requests currently running
+	update    chan struct{}       // Notification channel for possible sync progression
+
+	deliveries chan *response // Channel to feed responses into the sync loop
+	stale      chan struct{}  // Channel closed when the current sync cycle ends
+
+	accountSynced  uint64             // Number of accounts downloaded
+	accountBytes   common.StorageSize // Number of account trie bytes persisted to disk
+	bytecodeSynced uint64             // Number of bytecodes downloaded
+	bytecodeBytes  common.StorageSize // Number of bytecode bytes downloaded
+	storageSynced  uint64             // Number of storage slots downloaded
+	storageBytes   common.StorageSize // Number of storage trie bytes persisted to disk
+
+	trienodeHealSynced uint64 // Number of state trie nodes downloaded
+	bytecodeHealSynced uint64 // Number of bytecodes downloaded
+
+	startTime time.Time // Time instance when snapshot sync started
+	logTime   time.Time // Time instance when status was last reported
+
+	lock sync.RWMutex // Protects fields that can change outside of sync (peers, reqs, root)
+}
+
+// NewSyncer creates a new snapshot syncer to download the Cortex state over the
+// snap protocol.
+func NewSyncer(db ctxcdb.KeyValueStore, bloom *trie.SyncBloom) *Syncer {
+	return &Syncer{
+		db:         db,
+		bloom:      bloom,
+		peers:      make(map[string]SyncPeer),
+		busy:       make(map[string]struct{}),
+		stateless:  make(map[string]struct{}),
+		requests:   make(map[uint64]*request),
+		update:     make(chan struct{}, 1),
+		deliveries: make(chan *response),
+	}
+}
+
+// Register injects a new data source into the syncer's peerset.
+func (s *Syncer) Register(peer SyncPeer) error {
+	// Make sure the peer is not registered yet
+	id := peer.ID()
+
+	s.lock.Lock()
+	if _, ok := s.peers[id]; ok {
+		log.Error("Snap peer already registered", "id", id)
+
+		s.lock.Unlock()
+		return errors.New("already registered")
+	}
+	s.peers[id] = peer
+	s.lock.Unlock()
+
+	// Notify any active syncs that a new peer can be assigned data
+	s.signal()
+	return nil
+}
+
+// Unregister removes a data source from the syncer's peerset.
+func (s *Syncer) Unregister(id string) error {
+	// Remove all traces of the peer from the registry
+	s.lock.Lock()
+	if _, ok := s.peers[id]; !ok {
+		log.Error("Snap peer not registered", "id", id)
+
+		s.lock.Unlock()
+		return errors.New("not registered")
+	}
+	delete(s.peers, id)
+	delete(s.busy, id)
+	delete(s.stateless, id)
+
+	// Fail all the requests that were assigned to the departed peer
+	var failed []*request
+	for reqid, req := range s.requests {
+		if req.peer == id {
+			req.timeout.Stop()
+			delete(s.requests, reqid)
+			failed = append(failed, req)
+		}
+	}
+	s.lock.Unlock()
+
+	for _, req := range failed {
+		go s.deliver(&response{req: req, failed: true})
+	}
+	// Notify any active syncs that pending requests need to be reverted
+	s.signal()
+	return nil
+}
+
+// Sync starts (or resumes a previous) sync cycle to iterate over a state trie
+// with the given root and reconstruct the nodes based on the snapshot leaves.
+// Previously downloaded segments will not be redownloaded or fixed, rather any
+// errors will be healed after the leaves are fully accumulated.
+func (s *Syncer) Sync(root common.Hash, cancel chan struct{}) error {
+	// Move the trie root from any previous value, revert stateless markers for
+	// any peers and initialize the syncer if it was not yet run
+	s.lock.Lock()
+	s.root = root
+	s.stale = make(chan struct{})
+	s.stateless = make(map[string]struct{})
+	s.healer = &healTask{
+		scheduler: state.NewStateSync(root, s.db, s.bloom),
+		trieTasks: make(map[common.Hash]trie.SyncPath),
+		codeTasks: make(map[common.Hash]struct{}),
+	}
+	if s.tasks == nil {
+		s.tasks = newAccountTasks()
+		s.startTime = time.Now()
+	}
+	// Any ranges pending from a previous cycle might belong to a different
+	// root, drop them and restart each task from its last persisted account
+	for _, task := range s.tasks {
+		task.reset()
+	}
+	s.lock.Unlock()
+
+	defer func() {
+		// Drop all the in-flight requests of this cycle, any late responses
+		// will be discarded as unrequested
+		s.lock.Lock()
+		for id, req := range s.requests {
+			req.timeout.Stop()
+			delete(s.requests, id)
+		}
+		s.busy = make(map[string]struct{})
+		close(s.stale)
+		s.lock.Unlock()
+	}()
+	log.Debug("Starting snapshot sync cycle", "root", root)
+
+	for {
+		// Terminate the sync once all the ranges are retrieved and healed
+		if s.done() {
+			s.reportSyncProgress(true)
+			s.reportHealProgress(true)
+			log.Debug("Snapshot sync cycle completed", "root", root)
+			return nil
+		}
+		// Assign all the data retrieval tasks to any free peers
+		s.assignTasks()
+
+		// Wait for something to happen
+		select {
+		case <-s.update:
+			// Something happened (new peer, delivery, timeout), recheck tasks
+		case <-cancel:
+			return ErrCancelled
+
+		case res := <-s.deliveries:
+			if res.req.stale == s.stale {
+				s.process(res)
+			}
+		case <-time.After(progressInterval):
+			// Nothing arrived for a while, make sure the progress is visible
+		}
+		if s.rangesDone() {
+			s.reportHealProgress(false)
+		} else {
+			s.reportSyncProgress(false)
+		}
+	}
+}
+
+// OnAccounts is a callback method to invoke when a range of accounts are
+// received from a remote peer.
+func (s *Syncer) OnAccounts(peer SyncPeer, id uint64, hashes []common.Hash, accounts [][]byte, proof [][]byte) error {
+	req := s.claim(peer, id, GetAccountRangeMsg)
+	if req == nil {
+		return nil
+	}
+	s.deliver(&response{req: req, hashes: hashes, accounts: accounts, proof: proof})
+	return nil
+}
+
+// OnStorage is a callback method to invoke when ranges of storage slots
+// are received from a remote peer.
+func (s *Syncer) OnStorage(peer SyncPeer, id uint64, hashes [][]common.Hash, slots [][][]byte, proof [][]byte) error {
+	req := s.claim(peer, id, GetStorageRangesMsg)
+	if req == nil {
+		return nil
+	}
+	s.deliver(&response{req: req, slotHashes: hashes, slots: slots, proof: proof})
+	return nil
+}
+
+// OnByteCodes is a callback method to invoke when a batch of contract
+// bytes codes are received from a remote peer.
+func (s *Syncer) OnByteCodes(peer SyncPeer, id uint64, bytecodes [][]byte) error {
+	req := s.claim(peer, id, GetByteCodesMsg)
+	if req == nil {
+		return nil
+	}
+	s.deliver(&response{req: req, blobs: bytecodes})
+	return nil
+}
+
+// OnTrieNodes is a callback method to invoke when a batch of trie nodes
+// are received from a remote peer.
+func (s *Syncer) OnTrieNodes(peer SyncPeer, id uint64, trienodes [][]byte) error {
+	req := s.claim(peer, id, GetTrieNodesMsg)
+	if req == nil {
+		return nil
+	}
+	s.deliver(&response{req: req, blobs: trienodes})
+	return nil
+}
+
+// claim retrieves and removes the pending request matching a delivery, or nil
+// if the delivery was not requested from the peer (or already timed out).
+func (s *Syncer) claim(peer SyncPeer, id uint64, kind byte) *request {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	req, ok := s.requests[id]
+	if !ok || req.peer != peer.ID() || req.kind != kind {
+		peer.Log().Debug("Unexpected snap response", "reqid", id, "kind", kind)
+		return nil
+	}
+	req.timeout.Stop()
+	delete(s.requests, id)
+	return req
+}
+
+// deliver feeds a response into the sync loop, unless the sync cycle the request
+// belongs to was already torn down.
+func (s *Syncer) deliver(res *response) {
+	select {
+	case s.deliveries <- res:
+	case <-res.req.stale:
+	}
+}
+
+// signal notifies the sync loop that it should recheck its task assignments.
+func (s *Syncer) signal() {
+	select {
+	case s.update <- struct{}{}:
+	default:
+	}
+}
+
+// markStateless flags a peer as unable to serve the state currently synced, so
+// no more requests are assigned to it until the sync root changes.
+func (s *Syncer) markStateless(id string) {
+	s.lock.Lock()
+	s.stateless[id] = struct{}{}
+	s.lock.Unlock()
+}
+
+// rangesDone returns whether all the account ranges, together with their
+// storage and bytecodes, were retrieved.
+func (s *Syncer) rangesDone() bool {
+	for _, task := range s.tasks {
+		if !task.done || task.res != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// done returns whether the state trie is fully retrieved and healed.
+func (s *Syncer) done() bool {
+	return s.rangesDone() && s.healer.scheduler.Pending() == 0 &&
+		len(s.healer.trieTasks) == 0 && len(s.healer.codeTasks) == 0
+}
+
+// assignTasks attempts to match idle peers to pending retrievals.
+func (s *Syncer) assignTasks() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for id, peer := range s.peers {
+		if _, ok := s.busy[id]; ok {
+			continue
+		}
+		if _, ok := s.stateless[id]; ok {
+			continue
+		}
+		req := s.nextRequest()
+		if req == nil {
+			return
+		}
+		s.issue(peer, req)
+	}
+}
+
+// nextRequest assembles the next data retrieval request to assign to a peer,
+// or nil if there's nothing left to retrieve for now. Data needed to complete
+// a pending account range is prioritised over new ranges, and healing only
+// starts once all the ranges are complete.
+func (s *Syncer) nextRequest() *request {
+	for _, task := range s.tasks {
+		if task.res == nil {
+			continue
+		}
+		// Fetch any missing bytecodes of the pending account range
+		var hashes []common.Hash
+		for hash := range task.codeTasks {
+			if _, ok := task.codeInFlight[hash]; ok {
+				continue
+			}
+			task.codeInFlight[hash] = struct{}{}
+			if hashes = append(hashes, hash); len(hashes) >= maxCodeRequestCount {
+				break
+			}
+		}
+		if len(hashes) > 0 {
+			return &request{kind: GetByteCodesMsg, task: task, hashes: hashes}
+		}
+		// Large contracts are continued alone from their last retrieved slot
+		for account, next := range task.stateNext {
+			if _, ok := task.stateInFlight[account]; ok {
+				continue
+			}
+			task.stateInFlight[account] = struct{}{}
+			return &request{
+				kind:     GetStorageRangesMsg,
+				task:     task,
+				origin:   next,
+				accounts: []common.Hash{account},
+				roots:    []common.Hash{task.stateTasks[account]},
+			}
+		}
+		// Small contracts are retrieved in batches
+		var accounts, roots []common.Hash
+		for account, root := range task.stateTasks {
+			if _, ok := task.stateInFlight[account]; ok {
+				continue
+			}
+			task.stateInFlight[account] = struct{}{}
+			accounts, roots = append(accounts, account), append(roots, root)
+			if len(accounts) >= maxStorageSetRequestCount {
+				break
+			}
+		}
+		if len(accounts) > 0 {
+			return &request{kind: GetStorageRangesMsg, task: task, accounts: accounts, roots: roots}
+		}
+	}
+	// No data missing for the pending ranges, retrieve a new range
+	for _, task := range s.tasks {
+		if task.done || task.req || task.res != nil {
+			continue
+		}
+		task.req = true
+		return &request{kind: GetAccountRangeMsg, task: task, origin: task.Next, limit: task.Last}
+	}
+	if !s.rangesDone() {
+		return nil
+	}
+	// All ranges are complete, fill any gaps in the state trie
+	s.fillHealTasks()
+
+	if len(s.healer.trieTasks) > 0 {
+		req := &request{kind: GetTrieNodesMsg, heal: true}
+		for hash, path := range s.healer.trieTasks {
+			delete(s.healer.trieTasks, hash)
+			req.hashes, req.paths = append(req.hashes, hash), append(req.paths, path)
+			if len(req.hashes) >= maxTrieRequestCount {
+				break
+			}
+		}
+		return req
+	}
+	if len(s.healer.codeTasks) > 0 {
+		req := &request{kind: GetByteCodesMsg, heal: true}
+		for hash := range s.healer.codeTasks {
+			delete(s.healer.codeTasks, hash)
+			if req.hashes = append(req.hashes, hash); len(req.hashes) >= maxCodeRequestCount {
+				break
+			}
+		}
+		return req
+	}
+	return nil
+}
+
+// fillHealTasks tops up the healing task queues from the trie scheduler.
+func (s *Syncer) fillHealTasks() {
+	have := len(s.healer.trieTasks) + len(s.healer.codeTasks)
+	want := maxTrieRequestCount + maxCodeRequestCount
+	if have >= want {
+		return
+	}
+	nodes, paths, codes := s.healer.scheduler.Missing(want - have)
+	for i, hash := range nodes {
+		s.healer.trieTasks[hash] = paths[i]
+	}
+	for _, hash := range codes {
+		s.healer.codeTasks[hash] = struct{}{}
+	}
+}
+
+// issue sends a request to a remote peer and starts tracking its timeout. The
+// caller must hold the lock.
+func (s *Syncer) issue(peer SyncPeer, req *request) {
+	// Generate a unique id for the request
+	id := rand.Uint64()
+	for {
+		if _, ok := s.requests[id]; !ok {
+			break
+		}
+		id = rand.Uint64()
+	}
+	req.id, req.peer, req.stale = id, peer.ID(), s.stale
+
+	req.timeout = time.AfterFunc(requestTimeout, func() {
+		s.lock.Lock()
+		if _, ok := s.requests[id]; !ok {
+			s.lock.Unlock()
+			return
+		}
+		delete(s.requests, id)
+		s.lock.Unlock()
+
+		peer.Log().Debug("Snap request timed out", "reqid", id, "kind", req.kind)
+		s.deliver(&response{req: req, failed: true})
+	})
+	s.requests[id] = req
+	s.busy[req.peer] = struct{}{}
+
+	// Send the request in the background to not block the sync loop
+	root := s.root
+	go func() {
+		var err error
+		switch req.kind {
+		case GetAccountRangeMsg:
+			err = peer.RequestAccountRange(id, root, req.origin, req.limit, maxRequestSize)
+
+		case GetStorageRangesMsg:
+			var origin []byte
+			if req.origin != (common.Hash{}) {
+				origin = req.origin[:]
+			}
+			err = peer.RequestStorageRanges(id, root, req.accounts, origin, nil, maxRequestSize)
+
+		case GetByteCodesMsg:
+			err = peer.RequestByteCodes(id, req.hashes, maxRequestSize)
+
+		case GetTrieNodesMsg:
+			paths := make([]TrieNodePathSet, len(req.paths))
+			for i, path := range req.paths {
+				paths[i] = TrieNodePathSet(path)
+			}
+			err = peer.RequestTrieNodes(id, root, paths, maxRequestSize)
+		}
+		if err != nil {
+			peer.Log().Debug("Failed to send snap request", "reqid", id, "kind", req.kind, "err", err)
+
+			s.lock.Lock()
+			_, ok := s.requests[id]
+			if ok {
+				req.timeout.Stop()
+				delete(s.requests, id)
+			}
+			s.lock.Unlock()
+
+			if ok {
+				s.deliver(&response{req: req, failed: true})
+			}
+		}
+	}()
+}
+
+// process handles a single response delivered to the sync loop.
+func (s *Syncer) process(res *response) {
+	s.lock.Lock()
+	delete(s.busy, res.req.peer)
+	s.lock.Unlock()
+
+	if res.failed {
+		s.revert(res.req)
+		return
+	}
+	var err error
+	switch res.req.kind {
+	case GetAccountRangeMsg:
+		err = s.processAccountResponse(res)
+	case GetStorageRangesMsg:
+		err = s.processStorageResponse(res)
+	case GetByteCodesMsg:
+		if res.req.heal {
+			err = s.processHealByteCodes(res)
+		} else {
+			err = s.processByteCodes(res)
+		}
+	case GetTrieNodesMsg:
+		err = s.processHealTrieNodes(res)
+	}
+	if err != nil {
+		// The peer either doesn't have the state or served invalid data, in
+		// both cases don't bother it again during this cycle
+		log.Debug("Rejected snap response", "peer", res.req.peer, "reqid", res.req.id, "err", err)
+		s.markStateless(res.req.peer)
+	}
+}
+
+// revert returns the items of a failed request back into the task queues.
+func (s *Syncer) revert(req *request) {
+	switch req.kind {
+	case GetAccountRangeMsg:
+		req.task.req = false
+
+	case GetStorageRangesMsg:
+		for _, account := range req.accounts {
+			delete(req.task.stateInFlight, account)
+		}
+	case GetByteCodesMsg:
+		for _, hash := range req.hashes {
+			if req.heal {
+				s.healer.codeTasks[hash] = struct{}{}
+			} else {
+				delete(req.task.codeInFlight, hash)
+			}
+		}
+	case GetTrieNodesMsg:
+		for i, hash := range req.hashes {
+			s.healer.trieTasks[hash] = req.paths[i]
+		}
+	}
+}
+
+// processAccountResponse verifies a range of accounts against its Merkle proof
+// and schedules the retrieval of the storage tries and bytecodes it references.
+func (s *Syncer) processAccountResponse(res *response) error {
+	req, task := res.req, res.req.task
+	task.req = false
+
+	// An empty response without proofs means the peer doesn't have the state
+	if len(res.hashes) == 0 && len(res.proof) == 0 {
+		return errors.New("state unavailable")
+	}
+	keys := make([][]byte, len(res.hashes))
+	for i, hash := range res.hashes {
+		keys[i] = common.CopyBytes(hash[:])
+	}
+	var end []byte
+	if len(keys) > 0 {
+		end = keys[len(keys)-1]
+	}
+	proofdb, bounds := proofSet(res.proof)
+	nodes, tr, _, cont, err := trie.VerifyRangeProof(s.root, req.origin[:], end, keys, res.accounts, proofdb)
+	if err != nil {
+		return err
+	}
+	hashes := res.hashes
+	accounts := make([]*state.Account, len(res.accounts))
+	for i, blob := range res.accounts {
+		accounts[i] = new(state.Account)
+		if err := rlp.DecodeBytes(blob, accounts[i]); err != nil {
+			return err
+		}
+	}
+	// Accounts beyond the task interval belong to another task, don't persist
+	// them (or any node on their path) and consider this task done
+	for i, hash := range hashes {
+		if bytes.Compare(hash[:], task.Last[:]) > 0 {
+			overflow := memorydb.New()
+			for _, hash := range hashes[i:] {
+				if err := tr.Prove(hash[:], 0, overflow); err != nil {
+					return err
+				}
+			}
+			it := overflow.NewIterator(nil, nil)
+			for it.Next() {
+				bounds[common.BytesToHash(it.Key())] = struct{}{}
+			}
+			it.Release()
+
+			hashes, accounts, cont = hashes[:i], accounts[:i], false
+			break
+		}
+	}
+	task.res = &accountChunk{
+		hashes:   hashes,
+		accounts: accounts,
+		trie:     tr,
+		nodes:    nodes,
+		skip:     bounds,
+		cont:     cont,
+	}
+	// Schedule the retrieval of any storage trie or bytecode not yet available
+	for i, account := range accounts {
+		if !bytes.Equal(account.CodeHash, emptyCode[:]) {
+			if hash := common.BytesToHash(account.CodeHash); len(rawdb.ReadCode(s.db, hash)) == 0 {
+				task.codeTasks[hash] = struct{}{}
+			}
+		}
+		if account.Root != emptyRoot && len(rawdb.ReadTrieNode(s.db, account.Root)) == 0 {
+			task.stateTasks[hashes[i]] = account.Root
+		}
+	}
+	s.forwardAccountTask(task)
+	return nil
+}
+
+// processStorageResponse verifies the ranges of storage slots of a batch of
+// accounts and persists the resulting trie nodes.
+func (s *Syncer) processStorageResponse(res *response) error {
+	req, task := res.req, res.req.task
+	for _, account := range req.accounts {
+		delete(task.stateInFlight, account)
+	}
+	// An empty response means the peer doesn't have the state
+	if len(res.slotHashes) == 0 {
+		return errors.New("state unavailable")
+	}
+	if len(res.slotHashes) > len(req.accounts) {
+		return fmt.Errorf("too many storage ranges: have %d, requested %d", len(res.slotHashes), len(req.accounts))
+	}
+	for i, hashes := range res.slotHashes {
+		keys := make([][]byte, len(hashes))
+		for j, hash := range hashes {
+			keys[j] = common.CopyBytes(hash[:])
+		}
+		var (
+			nodes  ctxcdb.KeyValueStore
+			bounds map[common.Hash]struct{}
+			cont   bool
+			err    error
+		)
+		if i == len(res.slotHashes)-1 && len(res.proof) > 0 {
+			// The last range is capped or continued, verify it with the proof
+			var origin common.Hash
+			if i == 0 {
+				origin = req.origin
+			}
+			var end []byte
+			if len(keys) > 0 {
+				end = keys[len(keys)-1]
+			}
+			var proofdb ctxcdb.KeyValueReader
+			proofdb, bounds = proofSet(res.proof)
+			nodes, _, _, cont, err = trie.VerifyRangeProof(req.roots[i], origin[:], end, keys, res.slots[i], proofdb)
+		} else {
+			// The range is expected to cover the entire storage trie
+			nodes, _, _, cont, err = trie.VerifyRangeProof(req.roots[i], nil, nil, keys, res.slots[i], nil)
+		}
+		if err != nil {
+			return err
+		}
+		s.storageBytes += s.persist(nodes, bounds)
+		s.storageSynced += uint64(len(keys))
+
+		// The boundary nodes of split storage tries are left for healing, but
+		// it can only reach them if the account's path isn't persisted either
+		account := req.accounts[i]
+		if len(bounds) > 0 {
+			task.needHeal[account] = struct{}{}
+		}
+		if cont {
+			task.stateNext[account] = incHash(hashes[len(hashes)-1])
+		} else {
+			delete(task.stateTasks, account)
+			delete(task.stateNext, account)
+		}
+	}
+	s.forwardAccountTask(task)
+	return nil
+}
+
+// processByteCodes stores the contract codes referenced by pending account
+// ranges.
+func (s *Syncer) processByteCodes(res *response) error {
+	req, task := res.req, res.req.task
+	for _, hash := range req.hashes {
+		delete(task.codeInFlight, hash)
+	}
+	codes, err := matchBlobs(req.hashes, res.blobs)
+	if err != nil {
+		return err
+	}
+	batch := s.db.NewBatch()
+	for hash, code := range codes {
+		rawdb.WriteCode(batch, hash, code)
+		if s.bloom != nil {
+			s.bloom.Add(hash[:])
+		}
+		delete(task.codeTasks, hash)
+
+		s.bytecodeSynced++
+		s.bytecodeBytes += common.StorageSize(len(code))
+	}
+	if err := batch.Write(); err != nil {
+		log.Crit("Failed to persist bytecodes", "err", err)
+	}
+	s.forwardAccountTask(task)
+	return nil
+}
+
+// processHealTrieNodes feeds the trie nodes retrieved during healing into the
+// trie scheduler and persists any completed subtries.
+func (s *Syncer) processHealTrieNodes(res *response) error {
+	req := res.req
+
+	nodes, err := matchBlobs(req.hashes, res.blobs)
+	if err != nil {
+		s.revert(req)
+		return err
+	}
+	for i, hash := range req.hashes {
+		node, ok := nodes[hash]
+		if !ok {
+			// Missing from the response, schedule for retrieval from elsewhere
+			s.healer.trieTasks[hash] = req.paths[i]
+			continue
+		}
+		if err := s.healer.scheduler.Process(trie.SyncResult{Hash: hash, Data: node}); err != nil {
+			log.Error("Invalid trienode processed", "hash", hash, "err", err)
+		}
+		s.trienodeHealSynced++
+	}
+	s.commitHealer()
+
+	if len(nodes) == 0 {
+		return errors.New("state unavailable")
+	}
+	return nil
+}
+
+// processHealByteCodes feeds the bytecodes retrieved during healing into the
+// trie scheduler and persists them.
+func (s *Syncer) processHealByteCodes(res *response) error {
+	req := res.req
+
+	codes, err := matchBlobs(req.hashes, res.blobs)
+	if err != nil {
+		s.revert(req)
+		return err
+	}
+	for _, hash := range req.hashes {
+		code, ok := codes[hash]
+		if !ok {
+			// Missing from the response, schedule for retrieval from elsewhere
+			s.healer.codeTasks[hash] = struct{}{}
+			continue
+		}
+		if err := s.healer.scheduler.Process(trie.SyncResult{Hash: hash, Data: code}); err != nil {
+			log.Error("Invalid bytecode processed", "hash", hash, "err", err)
+		}
+		s.bytecodeHealSynced++
+	}
+	s.commitHealer()
+
+	if len(codes) == 0 {
+		return errors.New("state unavailable")
+	}
+	return nil
+}
+
+// commitHealer flushes the completed healing data into the database.
+func (s *Syncer) commitHealer() {
+	batch := s.db.NewBatch()
+	if err := s.healer.scheduler.Commit(batch); err != nil {
+		log.Error("Failed to commit healing data", "err", err)
+	}
+	if err := batch.Write(); err != nil {
+		log.Crit("Failed to persist healing data", "err", err)
+	}
+}
+
+// forwardAccountTask persists the pending account range of a task once all the
+// storage tries and bytecodes it references are available, moving the task on
+// to the next range.
+func (s *Syncer) forwardAccountTask(task *accountTask) {
+	res := task.res
+	if res == nil || len(task.stateTasks) > 0 || len(task.codeTasks) > 0 {
+		return
+	}
+	if len(task.needHeal) > 0 {
+		paths := memorydb.New()
+		for account := range task.needHeal {
+			if err := res.trie.Prove(account[:], 0, paths); err != nil {
+				log.Error("Failed to mark account for healing", "account", account, "err", err)
+			}
+		}
+		it := paths.NewIterator(nil, nil)
+		for it.Next() {
+			res.skip[common.BytesToHash(it.Key())] = struct{}{}
+		}
+		it.Release()
+	}
+	s.accountBytes += s.persist(res.nodes, res.skip)
+	s.accountSynced += uint64(len(res.hashes))
+
+	task.res = nil
+	task.needHeal = make(map[common.Hash]struct{})
+
+	if !res.cont || len(res.hashes) == 0 || res.hashes[len(res.hashes)-1] == task.Last {
+		task.done = true
+		return
+	}
+	task.Next = incHash(res.hashes[len(res.hashes)-1])
+}
+
+// persist writes the trie nodes reconstructed from a range proof into the
+// database, except the ones on the boundary of the range. Those reference data
+// outside of the range and will be retrieved during healing.
+func (s *Syncer) persist(nodes ctxcdb.KeyValueStore, skip map[common.Hash]struct{}) common.StorageSize {
+	var (
+		batch = s.db.NewBatch()
+		size  common.StorageSize
+	)
+	it := nodes.NewIterator(nil, nil)
+	for it.Next() {
+		if len(it.Key()) != common.HashLength {
+			continue
+		}
+		hash := common.BytesToHash(it.Key())
+		if _, ok := skip[hash]; ok {
+			continue
+		}
+		rawdb.WriteTrieNode(batch, hash, it.Value())
+		if s.bloom != nil {
+			s.bloom.Add(hash[:])
+		}
+		size += common.StorageSize(common.HashLength + len(it.Value()))
+	}
+	it.Release()
+
+	if err := batch.Write(); err != nil {
+		log.Crit("Failed to persist trie nodes", "err", err)
+	}
+	return size
+}
+
+// reportSyncProgress logs the progress of the range retrieval phase.
+func (s *Syncer) reportSyncProgress(force bool) {
+	if !force && time.Since(s.logTime) < progressInterval {
+		return
+	}
+	s.logTime = time.Now()
+
+	var done int
+	for _, task := range s.tasks {
+		if task.done {
+			done++
+		}
+	}
+	log.Info("State sync in progress", "tasks", fmt.Sprintf("%d/%d", done, len(s.tasks)),
+		"accounts", s.accountSynced, "slots", s.storageSynced, "codes", s.bytecodeSynced,
+		"size", s.accountBytes+s.storageBytes+s.bytecodeBytes, "elapsed", common.PrettyDuration(time.Since(s.startTime)))
+}
+
+// reportHealProgress logs the progress of the healing phase.
+func (s *Syncer) reportHealProgress(force bool) {
+	if !force && time.Since(s.logTime) < progressInterval {
+		return
+	}
+	s.logTime = time.Now()
+
+	log.Info("State heal in progress", "nodes", s.trienodeHealSynced, "codes", s.bytecodeHealSynced,
+		"pending", s.healer.scheduler.Pending(), "elapsed", common.PrettyDuration(time.Since(s.startTime)))
+}
+
+// newAccountTasks splits the account hash space into equal intervals to be
+// retrieved concurrently.
+func newAccountTasks() []*accountTask {
+	var (
+		next  common.Hash
+		step  = new(big.Int).Div(new(big.Int).Exp(common.Big2, common.Big256, nil), big.NewInt(accountConcurrency))
+		tasks = make([]*accountTask, 0, accountConcurrency)
+	)
+	for i := 0; i < accountConcurrency; i++ {
+		last := common.BigToHash(new(big.Int).Sub(new(big.Int).Add(next.Big(), step), common.Big1))
+		if i == accountConcurrency-1 {
+			last = maxHash
+		}
+		task := &accountTask{Next: next, Last: last}
+		task.reset()
+		tasks = append(tasks, task)
+
+		next = incHash(last)
+	}
+	return tasks
+}
+
+// proofSet converts a list of proof nodes into a database usable for range
+// verification, also returning the hashes of the nodes. A nil database is
+// returned if there are no proof nodes at all.
+func proofSet(proof [][]byte) (ctxcdb.KeyValueReader, map[common.Hash]struct{}) {
+	bounds := make(map[common.Hash]struct{})
+	if len(proof) == 0 {
+		return nil, bounds
+	}
+	db := memorydb.New()
+	for _, node := range proof {
+		hash := crypto.Keccak256(node)
+		db.Put(hash, node)
+		bounds[common.BytesToHash(hash)] = struct{}{}
+	}
+	return db, bounds
+}
+
+// matchBlobs cross references a list of requested hashes with the blobs of a
+// response. Blobs must be delivered in request order, but the remote peer may
+// omit the ones it doesn't have.
+func matchBlobs(hashes []common.Hash, blobs [][]byte) (map[common.Hash][]byte, error) {
+	var (
+		found = make(map[common.Hash][]byte)
+		next  int
+	)
+	for _, blob := range blobs {
+		hash := crypto.Keccak256Hash(blob)
+		for next < len(hashes) && hashes[next] != hash {
+			next++
+		}
+		if next == len(hashes) {
+			return nil, fmt.Errorf("unexpected blob %x", hash)
+		}
+		found[hash] = blob
+		next++
+	}
+	return found, nil
+}
+
+// incHash returns the next hash, in lexicographical order (a.k.a plus one).
+func incHash(h common.Hash) common.Hash {
+	for i := len(h) - 1; i >= 0; i-- {
+		h[i]++
+		if h[i] != 0 {
+			break
+		}
+	}
+	return h
+}
@@ -0,0 +1,210 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package snap
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/consensus/cuckoo"
+	"github.com/CortexFoundation/CortexTheseus/core"
+	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
+	"github.com/CortexFoundation/CortexTheseus/core/state"
+	"github.com/CortexFoundation/CortexTheseus/core/vm"
+	"github.com/CortexFoundation/CortexTheseus/ctxcdb"
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/params"
+	"github.com/CortexFoundation/CortexTheseus/trie"
+)
+
+// testPeer is a snap sync peer serving the state of a local chain, capping
+// every response at a fixed size to force ranges to be split and proven.
+type testPeer struct {
+	id     string
+	chain  *core.BlockChain
+	syncer *Syncer
+	limit  uint64
+	logger log.Logger
+}
+
+func newTestPeer(id string, chain *core.BlockChain, syncer *Syncer, limit uint64) *testPeer {
+	return &testPeer{id: id, chain: chain, syncer: syncer, limit: limit, logger: log.New("id", id)}
+}
+
+func (p *testPeer) ID() string      { return p.id }
+func (p *testPeer) Log() log.Logger { return p.logger }
+
+func (p *testPeer) RequestAccountRange(id uint64, root, origin, limit common.Hash, bytes uint64) error {
+	go func() {
+		res := serviceGetAccountRangeQuery(p.chain, &GetAccountRangePacket{ID: id, Root: root, Origin: origin, Limit: limit, Bytes: p.limit})
+		hashes, accounts, err := res.Unpack()
+		if err != nil {
+			panic(err)
+		}
+		p.syncer.OnAccounts(p, id, hashes, accounts, res.Proof)
+	}()
+	return nil
+}
+
+func (p *testPeer) RequestStorageRanges(id uint64, root common.Hash, accounts []common.Hash, origin, limit []byte, bytes uint64) error {
+	go func() {
+		res := serviceGetStorageRangesQuery(p.chain, &GetStorageRangesPacket{ID: id, Root: root, Accounts: accounts, Origin: origin, Limit: limit, Bytes: p.limit})
+		hashes, slots := res.Unpack()
+		p.syncer.OnStorage(p, id, hashes, slots, res.Proof)
+	}()
+	return nil
+}
+
+func (p *testPeer) RequestByteCodes(id uint64, hashes []common.Hash, bytes uint64) error {
+	go func() {
+		res := serviceGetByteCodesQuery(p.chain, &GetByteCodesPacket{ID: id, Hashes: hashes, Bytes: bytes})
+		p.syncer.OnByteCodes(p, id, res.Codes)
+	}()
+	return nil
+}
+
+func (p *testPeer) RequestTrieNodes(id uint64, root common.Hash, paths []TrieNodePathSet, bytes uint64) error {
+	go func() {
+		res, err := serviceGetTrieNodesQuery(p.chain, &GetTrieNodesPacket{ID: id, Root: root, Paths: paths, Bytes: bytes})
+		if err != nil {
+			panic(err)
+		}
+		p.syncer.OnTrieNodes(p, id, res.Nodes)
+	}()
+	return nil
+}
+
+// newTestChain creates a chain whose genesis state contains plain accounts,
+// contracts with small storage tries and a contract with a large storage trie.
+func newTestChain(t *testing.T) *core.BlockChain {
+	alloc := make(core.GenesisAlloc)
+	for i := 0; i < 500; i++ {
+		alloc[common.BigToAddress(big.NewInt(int64(i+1)))] = core.GenesisAccount{Balance: big.NewInt(int64(i + 1))}
+	}
+	for i := 0; i < 20; i++ {
+		storage := make(map[common.Hash]common.Hash)
+		for j := 0; j < 10; j++ {
+			storage[common.BigToHash(big.NewInt(int64(j+1)))] = common.BigToHash(big.NewInt(int64(i*100 + j + 1)))
+		}
+		alloc[common.BigToAddress(big.NewInt(int64(1000+i)))] = core.GenesisAccount{
+			Balance: big.NewInt(1),
+			Code:    []byte{0x60, byte(i), 0x00},
+			Storage: storage,
+		}
+	}
+	storage := make(map[common.Hash]common.Hash)
+	for j := 0; j < 1000; j++ {
+		storage[common.BigToHash(big.NewInt(int64(j+1)))] = common.BigToHash(big.NewInt(int64(j + 1)))
+	}
+	alloc[common.BigToAddress(big.NewInt(2000))] = core.GenesisAccount{
+		Balance: big.NewInt(1),
+		Code:    []byte{0x60, 0xff, 0x00},
+		Storage: storage,
+	}
+	return newTestChainWithAlloc(t, alloc)
+}
+
+// newTestChainWithAlloc creates a chain with the given genesis allocation.
+func newTestChainWithAlloc(t *testing.T, alloc core.GenesisAlloc) *core.BlockChain {
+	var (
+		db    = rawdb.NewMemoryDatabase()
+		gspec = &core.Genesis{Config: params.TestChainConfig, Alloc: alloc, Supply: params.CTXC_INIT}
+	)
+	gspec.MustCommit(db)
+	chain, err := core.NewBlockChain(db, nil, gspec.Config, cuckoo.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	return chain
+}
+
+// checkStateComplete ensures the entire state trie of the given root, including
+// the storage tries and contract codes, is available in the database.
+func checkStateComplete(t *testing.T, db ctxcdb.Database, root common.Hash) {
+	statedb, err := state.New(root, state.NewDatabase(db), nil)
+	if err != nil {
+		t.Fatalf("failed to open synced state: %v", err)
+	}
+	it := state.NewNodeIterator(statedb)
+	for it.Next() {
+	}
+	if it.Error != nil {
+		t.Fatalf("synced state incomplete: %v", it.Error)
+	}
+}
+
+// runSync syncs the given root with the registered peers, failing the test if
+// it doesn't complete in time.
+func runSync(t *testing.T, syncer *Syncer, root common.Hash) {
+	var (
+		cancel = make(chan struct{})
+		done   = make(chan error, 1)
+	)
+	go func() { done <- syncer.Sync(root, cancel) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("sync failed: %v", err)
+		}
+	case <-time.After(time.Minute):
+		close(cancel)
+		t.Fatalf("sync timed out")
+	}
+}
+
+// Tests that the state can be retrieved from a peer serving small chunks, with
+// the ranges split across multiple responses and the gaps along the chunk
+// boundaries filled by healing.
+func TestSync(t *testing.T) {
+	chain := newTestChain(t)
+	defer chain.Stop()
+
+	var (
+		db     = rawdb.NewMemoryDatabase()
+		syncer = NewSyncer(db, trie.NewSyncBloom(1, db))
+		root   = chain.CurrentBlock().Root()
+	)
+	syncer.Register(newTestPeer("source", chain, syncer, 4096))
+	runSync(t, syncer, root)
+
+	checkStateComplete(t, db, root)
+}
+
+// Tests that peers not having the requested state are ignored and the sync
+// completes with the peers that do.
+func TestSyncWithStatelessPeer(t *testing.T) {
+	var (
+		source = newTestChain(t)
+		empty  = newTestChainWithAlloc(t, core.GenesisAlloc{common.HexToAddress("0x01"): {Balance: big.NewInt(1)}})
+	)
+	defer source.Stop()
+	defer empty.Stop()
+
+	var (
+		db     = rawdb.NewMemoryDatabase()
+		syncer = NewSyncer(db, trie.NewSyncBloom(1, db))
+		root   = source.CurrentBlock().Root()
+	)
+	syncer.Register(newTestPeer("stateless", empty, syncer, 4096))
+	syncer.Register(newTestPeer("source", source, syncer, 4096))
+	runSync(t, syncer, root)
+
+	checkStateComplete(t, db, root)
+}
@@ -0,0 +1,517 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulation
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/p2p/enode"
+	"github.com/CortexFoundation/CortexTheseus/p2p/simulations"
+	"github.com/CortexFoundation/CortexTheseus/rlp"
+	"github.com/CortexFoundation/CortexTheseus/rpc"
+)
+
+const (
+	// pollInterval is the interval at which node state is polled while
+	// waiting for a condition across the network.
+	pollInterval = 100 * time.Millisecond
+
+	// stallTimeout is the time after which settling nodes which stopped
+	// switching heads are nudged with another block.
+	stallTimeout = 5 * time.Second
+)
+
+var errNoNodes = errors.New("no running Cortex nodes in the network")
+
+// cluster drives the running Cortex nodes of a simulation network through
+// their RPC APIs, so scenarios work with every node adapter.
+type cluster struct {
+	net     *simulations.Network
+	nodes   []*simulations.Node
+	clients []*rpc.Client
+	index   map[enode.ID]int
+
+	groups [][]int       // Node indexes of each partition, one group when healed
+	cut    [][2]enode.ID // Connections dropped to partition the network
+}
+
+// newCluster collects the running Cortex nodes of the network.
+func newCluster(net *simulations.Network) (*cluster, error) {
+	c := &cluster{net: net, index: make(map[enode.ID]int)}
+	for _, node := range net.GetNodes() {
+		if !node.Up() || !runsCortex(net, node) {
+			continue
+		}
+		client, err := node.Client()
+		if err != nil {
+			return nil, fmt.Errorf("node %s: %v", node.ID().TerminalString(), err)
+		}
+		c.index[node.ID()] = len(c.nodes)
+		c.nodes = append(c.nodes, node)
+		c.clients = append(c.clients, client)
+	}
+	if len(c.nodes) == 0 {
+		return nil, errNoNodes
+	}
+	all := make([]int, len(c.nodes))
+	for i := range all {
+		all[i] = i
+	}
+	c.groups = [][]int{all}
+	return c, nil
+}
+
+// runsCortex reports whether the Cortex service is configured on a node.
+func runsCortex(net *simulations.Network, node *simulations.Node) bool {
+	services := node.Config.Services
+	if len(services) == 0 {
+		return net.Config().DefaultService == ServiceName
+	}
+	for _, service := range services {
+		if service == ServiceName {
+			return true
+		}
+	}
+	return false
+}
+
+// conns returns the active connections between the nodes of the cluster.
+func (c *cluster) conns() [][2]enode.ID {
+	var conns [][2]enode.ID
+	for i := range c.nodes {
+		for j := i + 1; j < len(c.nodes); j++ {
+			one, other := c.nodes[i].ID(), c.nodes[j].ID()
+			if conn := c.net.GetConn(one, other); conn != nil && conn.Up {
+				conns = append(conns, [2]enode.ID{one, other})
+			}
+		}
+	}
+	return conns
+}
+
+// waitPeers waits until every node has completed the Cortex protocol
+// handshake with all of its connected peers.
+func (c *cluster) waitPeers(ctx context.Context) error {
+	want := make([]int, len(c.nodes))
+	for _, conn := range c.conns() {
+		want[c.index[conn[0]]]++
+		want[c.index[conn[1]]]++
+	}
+	for i, client := range c.clients {
+		for {
+			var peers []struct {
+				Protocols map[string]interface{} `json:"protocols"`
+			}
+			if err := client.CallContext(ctx, &peers, "admin_peers"); err != nil {
+				return err
+			}
+			ready := 0
+			for _, peer := range peers {
+				if _, ok := peer.Protocols[ServiceName].(map[string]interface{}); ok {
+					ready++
+				}
+			}
+			if ready >= want[i] {
+				break
+			}
+			if err := sleep(ctx, pollInterval); err != nil {
+				return fmt.Errorf("node %s has %d of %d peers: %v", c.nodes[i].ID().TerminalString(), ready, want[i], err)
+			}
+		}
+	}
+	return nil
+}
+
+// partition splits the nodes into k groups of consecutive nodes and drops all
+// connections between the groups.
+func (c *cluster) partition(ctx context.Context, k int) error {
+	if k > len(c.nodes) {
+		k = len(c.nodes)
+	}
+	if k <= 1 {
+		return nil
+	}
+	group := make(map[enode.ID]int)
+	c.groups = make([][]int, k)
+	for i, node := range c.nodes {
+		g := i * k / len(c.nodes)
+		group[node.ID()] = g
+		c.groups[g] = append(c.groups[g], i)
+	}
+	for _, conn := range c.conns() {
+		if group[conn[0]] == group[conn[1]] {
+			continue
+		}
+		if err := c.link(ctx, conn, "admin_removePeer"); err != nil {
+			return err
+		}
+		c.cut = append(c.cut, conn)
+	}
+	log.Info("Partitioned simulation network", "groups", k, "dropped", len(c.cut))
+	return nil
+}
+
+// heal restores the connections dropped by partition.
+func (c *cluster) heal(ctx context.Context) error {
+	for _, conn := range c.cut {
+		if err := c.link(ctx, conn, "admin_addPeer"); err != nil {
+			return err
+		}
+	}
+	log.Info("Healed simulation network", "restored", len(c.cut))
+
+	all := make([]int, len(c.nodes))
+	for i := range all {
+		all[i] = i
+	}
+	c.groups, c.cut = [][]int{all}, nil
+	return nil
+}
+
+// link adds or removes a connection on both of its ends. The node which did
+// not dial the connection last has no dial history for its peer and will
+// reconnect immediately, while simulations.Network always redials from the
+// same end.
+func (c *cluster) link(ctx context.Context, conn [2]enode.ID, method string) error {
+	for _, ends := range [][2]enode.ID{{conn[0], conn[1]}, {conn[1], conn[0]}} {
+		self, peer := c.nodes[c.index[ends[0]]], c.nodes[c.index[ends[1]]]
+		if err := c.clients[c.index[ends[0]]].CallContext(ctx, nil, method, string(peer.Addr())); err != nil {
+			return fmt.Errorf("node %s: %v", self.ID().TerminalString(), err)
+		}
+	}
+	return nil
+}
+
+// waitLinks waits until the network reports all the given connections up.
+func (c *cluster) waitLinks(ctx context.Context, conns [][2]enode.ID) error {
+	for _, conn := range conns {
+		for {
+			if conn := c.net.GetConn(conn[0], conn[1]); conn != nil && conn.Up {
+				break
+			}
+			if err := sleep(ctx, pollInterval); err != nil {
+				return fmt.Errorf("connection %s-%s not restored: %v", conn[0].TerminalString(), conn[1].TerminalString(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// mine makes a node seal n blocks on top of its head. Blocks are produced one
+// at a time and no faster than one per second, as blocks with timestamps in
+// the future are rejected by the other nodes.
+func (c *cluster) mine(ctx context.Context, node, n int) ([]*types.Header, error) {
+	client := c.clients[node]
+	heads := make(chan *types.Header, 16)
+	sub, err := client.Subscribe(ctx, "ctxc", heads, "newHeads")
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsubscribe()
+
+	var mined []*types.Header
+	for len(mined) < n {
+		head, err := c.head(ctx, node)
+		if err != nil {
+			return mined, err
+		}
+		if wait := time.Until(time.Unix(int64(head.Time)+1, 0)); wait > 0 {
+			if err := sleep(ctx, wait); err != nil {
+				return mined, err
+			}
+		}
+		if err := client.CallContext(ctx, nil, "miner_start", nil); err != nil {
+			return mined, err
+		}
+		var header *types.Header
+		for header == nil {
+			select {
+			case h := <-heads:
+				if h.Coinbase == c.coinbase(node) && h.Number.Cmp(head.Number) > 0 {
+					header = h
+				}
+			case err := <-sub.Err():
+				return mined, err
+			case <-ctx.Done():
+				return mined, ctx.Err()
+			}
+		}
+		if err := client.CallContext(ctx, nil, "miner_stop"); err != nil {
+			return mined, err
+		}
+		mined = append(mined, header)
+	}
+	return mined, nil
+}
+
+// coinbase returns the address blocks sealed by a node are credited to.
+func (c *cluster) coinbase(node int) common.Address {
+	return crypto.PubkeyToAddress(c.nodes[node].Config.PrivateKey.PublicKey)
+}
+
+// head returns the current head header of a node.
+func (c *cluster) head(ctx context.Context, node int) (*types.Header, error) {
+	head, _, err := c.headTd(ctx, node)
+	return head, err
+}
+
+// headTd returns the current head header of a node and its total difficulty.
+func (c *cluster) headTd(ctx context.Context, node int) (*types.Header, *big.Int, error) {
+	var raw json.RawMessage
+	if err := c.clients[node].CallContext(ctx, &raw, "ctxc_getBlockByNumber", "latest", false); err != nil {
+		return nil, nil, err
+	}
+	var (
+		head *types.Header
+		td   struct {
+			TotalDifficulty *hexutil.Big `json:"totalDifficulty"`
+		}
+	)
+	if err := json.Unmarshal(raw, &head); err != nil {
+		return nil, nil, err
+	}
+	if err := json.Unmarshal(raw, &td); err != nil {
+		return nil, nil, err
+	}
+	if head == nil || td.TotalDifficulty == nil {
+		return nil, nil, errors.New("missing head block")
+	}
+	return head, td.TotalDifficulty.ToInt(), nil
+}
+
+// converge waits until all nodes share the same head block.
+func (c *cluster) converge(ctx context.Context) (*types.Header, error) {
+	for {
+		heads := make(map[common.Hash]int)
+		var head *types.Header
+		for i := range c.nodes {
+			var err error
+			if head, err = c.head(ctx, i); err != nil {
+				return nil, err
+			}
+			heads[head.Hash()]++
+		}
+		if len(heads) == 1 {
+			return head, nil
+		}
+		if err := sleep(ctx, pollInterval); err != nil {
+			return nil, fmt.Errorf("nodes did not converge on %d heads: %v", len(heads), err)
+		}
+	}
+}
+
+// settle waits until all nodes share the same head block, mining on the given
+// node whenever the other chains are not clearly lighter or stop changing.
+// Peers only learn the total difficulty of a chain from the parent of fully
+// propagated blocks and never sync towards chains which are not heavier than
+// their own, so competing chains left behind by a partition would otherwise
+// persist.
+func (c *cluster) settle(ctx context.Context, node int) (*types.Header, error) {
+	var (
+		last     map[common.Hash]bool
+		progress = time.Now()
+	)
+	for {
+		head, td, err := c.headTd(ctx, node)
+		if err != nil {
+			return nil, err
+		}
+		var (
+			parentTd = new(big.Int).Sub(td, head.Difficulty)
+			heads    = map[common.Hash]bool{head.Hash(): true}
+			outdone  = false
+		)
+		for i := range c.nodes {
+			other, otherTd, err := c.headTd(ctx, i)
+			if err != nil {
+				return nil, err
+			}
+			if other.Hash() != head.Hash() && otherTd.Cmp(parentTd) >= 0 {
+				outdone = true
+			}
+			heads[other.Hash()] = true
+		}
+		if len(heads) == 1 {
+			return head, nil
+		}
+		if !sameHeads(heads, last) {
+			last, progress = heads, time.Now()
+		}
+		if outdone || time.Since(progress) > stallTimeout {
+			if _, err := c.mine(ctx, node, 1); err != nil {
+				return nil, err
+			}
+			progress = time.Now()
+			continue
+		}
+		if err := sleep(ctx, pollInterval); err != nil {
+			return nil, fmt.Errorf("nodes did not settle on %d heads: %v", len(heads), err)
+		}
+	}
+}
+
+func sameHeads(a, b map[common.Hash]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for hash := range a {
+		if !b[hash] {
+			return false
+		}
+	}
+	return true
+}
+
+// sendTx signs a transaction with the given key and submits it to a node.
+// A nil recipient creates a contract.
+func (c *cluster) sendTx(ctx context.Context, node int, key *ecdsa.PrivateKey, to *common.Address, gas uint64, data []byte) (*types.Transaction, error) {
+	client := c.clients[node]
+	var (
+		nonce    hexutil.Uint64
+		gasPrice hexutil.Big
+		from     = crypto.PubkeyToAddress(key.PublicKey)
+	)
+	if err := client.CallContext(ctx, &nonce, "ctxc_getTransactionCount", from, "pending"); err != nil {
+		return nil, err
+	}
+	if err := client.CallContext(ctx, &gasPrice, "ctxc_gasPrice"); err != nil {
+		return nil, err
+	}
+	var tx *types.Transaction
+	if to == nil {
+		tx = types.NewContractCreation(uint64(nonce), new(big.Int), gas, gasPrice.ToInt(), data)
+	} else {
+		tx = types.NewTransaction(uint64(nonce), *to, new(big.Int), gas, gasPrice.ToInt(), data)
+	}
+	signed, err := types.SignTx(tx, types.NewEIP155Signer(Genesis().Config.ChainID), key)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := rlp.EncodeToBytes(signed)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.CallContext(ctx, nil, "ctxc_sendRawTransaction", hexutil.Bytes(blob)); err != nil {
+		return nil, err
+	}
+	return signed, nil
+}
+
+// sleep waits for the given duration or until the context is cancelled.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// headTracker records when blocks join the canonical chain of every node.
+type headTracker struct {
+	headers  map[common.Hash]*types.Header
+	arrivals map[common.Hash]map[int]time.Time
+	subs     []*rpc.ClientSubscription
+	lock     sync.Mutex
+}
+
+// trackHeads subscribes to the head events of all nodes.
+func (c *cluster) trackHeads(ctx context.Context) (*headTracker, error) {
+	t := &headTracker{
+		headers:  make(map[common.Hash]*types.Header),
+		arrivals: make(map[common.Hash]map[int]time.Time),
+	}
+	for i, client := range c.clients {
+		heads := make(chan *types.Header, 64)
+		sub, err := client.Subscribe(ctx, "ctxc", heads, "newHeads")
+		if err != nil {
+			t.stop()
+			return nil, err
+		}
+		t.subs = append(t.subs, sub)
+		go t.loop(i, heads, sub)
+	}
+	return t, nil
+}
+
+func (t *headTracker) loop(node int, heads chan *types.Header, sub *rpc.ClientSubscription) {
+	for {
+		select {
+		case head := <-heads:
+			t.arrive(node, head, time.Now())
+		case <-sub.Err():
+			return
+		}
+	}
+}
+
+// arrive records a new head of a node. Ancestors which the node imported in
+// the same batch are considered to have arrived at the same time.
+func (t *headTracker) arrive(node int, head *types.Header, now time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	hash := head.Hash()
+	t.headers[hash] = head
+	for {
+		seen := t.arrivals[hash]
+		if seen == nil {
+			seen = make(map[int]time.Time)
+			t.arrivals[hash] = seen
+		}
+		if _, ok := seen[node]; ok {
+			return
+		}
+		seen[node] = now
+
+		parent := t.headers[t.headers[hash].ParentHash]
+		if parent == nil {
+			return
+		}
+		hash = parent.Hash()
+	}
+}
+
+// arrival returns the time a block joined the chain of a node.
+func (t *headTracker) arrival(hash common.Hash, node int) (time.Time, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	at, ok := t.arrivals[hash][node]
+	return at, ok
+}
+
+func (t *headTracker) stop() {
+	for _, sub := range t.subs {
+		sub.Unsubscribe()
+	}
+}
@@ -0,0 +1,694 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulation
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/p2p/simulations"
+	"github.com/CortexFoundation/CortexTheseus/params"
+	"github.com/CortexFoundation/CortexTheseus/rlp"
+	torrentfs "github.com/CortexFoundation/torrentfs/types"
+)
+
+// Names of the scenarios registered with the simulation API.
+const (
+	PropagationScenario = "ctxc-block-propagation"
+	UploadScenario      = "ctxc-model-upload"
+	InferenceScenario   = "ctxc-inference-agreement"
+)
+
+const (
+	modelSize     = 4096             // Raw size of the simulated model, uploaded in a single transaction
+	inferGas      = 2000000          // Gas allowance of inference transactions
+	deployGas     = 500000           // Gas allowance of contract and model creations
+	maxMineBlocks = 64               // Blocks mined at most while waiting for a transaction
+	settleTimeout = 10 * time.Second // Time allowed for blocks to reach all nodes of a partition
+)
+
+// Config holds the parameters of the Cortex scenarios, decoded from the JSON
+// body of the scenario request. Zero values select the defaults.
+type Config struct {
+	Blocks     int `json:"blocks"`     // Blocks mined by every partition
+	Partitions int `json:"partitions"` // Groups the network is split into, 1 for none
+	Inferences int `json:"inferences"` // Inference transactions sent by every partition
+	Timeout    int `json:"timeout"`    // Time limit of the scenario in seconds
+}
+
+// DefaultConfig contains the default scenario parameters.
+var DefaultConfig = Config{
+	Blocks:     5,
+	Partitions: 1,
+	Inferences: 4,
+	Timeout:    300,
+}
+
+func parseConfig(params json.RawMessage) (Config, error) {
+	config := DefaultConfig
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &config); err != nil {
+			return config, fmt.Errorf("invalid scenario parameters: %v", err)
+		}
+	}
+	if config.Blocks <= 0 {
+		config.Blocks = DefaultConfig.Blocks
+	}
+	if config.Partitions <= 0 {
+		config.Partitions = DefaultConfig.Partitions
+	}
+	if config.Inferences <= 0 {
+		config.Inferences = DefaultConfig.Inferences
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultConfig.Timeout
+	}
+	return config, nil
+}
+
+// Stats summarises a set of durations. Durations are encoded in nanoseconds.
+type Stats struct {
+	Samples int           `json:"samples"`
+	Min     time.Duration `json:"min"`
+	Mean    time.Duration `json:"mean"`
+	Max     time.Duration `json:"max"`
+	P95     time.Duration `json:"p95"`
+}
+
+func newStats(samples []time.Duration) Stats {
+	if len(samples) == 0 {
+		return Stats{}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+
+	var sum time.Duration
+	for _, d := range samples {
+		sum += d
+	}
+	return Stats{
+		Samples: len(samples),
+		Min:     samples[0],
+		Mean:    sum / time.Duration(len(samples)),
+		Max:     samples[len(samples)-1],
+		P95:     samples[(len(samples)*95-1)/100],
+	}
+}
+
+// PropagationReport is the result of the block propagation scenario.
+type PropagationReport struct {
+	Nodes       int           `json:"nodes"`
+	Partitions  int           `json:"partitions"`
+	Blocks      int           `json:"blocks"`      // Blocks mined across all partitions
+	Coverage    float64       `json:"coverage"`    // Fraction of nodes reached by blocks of their partition
+	Delay       Stats         `json:"delay"`       // Time from sealing until blocks join the chain of a node
+	Convergence time.Duration `json:"convergence"` // Time from healing until all nodes share a head
+	Head        common.Hash   `json:"head"`
+	Number      uint64        `json:"number"`
+}
+
+// UploadReport is the result of the model upload scenario.
+type UploadReport struct {
+	Nodes      int            `json:"nodes"`
+	Partitions int            `json:"partitions"`
+	Model      common.Address `json:"model"`    // Address of the model meta contract
+	Created    uint64         `json:"created"`  // Block including the model creation
+	Uploaded   uint64         `json:"uploaded"` // Block completing the model upload
+	Matured    int            `json:"matured"`  // Nodes which saw the model mature
+	Maturity   Stats          `json:"maturity"` // Time from creation until nodes saw the model mature
+}
+
+// InferenceReport is the result of the inference agreement scenario.
+type InferenceReport struct {
+	Nodes       int            `json:"nodes"`
+	Partitions  int            `json:"partitions"`
+	Model       common.Address `json:"model"`
+	Contract    common.Address `json:"contract"`    // Contract running the inferences
+	Inferences  int            `json:"inferences"`  // Inference transactions sent
+	Included    int            `json:"included"`    // Inferences on the canonical chain after healing
+	Agreeing    int            `json:"agreeing"`    // Nodes whose results match the first node
+	Correct     int            `json:"correct"`     // Nodes whose results match the inference engine
+	Convergence time.Duration  `json:"convergence"` // Time from healing until all nodes share a head
+	Head        common.Hash    `json:"head"`
+	Root        common.Hash    `json:"root"`
+}
+
+// Scenarios returns the Cortex scenarios using the given storage, which must
+// be the inference engine of the simulated nodes.
+func Scenarios(storage *Storage) map[string]simulations.ScenarioFunc {
+	return map[string]simulations.ScenarioFunc{
+		PropagationScenario: func(ctx context.Context, net *simulations.Network, params json.RawMessage) (interface{}, error) {
+			return runScenario(ctx, net, params, propagationScenario)
+		},
+		UploadScenario: func(ctx context.Context, net *simulations.Network, params json.RawMessage) (interface{}, error) {
+			return runScenario(ctx, net, params, func(ctx context.Context, c *cluster, config Config) (interface{}, error) {
+				return uploadScenario(ctx, c, config, storage)
+			})
+		},
+		InferenceScenario: func(ctx context.Context, net *simulations.Network, params json.RawMessage) (interface{}, error) {
+			return runScenario(ctx, net, params, func(ctx context.Context, c *cluster, config Config) (interface{}, error) {
+				return inferenceScenario(ctx, c, config, storage)
+			})
+		},
+	}
+}
+
+// RegisterScenarios makes the Cortex scenarios available to the simulation
+// HTTP API.
+func RegisterScenarios(storage *Storage) {
+	for name, fn := range Scenarios(storage) {
+		simulations.RegisterScenario(name, fn)
+	}
+}
+
+// runScenario prepares the cluster of Cortex nodes and runs a scenario on it
+// within the configured time limit.
+func runScenario(ctx context.Context, net *simulations.Network, params json.RawMessage, run func(context.Context, *cluster, Config) (interface{}, error)) (interface{}, error) {
+	config, err := parseConfig(params)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(config.Timeout)*time.Second)
+	defer cancel()
+
+	c, err := newCluster(net)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.waitPeers(ctx); err != nil {
+		return nil, err
+	}
+	report, err := run(ctx, c, config)
+	if len(c.cut) > 0 {
+		// Restore the network even if the scenario timed out.
+		if err := c.heal(context.Background()); err != nil {
+			log.Warn("Failed to heal simulation network", "err", err)
+		}
+	}
+	return report, err
+}
+
+// propagationScenario mines blocks in every partition and measures the time
+// until they reach the other nodes of the partition. After healing, it
+// measures how long the nodes take to agree on a single chain.
+func propagationScenario(ctx context.Context, c *cluster, config Config) (interface{}, error) {
+	tracker, err := c.trackHeads(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tracker.stop()
+
+	if err := c.partition(ctx, config.Partitions); err != nil {
+		return nil, err
+	}
+	report := &PropagationReport{Nodes: len(c.nodes), Partitions: len(c.groups)}
+
+	// Mine concurrently in all partitions and wait for the blocks to settle.
+	mined := make([][]*types.Header, len(c.groups))
+	errc := make(chan error, len(c.groups))
+	for g, group := range c.groups {
+		go func(g, miner int) {
+			var err error
+			mined[g], err = c.mine(ctx, miner, config.Blocks)
+			errc <- err
+		}(g, group[0])
+	}
+	for range c.groups {
+		if err := <-errc; err != nil {
+			return nil, err
+		}
+	}
+	settle, cancel := context.WithTimeout(ctx, settleTimeout)
+	for g, group := range c.groups {
+		last := mined[g][len(mined[g])-1].Hash()
+		for _, node := range group {
+			for {
+				if _, ok := tracker.arrival(last, node); ok || sleep(settle, pollInterval) != nil {
+					break
+				}
+			}
+		}
+	}
+	cancel()
+
+	// Collect the delays relative to the earliest arrival, which is usually the
+	// one at the miner, though head events of nodes are delivered concurrently.
+	var (
+		delays   []time.Duration
+		expected int
+	)
+	for g, group := range c.groups {
+		for _, header := range mined[g] {
+			sealed, ok := tracker.arrival(header.Hash(), group[0])
+			if !ok {
+				continue
+			}
+			for _, node := range group[1:] {
+				if at, ok := tracker.arrival(header.Hash(), node); ok && at.Before(sealed) {
+					sealed = at
+				}
+			}
+			for _, node := range group[1:] {
+				expected++
+				if at, ok := tracker.arrival(header.Hash(), node); ok {
+					delays = append(delays, at.Sub(sealed))
+				}
+			}
+			report.Blocks++
+		}
+	}
+	report.Delay = newStats(delays)
+	if expected > 0 {
+		report.Coverage = float64(len(delays)) / float64(expected)
+	} else {
+		report.Coverage = 1
+	}
+
+	head, convergence, err := settleNetwork(ctx, c)
+	if err != nil {
+		return report, err
+	}
+	report.Convergence = convergence
+	report.Head, report.Number = head.Hash(), head.Number.Uint64()
+	return report, nil
+}
+
+// settleNetwork heals a partitioned network and waits until all nodes share
+// the same head, returning the head and the time it took.
+func settleNetwork(ctx context.Context, c *cluster) (*types.Header, time.Duration, error) {
+	start := time.Now()
+	cut := c.cut
+	if len(cut) == 0 {
+		head, err := c.converge(ctx)
+		return head, time.Since(start), err
+	}
+	if err := c.heal(ctx); err != nil {
+		return nil, 0, err
+	}
+	if err := c.waitLinks(ctx, cut); err != nil {
+		return nil, 0, err
+	}
+	if err := c.waitPeers(ctx); err != nil {
+		return nil, 0, err
+	}
+	head, err := c.settle(ctx, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return head, time.Since(start), nil
+}
+
+// uploadScenario creates and uploads a model within the first partition and
+// measures the time until every node sees the model mature. Nodes outside the
+// first partition can only do so once the network heals.
+func uploadScenario(ctx context.Context, c *cluster, config Config, storage *Storage) (interface{}, error) {
+	if err := c.partition(ctx, config.Partitions); err != nil {
+		return nil, err
+	}
+	report := &UploadReport{Nodes: len(c.nodes), Partitions: len(c.groups)}
+
+	watcher := newMaturityWatcher(c)
+	start := time.Now()
+	model, err := uploadModel(ctx, c, storage, watcher)
+	report.Model, report.Created, report.Uploaded = model.address, model.created, model.uploaded
+	if err != nil {
+		return report, err
+	}
+	if _, _, err := settleNetwork(ctx, c); err != nil {
+		return report, err
+	}
+	matured := watcher.wait(ctx)
+
+	var samples []time.Duration
+	for _, at := range matured {
+		samples = append(samples, at.Sub(start))
+	}
+	report.Matured = len(matured)
+	report.Maturity = newStats(samples)
+	return report, nil
+}
+
+// inferenceScenario uploads a model, deploys a contract running inferences
+// on it and lets every partition send inference transactions. After healing
+// it checks that all nodes agree on the inference results.
+func inferenceScenario(ctx context.Context, c *cluster, config Config, storage *Storage) (interface{}, error) {
+	report := &InferenceReport{Nodes: len(c.nodes)}
+
+	// Prepare the model and the inference contract on the whole network.
+	model, err := uploadModel(ctx, c, storage, nil)
+	report.Model = model.address
+	if err != nil {
+		return report, err
+	}
+	creator := Accounts[1]
+	tx, err := c.sendTx(ctx, 0, creator, nil, deployGas, inferContract(model.address))
+	if err != nil {
+		return report, err
+	}
+	if _, err := c.mineUntilIncluded(ctx, 0, tx.Hash()); err != nil {
+		return report, err
+	}
+	report.Contract = crypto.CreateAddress(crypto.PubkeyToAddress(creator.PublicKey), tx.Nonce())
+	if _, err := c.mine(ctx, 0, 1); err != nil {
+		return report, err
+	}
+	if _, err := c.converge(ctx); err != nil {
+		return report, err
+	}
+
+	// Send inferences in every partition.
+	if err := c.partition(ctx, config.Partitions); err != nil {
+		return report, err
+	}
+	report.Partitions = len(c.groups)
+
+	var (
+		inputs []common.Hash
+		lock   sync.Mutex
+		errc   = make(chan error, len(c.groups))
+	)
+	for g, group := range c.groups {
+		go func(g int, miner int) {
+			key := Accounts[(2+g)%len(Accounts)]
+			var last common.Hash
+			for i := 0; i < config.Inferences; i++ {
+				input := inferInput(g, i)
+				tx, err := c.sendTx(ctx, miner, key, &report.Contract, inferGas, input.Bytes())
+				if err != nil {
+					errc <- err
+					return
+				}
+				lock.Lock()
+				inputs = append(inputs, input)
+				lock.Unlock()
+				last = tx.Hash()
+			}
+			_, err := c.mineUntilIncluded(ctx, miner, last)
+			errc <- err
+		}(g, group[0])
+	}
+	for range c.groups {
+		if err := <-errc; err != nil {
+			return report, err
+		}
+	}
+	report.Inferences = len(inputs)
+
+	head, convergence, err := settleNetwork(ctx, c)
+	if err != nil {
+		return report, err
+	}
+	report.Convergence = convergence
+	report.Head, report.Root = head.Hash(), head.Root
+
+	// Compare the stored results of all nodes at the common head. Inferences
+	// dropped from the canonical chain leave their result empty.
+	expected := make([]common.Hash, len(inputs))
+	for j, input := range inputs {
+		result, err := storage.Infer(model.hash, input.Bytes())
+		if err != nil {
+			return report, err
+		}
+		expected[j] = common.BytesToHash(result)
+	}
+	var reference []common.Hash
+	for i, client := range c.clients {
+		results := make([]common.Hash, len(inputs))
+		correct := true
+		for j, input := range inputs {
+			var result hexutil.Bytes
+			if err := client.CallContext(ctx, &result, "ctxc_getStorageAt", report.Contract, input.Hex(), hexutil.EncodeBig(head.Number)); err != nil {
+				return report, err
+			}
+			results[j] = common.BytesToHash(result)
+			if results[j] != (common.Hash{}) && results[j] != expected[j] {
+				correct = false
+			}
+		}
+		if i == 0 {
+			reference = results
+			for _, result := range results {
+				if result != (common.Hash{}) {
+					report.Included++
+				}
+			}
+		}
+		if correct {
+			report.Correct++
+		}
+		if equalResults(reference, results) {
+			report.Agreeing++
+		}
+	}
+	return report, nil
+}
+
+func equalResults(a, b []common.Hash) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// inferInput returns the input of the i-th inference sent by a partition.
+func inferInput(group, i int) common.Hash {
+	seed := make([]byte, 16)
+	binary.BigEndian.PutUint64(seed, uint64(group))
+	binary.BigEndian.PutUint64(seed[8:], uint64(i))
+	return crypto.Keccak256Hash([]byte("ctxc-simulation-input"), seed)
+}
+
+// model is a model uploaded by a scenario.
+type model struct {
+	address  common.Address // Address of the model meta contract
+	hash     common.Address // Info hash of the model content
+	created  uint64         // Block including the model creation
+	uploaded uint64         // Block completing the model upload
+}
+
+// uploadModel creates a model in the first partition, uploads it and mines
+// until it has matured there.
+func uploadModel(ctx context.Context, c *cluster, storage *Storage, watcher *maturityWatcher) (*model, error) {
+	var (
+		author = Accounts[0]
+		miner  = c.groups[0][0]
+		data   = modelData(time.Now().UnixNano())
+		m      = &model{hash: common.BytesToAddress(crypto.Keccak256(data))}
+	)
+	storage.Put(m.hash, data)
+
+	meta := &torrentfs.ModelMeta{
+		Hash:          m.hash,
+		RawSize:       uint64(len(data)),
+		InputShape:    []uint64{1},
+		OutputShape:   []uint64{1},
+		AuthorAddress: crypto.PubkeyToAddress(author.PublicKey),
+	}
+	code, err := rlp.EncodeToBytes(meta)
+	if err != nil {
+		return m, err
+	}
+	tx, err := c.sendTx(ctx, miner, author, nil, deployGas, append([]byte{0, 1}, code...))
+	if err != nil {
+		return m, err
+	}
+	m.address = crypto.CreateAddress(meta.AuthorAddress, tx.Nonce())
+	if watcher != nil {
+		watcher.start(ctx, m.address)
+	}
+	if m.created, err = c.mineUntilIncluded(ctx, miner, tx.Hash()); err != nil {
+		return m, err
+	}
+	log.Info("Created simulation model", "address", m.address, "hash", m.hash, "number", m.created)
+
+	// Uploads are only accepted once the model had time to seed.
+	head, err := c.head(ctx, miner)
+	if err != nil {
+		return m, err
+	}
+	if wait := int(m.created+params.SeedingBlks) - int(head.Number.Uint64()); wait > 0 {
+		if _, err := c.mine(ctx, miner, wait); err != nil {
+			return m, err
+		}
+	}
+	if tx, err = c.sendTx(ctx, miner, author, &m.address, params.UploadGas, nil); err != nil {
+		return m, err
+	}
+	if m.uploaded, err = c.mineUntilIncluded(ctx, miner, tx.Hash()); err != nil {
+		return m, err
+	}
+	if _, err := c.mine(ctx, miner, int(Genesis().Config.GetMatureBlock())); err != nil {
+		return m, err
+	}
+	log.Info("Uploaded simulation model", "address", m.address, "number", m.uploaded)
+	return m, nil
+}
+
+// modelData generates the content of a simulated model.
+func modelData(seed int64) []byte {
+	data := make([]byte, modelSize)
+	binary.BigEndian.PutUint64(data, uint64(seed))
+	for i := 32; i < len(data); i += 32 {
+		copy(data[i:], crypto.Keccak256(data[i-32:i]))
+	}
+	return data
+}
+
+// mineUntilIncluded mines blocks on a node until the given transaction is
+// included, returning the number of the including block.
+func (c *cluster) mineUntilIncluded(ctx context.Context, node int, hash common.Hash) (uint64, error) {
+	for i := 0; i < maxMineBlocks; i++ {
+		var receipt *struct {
+			BlockNumber hexutil.Uint64 `json:"blockNumber"`
+			Status      hexutil.Uint64 `json:"status"`
+		}
+		if err := c.clients[node].CallContext(ctx, &receipt, "ctxc_getTransactionReceipt", hash); err != nil {
+			return 0, err
+		}
+		if receipt != nil {
+			if receipt.Status != hexutil.Uint64(types.ReceiptStatusSuccessful) {
+				return 0, fmt.Errorf("transaction %x failed", hash)
+			}
+			return uint64(receipt.BlockNumber), nil
+		}
+		if _, err := c.mine(ctx, node, 1); err != nil {
+			return 0, err
+		}
+	}
+	return 0, fmt.Errorf("transaction %x not included after %d blocks", hash, maxMineBlocks)
+}
+
+// inferContract returns the creation code of a contract which, when called
+// with a 32 byte input, runs the model on it and stores the result under the
+// input.
+func inferContract(model common.Address) []byte {
+	inputSlot := crypto.Keccak256(make([]byte, 32))
+
+	runtime := []byte{
+		0x60, 0x01, 0x60, 0x00, 0x55, // sstore(0, 1): the input is one word long
+		0x60, 0x00, 0x35, 0x7f, // sstore(keccak256(0), calldataload(0))
+	}
+	runtime = append(runtime, inputSlot...)
+	runtime = append(runtime,
+		0x55,
+		0x60, 0x01, 0x60, 0x80, 0x52, // mstore(0x80, 1): the output is one word long
+		0x60, 0x00, 0x60, 0xa0, 0x52, // mstore(0xa0, 0)
+		0x60, 0x80, 0x60, 0x00, 0x73, // inferarray(model, 0, 0x80)
+	)
+	runtime = append(runtime, model.Bytes()...)
+	runtime = append(runtime,
+		0xc1, 0x50,
+		0x60, 0xa0, 0x51, 0x60, 0x00, 0x35, 0x55, // sstore(calldataload(0), mload(0xa0))
+		0x00,
+	)
+	// Constructor copying the runtime code into memory and returning it.
+	code := []byte{0x60, byte(len(runtime)), 0x80, 0x60, 0x0c, 0x60, 0x00, 0x39, 0x60, 0x00, 0xf3, 0x00}
+	return append(code, runtime...)
+}
+
+// maturityWatcher polls all nodes for the state of a model and records when
+// each of them first sees it mature.
+type maturityWatcher struct {
+	c       *cluster
+	matured map[int]time.Time
+	done    chan struct{}
+	lock    sync.Mutex
+}
+
+func newMaturityWatcher(c *cluster) *maturityWatcher {
+	return &maturityWatcher{c: c, matured: make(map[int]time.Time), done: make(chan struct{})}
+}
+
+func (w *maturityWatcher) start(ctx context.Context, model common.Address) {
+	var wg sync.WaitGroup
+	for i := range w.c.nodes {
+		wg.Add(1)
+		go func(node int) {
+			defer wg.Done()
+			for {
+				if ok, _ := w.c.mature(ctx, node, model); ok {
+					w.lock.Lock()
+					w.matured[node] = time.Now()
+					w.lock.Unlock()
+					return
+				}
+				if sleep(ctx, pollInterval) != nil {
+					return
+				}
+			}
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		close(w.done)
+	}()
+}
+
+// wait blocks until all nodes saw the model mature or the context is done,
+// returning the maturity times recorded so far.
+func (w *maturityWatcher) wait(ctx context.Context) map[int]time.Time {
+	select {
+	case <-w.done:
+	case <-ctx.Done():
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	matured := make(map[int]time.Time, len(w.matured))
+	for node, at := range w.matured {
+		matured[node] = at
+	}
+	return matured
+}
+
+// mature reports whether a model is fully uploaded and mature on the current
+// chain of a node.
+func (c *cluster) mature(ctx context.Context, node int, model common.Address) (bool, error) {
+	var (
+		client   = c.clients[node]
+		upload   hexutil.Big
+		num      hexutil.Big
+		blockNum hexutil.Uint64
+	)
+	if err := client.CallContext(ctx, &blockNum, "ctxc_blockNumber"); err != nil {
+		return false, err
+	}
+	block := hexutil.EncodeUint64(uint64(blockNum))
+	if err := client.CallContext(ctx, &upload, "ctxc_getUpload", model, block); err != nil {
+		return false, err
+	}
+	if err := client.CallContext(ctx, &num, "ctxc_getNum", model, block); err != nil {
+		return false, err
+	}
+	if upload.ToInt().Sign() != 0 || num.ToInt().Sign() == 0 {
+		return false, nil
+	}
+	mature := new(big.Int).Add(num.ToInt(), big.NewInt(Genesis().Config.GetMatureBlock()))
+	return mature.Cmp(new(big.Int).SetUint64(uint64(blockNum))) <= 0, nil
+}
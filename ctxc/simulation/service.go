@@ -0,0 +1,107 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package simulation runs Cortex nodes inside p2p network simulations and
+// provides scenarios measuring block propagation, model upload maturity and
+// inference result agreement.
+package simulation
+
+import (
+	"crypto/ecdsa"
+	"encoding/binary"
+	"math/big"
+
+	"github.com/CortexFoundation/CortexTheseus/consensus/cuckoo"
+	"github.com/CortexFoundation/CortexTheseus/core"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/ctxc"
+	"github.com/CortexFoundation/CortexTheseus/ctxc/downloader"
+	"github.com/CortexFoundation/CortexTheseus/node"
+	"github.com/CortexFoundation/CortexTheseus/p2p/simulations/adapters"
+	"github.com/CortexFoundation/CortexTheseus/params"
+)
+
+// ServiceName is the name of the Cortex service in simulation node configs.
+const ServiceName = "ctxc"
+
+// Accounts are the funded accounts of the simulation genesis. Scenarios use
+// them to sign the model upload and inference transactions.
+var Accounts = func() []*ecdsa.PrivateKey {
+	keys := make([]*ecdsa.PrivateKey, 4)
+	for i := range keys {
+		seed := make([]byte, 8)
+		binary.BigEndian.PutUint64(seed, uint64(i))
+		key, err := crypto.ToECDSA(crypto.Keccak256([]byte("ctxc-simulation"), seed))
+		if err != nil {
+			panic(err)
+		}
+		keys[i] = key
+	}
+	return keys
+}()
+
+// Genesis returns the genesis block of simulated networks. It uses the chain
+// parameters of the Dolores test network, where models mature after a single
+// block and the upload quota is large, so that scenarios finish quickly.
+func Genesis() *core.Genesis {
+	alloc := make(core.GenesisAlloc)
+	for _, key := range Accounts {
+		alloc[crypto.PubkeyToAddress(key.PublicKey)] = core.GenesisAccount{Balance: params.CTXC_INIT}
+	}
+	return &core.Genesis{
+		Config:     params.DoloresChainConfig,
+		GasLimit:   params.GenesisGasLimit,
+		Difficulty: big.NewInt(2),
+		Alloc:      alloc,
+		Supply:     new(big.Int).Mul(params.CTXC_INIT, big.NewInt(int64(len(Accounts)))),
+	}
+}
+
+// Services returns the simulation service constructors running full Cortex
+// nodes on in-memory databases with the fake Cuckoo engine.
+func Services(genesis *core.Genesis) adapters.Services {
+	return adapters.Services{
+		ServiceName: func(ctx *adapters.ServiceContext) (node.Service, error) {
+			return NewService(ctx, genesis)
+		},
+	}
+}
+
+// NewService creates a Cortex node for the given simulation node. Blocks are
+// credited to the address of the node key.
+func NewService(ctx *adapters.ServiceContext, genesis *core.Genesis) (*ctxc.Cortex, error) {
+	config := ctxc.DefaultConfig
+	config.Genesis = genesis
+	config.NetworkId = genesis.Config.ChainID.Uint64()
+	config.SyncMode = downloader.FullSync
+	config.Coinbase = crypto.PubkeyToAddress(ctx.Config.PrivateKey.PublicKey)
+	config.Cuckoo.PowMode = cuckoo.ModeFake
+	config.DatabaseCache = 16
+	config.TrieCleanCache = 16
+	config.TrieCleanCacheJournal = ""
+	config.TrieDirtyCache = 16
+	config.SnapshotCache = 0
+	config.TxPool.Journal = ""
+
+	cortex, err := ctxc.New(ctx.NodeContext, &config)
+	if err != nil {
+		return nil, err
+	}
+	// Fake seals are instant, so empty pre-sealed blocks would always win the
+	// race against blocks carrying the pending transactions.
+	cortex.Miner().DisablePreseal()
+	return cortex, nil
+}
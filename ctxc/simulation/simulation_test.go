@@ -0,0 +1,124 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulation
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/CortexFoundation/CortexTheseus/p2p/enode"
+	"github.com/CortexFoundation/CortexTheseus/p2p/simulations"
+	"github.com/CortexFoundation/CortexTheseus/p2p/simulations/adapters"
+)
+
+var testStorage = NewStorage()
+
+// newTestNetwork starts a ring of Cortex nodes in the simulation adapter.
+func newTestNetwork(t *testing.T, n int) *simulations.Network {
+	if err := testStorage.Start(); err != nil && err != errEngineStarted {
+		t.Fatal(err)
+	}
+	adapter := adapters.NewSimAdapter(Services(Genesis()))
+	net := simulations.NewNetwork(adapter, &simulations.NetworkConfig{DefaultService: ServiceName})
+
+	ids := make([]enode.ID, n)
+	for i := range ids {
+		node, err := net.NewNodeWithConfig(adapters.RandomNodeConfig())
+		if err != nil {
+			net.Shutdown()
+			t.Fatal(err)
+		}
+		if err := net.Start(node.ID()); err != nil {
+			net.Shutdown()
+			t.Fatal(err)
+		}
+		ids[i] = node.ID()
+	}
+	for i := range ids {
+		if err := net.Connect(ids[i], ids[(i+1)%n]); err != nil {
+			net.Shutdown()
+			t.Fatal(err)
+		}
+	}
+	return net
+}
+
+func runTestScenario(t *testing.T, net *simulations.Network, name string, config Config, report interface{}) {
+	params, _ := json.Marshal(config)
+	result, err := Scenarios(testStorage)[name](context.Background(), net, params)
+	if err != nil {
+		t.Fatalf("scenario %s failed: %v", name, err)
+	}
+	blob, _ := json.Marshal(result)
+	t.Logf("%s: %s", name, blob)
+	if err := json.Unmarshal(blob, report); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBlockPropagation(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping network simulation in short mode")
+	}
+	net := newTestNetwork(t, 4)
+	defer net.Shutdown()
+
+	var report PropagationReport
+	runTestScenario(t, net, PropagationScenario, Config{Blocks: 3, Partitions: 2, Timeout: 120}, &report)
+	if report.Partitions != 2 {
+		t.Errorf("partitions mismatch: have %d, want 2", report.Partitions)
+	}
+	if report.Blocks != 6 {
+		t.Errorf("mined blocks mismatch: have %d, want 6", report.Blocks)
+	}
+	if report.Coverage != 1 {
+		t.Errorf("blocks did not reach all nodes of their partition: coverage %f", report.Coverage)
+	}
+	if report.Number < 4 {
+		t.Errorf("head too low after healing: %d", report.Number)
+	}
+}
+
+func TestModelUploadAndInference(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping network simulation in short mode")
+	}
+	net := newTestNetwork(t, 4)
+	defer net.Shutdown()
+
+	var upload UploadReport
+	runTestScenario(t, net, UploadScenario, Config{Partitions: 2, Timeout: 120}, &upload)
+	if upload.Matured != 4 {
+		t.Errorf("model matured on %d of 4 nodes", upload.Matured)
+	}
+	if upload.Uploaded < upload.Created+6 {
+		t.Errorf("model uploaded too early: created %d, uploaded %d", upload.Created, upload.Uploaded)
+	}
+
+	var infer InferenceReport
+	runTestScenario(t, net, InferenceScenario, Config{Partitions: 2, Inferences: 2, Timeout: 120}, &infer)
+	if infer.Inferences != 4 {
+		t.Errorf("sent inferences mismatch: have %d, want 4", infer.Inferences)
+	}
+	if infer.Included < 2 {
+		t.Errorf("too few inferences on the canonical chain: %d", infer.Included)
+	}
+	if infer.Agreeing != 4 || infer.Correct != 4 {
+		t.Errorf("nodes disagree on inference results: %d agreeing, %d correct", infer.Agreeing, infer.Correct)
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulation
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/inference"
+	"github.com/CortexFoundation/inference/synapse"
+)
+
+// modelOps is the operation count reported for every stored model, making
+// inference cost the minimum amount of gas.
+const modelOps = 1
+
+var (
+	errFileNotFound  = errors.New("file not found")
+	errEngineStarted = errors.New("inference engine already initialised")
+)
+
+// Storage is an in-memory stand-in for the torrent file system and the CVM
+// inference engine. Files are stored by info hash and inference results are
+// derived deterministically from the model content and the input, so nodes
+// agree on them exactly when they agree on the model.
+//
+// The inference engine is a process wide singleton, hence a single storage is
+// shared by all the nodes of a simulation.
+type Storage struct {
+	files map[common.Address][]byte
+	lock  sync.RWMutex
+
+	server *http.Server
+	url    string
+}
+
+// NewStorage creates an empty file store.
+func NewStorage() *Storage {
+	return &Storage{files: make(map[common.Address][]byte)}
+}
+
+// Put stores the content of a file under the given info hash.
+func (s *Storage) Put(hash common.Address, data []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.files[hash] = common.CopyBytes(data)
+}
+
+// Has reports whether the file with the given info hash is stored.
+func (s *Storage) Has(hash common.Address) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	_, ok := s.files[hash]
+	return ok
+}
+
+// Infer returns the inference result of the given model on an input.
+func (s *Storage) Infer(model common.Address, input []byte) ([]byte, error) {
+	s.lock.RLock()
+	data, ok := s.files[model]
+	s.lock.RUnlock()
+
+	if !ok {
+		return nil, errFileNotFound
+	}
+	return crypto.Keccak256(data, input), nil
+}
+
+// GetFileWithSize implements torrentfs.CortexStorage.
+func (s *Storage) GetFileWithSize(ctx context.Context, infohash string, rawSize uint64, path string) ([]byte, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	data, ok := s.files[common.HexToAddress(infohash)]
+	if !ok || uint64(len(data)) != rawSize {
+		return nil, errFileNotFound
+	}
+	return common.CopyBytes(data), nil
+}
+
+// Download implements torrentfs.CortexStorage. Files are seeded with Put, so
+// download requests are accepted without further action.
+func (s *Storage) Download(ctx context.Context, ih string, request uint64) error {
+	return nil
+}
+
+// Stop implements torrentfs.CortexStorage.
+func (s *Storage) Stop() error {
+	return nil
+}
+
+// Start serves the remote inference protocol over a local HTTP endpoint and
+// installs it as the inference engine of the process. It fails if the engine
+// has already been initialised.
+func (s *Storage) Start() error {
+	if synapse.Engine() != nil {
+		return errEngineStarted
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	s.server = &http.Server{Handler: s}
+	s.url = "http://" + listener.Addr().String()
+	go s.server.Serve(listener)
+
+	synapse.New(&synapse.Config{
+		IsRemoteInfer: true,
+		InferURI:      s.url,
+		Storagefs:     s,
+	})
+	log.Info("Started simulation inference engine", "url", s.url)
+	return nil
+}
+
+// ServeHTTP answers the requests of the remote inference protocol.
+func (s *Storage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeInferResult(w, nil, err)
+		return
+	}
+	switch inference.RetriveType(body) {
+	case inference.INFER_BY_IC:
+		var work inference.ICWork
+		if err := work.UnmarshalJSON(body); err != nil {
+			writeInferResult(w, nil, err)
+			return
+		}
+		result, err := s.Infer(common.HexToAddress(work.Model), work.Input)
+		writeInferResult(w, result, err)
+
+	case inference.INFER_BY_IH:
+		var work inference.IHWork
+		if err := work.UnmarshalJSON(body); err != nil {
+			writeInferResult(w, nil, err)
+			return
+		}
+		input, err := s.GetFileWithSize(r.Context(), work.Input, work.InputSize, "")
+		if err != nil {
+			writeInferResult(w, nil, err)
+			return
+		}
+		result, err := s.Infer(common.HexToAddress(work.Model), input)
+		writeInferResult(w, result, err)
+
+	case inference.GAS_BY_H:
+		var work inference.GasWork
+		if err := work.UnmarshalJSON(body); err != nil {
+			writeInferResult(w, nil, err)
+			return
+		}
+		if !s.Has(common.HexToAddress(work.Model)) {
+			writeInferResult(w, nil, errFileNotFound)
+			return
+		}
+		ops := make([]byte, 8)
+		binary.BigEndian.PutUint64(ops, modelOps)
+		writeInferResult(w, ops, nil)
+
+	case inference.AVAILABLE_BY_H:
+		var work inference.AvailableWork
+		if err := work.UnmarshalJSON(body); err != nil {
+			writeInferResult(w, nil, err)
+			return
+		}
+		if !s.Has(common.HexToAddress(work.InfoHash)) {
+			writeInferResult(w, nil, errFileNotFound)
+			return
+		}
+		writeInferResult(w, nil, nil)
+
+	default:
+		writeInferResult(w, nil, errors.New("unknown request type"))
+	}
+}
+
+// writeInferResult encodes the reply to a remote inference request.
+func writeInferResult(w http.ResponseWriter, data []byte, err error) {
+	res := inference.InferResult{Info: inference.RES_OK, Data: hexutil.Bytes(data)}
+	if err != nil {
+		res = inference.InferResult{Info: inference.RES_ERROR, Data: hexutil.Bytes(err.Error())}
+	}
+	blob, _ := json.Marshal(res)
+	w.Write(blob)
+}
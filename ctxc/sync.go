@@ -275,6 +275,12 @@ func (cs *chainSyncer) modeAndLocalHead() (downloader.SyncMode, *big.Int) {
 	if atomic.LoadUint32(&cs.pm.fastSync) == 1 {
 		block := cs.pm.blockchain.CurrentFastBlock()
 		td := cs.pm.blockchain.GetTdByHash(block.Hash())
+		if atomic.LoadUint32(&cs.pm.snapSync) == 1 {
+			return downloader.SnapSync, td
+		}
+		if atomic.LoadUint32(&cs.pm.checkpointSync) == 1 {
+			return downloader.CheckpointSync, td
+		}
 		return downloader.FastSync, td
 	}
 	// We are probably in full sync, but we might have rewound to before the
@@ -300,7 +306,7 @@ func (cs *chainSyncer) startSync(op *chainSyncOp) {
 
 // doSync synchronizes the local blockchain with a remote peer.
 func (pm *ProtocolManager) doSync(op *chainSyncOp) error {
-	if op.mode == downloader.FastSync {
+	if op.mode == downloader.FastSync || op.mode == downloader.SnapSync || op.mode == downloader.CheckpointSync {
 		// Before launch the fast sync, we have to ensure user uses the same
 		// txlookup limit.
 		// The main concern here is: during the fast sync Cortex won't index the
@@ -326,6 +332,8 @@ func (pm *ProtocolManager) doSync(op *chainSyncOp) error {
 	if atomic.LoadUint32(&pm.fastSync) == 1 {
 		log.Info("Fast sync complete, auto disabling")
 		atomic.StoreUint32(&pm.fastSync, 0)
+		atomic.StoreUint32(&pm.snapSync, 0)
+		atomic.StoreUint32(&pm.checkpointSync, 0)
 	}
 
 	// If we've successfully finished a sync cycle and passed any required checkpoint,
@@ -23,6 +23,7 @@ import (
 	"time"
 
 	"github.com/CortexFoundation/CortexTheseus/ctxc/downloader"
+	"github.com/CortexFoundation/CortexTheseus/ctxc/protocols/snap"
 	"github.com/CortexFoundation/CortexTheseus/p2p"
 )
 
@@ -62,3 +63,67 @@ func testFastSyncDisabling(t *testing.T, protocol int) {
 		t.Fatalf("fast sync not disabled after successful synchronisation")
 	}
 }
+
+// Tests that snap sync completes through doSync on a node that does not maintain
+// snapshots itself, retrieving the pivot state over the snap protocol.
+func TestSnapSyncWithoutSnapshots(t *testing.T) {
+	t.Parallel()
+
+	pmEmpty, _ := newTestProtocolManagerMust(t, downloader.SnapSync, 0, nil, nil)
+	if atomic.LoadUint32(&pmEmpty.snapSync) == 0 {
+		t.Fatalf("snap sync disabled on pristine blockchain")
+	}
+	pmFull, _ := newTestProtocolManagerMust(t, downloader.FullSync, 1024, nil, nil)
+
+	// The syncing node must run the snap protocol even without a snapshot cache
+	empty := &Cortex{config: &Config{}, blockchain: pmEmpty.blockchain, protocolManager: pmEmpty}
+	var emptySnap *p2p.Protocol
+	for _, proto := range empty.Protocols() {
+		if proto.Name == snap.ProtocolName {
+			emptySnap = &proto
+			break
+		}
+	}
+	if emptySnap == nil {
+		t.Fatalf("snap protocol not running during snap sync")
+	}
+	fullSnap := snap.MakeProtocols((*snapHandler)(pmFull))[0]
+
+	// Connect the two nodes over both protocols
+	fullID, emptyID := enode.ID{1}, enode.ID{2}
+
+	io1, io2 := p2p.MsgPipe()
+	go pmFull.handle(pmFull.newPeer(65, p2p.NewPeer(emptyID, "empty", nil), io2, pmFull.txpool.Get))
+	go pmEmpty.handle(pmEmpty.newPeer(65, p2p.NewPeer(fullID, "full", nil), io1, pmEmpty.txpool.Get))
+
+	snap1, snap2 := p2p.MsgPipe()
+	go fullSnap.Run(p2p.NewPeer(emptyID, "empty", nil), snap2)
+	go emptySnap.Run(p2p.NewPeer(fullID, "full", nil), snap1)
+
+	time.Sleep(250 * time.Millisecond)
+	mode, _ := pmEmpty.chainSync.modeAndLocalHead()
+	if mode != downloader.SnapSync {
+		t.Fatalf("sync mode mismatch: have %v, want %v", mode, downloader.SnapSync)
+	}
+	done := make(chan error, 1)
+	go func() { done <- pmEmpty.doSync(peerToSyncOp(mode, pmEmpty.peers.BestPeer())) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal("sync failed:", err)
+		}
+	case <-time.After(30 * time.Second):
+		pmEmpty.downloader.Cancel()
+		t.Fatal("snap sync did not complete")
+	}
+	head := pmEmpty.blockchain.CurrentBlock()
+	if head.Hash() != pmFull.blockchain.CurrentBlock().Hash() {
+		t.Fatalf("head mismatch: have #%d, want #%d", head.NumberU64(), pmFull.blockchain.CurrentBlock().NumberU64())
+	}
+	if _, err := pmEmpty.blockchain.StateAt(head.Root()); err != nil {
+		t.Fatalf("head state missing: %v", err)
+	}
+	if atomic.LoadUint32(&pmEmpty.snapSync) == 1 {
+		t.Fatalf("snap sync not disabled after successful synchronisation")
+	}
+}
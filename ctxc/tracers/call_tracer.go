@@ -0,0 +1,235 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
+	"github.com/CortexFoundation/CortexTheseus/core/vm"
+)
+
+// CallFrame is a single call, contract creation or self destruct recorded by
+// the native call tracer. Frames are listed flat in execution order, the
+// position of a frame in the call tree is given by its trace address.
+type CallFrame struct {
+	Type         string         `json:"type"`
+	From         common.Address `json:"from"`
+	To           common.Address `json:"to"`
+	Value        *hexutil.Big   `json:"value"`
+	Gas          hexutil.Uint64 `json:"gas"`
+	Input        hexutil.Bytes  `json:"input,omitempty"`
+	Error        string         `json:"error,omitempty"`
+	TraceAddress []int          `json:"traceAddress"`
+	Subtraces    int            `json:"subtraces"`
+
+	depth int // CVM depth of the calling frame, used to detect returns
+}
+
+// CallTracer is a native Go tracer recording the tree of internal calls of a
+// transaction, including the value transferred by each of them. It implements
+// vm.Tracer and is much cheaper than the JavaScript call tracer.
+type CallTracer struct {
+	frames  []*CallFrame // All recorded frames in execution order
+	pending []*CallFrame // Frames entered but not yet returned from, root first
+}
+
+// NewCallTracer creates a native call tracer.
+func NewCallTracer() *CallTracer {
+	return new(CallTracer)
+}
+
+// Frames returns the recorded call frames in execution order. The first frame
+// is the top level call of the transaction.
+func (t *CallTracer) Frames() []*CallFrame {
+	return t.frames
+}
+
+// CaptureStart implements vm.Tracer, recording the top level call.
+func (t *CallTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	typ := vm.CALL.String()
+	if create {
+		typ = vm.CREATE.String()
+	}
+	t.enter(&CallFrame{
+		Type:  typ,
+		From:  from,
+		To:    to,
+		Value: (*hexutil.Big)(new(big.Int).Set(value)),
+		Gas:   hexutil.Uint64(gas),
+		Input: common.CopyBytes(input),
+	})
+	return nil
+}
+
+// CaptureState implements vm.Tracer, recording the internal calls made by the
+// executing contract and detecting returns from them.
+func (t *CallTracer) CaptureState(env *vm.CVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, rStack *vm.ReturnStack, rData []byte, contract *vm.Contract, depth int, err error) error {
+	if err != nil {
+		return nil
+	}
+	// Any frame entered from this depth or deeper has returned by now, with the
+	// result of the call on top of the stack.
+	t.exit(depth, stack)
+
+	data := stack.Data()
+	switch op {
+	case vm.CREATE, vm.CREATE2:
+		if len(data) < 3 {
+			return nil
+		}
+		t.enter(&CallFrame{
+			Type:  op.String(),
+			From:  contract.Address(),
+			Value: (*hexutil.Big)(stack.Back(0).ToBig()),
+			Gas:   hexutil.Uint64(gas),
+			Input: memoryCopy(memory, stack.Back(1).Uint64(), stack.Back(2).Uint64()),
+			depth: depth,
+		})
+
+	case vm.CALL, vm.CALLCODE:
+		if len(data) < 5 {
+			return nil
+		}
+		t.enter(&CallFrame{
+			Type:  op.String(),
+			From:  contract.Address(),
+			To:    common.Address(stack.Back(1).Bytes20()),
+			Value: (*hexutil.Big)(stack.Back(2).ToBig()),
+			Gas:   hexutil.Uint64(stack.Back(0).Uint64()),
+			Input: memoryCopy(memory, stack.Back(3).Uint64(), stack.Back(4).Uint64()),
+			depth: depth,
+		})
+
+	case vm.DELEGATECALL, vm.STATICCALL:
+		if len(data) < 4 {
+			return nil
+		}
+		t.enter(&CallFrame{
+			Type:  op.String(),
+			From:  contract.Address(),
+			To:    common.Address(stack.Back(1).Bytes20()),
+			Value: (*hexutil.Big)(new(big.Int)),
+			Gas:   hexutil.Uint64(stack.Back(0).Uint64()),
+			Input: memoryCopy(memory, stack.Back(2).Uint64(), stack.Back(3).Uint64()),
+			depth: depth,
+		})
+
+	case vm.SELFDESTRUCT:
+		if len(data) < 1 {
+			return nil
+		}
+		frame := &CallFrame{
+			Type:  op.String(),
+			From:  contract.Address(),
+			To:    common.Address(stack.Back(0).Bytes20()),
+			Value: (*hexutil.Big)(new(big.Int).Set(env.StateDB.GetBalance(contract.Address()))),
+		}
+		t.enter(frame)
+		t.pending = t.pending[:len(t.pending)-1]
+	}
+	return nil
+}
+
+// CaptureFault implements vm.Tracer.
+func (t *CallTracer) CaptureFault(env *vm.CVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, rStack *vm.ReturnStack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+// CaptureEnd implements vm.Tracer, finalizing the top level call.
+func (t *CallTracer) CaptureEnd(output []byte, gasUsed uint64, _ time.Duration, err error) error {
+	if len(t.pending) == 0 {
+		return nil
+	}
+	// Internal calls still pending when the transaction ends cannot have any
+	// further effect, drop them from the pending list.
+	root := t.pending[0]
+	t.pending = t.pending[:0]
+	if err != nil {
+		root.Error = err.Error()
+	}
+	t.markReverted()
+	return nil
+}
+
+// markReverted flags all frames whose effects were undone because one of their
+// ancestors failed. Frames are stored in pre-order, so the ancestors of a frame
+// are exactly the frames on the stack with a shorter trace address.
+func (t *CallTracer) markReverted() {
+	var ancestors []*CallFrame
+	for _, frame := range t.frames {
+		for len(ancestors) > 0 && len(ancestors[len(ancestors)-1].TraceAddress) >= len(frame.TraceAddress) {
+			ancestors = ancestors[:len(ancestors)-1]
+		}
+		if n := len(ancestors); n > 0 && ancestors[n-1].Error != "" && frame.Error == "" {
+			frame.Error = "parent reverted"
+		}
+		ancestors = append(ancestors, frame)
+	}
+}
+
+// enter records a new frame as the last child of the innermost pending frame.
+func (t *CallTracer) enter(frame *CallFrame) {
+	frame.TraceAddress = []int{}
+	if n := len(t.pending); n > 0 {
+		parent := t.pending[n-1]
+		frame.TraceAddress = append(append([]int{}, parent.TraceAddress...), parent.Subtraces)
+		parent.Subtraces++
+	}
+	t.frames = append(t.frames, frame)
+	t.pending = append(t.pending, frame)
+}
+
+// exit closes all pending frames entered from the given depth or deeper, using
+// the value on top of the stack of the caller to determine their outcome.
+func (t *CallTracer) exit(depth int, stack *vm.Stack) {
+	for len(t.pending) > 1 {
+		frame := t.pending[len(t.pending)-1]
+		if frame.depth < depth {
+			return
+		}
+		t.pending = t.pending[:len(t.pending)-1]
+
+		if len(stack.Data()) == 0 {
+			continue
+		}
+		ret := stack.Back(0)
+		switch frame.Type {
+		case vm.CREATE.String(), vm.CREATE2.String():
+			if ret.IsZero() {
+				frame.Error = "internal failure"
+			} else {
+				frame.To = common.Address(ret.Bytes20())
+			}
+		default:
+			if ret.IsZero() {
+				frame.Error = "internal failure"
+			}
+		}
+	}
+}
+
+// memoryCopy returns a copy of the given memory range, or nil if the range is
+// not (yet) backed by the memory.
+func memoryCopy(memory *vm.Memory, offset, size uint64) []byte {
+	if size == 0 || offset+size < offset || offset+size > uint64(memory.Len()) {
+		return nil
+	}
+	return memory.GetCopy(int64(offset), int64(size))
+}
@@ -0,0 +1,108 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
+	"github.com/CortexFoundation/CortexTheseus/core/state"
+	"github.com/CortexFoundation/CortexTheseus/core/vm"
+	"github.com/CortexFoundation/CortexTheseus/core/vm/runtime"
+)
+
+// Tests that the native call tracer records nested calls with their value and
+// trace address, and flags failed calls and the calls they reverted.
+func TestCallTracer(t *testing.T) {
+	var (
+		origin = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+		outer  = common.HexToAddress("0x00000000000000000000000000000000000000bb")
+		inner  = common.HexToAddress("0x00000000000000000000000000000000000000cc")
+		leaf   = common.HexToAddress("0x00000000000000000000000000000000000000dd")
+	)
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	statedb.AddBalance(outer, big.NewInt(100))
+
+	// outer: CALL(gas, inner, 7, 0, 0, 0, 0), STOP
+	statedb.SetCode(outer, append(callCode(inner, 7), byte(vm.STOP)))
+	// inner: CALL(gas, leaf, 0, 0, 0, 0, 0), REVERT(0, 0)
+	statedb.SetCode(inner, append(callCode(leaf, 0), byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.REVERT)))
+	// leaf: STOP
+	statedb.SetCode(leaf, []byte{byte(vm.STOP)})
+
+	tracer := NewCallTracer()
+	_, _, err := runtime.Call(outer, nil, &runtime.Config{
+		Origin:    origin,
+		GasLimit:  1000000,
+		State:     statedb,
+		CVMConfig: vm.Config{Debug: true, Tracer: tracer},
+	})
+	if err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	frames := tracer.Frames()
+	if len(frames) != 3 {
+		t.Fatalf("frame count mismatch: have %d, want 3", len(frames))
+	}
+	want := []struct {
+		from, to  common.Address
+		value     int64
+		addr      []int
+		subtraces int
+		err       string
+	}{
+		{origin, outer, 0, []int{}, 1, ""},
+		{outer, inner, 7, []int{0}, 1, "internal failure"},
+		{inner, leaf, 0, []int{0, 0}, 0, "parent reverted"},
+	}
+	for i, w := range want {
+		f := frames[i]
+		if f.From != w.from || f.To != w.to {
+			t.Errorf("frame %d: route mismatch: have %x->%x, want %x->%x", i, f.From, f.To, w.from, w.to)
+		}
+		if f.Value.ToInt().Int64() != w.value {
+			t.Errorf("frame %d: value mismatch: have %v, want %d", i, f.Value, w.value)
+		}
+		if !reflect.DeepEqual(f.TraceAddress, w.addr) {
+			t.Errorf("frame %d: trace address mismatch: have %v, want %v", i, f.TraceAddress, w.addr)
+		}
+		if f.Subtraces != w.subtraces {
+			t.Errorf("frame %d: subtraces mismatch: have %d, want %d", i, f.Subtraces, w.subtraces)
+		}
+		if f.Error != w.err {
+			t.Errorf("frame %d: error mismatch: have %q, want %q", i, f.Error, w.err)
+		}
+	}
+}
+
+// callCode returns contract code calling the given address with value and no
+// input, leaving the call result on the stack.
+func callCode(to common.Address, value byte) []byte {
+	code := []byte{
+		byte(vm.PUSH1), 0, // retSize
+		byte(vm.PUSH1), 0, // retOffset
+		byte(vm.PUSH1), 0, // inSize
+		byte(vm.PUSH1), 0, // inOffset
+		byte(vm.PUSH1), value,
+		byte(vm.PUSH20),
+	}
+	code = append(code, to.Bytes()...)
+	return append(code, byte(vm.PUSH3), 0x01, 0x00, 0x00, byte(vm.CALL))
+}
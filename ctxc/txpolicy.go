@@ -0,0 +1,165 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package ctxc
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/common/mclock"
+	"github.com/CortexFoundation/CortexTheseus/core"
+	"github.com/CortexFoundation/CortexTheseus/core/state"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+)
+
+// TxPropagationConfig decides which transactions are broadcast in full to
+// remote peers and which are only announced by hash, leaving it up to the
+// peers to retrieve them if needed.
+type TxPropagationConfig struct {
+	BroadcastPeers   int                // Maximum number of peers a transaction is broadcast to in full (0 = square root of the peer count)
+	MaxBroadcastSize common.StorageSize // Transactions larger than this are only announced (0 = no limit)
+	AnnounceUploads  bool               // Whether file upload progress transactions are only announced
+	PeerBudget       common.StorageSize // Full transaction bytes broadcast to a single peer per second (0 = no limit)
+}
+
+// DefaultTxPropagationConfig contains the default transaction propagation
+// policy. Transfers are broadcast as before, while uploads and large inference
+// payloads are left to the announcement mechanism.
+var DefaultTxPropagationConfig = TxPropagationConfig{
+	MaxBroadcastSize: 4 * 1024,
+	AnnounceUploads:  true,
+	PeerBudget:       512 * 1024,
+}
+
+// broadcastPeers returns the number of peers, out of the given ones not yet
+// knowing about a transaction, that should receive it in full.
+func (c *TxPropagationConfig) broadcastPeers(peers int) int {
+	n := int(math.Sqrt(float64(peers)))
+	if c.BroadcastPeers > 0 {
+		n = c.BroadcastPeers
+	}
+	if n > peers {
+		n = peers
+	}
+	return n
+}
+
+// broadcastFilter returns a function reporting whether a transaction may be
+// broadcast in full, marking the policy meters for the ones that may not.
+func (pm *ProtocolManager) broadcastFilter() func(tx *types.Transaction) bool {
+	return func(tx *types.Transaction) bool {
+		if limit := pm.txPropagation.MaxBroadcastSize; limit > 0 && tx.Size() > limit {
+			txPolicyOversizedMeter.Mark(1)
+			return false
+		}
+		if pm.txPropagation.AnnounceUploads && tx.To() != nil && tx.Value().Sign() == 0 && pm.uploads.uploading(*tx.To()) {
+			txPolicyUploadMeter.Mark(1)
+			return false
+		}
+		return true
+	}
+}
+
+// uploadStatus caches whether addresses have a file upload in progress as of
+// the head block, so broadcasting transactions doesn't open a new state for
+// every batch. The cache is dropped whenever the head block changes.
+type uploadStatus struct {
+	chain  *core.BlockChain
+	head   common.Hash             // Head block the cached statuses belong to
+	state  *state.StateDB          // State of the head block, opened on first use
+	status map[common.Address]bool // Upload status of the addresses looked up
+	lock   sync.Mutex
+}
+
+// newUploadStatus creates an upload status cache tracking the head of the chain.
+func newUploadStatus(chain *core.BlockChain) *uploadStatus {
+	return &uploadStatus{
+		chain:  chain,
+		status: make(map[common.Address]bool),
+	}
+}
+
+// uploading reports whether the address has an upload in progress in the state
+// of the current head block.
+func (u *uploadStatus) uploading(addr common.Address) bool {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	head := u.chain.CurrentBlock()
+	if hash := head.Hash(); hash != u.head {
+		u.head, u.state, u.status = hash, nil, make(map[common.Address]bool)
+	}
+	if status, ok := u.status[addr]; ok {
+		return status
+	}
+	if u.state == nil {
+		state, err := u.chain.StateAt(head.Root())
+		if err != nil {
+			return false
+		}
+		u.state = state
+	}
+	status := u.state.Uploading(addr)
+	u.status[addr] = status
+	return status
+}
+
+// txBudget is a token bucket limiting the bytes of full transactions broadcast
+// to a single peer. Transactions over the budget are only announced.
+type txBudget struct {
+	rate  float64 // Bytes refilled per second, zero if the budget is unlimited
+	avail float64 // Bytes currently available for broadcasting
+	last  mclock.AbsTime
+	clock mclock.Clock
+	lock  sync.Mutex
+}
+
+// newTxBudget creates a per-peer byte budget refilled at the given rate,
+// allowing a burst of up to one second worth of traffic.
+func newTxBudget(rate common.StorageSize, clock mclock.Clock) *txBudget {
+	return &txBudget{
+		rate:  float64(rate),
+		avail: float64(rate),
+		last:  clock.Now(),
+		clock: clock,
+	}
+}
+
+// take consumes size bytes from the budget, reporting whether there were
+// enough available.
+func (b *txBudget) take(size common.StorageSize) bool {
+	if b == nil || b.rate == 0 {
+		return true
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := b.clock.Now()
+	b.avail += b.rate * time.Duration(now-b.last).Seconds()
+	if b.avail > b.rate {
+		b.avail = b.rate
+	}
+	b.last = now
+
+	if float64(size) > b.avail {
+		return false
+	}
+	b.avail -= float64(size)
+	return true
+}
@@ -0,0 +1,119 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package ctxc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/common/mclock"
+	"github.com/CortexFoundation/CortexTheseus/consensus/cuckoo"
+	"github.com/CortexFoundation/CortexTheseus/core"
+	"github.com/CortexFoundation/CortexTheseus/ctxc/downloader"
+	"github.com/CortexFoundation/CortexTheseus/params"
+)
+
+func TestTxBroadcastPeers(t *testing.T) {
+	tests := []struct {
+		config TxPropagationConfig
+		peers  int
+		want   int
+	}{
+		{TxPropagationConfig{}, 0, 0},
+		{TxPropagationConfig{}, 1, 1},
+		{TxPropagationConfig{}, 16, 4},
+		{TxPropagationConfig{}, 50, 7},
+		{TxPropagationConfig{BroadcastPeers: 3}, 50, 3},
+		{TxPropagationConfig{BroadcastPeers: 3}, 2, 2},
+	}
+	for i, tt := range tests {
+		if have := tt.config.broadcastPeers(tt.peers); have != tt.want {
+			t.Errorf("test %d: broadcast peers mismatch: have %d, want %d", i, have, tt.want)
+		}
+	}
+}
+
+func TestTxBudget(t *testing.T) {
+	clock := new(mclock.Simulated)
+	budget := newTxBudget(1000, clock)
+
+	if !budget.take(600) {
+		t.Fatal("initial take rejected")
+	}
+	if budget.take(600) {
+		t.Fatal("take over budget accepted")
+	}
+	clock.Run(200 * time.Millisecond)
+	if !budget.take(600) {
+		t.Fatal("take after refill rejected")
+	}
+	// The budget must not accumulate beyond one second worth of traffic.
+	clock.Run(time.Hour)
+	if budget.take(1001) {
+		t.Fatal("take over burst accepted")
+	}
+	// A zero rate disables the budget.
+	if !newTxBudget(0, clock).take(1 << 20) {
+		t.Fatal("unlimited budget rejected take")
+	}
+}
+
+func TestTxBroadcastFilter(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	defer pm.Stop()
+
+	small := newTestTransaction(testAccount, 0, 0)
+	large := newTestTransaction(testAccount, 1, 8192)
+
+	pm.txPropagation = TxPropagationConfig{}
+	if filter := pm.broadcastFilter(); !filter(small) || !filter(large) {
+		t.Fatal("unlimited policy rejected transaction")
+	}
+	pm.txPropagation = TxPropagationConfig{MaxBroadcastSize: 4096}
+	if filter := pm.broadcastFilter(); !filter(small) || filter(large) {
+		t.Fatal("size limit not applied")
+	}
+}
+
+func TestUploadStatusCache(t *testing.T) {
+	pm, db := newTestProtocolManagerMust(t, downloader.FullSync, 1, nil, nil)
+	defer pm.Stop()
+
+	addr := common.Address{0xaa}
+	if pm.uploads.uploading(addr) {
+		t.Fatal("upload reported for plain account")
+	}
+	head, state := pm.uploads.head, pm.uploads.state
+	if head != pm.blockchain.CurrentBlock().Hash() || state == nil {
+		t.Fatal("head state not cached")
+	}
+	// Lookups on the same head must reuse the cached state
+	pm.uploads.uploading(common.Address{0xbb})
+	if pm.uploads.state != state || len(pm.uploads.status) != 2 {
+		t.Fatalf("cached state not reused: %d statuses", len(pm.uploads.status))
+	}
+	// A new head block must drop the cached statuses
+	blocks, _ := core.GenerateChain(params.TestChainConfig, pm.blockchain.CurrentBlock(), cuckoo.NewFaker(), db, 1, nil)
+	if _, err := pm.blockchain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert block: %v", err)
+	}
+	pm.uploads.uploading(addr)
+	if pm.uploads.head != blocks[0].Hash() || pm.uploads.state == state || len(pm.uploads.status) != 1 {
+		t.Fatal("cache not refreshed on new head")
+	}
+}
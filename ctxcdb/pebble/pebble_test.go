@@ -0,0 +1,80 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+//go:build arm64 || amd64
+// +build arm64 amd64
+
+package pebble
+
+import (
+	"testing"
+
+	"github.com/CortexFoundation/CortexTheseus/ctxcdb"
+	"github.com/CortexFoundation/CortexTheseus/ctxcdb/dbtest"
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+func TestPebbleDB(t *testing.T) {
+	t.Run("DatabaseSuite", func(t *testing.T) {
+		dbtest.TestDatabaseSuite(t, func() ctxcdb.KeyValueStore {
+			db, err := pebble.Open("", &pebble.Options{
+				FS: vfs.NewMem(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return &Database{
+				db: db,
+			}
+		})
+	})
+}
+
+// Tests that a database can be opened, written, compacted and reopened on disk,
+// and that the stats are retrievable.
+func TestPebbleDBReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := New(dir, 0, 0, "", false)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if err := db.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if err := db.Compact(nil, nil); err != nil {
+		t.Fatalf("failed to compact: %v", err)
+	}
+	if stats, err := db.Stat("pebble.metrics"); err != nil || stats == "" {
+		t.Fatalf("failed to retrieve stats: %q, %v", stats, err)
+	}
+	if _, err := db.Stat("leveldb.stats"); err == nil {
+		t.Fatalf("unknown property accepted")
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close database: %v", err)
+	}
+	db, err = New(dir, 0, 0, "", true)
+	if err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	defer db.Close()
+
+	if val, err := db.Get([]byte("key")); err != nil || string(val) != "value" {
+		t.Fatalf("value mismatch: have %q, %v, want %q", val, err, "value")
+	}
+}
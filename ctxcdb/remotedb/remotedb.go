@@ -0,0 +1,290 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package remotedb implements a read-only database proxying all reads to a
+// running node over the debug_db* RPC methods.
+//
+// The remote node must be started with the raw database API enabled. All
+// write operations fail, making the database suitable for inspection tools
+// operating on the data of a live node.
+package remotedb
+
+import (
+	"errors"
+
+	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
+	"github.com/CortexFoundation/CortexTheseus/ctxcdb"
+	"github.com/CortexFoundation/CortexTheseus/rpc"
+)
+
+// iteratorBatchSize is the number of entries requested from the remote node
+// in a single iteration round trip.
+const iteratorBatchSize = 1024
+
+// errReadOnly is returned if a write operation is attempted on the database.
+var errReadOnly = errors.New("remote database is read-only")
+
+// Database is a key-value lookup for a remote database via debug_db* RPCs.
+type Database struct {
+	remote *rpc.Client
+}
+
+// New creates a read-only database proxying all requests to the node behind
+// the given RPC client.
+func New(client *rpc.Client) ctxcdb.Database {
+	return &Database{remote: client}
+}
+
+// Dial connects to the node at the given endpoint and creates a read-only
+// database proxying all requests to it.
+func Dial(endpoint string) (ctxcdb.Database, error) {
+	client, err := rpc.Dial(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return New(client), nil
+}
+
+// Has retrieves if a key is present in the key-value store.
+func (db *Database) Has(key []byte) (bool, error) {
+	var has bool
+	if err := db.remote.Call(&has, "debug_dbHas", hexutil.Bytes(key)); err != nil {
+		return false, err
+	}
+	return has, nil
+}
+
+// Get retrieves the given key if it's present in the key-value store.
+func (db *Database) Get(key []byte) ([]byte, error) {
+	var resp hexutil.Bytes
+	if err := db.remote.Call(&resp, "debug_dbGet", hexutil.Bytes(key)); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// HasAncient returns an indicator whether the specified data exists in the
+// ancient store.
+func (db *Database) HasAncient(kind string, number uint64) (bool, error) {
+	if _, err := db.Ancient(kind, number); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Ancient retrieves an ancient binary blob from the append-only immutable files.
+func (db *Database) Ancient(kind string, number uint64) ([]byte, error) {
+	var resp hexutil.Bytes
+	if err := db.remote.Call(&resp, "debug_dbAncient", kind, number); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Ancients returns the ancient item numbers in the ancient store.
+func (db *Database) Ancients() (uint64, error) {
+	var resp uint64
+	err := db.remote.Call(&resp, "debug_dbAncients")
+	return resp, err
+}
+
+// AncientSize returns the ancient size of the specified category.
+func (db *Database) AncientSize(kind string) (uint64, error) {
+	var resp uint64
+	err := db.remote.Call(&resp, "debug_dbAncientSize", kind)
+	return resp, err
+}
+
+// Put is not supported by the read-only remote database.
+func (db *Database) Put(key []byte, value []byte) error {
+	return errReadOnly
+}
+
+// Delete is not supported by the read-only remote database.
+func (db *Database) Delete(key []byte) error {
+	return errReadOnly
+}
+
+// AppendAncient is not supported by the read-only remote database.
+func (db *Database) AppendAncient(number uint64, hash, header, body, receipts, td []byte) error {
+	return errReadOnly
+}
+
+// TruncateAncients is not supported by the read-only remote database.
+func (db *Database) TruncateAncients(n uint64) error {
+	return errReadOnly
+}
+
+// Sync is a noop, there is nothing to flush in a read-only database.
+func (db *Database) Sync() error {
+	return nil
+}
+
+// NewBatch creates a batch which fails to write, as the remote database is
+// read-only.
+func (db *Database) NewBatch() ctxcdb.Batch {
+	return new(batch)
+}
+
+// NewIterator creates a binary-alphabetical iterator over a subset of the
+// remote database content with a particular key prefix, starting at a
+// particular initial key (or after, if it does not exist). The entries are
+// retrieved from the remote node in batches as the iteration progresses.
+func (db *Database) NewIterator(prefix []byte, start []byte) ctxcdb.Iterator {
+	return &iterator{
+		db:     db,
+		prefix: prefix,
+		next:   start,
+		more:   true,
+		index:  -1,
+	}
+}
+
+// Stat is not supported by the remote database.
+func (db *Database) Stat(property string) (string, error) {
+	return "", errors.New("stats not supported by the remote database")
+}
+
+// Compact is not supported by the read-only remote database.
+func (db *Database) Compact(start []byte, limit []byte) error {
+	return errReadOnly
+}
+
+// Close closes the connection to the remote node.
+func (db *Database) Close() error {
+	db.remote.Close()
+	return nil
+}
+
+// batch is a write-only batch which can't be flushed into the read-only
+// remote database. It only tracks the size of the queued data.
+type batch struct {
+	size int
+}
+
+// Put inserts the given value into the batch for later committing.
+func (b *batch) Put(key, value []byte) error {
+	b.size += len(value)
+	return nil
+}
+
+// Delete inserts the a key removal into the batch for later committing.
+func (b *batch) Delete(key []byte) error {
+	b.size++
+	return nil
+}
+
+// ValueSize retrieves the amount of data queued up for writing.
+func (b *batch) ValueSize() int {
+	return b.size
+}
+
+// Write fails, as the remote database is read-only.
+func (b *batch) Write() error {
+	return errReadOnly
+}
+
+// Reset resets the batch for reuse.
+func (b *batch) Reset() {
+	b.size = 0
+}
+
+// Replay fails, as the batch doesn't retain the queued data.
+func (b *batch) Replay(w ctxcdb.KeyValueWriter) error {
+	return errReadOnly
+}
+
+// rangeResult is a batch of consecutive entries retrieved from the remote node.
+type rangeResult struct {
+	Keys   []hexutil.Bytes `json:"keys"`
+	Values []hexutil.Bytes `json:"values"`
+	Next   hexutil.Bytes   `json:"next,omitempty"`
+}
+
+// iterator is an iterator over the entries of the remote database, retrieving
+// them batch by batch.
+type iterator struct {
+	db     *Database
+	prefix []byte
+	next   []byte // Start key (relative to the prefix) of the next batch
+	more   bool   // Whether there are more batches to retrieve
+
+	batch *rangeResult
+	index int
+	err   error
+}
+
+// Next moves the iterator to the next key/value pair. It returns whether the
+// iterator is exhausted.
+func (it *iterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if it.batch != nil && it.index+1 < len(it.batch.Keys) {
+		it.index++
+		return true
+	}
+	if !it.more {
+		it.batch, it.index = nil, -1
+		return false
+	}
+	res := new(rangeResult)
+	if err := it.db.remote.Call(res, "debug_dbIterate", hexutil.Bytes(it.prefix), hexutil.Bytes(it.next), iteratorBatchSize); err != nil {
+		it.err = err
+		it.batch, it.index = nil, -1
+		return false
+	}
+	if len(res.Keys) != len(res.Values) {
+		it.err = errors.New("remote database returned mismatching keys and values")
+		it.batch, it.index = nil, -1
+		return false
+	}
+	it.batch, it.index = res, 0
+	it.next, it.more = res.Next, len(res.Next) > 0
+
+	if len(res.Keys) == 0 {
+		it.batch, it.index = nil, -1
+		return false
+	}
+	return true
+}
+
+// Error returns any accumulated error. Exhausting all the key/value pairs
+// is not considered to be an error.
+func (it *iterator) Error() error {
+	return it.err
+}
+
+// Key returns the key of the current key/value pair, or nil if done.
+func (it *iterator) Key() []byte {
+	if it.batch == nil || it.index < 0 {
+		return nil
+	}
+	return it.batch.Keys[it.index]
+}
+
+// Value returns the value of the current key/value pair, or nil if done.
+func (it *iterator) Value() []byte {
+	if it.batch == nil || it.index < 0 {
+		return nil
+	}
+	return it.batch.Values[it.index]
+}
+
+// Release releases associated resources.
+func (it *iterator) Release() {
+	it.batch, it.index, it.more = nil, -1, false
+}
@@ -0,0 +1,371 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexFoundation library.
+//
+// The CortexFoundation library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexFoundation library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexFoundation library. If not, see <http://www.gnu.org/licenses/>.
+
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
+	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
+	"github.com/CortexFoundation/CortexTheseus/core/state"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/ctxcdb"
+	"github.com/CortexFoundation/CortexTheseus/node"
+	"github.com/CortexFoundation/CortexTheseus/rlp"
+	"github.com/CortexFoundation/CortexTheseus/rpc"
+	torrentfs "github.com/CortexFoundation/torrentfs/types"
+)
+
+func TestBuildSchema(t *testing.T) {
+	// Make sure the schema can be parsed and matched up to the object model.
+	if _, err := newHandler(nil); err != nil {
+		t.Errorf("Could not construct GraphQL handler: %v", err)
+	}
+}
+
+var (
+	testModelAddr = common.HexToAddress("0x1000000000000000000000000000000000000001")
+	testInputAddr = common.HexToAddress("0x1000000000000000000000000000000000000002")
+
+	testModelMeta = &torrentfs.ModelMeta{
+		Comment:       "resnet",
+		Hash:          common.HexToAddress("0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"),
+		RawSize:       1024,
+		InputShape:    []uint64{1, 28, 28},
+		OutputShape:   []uint64{10},
+		Gas:           5000,
+		AuthorAddress: common.HexToAddress("0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"),
+		BlockNum:      *big.NewInt(7),
+	}
+	testInputMeta = &torrentfs.InputMeta{
+		Comment:  "digit",
+		Hash:     common.HexToAddress("0xcccccccccccccccccccccccccccccccccccccccc"),
+		RawSize:  784,
+		Shape:    []uint64{1, 28, 28},
+		BlockNum: *big.NewInt(8),
+	}
+)
+
+// testBackend serves a single canonical block on top of a state holding a
+// model and an input account. Backend methods not used by the tests are left
+// unimplemented and panic.
+type testBackend struct {
+	Backend
+
+	db     ctxcdb.Database
+	header *types.Header
+	state  *state.StateDB
+	pool   map[common.Hash]*types.Transaction
+}
+
+func newTestBackend(t *testing.T) *testBackend {
+	db := rawdb.NewMemoryDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db), nil)
+
+	model, err := testModelMeta.ToBytes()
+	if err != nil {
+		t.Fatalf("failed to encode model meta: %v", err)
+	}
+	statedb.SetCode(testModelAddr, append([]byte{0, 1}, model...))
+	statedb.SetUpload(testModelAddr, big.NewInt(0))
+	statedb.SetNum(testModelAddr, big.NewInt(7))
+
+	input, err := testInputMeta.ToBytes()
+	if err != nil {
+		t.Fatalf("failed to encode input meta: %v", err)
+	}
+	statedb.SetCode(testInputAddr, append([]byte{0, 2}, input...))
+	statedb.SetUpload(testInputAddr, big.NewInt(512))
+	statedb.SetNum(testInputAddr, big.NewInt(8))
+
+	header := &types.Header{
+		Number:    big.NewInt(1),
+		Quota:     2048,
+		QuotaUsed: 1536,
+		Supply:    big.NewInt(1000),
+		Root:      statedb.IntermediateRoot(false),
+	}
+	header.Solution[0], header.Solution[41] = 3, 5
+	return &testBackend{db: db, header: header, state: statedb, pool: make(map[common.Hash]*types.Transaction)}
+}
+
+func (b *testBackend) ChainDb() ctxcdb.Database { return b.db }
+
+func (b *testBackend) HeaderByNumber(ctx context.Context, number rpc.BlockNumber) (*types.Header, error) {
+	if number == rpc.LatestBlockNumber || uint64(number) == b.header.Number.Uint64() {
+		return b.header, nil
+	}
+	return nil, nil
+}
+
+func (b *testBackend) HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error) {
+	if hash == b.header.Hash() {
+		return b.header, nil
+	}
+	return nil, nil
+}
+
+func (b *testBackend) StateAndHeaderByNumber(ctx context.Context, number rpc.BlockNumber) (*state.StateDB, *types.Header, error) {
+	header, err := b.HeaderByNumber(ctx, number)
+	if header == nil || err != nil {
+		return nil, nil, err
+	}
+	return b.state.Copy(), header, nil
+}
+
+func (b *testBackend) SendTx(ctx context.Context, tx *types.Transaction) error {
+	b.pool[tx.Hash()] = tx
+	return nil
+}
+
+func (b *testBackend) GetPoolTransaction(hash common.Hash) *types.Transaction {
+	return b.pool[hash]
+}
+
+// query runs a GraphQL request against the backend and decodes the result
+// into data, failing the test on any error.
+func query(t *testing.T, backend Backend, request string, data interface{}) {
+	t.Helper()
+
+	handler, err := newHandler(backend)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	body, _ := json.Marshal(map[string]string{"query": request})
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(string(body))))
+
+	var response struct {
+		Data   json.RawMessage
+		Errors []struct{ Message string }
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid response %s: %v", rec.Body, err)
+	}
+	if len(response.Errors) > 0 {
+		t.Fatalf("query failed: %v", response.Errors)
+	}
+	if err := json.Unmarshal(response.Data, data); err != nil {
+		t.Fatalf("invalid response data %s: %v", response.Data, err)
+	}
+}
+
+func TestBlockQuotaAndSolution(t *testing.T) {
+	var result struct {
+		Block struct {
+			Quota     hexutil.Uint64
+			QuotaUsed hexutil.Uint64
+			Supply    hexutil.Big
+			Solution  []hexutil.Uint64
+		}
+	}
+	query(t, newTestBackend(t), `{ block(number: 1) { quota quotaUsed supply solution } }`, &result)
+
+	if result.Block.Quota != 2048 || result.Block.QuotaUsed != 1536 {
+		t.Errorf("quota mismatch: have %d/%d, want 2048/1536", result.Block.Quota, result.Block.QuotaUsed)
+	}
+	if supply := (*big.Int)(&result.Block.Supply); supply.Cmp(big.NewInt(1000)) != 0 {
+		t.Errorf("supply mismatch: have %v, want 1000", supply)
+	}
+	if len(result.Block.Solution) != len(types.BlockSolution{}) {
+		t.Fatalf("solution length mismatch: have %d, want %d", len(result.Block.Solution), len(types.BlockSolution{}))
+	}
+	if result.Block.Solution[0] != 3 || result.Block.Solution[41] != 5 {
+		t.Errorf("solution mismatch: have %v", result.Block.Solution)
+	}
+}
+
+func TestAccountModelAndInput(t *testing.T) {
+	type modelMeta struct {
+		Comment       string
+		Hash          common.Address
+		RawSize       hexutil.Uint64
+		InputShape    []hexutil.Uint64
+		OutputShape   []hexutil.Uint64
+		Gas           hexutil.Uint64
+		AuthorAddress common.Address
+		BlockNum      hexutil.Big
+	}
+	type inputMeta struct {
+		Comment  string
+		Hash     common.Address
+		RawSize  hexutil.Uint64
+		Shape    []hexutil.Uint64
+		BlockNum hexutil.Big
+	}
+	type account struct {
+		Upload hexutil.Big
+		Num    hexutil.Big
+		Model  *modelMeta
+		Input  *inputMeta
+	}
+	var result struct {
+		Block struct {
+			ModelAccount account
+			InputAccount account
+		}
+	}
+	fields := `upload num model { comment hash rawSize inputShape outputShape gas authorAddress blockNum } input { comment hash rawSize shape blockNum }`
+	query(t, newTestBackend(t), `{ block {
+		modelAccount: account(address: "`+testModelAddr.Hex()+`") { `+fields+` }
+		inputAccount: account(address: "`+testInputAddr.Hex()+`") { `+fields+` }
+	} }`, &result)
+
+	model := result.Block.ModelAccount
+	if (*big.Int)(&model.Upload).Sign() != 0 || (*big.Int)(&model.Num).Int64() != 7 {
+		t.Errorf("model account upload/num mismatch: have %v/%v, want 0/7", (*big.Int)(&model.Upload), (*big.Int)(&model.Num))
+	}
+	if model.Input != nil {
+		t.Errorf("model account decoded as input: %+v", model.Input)
+	}
+	want := modelMeta{
+		Comment:       testModelMeta.Comment,
+		Hash:          testModelMeta.Hash,
+		RawSize:       1024,
+		InputShape:    []hexutil.Uint64{1, 28, 28},
+		OutputShape:   []hexutil.Uint64{10},
+		Gas:           5000,
+		AuthorAddress: testModelMeta.AuthorAddress,
+		BlockNum:      hexutil.Big(*big.NewInt(7)),
+	}
+	if model.Model == nil || !reflect.DeepEqual(*model.Model, want) {
+		t.Errorf("model meta mismatch: have %+v, want %+v", model.Model, want)
+	}
+
+	input := result.Block.InputAccount
+	if (*big.Int)(&input.Upload).Int64() != 512 || (*big.Int)(&input.Num).Int64() != 8 {
+		t.Errorf("input account upload/num mismatch: have %v/%v, want 512/8", (*big.Int)(&input.Upload), (*big.Int)(&input.Num))
+	}
+	if input.Model != nil {
+		t.Errorf("input account decoded as model: %+v", input.Model)
+	}
+	wantInput := inputMeta{
+		Comment:  testInputMeta.Comment,
+		Hash:     testInputMeta.Hash,
+		RawSize:  784,
+		Shape:    []hexutil.Uint64{1, 28, 28},
+		BlockNum: hexutil.Big(*big.NewInt(8)),
+	}
+	if input.Input == nil || !reflect.DeepEqual(*input.Input, wantInput) {
+		t.Errorf("input meta mismatch: have %+v, want %+v", input.Input, wantInput)
+	}
+}
+
+func TestSendRawTransaction(t *testing.T) {
+	backend := newTestBackend(t)
+
+	key, _ := crypto.GenerateKey()
+	model, _ := testModelMeta.ToBytes()
+	tx := types.NewContractCreation(0, big.NewInt(0), 1000000, big.NewInt(1), append([]byte{0, 1}, model...))
+	tx, err := types.SignTx(tx, types.HomesteadSigner{}, key)
+	if err != nil {
+		t.Fatalf("failed to sign transaction: %v", err)
+	}
+	raw, _ := rlp.EncodeToBytes(tx)
+
+	var sent struct{ SendRawTransaction common.Hash }
+	query(t, backend, `mutation { sendRawTransaction(data: "`+hexutil.Encode(raw)+`") }`, &sent)
+	if sent.SendRawTransaction != tx.Hash() {
+		t.Fatalf("hash mismatch: have %x, want %x", sent.SendRawTransaction, tx.Hash())
+	}
+	if backend.pool[tx.Hash()] == nil {
+		t.Fatal("transaction not submitted to the backend")
+	}
+	// The pending transaction must be queryable with its model metadata
+	var pending struct {
+		Transaction struct {
+			Model struct{ Comment string }
+		}
+	}
+	query(t, backend, `{ transaction(hash: "`+tx.Hash().Hex()+`") { model { comment } } }`, &pending)
+	if pending.Transaction.Model.Comment != testModelMeta.Comment {
+		t.Errorf("model comment mismatch: have %q, want %q", pending.Transaction.Model.Comment, testModelMeta.Comment)
+	}
+}
+
+// Tests that GraphQL queries served on the HTTP RPC endpoint are rejected for
+// tokens which don't allow the ctxc namespace.
+func TestRestrictedToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graphql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	secret := bytes.Repeat([]byte{0x01}, 32)
+	if err := ioutil.WriteFile(filepath.Join(dir, "jwtsecret"), []byte(common.Bytes2Hex(secret)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	stack, err := node.New(&node.Config{
+		DataDir:          dir,
+		HTTPHost:         "127.0.0.1",
+		HTTPVirtualHosts: []string{"*"},
+		JWTSecret:        filepath.Join(dir, "jwtsecret"),
+	})
+	if err != nil {
+		t.Fatalf("failed to create node: %v", err)
+	}
+	backend := newTestBackend(t)
+	if err := stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
+		return New(ctx, backend, nil, []string{"*"})
+	}); err != nil {
+		t.Fatalf("failed to register GraphQL service: %v", err)
+	}
+	if err := stack.Start(); err != nil {
+		t.Fatalf("failed to start node: %v", err)
+	}
+	defer stack.Stop()
+
+	post := func(namespaces []string) int {
+		claims, _ := json.Marshal(map[string]interface{}{"namespaces": namespaces})
+		unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + base64.RawURLEncoding.EncodeToString(claims)
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(unsigned))
+
+		req, _ := http.NewRequest(http.MethodPost, "http://"+stack.HTTPEndpoint()+"/graphql", strings.NewReader(`{"query": "{ block { number } }"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+unsigned+"."+base64.RawURLEncoding.EncodeToString(mac.Sum(nil)))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := post([]string{"net", "web3"}); code != http.StatusForbidden {
+		t.Errorf("token without ctxc: status mismatch: have %d, want %d", code, http.StatusForbidden)
+	}
+	if code := post([]string{"ctxc"}); code != http.StatusOK {
+		t.Errorf("token with ctxc: status mismatch: have %d, want %d", code, http.StatusOK)
+	}
+}
@@ -104,6 +104,9 @@ type SyncProgress struct {
 	HighestBlock  uint64 // Highest alleged block number in the chain
 	PulledStates  uint64 // Number of state trie entries already downloaded
 	KnownStates   uint64 // Total number of state trie entries known about
+	SampledSeals  uint64 // Number of header seals verified before import during checkpoint sync
+	VerifiedSeals uint64 // Number of header seals fully verified in the background
+	KnownSeals    uint64 // Total number of header seals scheduled for full verification
 }
 
 // ChainSyncReader wraps access to the node's current sync status. If there's no
@@ -66,6 +66,59 @@ func (s *PublicCortexAPI) GasPrice(ctx context.Context) (*hexutil.Big, error) {
 	return (*hexutil.Big)(price), err
 }
 
+// GasPrices is the per transaction class gas price recommendation returned by
+// ctxc_gasPrices.
+type GasPrices struct {
+	Transfer *hexutil.Big `json:"transfer"`
+	Call     *hexutil.Big `json:"call"`
+	Upload   *hexutil.Big `json:"upload"`
+}
+
+// GasPrices returns separate gas price suggestions for plain transfers, contract
+// calls and upload transactions, since these compete for different resources.
+func (s *PublicCortexAPI) GasPrices(ctx context.Context) (*GasPrices, error) {
+	prices, err := s.b.SuggestPrices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &GasPrices{
+		Transfer: (*hexutil.Big)(prices.Transfer),
+		Call:     (*hexutil.Big)(prices.Call),
+		Upload:   (*hexutil.Big)(prices.Upload),
+	}, nil
+}
+
+type feeHistoryResult struct {
+	OldestBlock    *hexutil.Big     `json:"oldestBlock"`
+	Reward         [][]*hexutil.Big `json:"reward,omitempty"`
+	GasUsedRatio   []float64        `json:"gasUsedRatio"`
+	QuotaUsedRatio []float64        `json:"quotaUsedRatio"`
+}
+
+// FeeHistory returns the gas used ratio, the quota used ratio and the requested
+// gas price percentiles for a range of blocks ending at lastBlock.
+func (s *PublicCortexAPI) FeeHistory(ctx context.Context, blockCount rpc.DecimalOrHex, lastBlock rpc.BlockNumber, rewardPercentiles []float64) (*feeHistoryResult, error) {
+	oldest, reward, gasUsed, quotaUsed, err := s.b.FeeHistory(ctx, int(blockCount), lastBlock, rewardPercentiles)
+	if err != nil {
+		return nil, err
+	}
+	results := &feeHistoryResult{
+		OldestBlock:    (*hexutil.Big)(oldest),
+		GasUsedRatio:   gasUsed,
+		QuotaUsedRatio: quotaUsed,
+	}
+	if reward != nil {
+		results.Reward = make([][]*hexutil.Big, len(reward))
+		for i, w := range reward {
+			results.Reward[i] = make([]*hexutil.Big, len(w))
+			for j, v := range w {
+				results.Reward[i][j] = (*hexutil.Big)(v)
+			}
+		}
+	}
+	return results, nil
+}
+
 // ProtocolVersion returns the current Cortex protocol version this node supports
 func (s *PublicCortexAPI) ProtocolVersion() hexutil.Uint {
 	return hexutil.Uint(s.b.ProtocolVersion())
@@ -78,6 +131,9 @@ func (s *PublicCortexAPI) ProtocolVersion() hexutil.Uint {
 // - highestBlock:  block number of the highest block header this node has received from peers
 // - pulledStates:  number of state entries processed until now
 // - knownStates:   number of known state entries that still need to be pulled
+// - sampledSeals:  number of header seals verified before import during checkpoint sync
+// - verifiedSeals: number of header seals fully verified in the background
+// - knownSeals:    number of header seals scheduled for full verification
 func (s *PublicCortexAPI) Syncing() (interface{}, error) {
 	progress := s.b.Downloader().Progress()
 
@@ -92,6 +148,9 @@ func (s *PublicCortexAPI) Syncing() (interface{}, error) {
 		"highestBlock":  hexutil.Uint64(progress.HighestBlock),
 		"pulledStates":  hexutil.Uint64(progress.PulledStates),
 		"knownStates":   hexutil.Uint64(progress.KnownStates),
+		"sampledSeals":  hexutil.Uint64(progress.SampledSeals),
+		"verifiedSeals": hexutil.Uint64(progress.VerifiedSeals),
+		"knownSeals":    hexutil.Uint64(progress.KnownSeals),
 	}, nil
 }
 
@@ -698,17 +757,18 @@ type CallArgs struct {
 	Data     hexutil.Bytes   `json:"data"`
 }
 
-func (s *PublicBlockChainAPI) doCall(ctx context.Context, args CallArgs, blockNr rpc.BlockNumber, vmCfg vm.Config, timeout time.Duration) ([]byte, uint64, bool, error) {
+// DoCall executes the given call message on the state of the given block.
+func DoCall(ctx context.Context, b Backend, args CallArgs, blockNr rpc.BlockNumber, vmCfg vm.Config, timeout time.Duration) ([]byte, uint64, bool, error) {
 	defer func(start time.Time) { log.Debug("Executing CVM call finished", "runtime", time.Since(start)) }(time.Now())
 
-	state, header, err := s.b.StateAndHeaderByNumber(ctx, blockNr)
+	state, header, err := b.StateAndHeaderByNumber(ctx, blockNr)
 	if state == nil || err != nil {
 		return nil, 0, false, err
 	}
 	// Set sender address or use a default if none specified
 	addr := args.From
 	if addr == (common.Address{}) {
-		if wallets := s.b.AccountManager().Wallets(); len(wallets) > 0 {
+		if wallets := b.AccountManager().Wallets(); len(wallets) > 0 {
 			if accounts := wallets[0].Accounts(); len(accounts) > 0 {
 				addr = accounts[0].Address
 			}
@@ -740,7 +800,7 @@ func (s *PublicBlockChainAPI) doCall(ctx context.Context, args CallArgs, blockNr
 
 	// Get a new instance of the CVM.
 	vmCfg.CallFakeVM = true
-	cvm, vmError, err := s.b.GetCVM(ctx, msg, state, header, vmCfg)
+	cvm, vmError, err := b.GetCVM(ctx, msg, state, header, vmCfg)
 	if err != nil {
 		return nil, 0, false, err
 	}
@@ -771,19 +831,19 @@ func (s *PublicBlockChainAPI) doCall(ctx context.Context, args CallArgs, blockNr
 // Call executes the given transaction on the state for the given block number.
 // It doesn't make and changes in the state/blockchain and is useful to execute and retrieve values.
 func (s *PublicBlockChainAPI) Call(ctx context.Context, args CallArgs, blockNr rpc.BlockNumber) (hexutil.Bytes, error) {
-	result, _, _, err := s.doCall(ctx, args, blockNr, vm.Config{}, 5*time.Second)
+	result, _, _, err := DoCall(ctx, s.b, args, blockNr, vm.Config{}, 5*time.Second)
 	return result, err
 }
 
 // same as Call, except for RPC_GetInternalTransaction flag with overwritten returns.
 func (s *PublicBlockChainAPI) GetInternalTransaction(ctx context.Context, args CallArgs, blockNr rpc.BlockNumber) (string, error) {
-	result, _, _, err := s.doCall(ctx, args, blockNr, vm.Config{RPC_GetInternalTransaction: true}, 5*time.Second)
+	result, _, _, err := DoCall(ctx, s.b, args, blockNr, vm.Config{RPC_GetInternalTransaction: true}, 5*time.Second)
 	return (string)(result), err
 }
 
-// EstimateGas returns an estimate of the amount of gas needed to execute the
-// given transaction against the current pending block.
-func (s *PublicBlockChainAPI) EstimateGas(ctx context.Context, args CallArgs) (hexutil.Uint64, error) {
+// DoEstimateGas returns an estimate of the amount of gas needed to execute the
+// given transaction against the state of the given block.
+func DoEstimateGas(ctx context.Context, b Backend, args CallArgs, blockNr rpc.BlockNumber) (hexutil.Uint64, error) {
 	// Binary search the gas requirement, as it may be higher than the amount used
 	var (
 		lo  uint64 = params.TxGas - 1
@@ -794,7 +854,7 @@ func (s *PublicBlockChainAPI) EstimateGas(ctx context.Context, args CallArgs) (h
 		hi = uint64(args.Gas)
 	} else {
 		// Retrieve the current pending block to act as the gas ceiling
-		block, err := s.b.BlockByNumber(ctx, rpc.PendingBlockNumber)
+		block, err := b.BlockByNumber(ctx, blockNr)
 		if err != nil {
 			return 0, err
 		}
@@ -809,7 +869,7 @@ func (s *PublicBlockChainAPI) EstimateGas(ctx context.Context, args CallArgs) (h
 	executable := func(gas uint64) bool {
 		args.Gas = hexutil.Uint64(gas)
 
-		_, _, failed, err := s.doCall(ctx, args, rpc.PendingBlockNumber, vm.Config{}, 0)
+		_, _, failed, err := DoCall(ctx, b, args, blockNr, vm.Config{}, 0)
 		if err != nil || failed {
 			return false
 		}
@@ -833,6 +893,12 @@ func (s *PublicBlockChainAPI) EstimateGas(ctx context.Context, args CallArgs) (h
 	return hexutil.Uint64(hi), nil
 }
 
+// EstimateGas returns an estimate of the amount of gas needed to execute the
+// given transaction against the current pending block.
+func (s *PublicBlockChainAPI) EstimateGas(ctx context.Context, args CallArgs) (hexutil.Uint64, error) {
+	return DoEstimateGas(ctx, s.b, args, rpc.PendingBlockNumber)
+}
+
 // ExecutionResult groups all structured logs emitted by the CVM
 // while replaying a transaction in debug mode as well as transaction
 // execution status, the amount of gas used and the return value
@@ -0,0 +1,99 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package era
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+)
+
+// accumulatorDepth is the depth of the merkle tree of the header records of an
+// epoch, fitting MaxEra1Size leaves.
+const accumulatorDepth = 13
+
+// zeroHashes are the roots of the empty subtrees of each depth of the merkle tree.
+var zeroHashes = func() [accumulatorDepth + 1]common.Hash {
+	var hashes [accumulatorDepth + 1]common.Hash
+	for i := 1; i <= accumulatorDepth; i++ {
+		hashes[i] = sha256.Sum256(append(hashes[i-1][:], hashes[i-1][:]...))
+	}
+	return hashes
+}()
+
+// ComputeAccumulator calculates the accumulator root of an epoch, which is the
+// SSZ hash tree root of the list of header records:
+//
+//   HeaderRecord := { block-hash: Bytes32, total-difficulty: Uint256 }
+//   Accumulator  := List[HeaderRecord, MaxEra1Size]
+//
+// The root commits to both the canonical hashes and the total difficulties of
+// the epoch, allowing its history to be verified without the rest of the chain.
+func ComputeAccumulator(hashes []common.Hash, tds []*big.Int) (common.Hash, error) {
+	if len(hashes) != len(tds) {
+		return common.Hash{}, fmt.Errorf("must have equal number hashes as td values: %d != %d", len(hashes), len(tds))
+	}
+	if len(hashes) > MaxEra1Size {
+		return common.Hash{}, fmt.Errorf("too many records: have %d, max %d", len(hashes), MaxEra1Size)
+	}
+	// Hash every record into a leaf of the tree
+	level := make([]common.Hash, len(hashes))
+	for i := range hashes {
+		td, err := uint256LE(tds[i])
+		if err != nil {
+			return common.Hash{}, err
+		}
+		level[i] = sha256.Sum256(append(hashes[i].Bytes(), td[:]...))
+	}
+	// Merkleize the leaves, padding the missing ones with zero subtrees
+	for depth := 0; depth < accumulatorDepth; depth++ {
+		next := make([]common.Hash, (len(level)+1)/2)
+		for i := range next {
+			right := zeroHashes[depth]
+			if 2*i+1 < len(level) {
+				right = level[2*i+1]
+			}
+			next[i] = sha256.Sum256(append(level[2*i].Bytes(), right[:]...))
+		}
+		level = next
+	}
+	root := zeroHashes[accumulatorDepth]
+	if len(level) > 0 {
+		root = level[0]
+	}
+	// Mix in the length of the list
+	var length [32]byte
+	binary.LittleEndian.PutUint64(length[:], uint64(len(hashes)))
+	return sha256.Sum256(append(root.Bytes(), length[:]...)), nil
+}
+
+// uint256LE converts a non-negative big integer of at most 256 bits into its
+// little endian representation.
+func uint256LE(n *big.Int) ([32]byte, error) {
+	var le [32]byte
+	if n.Sign() < 0 || n.BitLen() > 256 {
+		return le, fmt.Errorf("total difficulty out of range: %v", n)
+	}
+	be := n.Bytes()
+	for i := range be {
+		le[i] = be[len(be)-1-i]
+	}
+	return le, nil
+}
@@ -0,0 +1,190 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package e2store implements the simple type-length-value container the era
+// archives are built upon.
+//
+// An e2store file is a sequence of entries, each of them being a header of 8
+// bytes followed by the value:
+//
+//   entry  := header | value
+//   header := type | length | reserved
+//
+// The type is a little endian uint16, the length of the value a little endian
+// uint32, and the reserved bytes are two zeroes.
+package e2store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	headerSize     = 8
+	valueSizeLimit = 1024 * 1024 * 50
+)
+
+var errReservedNonZero = errors.New("reserved bytes are non-zero")
+
+// Entry is a variable-length-data record in an e2store.
+type Entry struct {
+	Type  uint16
+	Value []byte
+}
+
+// Writer writes entries using e2store encoding.
+type Writer struct {
+	w io.Writer
+}
+
+// NewWriter returns a new Writer that writes to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w}
+}
+
+// Write writes a single e2store entry to w, returning the number of bytes
+// written including the header.
+func (w *Writer) Write(typ uint16, b []byte) (int, error) {
+	buf := make([]byte, headerSize)
+	binary.LittleEndian.PutUint16(buf, typ)
+	binary.LittleEndian.PutUint32(buf[2:], uint32(len(b)))
+
+	// Write header and value at once, to keep the entries atomic as far as
+	// possible for the underlying writer.
+	return w.w.Write(append(buf, b...))
+}
+
+// Reader reads entries from an e2store.
+type Reader struct {
+	r      io.ReaderAt
+	offset int64
+}
+
+// NewReader returns a new Reader that reads from r.
+func NewReader(r io.ReaderAt) *Reader {
+	return &Reader{r, 0}
+}
+
+// Read reads the next entry from the store.
+func (r *Reader) Read() (*Entry, error) {
+	var e Entry
+	n, err := r.ReadAt(&e, r.offset)
+	if err != nil {
+		return nil, err
+	}
+	r.offset += int64(n)
+	return &e, nil
+}
+
+// ReadAt reads the entry at the given offset into e, returning the number of
+// bytes read including the header.
+func (r *Reader) ReadAt(e *Entry, off int64) (int, error) {
+	typ, length, err := r.ReadMetadataAt(off)
+	if err != nil {
+		return 0, err
+	}
+	e.Type = typ
+
+	// Read the value, if there's any.
+	if length == 0 {
+		return headerSize, nil
+	}
+	e.Value = make([]byte, length)
+	if n, err := r.r.ReadAt(e.Value, off+headerSize); err != nil {
+		if err == io.EOF && n < int(length) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if err != io.EOF {
+			return 0, err
+		}
+	}
+	return headerSize + int(length), nil
+}
+
+// ReaderAt returns a reader of the value of the entry at the given offset,
+// along with the number of bytes the entry spans including the header. The
+// type of the entry must match the expected one.
+func (r *Reader) ReaderAt(expected uint16, off int64) (io.Reader, int, error) {
+	typ, length, err := r.ReadMetadataAt(off)
+	if err != nil {
+		return nil, 0, err
+	}
+	if typ != expected {
+		return nil, 0, fmt.Errorf("wrong type, want %d have %d", expected, typ)
+	}
+	return io.NewSectionReader(r.r, off+headerSize, int64(length)), headerSize + int(length), nil
+}
+
+// LengthAt reads the header at the given offset and returns the total length
+// of the entry, including the header.
+func (r *Reader) LengthAt(off int64) (int64, error) {
+	_, length, err := r.ReadMetadataAt(off)
+	if err != nil {
+		return 0, err
+	}
+	return headerSize + int64(length), nil
+}
+
+// ReadMetadataAt reads the header of the entry at the given offset and returns
+// the type and the length of its value.
+func (r *Reader) ReadMetadataAt(off int64) (typ uint16, length uint32, err error) {
+	b := make([]byte, headerSize)
+	if n, err := r.r.ReadAt(b, off); err != nil {
+		if err == io.EOF && n > 0 {
+			return 0, 0, io.ErrUnexpectedEOF
+		}
+		return 0, 0, err
+	}
+	typ = binary.LittleEndian.Uint16(b)
+	length = binary.LittleEndian.Uint32(b[2:])
+
+	// Check the reserved bytes of the header are zero.
+	if b[6] != 0 || b[7] != 0 {
+		return 0, 0, errReservedNonZero
+	}
+	if length > valueSizeLimit {
+		return 0, 0, fmt.Errorf("value too large: %d", length)
+	}
+	return typ, length, nil
+}
+
+// Find returns the first entry with the matching type.
+func (r *Reader) Find(want uint16) (*Entry, error) {
+	var (
+		off int64
+		typ uint16
+		n   int64
+		err error
+	)
+	for {
+		if typ, _, err = r.ReadMetadataAt(off); err != nil {
+			return nil, err
+		}
+		if typ == want {
+			var e Entry
+			if _, err := r.ReadAt(&e, off); err != nil {
+				return nil, err
+			}
+			return &e, nil
+		}
+		if n, err = r.LengthAt(off); err != nil {
+			return nil, err
+		}
+		off += n
+	}
+}
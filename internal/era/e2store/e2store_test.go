@@ -0,0 +1,158 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package e2store
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+)
+
+func TestEncode(t *testing.T) {
+	for _, test := range []struct {
+		entries []Entry
+		want    string
+		name    string
+	}{
+		{
+			name:    "emptyEntry",
+			entries: []Entry{{0xffff, nil}},
+			want:    "ffff000000000000",
+		},
+		{
+			name:    "beef",
+			entries: []Entry{{42, common.Hex2Bytes("beef")}},
+			want:    "2a00020000000000beef",
+		},
+		{
+			name: "twoEntries",
+			entries: []Entry{
+				{42, common.Hex2Bytes("beef")},
+				{9, common.Hex2Bytes("abcdabcd")},
+			},
+			want: "2a00020000000000beef0900040000000000abcdabcd",
+		},
+	} {
+		var (
+			b = bytes.NewBuffer(nil)
+			w = NewWriter(b)
+		)
+		for _, e := range test.entries {
+			if _, err := w.Write(e.Type, e.Value); err != nil {
+				t.Fatalf("%s: encoding error: %v", test.name, err)
+			}
+		}
+		if want, have := common.FromHex(test.want), b.Bytes(); !bytes.Equal(want, have) {
+			t.Fatalf("%s: encoding mismatch: have %x, want %x", test.name, have, want)
+		}
+		r := NewReader(bytes.NewReader(b.Bytes()))
+		for _, want := range test.entries {
+			have, err := r.Read()
+			if err != nil {
+				t.Fatalf("%s: decoding error: %v", test.name, err)
+			}
+			if have.Type != want.Type || !bytes.Equal(have.Value, want.Value) {
+				t.Fatalf("%s: decoding mismatch: have %v, want %v", test.name, have, want)
+			}
+		}
+		if _, err := r.Read(); err != io.EOF {
+			t.Fatalf("%s: missing end of stream: %v", test.name, err)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	for i, tt := range []struct {
+		have string
+		err  error
+	}{
+		{ // basic valid decoding
+			have: "ffff000000000000",
+		},
+		{ // basic invalid decoding
+			have: "ffff000000000001",
+			err:  errReservedNonZero,
+		},
+		{ // no more entries to read, returns EOF
+			have: "",
+			err:  io.EOF,
+		},
+		{ // malformed type
+			have: "bad",
+			err:  io.ErrUnexpectedEOF,
+		},
+		{ // malformed length
+			have: "badbeef",
+			err:  io.ErrUnexpectedEOF,
+		},
+		{ // specified length longer than actual value
+			have: "beef010000000000",
+			err:  io.ErrUnexpectedEOF,
+		},
+	} {
+		r := NewReader(bytes.NewReader(common.FromHex(tt.have)))
+		if tt.err != nil {
+			_, err := r.Read()
+			if err == nil {
+				t.Fatalf("test %d, expected error, got none", i)
+			}
+			if err != tt.err {
+				t.Fatalf("test %d, expected error %v, got %v", i, tt.err, err)
+			}
+			continue
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	var (
+		b = bytes.NewBuffer(nil)
+		w = NewWriter(b)
+	)
+	w.Write(1, common.Hex2Bytes("aa"))
+	w.Write(2, common.Hex2Bytes("bbbb"))
+	w.Write(3, common.Hex2Bytes("cccccc"))
+
+	r := NewReader(bytes.NewReader(b.Bytes()))
+	e, err := r.Find(3)
+	if err != nil {
+		t.Fatalf("failed to find entry: %v", err)
+	}
+	if !bytes.Equal(e.Value, common.Hex2Bytes("cccccc")) {
+		t.Fatalf("entry value mismatch: have %x, want %x", e.Value, "cccccc")
+	}
+	if _, err := r.Find(4); err != io.EOF {
+		t.Fatalf("missing entry error mismatch: have %v, want %v", err, io.EOF)
+	}
+	// Ensure values can be streamed through a section reader
+	rd, n, err := r.ReaderAt(2, 9)
+	if err != nil {
+		t.Fatalf("failed to open entry reader: %v", err)
+	}
+	if n != 10 {
+		t.Fatalf("entry length mismatch: have %d, want %d", n, 10)
+	}
+	if blob, _ := ioutil.ReadAll(rd); !bytes.Equal(blob, common.Hex2Bytes("bbbb")) {
+		t.Fatalf("entry value mismatch: have %x, want %x", blob, "bbbb")
+	}
+	if _, _, err := r.ReaderAt(1, 9); err == nil {
+		t.Fatalf("opened entry with mismatching type")
+	}
+}
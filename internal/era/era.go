@@ -0,0 +1,399 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package era implements the era1 archive format, a flat file holding a fixed
+// size epoch of historical blocks, receipts and total difficulties.
+//
+// An era1 file is an e2store of the following entries:
+//
+//   era1        := Version | block-tuple* | Accumulator | BlockIndex
+//   block-tuple := CompressedHeader | CompressedBody | CompressedReceipts | TotalDifficulty
+//
+// The header, body and receipts are the RLP encodings stored by the chain
+// freezer (receipts in their storage form), compressed with framed snappy. The
+// total difficulty is a little endian uint256 and the accumulator the root
+// computed by ComputeAccumulator. The block index closing the file is:
+//
+//   BlockIndex := starting-number | offset* | count
+//
+// where the offsets point to the header of every block, relative to the start
+// of the index entry.
+package era
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/internal/era/e2store"
+	"github.com/CortexFoundation/CortexTheseus/rlp"
+	"github.com/CortexFoundation/CortexTheseus/trie"
+	"github.com/golang/snappy"
+)
+
+const (
+	TypeVersion            uint16 = 0x3265
+	TypeCompressedHeader   uint16 = 0x03
+	TypeCompressedBody     uint16 = 0x04
+	TypeCompressedReceipts uint16 = 0x05
+	TypeTotalDifficulty    uint16 = 0x06
+	TypeAccumulator        uint16 = 0x07
+	TypeBlockIndex         uint16 = 0x3266
+
+	// MaxEra1Size is the number of blocks in an epoch.
+	MaxEra1Size = 8192
+)
+
+var errOutOfRange = errors.New("block number out of range")
+
+// Filename returns a recognizable era1-formatted file name for the specified
+// epoch and network.
+func Filename(network string, epoch int, root common.Hash) string {
+	return fmt.Sprintf("%s-%05d-%s.era1", network, epoch, root.Hex()[2:10])
+}
+
+// ReadDir reads all the era1 files of the given network in a directory, sorted
+// by epoch. The epochs must be contiguous, but may start at any epoch.
+func ReadDir(dir, network string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory %s: %w", dir, err)
+	}
+	var (
+		eras  []string
+		epoch = make(map[string]int)
+	)
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != ".era1" {
+			continue
+		}
+		parts := strings.Split(strings.TrimSuffix(entry.Name(), ".era1"), "-")
+		if len(parts) != 3 || parts[0] != network {
+			// Invalid era1 filename, skip.
+			continue
+		}
+		n, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("malformed era1 filename: %s", entry.Name())
+		}
+		eras, epoch[entry.Name()] = append(eras, entry.Name()), n
+	}
+	sort.Slice(eras, func(i, j int) bool { return epoch[eras[i]] < epoch[eras[j]] })
+	for i := 1; i < len(eras); i++ {
+		switch prev, next := epoch[eras[i-1]], epoch[eras[i]]; {
+		case next == prev:
+			return nil, fmt.Errorf("duplicate epoch %d: %s and %s", next, eras[i-1], eras[i])
+		case next != prev+1:
+			return nil, fmt.Errorf("missing epoch %d", prev+1)
+		}
+	}
+	return eras, nil
+}
+
+// ReadAtSeekCloser is the file interface an era is read from.
+type ReadAtSeekCloser interface {
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+// Era reads an era1 file.
+type Era struct {
+	f     ReadAtSeekCloser
+	s     *e2store.Reader
+	start uint64 // Number of the first block
+	count uint64 // Number of blocks
+	index int64  // Offset of the block index entry
+}
+
+// Open opens an era1 file.
+func Open(filename string) (*Era, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	e, err := From(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+// From returns an era backed by f, parsing its block index.
+func From(f ReadAtSeekCloser) (*Era, error) {
+	length, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	e := &Era{f: f, s: e2store.NewReader(f)}
+
+	// Read the block count from the very end of the file, then the starting
+	// number from the beginning of the index.
+	buf := make([]byte, 8)
+	if length < 16 {
+		return nil, errors.New("file too short")
+	}
+	if _, err := f.ReadAt(buf, length-8); err != nil {
+		return nil, err
+	}
+	e.count = binary.LittleEndian.Uint64(buf)
+	if e.count > MaxEra1Size {
+		return nil, fmt.Errorf("too many blocks: %d", e.count)
+	}
+	e.index = length - 8*int64(e.count) - 16 - 8
+	if e.index < 0 {
+		return nil, errors.New("file too short for block index")
+	}
+	typ, size, err := e.s.ReadMetadataAt(e.index)
+	if err != nil {
+		return nil, err
+	}
+	if typ != TypeBlockIndex || uint64(size) != 8*e.count+16 {
+		return nil, errors.New("malformed block index")
+	}
+	if _, err := f.ReadAt(buf, e.index+8); err != nil {
+		return nil, err
+	}
+	e.start = binary.LittleEndian.Uint64(buf)
+	return e, nil
+}
+
+// Close closes the era file.
+func (e *Era) Close() error {
+	return e.f.Close()
+}
+
+// Start returns the number of the first block of the era.
+func (e *Era) Start() uint64 {
+	return e.start
+}
+
+// Count returns the number of blocks of the era.
+func (e *Era) Count() uint64 {
+	return e.count
+}
+
+// Accumulator returns the accumulator root stored in the era.
+func (e *Era) Accumulator() (common.Hash, error) {
+	entry, err := e.s.Find(TypeAccumulator)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return common.BytesToHash(entry.Value), nil
+}
+
+// headerOffset returns the offset of the header entry of the given block.
+func (e *Era) headerOffset(num uint64) (int64, error) {
+	if num < e.start || num >= e.start+e.count {
+		return 0, errOutOfRange
+	}
+	buf := make([]byte, 8)
+	if _, err := e.f.ReadAt(buf, e.index+8+8+int64(num-e.start)*8); err != nil {
+		return 0, err
+	}
+	return e.index + int64(binary.LittleEndian.Uint64(buf)), nil
+}
+
+// readEntry reads the n-th entry of the block tuple of the given block,
+// checking it's of the expected type.
+func (e *Era) readEntry(num uint64, n int, typ uint16) ([]byte, error) {
+	off, err := e.headerOffset(num)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < n; i++ {
+		length, err := e.s.LengthAt(off)
+		if err != nil {
+			return nil, err
+		}
+		off += length
+	}
+	var entry e2store.Entry
+	if _, err := e.s.ReadAt(&entry, off); err != nil {
+		return nil, err
+	}
+	if entry.Type != typ {
+		return nil, fmt.Errorf("wrong entry type for block %d: have %d, want %d", num, entry.Type, typ)
+	}
+	return entry.Value, nil
+}
+
+// readCompressed reads and decompresses an entry of the block tuple.
+func (e *Era) readCompressed(num uint64, n int, typ uint16) ([]byte, error) {
+	blob, err := e.readEntry(num, n, typ)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(snappy.NewReader(bytes.NewReader(blob)))
+}
+
+// GetRawHeaderByNumber returns the RLP encoded header of the given block.
+func (e *Era) GetRawHeaderByNumber(num uint64) ([]byte, error) {
+	return e.readCompressed(num, 0, TypeCompressedHeader)
+}
+
+// GetRawBodyByNumber returns the RLP encoded body of the given block.
+func (e *Era) GetRawBodyByNumber(num uint64) ([]byte, error) {
+	return e.readCompressed(num, 1, TypeCompressedBody)
+}
+
+// GetRawReceiptsByNumber returns the RLP encoded storage receipts of the given
+// block.
+func (e *Era) GetRawReceiptsByNumber(num uint64) ([]byte, error) {
+	return e.readCompressed(num, 2, TypeCompressedReceipts)
+}
+
+// GetTotalDifficulty returns the total difficulty of the given block.
+func (e *Era) GetTotalDifficulty(num uint64) (*big.Int, error) {
+	blob, err := e.readEntry(num, 3, TypeTotalDifficulty)
+	if err != nil {
+		return nil, err
+	}
+	if len(blob) != 32 {
+		return nil, fmt.Errorf("invalid total difficulty length %d", len(blob))
+	}
+	be := make([]byte, 32)
+	for i := range blob {
+		be[i] = blob[len(blob)-1-i]
+	}
+	return new(big.Int).SetBytes(be), nil
+}
+
+// GetHeaderByNumber returns the header of the given block.
+func (e *Era) GetHeaderByNumber(num uint64) (*types.Header, error) {
+	blob, err := e.GetRawHeaderByNumber(num)
+	if err != nil {
+		return nil, err
+	}
+	header := new(types.Header)
+	if err := rlp.DecodeBytes(blob, header); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// GetBlockByNumber returns the block of the given number.
+func (e *Era) GetBlockByNumber(num uint64) (*types.Block, error) {
+	header, err := e.GetHeaderByNumber(num)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := e.GetRawBodyByNumber(num)
+	if err != nil {
+		return nil, err
+	}
+	body := new(types.Body)
+	if err := rlp.DecodeBytes(blob, body); err != nil {
+		return nil, err
+	}
+	return types.NewBlockWithHeader(header).WithBody(body.Transactions, body.Uncles), nil
+}
+
+// GetReceiptsByNumber returns the receipts of the given block. Only the fields
+// persisted by the storage encoding are populated.
+func (e *Era) GetReceiptsByNumber(num uint64) (types.Receipts, error) {
+	blob, err := e.GetRawReceiptsByNumber(num)
+	if err != nil {
+		return nil, err
+	}
+	var storage []*types.ReceiptForStorage
+	if err := rlp.DecodeBytes(blob, &storage); err != nil {
+		return nil, err
+	}
+	receipts := make(types.Receipts, len(storage))
+	for i, receipt := range storage {
+		receipts[i] = (*types.Receipt)(receipt)
+	}
+	return receipts, nil
+}
+
+// Verify checks the consistency of all the blocks of the era: the headers are
+// linked, the bodies and receipts match the roots of the headers, the total
+// difficulties are accumulated and the accumulator root matches the contents.
+// The verified accumulator root is returned.
+//
+// The total difficulty of the first block can't be verified without its parent
+// and is left to be checked against the chain the era is imported into.
+func (e *Era) Verify() (common.Hash, error) {
+	var (
+		hashes = make([]common.Hash, 0, e.count)
+		tds    = make([]*big.Int, 0, e.count)
+		parent *types.Header
+	)
+	for num := e.start; num < e.start+e.count; num++ {
+		block, err := e.GetBlockByNumber(num)
+		if err != nil {
+			return common.Hash{}, fmt.Errorf("block %d: %v", num, err)
+		}
+		receipts, err := e.GetReceiptsByNumber(num)
+		if err != nil {
+			return common.Hash{}, fmt.Errorf("receipts %d: %v", num, err)
+		}
+		td, err := e.GetTotalDifficulty(num)
+		if err != nil {
+			return common.Hash{}, fmt.Errorf("total difficulty %d: %v", num, err)
+		}
+		if block.NumberU64() != num {
+			return common.Hash{}, fmt.Errorf("block %d: number mismatch: have %d", num, block.NumberU64())
+		}
+		if parent != nil {
+			if block.ParentHash() != parent.Hash() {
+				return common.Hash{}, fmt.Errorf("block %d: parent hash mismatch: have %x, want %x", num, block.ParentHash(), parent.Hash())
+			}
+			if want := new(big.Int).Add(tds[len(tds)-1], block.Difficulty()); td.Cmp(want) != 0 {
+				return common.Hash{}, fmt.Errorf("block %d: total difficulty mismatch: have %v, want %v", num, td, want)
+			}
+		}
+		if hash := types.DeriveSha(block.Transactions(), trie.NewStackTrie(nil)); hash != block.TxHash() {
+			return common.Hash{}, fmt.Errorf("block %d: transaction root mismatch: have %x, want %x", num, hash, block.TxHash())
+		}
+		if hash := types.CalcUncleHash(block.Uncles()); hash != block.UncleHash() {
+			return common.Hash{}, fmt.Errorf("block %d: uncle root mismatch: have %x, want %x", num, hash, block.UncleHash())
+		}
+		for _, receipt := range receipts {
+			receipt.Bloom = types.CreateBloom(types.Receipts{receipt})
+		}
+		if hash := types.DeriveSha(receipts, trie.NewStackTrie(nil)); hash != block.ReceiptHash() {
+			return common.Hash{}, fmt.Errorf("block %d: receipt root mismatch: have %x, want %x", num, hash, block.ReceiptHash())
+		}
+		hashes, tds = append(hashes, block.Hash()), append(tds, td)
+		parent = block.Header()
+	}
+	root, err := ComputeAccumulator(hashes, tds)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	want, err := e.Accumulator()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if root != want {
+		return common.Hash{}, fmt.Errorf("accumulator mismatch: have %x, want %x", root, want)
+	}
+	return root, nil
+}
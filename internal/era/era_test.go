@@ -0,0 +1,234 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package era
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/consensus/cuckoo"
+	"github.com/CortexFoundation/CortexTheseus/core"
+	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/params"
+)
+
+// makeTestChain generates a chain of the given length with a value transfer in
+// every block, returning the blocks, their receipts and total difficulties.
+func makeTestChain(n int) ([]*types.Block, []types.Receipts, []*big.Int) {
+	var (
+		key, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr   = crypto.PubkeyToAddress(key.PublicKey)
+		db     = rawdb.NewMemoryDatabase()
+		gspec  = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  core.GenesisAlloc{addr: {Balance: big.NewInt(1000000000000000000)}},
+			Supply: params.CTXC_INIT,
+		}
+		genesis = gspec.MustCommit(db)
+		signer  = types.NewEIP155Signer(params.TestChainConfig.ChainID)
+	)
+	blocks, receipts := core.GenerateChain(gspec.Config, genesis, cuckoo.NewFaker(), db, n, func(i int, gen *core.BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(gen.TxNonce(addr), common.Address{0x01}, big.NewInt(1), params.TxGas, big.NewInt(1), nil), signer, key)
+		gen.AddTx(tx)
+	})
+	tds := make([]*big.Int, n)
+	td := new(big.Int).Set(genesis.Difficulty())
+	for i, block := range blocks {
+		td.Add(td, block.Difficulty())
+		tds[i] = new(big.Int).Set(td)
+	}
+	return blocks, receipts, tds
+}
+
+// Tests that an era archive can be built, read back and verified.
+func TestEra1Builder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "era")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	blocks, receipts, tds := makeTestChain(16)
+
+	f, err := os.Create(filepath.Join(dir, "test.era1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := NewBuilder(f)
+	for i, block := range blocks {
+		if err := builder.Add(block, receipts[i], tds[i]); err != nil {
+			t.Fatalf("failed to add block %d: %v", block.NumberU64(), err)
+		}
+	}
+	if err := builder.Add(blocks[0], receipts[0], tds[0]); err == nil {
+		t.Fatalf("non contiguous block accepted")
+	}
+	root, err := builder.Finalize()
+	if err != nil {
+		t.Fatalf("failed to finalize era: %v", err)
+	}
+	f.Close()
+
+	e, err := Open(f.Name())
+	if err != nil {
+		t.Fatalf("failed to open era: %v", err)
+	}
+	defer e.Close()
+
+	if e.Start() != 1 || e.Count() != 16 {
+		t.Fatalf("era range mismatch: have %d+%d, want %d+%d", e.Start(), e.Count(), 1, 16)
+	}
+	if have, err := e.Accumulator(); err != nil || have != root {
+		t.Fatalf("accumulator mismatch: have %x (%v), want %x", have, err, root)
+	}
+	for i, want := range blocks {
+		block, err := e.GetBlockByNumber(want.NumberU64())
+		if err != nil {
+			t.Fatalf("failed to read block %d: %v", want.NumberU64(), err)
+		}
+		if block.Hash() != want.Hash() || len(block.Transactions()) != 1 {
+			t.Fatalf("block %d mismatch: have %x, want %x", want.NumberU64(), block.Hash(), want.Hash())
+		}
+		have, err := e.GetReceiptsByNumber(want.NumberU64())
+		if err != nil {
+			t.Fatalf("failed to read receipts %d: %v", want.NumberU64(), err)
+		}
+		if len(have) != 1 || have[0].CumulativeGasUsed != receipts[i][0].CumulativeGasUsed {
+			t.Fatalf("receipts %d mismatch", want.NumberU64())
+		}
+		td, err := e.GetTotalDifficulty(want.NumberU64())
+		if err != nil || td.Cmp(tds[i]) != 0 {
+			t.Fatalf("total difficulty %d mismatch: have %v (%v), want %v", want.NumberU64(), td, err, tds[i])
+		}
+	}
+	if _, err := e.GetBlockByNumber(17); err != errOutOfRange {
+		t.Fatalf("out of range error mismatch: have %v, want %v", err, errOutOfRange)
+	}
+	if have, err := e.Verify(); err != nil || have != root {
+		t.Fatalf("verification failed: have %x (%v), want %x", have, err, root)
+	}
+}
+
+// Tests that an era archive with inconsistent contents fails verification.
+func TestEra1VerifyFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "era")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	blocks, receipts, tds := makeTestChain(4)
+
+	f, err := os.Create(filepath.Join(dir, "test.era1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := NewBuilder(f)
+	for i, block := range blocks {
+		// Skew the total difficulty of the last block
+		td := tds[i]
+		if i == len(blocks)-1 {
+			td = new(big.Int).Add(td, common.Big1)
+		}
+		if err := builder.Add(block, receipts[i], td); err != nil {
+			t.Fatalf("failed to add block %d: %v", block.NumberU64(), err)
+		}
+	}
+	if _, err := builder.Finalize(); err != nil {
+		t.Fatalf("failed to finalize era: %v", err)
+	}
+	f.Close()
+
+	e, err := Open(f.Name())
+	if err != nil {
+		t.Fatalf("failed to open era: %v", err)
+	}
+	defer e.Close()
+
+	if _, err := e.Verify(); err == nil {
+		t.Fatalf("inconsistent era verified")
+	}
+}
+
+// Tests that the accumulator commits to both the hashes and total difficulties.
+func TestComputeAccumulator(t *testing.T) {
+	hashes := []common.Hash{{0x01}, {0x02}, {0x03}}
+	tds := []*big.Int{big.NewInt(1), big.NewInt(2), big.NewInt(3)}
+
+	root, err := ComputeAccumulator(hashes, tds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := ComputeAccumulator(hashes, tds); again != root {
+		t.Fatalf("accumulator not deterministic: %x != %x", again, root)
+	}
+	if other, _ := ComputeAccumulator(hashes, []*big.Int{big.NewInt(1), big.NewInt(2), big.NewInt(4)}); other == root {
+		t.Fatalf("accumulator ignores total difficulty")
+	}
+	if other, _ := ComputeAccumulator(hashes[:2], tds[:2]); other == root {
+		t.Fatalf("accumulator ignores length")
+	}
+	if _, err := ComputeAccumulator(hashes, tds[:2]); err == nil {
+		t.Fatalf("mismatching records accepted")
+	}
+	if _, err := ComputeAccumulator(hashes[:1], []*big.Int{big.NewInt(-1)}); err == nil {
+		t.Fatalf("negative total difficulty accepted")
+	}
+}
+
+// Tests that the era archives of a directory are returned in epoch order, as
+// long as the epochs are contiguous.
+func TestReadDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "era-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	touch := func(name string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	touch(Filename("mainnet", 3, common.Hash{0x03}))
+	touch(Filename("mainnet", 2, common.Hash{0x02}))
+	touch(Filename("dolores", 0, common.Hash{0x00}))
+	touch("checksums.txt")
+
+	entries, err := ReadDir(dir, "mainnet")
+	if err != nil {
+		t.Fatalf("failed to read directory: %v", err)
+	}
+	if want := []string{Filename("mainnet", 2, common.Hash{0x02}), Filename("mainnet", 3, common.Hash{0x03})}; len(entries) != 2 || entries[0] != want[0] || entries[1] != want[1] {
+		t.Fatalf("entries mismatch: have %v, want %v", entries, want)
+	}
+	touch(Filename("mainnet", 5, common.Hash{0x05}))
+	if _, err := ReadDir(dir, "mainnet"); err == nil {
+		t.Fatalf("missing epoch accepted")
+	}
+	touch(Filename("mainnet", 4, common.Hash{0x04}))
+	touch(Filename("mainnet", 4, common.Hash{0x14}))
+	if _, err := ReadDir(dir, "mainnet"); err == nil {
+		t.Fatalf("duplicate epoch accepted")
+	}
+}
@@ -19,6 +19,7 @@ package web3ext
 
 var Modules = map[string]string{
 	"admin":      Admin_JS,
+	"avail":      Avail_JS,
 	"chequebook": Chequebook_JS,
 	"clique":     Clique_JS,
 	"debug":      Debug_JS,
@@ -31,6 +32,26 @@ var Modules = map[string]string{
 	"txpool":     TxPool_JS,
 }
 
+const Avail_JS = `
+web3._extend({
+	property: 'avail',
+	methods: [
+		new web3._extend.Method({
+			name: 'holders',
+			call: 'avail_holders',
+			params: 2,
+			inputFormatter: [null, web3._extend.utils.fromDecimal]
+		}),
+	],
+	properties: [
+		new web3._extend.Property({
+			name: 'local',
+			getter: 'avail_local'
+		}),
+	]
+});
+`
+
 const Chequebook_JS = `
 web3._extend({
 	property: 'chequebook',
@@ -131,6 +152,17 @@ web3._extend({
 			call: 'admin_removeTrustedPeer',
 			params: 1
 		}),
+		new web3._extend.Method({
+			name: 'ban',
+			call: 'admin_ban',
+			params: 2,
+			inputFormatter: [null, null]
+		}),
+		new web3._extend.Method({
+			name: 'unban',
+			call: 'admin_unban',
+			params: 1
+		}),
 		new web3._extend.Method({
 			name: 'exportChain',
 			call: 'admin_exportChain',
@@ -181,6 +213,14 @@ web3._extend({
 			name: 'datadir',
 			getter: 'admin_datadir'
 		}),
+		new web3._extend.Property({
+			name: 'peerScores',
+			getter: 'admin_peerScores'
+		}),
+		new web3._extend.Property({
+			name: 'discoveryStats',
+			getter: 'admin_discoveryStats'
+		}),
 	]
 });
 `
@@ -367,6 +407,11 @@ web3._extend({
 			params: 2,
 			inputFormatter: [null, null]
 		}),
+		new web3._extend.Method({
+			name: 'traceFilter',
+			call: 'debug_traceFilter',
+			params: 1
+		}),
 		new web3._extend.Method({
 			name: 'traceTransaction',
 			call: 'debug_traceTransaction',
@@ -488,6 +533,17 @@ web3._extend({
 			params: 2,
 			inputFormatter: [web3._extend.formatters.inputBlockNumberFormatter, web3._extend.utils.toHex]
 		}),
+		new web3._extend.Method({
+			name: 'feeHistory',
+			call: 'ctxc_feeHistory',
+			params: 3,
+			inputFormatter: [null, web3._extend.formatters.inputBlockNumberFormatter, null]
+		}),
+		new web3._extend.Method({
+			name: 'getGasPrices',
+			call: 'ctxc_gasPrices',
+			params: 0
+		}),
 	],
 	properties: [
 		new web3._extend.Property({
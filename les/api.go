@@ -0,0 +1,85 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"errors"
+
+	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
+	"github.com/CortexFoundation/CortexTheseus/params"
+)
+
+var (
+	errNoCheckpoint = errors.New("no local checkpoint provided")
+	errNotActivated = errors.New("checkpoint registrar is not activated")
+)
+
+// PrivateLightAPI provides an API to access the LES light server.
+type PrivateLightAPI struct {
+	oracle *checkpointOracle
+	latest func() params.TrustedCheckpoint
+	get    func(uint64) params.TrustedCheckpoint
+}
+
+// NewPrivateLightAPI creates a new LES service API.
+func NewPrivateLightAPI(oracle *checkpointOracle, latest func() params.TrustedCheckpoint, get func(uint64) params.TrustedCheckpoint) *PrivateLightAPI {
+	return &PrivateLightAPI{oracle: oracle, latest: latest, get: get}
+}
+
+// LatestCheckpoint returns the latest local checkpoint package.
+//
+// The checkpoint package consists of 4 strings:
+//
+//	result[0], hex encoded latest section index
+//	result[1], 32 bytes hex encoded latest section head hash
+//	result[2], 32 bytes hex encoded latest section canonical hash trie root hash
+//	result[3], 32 bytes hex encoded latest section bloom trie root hash
+func (api *PrivateLightAPI) LatestCheckpoint() ([4]string, error) {
+	var res [4]string
+	cp := api.latest()
+	if cp.Empty() {
+		return res, errNoCheckpoint
+	}
+	res[0] = hexutil.EncodeUint64(cp.SectionIndex)
+	res[1], res[2], res[3] = cp.SectionHead.Hex(), cp.CHTRoot.Hex(), cp.BloomRoot.Hex()
+	return res, nil
+}
+
+// GetCheckpoint returns the specific local checkpoint package.
+//
+// The checkpoint package consists of 3 strings:
+//
+//	result[0], 32 bytes hex encoded latest section head hash
+//	result[1], 32 bytes hex encoded latest section canonical hash trie root hash
+//	result[2], 32 bytes hex encoded latest section bloom trie root hash
+func (api *PrivateLightAPI) GetCheckpoint(index uint64) ([3]string, error) {
+	var res [3]string
+	cp := api.get(index)
+	if cp.Empty() {
+		return res, errNoCheckpoint
+	}
+	res[0], res[1], res[2] = cp.SectionHead.Hex(), cp.CHTRoot.Hex(), cp.BloomRoot.Hex()
+	return res, nil
+}
+
+// GetCheckpointContractAddress returns the contract contract address in hex format.
+func (api *PrivateLightAPI) GetCheckpointContractAddress() (string, error) {
+	if api.oracle == nil {
+		return "", errNotActivated
+	}
+	return api.oracle.config.Address.Hex(), nil
+}
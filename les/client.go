@@ -0,0 +1,223 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package les implements the Light Cortex Subprotocol.
+package les
+
+import (
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/accounts"
+	"github.com/CortexFoundation/CortexTheseus/consensus"
+	"github.com/CortexFoundation/CortexTheseus/core"
+	"github.com/CortexFoundation/CortexTheseus/core/bloombits"
+	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
+	"github.com/CortexFoundation/CortexTheseus/core/vm"
+	"github.com/CortexFoundation/CortexTheseus/ctxc"
+	"github.com/CortexFoundation/CortexTheseus/ctxc/downloader"
+	"github.com/CortexFoundation/CortexTheseus/ctxc/filters"
+	"github.com/CortexFoundation/CortexTheseus/ctxc/gasprice"
+	"github.com/CortexFoundation/CortexTheseus/ctxcdb"
+	"github.com/CortexFoundation/CortexTheseus/event"
+	"github.com/CortexFoundation/CortexTheseus/internal/ctxcapi"
+	"github.com/CortexFoundation/CortexTheseus/light"
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/node"
+	"github.com/CortexFoundation/CortexTheseus/p2p"
+	"github.com/CortexFoundation/CortexTheseus/params"
+	"github.com/CortexFoundation/CortexTheseus/rpc"
+)
+
+// LightCortex implements the Cortex light client service. Only headers are
+// synchronised, everything else is retrieved on demand from les servers.
+type LightCortex struct {
+	config      *ctxc.Config
+	chainConfig *params.ChainConfig
+
+	// Handlers
+	peers      *peerSet
+	retriever  *retrieveManager
+	odr        *LesOdr
+	relay      *lesTxRelay
+	handler    *clientHandler
+	txPool     *light.TxPool
+	blockchain *light.LightChain
+
+	// DB interfaces
+	chainDb ctxcdb.Database // Block chain database
+
+	bloomRequests                chan chan *bloombits.Retrieval // Channel receiving bloom data retrieval requests
+	chtIndexer, bloomTrieIndexer *core.ChainIndexer
+	closeBloomHandler            chan struct{}
+
+	ApiBackend *LesApiBackend
+
+	eventMux       *event.TypeMux
+	engine         consensus.Engine
+	accountManager *accounts.Manager
+
+	networkId     uint64
+	netRPCService *ctxcapi.PublicNetAPI
+}
+
+// New creates a light client service on top of the given node.
+func New(ctx *node.ServiceContext, config *ctxc.Config) (*LightCortex, error) {
+	chainDb, err := ctx.OpenDatabase("lightchaindata", config.DatabaseCache, config.DatabaseHandles, "ctxc/db/chaindata/")
+	if err != nil {
+		return nil, err
+	}
+	chainConfig, genesisHash, genesisErr := core.SetupGenesisBlock(chainDb, config.Genesis)
+	if _, isCompat := genesisErr.(*params.ConfigCompatError); genesisErr != nil && !isCompat {
+		return nil, genesisErr
+	}
+	log.Info("Initialised chain configuration", "config", chainConfig)
+
+	peers := newPeerSet()
+	lc := &LightCortex{
+		config:            config,
+		chainConfig:       chainConfig,
+		chainDb:           chainDb,
+		eventMux:          ctx.EventMux,
+		accountManager:    ctx.AccountManager,
+		engine:            ctxc.CreateConsensusEngine(ctx, chainConfig, &config.Cuckoo, nil, false, chainDb),
+		peers:             peers,
+		retriever:         newRetrieveManager(peers),
+		bloomRequests:     make(chan chan *bloombits.Retrieval),
+		closeBloomHandler: make(chan struct{}),
+		networkId:         config.NetworkId,
+	}
+	lc.odr = NewLesOdr(chainDb, light.DefaultClientIndexerConfig, lc.retriever)
+	lc.relay = newLesTxRelay(peers)
+
+	// The helper trie indexers are only fed by trusted checkpoints, the client
+	// never processes full sections itself.
+	lc.chtIndexer = light.NewChtIndexer(chainDb, lc.odr.IndexerConfig().ChtSize, lc.odr.IndexerConfig().ChtConfirms)
+	lc.bloomTrieIndexer = light.NewBloomTrieIndexer(chainDb, lc.odr.IndexerConfig().BloomSize, lc.odr.IndexerConfig().BloomTrieSize)
+	lc.odr.SetIndexers(lc.chtIndexer, lc.bloomTrieIndexer, nil)
+
+	checkpoint := config.Checkpoint
+	if checkpoint == nil {
+		checkpoint = params.TrustedCheckpoints[genesisHash]
+	}
+	// Note: NewLightChain adds the trusted checkpoint so it needs an ODR with
+	// indexers already set but not started yet
+	if lc.blockchain, err = light.NewLightChain(lc.odr, lc.chainConfig, lc.engine, checkpoint); err != nil {
+		return nil, err
+	}
+	// Rewind the chain in case of an incompatible config upgrade.
+	if compat, isCompat := genesisErr.(*params.ConfigCompatError); isCompat {
+		log.Warn("Rewinding chain to upgrade configuration", "err", compat)
+		lc.blockchain.SetHead(compat.RewindTo)
+		rawdb.WriteChainConfig(chainDb, genesisHash, chainConfig)
+	}
+	lc.txPool = light.NewTxPool(lc.chainConfig, lc.blockchain, lc.relay)
+
+	oracle := config.CheckpointOracle
+	if oracle == nil {
+		oracle = params.CheckpointOracles[genesisHash]
+	}
+	lc.handler = newClientHandler(lc, checkpoint, newCheckpointOracle(oracle, nil))
+
+	lc.ApiBackend = &LesApiBackend{lc, nil}
+	gpoParams := config.GPO
+	if gpoParams.Default == nil {
+		gpoParams.Default = config.Miner.GasPrice
+	}
+	lc.ApiBackend.gpo = gasprice.NewOracle(lc.ApiBackend, gpoParams)
+
+	log.Info("Initialising light Cortex protocol", "versions", ProtocolVersions, "network", config.NetworkId)
+	return lc, nil
+}
+
+// APIs returns the collection of RPC services the light client offers.
+func (s *LightCortex) APIs() []rpc.API {
+	apis := ctxcapi.GetAPIs(s.ApiBackend, vm.Config{})
+	apis = append(apis, s.engine.APIs(s.BlockChain())...)
+	return append(apis, []rpc.API{
+		{
+			Namespace: "ctxc",
+			Version:   "1.0",
+			Service:   downloader.NewPublicDownloaderAPI(s.handler.downloader, s.eventMux),
+			Public:    true,
+		}, {
+			Namespace: "ctxc",
+			Version:   "1.0",
+			Service:   filters.NewPublicFilterAPI(s.ApiBackend, true, 5*time.Minute),
+			Public:    true,
+		}, {
+			Namespace: "net",
+			Version:   "1.0",
+			Service:   s.netRPCService,
+			Public:    true,
+		},
+	}...)
+}
+
+func (s *LightCortex) BlockChain() *light.LightChain      { return s.blockchain }
+func (s *LightCortex) TxPool() *light.TxPool              { return s.txPool }
+func (s *LightCortex) Engine() consensus.Engine           { return s.engine }
+func (s *LightCortex) LesVersion() int                    { return int(ProtocolVersions[0]) }
+func (s *LightCortex) Downloader() *downloader.Downloader { return s.handler.downloader }
+func (s *LightCortex) EventMux() *event.TypeMux           { return s.eventMux }
+
+// Protocols implements node.Service, returning all the currently configured
+// network protocols to start.
+func (s *LightCortex) Protocols() []p2p.Protocol {
+	protos := make([]p2p.Protocol, len(ProtocolVersions))
+	for i, vsn := range ProtocolVersions {
+		version := vsn
+		protos[i] = p2p.Protocol{
+			Name:    protocolName,
+			Version: version,
+			Length:  protocolLengths[version],
+			Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+				return s.handler.runPeer(newPeer(int(version), s.networkId, p, rw))
+			},
+		}
+	}
+	return protos
+}
+
+// Start implements node.Service, starting all internal goroutines needed by the
+// light client.
+func (s *LightCortex) Start(srvr *p2p.Server) error {
+	log.Warn("Light client mode is an experimental feature")
+
+	// Start the bloom bits servicing goroutines
+	s.startBloomHandlers(s.odr.IndexerConfig().BloomTrieSize)
+
+	s.netRPCService = ctxcapi.NewPublicNetAPI(srvr, s.networkId)
+	s.handler.start()
+	return nil
+}
+
+// Stop implements node.Service, terminating all internal goroutines used by the
+// light client.
+func (s *LightCortex) Stop() error {
+	s.peers.Close()
+	s.handler.stop()
+
+	close(s.closeBloomHandler)
+	s.chtIndexer.Close()
+	s.bloomTrieIndexer.Close()
+	s.txPool.Stop()
+	s.blockchain.Stop()
+	s.engine.Close()
+	s.eventMux.Stop()
+	s.chainDb.Close()
+	log.Info("Light client stopped")
+	return nil
+}
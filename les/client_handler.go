@@ -0,0 +1,241 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"sync"
+
+	"github.com/CortexFoundation/CortexTheseus/ctxc/downloader"
+	"github.com/CortexFoundation/CortexTheseus/light"
+	"github.com/CortexFoundation/CortexTheseus/p2p"
+	"github.com/CortexFoundation/CortexTheseus/params"
+)
+
+// downloaderVersion is the Cortex protocol version the les peers are registered
+// with in the downloader, it only selects the header fetching strategy.
+const downloaderVersion = 64
+
+// clientHandler manages the connections to les servers, routing their
+// responses to the retriever and the downloader and keeping the light chain
+// in sync with the best server.
+type clientHandler struct {
+	networkId uint64
+
+	checkpoint *params.TrustedCheckpoint // Locally configured trusted checkpoint
+	oracle     *checkpointOracle         // Oracle verifying the checkpoints advertised by servers
+
+	peers      *peerSet
+	retriever  *retrieveManager
+	odr        *LesOdr
+	blockchain *light.LightChain
+	downloader *downloader.Downloader
+
+	syncCh  chan *peer // Servers whose head is worth syncing to
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+}
+
+func newClientHandler(lc *LightCortex, checkpoint *params.TrustedCheckpoint, oracle *checkpointOracle) *clientHandler {
+	h := &clientHandler{
+		networkId:  lc.networkId,
+		checkpoint: checkpoint,
+		oracle:     oracle,
+		peers:      lc.peers,
+		retriever:  lc.retriever,
+		odr:        lc.odr,
+		blockchain: lc.blockchain,
+		syncCh:     make(chan *peer, 1),
+		closeCh:    make(chan struct{}),
+	}
+	var height uint64
+	if checkpoint != nil {
+		height = (checkpoint.SectionIndex+1)*lc.odr.IndexerConfig().ChtSize - 1
+	}
+	h.downloader = downloader.New(height, lc.chainDb, nil, lc.eventMux, nil, lc.blockchain, func(id string, reason error) { h.removePeer(id) })
+	return h
+}
+
+func (h *clientHandler) start() {
+	h.wg.Add(1)
+	go h.syncLoop()
+}
+
+func (h *clientHandler) stop() {
+	close(h.closeCh)
+	h.downloader.Terminate()
+	h.wg.Wait()
+}
+
+// status assembles the handshake packet sent to les servers.
+func (h *clientHandler) status(version int) *statusData {
+	head := h.blockchain.CurrentHeader()
+	hash, number := head.Hash(), head.Number.Uint64()
+
+	return &statusData{
+		ProtocolVersion: uint32(version),
+		NetworkId:       h.networkId,
+		HeadTd:          h.blockchain.GetTd(hash, number),
+		HeadHash:        hash,
+		HeadNum:         number,
+		GenesisHash:     h.blockchain.Genesis().Hash(),
+	}
+}
+
+// runPeer is the callback invoked to manage the life cycle of a les server.
+// When this function terminates, the peer is disconnected.
+func (h *clientHandler) runPeer(p *peer) error {
+	p.Log().Debug("Light server connected", "name", p.Name())
+
+	if err := p.handshake(h.status(p.version)); err != nil {
+		p.Log().Debug("Light server handshake failed", "err", err)
+		return err
+	}
+	if err := h.peers.Register(p); err != nil {
+		p.Log().Error("Light server registration failed", "err", err)
+		return err
+	}
+	if err := h.downloader.RegisterPeer(p.id, downloaderVersion, p); err != nil {
+		h.peers.Unregister(p.id)
+		return err
+	}
+	defer h.removePeer(p.id)
+
+	h.requestSync(p)
+	for {
+		if err := h.handleMsg(p); err != nil {
+			p.Log().Debug("Light server message handling failed", "err", err)
+			return err
+		}
+	}
+}
+
+// removePeer unregisters a server from the downloader and the peer set and
+// disconnects it.
+func (h *clientHandler) removePeer(id string) {
+	p := h.peers.Peer(id)
+	if p == nil {
+		return
+	}
+	h.downloader.UnregisterPeer(id)
+	h.peers.Unregister(id)
+	p.Disconnect(p2p.DiscUselessPeer)
+}
+
+// requestSync schedules a synchronisation with the given server unless one is
+// already pending.
+func (h *clientHandler) requestSync(p *peer) {
+	select {
+	case h.syncCh <- p:
+	default:
+	}
+}
+
+// handleMsg is invoked whenever an inbound message is received from a les
+// server. The remote connection is torn down upon returning any error.
+func (h *clientHandler) handleMsg(p *peer) error {
+	msg, err := p.rw.ReadMsg()
+	if err != nil {
+		return err
+	}
+	defer msg.Discard()
+
+	if msg.Size > protocolMaxMsgSize {
+		return errResp(ErrMsgTooLarge, "%v > %v", msg.Size, protocolMaxMsgSize)
+	}
+	var deliver *Msg
+	switch msg.Code {
+	case StatusMsg:
+		return errResp(ErrExtraStatusMsg, "uncontrolled status message")
+
+	case AnnounceMsg:
+		var req announceData
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+		if err := req.sanityCheck(); err != nil {
+			return errResp(ErrDecode, "%v", err)
+		}
+		p.Log().Trace("Announce message content", "number", req.Number, "hash", req.Hash, "td", req.Td)
+		p.setHead(blockInfo{Hash: req.Hash, Number: req.Number, Td: req.Td})
+		h.requestSync(p)
+		return nil
+
+	case BlockHeadersMsg:
+		var resp blockHeadersPacket
+		if err := msg.Decode(&resp); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		// Headers not requested by the retriever belong to the downloader
+		if h.retriever.deliver(p, &Msg{MsgType: BlockHeadersMsg, ReqID: resp.ReqID, Obj: resp.Headers}) == nil {
+			return nil
+		}
+		if err := h.downloader.DeliverHeaders(p.id, resp.Headers); err != nil {
+			p.Log().Debug("Failed to deliver headers", "err", err)
+		}
+		return nil
+
+	case BlockBodiesMsg:
+		var resp blockBodiesPacket
+		if err := msg.Decode(&resp); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		deliver = &Msg{MsgType: BlockBodiesMsg, ReqID: resp.ReqID, Obj: resp.Data}
+
+	case ReceiptsMsg:
+		var resp receiptsPacket
+		if err := msg.Decode(&resp); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		deliver = &Msg{MsgType: ReceiptsMsg, ReqID: resp.ReqID, Obj: resp.Receipts}
+
+	case CodeMsg:
+		var resp codePacket
+		if err := msg.Decode(&resp); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		deliver = &Msg{MsgType: CodeMsg, ReqID: resp.ReqID, Obj: resp.Data}
+
+	case ProofsMsg:
+		var resp proofsPacket
+		if err := msg.Decode(&resp); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		deliver = &Msg{MsgType: ProofsMsg, ReqID: resp.ReqID, Obj: resp.Data}
+
+	case HelperTrieProofsMsg:
+		var resp helperTrieProofsPacket
+		if err := msg.Decode(&resp); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		deliver = &Msg{MsgType: HelperTrieProofsMsg, ReqID: resp.ReqID, Obj: resp.Data}
+
+	case TxLookupMsg:
+		var resp txLookupPacket
+		if err := msg.Decode(&resp); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		deliver = &Msg{MsgType: TxLookupMsg, ReqID: resp.ReqID, Obj: resp.Lookups}
+
+	default:
+		return errResp(ErrInvalidMsgCode, "%v", msg.Code)
+	}
+	// Late responses to timed out requests are harmless, only log them
+	if err := h.retriever.deliver(p, deliver); err != nil {
+		p.Log().Debug("Dropped light response", "code", msg.Code, "err", err)
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"encoding/binary"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/light"
+	"github.com/CortexFoundation/CortexTheseus/p2p"
+	"github.com/CortexFoundation/CortexTheseus/params"
+	"github.com/CortexFoundation/CortexTheseus/rlp"
+	"github.com/CortexFoundation/CortexTheseus/trie"
+)
+
+// newTestClientPeer connects a raw message pipe to the server as a light
+// client, executing the handshake.
+func newTestClientPeer(t *testing.T, srv *LesServer) p2p.MsgReadWriter {
+	client, server := newTestPeerPair(t, "client")
+	go srv.runPeer(server)
+
+	head := srv.blockchain.CurrentHeader()
+	status := &statusData{
+		ProtocolVersion: lpv1,
+		NetworkId:       srv.networkId,
+		HeadTd:          srv.blockchain.GetTd(head.Hash(), head.Number.Uint64()),
+		HeadHash:        head.Hash(),
+		HeadNum:         head.Number.Uint64(),
+		GenesisHash:     srv.blockchain.Genesis().Hash(),
+	}
+	if err := p2p.Send(client.rw, StatusMsg, status); err != nil {
+		t.Fatalf("status send: %v", err)
+	}
+	status.Server = true
+	if err := p2p.ExpectMsg(client.rw, StatusMsg, status); err != nil {
+		t.Fatalf("status recv: %v", err)
+	}
+	return client.rw
+}
+
+// Tests that block headers can be retrieved by number and hash, in both
+// directions.
+func TestGetBlockHeaders(t *testing.T) {
+	srv, blocks := newTestServer(t, 16)
+	rw := newTestClientPeer(t, srv)
+
+	tests := []struct {
+		query  getBlockHeadersData
+		expect []*types.Block
+	}{
+		{getBlockHeadersData{Origin: hashOrNumber{Number: 1}, Amount: 3}, blocks[0:3]},
+		{getBlockHeadersData{Origin: hashOrNumber{Number: 2}, Amount: 3, Skip: 2}, []*types.Block{blocks[1], blocks[4], blocks[7]}},
+		{getBlockHeadersData{Origin: hashOrNumber{Hash: blocks[9].Hash()}, Amount: 3, Reverse: true}, []*types.Block{blocks[9], blocks[8], blocks[7]}},
+		{getBlockHeadersData{Origin: hashOrNumber{Number: 15}, Amount: 5}, blocks[14:16]},
+		{getBlockHeadersData{Origin: hashOrNumber{Hash: common.Hash{0xff}}, Amount: 1}, nil},
+	}
+	for i, tt := range tests {
+		if err := p2p.Send(rw, GetBlockHeadersMsg, &getBlockHeadersPacket{ReqID: uint64(i), Query: tt.query}); err != nil {
+			t.Fatalf("test %d: failed to send request: %v", i, err)
+		}
+		headers := []*types.Header{}
+		for _, block := range tt.expect {
+			headers = append(headers, block.Header())
+		}
+		if err := p2p.ExpectMsg(rw, BlockHeadersMsg, &blockHeadersPacket{ReqID: uint64(i), Headers: headers}); err != nil {
+			t.Errorf("test %d: headers mismatch: %v", i, err)
+		}
+	}
+}
+
+// Tests that block bodies and receipts are served for known blocks only.
+func TestGetBlockBodiesAndReceipts(t *testing.T) {
+	srv, blocks := newTestServer(t, 8)
+	rw := newTestClientPeer(t, srv)
+
+	hashes := []common.Hash{blocks[1].Hash(), {0xff}, blocks[5].Hash()}
+	if err := p2p.Send(rw, GetBlockBodiesMsg, &getHashesPacket{ReqID: 1, Hashes: hashes}); err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	bodies := []rlp.RawValue{
+		rawdb.ReadBodyRLP(srv.chainDb, blocks[1].Hash(), 2),
+		rawdb.ReadBodyRLP(srv.chainDb, blocks[5].Hash(), 6),
+	}
+	if err := p2p.ExpectMsg(rw, BlockBodiesMsg, &blockBodiesPacket{ReqID: 1, Data: bodies}); err != nil {
+		t.Errorf("bodies mismatch: %v", err)
+	}
+	if err := p2p.Send(rw, GetReceiptsMsg, &getHashesPacket{ReqID: 2, Hashes: hashes}); err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	receipts := []types.Receipts{
+		rawdb.ReadRawReceipts(srv.chainDb, blocks[1].Hash(), 2),
+		rawdb.ReadRawReceipts(srv.chainDb, blocks[5].Hash(), 6),
+	}
+	if err := p2p.ExpectMsg(rw, ReceiptsMsg, &receiptsPacket{ReqID: 2, Receipts: receipts}); err != nil {
+		t.Errorf("receipts mismatch: %v", err)
+	}
+}
+
+// Tests that contract codes and state proofs are served against the state root
+// of the requested block.
+func TestGetCodeAndProofs(t *testing.T) {
+	srv, blocks := newTestServer(t, 4)
+	rw := newTestClientPeer(t, srv)
+
+	head := blocks[len(blocks)-1]
+	codeReq := CodeReq{BHash: head.Hash(), AccKey: crypto.Keccak256(testContractAddr[:])}
+	if err := p2p.Send(rw, GetCodeMsg, &getCodePacket{ReqID: 1, Reqs: []CodeReq{codeReq}}); err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	if err := p2p.ExpectMsg(rw, CodeMsg, &codePacket{ReqID: 1, Data: [][]byte{testContractCode}}); err != nil {
+		t.Errorf("code mismatch: %v", err)
+	}
+	// Request an account and a storage proof, both merged into a single node list
+	accKey, storageKey := crypto.Keccak256(testBankAddress[:]), crypto.Keccak256(testStorageKey[:])
+	reqs := []ProofReq{
+		{BHash: head.Hash(), Key: accKey},
+		{BHash: head.Hash(), AccKey: crypto.Keccak256(testContractAddr[:]), Key: storageKey},
+	}
+	if err := p2p.Send(rw, GetProofsMsg, &getProofsPacket{ReqID: 2, Reqs: reqs}); err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	msg, err := rw.ReadMsg()
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	var resp proofsPacket
+	if err := msg.Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.ReqID != 2 {
+		t.Fatalf("request id mismatch: have %d, want 2", resp.ReqID)
+	}
+	nodes := resp.Data.NodeSet()
+	if _, err := trie.VerifyProof(head.Root(), accKey, nodes); err != nil {
+		t.Errorf("account proof invalid: %v", err)
+	}
+	statedb, err := srv.blockchain.StateAt(head.Root())
+	if err != nil {
+		t.Fatalf("failed to open state: %v", err)
+	}
+	if _, err := trie.VerifyProof(statedb.StorageTrie(testContractAddr).Hash(), storageKey, nodes); err != nil {
+		t.Errorf("storage proof invalid: %v", err)
+	}
+}
+
+// Tests that canonical hash trie proofs are served along with the requested
+// headers once a section is indexed.
+func TestGetCHTProofs(t *testing.T) {
+	config := light.TestServerIndexerConfig
+	srv, blocks := newTestServer(t, int(config.ChtSize+config.ChtConfirms))
+	waitIndexed(t, srv, 1)
+	rw := newTestClientPeer(t, srv)
+
+	sectionHead := blocks[config.ChtSize-2].Hash()
+	root := light.GetChtRoot(srv.chainDb, 0, sectionHead)
+
+	var key [8]byte
+	binary.BigEndian.PutUint64(key[:], 1)
+	req := HelperTrieReq{Type: htCanonical, TrieIdx: 0, Key: key[:], AuxReq: htAuxHeader}
+	if err := p2p.Send(rw, GetHelperTrieProofsMsg, &getHelperTrieProofsPacket{ReqID: 1, Reqs: []HelperTrieReq{req}}); err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	msg, err := rw.ReadMsg()
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	var resp helperTrieProofsPacket
+	if err := msg.Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	value, err := trie.VerifyProof(root, key[:], resp.Data.Proofs.NodeSet())
+	if err != nil {
+		t.Fatalf("canonical hash trie proof invalid: %v", err)
+	}
+	var node light.ChtNode
+	if err := rlp.DecodeBytes(value, &node); err != nil {
+		t.Fatalf("failed to decode trie entry: %v", err)
+	}
+	if node.Hash != blocks[0].Hash() {
+		t.Errorf("trie entry mismatch: have %x, want %x", node.Hash, blocks[0].Hash())
+	}
+	if len(resp.Data.AuxData) != 1 {
+		t.Fatalf("auxiliary data count mismatch: have %d, want 1", len(resp.Data.AuxData))
+	}
+	var header types.Header
+	if err := rlp.DecodeBytes(resp.Data.AuxData[0], &header); err != nil || header.Hash() != blocks[0].Hash() {
+		t.Errorf("auxiliary header mismatch: %v", err)
+	}
+}
+
+// Tests that transactions are looked up by hash and relayed ones are added to
+// the transaction pool.
+func TestTxLookupAndSend(t *testing.T) {
+	srv, blocks := newTestServer(t, 4)
+	rw := newTestClientPeer(t, srv)
+
+	known := blocks[2].Transactions()[0].Hash()
+	if err := p2p.Send(rw, GetTxLookupMsg, &getHashesPacket{ReqID: 1, Hashes: []common.Hash{known, {0xff}}}); err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	lookups := []txLookup{{BlockHash: blocks[2].Hash(), BlockNumber: 3, Index: 0}, {}}
+	if err := p2p.ExpectMsg(rw, TxLookupMsg, &txLookupPacket{ReqID: 1, Lookups: lookups}); err != nil {
+		t.Errorf("lookup mismatch: %v", err)
+	}
+	signer := types.NewEIP155Signer(params.TestChainConfig.ChainID)
+	tx, _ := types.SignTx(types.NewTransaction(uint64(len(blocks)), testRecipient, big.NewInt(1), params.TxGas, big.NewInt(params.GWei), nil), signer, testBankKey)
+	if err := p2p.Send(rw, SendTxMsg, &sendTxPacket{ReqID: 2, Txs: []*types.Transaction{tx}}); err != nil {
+		t.Fatalf("failed to send transaction: %v", err)
+	}
+	for start := time.Now(); srv.txpool.Get(tx.Hash()) == nil; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("relayed transaction not added to the pool")
+		}
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+// This file contains some shares testing functionality, common to multiple
+// different files and modules being tested.
+
+package les
+
+import (
+	"crypto/rand"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/consensus/cuckoo"
+	"github.com/CortexFoundation/CortexTheseus/core"
+	"github.com/CortexFoundation/CortexTheseus/core/bloombits"
+	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/core/vm"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/ctxc"
+	"github.com/CortexFoundation/CortexTheseus/event"
+	"github.com/CortexFoundation/CortexTheseus/light"
+	"github.com/CortexFoundation/CortexTheseus/p2p"
+	"github.com/CortexFoundation/CortexTheseus/p2p/enode"
+	"github.com/CortexFoundation/CortexTheseus/params"
+)
+
+var (
+	testBankKey, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	testBankAddress = crypto.PubkeyToAddress(testBankKey.PublicKey)
+	testBankFunds   = big.NewInt(1000000000000000000)
+
+	testContractAddr = common.Address{0xcc}
+	testContractCode = common.Hex2Bytes("606060405260cc8060106000396000f360606040526000357c01000000000000000000000000000000000000000000000000000000009004")
+	testStorageKey   = common.Hash{0x01}
+	testStorageValue = common.Hash{0x02}
+
+	testRecipient = common.Address{0xaa}
+
+	testGenesis = &core.Genesis{
+		Config: params.TestChainConfig,
+		Alloc: core.GenesisAlloc{
+			testBankAddress: {Balance: testBankFunds},
+			testContractAddr: {
+				Balance: big.NewInt(0),
+				Code:    testContractCode,
+				Storage: map[common.Hash]common.Hash{testStorageKey: testStorageValue},
+			},
+		},
+		Supply: params.CTXC_INIT,
+	}
+)
+
+// newTestServer creates a light server on top of a full chain of the given
+// length, every block carrying a single value transfer.
+func newTestServer(t *testing.T, blocks int) (*LesServer, []*types.Block) {
+	var (
+		db      = rawdb.NewMemoryDatabase()
+		signer  = types.NewEIP155Signer(params.TestChainConfig.ChainID)
+		genesis = testGenesis.MustCommit(db)
+	)
+	chain, _ := core.GenerateChain(params.TestChainConfig, genesis, cuckoo.NewFaker(), db, blocks, func(i int, gen *core.BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(gen.TxNonce(testBankAddress), testRecipient, big.NewInt(1), params.TxGas, big.NewInt(1), nil), signer, testBankKey)
+		gen.AddTx(tx)
+	})
+	blockchain, err := core.NewBlockChain(db, &core.CacheConfig{TrieDirtyDisabled: true}, params.TestChainConfig, cuckoo.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create full chain: %v", err)
+	}
+	if n, err := blockchain.InsertChain(chain); err != nil {
+		t.Fatalf("failed to insert block %d: %v", n, err)
+	}
+	poolConfig := core.DefaultTxPoolConfig
+	poolConfig.Journal = ""
+	txpool := core.NewTxPool(poolConfig, params.TestChainConfig, blockchain)
+
+	config := ctxc.DefaultConfig
+	config.LightServ = 100
+	srv := newLesServer(&config, light.TestServerIndexerConfig, blockchain, txpool, db)
+	srv.chtIndexer.Start(blockchain)
+
+	t.Cleanup(func() {
+		srv.Stop()
+		srv.bloomTrieIndexer.Close()
+		txpool.Stop()
+		blockchain.Stop()
+	})
+	srv.Start(nil)
+	return srv, chain
+}
+
+// waitIndexed waits until the CHT indexer of the server generated the given
+// number of sections.
+func waitIndexed(t *testing.T, srv *LesServer, sections uint64) {
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if have, _, _ := srv.chtIndexer.Sections(); have >= sections {
+			return
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("canonical hash trie section %d not generated", sections)
+		}
+	}
+}
+
+// newTestClient creates a light client over an empty database containing only
+// the genesis block, optionally trusting the given checkpoint.
+func newTestClient(t *testing.T, checkpoint *params.TrustedCheckpoint) *LightCortex {
+	db := rawdb.NewMemoryDatabase()
+	testGenesis.MustCommit(db)
+
+	config := ctxc.DefaultConfig
+	peers := newPeerSet()
+	lc := &LightCortex{
+		config:            &config,
+		chainConfig:       params.TestChainConfig,
+		chainDb:           db,
+		eventMux:          new(event.TypeMux),
+		engine:            cuckoo.NewFaker(),
+		peers:             peers,
+		retriever:         newRetrieveManager(peers),
+		bloomRequests:     make(chan chan *bloombits.Retrieval),
+		closeBloomHandler: make(chan struct{}),
+		networkId:         config.NetworkId,
+	}
+	lc.odr = NewLesOdr(db, light.TestClientIndexerConfig, lc.retriever)
+	lc.relay = newLesTxRelay(peers)
+	lc.chtIndexer = light.NewChtIndexer(db, lc.odr.IndexerConfig().ChtSize, lc.odr.IndexerConfig().ChtConfirms)
+	lc.bloomTrieIndexer = light.NewBloomTrieIndexer(db, lc.odr.IndexerConfig().BloomSize, lc.odr.IndexerConfig().BloomTrieSize)
+	lc.odr.SetIndexers(lc.chtIndexer, lc.bloomTrieIndexer, nil)
+
+	var err error
+	if lc.blockchain, err = light.NewLightChain(lc.odr, lc.chainConfig, lc.engine, checkpoint); err != nil {
+		t.Fatalf("failed to create light chain: %v", err)
+	}
+	lc.txPool = light.NewTxPool(lc.chainConfig, lc.blockchain, lc.relay)
+	lc.handler = newClientHandler(lc, checkpoint, nil)
+	lc.ApiBackend = &LesApiBackend{lc, nil}
+
+	t.Cleanup(func() { lc.Stop() })
+	lc.startBloomHandlers(lc.odr.IndexerConfig().BloomTrieSize)
+	lc.handler.start()
+	return lc
+}
+
+// newTestPeerPair creates the two ends of a les connection, returning the
+// server as seen by the client and the client as seen by the server.
+func newTestPeerPair(t *testing.T, name string) (client *peer, server *peer) {
+	app, net := p2p.MsgPipe()
+	t.Cleanup(func() {
+		app.Close()
+		net.Close()
+	})
+
+	var clientID, serverID enode.ID
+	rand.Read(clientID[:])
+	rand.Read(serverID[:])
+
+	client = newPeer(lpv1, ctxc.DefaultConfig.NetworkId, p2p.NewPeer(serverID, name, nil), app)
+	server = newPeer(lpv1, ctxc.DefaultConfig.NetworkId, p2p.NewPeer(clientID, name, nil), net)
+	return client, server
+}
+
+// connect runs a les connection between the client and the server, waiting
+// until both sides registered the other one.
+func connect(t *testing.T, lc *LightCortex, srv *LesServer) {
+	client, server := newTestPeerPair(t, "test")
+	go srv.runPeer(server)
+	go lc.handler.runPeer(client)
+
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if lc.peers.Len() > 0 && srv.peers.Len() > 0 {
+			return
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("les handshake timed out")
+		}
+	}
+}
+
+// waitSynced waits until the light client imported the header chain of the
+// server.
+func waitSynced(t *testing.T, lc *LightCortex, number uint64) {
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if lc.blockchain.CurrentHeader().Number.Uint64() >= number {
+			return
+		}
+		if time.Since(start) > 10*time.Second {
+			t.Fatalf("light client not synced: have #%d, want #%d", lc.blockchain.CurrentHeader().Number, number)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/CortexFoundation/CortexTheseus/core"
+	"github.com/CortexFoundation/CortexTheseus/ctxcdb"
+	"github.com/CortexFoundation/CortexTheseus/light"
+	"github.com/CortexFoundation/CortexTheseus/log"
+)
+
+// LesOdr implements light.OdrBackend
+type LesOdr struct {
+	db                                         ctxcdb.Database
+	indexerConfig                              *light.IndexerConfig
+	chtIndexer, bloomTrieIndexer, bloomIndexer *core.ChainIndexer
+	retriever                                  *retrieveManager
+}
+
+// NewLesOdr creates an ODR backend retrieving data from les servers.
+func NewLesOdr(db ctxcdb.Database, config *light.IndexerConfig, retriever *retrieveManager) *LesOdr {
+	return &LesOdr{
+		db:            db,
+		indexerConfig: config,
+		retriever:     retriever,
+	}
+}
+
+// Database returns the backing database
+func (odr *LesOdr) Database() ctxcdb.Database {
+	return odr.db
+}
+
+// SetIndexers adds the necessary chain indexers to the ODR backend
+func (odr *LesOdr) SetIndexers(chtIndexer, bloomTrieIndexer, bloomIndexer *core.ChainIndexer) {
+	odr.chtIndexer = chtIndexer
+	odr.bloomTrieIndexer = bloomTrieIndexer
+	odr.bloomIndexer = bloomIndexer
+}
+
+// ChtIndexer returns the CHT chain indexer
+func (odr *LesOdr) ChtIndexer() *core.ChainIndexer {
+	return odr.chtIndexer
+}
+
+// BloomTrieIndexer returns the bloom trie chain indexer
+func (odr *LesOdr) BloomTrieIndexer() *core.ChainIndexer {
+	return odr.bloomTrieIndexer
+}
+
+// BloomIndexer returns the bloombits chain indexer
+func (odr *LesOdr) BloomIndexer() *core.ChainIndexer {
+	return odr.bloomIndexer
+}
+
+// IndexerConfig returns the indexer config.
+func (odr *LesOdr) IndexerConfig() *light.IndexerConfig {
+	return odr.indexerConfig
+}
+
+// Retrieve tries to fetch an object from the LES network. If the network
+// retrieval was successful, it stores the object in local db.
+func (odr *LesOdr) Retrieve(ctx context.Context, req light.OdrRequest) error {
+	lreq := LesRequest(req)
+	if lreq == nil {
+		return fmt.Errorf("unsupported odr request %T", req)
+	}
+	if err := odr.retriever.retrieve(ctx, lreq, odr.db); err != nil {
+		log.Debug("Failed to retrieve data from network", "err", err)
+		return err
+	}
+	// retrieved from network, store in db
+	req.StoreResult(odr.db)
+	return nil
+}
@@ -0,0 +1,429 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/ctxcdb"
+	"github.com/CortexFoundation/CortexTheseus/light"
+	"github.com/CortexFoundation/CortexTheseus/rlp"
+	"github.com/CortexFoundation/CortexTheseus/trie"
+)
+
+var (
+	errInvalidMessageType  = errors.New("invalid message type")
+	errInvalidEntryCount   = errors.New("invalid number of response entries")
+	errHeaderUnavailable   = errors.New("header unavailable")
+	errTxHashMismatch      = errors.New("transaction hash mismatch")
+	errUncleHashMismatch   = errors.New("uncle hash mismatch")
+	errReceiptHashMismatch = errors.New("receipt hash mismatch")
+	errDataHashMismatch    = errors.New("data hash mismatch")
+	errCHTHashMismatch     = errors.New("cht hash mismatch")
+	errCHTNumberMismatch   = errors.New("cht number mismatch")
+	errUselessNodes        = errors.New("useless nodes in merkle proof nodeset")
+)
+
+// Msg encodes a les response message for delivery to the retriever.
+type Msg struct {
+	MsgType int
+	ReqID   uint64
+	Obj     interface{}
+}
+
+// LesOdrRequest is a light.OdrRequest which can be sent to a les server and
+// whose response can be validated.
+type LesOdrRequest interface {
+	CanSend(*peer) bool
+	Request(uint64, *peer) error
+	Validate(ctxcdb.Database, *Msg) error
+}
+
+// LesRequest wraps the given light.OdrRequest into its les counterpart.
+func LesRequest(req light.OdrRequest) LesOdrRequest {
+	switch r := req.(type) {
+	case *light.BlockRequest:
+		return (*BlockRequest)(r)
+	case *light.ReceiptsRequest:
+		return (*ReceiptsRequest)(r)
+	case *light.TrieRequest:
+		return (*TrieRequest)(r)
+	case *light.CodeRequest:
+		return (*CodeRequest)(r)
+	case *light.ChtRequest:
+		return (*ChtRequest)(r)
+	case *light.BloomRequest:
+		return (*BloomRequest)(r)
+	case *light.TxLookupRequest:
+		return (*TxLookupRequest)(r)
+	default:
+		return nil
+	}
+}
+
+// BlockRequest is the ODR request type for block bodies
+type BlockRequest light.BlockRequest
+
+// CanSend tells if a certain peer is suitable for serving the given request
+func (r *BlockRequest) CanSend(peer *peer) bool {
+	return peer.headBlockInfo().Number >= r.Number
+}
+
+// Request sends an ODR request to the LES network (implementation of LesOdrRequest)
+func (r *BlockRequest) Request(reqID uint64, peer *peer) error {
+	peer.Log().Debug("Requesting block body", "hash", r.Hash)
+	return peer.requestBodies(reqID, []common.Hash{r.Hash})
+}
+
+// Validate processes an ODR request reply message from the LES network
+// returns true and stores results in memory if the message was a valid reply
+// to the request (implementation of LesOdrRequest)
+func (r *BlockRequest) Validate(db ctxcdb.Database, msg *Msg) error {
+	// Ensure we have a correct message with a single block body
+	if msg.MsgType != BlockBodiesMsg {
+		return errInvalidMessageType
+	}
+	bodies := msg.Obj.([]rlp.RawValue)
+	if len(bodies) != 1 {
+		return errInvalidEntryCount
+	}
+	body := new(types.Body)
+	if err := rlp.DecodeBytes(bodies[0], body); err != nil {
+		return err
+	}
+	// Retrieve our stored header and validate block content against it
+	if r.Header == nil {
+		return errHeaderUnavailable
+	}
+	if r.Header.TxHash != types.DeriveSha(types.Transactions(body.Transactions), trie.NewStackTrie(nil)) {
+		return errTxHashMismatch
+	}
+	if r.Header.UncleHash != types.CalcUncleHash(body.Uncles) {
+		return errUncleHashMismatch
+	}
+	// Validations passed, store and return
+	r.Rlp = bodies[0]
+	return nil
+}
+
+// ReceiptsRequest is the ODR request type for block receipts by block hash
+type ReceiptsRequest light.ReceiptsRequest
+
+// CanSend tells if a certain peer is suitable for serving the given request
+func (r *ReceiptsRequest) CanSend(peer *peer) bool {
+	return peer.headBlockInfo().Number >= r.Number
+}
+
+// Request sends an ODR request to the LES network (implementation of LesOdrRequest)
+func (r *ReceiptsRequest) Request(reqID uint64, peer *peer) error {
+	peer.Log().Debug("Requesting block receipts", "hash", r.Hash)
+	return peer.requestReceipts(reqID, []common.Hash{r.Hash})
+}
+
+// Validate processes an ODR request reply message from the LES network
+// returns true and stores results in memory if the message was a valid reply
+// to the request (implementation of LesOdrRequest)
+func (r *ReceiptsRequest) Validate(db ctxcdb.Database, msg *Msg) error {
+	// Ensure we have a correct message with a single block receipt
+	if msg.MsgType != ReceiptsMsg {
+		return errInvalidMessageType
+	}
+	receipts := msg.Obj.([]types.Receipts)
+	if len(receipts) != 1 {
+		return errInvalidEntryCount
+	}
+	receipt := receipts[0]
+
+	// Retrieve our stored header and validate receipt content against it
+	if r.Header == nil {
+		return errHeaderUnavailable
+	}
+	if r.Header.ReceiptHash != types.DeriveSha(receipt, trie.NewStackTrie(nil)) {
+		return errReceiptHashMismatch
+	}
+	// Validations passed, store and return
+	r.Receipts = receipt
+	return nil
+}
+
+// TrieRequest is the ODR request type for state/storage trie entries
+type TrieRequest light.TrieRequest
+
+// CanSend tells if a certain peer is suitable for serving the given request
+func (r *TrieRequest) CanSend(peer *peer) bool {
+	return peer.headBlockInfo().Number >= r.Id.BlockNumber
+}
+
+// Request sends an ODR request to the LES network (implementation of LesOdrRequest)
+func (r *TrieRequest) Request(reqID uint64, peer *peer) error {
+	peer.Log().Debug("Requesting trie proof", "root", r.Id.Root, "key", r.Key)
+	req := ProofReq{
+		BHash:  r.Id.BlockHash,
+		AccKey: r.Id.AccKey,
+		Key:    r.Key,
+	}
+	return peer.requestProofs(reqID, []ProofReq{req})
+}
+
+// Validate processes an ODR request reply message from the LES network
+// returns true and stores results in memory if the message was a valid reply
+// to the request (implementation of LesOdrRequest)
+func (r *TrieRequest) Validate(db ctxcdb.Database, msg *Msg) error {
+	if msg.MsgType != ProofsMsg {
+		return errInvalidMessageType
+	}
+	proofs := msg.Obj.(light.NodeList)
+	// Verify the proof and store if checks out
+	nodeSet := proofs.NodeSet()
+	reads := &readTraceDB{db: nodeSet}
+	if _, err := trie.VerifyProof(r.Id.Root, r.Key, reads); err != nil {
+		return fmt.Errorf("merkle proof verification failed: %v", err)
+	}
+	// check if all nodes have been read by VerifyProof
+	if len(reads.reads) != nodeSet.KeyCount() {
+		return errUselessNodes
+	}
+	r.Proof = nodeSet
+	return nil
+}
+
+// CodeRequest is the ODR request type for node data (used for retrieving contract code)
+type CodeRequest light.CodeRequest
+
+// CanSend tells if a certain peer is suitable for serving the given request
+func (r *CodeRequest) CanSend(peer *peer) bool {
+	return peer.headBlockInfo().Number >= r.Id.BlockNumber
+}
+
+// Request sends an ODR request to the LES network (implementation of LesOdrRequest)
+func (r *CodeRequest) Request(reqID uint64, peer *peer) error {
+	peer.Log().Debug("Requesting code data", "hash", r.Hash)
+	req := CodeReq{
+		BHash:  r.Id.BlockHash,
+		AccKey: r.Id.AccKey,
+	}
+	return peer.requestCode(reqID, []CodeReq{req})
+}
+
+// Validate processes an ODR request reply message from the LES network
+// returns true and stores results in memory if the message was a valid reply
+// to the request (implementation of LesOdrRequest)
+func (r *CodeRequest) Validate(db ctxcdb.Database, msg *Msg) error {
+	// Ensure we have a correct message with a single code element
+	if msg.MsgType != CodeMsg {
+		return errInvalidMessageType
+	}
+	reply := msg.Obj.([][]byte)
+	if len(reply) != 1 {
+		return errInvalidEntryCount
+	}
+	data := reply[0]
+
+	// Verify the data and store if checks out
+	if hash := crypto.Keccak256Hash(data); r.Hash != hash {
+		return errDataHashMismatch
+	}
+	r.Data = data
+	return nil
+}
+
+// ChtRequest is the ODR request type for state/storage trie entries
+type ChtRequest light.ChtRequest
+
+// CanSend tells if a certain peer is suitable for serving the given request
+func (r *ChtRequest) CanSend(peer *peer) bool {
+	return peer.headBlockInfo().Number >= (r.ChtNum+1)*r.Config.ChtSize-1
+}
+
+// Request sends an ODR request to the LES network (implementation of LesOdrRequest)
+func (r *ChtRequest) Request(reqID uint64, peer *peer) error {
+	peer.Log().Debug("Requesting CHT", "cht", r.ChtNum, "block", r.BlockNum)
+	var encNum [8]byte
+	binary.BigEndian.PutUint64(encNum[:], r.BlockNum)
+	req := HelperTrieReq{
+		Type:    htCanonical,
+		TrieIdx: r.ChtNum,
+		Key:     encNum[:],
+		AuxReq:  htAuxHeader,
+	}
+	return peer.requestHelperTrieProofs(reqID, []HelperTrieReq{req})
+}
+
+// Validate processes an ODR request reply message from the LES network
+// returns true and stores results in memory if the message was a valid reply
+// to the request (implementation of LesOdrRequest)
+func (r *ChtRequest) Validate(db ctxcdb.Database, msg *Msg) error {
+	if msg.MsgType != HelperTrieProofsMsg {
+		return errInvalidMessageType
+	}
+	resp := msg.Obj.(HelperTrieResps)
+	if len(resp.AuxData) != 1 {
+		return errInvalidEntryCount
+	}
+	nodeSet := resp.Proofs.NodeSet()
+	headerEnc := resp.AuxData[0]
+	if len(headerEnc) == 0 {
+		return errHeaderUnavailable
+	}
+	header := new(types.Header)
+	if err := rlp.DecodeBytes(headerEnc, header); err != nil {
+		return errHeaderUnavailable
+	}
+	// Verify the CHT
+	var encNumber [8]byte
+	binary.BigEndian.PutUint64(encNumber[:], r.BlockNum)
+
+	reads := &readTraceDB{db: nodeSet}
+	value, err := trie.VerifyProof(r.ChtRoot, encNumber[:], reads)
+	if err != nil {
+		return fmt.Errorf("merkle proof verification failed: %v", err)
+	}
+	if len(reads.reads) != nodeSet.KeyCount() {
+		return errUselessNodes
+	}
+	var node light.ChtNode
+	if err := rlp.DecodeBytes(value, &node); err != nil {
+		return err
+	}
+	if node.Hash != header.Hash() {
+		return errCHTHashMismatch
+	}
+	if r.BlockNum != header.Number.Uint64() {
+		return errCHTNumberMismatch
+	}
+	// Verifications passed, store and return
+	r.Header = header
+	r.Proof = nodeSet
+	r.Td = node.Td
+	return nil
+}
+
+// BloomRequest is the ODR request type for bloom bits of a set of sections
+type BloomRequest light.BloomRequest
+
+// CanSend tells if a certain peer is suitable for serving the given request
+func (r *BloomRequest) CanSend(peer *peer) bool {
+	return peer.headBlockInfo().Number >= (r.BloomTrieNum+1)*r.Config.BloomTrieSize-1
+}
+
+// Request sends an ODR request to the LES network (implementation of LesOdrRequest)
+func (r *BloomRequest) Request(reqID uint64, peer *peer) error {
+	peer.Log().Debug("Requesting BloomBits", "bloomTrie", r.BloomTrieNum, "bitIdx", r.BitIdx, "sections", r.SectionIndexList)
+	reqs := make([]HelperTrieReq, len(r.SectionIndexList))
+
+	var encNumber [10]byte
+	binary.BigEndian.PutUint16(encNumber[:2], uint16(r.BitIdx))
+
+	for i, sectionIdx := range r.SectionIndexList {
+		binary.BigEndian.PutUint64(encNumber[2:], sectionIdx)
+		reqs[i] = HelperTrieReq{
+			Type:    htBloomBits,
+			TrieIdx: r.BloomTrieNum,
+			Key:     common.CopyBytes(encNumber[:]),
+		}
+	}
+	return peer.requestHelperTrieProofs(reqID, reqs)
+}
+
+// Validate processes an ODR request reply message from the LES network
+// returns true and stores results in memory if the message was a valid reply
+// to the request (implementation of LesOdrRequest)
+func (r *BloomRequest) Validate(db ctxcdb.Database, msg *Msg) error {
+	if msg.MsgType != HelperTrieProofsMsg {
+		return errInvalidMessageType
+	}
+	resps := msg.Obj.(HelperTrieResps)
+	proofs := resps.Proofs
+	nodeSet := proofs.NodeSet()
+	reads := &readTraceDB{db: nodeSet}
+
+	r.BloomBits = make([][]byte, len(r.SectionIndexList))
+
+	// Verify the proofs
+	var encNumber [10]byte
+	binary.BigEndian.PutUint16(encNumber[:2], uint16(r.BitIdx))
+
+	for i, idx := range r.SectionIndexList {
+		binary.BigEndian.PutUint64(encNumber[2:], idx)
+		value, err := trie.VerifyProof(r.BloomTrieRoot, encNumber[:], reads)
+		if err != nil {
+			return err
+		}
+		r.BloomBits[i] = value
+	}
+	if len(reads.reads) != nodeSet.KeyCount() {
+		return errUselessNodes
+	}
+	r.Proofs = nodeSet
+	return nil
+}
+
+// TxLookupRequest is the ODR request type for locating a transaction
+type TxLookupRequest light.TxLookupRequest
+
+// CanSend tells if a certain peer is suitable for serving the given request
+func (r *TxLookupRequest) CanSend(peer *peer) bool {
+	return true
+}
+
+// Request sends an ODR request to the LES network (implementation of LesOdrRequest)
+func (r *TxLookupRequest) Request(reqID uint64, peer *peer) error {
+	peer.Log().Debug("Requesting transaction lookup", "hash", r.Hash)
+	return peer.requestTxLookup(reqID, []common.Hash{r.Hash})
+}
+
+// Validate processes an ODR request reply message from the LES network. The
+// lookup itself is unproven, light.GetTransaction verifies it against the
+// referenced block.
+func (r *TxLookupRequest) Validate(db ctxcdb.Database, msg *Msg) error {
+	if msg.MsgType != TxLookupMsg {
+		return errInvalidMessageType
+	}
+	lookups := msg.Obj.([]txLookup)
+	if len(lookups) != 1 {
+		return errInvalidEntryCount
+	}
+	r.BlockHash, r.BlockNumber, r.Index = lookups[0].BlockHash, lookups[0].BlockNumber, lookups[0].Index
+	return nil
+}
+
+// readTraceDB stores the keys of database reads. We use this to check that received node
+// sets contain only the trie nodes necessary to make proofs pass.
+type readTraceDB struct {
+	db    ctxcdb.KeyValueReader
+	reads map[string]struct{}
+}
+
+// Get returns a stored node
+func (db *readTraceDB) Get(k []byte) ([]byte, error) {
+	if db.reads == nil {
+		db.reads = make(map[string]struct{})
+	}
+	db.reads[string(k)] = struct{}{}
+	return db.db.Get(k)
+}
+
+// Has returns true if the node set contains the given key
+func (db *readTraceDB) Has(key []byte) (bool, error) {
+	_, err := db.Get(key)
+	return err == nil, nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
+	"github.com/CortexFoundation/CortexTheseus/light"
+	"github.com/CortexFoundation/CortexTheseus/rpc"
+)
+
+// Tests that a light client syncs the header chain of a server and retrieves
+// blocks, receipts, transactions and state on demand.
+func TestOdrRetrieval(t *testing.T) {
+	srv, blocks := newTestServer(t, 16)
+	lc := newTestClient(t, nil)
+	connect(t, lc, srv)
+	waitSynced(t, lc, uint64(len(blocks)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	head := blocks[len(blocks)-1]
+	if have := lc.blockchain.CurrentHeader().Hash(); have != head.Hash() {
+		t.Fatalf("head mismatch: have %x, want %x", have, head.Hash())
+	}
+	block, err := lc.ApiBackend.BlockByNumber(ctx, rpc.BlockNumber(5))
+	if err != nil {
+		t.Fatalf("block retrieval failed: %v", err)
+	}
+	if block.Hash() != blocks[4].Hash() || len(block.Transactions()) != 1 {
+		t.Fatalf("block mismatch")
+	}
+	receipts, err := lc.ApiBackend.GetReceipts(ctx, blocks[4].Hash())
+	if err != nil {
+		t.Fatalf("receipts retrieval failed: %v", err)
+	}
+	if len(receipts) != 1 || receipts[0].TxHash != block.Transactions()[0].Hash() {
+		t.Fatalf("receipts mismatch")
+	}
+	want := blocks[7].Transactions()[0]
+	tx, hash, number, index, err := lc.ApiBackend.GetTransaction(ctx, want.Hash())
+	if err != nil {
+		t.Fatalf("transaction retrieval failed: %v", err)
+	}
+	if tx == nil || tx.Hash() != want.Hash() || hash != blocks[7].Hash() || number != 8 || index != 0 {
+		t.Fatalf("transaction mismatch: have %v in %x #%d:%d", tx, hash, number, index)
+	}
+	statedb, _, err := lc.ApiBackend.StateAndHeaderByNumber(ctx, rpc.LatestBlockNumber)
+	if err != nil {
+		t.Fatalf("state retrieval failed: %v", err)
+	}
+	if have := statedb.GetBalance(testRecipient); have.Cmp(big.NewInt(int64(len(blocks)))) != 0 {
+		t.Errorf("recipient balance mismatch: have %v, want %d", have, len(blocks))
+	}
+	if have := statedb.GetCode(testContractAddr); !bytes.Equal(have, testContractCode) {
+		t.Errorf("contract code mismatch: have %x, want %x", have, testContractCode)
+	}
+	if have := statedb.GetState(testContractAddr, testStorageKey); have != testStorageValue {
+		t.Errorf("storage mismatch: have %x, want %x", have, testStorageValue)
+	}
+	if err := statedb.Error(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Tests that retrievals fail without servers instead of hanging.
+func TestOdrNoPeers(t *testing.T) {
+	lc := newTestClient(t, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, _, _, _, err := light.GetTransaction(ctx, lc.odr, common.Hash{0x01}); err != light.ErrNoPeers {
+		t.Fatalf("error mismatch: have %v, want %v", err, light.ErrNoPeers)
+	}
+}
+
+// Tests that a light client trusting a checkpoint skips the headers before it
+// and retrieves them on demand through the canonical hash trie.
+func TestCheckpointSync(t *testing.T) {
+	config := light.TestServerIndexerConfig
+	srv, blocks := newTestServer(t, int(config.ChtSize+config.ChtConfirms))
+	waitIndexed(t, srv, 1)
+
+	checkpoint := srv.localCheckpoint(0)
+	checkpoint.BloomRoot = common.Hash{0x01} // bloom trie is not indexed in the test
+
+	lc := newTestClient(t, &checkpoint)
+	connect(t, lc, srv)
+	waitSynced(t, lc, uint64(len(blocks)))
+
+	if header := rawdb.ReadHeader(lc.chainDb, blocks[0].Hash(), 1); header != nil {
+		t.Fatalf("header before the checkpoint downloaded")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	header, err := lc.blockchain.GetHeaderByNumberOdr(ctx, 1)
+	if err != nil {
+		t.Fatalf("header retrieval failed: %v", err)
+	}
+	if header.Hash() != blocks[0].Hash() {
+		t.Fatalf("header mismatch: have %x, want %x", header.Hash(), blocks[0].Hash())
+	}
+}
@@ -0,0 +1,355 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/p2p"
+	"github.com/CortexFoundation/CortexTheseus/params"
+)
+
+var (
+	errClosed            = errors.New("peer set is closed")
+	errAlreadyRegistered = errors.New("peer is already registered")
+	errNotRegistered     = errors.New("peer is not registered")
+	errNotSupported      = errors.New("not supported by light peers")
+)
+
+const handshakeTimeout = 5 * time.Second
+
+// blockInfo represents the head of a peer's chain.
+type blockInfo struct {
+	Hash   common.Hash // Hash of one particular block being announced
+	Number uint64      // Number of one particular block being announced
+	Td     *big.Int    // Total difficulty of one particular block being announced
+}
+
+// peer represents a remote les node, either a server (seen from a client) or
+// a light client (seen from a server).
+type peer struct {
+	*p2p.Peer
+	rw p2p.MsgReadWriter
+
+	id      string
+	version int
+	network uint64
+
+	lock     sync.RWMutex
+	headInfo blockInfo
+	server   bool // Whether the remote side serves light clients
+
+	// Checkpoint advertised by a server, empty if the server has none
+	checkpoint       params.TrustedCheckpoint
+	checkpointNumber uint64
+}
+
+func newPeer(version int, network uint64, p *p2p.Peer, rw p2p.MsgReadWriter) *peer {
+	return &peer{
+		Peer:    p,
+		rw:      rw,
+		id:      fmt.Sprintf("%x", p.ID().Bytes()[:8]),
+		version: version,
+		network: network,
+	}
+}
+
+// genReqID generates a new random request identifier.
+func genReqID() uint64 {
+	return rand.Uint64()
+}
+
+// Head retrieves a copy of the current head hash and total difficulty of the
+// peer, implementing the downloader's peer interface.
+func (p *peer) Head() (hash common.Hash, td *big.Int) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.headInfo.Hash, new(big.Int).Set(p.headInfo.Td)
+}
+
+// headBlockInfo retrieves a copy of the announced head of the peer.
+func (p *peer) headBlockInfo() blockInfo {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return blockInfo{Hash: p.headInfo.Hash, Number: p.headInfo.Number, Td: new(big.Int).Set(p.headInfo.Td)}
+}
+
+// setHead updates the head of the peer after an announcement.
+func (p *peer) setHead(info blockInfo) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.headInfo = info
+}
+
+// String implements fmt.Stringer.
+func (p *peer) String() string {
+	return fmt.Sprintf("Peer %s [%s]", p.id, fmt.Sprintf("les/%d", p.version))
+}
+
+// announce sends a new head announcement to a light client.
+func (p *peer) announce(data announceData) error {
+	return p2p.Send(p.rw, AnnounceMsg, data)
+}
+
+// RequestHeadersByHash fetches a batch of blocks' headers corresponding to the
+// specified header query, based on the hash of an origin block.
+func (p *peer) RequestHeadersByHash(origin common.Hash, amount int, skip int, reverse bool) error {
+	p.Log().Debug("Fetching batch of headers", "count", amount, "fromhash", origin, "skip", skip, "reverse", reverse)
+	return p.requestHeaders(genReqID(), getBlockHeadersData{Origin: hashOrNumber{Hash: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
+}
+
+// RequestHeadersByNumber fetches a batch of blocks' headers corresponding to the
+// specified header query, based on the number of an origin block.
+func (p *peer) RequestHeadersByNumber(origin uint64, amount int, skip int, reverse bool) error {
+	p.Log().Debug("Fetching batch of headers", "count", amount, "fromnum", origin, "skip", skip, "reverse", reverse)
+	return p.requestHeaders(genReqID(), getBlockHeadersData{Origin: hashOrNumber{Number: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
+}
+
+// RequestBodies implements the downloader's peer interface, light servers are
+// never asked for bodies during light sync.
+func (p *peer) RequestBodies([]common.Hash) error { return errNotSupported }
+
+// RequestReceipts implements the downloader's peer interface, light servers
+// are never asked for receipts during light sync.
+func (p *peer) RequestReceipts([]common.Hash) error { return errNotSupported }
+
+// RequestNodeData implements the downloader's peer interface, light servers
+// are never asked for state during light sync.
+func (p *peer) RequestNodeData([]common.Hash) error { return errNotSupported }
+
+func (p *peer) requestHeaders(reqID uint64, query getBlockHeadersData) error {
+	return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersPacket{ReqID: reqID, Query: query})
+}
+
+func (p *peer) requestBodies(reqID uint64, hashes []common.Hash) error {
+	p.Log().Debug("Fetching batch of block bodies", "count", len(hashes))
+	return p2p.Send(p.rw, GetBlockBodiesMsg, &getHashesPacket{ReqID: reqID, Hashes: hashes})
+}
+
+func (p *peer) requestReceipts(reqID uint64, hashes []common.Hash) error {
+	p.Log().Debug("Fetching batch of receipts", "count", len(hashes))
+	return p2p.Send(p.rw, GetReceiptsMsg, &getHashesPacket{ReqID: reqID, Hashes: hashes})
+}
+
+func (p *peer) requestCode(reqID uint64, reqs []CodeReq) error {
+	p.Log().Debug("Fetching batch of codes", "count", len(reqs))
+	return p2p.Send(p.rw, GetCodeMsg, &getCodePacket{ReqID: reqID, Reqs: reqs})
+}
+
+func (p *peer) requestProofs(reqID uint64, reqs []ProofReq) error {
+	p.Log().Debug("Fetching batch of proofs", "count", len(reqs))
+	return p2p.Send(p.rw, GetProofsMsg, &getProofsPacket{ReqID: reqID, Reqs: reqs})
+}
+
+func (p *peer) requestHelperTrieProofs(reqID uint64, reqs []HelperTrieReq) error {
+	p.Log().Debug("Fetching batch of HelperTrie proofs", "count", len(reqs))
+	return p2p.Send(p.rw, GetHelperTrieProofsMsg, &getHelperTrieProofsPacket{ReqID: reqID, Reqs: reqs})
+}
+
+func (p *peer) requestTxLookup(reqID uint64, hashes []common.Hash) error {
+	p.Log().Debug("Looking up transactions", "count", len(hashes))
+	return p2p.Send(p.rw, GetTxLookupMsg, &getHashesPacket{ReqID: reqID, Hashes: hashes})
+}
+
+func (p *peer) sendTxs(reqID uint64, txs types.Transactions) error {
+	p.Log().Debug("Sending batch of transactions", "count", len(txs))
+	return p2p.Send(p.rw, SendTxMsg, &sendTxPacket{ReqID: reqID, Txs: txs})
+}
+
+// handshake executes the les protocol handshake, negotiating version number,
+// network IDs, difficulties, head and genesis blocks. Servers additionally
+// advertise their stable checkpoint.
+func (p *peer) handshake(local *statusData) error {
+	errc := make(chan error, 2)
+	var status statusData // safe to read after two values have been received from errc
+
+	go func() {
+		errc <- p2p.Send(p.rw, StatusMsg, local)
+	}()
+	go func() {
+		errc <- p.readStatus(local, &status)
+	}()
+	timeout := time.NewTimer(handshakeTimeout)
+	defer timeout.Stop()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errc:
+			if err != nil {
+				return err
+			}
+		case <-timeout.C:
+			return p2p.DiscReadTimeout
+		}
+	}
+	// Light clients only talk to servers and servers only to light clients
+	if status.Server == local.Server {
+		return errResp(ErrUselessPeer, "server %v, local server %v", status.Server, local.Server)
+	}
+	p.lock.Lock()
+	p.headInfo = blockInfo{Hash: status.HeadHash, Number: status.HeadNum, Td: status.HeadTd}
+	p.server = status.Server
+	p.checkpoint = status.Checkpoint
+	p.checkpointNumber = status.CheckpointNumber
+	p.lock.Unlock()
+	return nil
+}
+
+func (p *peer) readStatus(local, status *statusData) error {
+	msg, err := p.rw.ReadMsg()
+	if err != nil {
+		return err
+	}
+	defer msg.Discard()
+
+	if msg.Code != StatusMsg {
+		return errResp(ErrNoStatusMsg, "first msg has code %x (!= %x)", msg.Code, StatusMsg)
+	}
+	if msg.Size > protocolMaxMsgSize {
+		return errResp(ErrMsgTooLarge, "%v > %v", msg.Size, protocolMaxMsgSize)
+	}
+	// Decode the handshake and make sure everything matches
+	if err := msg.Decode(status); err != nil {
+		return errResp(ErrDecode, "msg %v: %v", msg, err)
+	}
+	if status.GenesisHash != local.GenesisHash {
+		return errResp(ErrGenesisBlockMismatch, "%x (!= %x)", status.GenesisHash[:8], local.GenesisHash[:8])
+	}
+	if status.NetworkId != local.NetworkId {
+		return errResp(ErrNetworkIdMismatch, "%d (!= %d)", status.NetworkId, local.NetworkId)
+	}
+	if status.ProtocolVersion != local.ProtocolVersion {
+		return errResp(ErrProtocolVersionMismatch, "%d (!= %d)", status.ProtocolVersion, local.ProtocolVersion)
+	}
+	if status.HeadTd == nil || status.HeadTd.BitLen() > 100 {
+		return errResp(ErrDecode, "invalid head difficulty %v", status.HeadTd)
+	}
+	return nil
+}
+
+// peerSet represents the collection of active les peers.
+type peerSet struct {
+	peers  map[string]*peer
+	lock   sync.RWMutex
+	closed bool
+}
+
+// newPeerSet creates a new peer set to track the active participants.
+func newPeerSet() *peerSet {
+	return &peerSet{
+		peers: make(map[string]*peer),
+	}
+}
+
+// Register injects a new peer into the working set, or returns an error if the
+// peer is already known.
+func (ps *peerSet) Register(p *peer) error {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	if ps.closed {
+		return errClosed
+	}
+	if _, ok := ps.peers[p.id]; ok {
+		return errAlreadyRegistered
+	}
+	ps.peers[p.id] = p
+	return nil
+}
+
+// Unregister removes a remote peer from the active set, disabling any further
+// actions to/from that particular entity.
+func (ps *peerSet) Unregister(id string) error {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	if _, ok := ps.peers[id]; !ok {
+		return errNotRegistered
+	}
+	delete(ps.peers, id)
+	return nil
+}
+
+// Peer retrieves the registered peer with the given id.
+func (ps *peerSet) Peer(id string) *peer {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	return ps.peers[id]
+}
+
+// Len returns if the current number of peers in the set.
+func (ps *peerSet) Len() int {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	return len(ps.peers)
+}
+
+// AllPeers returns all peers in a list.
+func (ps *peerSet) AllPeers() []*peer {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	list := make([]*peer, 0, len(ps.peers))
+	for _, p := range ps.peers {
+		list = append(list, p)
+	}
+	return list
+}
+
+// BestPeer retrieves the known peer with the currently highest total difficulty.
+func (ps *peerSet) BestPeer() *peer {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	var (
+		bestPeer *peer
+		bestTd   *big.Int
+	)
+	for _, p := range ps.peers {
+		if _, td := p.Head(); bestPeer == nil || td.Cmp(bestTd) > 0 {
+			bestPeer, bestTd = p, td
+		}
+	}
+	return bestPeer
+}
+
+// Close disconnects all peers. No new peers can be registered after Close has
+// returned.
+func (ps *peerSet) Close() {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	for _, p := range ps.peers {
+		p.Disconnect(p2p.DiscQuitting)
+	}
+	ps.closed = true
+}
+
+func errResp(code errCode, format string, v ...interface{}) error {
+	return fmt.Errorf("%v - %v", code, fmt.Sprintf(format, v...))
+}
@@ -0,0 +1,290 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/light"
+	"github.com/CortexFoundation/CortexTheseus/params"
+	"github.com/CortexFoundation/CortexTheseus/rlp"
+)
+
+// Constants to match up protocol versions and messages
+const (
+	lpv1 = 1
+)
+
+// protocolName is the official short name of the protocol used during capability negotiation.
+const protocolName = "les"
+
+// ProtocolVersions are the supported versions of the les protocol (first is primary).
+var ProtocolVersions = []uint{lpv1}
+
+// protocolLengths are the number of implemented message corresponding to different protocol versions.
+var protocolLengths = map[uint]uint64{lpv1: 17}
+
+const protocolMaxMsgSize = 10 * 1024 * 1024 // Maximum cap on the size of a protocol message
+
+// les protocol message codes
+const (
+	StatusMsg              = 0x00
+	AnnounceMsg            = 0x01
+	GetBlockHeadersMsg     = 0x02
+	BlockHeadersMsg        = 0x03
+	GetBlockBodiesMsg      = 0x04
+	BlockBodiesMsg         = 0x05
+	GetReceiptsMsg         = 0x06
+	ReceiptsMsg            = 0x07
+	GetCodeMsg             = 0x08
+	CodeMsg                = 0x09
+	GetProofsMsg           = 0x0a
+	ProofsMsg              = 0x0b
+	GetHelperTrieProofsMsg = 0x0c
+	HelperTrieProofsMsg    = 0x0d
+	SendTxMsg              = 0x0e
+	GetTxLookupMsg         = 0x0f
+	TxLookupMsg            = 0x10
+)
+
+// Serving limits of a single request, larger requests are rejected.
+const (
+	MaxHeaderFetch           = 192 // Amount of block headers to be fetched per retrieval request
+	MaxBodyFetch             = 32  // Amount of block bodies to be fetched per retrieval request
+	MaxReceiptFetch          = 128 // Amount of transaction receipts to allow fetching per request
+	MaxCodeFetch             = 64  // Amount of contract codes to allow fetching per request
+	MaxProofsFetch           = 64  // Amount of merkle proofs to be fetched per retrieval request
+	MaxHelperTrieProofsFetch = 64  // Amount of helper tries to be fetched per retrieval request
+	MaxTxSend                = 64  // Amount of transactions to be send per request
+	MaxTxLookup              = 256 // Amount of transactions to be looked up per request
+)
+
+type errCode int
+
+const (
+	ErrMsgTooLarge = iota
+	ErrDecode
+	ErrInvalidMsgCode
+	ErrProtocolVersionMismatch
+	ErrNetworkIdMismatch
+	ErrGenesisBlockMismatch
+	ErrNoStatusMsg
+	ErrExtraStatusMsg
+	ErrUselessPeer
+	ErrRequestRejected
+	ErrUnexpectedResponse
+	ErrInvalidResponse
+)
+
+func (e errCode) String() string {
+	return errorToString[int(e)]
+}
+
+// XXX change once legacy code is out
+var errorToString = map[int]string{
+	ErrMsgTooLarge:             "Message too long",
+	ErrDecode:                  "Invalid message",
+	ErrInvalidMsgCode:          "Invalid message code",
+	ErrProtocolVersionMismatch: "Protocol version mismatch",
+	ErrNetworkIdMismatch:       "NetworkId mismatch",
+	ErrGenesisBlockMismatch:    "Genesis block mismatch",
+	ErrNoStatusMsg:             "No status message",
+	ErrExtraStatusMsg:          "Extra status message",
+	ErrUselessPeer:             "Useless peer",
+	ErrRequestRejected:         "Request rejected",
+	ErrUnexpectedResponse:      "Unexpected response",
+	ErrInvalidResponse:         "Invalid response",
+}
+
+// statusData is the network packet for the status message. Servers advertise
+// their latest stable checkpoint along with the block number it was registered
+// in the checkpoint oracle, clients leave both empty.
+type statusData struct {
+	ProtocolVersion  uint32
+	NetworkId        uint64
+	HeadTd           *big.Int
+	HeadHash         common.Hash
+	HeadNum          uint64
+	GenesisHash      common.Hash
+	Server           bool
+	Checkpoint       params.TrustedCheckpoint
+	CheckpointNumber uint64
+}
+
+// announceData is the network packet for the block announcements.
+type announceData struct {
+	Hash   common.Hash // Hash of one particular block being announced
+	Number uint64      // Number of one particular block being announced
+	Td     *big.Int    // Total difficulty of one particular block being announced
+}
+
+// sanityCheck verifies that the values are reasonable, as a DoS protection
+func (a *announceData) sanityCheck() error {
+	if tdlen := a.Td.BitLen(); tdlen > 100 {
+		return fmt.Errorf("too large block TD: bitlen %d", tdlen)
+	}
+	return nil
+}
+
+// getBlockHeadersData represents a block header query.
+type getBlockHeadersData struct {
+	Origin  hashOrNumber // Block from which to retrieve headers
+	Amount  uint64       // Maximum number of headers to retrieve
+	Skip    uint64       // Blocks to skip between consecutive headers
+	Reverse bool         // Query direction (false = rising towards latest, true = falling towards genesis)
+}
+
+// hashOrNumber is a combined field for specifying an origin block.
+type hashOrNumber struct {
+	Hash   common.Hash // Block hash from which to retrieve headers (excludes Number)
+	Number uint64      // Block hash from which to retrieve headers (excludes Hash)
+}
+
+// EncodeRLP is a specialized encoder for hashOrNumber to encode only one of the
+// two contained union fields.
+func (hn *hashOrNumber) EncodeRLP(w io.Writer) error {
+	if hn.Hash == (common.Hash{}) {
+		return rlp.Encode(w, hn.Number)
+	}
+	if hn.Number != 0 {
+		return fmt.Errorf("both origin hash (%x) and number (%d) provided", hn.Hash, hn.Number)
+	}
+	return rlp.Encode(w, hn.Hash)
+}
+
+// DecodeRLP is a specialized decoder for hashOrNumber to decode the contents
+// into either a block hash or a block number.
+func (hn *hashOrNumber) DecodeRLP(s *rlp.Stream) error {
+	_, size, _ := s.Kind()
+	origin, err := s.Raw()
+	if err == nil {
+		switch {
+		case size == 32:
+			err = rlp.DecodeBytes(origin, &hn.Hash)
+		case size <= 8:
+			err = rlp.DecodeBytes(origin, &hn.Number)
+		default:
+			err = fmt.Errorf("invalid input size %d for origin", size)
+		}
+	}
+	return err
+}
+
+// CodeReq is a request for the contract code of an account.
+type CodeReq struct {
+	BHash  common.Hash
+	AccKey []byte
+}
+
+// ProofReq is a request for a merkle proof of a state or storage trie entry.
+type ProofReq struct {
+	BHash       common.Hash
+	AccKey, Key []byte
+	FromLevel   uint
+}
+
+const (
+	htCanonical = iota // Canonical hash trie
+	htBloomBits        // BloomBits trie
+
+	// helper trie auxiliary types
+	htAuxNone   = 0 // no auxiliary data requested
+	htAuxHeader = 1 // applicable for htCanonical, requests for relevant headers
+)
+
+// HelperTrieReq is a request for a merkle proof of a CHT or bloom trie entry.
+type HelperTrieReq struct {
+	Type              uint
+	TrieIdx           uint64
+	Key               []byte
+	FromLevel, AuxReq uint
+}
+
+// HelperTrieResps is the response to a batch of helper trie requests, the
+// proofs of all requests are merged into a single node list.
+type HelperTrieResps struct {
+	Proofs  light.NodeList
+	AuxData [][]byte
+}
+
+// txLookup is the position of a canonical transaction, an empty block hash
+// signals an unknown transaction.
+type txLookup struct {
+	BlockHash   common.Hash
+	BlockNumber uint64
+	Index       uint64
+}
+
+// Request and response packets, all of them tagged by the request identifier.
+type (
+	getBlockHeadersPacket struct {
+		ReqID uint64
+		Query getBlockHeadersData
+	}
+	blockHeadersPacket struct {
+		ReqID   uint64
+		Headers []*types.Header
+	}
+	getHashesPacket struct {
+		ReqID  uint64
+		Hashes []common.Hash
+	}
+	blockBodiesPacket struct {
+		ReqID uint64
+		Data  []rlp.RawValue
+	}
+	receiptsPacket struct {
+		ReqID    uint64
+		Receipts []types.Receipts
+	}
+	getCodePacket struct {
+		ReqID uint64
+		Reqs  []CodeReq
+	}
+	codePacket struct {
+		ReqID uint64
+		Data  [][]byte
+	}
+	getProofsPacket struct {
+		ReqID uint64
+		Reqs  []ProofReq
+	}
+	proofsPacket struct {
+		ReqID uint64
+		Data  light.NodeList
+	}
+	getHelperTrieProofsPacket struct {
+		ReqID uint64
+		Reqs  []HelperTrieReq
+	}
+	helperTrieProofsPacket struct {
+		ReqID uint64
+		Data  HelperTrieResps
+	}
+	sendTxPacket struct {
+		ReqID uint64
+		Txs   []*types.Transaction
+	}
+	txLookupPacket struct {
+		ReqID   uint64
+		Lookups []txLookup
+	}
+)
@@ -0,0 +1,153 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/ctxcdb"
+	"github.com/CortexFoundation/CortexTheseus/light"
+	"github.com/CortexFoundation/CortexTheseus/p2p"
+)
+
+// requestTimeout is the time allowed for a server to answer a request before
+// it is retried at another server.
+var requestTimeout = 10 * time.Second
+
+var errRequestTimeout = errors.New("request timed out")
+
+// sentReq is a request in flight, waiting for the response of a given peer.
+type sentReq struct {
+	peer      *peer
+	deliverCh chan *Msg
+}
+
+// retrieveManager sends on-demand retrieval requests to the connected servers
+// and matches the responses to them by request identifier. Invalid responses
+// and timeouts are retried at other servers.
+type retrieveManager struct {
+	peers *peerSet
+
+	lock     sync.Mutex
+	sentReqs map[uint64]*sentReq
+}
+
+// newRetrieveManager creates the retrieval manager.
+func newRetrieveManager(peers *peerSet) *retrieveManager {
+	return &retrieveManager{
+		peers:    peers,
+		sentReqs: make(map[uint64]*sentReq),
+	}
+}
+
+// retrieve sends a request to suitable servers one after the other until a
+// valid response is received, the context is cancelled or every suitable
+// server has failed.
+func (rm *retrieveManager) retrieve(ctx context.Context, req LesOdrRequest, db ctxcdb.Database) error {
+	var (
+		tried   = make(map[*peer]struct{})
+		lastErr = light.ErrNoPeers
+	)
+	for {
+		p := rm.choosePeer(req, tried)
+		if p == nil {
+			return lastErr
+		}
+		tried[p] = struct{}{}
+
+		msg, err := rm.request(ctx, p, req)
+		if err == context.Canceled || err == context.DeadlineExceeded {
+			return err
+		}
+		if err == nil {
+			if err = req.Validate(db, msg); err != nil {
+				// Servers answering with garbage are of no use, drop them
+				p.Log().Debug("Invalid light response", "err", err)
+				p.Disconnect(p2p.DiscUselessPeer)
+			}
+		}
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+	}
+}
+
+// request sends the request to a single peer and waits for its response.
+func (rm *retrieveManager) request(ctx context.Context, p *peer, req LesOdrRequest) (*Msg, error) {
+	reqID := genReqID()
+	sent := &sentReq{peer: p, deliverCh: make(chan *Msg, 1)}
+
+	rm.lock.Lock()
+	rm.sentReqs[reqID] = sent
+	rm.lock.Unlock()
+
+	defer func() {
+		rm.lock.Lock()
+		delete(rm.sentReqs, reqID)
+		rm.lock.Unlock()
+	}()
+	if err := req.Request(reqID, p); err != nil {
+		return nil, err
+	}
+	timeout := time.NewTimer(requestTimeout)
+	defer timeout.Stop()
+
+	select {
+	case msg := <-sent.deliverCh:
+		return msg, nil
+	case <-timeout.C:
+		return nil, errRequestTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// choosePeer selects the suitable server with the highest announced head
+// which has not yet been tried for the request.
+func (rm *retrieveManager) choosePeer(req LesOdrRequest, tried map[*peer]struct{}) *peer {
+	var best *peer
+	for _, p := range rm.peers.AllPeers() {
+		if _, ok := tried[p]; ok || !req.CanSend(p) {
+			continue
+		}
+		if best == nil || p.headBlockInfo().Number > best.headBlockInfo().Number {
+			best = p
+		}
+	}
+	return best
+}
+
+// deliver hands a response over to the matching request in flight. It returns
+// an error if the response was not requested from the given peer.
+func (rm *retrieveManager) deliver(p *peer, msg *Msg) error {
+	rm.lock.Lock()
+	sent, ok := rm.sentReqs[msg.ReqID]
+	if ok && sent.peer == p {
+		delete(rm.sentReqs, msg.ReqID)
+	}
+	rm.lock.Unlock()
+
+	if !ok || sent.peer != p {
+		return errResp(ErrUnexpectedResponse, "reqID = %v", msg.ReqID)
+	}
+	sent.deliverCh <- msg
+	return nil
+}
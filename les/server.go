@@ -0,0 +1,212 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/CortexFoundation/CortexTheseus/accounts/abi/bind"
+	"github.com/CortexFoundation/CortexTheseus/core"
+	"github.com/CortexFoundation/CortexTheseus/ctxc"
+	"github.com/CortexFoundation/CortexTheseus/ctxcdb"
+	"github.com/CortexFoundation/CortexTheseus/light"
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/p2p"
+	"github.com/CortexFoundation/CortexTheseus/params"
+	"github.com/CortexFoundation/CortexTheseus/rpc"
+)
+
+// LesServer serves light clients from the chain of a full node. It maintains
+// the CHT and bloom trie indexers and answers on-demand retrieval requests
+// with merkle proofs rooted in them.
+type LesServer struct {
+	config        *ctxc.Config
+	indexerConfig *light.IndexerConfig
+	networkId     uint64
+
+	blockchain *core.BlockChain
+	txpool     *core.TxPool
+	chainDb    ctxcdb.Database
+
+	chtIndexer       *core.ChainIndexer
+	bloomTrieIndexer *core.ChainIndexer
+	oracle           *checkpointOracle
+
+	peers        *peerSet
+	maxPeers     int
+	servingQueue chan struct{} // Tokens limiting the number of requests served concurrently
+
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewLesServer creates a light server on top of a full node. The bloom trie
+// indexer is attached to the node's bloom indexer, the CHT indexer follows
+// the canonical chain directly.
+func NewLesServer(c *ctxc.Cortex, config *ctxc.Config) (*LesServer, error) {
+	srv := newLesServer(config, light.DefaultServerIndexerConfig, c.BlockChain(), c.TxPool(), c.ChainDb())
+	c.BloomIndexer().AddChildIndexer(srv.bloomTrieIndexer)
+	srv.chtIndexer.Start(c.BlockChain())
+
+	// Set up the checkpoint oracle if one is configured or known for the network
+	oracle := config.CheckpointOracle
+	if oracle == nil {
+		oracle = params.CheckpointOracles[c.BlockChain().Genesis().Hash()]
+	}
+	srv.oracle = newCheckpointOracle(oracle, srv.localCheckpoint)
+
+	log.Info("Light server enabled", "serve", config.LightServ, "peers", config.LightPeers)
+	return srv, nil
+}
+
+func newLesServer(config *ctxc.Config, indexerConfig *light.IndexerConfig, blockchain *core.BlockChain, txpool *core.TxPool, chainDb ctxcdb.Database) *LesServer {
+	threads := config.LightServ * runtime.NumCPU() / 100
+	if threads < 1 {
+		threads = 1
+	}
+	return &LesServer{
+		config:           config,
+		indexerConfig:    indexerConfig,
+		networkId:        config.NetworkId,
+		blockchain:       blockchain,
+		txpool:           txpool,
+		chainDb:          chainDb,
+		chtIndexer:       light.NewChtIndexer(chainDb, indexerConfig.ChtSize, indexerConfig.ChtConfirms),
+		bloomTrieIndexer: light.NewBloomTrieIndexer(chainDb, indexerConfig.BloomSize, indexerConfig.BloomTrieSize),
+		peers:            newPeerSet(),
+		maxPeers:         config.LightPeers,
+		servingQueue:     make(chan struct{}, threads),
+		closeCh:          make(chan struct{}),
+	}
+}
+
+// localCheckpoint returns the checkpoint of the given section generated by the
+// local indexers, or an empty one if the section is not yet indexed.
+func (s *LesServer) localCheckpoint(index uint64) params.TrustedCheckpoint {
+	sectionHead := s.chtIndexer.SectionHead(index)
+	return params.TrustedCheckpoint{
+		SectionIndex: index,
+		SectionHead:  sectionHead,
+		CHTRoot:      light.GetChtRoot(s.chainDb, index, sectionHead),
+		BloomRoot:    light.GetBloomTrieRoot(s.chainDb, index, sectionHead),
+	}
+}
+
+// latestLocalCheckpoint returns the newest checkpoint covered by both the CHT
+// and the bloom trie indexers.
+func (s *LesServer) latestLocalCheckpoint() params.TrustedCheckpoint {
+	sections, _, _ := s.chtIndexer.Sections()
+	sections2, _, _ := s.bloomTrieIndexer.Sections()
+	// Cap the section index if the two sections are not consistent.
+	if sections > sections2 {
+		sections = sections2
+	}
+	if sections == 0 {
+		// No checkpoint information can be provided.
+		return params.TrustedCheckpoint{}
+	}
+	return s.localCheckpoint(sections - 1)
+}
+
+// SetContractBackend binds the checkpoint oracle to the given contract backend,
+// enabling the advertisement of stable checkpoints.
+func (s *LesServer) SetContractBackend(backend bind.ContractBackend) {
+	if s.oracle == nil {
+		return
+	}
+	s.oracle.start(backend)
+}
+
+// Protocols implements node.Service, returning the les protocols to run.
+func (s *LesServer) Protocols() []p2p.Protocol {
+	protos := make([]p2p.Protocol, len(ProtocolVersions))
+	for i, vsn := range ProtocolVersions {
+		version := vsn
+		protos[i] = p2p.Protocol{
+			Name:    protocolName,
+			Version: version,
+			Length:  protocolLengths[version],
+			Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+				return s.runPeer(newPeer(int(version), s.networkId, p, rw))
+			},
+		}
+	}
+	return protos
+}
+
+// Start starts the head announcement loop of the light server.
+func (s *LesServer) Start(srvr *p2p.Server) {
+	s.wg.Add(1)
+	go s.broadcastLoop()
+}
+
+// Stop stops the light server, disconnecting all clients.
+func (s *LesServer) Stop() {
+	close(s.closeCh)
+	s.peers.Close()
+	s.wg.Wait()
+
+	// bloom trie indexer is closed by parent bloombits indexer
+	s.chtIndexer.Close()
+	log.Info("Light server stopped")
+}
+
+// APIs returns the RPC services offered by the light server.
+func (s *LesServer) APIs() []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: "les",
+			Version:   "1.0",
+			Service:   NewPrivateLightAPI(s.oracle, s.latestLocalCheckpoint, s.localCheckpoint),
+			Public:    false,
+		},
+	}
+}
+
+// broadcastLoop announces every new canonical head to the connected clients.
+func (s *LesServer) broadcastLoop() {
+	defer s.wg.Done()
+
+	headCh := make(chan core.ChainHeadEvent, 10)
+	headSub := s.blockchain.SubscribeChainHeadEvent(headCh)
+	defer headSub.Unsubscribe()
+
+	for {
+		select {
+		case ev := <-headCh:
+			header := ev.Block.Header()
+			hash, number := header.Hash(), header.Number.Uint64()
+			td := s.blockchain.GetTd(hash, number)
+			if td == nil {
+				continue
+			}
+			announce := announceData{Hash: hash, Number: number, Td: td}
+			for _, p := range s.peers.AllPeers() {
+				if err := p.announce(announce); err != nil {
+					p.Log().Debug("Failed to announce head", "err", err)
+				}
+			}
+			log.Debug("Announced new head to light clients", "number", number, "hash", hash, "clients", s.peers.Len())
+
+		case <-headSub.Err():
+			return
+		case <-s.closeCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,443 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
+	"github.com/CortexFoundation/CortexTheseus/core/state"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/light"
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/p2p"
+	"github.com/CortexFoundation/CortexTheseus/rlp"
+	"github.com/CortexFoundation/CortexTheseus/trie"
+)
+
+var (
+	errNoHeader     = errors.New("header not found")
+	errNoHelperTrie = errors.New("helper trie not available")
+)
+
+const (
+	softResponseLimit = 2 * 1024 * 1024 // Target maximum size of returned blocks, headers or node data.
+	estHeaderRlpSize  = 500             // Approximate size of an RLP encoded block header
+)
+
+// status assembles the handshake packet advertised to light clients.
+func (s *LesServer) status(version int) *statusData {
+	head := s.blockchain.CurrentHeader()
+	hash, number := head.Hash(), head.Number.Uint64()
+
+	status := &statusData{
+		ProtocolVersion: uint32(version),
+		NetworkId:       s.networkId,
+		HeadTd:          s.blockchain.GetTd(hash, number),
+		HeadHash:        hash,
+		HeadNum:         number,
+		GenesisHash:     s.blockchain.Genesis().Hash(),
+		Server:          true,
+	}
+	// Advertise the stable checkpoint if the oracle approved one
+	if s.oracle != nil && s.oracle.isRunning() {
+		if cp, height := s.oracle.stableCheckpoint(); cp != nil {
+			status.Checkpoint, status.CheckpointNumber = *cp, height
+		}
+	}
+	return status
+}
+
+// runPeer is the callback invoked to manage the life cycle of a light client.
+// When this function terminates, the peer is disconnected.
+func (s *LesServer) runPeer(p *peer) error {
+	if s.peers.Len() >= s.maxPeers {
+		return p2p.DiscTooManyPeers
+	}
+	p.Log().Debug("Light client connected", "name", p.Name())
+
+	if err := p.handshake(s.status(p.version)); err != nil {
+		p.Log().Debug("Light client handshake failed", "err", err)
+		return err
+	}
+	if err := s.peers.Register(p); err != nil {
+		p.Log().Error("Light client registration failed", "err", err)
+		return err
+	}
+	defer s.peers.Unregister(p.id)
+
+	for {
+		if err := s.handleMsg(p); err != nil {
+			p.Log().Debug("Light client message handling failed", "err", err)
+			return err
+		}
+	}
+}
+
+// handleMsg is invoked whenever an inbound message is received from a light
+// client. The remote connection is torn down upon returning any error.
+func (s *LesServer) handleMsg(p *peer) error {
+	msg, err := p.rw.ReadMsg()
+	if err != nil {
+		return err
+	}
+	defer msg.Discard()
+
+	if msg.Size > protocolMaxMsgSize {
+		return errResp(ErrMsgTooLarge, "%v > %v", msg.Size, protocolMaxMsgSize)
+	}
+	// Limit the number of requests served in parallel across all clients
+	select {
+	case s.servingQueue <- struct{}{}:
+		defer func() { <-s.servingQueue }()
+	case <-s.closeCh:
+		return p2p.DiscQuitting
+	}
+
+	switch msg.Code {
+	case StatusMsg:
+		return errResp(ErrExtraStatusMsg, "uncontrolled status message")
+
+	case GetBlockHeadersMsg:
+		var req getBlockHeadersPacket
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		if req.Query.Amount > MaxHeaderFetch {
+			return errResp(ErrRequestRejected, "too many headers requested: %d", req.Query.Amount)
+		}
+		return p2p.Send(p.rw, BlockHeadersMsg, &blockHeadersPacket{ReqID: req.ReqID, Headers: s.serveHeaders(req.Query)})
+
+	case GetBlockBodiesMsg:
+		var req getHashesPacket
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		if len(req.Hashes) > MaxBodyFetch {
+			return errResp(ErrRequestRejected, "too many bodies requested: %d", len(req.Hashes))
+		}
+		var bodies []rlp.RawValue
+		for _, hash := range req.Hashes {
+			if data := s.blockchain.GetBodyRLP(hash); len(data) != 0 {
+				bodies = append(bodies, data)
+			}
+		}
+		return p2p.Send(p.rw, BlockBodiesMsg, &blockBodiesPacket{ReqID: req.ReqID, Data: bodies})
+
+	case GetReceiptsMsg:
+		var req getHashesPacket
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		if len(req.Hashes) > MaxReceiptFetch {
+			return errResp(ErrRequestRejected, "too many receipts requested: %d", len(req.Hashes))
+		}
+		var receipts []types.Receipts
+		for _, hash := range req.Hashes {
+			number := rawdb.ReadHeaderNumber(s.chainDb, hash)
+			if number == nil {
+				continue
+			}
+			results := rawdb.ReadRawReceipts(s.chainDb, hash, *number)
+			if results == nil {
+				if header := s.blockchain.GetHeader(hash, *number); header == nil || header.ReceiptHash != types.EmptyRootHash {
+					continue
+				}
+			}
+			receipts = append(receipts, results)
+		}
+		return p2p.Send(p.rw, ReceiptsMsg, &receiptsPacket{ReqID: req.ReqID, Receipts: receipts})
+
+	case GetCodeMsg:
+		var req getCodePacket
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		if len(req.Reqs) > MaxCodeFetch {
+			return errResp(ErrRequestRejected, "too many codes requested: %d", len(req.Reqs))
+		}
+		var data [][]byte
+		for _, r := range req.Reqs {
+			code, err := s.serveCode(r)
+			if err != nil {
+				p.Log().Debug("Failed to serve contract code", "block", r.BHash, "account", common.BytesToHash(r.AccKey), "err", err)
+				break
+			}
+			data = append(data, code)
+		}
+		return p2p.Send(p.rw, CodeMsg, &codePacket{ReqID: req.ReqID, Data: data})
+
+	case GetProofsMsg:
+		var req getProofsPacket
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		if len(req.Reqs) > MaxProofsFetch {
+			return errResp(ErrRequestRejected, "too many proofs requested: %d", len(req.Reqs))
+		}
+		nodes := light.NewNodeSet()
+		for _, r := range req.Reqs {
+			if err := s.serveProof(r, nodes); err != nil {
+				p.Log().Debug("Failed to serve merkle proof", "block", r.BHash, "err", err)
+				break
+			}
+			if nodes.DataSize() >= softResponseLimit {
+				break
+			}
+		}
+		return p2p.Send(p.rw, ProofsMsg, &proofsPacket{ReqID: req.ReqID, Data: nodes.NodeList()})
+
+	case GetHelperTrieProofsMsg:
+		var req getHelperTrieProofsPacket
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		if len(req.Reqs) > MaxHelperTrieProofsFetch {
+			return errResp(ErrRequestRejected, "too many helper trie proofs requested: %d", len(req.Reqs))
+		}
+		var (
+			nodes   = light.NewNodeSet()
+			auxData [][]byte
+		)
+		for _, r := range req.Reqs {
+			aux, err := s.serveHelperTrieProof(r, nodes)
+			if err != nil {
+				p.Log().Debug("Failed to serve helper trie proof", "type", r.Type, "section", r.TrieIdx, "err", err)
+				break
+			}
+			if r.AuxReq != htAuxNone {
+				auxData = append(auxData, aux)
+			}
+		}
+		return p2p.Send(p.rw, HelperTrieProofsMsg, &helperTrieProofsPacket{ReqID: req.ReqID, Data: HelperTrieResps{Proofs: nodes.NodeList(), AuxData: auxData}})
+
+	case SendTxMsg:
+		var req sendTxPacket
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		if len(req.Txs) > MaxTxSend {
+			return errResp(ErrRequestRejected, "too many transactions sent: %d", len(req.Txs))
+		}
+		for i, err := range s.txpool.AddRemotes(req.Txs) {
+			if err != nil {
+				p.Log().Debug("Rejected light client transaction", "hash", req.Txs[i].Hash(), "err", err)
+			}
+		}
+		return nil
+
+	case GetTxLookupMsg:
+		var req getHashesPacket
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		if len(req.Hashes) > MaxTxLookup {
+			return errResp(ErrRequestRejected, "too many transactions looked up: %d", len(req.Hashes))
+		}
+		lookups := make([]txLookup, len(req.Hashes))
+		for i, hash := range req.Hashes {
+			if tx, blockHash, number, index := rawdb.ReadTransaction(s.chainDb, hash); tx != nil {
+				lookups[i] = txLookup{BlockHash: blockHash, BlockNumber: number, Index: index}
+			}
+		}
+		return p2p.Send(p.rw, TxLookupMsg, &txLookupPacket{ReqID: req.ReqID, Lookups: lookups})
+
+	default:
+		return errResp(ErrInvalidMsgCode, "%v", msg.Code)
+	}
+}
+
+// serveHeaders gathers the headers matching a query, limited by the fetch and
+// network limits.
+func (s *LesServer) serveHeaders(query getBlockHeadersData) []*types.Header {
+	var (
+		hashMode        = query.Origin.Hash != (common.Hash{})
+		first           = true
+		maxNonCanonical = uint64(100)
+		bytes           common.StorageSize
+		headers         []*types.Header
+		unknown         bool
+	)
+	for !unknown && len(headers) < int(query.Amount) && bytes < softResponseLimit {
+		// Retrieve the next header satisfying the query
+		var origin *types.Header
+		if hashMode {
+			if first {
+				first = false
+				origin = s.blockchain.GetHeaderByHash(query.Origin.Hash)
+				if origin != nil {
+					query.Origin.Number = origin.Number.Uint64()
+				}
+			} else {
+				origin = s.blockchain.GetHeader(query.Origin.Hash, query.Origin.Number)
+			}
+		} else {
+			origin = s.blockchain.GetHeaderByNumber(query.Origin.Number)
+		}
+		if origin == nil {
+			break
+		}
+		headers = append(headers, origin)
+		bytes += estHeaderRlpSize
+
+		// Advance to the next header of the query
+		switch {
+		case hashMode && query.Reverse:
+			// Hash based traversal towards the genesis block
+			ancestor := query.Skip + 1
+			if ancestor == 0 {
+				unknown = true
+			} else {
+				query.Origin.Hash, query.Origin.Number = s.blockchain.GetAncestor(query.Origin.Hash, query.Origin.Number, ancestor, &maxNonCanonical)
+				unknown = (query.Origin.Hash == common.Hash{})
+			}
+		case hashMode && !query.Reverse:
+			// Hash based traversal towards the leaf block
+			var (
+				current = origin.Number.Uint64()
+				next    = current + query.Skip + 1
+			)
+			if next <= current {
+				log.Warn("GetBlockHeaders skip overflow attack", "current", current, "skip", query.Skip, "next", next)
+				unknown = true
+			} else {
+				if header := s.blockchain.GetHeaderByNumber(next); header != nil {
+					nextHash := header.Hash()
+					expOldHash, _ := s.blockchain.GetAncestor(nextHash, next, query.Skip+1, &maxNonCanonical)
+					if expOldHash == query.Origin.Hash {
+						query.Origin.Hash, query.Origin.Number = nextHash, next
+					} else {
+						unknown = true
+					}
+				} else {
+					unknown = true
+				}
+			}
+		case query.Reverse:
+			// Number based traversal towards the genesis block
+			if query.Origin.Number >= query.Skip+1 {
+				query.Origin.Number -= query.Skip + 1
+			} else {
+				unknown = true
+			}
+
+		case !query.Reverse:
+			// Number based traversal towards the leaf block
+			query.Origin.Number += query.Skip + 1
+		}
+	}
+	return headers
+}
+
+// openAccount resolves the account with the given hashed key in the state of
+// the given block.
+func (s *LesServer) openAccount(blockHash common.Hash, accKey []byte) (*trie.Database, *state.Account, error) {
+	header := s.blockchain.GetHeaderByHash(blockHash)
+	if header == nil {
+		return nil, nil, errNoHeader
+	}
+	triedb := s.blockchain.StateCache().TrieDB()
+	t, err := trie.New(header.Root, triedb)
+	if err != nil {
+		return nil, nil, err
+	}
+	blob, err := t.TryGet(accKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	var account state.Account
+	if err := rlp.DecodeBytes(blob, &account); err != nil {
+		return nil, nil, err
+	}
+	return triedb, &account, nil
+}
+
+// serveCode retrieves the contract code of an account.
+func (s *LesServer) serveCode(req CodeReq) ([]byte, error) {
+	_, account, err := s.openAccount(req.BHash, req.AccKey)
+	if err != nil {
+		return nil, err
+	}
+	return s.blockchain.StateCache().ContractCode(common.BytesToHash(req.AccKey), common.BytesToHash(account.CodeHash))
+}
+
+// serveProof collects the merkle proof of a state or storage trie entry.
+func (s *LesServer) serveProof(req ProofReq, nodes *light.NodeSet) error {
+	var (
+		t   *trie.Trie
+		err error
+	)
+	if len(req.AccKey) == 0 {
+		header := s.blockchain.GetHeaderByHash(req.BHash)
+		if header == nil {
+			return errNoHeader
+		}
+		t, err = trie.New(header.Root, s.blockchain.StateCache().TrieDB())
+	} else {
+		var (
+			triedb  *trie.Database
+			account *state.Account
+		)
+		if triedb, account, err = s.openAccount(req.BHash, req.AccKey); err != nil {
+			return err
+		}
+		t, err = trie.New(account.Root, triedb)
+	}
+	if err != nil {
+		return err
+	}
+	return t.Prove(req.Key, req.FromLevel, nodes)
+}
+
+// serveHelperTrieProof collects the merkle proof of a CHT or bloom trie entry
+// and the requested auxiliary data.
+func (s *LesServer) serveHelperTrieProof(req HelperTrieReq, nodes *light.NodeSet) ([]byte, error) {
+	var (
+		root   common.Hash
+		prefix string
+	)
+	switch req.Type {
+	case htCanonical:
+		sectionHead := s.blockchain.GetCanonicalHash((req.TrieIdx+1)*s.indexerConfig.ChtSize - 1)
+		root, prefix = light.GetChtRoot(s.chainDb, req.TrieIdx, sectionHead), light.ChtTablePrefix
+	case htBloomBits:
+		sectionHead := s.blockchain.GetCanonicalHash((req.TrieIdx+1)*s.indexerConfig.BloomTrieSize - 1)
+		root, prefix = light.GetBloomTrieRoot(s.chainDb, req.TrieIdx, sectionHead), light.BloomTrieTablePrefix
+	default:
+		return nil, errResp(ErrRequestRejected, "unknown helper trie type %d", req.Type)
+	}
+	if root == (common.Hash{}) {
+		return nil, errNoHelperTrie
+	}
+	t, err := trie.New(root, trie.NewDatabase(rawdb.NewTable(s.chainDb, prefix)))
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Prove(req.Key, req.FromLevel, nodes); err != nil {
+		return nil, err
+	}
+	if req.Type == htCanonical && req.AuxReq == htAuxHeader && len(req.Key) == 8 {
+		header := s.blockchain.GetHeaderByNumber(binary.BigEndian.Uint64(req.Key))
+		if header == nil {
+			return nil, errNoHeader
+		}
+		return rlp.EncodeToBytes(header)
+	}
+	return nil, nil
+}
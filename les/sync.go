@@ -0,0 +1,180 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/contracts/checkpointoracle"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/ctxc/downloader"
+	"github.com/CortexFoundation/CortexTheseus/ctxcdb"
+	"github.com/CortexFoundation/CortexTheseus/light"
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/params"
+)
+
+// checkpointSyncTimeout is the time allowed for validating an advertised
+// checkpoint and retrieving its section head.
+const checkpointSyncTimeout = time.Minute
+
+var (
+	errNoOracle              = errors.New("checkpoint oracle is not configured")
+	errNoCheckpointVotes     = errors.New("no checkpoint votes found")
+	errCheckpointNotApproved = errors.New("checkpoint not approved by enough signers")
+)
+
+// syncLoop synchronises the light chain with the servers scheduled by new
+// connections and head announcements, one at a time.
+func (h *clientHandler) syncLoop() {
+	defer h.wg.Done()
+
+	for {
+		select {
+		case p := <-h.syncCh:
+			h.synchronise(p)
+		case <-h.closeCh:
+			return
+		}
+	}
+}
+
+// synchronise tries to sync up our local header chain with a remote server.
+// If the local chain is far behind, the sync is started from the newest known
+// checkpoint instead of the genesis.
+func (h *clientHandler) synchronise(p *peer) {
+	// Make sure the peer's TD is higher than our own.
+	latest := h.blockchain.CurrentHeader()
+	currentTd := h.blockchain.GetTd(latest.Hash(), latest.Number.Uint64())
+
+	head := p.headBlockInfo()
+	if currentTd != nil && head.Td.Cmp(currentTd) <= 0 {
+		return
+	}
+	// Pick the newest checkpoint, preferring a verified one advertised by the server
+	checkpoint := h.checkpoint
+	if advertised := checkpointOf(p); advertised != nil && (checkpoint == nil || advertised.SectionIndex > checkpoint.SectionIndex) {
+		if err := h.validateCheckpoint(p); err != nil {
+			p.Log().Debug("Failed to verify advertised checkpoint", "section", advertised.SectionIndex, "err", err)
+		} else {
+			checkpoint = advertised
+		}
+	}
+	// Jump to the checkpoint if the local chain is behind it
+	if checkpoint != nil && !checkpoint.Empty() {
+		sectionHead := (checkpoint.SectionIndex+1)*h.odr.IndexerConfig().ChtSize - 1
+		if latest.Number.Uint64() < sectionHead && head.Number >= sectionHead {
+			h.blockchain.AddTrustedCheckpoint(checkpoint)
+
+			ctx, cancel := context.WithTimeout(context.Background(), checkpointSyncTimeout)
+			synced := h.blockchain.SyncCheckpoint(ctx, checkpoint)
+			cancel()
+			if !synced {
+				p.Log().Debug("Failed to sync to checkpoint", "section", checkpoint.SectionIndex)
+				return
+			}
+			log.Info("Synced to checkpoint", "section", checkpoint.SectionIndex, "number", sectionHead)
+		}
+	}
+	if err := h.downloader.Synchronise(p.id, head.Hash, head.Td, downloader.LightSync); err != nil {
+		p.Log().Debug("Synchronisation failed", "err", err)
+	}
+}
+
+// validateCheckpoint verifies the checkpoint advertised by a server against the
+// votes logged by the checkpoint oracle in the block it was registered in.
+func (h *clientHandler) validateCheckpoint(p *peer) error {
+	if h.oracle == nil {
+		return errNoOracle
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), checkpointSyncTimeout)
+	defer cancel()
+
+	// Retrieve the registration block header from the advertising server
+	req := &headerRequest{peer: p, number: p.checkpointNumber}
+	if err := h.retriever.retrieve(ctx, req, h.odr.Database()); err != nil {
+		return err
+	}
+	logs, err := light.GetUntrustedBlockLogs(ctx, h.odr, req.header)
+	if err != nil {
+		return err
+	}
+	contract, err := checkpointoracle.NewCheckpointOracle(h.oracle.config.Address, nil)
+	if err != nil {
+		return err
+	}
+	events := contract.LookupCheckpointEvents(logs, p.checkpoint.SectionIndex, p.checkpoint.Hash())
+	if len(events) == 0 {
+		return errNoCheckpointVotes
+	}
+	var signatures [][]byte
+	for _, event := range events {
+		signatures = append(signatures, append(event.R[:], append(event.S[:], event.V)...))
+	}
+	valid, signers := h.oracle.verifySigners(p.checkpoint.SectionIndex, p.checkpoint.Hash(), signatures)
+	if !valid {
+		return errCheckpointNotApproved
+	}
+	p.Log().Debug("Verified advertised checkpoint", "section", p.checkpoint.SectionIndex, "signers", len(signers))
+	return nil
+}
+
+// headerRequest retrieves a single untrusted header by number from a given
+// server.
+type headerRequest struct {
+	peer   *peer
+	number uint64
+	header *types.Header
+}
+
+// CanSend tells if a certain peer is suitable for serving the given request
+func (r *headerRequest) CanSend(p *peer) bool {
+	return p == r.peer
+}
+
+// Request sends the header query to the server
+func (r *headerRequest) Request(reqID uint64, p *peer) error {
+	return p.requestHeaders(reqID, getBlockHeadersData{Origin: hashOrNumber{Number: r.number}, Amount: 1})
+}
+
+// Validate checks that exactly the requested header was returned
+func (r *headerRequest) Validate(db ctxcdb.Database, msg *Msg) error {
+	if msg.MsgType != BlockHeadersMsg {
+		return errInvalidMessageType
+	}
+	headers := msg.Obj.([]*types.Header)
+	if len(headers) != 1 {
+		return errInvalidEntryCount
+	}
+	if headers[0].Number.Uint64() != r.number {
+		return errHeaderUnavailable
+	}
+	r.header = headers[0]
+	return nil
+}
+
+// checkpointOf is a helper returning the checkpoint advertised by a server, nil
+// if it has none.
+func checkpointOf(p *peer) *params.TrustedCheckpoint {
+	if p.checkpoint.Empty() {
+		return nil
+	}
+	cp := p.checkpoint
+	return &cp
+}
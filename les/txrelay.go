@@ -0,0 +1,97 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"sync"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+)
+
+// lesTxRelay implements light.TxRelayBackend, broadcasting the transactions of
+// the light pool to the connected servers until they get mined or discarded.
+type lesTxRelay struct {
+	peers *peerSet
+
+	lock    sync.Mutex
+	pending map[common.Hash]*types.Transaction
+}
+
+func newLesTxRelay(peers *peerSet) *lesTxRelay {
+	return &lesTxRelay{
+		peers:   peers,
+		pending: make(map[common.Hash]*types.Transaction),
+	}
+}
+
+// broadcast sends the transactions to all servers in batches of the maximum
+// size a server accepts.
+func (ltrx *lesTxRelay) broadcast(txs types.Transactions) {
+	for _, p := range ltrx.peers.AllPeers() {
+		for start := 0; start < len(txs); start += MaxTxSend {
+			end := start + MaxTxSend
+			if end > len(txs) {
+				end = len(txs)
+			}
+			go func(p *peer, batch types.Transactions) {
+				if err := p.sendTxs(genReqID(), batch); err != nil {
+					p.Log().Debug("Failed to relay transactions", "err", err)
+				}
+			}(p, txs[start:end])
+		}
+	}
+}
+
+// Send relays new transactions to the servers.
+func (ltrx *lesTxRelay) Send(txs types.Transactions) {
+	ltrx.lock.Lock()
+	for _, tx := range txs {
+		ltrx.pending[tx.Hash()] = tx
+	}
+	ltrx.lock.Unlock()
+
+	ltrx.broadcast(txs)
+}
+
+// NewHead drops the mined transactions and resends the ones still pending, as
+// servers may have dropped them or connected after the first broadcast.
+func (ltrx *lesTxRelay) NewHead(head common.Hash, mined []common.Hash) {
+	ltrx.lock.Lock()
+	for _, hash := range mined {
+		delete(ltrx.pending, hash)
+	}
+	txs := make(types.Transactions, 0, len(ltrx.pending))
+	for _, tx := range ltrx.pending {
+		txs = append(txs, tx)
+	}
+	ltrx.lock.Unlock()
+
+	if len(txs) > 0 {
+		ltrx.broadcast(txs)
+	}
+}
+
+// Discard stops relaying the given transactions.
+func (ltrx *lesTxRelay) Discard(hashes []common.Hash) {
+	ltrx.lock.Lock()
+	defer ltrx.lock.Unlock()
+
+	for _, hash := range hashes {
+		delete(ltrx.pending, hash)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package light
+
+import (
+	"testing"
+
+	"github.com/CortexFoundation/CortexTheseus/consensus/cuckoo"
+	"github.com/CortexFoundation/CortexTheseus/core"
+	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/ctxcdb"
+	"github.com/CortexFoundation/CortexTheseus/params"
+)
+
+// makeHeaderChain creates a deterministic chain of headers rooted at parent.
+func makeHeaderChain(parent *types.Header, n int, db ctxcdb.Database, seed int) []*types.Header {
+	blocks, _ := core.GenerateChain(params.TestChainConfig, types.NewBlockWithHeader(parent), cuckoo.NewFaker(), db, n, func(i int, b *core.BlockGen) {
+		b.SetCoinbase([20]byte{0: byte(seed), 19: byte(i)})
+	})
+	headers := make([]*types.Header, len(blocks))
+	for i, block := range blocks {
+		headers[i] = block.Header()
+	}
+	return headers
+}
+
+// newTestLightChain creates a light chain over a fresh database with the
+// given consensus engine.
+func newTestLightChain(engine *cuckoo.Cuckoo) (*LightChain, ctxcdb.Database, *types.Header) {
+	db := rawdb.NewMemoryDatabase()
+	gspec := &core.Genesis{Config: params.TestChainConfig, Supply: params.CTXC_INIT}
+	genesis := gspec.MustCommit(db)
+
+	lc, err := NewLightChain(&testOdr{sdb: db, ldb: db, indexerConfig: TestClientIndexerConfig}, gspec.Config, engine, nil)
+	if err != nil {
+		panic(err)
+	}
+	return lc, db, genesis.Header()
+}
+
+func TestLightChainInsertHeaders(t *testing.T) {
+	lc, db, genesis := newTestLightChain(cuckoo.NewFaker())
+	defer lc.Stop()
+
+	heads := make(chan core.ChainHeadEvent, 10)
+	sub := lc.SubscribeChainHeadEvent(heads)
+	defer sub.Unsubscribe()
+
+	headers := makeHeaderChain(genesis, 16, db, 0)
+	if _, err := lc.InsertHeaderChain(headers, 1); err != nil {
+		t.Fatalf("failed to insert headers: %v", err)
+	}
+	if have, want := lc.CurrentHeader().Hash(), headers[15].Hash(); have != want {
+		t.Fatalf("head mismatch: have %x, want %x", have, want)
+	}
+	select {
+	case ev := <-heads:
+		if ev.Block.Hash() != headers[15].Hash() {
+			t.Fatalf("head event mismatch: have %x, want %x", ev.Block.Hash(), headers[15].Hash())
+		}
+	default:
+		t.Fatalf("no head event posted")
+	}
+	// A longer fork with more difficulty takes over the canonical chain
+	fork := makeHeaderChain(headers[7], 12, db, 1)
+	if _, err := lc.InsertHeaderChain(fork, 1); err != nil {
+		t.Fatalf("failed to insert fork: %v", err)
+	}
+	if have, want := lc.CurrentHeader().Hash(), fork[11].Hash(); have != want {
+		t.Fatalf("head mismatch after reorg: have %x, want %x", have, want)
+	}
+	if have, want := lc.GetHeaderByNumber(9).Hash(), fork[0].Hash(); have != want {
+		t.Fatalf("canonical hash mismatch after reorg: have %x, want %x", have, want)
+	}
+	// Rewinding drops the headers above the new head
+	if err := lc.SetHead(4); err != nil {
+		t.Fatalf("failed to rewind: %v", err)
+	}
+	if have, want := lc.CurrentHeader().Hash(), headers[3].Hash(); have != want {
+		t.Fatalf("head mismatch after rewind: have %x, want %x", have, want)
+	}
+	if lc.HasHeader(fork[0].Hash(), fork[0].Number.Uint64()) {
+		t.Fatalf("rewound header still present")
+	}
+}
+
+func TestLightChainInvalidSeal(t *testing.T) {
+	lc, db, genesis := newTestLightChain(cuckoo.NewFakeFailer(3))
+	defer lc.Stop()
+
+	headers := makeHeaderChain(genesis, 5, db, 0)
+	if n, err := lc.InsertHeaderChain(headers, 1); err == nil || n != 2 {
+		t.Fatalf("invalid seal accepted: index %d, err %v", n, err)
+	}
+	if have := lc.CurrentHeader().Number.Uint64(); have != 0 {
+		t.Fatalf("head moved past invalid seal: #%d", have)
+	}
+}
@@ -0,0 +1,343 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package light
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/consensus/cuckoo"
+	"github.com/CortexFoundation/CortexTheseus/core"
+	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/core/vm"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/ctxcdb"
+	"github.com/CortexFoundation/CortexTheseus/params"
+	"github.com/CortexFoundation/CortexTheseus/rlp"
+	"github.com/CortexFoundation/CortexTheseus/trie"
+)
+
+var (
+	testBankKey, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	testBankAddress = crypto.PubkeyToAddress(testBankKey.PublicKey)
+	testBankFunds   = big.NewInt(1000000000000000000)
+
+	testContractAddr = common.Address{0xcc}
+	testContractCode = common.Hex2Bytes("606060405260cc8060106000396000f360606040526000357c01000000000000000000000000000000000000000000000000000000009004")
+	testStorageKey   = common.Hash{0x01}
+	testStorageValue = common.Hash{0x02}
+
+	testRecipient = common.Address{0xaa}
+)
+
+// testOdr serves retrievals straight out of a full node database, verifying the
+// returned CHT proofs the same way a light client would.
+type testOdr struct {
+	OdrBackend
+	indexerConfig *IndexerConfig
+	sdb, ldb      ctxcdb.Database
+	cht           *core.ChainIndexer
+	served        int
+}
+
+func (odr *testOdr) Database() ctxcdb.Database            { return odr.ldb }
+func (odr *testOdr) ChtIndexer() *core.ChainIndexer       { return odr.cht }
+func (odr *testOdr) BloomTrieIndexer() *core.ChainIndexer { return nil }
+func (odr *testOdr) BloomIndexer() *core.ChainIndexer     { return nil }
+func (odr *testOdr) IndexerConfig() *IndexerConfig        { return odr.indexerConfig }
+
+var errTestNotFound = errors.New("not found")
+
+func (odr *testOdr) Retrieve(ctx context.Context, req OdrRequest) error {
+	odr.served++
+	switch req := req.(type) {
+	case *BlockRequest:
+		req.Rlp = rawdb.ReadBodyRLP(odr.sdb, req.Hash, req.Number)
+		if req.Rlp == nil {
+			return errTestNotFound
+		}
+	case *ReceiptsRequest:
+		req.Receipts = rawdb.ReadRawReceipts(odr.sdb, req.Hash, req.Number)
+	case *TrieRequest:
+		t, err := trie.New(req.Id.Root, trie.NewDatabase(odr.sdb))
+		if err != nil {
+			return err
+		}
+		nodes := NewNodeSet()
+		if err := t.Prove(req.Key, 0, nodes); err != nil {
+			return err
+		}
+		req.Proof = nodes
+	case *CodeRequest:
+		req.Data = rawdb.ReadCode(odr.sdb, req.Hash)
+		if crypto.Keccak256Hash(req.Data) != req.Hash {
+			return errTestNotFound
+		}
+	case *TxLookupRequest:
+		if number := rawdb.ReadTxLookupEntry(odr.sdb, req.Hash); number != nil {
+			block := rawdb.ReadBlock(odr.sdb, rawdb.ReadCanonicalHash(odr.sdb, *number), *number)
+			for i, tx := range block.Transactions() {
+				if tx.Hash() == req.Hash {
+					req.BlockHash, req.BlockNumber, req.Index = block.Hash(), *number, uint64(i)
+				}
+			}
+		}
+	case *ChtRequest:
+		var encNumber [8]byte
+		binary.BigEndian.PutUint64(encNumber[:], req.BlockNum)
+
+		t, err := trie.New(req.ChtRoot, trie.NewDatabase(rawdb.NewTable(odr.sdb, ChtTablePrefix)))
+		if err != nil {
+			return err
+		}
+		nodes := NewNodeSet()
+		if err := t.Prove(encNumber[:], 0, nodes); err != nil {
+			return err
+		}
+		value, err := trie.VerifyProof(req.ChtRoot, encNumber[:], nodes)
+		if err != nil {
+			return err
+		}
+		var node ChtNode
+		if err := rlp.DecodeBytes(value, &node); err != nil {
+			return err
+		}
+		header := rawdb.ReadHeader(odr.sdb, node.Hash, req.BlockNum)
+		if header == nil || header.Hash() != node.Hash {
+			return errTestNotFound
+		}
+		req.Header, req.Td, req.Proof = header, node.Td, nodes
+	default:
+		return errTestNotFound
+	}
+	req.StoreResult(odr.ldb)
+	return nil
+}
+
+// newTestChain creates a full node chain of the given length, along with a light
+// client database containing only the genesis and an ODR backend serving from
+// the full node.
+func newTestChain(t *testing.T, blocks int) (*core.BlockChain, *testOdr, []*types.Block) {
+	var (
+		sdb    = rawdb.NewMemoryDatabase()
+		ldb    = rawdb.NewMemoryDatabase()
+		signer = types.NewEIP155Signer(params.TestChainConfig.ChainID)
+		gspec  = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc: core.GenesisAlloc{
+				testBankAddress: {Balance: testBankFunds},
+				testContractAddr: {
+					Balance: big.NewInt(0),
+					Code:    testContractCode,
+					Storage: map[common.Hash]common.Hash{testStorageKey: testStorageValue},
+				},
+			},
+			Supply: params.CTXC_INIT,
+		}
+		genesis = gspec.MustCommit(sdb)
+	)
+	gspec.MustCommit(ldb)
+
+	chain, _ := core.GenerateChain(gspec.Config, genesis, cuckoo.NewFaker(), sdb, blocks, func(i int, gen *core.BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(gen.TxNonce(testBankAddress), testRecipient, big.NewInt(1), params.TxGas, big.NewInt(1), nil), signer, testBankKey)
+		gen.AddTx(tx)
+	})
+	blockchain, err := core.NewBlockChain(sdb, &core.CacheConfig{TrieDirtyDisabled: true}, params.TestChainConfig, cuckoo.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create full chain: %v", err)
+	}
+	if n, err := blockchain.InsertChain(chain); err != nil {
+		t.Fatalf("failed to insert block %d: %v", n, err)
+	}
+	odr := &testOdr{sdb: sdb, ldb: ldb, indexerConfig: TestClientIndexerConfig}
+	return blockchain, odr, chain
+}
+
+func TestOdrGetBlock(t *testing.T) {
+	blockchain, odr, blocks := newTestChain(t, 4)
+	defer blockchain.Stop()
+
+	lightchain, err := NewLightChain(odr, params.TestChainConfig, cuckoo.NewFaker(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lightchain.Stop()
+
+	headers := make([]*types.Header, len(blocks))
+	for i, block := range blocks {
+		headers[i] = block.Header()
+	}
+	if _, err := lightchain.InsertHeaderChain(headers, 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range blocks {
+		have, err := lightchain.GetBlockByNumber(context.Background(), want.NumberU64())
+		if err != nil {
+			t.Fatalf("block #%d: retrieval failed: %v", want.NumberU64(), err)
+		}
+		if have.Hash() != want.Hash() || len(have.Transactions()) != len(want.Transactions()) {
+			t.Fatalf("block #%d: mismatch", want.NumberU64())
+		}
+		receipts, err := GetBlockReceipts(context.Background(), odr, want.Hash(), want.NumberU64())
+		if err != nil {
+			t.Fatalf("block #%d: receipt retrieval failed: %v", want.NumberU64(), err)
+		}
+		if len(receipts) != 1 || receipts[0].TxHash != want.Transactions()[0].Hash() {
+			t.Fatalf("block #%d: receipt mismatch", want.NumberU64())
+		}
+	}
+	// Everything is stored locally now, retrievals must not hit the network
+	served := odr.served
+	if _, err := lightchain.GetBlockByNumber(context.Background(), 2); err != nil {
+		t.Fatal(err)
+	}
+	if odr.served != served {
+		t.Fatalf("cached block retrieved again")
+	}
+}
+
+func TestOdrGetTransaction(t *testing.T) {
+	blockchain, odr, blocks := newTestChain(t, 4)
+	defer blockchain.Stop()
+
+	lightchain, err := NewLightChain(odr, params.TestChainConfig, cuckoo.NewFaker(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lightchain.Stop()
+
+	headers := make([]*types.Header, len(blocks))
+	for i, block := range blocks {
+		headers[i] = block.Header()
+	}
+	if _, err := lightchain.InsertHeaderChain(headers, 1); err != nil {
+		t.Fatal(err)
+	}
+	want := blocks[2].Transactions()[0]
+	tx, hash, number, index, err := GetTransaction(context.Background(), odr, want.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx == nil || tx.Hash() != want.Hash() || hash != blocks[2].Hash() || number != 3 || index != 0 {
+		t.Fatalf("transaction mismatch: have %v in %x #%d:%d", tx, hash, number, index)
+	}
+	// Unknown transactions are reported as missing
+	if tx, _, _, _, err := GetTransaction(context.Background(), odr, common.Hash{0xff}); tx != nil || err != nil {
+		t.Fatalf("unknown transaction: have %v, %v", tx, err)
+	}
+}
+
+func TestOdrAccounts(t *testing.T) {
+	blockchain, odr, blocks := newTestChain(t, 4)
+	defer blockchain.Stop()
+
+	head := blocks[len(blocks)-1].Header()
+	rawdb.WriteHeader(odr.ldb, head)
+
+	statedb := NewState(context.Background(), head, odr)
+	if have := statedb.GetBalance(testRecipient); have.Cmp(big.NewInt(int64(len(blocks)))) != 0 {
+		t.Fatalf("recipient balance mismatch: have %v, want %d", have, len(blocks))
+	}
+	if have := statedb.GetNonce(testBankAddress); have != uint64(len(blocks)) {
+		t.Fatalf("bank nonce mismatch: have %d, want %d", have, len(blocks))
+	}
+	if have := statedb.GetCode(testContractAddr); !bytes.Equal(have, testContractCode) {
+		t.Fatalf("contract code mismatch: have %x, want %x", have, testContractCode)
+	}
+	if have := statedb.GetState(testContractAddr, testStorageKey); have != testStorageValue {
+		t.Fatalf("storage mismatch: have %x, want %x", have, testStorageValue)
+	}
+	if err := statedb.Error(); err != nil {
+		t.Fatal(err)
+	}
+	// A second state over the same root is served from the local database
+	served := odr.served
+	statedb = NewState(context.Background(), head, odr)
+	statedb.GetBalance(testRecipient)
+	statedb.GetCode(testContractAddr)
+	if odr.served != served {
+		t.Fatalf("retrieved state nodes are not cached locally")
+	}
+}
+
+func TestOdrGetHeaderByCht(t *testing.T) {
+	config := TestServerIndexerConfig
+	blockchain, odr, blocks := newTestChain(t, int(config.ChtSize+config.ChtConfirms))
+	defer blockchain.Stop()
+
+	// Build the canonical hash trie on the full node
+	indexer := NewChtIndexer(odr.sdb, config.ChtSize, config.ChtConfirms)
+	defer indexer.Close()
+	indexer.Start(blockchain)
+
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if sections, _, _ := indexer.Sections(); sections > 0 {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("canonical hash trie not generated")
+		}
+	}
+	head := blocks[config.ChtSize-2].Hash() // section head #ChtSize-1, blocks start at #1
+	root := GetChtRoot(odr.sdb, 0, head)
+	if root == (common.Hash{}) {
+		t.Fatalf("missing canonical hash trie root")
+	}
+	// Create a light chain trusting only the checkpoint
+	odr.indexerConfig = config
+	odr.cht = NewChtIndexer(odr.ldb, config.ChtSize, config.ChtConfirms)
+	defer odr.cht.Close()
+
+	checkpoint := &params.TrustedCheckpoint{
+		SectionIndex: 0,
+		SectionHead:  head,
+		CHTRoot:      root,
+		BloomRoot:    common.Hash{0x01},
+	}
+	lightchain, err := NewLightChain(odr, params.TestChainConfig, cuckoo.NewFaker(), checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lightchain.Stop()
+
+	for _, number := range []uint64{1, 64, config.ChtSize - 1} {
+		header, err := GetHeaderByNumber(context.Background(), odr, number)
+		if err != nil {
+			t.Fatalf("header #%d: retrieval failed: %v", number, err)
+		}
+		if header.Hash() != blocks[number-1].Hash() {
+			t.Fatalf("header #%d: hash mismatch", number)
+		}
+	}
+	if _, err := GetHeaderByNumber(context.Background(), odr, config.ChtSize); err != errNoTrustedCht {
+		t.Fatalf("header beyond checkpoint: have %v, want %v", err, errNoTrustedCht)
+	}
+	// Sync to the checkpoint, the section head becomes the local head
+	if !lightchain.SyncCheckpoint(context.Background(), checkpoint) {
+		t.Fatalf("failed to sync checkpoint")
+	}
+	if have := lightchain.CurrentHeader().Hash(); have != head {
+		t.Fatalf("head mismatch: have %x, want %x", have, head)
+	}
+}
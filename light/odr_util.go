@@ -0,0 +1,312 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package light
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/rlp"
+)
+
+var sha3Nil = crypto.Keccak256Hash(nil)
+
+// errTxNotFound is returned if a transaction lookup could not be proven by the
+// block body it points to.
+var errTxNotFound = errors.New("transaction not found")
+
+// GetHeaderByNumber retrieves the canonical block header corresponding to the
+// given number. The returned header is proven by local CHT.
+func GetHeaderByNumber(ctx context.Context, odr OdrBackend, number uint64) (*types.Header, error) {
+	// Try to find it in the local database first.
+	db := odr.Database()
+	hash := rawdb.ReadCanonicalHash(db, number)
+
+	// If there is a canonical hash, there should have a header too.
+	// But if it's pruned, re-fetch from network again.
+	if (hash != common.Hash{}) {
+		if header := rawdb.ReadHeader(db, hash, number); header != nil {
+			return header, nil
+		}
+	}
+	// Retrieve the header via ODR, ensure the requested header is covered
+	// by local trusted CHT.
+	chts, _, chtHead := odr.ChtIndexer().Sections()
+	if number >= chts*odr.IndexerConfig().ChtSize {
+		return nil, errNoTrustedCht
+	}
+	r := &ChtRequest{
+		ChtRoot:  GetChtRoot(db, chts-1, chtHead),
+		ChtNum:   chts - 1,
+		BlockNum: number,
+		Config:   odr.IndexerConfig(),
+	}
+	if err := odr.Retrieve(ctx, r); err != nil {
+		return nil, err
+	}
+	return r.Header, nil
+}
+
+// getHeader retrieves the block header corresponding to the given hash and
+// number, either from the local database or proven by the local CHT.
+func getHeader(ctx context.Context, odr OdrBackend, hash common.Hash, number uint64) (*types.Header, error) {
+	if header := rawdb.ReadHeader(odr.Database(), hash, number); header != nil {
+		return header, nil
+	}
+	header, err := GetHeaderByNumber(ctx, odr, number)
+	if err != nil {
+		return nil, err
+	}
+	if header.Hash() != hash {
+		return nil, errNoHeader
+	}
+	return header, nil
+}
+
+// GetCanonicalHash retrieves the canonical block hash corresponding to the number.
+func GetCanonicalHash(ctx context.Context, odr OdrBackend, number uint64) (common.Hash, error) {
+	hash := rawdb.ReadCanonicalHash(odr.Database(), number)
+	if hash != (common.Hash{}) {
+		return hash, nil
+	}
+	header, err := GetHeaderByNumber(ctx, odr, number)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	// number -> canonical mapping already be stored in db, get it.
+	return header.Hash(), nil
+}
+
+// GetTd retrieves the total difficulty corresponding to the number and hash.
+func GetTd(ctx context.Context, odr OdrBackend, hash common.Hash, number uint64) (*big.Int, error) {
+	td := rawdb.ReadTd(odr.Database(), hash, number)
+	if td != nil {
+		return td, nil
+	}
+	if _, err := getHeader(ctx, odr, hash, number); err != nil {
+		return nil, err
+	}
+	// <hash, number> -> td mapping already be stored in db, get it.
+	if td = rawdb.ReadTd(odr.Database(), hash, number); td == nil {
+		return nil, errNoHeader
+	}
+	return td, nil
+}
+
+// GetBodyRLP retrieves the block body (transactions and uncles) in RLP encoding.
+func GetBodyRLP(ctx context.Context, odr OdrBackend, hash common.Hash, number uint64) (rlp.RawValue, error) {
+	if data := rawdb.ReadBodyRLP(odr.Database(), hash, number); data != nil {
+		return data, nil
+	}
+	// Retrieve the block header first and pass it for verification.
+	header, err := getHeader(ctx, odr, hash, number)
+	if err != nil {
+		return nil, errNoHeader
+	}
+	r := &BlockRequest{Hash: hash, Number: number, Header: header}
+	if err := odr.Retrieve(ctx, r); err != nil {
+		return nil, err
+	}
+	return r.Rlp, nil
+}
+
+// GetBody retrieves the block body (transactions, uncles) corresponding to the
+// hash.
+func GetBody(ctx context.Context, odr OdrBackend, hash common.Hash, number uint64) (*types.Body, error) {
+	data, err := GetBodyRLP(ctx, odr, hash, number)
+	if err != nil {
+		return nil, err
+	}
+	body := new(types.Body)
+	if err := rlp.Decode(bytes.NewReader(data), body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// GetBlock retrieves an entire block corresponding to the hash, assembling it
+// back from the stored header and body.
+func GetBlock(ctx context.Context, odr OdrBackend, hash common.Hash, number uint64) (*types.Block, error) {
+	// Retrieve the block header and body contents
+	header, err := getHeader(ctx, odr, hash, number)
+	if err != nil {
+		return nil, errNoHeader
+	}
+	body, err := GetBody(ctx, odr, hash, number)
+	if err != nil {
+		return nil, err
+	}
+	// Reassemble the block and return
+	return types.NewBlockWithHeader(header).WithBody(body.Transactions, body.Uncles), nil
+}
+
+// GetBlockReceipts retrieves the receipts generated by the transactions included
+// in a block given by its hash.
+func GetBlockReceipts(ctx context.Context, odr OdrBackend, hash common.Hash, number uint64) (types.Receipts, error) {
+	// Assume receipts are already stored locally and attempt to retrieve.
+	receipts := rawdb.ReadRawReceipts(odr.Database(), hash, number)
+	if receipts == nil {
+		header, err := getHeader(ctx, odr, hash, number)
+		if err != nil {
+			return nil, errNoHeader
+		}
+		r := &ReceiptsRequest{Hash: hash, Number: number, Header: header}
+		if err := odr.Retrieve(ctx, r); err != nil {
+			return nil, err
+		}
+		receipts = r.Receipts
+	}
+	// If the receipts are incomplete, fill the derived fields
+	if len(receipts) > 0 && receipts[0].TxHash == (common.Hash{}) {
+		block, err := GetBlock(ctx, odr, hash, number)
+		if err != nil {
+			return nil, err
+		}
+		genesis := rawdb.ReadCanonicalHash(odr.Database(), 0)
+		config := rawdb.ReadChainConfig(odr.Database(), genesis)
+
+		if err := receipts.DeriveFields(config, block.Hash(), block.NumberU64(), block.Transactions()); err != nil {
+			return nil, err
+		}
+	}
+	return receipts, nil
+}
+
+// GetBlockLogs retrieves the logs generated by the transactions included in a
+// block given by its hash.
+func GetBlockLogs(ctx context.Context, odr OdrBackend, hash common.Hash, number uint64) ([][]*types.Log, error) {
+	// Retrieve the potentially incomplete receipts from disk or network
+	receipts, err := GetBlockReceipts(ctx, odr, hash, number)
+	if err != nil {
+		return nil, err
+	}
+	logs := make([][]*types.Log, len(receipts))
+	for i, receipt := range receipts {
+		logs[i] = receipt.Logs
+	}
+	return logs, nil
+}
+
+// GetUntrustedBlockLogs retrieves the logs generated by the transactions included
+// in a block. The retrieved logs are regarded as untrusted and will not be stored
+// in the database. This function should only be used in light client checkpoint
+// syncing, where the header itself was not yet verified against the local chain.
+func GetUntrustedBlockLogs(ctx context.Context, odr OdrBackend, header *types.Header) ([][]*types.Log, error) {
+	// Retrieve the potentially incomplete receipts from disk or network
+	hash, number := header.Hash(), header.Number.Uint64()
+	receipts := rawdb.ReadRawReceipts(odr.Database(), hash, number)
+	if receipts == nil {
+		r := &ReceiptsRequest{Hash: hash, Number: number, Header: header, Untrusted: true}
+		if err := odr.Retrieve(ctx, r); err != nil {
+			return nil, err
+		}
+		receipts = r.Receipts
+		// Untrusted request won't be stored in the database. Manually
+		// assign the hashes to the logs so the caller can match them.
+	}
+	logs := make([][]*types.Log, len(receipts))
+	for i, receipt := range receipts {
+		for _, log := range receipt.Logs {
+			log.BlockHash = hash
+			log.BlockNumber = number
+		}
+		logs[i] = receipt.Logs
+	}
+	return logs, nil
+}
+
+// GetBloomBits retrieves a batch of compressed bloomBits vectors belonging to
+// the given bit index and section indexes.
+func GetBloomBits(ctx context.Context, odr OdrBackend, bit uint, sections []uint64) ([][]byte, error) {
+	var (
+		reqIndex    []int
+		reqSections []uint64
+		db          = odr.Database()
+		result      = make([][]byte, len(sections))
+	)
+	blooms, _, sectionHead := odr.BloomTrieIndexer().Sections()
+	for i, section := range sections {
+		head := rawdb.ReadCanonicalHash(db, (section+1)*odr.IndexerConfig().BloomTrieSize-1)
+		// If we don't have the canonical hash stored for this section head number,
+		// we'll still look for an entry with a zero sectionHead (we store it with
+		// zero section head too if we don't know it at the time of the retrieval)
+		if bloomBits, _ := rawdb.ReadBloomBits(db, bit, section, head); len(bloomBits) != 0 {
+			result[i] = bloomBits
+			continue
+		}
+		if section >= blooms {
+			return nil, errNoTrustedBloomTrie
+		}
+		reqSections = append(reqSections, section)
+		reqIndex = append(reqIndex, i)
+	}
+	// Find all bloombits in database, nothing to query via odr, return.
+	if reqSections == nil {
+		return result, nil
+	}
+	// Send odr request to retrieve missing bloombits.
+	r := &BloomRequest{
+		BloomTrieRoot:    GetBloomTrieRoot(db, blooms-1, sectionHead),
+		BloomTrieNum:     blooms - 1,
+		BitIdx:           bit,
+		SectionIndexList: reqSections,
+		Config:           odr.IndexerConfig(),
+	}
+	if err := odr.Retrieve(ctx, r); err != nil {
+		return nil, err
+	}
+	for i, idx := range reqIndex {
+		result[idx] = r.BloomBits[i]
+	}
+	return result, nil
+}
+
+// GetTransaction retrieves a canonical transaction by hash, along with its block
+// hash, block number and index. If the transaction is not known locally, its
+// position is looked up remotely and proven by retrieving the referenced block.
+func GetTransaction(ctx context.Context, odr OdrBackend, txHash common.Hash) (*types.Transaction, common.Hash, uint64, uint64, error) {
+	if tx, blockHash, number, index := rawdb.ReadTransaction(odr.Database(), txHash); tx != nil {
+		return tx, blockHash, number, index, nil
+	}
+	r := &TxLookupRequest{Hash: txHash}
+	if err := odr.Retrieve(ctx, r); err != nil {
+		return nil, common.Hash{}, 0, 0, err
+	}
+	if r.BlockHash == (common.Hash{}) {
+		return nil, common.Hash{}, 0, 0, nil
+	}
+	// Verify the lookup against the canonical chain and the block contents
+	if hash, err := GetCanonicalHash(ctx, odr, r.BlockNumber); err != nil || hash != r.BlockHash {
+		return nil, common.Hash{}, 0, 0, errTxNotFound
+	}
+	block, err := GetBlock(ctx, odr, r.BlockHash, r.BlockNumber)
+	if err != nil {
+		return nil, common.Hash{}, 0, 0, err
+	}
+	txs := block.Transactions()
+	if r.Index >= uint64(len(txs)) || txs[r.Index].Hash() != txHash {
+		return nil, common.Hash{}, 0, 0, errTxNotFound
+	}
+	rawdb.WriteTxLookupEntriesByBlock(odr.Database(), block)
+	return txs[r.Index], r.BlockHash, r.BlockNumber, r.Index, nil
+}
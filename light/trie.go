@@ -0,0 +1,183 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package light
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core/rawdb"
+	"github.com/CortexFoundation/CortexTheseus/core/state"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/crypto"
+	"github.com/CortexFoundation/CortexTheseus/ctxcdb"
+	"github.com/CortexFoundation/CortexTheseus/trie"
+)
+
+// NewState creates a state database backed by on-demand retrieval. Trie nodes
+// and contract code missing from the local database are fetched from the
+// network and verified against the state root of the given header.
+func NewState(ctx context.Context, head *types.Header, odr OdrBackend) *state.StateDB {
+	state, _ := state.New(head.Root, NewStateDatabase(ctx, head, odr), nil)
+	return state
+}
+
+// NewStateDatabase creates an ODR capable state.Database for the given header.
+func NewStateDatabase(ctx context.Context, head *types.Header, odr OdrBackend) state.Database {
+	return &odrDatabase{ctx, StateTrieID(head), odr}
+}
+
+type odrDatabase struct {
+	ctx     context.Context
+	id      *TrieID
+	backend OdrBackend
+}
+
+func (db *odrDatabase) OpenTrie(root common.Hash) (state.Trie, error) {
+	return &odrTrie{db: db, id: db.id}, nil
+}
+
+func (db *odrDatabase) OpenStorageTrie(addrHash, root common.Hash) (state.Trie, error) {
+	return &odrTrie{db: db, id: StorageTrieID(db.id, addrHash, root)}, nil
+}
+
+func (db *odrDatabase) CopyTrie(t state.Trie) state.Trie {
+	switch t := t.(type) {
+	case *odrTrie:
+		cpy := &odrTrie{db: t.db, id: t.id}
+		if t.trie != nil {
+			cpytrie := *t.trie
+			cpy.trie = &cpytrie
+		}
+		return cpy
+	default:
+		panic(fmt.Errorf("unknown trie type %T", t))
+	}
+}
+
+func (db *odrDatabase) ContractCode(addrHash, codeHash common.Hash) ([]byte, error) {
+	if codeHash == sha3Nil {
+		return nil, nil
+	}
+	if code := rawdb.ReadCode(db.backend.Database(), codeHash); len(code) != 0 {
+		return code, nil
+	}
+	id := *db.id
+	id.AccKey = addrHash[:]
+	req := &CodeRequest{Id: &id, Hash: codeHash}
+	err := db.backend.Retrieve(db.ctx, req)
+	return req.Data, err
+}
+
+func (db *odrDatabase) ContractCodeSize(addrHash, codeHash common.Hash) (int, error) {
+	code, err := db.ContractCode(addrHash, codeHash)
+	return len(code), err
+}
+
+// TrieDB returns nil, the light state is never committed to disk.
+func (db *odrDatabase) TrieDB() *trie.Database {
+	return nil
+}
+
+// odrTrie is a secure trie whose missing nodes are retrieved on demand. Keys
+// are hashed before accessing the underlying trie.
+type odrTrie struct {
+	db   *odrDatabase
+	id   *TrieID
+	trie *trie.Trie
+}
+
+func (t *odrTrie) TryGet(key []byte) ([]byte, error) {
+	key = crypto.Keccak256(key)
+	var res []byte
+	err := t.do(key, func() (err error) {
+		res, err = t.trie.TryGet(key)
+		return err
+	})
+	return res, err
+}
+
+func (t *odrTrie) TryUpdate(key, value []byte) error {
+	key = crypto.Keccak256(key)
+	return t.do(key, func() error {
+		return t.trie.TryUpdate(key, value)
+	})
+}
+
+func (t *odrTrie) TryDelete(key []byte) error {
+	key = crypto.Keccak256(key)
+	return t.do(key, func() error {
+		return t.trie.TryDelete(key)
+	})
+}
+
+func (t *odrTrie) Commit(onleaf trie.LeafCallback) (common.Hash, error) {
+	if t.trie == nil {
+		return t.id.Root, nil
+	}
+	return t.trie.Commit(onleaf)
+}
+
+func (t *odrTrie) Hash() common.Hash {
+	if t.trie == nil {
+		return t.id.Root
+	}
+	return t.trie.Hash()
+}
+
+// NodeIterator iterates over the locally available nodes only, nodes which were
+// not retrieved yet surface as an iteration error.
+func (t *odrTrie) NodeIterator(startkey []byte) trie.NodeIterator {
+	if t.trie == nil {
+		if err := t.do(nil, func() error { return nil }); err != nil {
+			tr, _ := trie.New(common.Hash{}, trie.NewDatabase(t.db.backend.Database()))
+			return tr.NodeIterator(startkey)
+		}
+	}
+	return t.trie.NodeIterator(startkey)
+}
+
+func (t *odrTrie) GetKey(sha []byte) []byte {
+	return nil
+}
+
+func (t *odrTrie) Prove(key []byte, fromLevel uint, proofDb ctxcdb.KeyValueWriter) error {
+	return errors.New("not implemented, needs client/server interface split")
+}
+
+// do tries and retries to execute a function until it returns with no error or
+// an error type other than MissingNodeError
+func (t *odrTrie) do(key []byte, fn func() error) error {
+	for {
+		var err error
+		if t.trie == nil {
+			t.trie, err = trie.New(t.id.Root, trie.NewDatabase(t.db.backend.Database()))
+		}
+		if err == nil {
+			err = fn()
+		}
+		if _, ok := err.(*trie.MissingNodeError); !ok {
+			return err
+		}
+		r := &TrieRequest{Id: t.id, Key: key}
+		if err := t.db.backend.Retrieve(t.db.ctx, r); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,407 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package light
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/core"
+	"github.com/CortexFoundation/CortexTheseus/core/state"
+	"github.com/CortexFoundation/CortexTheseus/core/types"
+	"github.com/CortexFoundation/CortexTheseus/event"
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/params"
+)
+
+const (
+	chainHeadChanSize = 10
+
+	// txMaxSize mirrors the full transaction pool's size limit.
+	txMaxSize = 4 * 32 * 1024
+
+	// maxMinedCheck is the number of recent blocks scanned for pending
+	// transactions on a new chain head.
+	maxMinedCheck = 64
+)
+
+// txPermanent is the number of mined blocks after a mined transaction is
+// considered permanent and no rollback is expected
+var txPermanent = uint64(500)
+
+// TxPool implements the transaction pool for light clients, which keeps track
+// of the status of locally created transactions, detecting if they are included
+// in a block (mined) or rolled back. There are no queued transactions since we
+// always receive all locally signed transactions in the same order as they are
+// created.
+type TxPool struct {
+	config       *params.ChainConfig
+	signer       types.Signer
+	quit         chan struct{}
+	txFeed       event.Feed
+	scope        event.SubscriptionScope
+	chainHeadCh  chan core.ChainHeadEvent
+	chainHeadSub event.Subscription
+	mu           sync.RWMutex
+	chain        *LightChain
+	odr          OdrBackend
+	relay        TxRelayBackend
+	head         *types.Header
+	nonce        map[common.Address]uint64          // "pending" nonce
+	pending      map[common.Hash]*types.Transaction // pending transactions by tx hash
+	mined        map[common.Hash]uint64             // mined transactions by tx hash, mapped to the block number
+	wg           sync.WaitGroup
+}
+
+// TxRelayBackend provides an interface to the mechanism that forwards transactions
+// to the CTXC network. The implementations of the functions should be non-blocking.
+//
+// Send instructs backend to forward new transactions.
+// NewHead notifies backend about a new head after processed by the tx pool,
+// including mined transactions since the last NewHead call.
+// Discard notifies backend about transactions that should be discarded either
+// because they have been replaced by a re-send or because they have been mined
+// long ago and no rollback is expected.
+type TxRelayBackend interface {
+	Send(txs types.Transactions)
+	NewHead(head common.Hash, mined []common.Hash)
+	Discard(hashes []common.Hash)
+}
+
+// NewTxPool creates a new light transaction pool
+func NewTxPool(config *params.ChainConfig, chain *LightChain, relay TxRelayBackend) *TxPool {
+	pool := &TxPool{
+		config:      config,
+		signer:      types.MakeSigner(config, chain.CurrentHeader().Number),
+		nonce:       make(map[common.Address]uint64),
+		pending:     make(map[common.Hash]*types.Transaction),
+		mined:       make(map[common.Hash]uint64),
+		quit:        make(chan struct{}),
+		chainHeadCh: make(chan core.ChainHeadEvent, chainHeadChanSize),
+		chain:       chain,
+		relay:       relay,
+		odr:         chain.Odr(),
+		head:        chain.CurrentHeader(),
+	}
+	// Subscribe events from blockchain
+	pool.chainHeadSub = pool.chain.SubscribeChainHeadEvent(pool.chainHeadCh)
+
+	pool.wg.Add(1)
+	go pool.eventLoop()
+
+	return pool
+}
+
+// currentState returns the light state of the current head header
+func (pool *TxPool) currentState(ctx context.Context) *state.StateDB {
+	return NewState(ctx, pool.chain.CurrentHeader(), pool.odr)
+}
+
+// GetNonce returns the "pending" nonce of a given address. It always queries
+// the nonce belonging to the latest header too in order to detect if another
+// client using the same key sent a transaction.
+func (pool *TxPool) GetNonce(ctx context.Context, addr common.Address) (uint64, error) {
+	state := pool.currentState(ctx)
+	nonce := state.GetNonce(addr)
+	if state.Error() != nil {
+		return 0, state.Error()
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	sn, ok := pool.nonce[addr]
+	if ok && sn > nonce {
+		nonce = sn
+	}
+	if !ok || sn < nonce {
+		pool.nonce[addr] = nonce
+	}
+	return nonce, nil
+}
+
+// eventLoop processes chain head events and also notifies the tx relay backend
+// about the new head hash and tx state changes
+func (pool *TxPool) eventLoop() {
+	defer pool.wg.Done()
+
+	for {
+		select {
+		case ev := <-pool.chainHeadCh:
+			pool.setNewHead(ev.Block.Header())
+
+		// System stopped
+		case <-pool.chainHeadSub.Err():
+			return
+		case <-pool.quit:
+			return
+		}
+	}
+}
+
+// setNewHead scans the blocks since the previous head for pending transactions,
+// marking the included ones as mined and discarding the ones which are mined
+// deep enough not to be rolled back anymore.
+func (pool *TxPool) setNewHead(head *types.Header) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	pool.mu.Lock()
+	from := pool.head.Number.Uint64() + 1
+	pool.head = head
+	pool.signer = types.MakeSigner(pool.config, head.Number)
+	pending := len(pool.pending)
+	pool.mu.Unlock()
+
+	number := head.Number.Uint64()
+	if number+1 > from+maxMinedCheck {
+		from = number + 1 - maxMinedCheck
+	}
+	var mined []common.Hash
+	for n := from; pending > 0 && n <= number; n++ {
+		block, err := pool.chain.GetBlockByNumber(ctx, n)
+		if err != nil || block == nil {
+			log.Debug("Failed to retrieve block for mined tx check", "number", n, "err", err)
+			break
+		}
+		pool.mu.Lock()
+		for _, tx := range block.Transactions() {
+			hash := tx.Hash()
+			if _, ok := pool.pending[hash]; ok {
+				delete(pool.pending, hash)
+				pool.mined[hash] = n
+				mined = append(mined, hash)
+			}
+		}
+		pending = len(pool.pending)
+		pool.mu.Unlock()
+	}
+	// Forget about transactions which were mined long ago
+	var discard []common.Hash
+	pool.mu.Lock()
+	for hash, n := range pool.mined {
+		if n+txPermanent <= number {
+			delete(pool.mined, hash)
+			discard = append(discard, hash)
+		}
+	}
+	pool.mu.Unlock()
+
+	pool.relay.NewHead(head.Hash(), mined)
+	if len(discard) > 0 {
+		pool.relay.Discard(discard)
+	}
+}
+
+// Stop stops the light transaction pool
+func (pool *TxPool) Stop() {
+	// Unsubscribe all subscriptions registered from txpool
+	pool.scope.Close()
+	// Unsubscribe subscriptions registered from blockchain
+	pool.chainHeadSub.Unsubscribe()
+	close(pool.quit)
+	pool.wg.Wait()
+	log.Info("Transaction pool stopped")
+}
+
+// SubscribeNewTxsEvent registers a subscription of core.NewTxsEvent and
+// starts sending event to the given channel.
+func (pool *TxPool) SubscribeNewTxsEvent(ch chan<- core.NewTxsEvent) event.Subscription {
+	return pool.scope.Track(pool.txFeed.Subscribe(ch))
+}
+
+// Stats returns the number of currently pending (locally created) transactions
+func (pool *TxPool) Stats() (pending int) {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	pending = len(pool.pending)
+	return
+}
+
+// validateTx checks whether a transaction is valid according to the consensus rules.
+func (pool *TxPool) validateTx(ctx context.Context, tx *types.Transaction) error {
+	// Validate sender
+	var (
+		from common.Address
+		err  error
+	)
+	// Validate the transaction sender and it's sig. Throw
+	// if the from fields is invalid.
+	if from, err = types.Sender(pool.signer, tx); err != nil {
+		return core.ErrInvalidSender
+	}
+	// Reject transactions over defined size to prevent DOS attacks
+	if uint64(tx.Size()) > txMaxSize {
+		return core.ErrOversizedData
+	}
+	// Transactions can't be negative. This may never happen using RLP decoded
+	// transactions but may occur if you create a transaction using the RPC.
+	if tx.Value().Sign() < 0 {
+		return core.ErrNegativeValue
+	}
+	// Check the transaction doesn't exceed the current block limit gas.
+	header := pool.chain.CurrentHeader()
+	if header.GasLimit < tx.Gas() {
+		return core.ErrGasLimit
+	}
+	// Last but not least check for nonce errors
+	currentState := NewState(ctx, header, pool.odr)
+	if n := currentState.GetNonce(from); n > tx.Nonce() {
+		return core.ErrNonceTooLow
+	}
+	// Transactor should have enough funds to cover the costs
+	// cost == V + GP * GL
+	if b := currentState.GetBalance(from); b.Cmp(tx.Cost()) < 0 {
+		return core.ErrInsufficientFunds
+	}
+	// Should supply enough intrinsic gas
+	upload := tx.To() != nil && tx.Value().Sign() == 0 && currentState.Uploading(*tx.To())
+	gas, err := core.IntrinsicGas(tx.Data(), tx.To() == nil, upload, true, pool.config.IsIstanbul(header.Number))
+	if err != nil {
+		return err
+	}
+	if tx.Gas() < gas {
+		return core.ErrIntrinsicGas
+	}
+	return currentState.Error()
+}
+
+// add validates a new transaction and sets its state pending if processable.
+// It also updates the locally stored nonce if necessary.
+func (pool *TxPool) add(ctx context.Context, tx *types.Transaction) error {
+	hash := tx.Hash()
+
+	if pool.pending[hash] != nil {
+		return fmt.Errorf("known transaction (%x)", hash[:4])
+	}
+	err := pool.validateTx(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if _, ok := pool.pending[hash]; !ok {
+		pool.pending[hash] = tx
+
+		nonce := tx.Nonce() + 1
+
+		addr, _ := types.Sender(pool.signer, tx)
+		if nonce > pool.nonce[addr] {
+			pool.nonce[addr] = nonce
+		}
+		// Notify the subscribers. This event is posted in a goroutine
+		// because it's possible that somewhere during the post "Remove transaction"
+		// gets called which will then wait for the global tx pool lock and deadlock.
+		go pool.txFeed.Send(core.NewTxsEvent{Txs: types.Transactions{tx}})
+	}
+	// Print a log message if low enough level is set
+	log.Debug("Pooled new transaction", "hash", hash, "from", log.Lazy{Fn: func() common.Address { from, _ := types.Sender(pool.signer, tx); return from }}, "to", tx.To())
+	return nil
+}
+
+// Add adds a transaction to the pool if valid and passes it to the tx relay
+// backend
+func (pool *TxPool) Add(ctx context.Context, tx *types.Transaction) error {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if err := pool.add(ctx, tx); err != nil {
+		return err
+	}
+	pool.relay.Send(types.Transactions{tx})
+	return nil
+}
+
+// AddBatch adds all valid transactions to the pool and passes them to
+// the tx relay backend
+func (pool *TxPool) AddBatch(ctx context.Context, txs []*types.Transaction) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	var sendTx types.Transactions
+	for _, tx := range txs {
+		if err := pool.add(ctx, tx); err == nil {
+			sendTx = append(sendTx, tx)
+		}
+	}
+	if len(sendTx) > 0 {
+		pool.relay.Send(sendTx)
+	}
+}
+
+// GetTransaction returns a transaction if it is contained in the pool
+// and nil otherwise.
+func (pool *TxPool) GetTransaction(hash common.Hash) *types.Transaction {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	return pool.pending[hash]
+}
+
+// GetTransactions returns all currently processable transactions.
+// The returned slice may be modified by the caller.
+func (pool *TxPool) GetTransactions() (txs types.Transactions, err error) {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	txs = make(types.Transactions, 0, len(pool.pending))
+	for _, tx := range pool.pending {
+		txs = append(txs, tx)
+	}
+	return txs, nil
+}
+
+// Content retrieves the data content of the transaction pool, returning all the
+// pending as well as queued transactions, grouped by account and nonce.
+func (pool *TxPool) Content() (map[common.Address]types.Transactions, map[common.Address]types.Transactions) {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	// Retrieve all the pending transactions and sort by account and by nonce
+	pending := make(map[common.Address]types.Transactions)
+	for _, tx := range pool.pending {
+		account, _ := types.Sender(pool.signer, tx)
+		pending[account] = append(pending[account], tx)
+	}
+	// There are no queued transactions in a light pool, just return an empty map
+	queued := make(map[common.Address]types.Transactions)
+	return pending, queued
+}
+
+// RemoveTransactions removes all given transactions from the pool.
+func (pool *TxPool) RemoveTransactions(txs types.Transactions) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	var hashes []common.Hash
+	for _, tx := range txs {
+		hash := tx.Hash()
+		delete(pool.pending, hash)
+		hashes = append(hashes, hash)
+	}
+	pool.relay.Discard(hashes)
+}
+
+// RemoveTx removes the transaction with the given hash from the pool.
+func (pool *TxPool) RemoveTx(hash common.Hash) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	// delete from pending pool
+	delete(pool.pending, hash)
+	pool.relay.Discard([]common.Hash{hash})
+}
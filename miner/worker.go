@@ -655,6 +655,9 @@ func (w *worker) makeCurrent(parent *types.Block, header *types.Header) error {
 		return err
 	}
 	state.StartPrefetcher("miner")
+	if w.chain.StateDiffsEnabled() {
+		state.EnableDiffs()
+	}
 
 	env := &environment{
 		signer:    types.NewEIP155Signer(w.chainConfig.ChainID),
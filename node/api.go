@@ -19,7 +19,9 @@ package node
 import (
 	"context"
 	"fmt"
+	"net"
 	"strings"
+	"time"
 
 	"github.com/CortexFoundation/CortexTheseus/common/hexutil"
 	"github.com/CortexFoundation/CortexTheseus/crypto"
@@ -104,6 +106,81 @@ func (api *PrivateAdminAPI) RemoveTrustedPeer(url string) (bool, error) {
 	return true, nil
 }
 
+// Ban refuses connections from a remote node, given by enode URL or node ID, and
+// disconnects it if connected. The ban lasts for the given number of seconds,
+// or twice as long as the previous ban of the node if omitted.
+func (api *PrivateAdminAPI) Ban(node string, seconds *uint64) (bool, error) {
+	// Make sure the server is running, fail otherwise
+	server := api.node.Server()
+	if server == nil {
+		return false, ErrNodeStopped
+	}
+	id, ip, err := parseNodeID(node)
+	if err != nil {
+		return false, err
+	}
+	var duration time.Duration
+	if seconds != nil {
+		if *seconds == 0 {
+			return false, fmt.Errorf("zero ban duration")
+		}
+		duration = time.Duration(*seconds) * time.Second
+	}
+	if _, err := server.BanPeer(id, ip, duration); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Unban lifts the ban of a remote node, given by enode URL or node ID, and
+// resets its reputation. It returns whether the node was banned.
+func (api *PrivateAdminAPI) Unban(node string) (bool, error) {
+	// Make sure the server is running, fail otherwise
+	server := api.node.Server()
+	if server == nil {
+		return false, ErrNodeStopped
+	}
+	id, _, err := parseNodeID(node)
+	if err != nil {
+		return false, err
+	}
+	return server.UnbanPeer(id)
+}
+
+// PeerScores retrieves the reputation of all the remote nodes whose conduct was
+// reported, along with all the banned ones.
+func (api *PrivateAdminAPI) PeerScores() ([]*p2p.PeerScore, error) {
+	server := api.node.Server()
+	if server == nil {
+		return nil, ErrNodeStopped
+	}
+	return server.PeerScores(), nil
+}
+
+// DiscoveryStats retrieves the health of the node discovery tables, along with
+// the weights of the protocol dial candidate sources.
+func (api *PrivateAdminAPI) DiscoveryStats() (*p2p.DiscoveryStats, error) {
+	server := api.node.Server()
+	if server == nil {
+		return nil, ErrNodeStopped
+	}
+	return server.DiscoveryStats(), nil
+}
+
+// parseNodeID parses a node given either as an enode URL or as a hex node ID,
+// returning the IP address too if the URL contains it.
+func parseNodeID(node string) (enode.ID, net.IP, error) {
+	var id enode.ID
+	if err := id.UnmarshalText([]byte(node)); err == nil {
+		return id, nil, nil
+	}
+	n, err := enode.Parse(enode.ValidSchemes, node)
+	if err != nil {
+		return id, nil, fmt.Errorf("invalid enode: %v", err)
+	}
+	return n.ID(), n.IP(), nil
+}
+
 // PeerEvents creates an RPC subscription which receives peer events from the
 // node's p2p.Server
 func (api *PrivateAdminAPI) PeerEvents(ctx context.Context) (*rpc.Subscription, error) {
@@ -68,6 +68,11 @@ type Config struct {
 	// in memory.
 	DataDir string
 
+	// DBEngine is the database engine backing the node's databases, "leveldb" or
+	// "pebble". If empty, the engine of any pre-existing database is used, with
+	// new databases defaulting to leveldb.
+	DBEngine string `toml:",omitempty"`
+
 	// Configuration of peer-to-peer networking.
 	P2P p2p.Config
 
@@ -134,6 +139,20 @@ type Config struct {
 	// interface.
 	HTTPTimeouts rpc.HTTPTimeouts
 
+	// JWTSecret is the path of the file holding the hex encoded secret used to
+	// authenticate HTTP and WebSocket RPC requests with HS256 signed JSON web
+	// tokens. A new secret is generated if the file does not exist. If this field
+	// is empty, requests are not authenticated.
+	JWTSecret string `toml:",omitempty"`
+
+	// RPCAuditLog is the path of the file in which rejected RPC requests are
+	// recorded. If this field is empty, rejections are only logged.
+	RPCAuditLog string `toml:",omitempty"`
+
+	// RPCLimits configures the batch, response size and request rate limits
+	// enforced on the clients of the HTTP and WebSocket RPC endpoints.
+	RPCLimits rpc.Limits `toml:",omitempty"`
+
 	// WSHost is the host interface on which to start the websocket RPC server. If
 	// this field is empty, no websocket API endpoint will be started.
 	WSHost string
@@ -160,15 +179,6 @@ type Config struct {
 	// private APIs to untrusted users is a major security risk.
 	WSExposeAll bool `toml:",omitempty"`
 
-	// GraphQLHost is the host interface on which to start the GraphQL server. If this
-	// field is empty, no GraphQL API endpoint will be started.
-	GraphQLHost string
-
-	// GraphQLPort is the TCP port number on which to start the GraphQL server. The
-	// default zero value is/ valid and will pick a port number randomly (useful
-	// for ephemeral nodes).
-	GraphQLPort int `toml:",omitempty"`
-
 	// GraphQLCors is the Cross-Origin Resource Sharing header to send to requesting
 	// clients. Please be aware that CORS is a browser enforced security, it's fully
 	// useless for custom HTTP clients.
@@ -245,15 +255,6 @@ func (c *Config) HTTPEndpoint() string {
 	return fmt.Sprintf("%s:%d", c.HTTPHost, c.HTTPPort)
 }
 
-// GraphQLEndpoint resolves a GraphQL endpoint based on the configured host interface
-// and port parameters.
-func (c *Config) GraphQLEndpoint() string {
-	if c.GraphQLHost == "" {
-		return ""
-	}
-	return fmt.Sprintf("%s:%d", c.GraphQLHost, c.GraphQLPort)
-}
-
 // DefaultHTTPEndpoint returns the HTTP endpoint used by default.
 func DefaultHTTPEndpoint() string {
 	config := &Config{HTTPHost: DefaultHTTPHost, HTTPPort: DefaultHTTPPort}
@@ -276,9 +277,9 @@ func DefaultWSEndpoint() string {
 }
 
 // ExtRPCEnabled returns the indicator whether node enables the external
-// RPC(http, ws or graphql).
+// RPC(http or ws).
 func (c *Config) ExtRPCEnabled() bool {
-	return c.HTTPHost != "" || c.WSHost != "" || c.GraphQLHost != ""
+	return c.HTTPHost != "" || c.WSHost != ""
 }
 
 // NodeName returns the devp2p node identifier.
@@ -0,0 +1,317 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common"
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/rpc"
+)
+
+const (
+	jwtSecretLength = 32          // Length of the HS256 secret in bytes
+	jwtClockSkew    = time.Minute // Tolerated clock difference for time based claims
+)
+
+var (
+	errMissingToken     = errors.New("missing bearer token")
+	errMalformedToken   = errors.New("malformed token")
+	errUnsupportedAlg   = errors.New("unsupported signing algorithm")
+	errInvalidSignature = errors.New("invalid token signature")
+	errTokenExpired     = errors.New("token is expired")
+	errTokenNotValidYet = errors.New("token is not valid yet")
+	errNamespaceDenied  = errors.New("namespace not allowed for token")
+)
+
+// jwtClaims is the set of claims understood in RPC authentication tokens. The
+// time based claims are optional and checked only if present. Namespaces, if
+// set, lists the API namespaces the bearer is allowed to call.
+type jwtClaims struct {
+	Subject    string   `json:"sub,omitempty"`
+	IssuedAt   *int64   `json:"iat,omitempty"`
+	NotBefore  *int64   `json:"nbf,omitempty"`
+	ExpiresAt  *int64   `json:"exp,omitempty"`
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// loadJWTSecret reads the hex encoded HS256 secret from the given file. If the
+// file does not exist, a fresh random secret is generated and stored there.
+func loadJWTSecret(path string) ([]byte, error) {
+	if data, err := ioutil.ReadFile(path); err == nil {
+		secret := common.FromHex(strings.TrimSpace(string(data)))
+		if len(secret) != jwtSecretLength {
+			return nil, fmt.Errorf("invalid JWT secret in %s: need %d hex encoded bytes", path, jwtSecretLength)
+		}
+		return secret, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	secret := make([]byte, jwtSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path, []byte(common.Bytes2Hex(secret)), 0600); err != nil {
+		return nil, err
+	}
+	log.Info("Generated JWT secret", "path", path)
+	return secret, nil
+}
+
+// parseJWT verifies an HS256 signed token against the secret and returns its
+// claims if the signature and all time based claims are valid at now.
+func parseJWT(secret []byte, token string, now time.Time) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errMalformedToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "HS256" {
+		return nil, errUnsupportedAlg
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errMalformedToken
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, errInvalidSignature
+	}
+	claims := new(jwtClaims)
+	if err := decodeJWTSegment(parts[1], claims); err != nil {
+		return nil, err
+	}
+	if claims.ExpiresAt != nil && now.Add(-jwtClockSkew).Unix() > *claims.ExpiresAt {
+		return nil, errTokenExpired
+	}
+	if claims.NotBefore != nil && now.Add(jwtClockSkew).Unix() < *claims.NotBefore {
+		return nil, errTokenNotValidYet
+	}
+	if claims.IssuedAt != nil && now.Add(jwtClockSkew).Unix() < *claims.IssuedAt {
+		return nil, errTokenNotValidYet
+	}
+	return claims, nil
+}
+
+// decodeJWTSegment decodes a base64url encoded JSON token segment into v.
+func decodeJWTSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return errMalformedToken
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errMalformedToken
+	}
+	return nil
+}
+
+// rpcAuth authenticates HTTP and WebSocket RPC requests with JSON web tokens
+// and records rejected requests in the audit log.
+type rpcAuth struct {
+	secret []byte
+	audit  *auditLog
+}
+
+// newRPCAuth creates the request authenticator, returning nil if no secret
+// file is configured.
+func newRPCAuth(secretPath, auditPath string) (*rpcAuth, error) {
+	if secretPath == "" {
+		return nil, nil
+	}
+	secret, err := loadJWTSecret(secretPath)
+	if err != nil {
+		return nil, err
+	}
+	audit, err := newAuditLog(auditPath)
+	if err != nil {
+		return nil, err
+	}
+	return &rpcAuth{secret: secret, audit: audit}, nil
+}
+
+// wrap returns a handler that authenticates requests before passing them on
+// to next. A nil authenticator returns next unchanged.
+func (a *rpcAuth) wrap(next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// CORS preflight requests never carry credentials
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		claims, err := a.authenticate(r)
+		if err != nil {
+			a.audit.reject(r.RemoteAddr, "", "", err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		ctx := r.Context()
+		if claims.Subject != "" {
+			ctx = rpc.WithClientID(ctx, claims.Subject)
+		}
+		if claims.Namespaces != nil {
+			acl := &namespaceACL{
+				allowed: make(map[string]struct{}, len(claims.Namespaces)),
+				subject: claims.Subject,
+				remote:  r.RemoteAddr,
+				audit:   a.audit,
+			}
+			for _, ns := range claims.Namespaces {
+				acl.allowed[ns] = struct{}{}
+			}
+			ctx = rpc.WithAuthorizer(ctx, acl)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authenticate extracts and verifies the bearer token of a request.
+func (a *rpcAuth) authenticate(r *http.Request) (*jwtClaims, error) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil, errMissingToken
+	}
+	return parseJWT(a.secret, strings.TrimPrefix(auth, "Bearer "), time.Now())
+}
+
+// close releases the resources held by the authenticator.
+func (a *rpcAuth) close() {
+	if a != nil {
+		a.audit.close()
+	}
+}
+
+// requireNamespace returns a handler rejecting the requests whose token doesn't
+// allow the given namespace before passing them on to next. The method is only
+// used to report the rejected requests.
+func requireNamespace(namespace, method string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := rpc.Authorize(r.Context(), namespace, method); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// namespaceACL is the rpc.Authorizer restricting a token to its namespaces.
+type namespaceACL struct {
+	allowed map[string]struct{}
+	subject string
+	remote  string
+	audit   *auditLog
+}
+
+// Authorize implements rpc.Authorizer.
+func (acl *namespaceACL) Authorize(namespace, method string) error {
+	if _, ok := acl.allowed[namespace]; ok {
+		return nil
+	}
+	acl.audit.reject(acl.remote, acl.subject, method, errNamespaceDenied)
+	return errNamespaceDenied
+}
+
+// auditLog records rejected RPC requests in the regular log and, if a file is
+// configured, as JSON lines in that file.
+type auditLog struct {
+	lock sync.Mutex
+	out  io.WriteCloser
+}
+
+// auditEntry is a single line of the audit log file.
+type auditEntry struct {
+	Time    time.Time `json:"time"`
+	Remote  string    `json:"remote"`
+	Subject string    `json:"subject,omitempty"`
+	Method  string    `json:"method,omitempty"`
+	Reason  string    `json:"reason"`
+}
+
+func newAuditLog(path string) (*auditLog, error) {
+	if path == "" {
+		return &auditLog{}, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{out: out}, nil
+}
+
+// reject records a rejected request.
+func (l *auditLog) reject(remote, subject, method string, reason error) {
+	log.Warn("Rejected RPC request", "remote", remote, "subject", subject, "method", method, "reason", reason)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.out == nil {
+		return
+	}
+	entry, err := json.Marshal(&auditEntry{
+		Time:    time.Now().UTC(),
+		Remote:  remote,
+		Subject: subject,
+		Method:  method,
+		Reason:  reason.Error(),
+	})
+	if err != nil {
+		return
+	}
+	if _, err := l.out.Write(append(entry, '\n')); err != nil {
+		log.Error("Failed to write RPC audit log", "err", err)
+	}
+}
+
+func (l *auditLog) close() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.out != nil {
+		l.out.Close()
+		l.out = nil
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/rpc"
+)
+
+// signJWT creates a token with the given header algorithm and claims.
+func signJWT(secret []byte, alg string, claims interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestParseJWT(t *testing.T) {
+	var (
+		secret = bytes.Repeat([]byte{0x01}, jwtSecretLength)
+		now    = time.Unix(1600000000, 0)
+		past   = now.Add(-time.Hour).Unix()
+		future = now.Add(time.Hour).Unix()
+	)
+	tests := []struct {
+		token string
+		err   error
+	}{
+		{signJWT(secret, "HS256", jwtClaims{}), nil},
+		{signJWT(secret, "HS256", jwtClaims{IssuedAt: &past, ExpiresAt: &future}), nil},
+		{signJWT(secret, "HS256", jwtClaims{ExpiresAt: &past}), errTokenExpired},
+		{signJWT(secret, "HS256", jwtClaims{NotBefore: &future}), errTokenNotValidYet},
+		{signJWT(secret, "HS256", jwtClaims{IssuedAt: &future}), errTokenNotValidYet},
+		{signJWT(secret, "HS512", jwtClaims{}), errUnsupportedAlg},
+		{signJWT(bytes.Repeat([]byte{0x02}, jwtSecretLength), "HS256", jwtClaims{}), errInvalidSignature},
+		{"foo.bar", errMalformedToken},
+		{"!!!.bar.baz", errMalformedToken},
+	}
+	for i, test := range tests {
+		if _, err := parseJWT(secret, test.token, now); err != test.err {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, test.err)
+		}
+	}
+}
+
+func TestLoadJWTSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwt-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "jwtsecret")
+	generated, err := loadJWTSecret(path)
+	if err != nil {
+		t.Fatalf("failed to generate secret: %v", err)
+	}
+	loaded, err := loadJWTSecret(path)
+	if err != nil {
+		t.Fatalf("failed to load secret: %v", err)
+	}
+	if !bytes.Equal(generated, loaded) {
+		t.Fatalf("secret mismatch: generated %x, loaded %x", generated, loaded)
+	}
+	ioutil.WriteFile(path, []byte("0x1234"), 0600)
+	if _, err := loadJWTSecret(path); err == nil {
+		t.Fatal("short secret accepted")
+	}
+}
+
+type testRPCService struct{}
+
+func (testRPCService) Hello() string { return "hello" }
+
+// Tests that the authenticating handler rejects requests without a valid token,
+// enforces the namespaces of the token and records rejections.
+func TestRPCAuthHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwt-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	auditPath := filepath.Join(dir, "audit.log")
+	auth, err := newRPCAuth(filepath.Join(dir, "jwtsecret"), auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auth.close()
+
+	srv := rpc.NewServer()
+	srv.RegisterName("test", testRPCService{})
+	srv.RegisterName("admin", testRPCService{})
+	defer srv.Stop()
+	ts := httptest.NewServer(auth.wrap(srv))
+	defer ts.Close()
+
+	call := func(token, method string) (int, string) {
+		body := `{"jsonrpc":"2.0","id":1,"method":"` + method + `","params":[]}`
+		req, _ := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		blob, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(blob)
+	}
+	// Requests without or with a badly signed token are refused
+	if code, _ := call("", "test_hello"); code != http.StatusUnauthorized {
+		t.Errorf("missing token: status mismatch: have %d, want %d", code, http.StatusUnauthorized)
+	}
+	forged := signJWT(bytes.Repeat([]byte{0xff}, jwtSecretLength), "HS256", jwtClaims{})
+	if code, _ := call(forged, "test_hello"); code != http.StatusUnauthorized {
+		t.Errorf("forged token: status mismatch: have %d, want %d", code, http.StatusUnauthorized)
+	}
+	// Tokens without namespaces may call everything
+	full := signJWT(auth.secret, "HS256", jwtClaims{Subject: "ops"})
+	if _, body := call(full, "admin_hello"); !strings.Contains(body, `"result":"hello"`) {
+		t.Errorf("unrestricted token: unexpected response %s", body)
+	}
+	// Restricted tokens may only call their namespaces
+	limited := signJWT(auth.secret, "HS256", jwtClaims{Subject: "explorer", Namespaces: []string{"test"}})
+	if _, body := call(limited, "test_hello"); !strings.Contains(body, `"result":"hello"`) {
+		t.Errorf("restricted token, allowed namespace: unexpected response %s", body)
+	}
+	if _, body := call(limited, "admin_hello"); !strings.Contains(body, `"code":-32001`) {
+		t.Errorf("restricted token, denied namespace: unexpected response %s", body)
+	}
+	// All three rejections must be in the audit log
+	blob, err := ioutil.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("failed to read audit log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(blob)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("audit entry count mismatch: have %d, want 3", len(lines))
+	}
+	var entry auditEntry
+	if err := json.Unmarshal([]byte(lines[2]), &entry); err != nil {
+		t.Fatalf("invalid audit entry: %v", err)
+	}
+	if entry.Subject != "explorer" || entry.Method != "admin_hello" {
+		t.Errorf("audit entry mismatch: have %+v", entry)
+	}
+}
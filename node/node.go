@@ -67,11 +67,15 @@ type Node struct {
 	httpServer       *http.Server // HTTP RPC HTTP server
 	httpHandler      *rpc.Server  // HTTP RPC request handler to process the API requests
 
+	httpHandlers map[string]*httpHandler // Service handlers served on the HTTP RPC endpoint, by path
+
 	wsEndpoint     string       // WebSocket endpoint (interface + port) to listen at (empty = WebSocket disabled)
 	wsListenerAddr net.Addr     // Address of WebSocket RPC listener socket serving API requests
 	wsHTTPServer   *http.Server // WebSocket RPC HTTP server
 	wsHandler      *rpc.Server  // WebSocket RPC request handler to process the API requests
 
+	rpcAuth *rpcAuth // JWT authenticator of the HTTP and WebSocket endpoints (nil = disabled)
+
 	stop chan struct{} // Channel to wait for termination notifications
 	lock sync.RWMutex
 
@@ -195,11 +199,13 @@ func (n *Node) Start() error {
 
 	// Otherwise copy and specialize the P2P configuration
 	services := make(map[reflect.Type]Service)
+	n.httpHandlers = make(map[string]*httpHandler)
 	for _, constructor := range n.serviceFuncs {
 		// Create a new context for the particular service
 		ctx := &ServiceContext{
 			Config:         *n.config,
 			services:       make(map[reflect.Type]Service),
+			handlers:       n.httpHandlers,
 			EventMux:       n.eventmux,
 			AccountManager: n.accman,
 		}
@@ -287,17 +293,24 @@ func (n *Node) startRPC(services map[reflect.Type]Service) error {
 	for _, service := range services {
 		apis = append(apis, service.APIs()...)
 	}
+	// Set up request authentication for the network facing endpoints
+	if err := n.startAuth(); err != nil {
+		return err
+	}
 	// Start the various API endpoints, terminating all in case of errors
 	if err := n.startInProc(apis); err != nil {
+		n.stopAuth()
 		return err
 	}
 	if err := n.startIPC(apis); err != nil {
 		n.stopInProc()
+		n.stopAuth()
 		return err
 	}
 	if err := n.startHTTP(n.httpEndpoint, apis, n.config.HTTPModules, n.config.HTTPCors, n.config.HTTPVirtualHosts, n.config.HTTPTimeouts, n.config.WSOrigins); err != nil {
 		n.stopIPC()
 		n.stopInProc()
+		n.stopAuth()
 		return err
 	}
 	// if endpoints are not the same, start separate servers
@@ -306,6 +319,7 @@ func (n *Node) startRPC(services map[reflect.Type]Service) error {
 			n.stopHTTP()
 			n.stopIPC()
 			n.stopInProc()
+			n.stopAuth()
 			return err
 		}
 	}
@@ -315,6 +329,37 @@ func (n *Node) startRPC(services map[reflect.Type]Service) error {
 	return nil
 }
 
+// startAuth loads the JWT secret and opens the audit log if request
+// authentication is enabled.
+func (n *Node) startAuth() error {
+	if n.config.JWTSecret == "" {
+		return nil
+	}
+	secret := n.config.ResolvePath(n.config.JWTSecret)
+	if secret == "" {
+		return fmt.Errorf("cannot resolve JWT secret path %q without a data directory", n.config.JWTSecret)
+	}
+	var audit string
+	if n.config.RPCAuditLog != "" {
+		if audit = n.config.ResolvePath(n.config.RPCAuditLog); audit == "" {
+			return fmt.Errorf("cannot resolve RPC audit log path %q without a data directory", n.config.RPCAuditLog)
+		}
+	}
+	auth, err := newRPCAuth(secret, audit)
+	if err != nil {
+		return err
+	}
+	n.rpcAuth = auth
+	n.log.Info("RPC authentication enabled", "secret", n.config.JWTSecret, "audit", audit)
+	return nil
+}
+
+// stopAuth releases the resources of the request authenticator.
+func (n *Node) stopAuth() {
+	n.rpcAuth.close()
+	n.rpcAuth = nil
+}
+
 // startInProc initializes an in-process RPC endpoint.
 func (n *Node) startInProc(apis []rpc.API) error {
 	// Register all the APIs exposed by the services
@@ -374,14 +419,28 @@ func (n *Node) startHTTP(endpoint string, apis []rpc.API, modules []string, cors
 	}
 	// register apis and create handler stack
 	srv := rpc.NewServer()
+	srv.SetLimits(n.config.RPCLimits)
 	err := RegisterApisFromWhitelist(apis, modules, srv, false)
 	if err != nil {
 		return err
 	}
-	handler := NewHTTPHandlerStack(srv, cors, vhosts)
+	handler := NewHTTPHandlerStack(n.rpcAuth.wrap(srv), cors, vhosts)
 	// wrap handler in WebSocket handler only if WebSocket port is the same as http rpc
 	if n.httpEndpoint == n.wsEndpoint {
-		handler = NewWebsocketUpgradeHandler(handler, srv.WebsocketHandler(wsOrigins))
+		handler = NewWebsocketUpgradeHandler(handler, n.rpcAuth.wrap(srv.WebsocketHandler(wsOrigins)))
+	}
+	// mount the service handlers next to the API behind the same auth and limits
+	if len(n.httpHandlers) > 0 {
+		mux := http.NewServeMux()
+		mux.Handle("/", handler)
+		for path, h := range n.httpHandlers {
+			stack := NewHTTPHandlerStack(n.rpcAuth.wrap(requireNamespace(h.namespace, path, srv.LimitHandler(h.handler))), h.cors, h.vhosts)
+			mux.Handle(path, stack)
+			if !strings.HasSuffix(path, "/") {
+				mux.Handle(path+"/", stack)
+			}
+		}
+		handler = mux
 	}
 	httpServer, addr, err := StartHTTPEndpoint(endpoint, timeouts, handler)
 	if err != nil {
@@ -393,6 +452,11 @@ func (n *Node) startHTTP(endpoint string, apis []rpc.API, modules []string, cors
 	if n.httpEndpoint == n.wsEndpoint {
 		n.log.Info("WebSocket endpoint opened", "url", fmt.Sprintf("ws://%v", addr))
 	}
+	for path, h := range n.httpHandlers {
+		n.log.Info(h.name+" endpoint opened", "url", fmt.Sprintf("http://%v%s", addr, path),
+			"cors", strings.Join(h.cors, ","),
+			"vhosts", strings.Join(h.vhosts, ","))
+	}
 	// All listeners booted successfully
 	n.httpEndpoint = endpoint
 	n.httpListenerAddr = addr
@@ -423,7 +487,8 @@ func (n *Node) startWS(endpoint string, apis []rpc.API, modules []string, wsOrig
 	}
 
 	srv := rpc.NewServer()
-	handler := srv.WebsocketHandler(wsOrigins)
+	srv.SetLimits(n.config.RPCLimits)
+	handler := n.rpcAuth.wrap(srv.WebsocketHandler(wsOrigins))
 	err := RegisterApisFromWhitelist(apis, modules, srv, exposeAll)
 	if err != nil {
 		return err
@@ -470,6 +535,7 @@ func (n *Node) Stop() error {
 	n.stopWS()
 	n.stopHTTP()
 	n.stopIPC()
+	n.stopAuth()
 	n.rpcAPIs = nil
 	failure := &StopError{
 		Services: make(map[reflect.Type]error),
@@ -641,7 +707,13 @@ func (n *Node) OpenDatabase(name string, cache, handles int, namespace string) (
 	if n.config.DataDir == "" {
 		return rawdb.NewMemoryDatabase(), nil
 	}
-	return rawdb.NewLevelDBDatabase(n.config.ResolvePath(name), cache, handles, namespace)
+	return rawdb.Open(rawdb.OpenOptions{
+		Type:      n.config.DBEngine,
+		Directory: n.config.ResolvePath(name),
+		Namespace: namespace,
+		Cache:     cache,
+		Handles:   handles,
+	})
 }
 
 // OpenDatabaseWithFreezer opens an existing database with the given name (or
@@ -661,7 +733,14 @@ func (n *Node) OpenDatabaseWithFreezer(name string, cache, handles int, freezer,
 	case !filepath.IsAbs(freezer):
 		freezer = n.config.ResolvePath(freezer)
 	}
-	return rawdb.NewLevelDBDatabaseWithFreezer(root, cache, handles, freezer, namespace)
+	return rawdb.Open(rawdb.OpenOptions{
+		Type:              n.config.DBEngine,
+		Directory:         root,
+		AncientsDirectory: freezer,
+		Namespace:         namespace,
+		Cache:             cache,
+		Handles:           handles,
+	})
 }
 
 // ResolvePath returns the absolute path of a resource in the instance directory.
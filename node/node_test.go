@@ -22,6 +22,7 @@ import (
 	"net/http"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -632,6 +633,67 @@ func TestWebsocketHTTPOnSamePort_HTTPRequest(t *testing.T) {
 	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
 }
 
+// Tests that handlers registered by services are served on the HTTP endpoint
+// behind its authentication and request limits.
+func TestRegisterHTTPHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary data directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := testNodeConfig()
+	conf.DataDir = dir
+	conf.HTTPHost = "127.0.0.1"
+	conf.HTTPPort = 0
+	conf.HTTPVirtualHosts = []string{"*"}
+	conf.JWTSecret = "jwtsecret"
+	conf.RPCLimits = rpc.Limits{RequestRate: 0.001, RequestBurst: 1}
+	stack, err := New(conf)
+	if err != nil {
+		t.Fatalf("failed to create protocol stack: %v", err)
+	}
+	hello := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("hello")) })
+	stack.Register(func(ctx *ServiceContext) (Service, error) {
+		if err := ctx.RegisterHTTPHandler("Test", "/test", "ctxc", hello, nil, []string{"*"}); err != nil {
+			return nil, err
+		}
+		if err := ctx.RegisterHTTPHandler("Other", "/test", "ctxc", hello, nil, nil); err == nil {
+			t.Error("duplicate handler path accepted")
+		}
+		return new(NoopService), nil
+	})
+	if err := stack.Start(); err != nil {
+		t.Fatalf("failed to start protocol stack: %v", err)
+	}
+	defer stack.Stop()
+
+	get := func(token string) (int, string) {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+stack.HTTPEndpoint()+"/test", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp := doHTTPRequest(t, req)
+		defer resp.Body.Close()
+		blob, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(blob)
+	}
+	if code, _ := get(""); code != http.StatusUnauthorized {
+		t.Errorf("missing token: status mismatch: have %d, want %d", code, http.StatusUnauthorized)
+	}
+	restricted := signJWT(stack.rpcAuth.secret, "HS256", jwtClaims{Subject: "restricted", Namespaces: []string{"net", "web3"}})
+	if code, _ := get(restricted); code != http.StatusForbidden {
+		t.Errorf("token without namespace: status mismatch: have %d, want %d", code, http.StatusForbidden)
+	}
+	token := signJWT(stack.rpcAuth.secret, "HS256", jwtClaims{Subject: "test", Namespaces: []string{"ctxc"}})
+	if code, body := get(token); code != http.StatusOK || body != "hello" {
+		t.Errorf("valid token: unexpected response %d %q", code, body)
+	}
+	if code, body := get(token); code != http.StatusTooManyRequests || !strings.Contains(body, "rate limit") {
+		t.Errorf("throttled request: unexpected response %d %q", code, body)
+	}
+}
+
 func startHTTP(t *testing.T) *Node {
 	conf := &Config{HTTPPort: 7453, WSPort: 7453}
 	node, err := New(conf)
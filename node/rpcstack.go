@@ -29,6 +29,16 @@ import (
 	"github.com/rs/cors"
 )
 
+// httpHandler is an HTTP handler a service serves on the HTTP RPC endpoint.
+type httpHandler struct {
+	name      string
+	path      string
+	namespace string // API namespace a restricted token must allow to access the handler
+	handler   http.Handler
+	cors      []string
+	vhosts    []string
+}
+
 // NewHTTPHandlerStack returns wrapped http-related handlers
 func NewHTTPHandlerStack(srv http.Handler, cors []string, vhosts []string) http.Handler {
 	// Wrap the CORS-handler within a host-handler
@@ -17,6 +17,8 @@
 package node
 
 import (
+	"fmt"
+	"net/http"
 	"path/filepath"
 	"reflect"
 
@@ -33,6 +35,7 @@ import (
 // as well as utility methods to operate on the service environment.
 type ServiceContext struct {
 	services       map[reflect.Type]Service // Index of the already constructed services
+	handlers       map[string]*httpHandler  // HTTP handlers mounted on the RPC endpoint, by path
 	Config         Config
 	EventMux       *event.TypeMux    // Event multiplexer used for decoupled notifications
 	AccountManager *accounts.Manager // Account manager created by the node.
@@ -45,7 +48,13 @@ func (ctx *ServiceContext) OpenDatabase(name string, cache int, handles int, nam
 	if ctx.Config.DataDir == "" {
 		return rawdb.NewMemoryDatabase(), nil
 	}
-	return rawdb.NewLevelDBDatabase(ctx.Config.ResolvePath(name), cache, handles, namespace)
+	return rawdb.Open(rawdb.OpenOptions{
+		Type:      ctx.Config.DBEngine,
+		Directory: ctx.Config.ResolvePath(name),
+		Namespace: namespace,
+		Cache:     cache,
+		Handles:   handles,
+	})
 }
 
 // OpenDatabaseWithFreezer opens an existing database with the given name (or
@@ -65,7 +74,14 @@ func (ctx *ServiceContext) OpenDatabaseWithFreezer(name string, cache int, handl
 	case !filepath.IsAbs(freezer):
 		freezer = ctx.Config.ResolvePath(freezer)
 	}
-	return rawdb.NewLevelDBDatabaseWithFreezer(root, cache, handles, freezer, namespace)
+	return rawdb.Open(rawdb.OpenOptions{
+		Type:              ctx.Config.DBEngine,
+		Directory:         root,
+		AncientsDirectory: freezer,
+		Namespace:         namespace,
+		Cache:             cache,
+		Handles:           handles,
+	})
 }
 
 // ResolvePath resolves a user path into the data directory if that was relative
@@ -85,6 +101,25 @@ func (ctx *ServiceContext) Service(service interface{}) error {
 	return ErrServiceUnknown
 }
 
+// RegisterHTTPHandler mounts handler at path on the HTTP RPC endpoint of the
+// node, behind the same authentication and request limits as the RPC API.
+// Tokens restricted to a set of namespaces must allow the given one to access
+// the handler, and requests are checked against the given CORS domains and
+// virtual hosts. The handler is not served if the HTTP endpoint is disabled.
+func (ctx *ServiceContext) RegisterHTTPHandler(name, path, namespace string, handler http.Handler, cors, vhosts []string) error {
+	if ctx.handlers == nil {
+		return fmt.Errorf("cannot register %s handler outside of a node", name)
+	}
+	if path == "" || path == "/" || path[0] != '/' {
+		return fmt.Errorf("invalid %s handler path %q", name, path)
+	}
+	if prev, ok := ctx.handlers[path]; ok {
+		return fmt.Errorf("%s handler path %q already taken by %s", name, path, prev.name)
+	}
+	ctx.handlers[path] = &httpHandler{name: name, path: path, namespace: namespace, handler: handler, cors: cors, vhosts: vhosts}
+	return nil
+}
+
 // ExtRPCEnabled returns the indicator whether node enables the external
 // RPC(http, ws or graphql).
 func (ctx *ServiceContext) ExtRPCEnabled() bool {
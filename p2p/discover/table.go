@@ -434,6 +434,36 @@ func (tab *Table) len() (n int) {
 	return n
 }
 
+// TableStats is a snapshot of the health of a node table.
+type TableStats struct {
+	Nodes        int  `json:"nodes"`        // Entries across all buckets
+	Live         int  `json:"live"`         // Entries which answered at least one revalidation
+	Buckets      int  `json:"buckets"`      // Buckets holding at least one entry
+	Replacements int  `json:"replacements"` // Entries waiting in the replacement lists
+	Seeded       bool `json:"seeded"`       // Whether the initial table fill has completed
+}
+
+// stats returns a snapshot of the table contents.
+func (tab *Table) stats() *TableStats {
+	tab.mutex.Lock()
+	defer tab.mutex.Unlock()
+
+	stats := &TableStats{Seeded: tab.isInitDone()}
+	for _, b := range &tab.buckets {
+		if len(b.entries) > 0 {
+			stats.Buckets++
+		}
+		for _, n := range b.entries {
+			if n.livenessChecks > 0 {
+				stats.Live++
+			}
+		}
+		stats.Nodes += len(b.entries)
+		stats.Replacements += len(b.replacements)
+	}
+	return stats
+}
+
 // bucketLen returns the number of nodes in the bucket for the given ID.
 func (tab *Table) bucketLen(id enode.ID) int {
 	tab.mutex.Lock()
@@ -368,6 +368,37 @@ func TestTable_addSeenNode(t *testing.T) {
 	checkIPLimitInvariant(t, tab)
 }
 
+func TestTable_stats(t *testing.T) {
+	tab, db := newTestTable(newPingRecorder())
+	<-tab.initDone
+	defer db.Close()
+	defer tab.close()
+
+	// Insert three nodes into two buckets, one of them revalidated.
+	n1 := nodeAtDistance(tab.self().ID(), 256, net.IP{88, 77, 66, 1})
+	n2 := nodeAtDistance(tab.self().ID(), 256, net.IP{88, 77, 66, 2})
+	n3 := nodeAtDistance(tab.self().ID(), 255, net.IP{88, 77, 66, 3})
+	n1.livenessChecks = 1
+	tab.addSeenNode(n1)
+	tab.addSeenNode(n2)
+	tab.addSeenNode(n3)
+
+	// Overflow the second bucket to populate its replacement list.
+	fillBucket(tab, n3)
+	tab.addSeenNode(nodeAtDistance(tab.self().ID(), 255, net.IP{88, 77, 66, 4}))
+
+	want := &TableStats{
+		Nodes:        bucketSize + 2,
+		Live:         1,
+		Buckets:      2,
+		Replacements: 1,
+		Seeded:       true,
+	}
+	if have := tab.stats(); !reflect.DeepEqual(have, want) {
+		t.Fatalf("wrong stats: have %+v, want %+v", have, want)
+	}
+}
+
 // This test checks that ENR updates happen during revalidation. If a node in the table
 // announces a new sequence number, the new record should be pulled.
 func TestTable_revalidateSyncRecord(t *testing.T) {
@@ -171,6 +171,11 @@ func (t *UDPv4) Close() {
 	})
 }
 
+// TableStats returns a snapshot of the health of the local table.
+func (t *UDPv4) TableStats() *TableStats {
+	return t.tab.stats()
+}
+
 // Resolve searches for a specific node with the given ID and tries to get the most recent
 // version of the node record for it. It returns n if the node could not be resolved.
 func (t *UDPv4) Resolve(n *enode.Node) *enode.Node {
@@ -224,6 +224,11 @@ func (t *UDPv5) AllNodes() []*enode.Node {
 	return nodes
 }
 
+// TableStats returns a snapshot of the health of the local table.
+func (t *UDPv5) TableStats() *TableStats {
+	return t.tab.stats()
+}
+
 // LocalNode returns the current local node running the
 // protocol.
 func (t *UDPv5) LocalNode() *enode.LocalNode {
@@ -130,7 +130,8 @@ func (f *filterIter) Next() bool {
 // The distribution of nodes returned by Next is approximately fair, i.e. FairMix
 // attempts to draw from all sources equally often. However, if a certain source is slow
 // and doesn't return a node within the configured timeout, a node from any other source
-// will be returned.
+// will be returned. Sources added with AddWeightedSource are drawn from proportionally
+// to their weight.
 //
 // It's safe to call AddSource and Close concurrently with Next.
 type FairMix struct {
@@ -149,6 +150,8 @@ type mixSource struct {
 	it      Iterator
 	next    chan *Node
 	timeout time.Duration
+	weight  int // number of consecutive picks per round
+	picks   int // picks left in the current round
 }
 
 // NewFairMix creates a mixer.
@@ -168,14 +171,23 @@ func NewFairMix(timeout time.Duration) *FairMix {
 
 // AddSource adds a source of nodes.
 func (m *FairMix) AddSource(it Iterator) {
+	m.AddWeightedSource(it, 1)
+}
+
+// AddWeightedSource adds a source of nodes which is drawn from weight times as
+// often as a source added with AddSource. Weights below one are treated as one.
+func (m *FairMix) AddWeightedSource(it Iterator, weight int) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	if m.closed == nil {
 		return
 	}
+	if weight < 1 {
+		weight = 1
+	}
 	m.wg.Add(1)
-	source := &mixSource{it, make(chan *Node), m.timeout}
+	source := &mixSource{it: it, next: make(chan *Node), timeout: m.timeout, weight: weight}
 	m.sources = append(m.sources, source)
 	go m.runSource(m.closed, source)
 }
@@ -245,7 +257,8 @@ func (m *FairMix) nextFromAny() bool {
 	return ok
 }
 
-// pickSource chooses the next source to read from, cycling through them in order.
+// pickSource chooses the next source to read from, cycling through them in order
+// and staying on each source for as many picks as its weight.
 func (m *FairMix) pickSource() *mixSource {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -253,8 +266,16 @@ func (m *FairMix) pickSource() *mixSource {
 	if len(m.sources) == 0 {
 		return nil
 	}
+	if m.last < len(m.sources) {
+		if s := m.sources[m.last]; s.picks > 0 {
+			s.picks--
+			return s
+		}
+	}
 	m.last = (m.last + 1) % len(m.sources)
-	return m.sources[m.last]
+	s := m.sources[m.last]
+	s.picks = s.weight - 1
+	return s
 }
 
 // deleteSource deletes a source.
@@ -40,6 +40,7 @@ const (
 	dbVersionKey   = "version" // Version of the database to flush if changes
 	dbNodePrefix   = "n:"      // Identifier to prefix node entries with
 	dbLocalPrefix  = "local:"
+	dbBanPrefix    = "ban:" // Identifier to prefix ban records with, the full key is "ban:<ID>"
 	dbDiscoverRoot = "v4"
 	dbDiscv5Root   = "v5"
 
@@ -489,6 +490,82 @@ func nextNode(it iterator.Iterator) *Node {
 	return nil
 }
 
+// Ban is a record of a remote node being refused connections until a given
+// time, either by its ID or by the IP it was last seen on.
+type Ban struct {
+	ID    ID        // Identifier of the banned node
+	IP    net.IP    // IP the node was banned on, nil if unknown
+	Until time.Time // Time until which the ban is in effect
+	Count uint64    // Number of times the node was banned
+}
+
+// banRLP is the database encoding of a ban record.
+type banRLP struct {
+	IP    net.IP
+	Until uint64
+	Count uint64
+}
+
+// banKey returns the database key for a ban record.
+func banKey(id ID) []byte {
+	return append([]byte(dbBanPrefix), id[:]...)
+}
+
+// Ban retrieves the ban record of a node, or nil if it was never banned.
+func (db *DB) Ban(id ID) *Ban {
+	blob, err := db.lvl.Get(banKey(id), nil)
+	if err != nil {
+		return nil
+	}
+	return decodeBan(id, blob)
+}
+
+// decodeBan parses a stored ban record, returning nil if it's corrupted.
+func decodeBan(id ID, blob []byte) *Ban {
+	var enc banRLP
+	if err := rlp.DecodeBytes(blob, &enc); err != nil {
+		return nil
+	}
+	ban := &Ban{ID: id, Until: time.Unix(int64(enc.Until), 0), Count: enc.Count}
+	if len(enc.IP) > 0 {
+		ban.IP = enc.IP
+	}
+	return ban
+}
+
+// UpdateBan inserts - potentially overwriting - a ban record into the database.
+func (db *DB) UpdateBan(ban *Ban) error {
+	blob, err := rlp.EncodeToBytes(&banRLP{IP: ban.IP, Until: uint64(ban.Until.Unix()), Count: ban.Count})
+	if err != nil {
+		return err
+	}
+	return db.lvl.Put(banKey(ban.ID), blob, nil)
+}
+
+// DeleteBan removes the ban record of a node.
+func (db *DB) DeleteBan(id ID) error {
+	return db.lvl.Delete(banKey(id), nil)
+}
+
+// Bans retrieves all the ban records in the database, including expired ones.
+func (db *DB) Bans() []*Ban {
+	it := db.lvl.NewIterator(util.BytesPrefix([]byte(dbBanPrefix)), nil)
+	defer it.Release()
+
+	var bans []*Ban
+	for it.Next() {
+		var id ID
+		if len(it.Key()) != len(dbBanPrefix)+len(id) {
+			continue
+		}
+		copy(id[:], it.Key()[len(dbBanPrefix):])
+		if ban := decodeBan(id, it.Value()); ban != nil {
+			bans = append(bans, ban)
+		}
+	}
+	return bans
+}
+
 // close flushes and closes the database files.
 func (db *DB) Close() {
 	close(db.quit)
@@ -116,6 +116,9 @@ type Peer struct {
 
 	// events receives message send / receive events if set
 	events *event.Feed
+
+	// reputation tracks the conduct of the peer if set
+	reputation *reputation
 }
 
 // NewPeer returns a peer for testing purposes.
@@ -187,6 +190,22 @@ func (p *Peer) Disconnect(reason DiscReason) {
 	}
 }
 
+// Report records a conduct of the peer, adjusting its reputation. Peers whose
+// reputation drops too low are disconnected and banned for a while. Trusted
+// peers are scored, but never banned automatically.
+func (p *Peer) Report(b Behaviour) {
+	if p.reputation == nil {
+		return
+	}
+	var ip net.IP
+	if addr, ok := p.RemoteAddr().(*net.TCPAddr); ok {
+		ip = addr.IP
+	}
+	if p.reputation.report(p.ID(), ip, b, !p.rw.is(trustedConn)) {
+		p.Disconnect(DiscUselessPeer)
+	}
+}
+
 // String implements fmt.Stringer.
 func (p *Peer) String() string {
 	id := p.ID()
@@ -0,0 +1,362 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"math"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common/mclock"
+	"github.com/CortexFoundation/CortexTheseus/log"
+	"github.com/CortexFoundation/CortexTheseus/p2p/enode"
+)
+
+// Behaviour is a kind of conduct of a remote peer, affecting its reputation.
+type Behaviour uint8
+
+const (
+	UsefulResponse  Behaviour = iota // Peer delivered requested data
+	InvalidResponse                  // Peer sent a malformed or unexpected message
+	Timeout                          // Peer failed to deliver requested data in time
+	BadBlock                         // Peer sent a block or chain failing validation
+	InvalidSeal                      // Peer sent a block with an invalid proof-of-work
+	SpamTransaction                  // Peer relayed transactions rejected by the pool
+)
+
+var behaviourNames = [...]string{
+	UsefulResponse:  "useful response",
+	InvalidResponse: "invalid response",
+	Timeout:         "timeout",
+	BadBlock:        "bad block",
+	InvalidSeal:     "invalid seal",
+	SpamTransaction: "spam transaction",
+}
+
+// behaviourScores is the reputation change caused by each kind of conduct.
+var behaviourScores = [...]float64{
+	UsefulResponse:  1,
+	InvalidResponse: -20,
+	Timeout:         -10,
+	BadBlock:        -50,
+	InvalidSeal:     -200,
+	SpamTransaction: -5,
+}
+
+func (b Behaviour) String() string {
+	if int(b) < len(behaviourNames) {
+		return behaviourNames[b]
+	}
+	return "unknown behaviour"
+}
+
+const (
+	// maxReputation caps the score a peer can build up, so that a long useful
+	// history doesn't shield it from a ban once it starts misbehaving.
+	maxReputation = 50
+
+	// banThreshold is the score at which a peer gets banned.
+	banThreshold = -100
+
+	// reputationHalfLife is the time it takes for a score to decay halfway back
+	// to neutral.
+	reputationHalfLife = 10 * time.Minute
+
+	// maxTrackedPeers is the number of peer scores above which the ones decayed
+	// to neutral are forgotten.
+	maxTrackedPeers = 1024
+
+	// baseBanDuration is the length of the first automatic ban of a node, each
+	// subsequent one lasting twice as long as the previous.
+	baseBanDuration = 10 * time.Minute
+
+	// maxBanDuration caps the length of automatic bans.
+	maxBanDuration = 7 * 24 * time.Hour
+
+	// banForgetTime is the time after the expiry of a ban when it's forgotten,
+	// resetting the backoff of future bans.
+	banForgetTime = 7 * 24 * time.Hour
+)
+
+// PeerScore is the reputation of a remote node, as reported by the admin API.
+type PeerScore struct {
+	ID          string            `json:"id"`                    // Unique node identifier
+	IP          string            `json:"ip,omitempty"`          // IP address the node was last seen on
+	Score       float64           `json:"score"`                 // Current reputation, decaying towards zero
+	Reports     map[string]uint64 `json:"reports,omitempty"`     // Number of reports per kind of conduct
+	Bans        uint64            `json:"bans"`                  // Number of times the node was banned
+	BannedUntil *time.Time        `json:"bannedUntil,omitempty"` // Expiry of the active ban, if any
+}
+
+// peerReputation is the score of a single remote node.
+type peerReputation struct {
+	score   float64              // Score as of the last update
+	updated mclock.AbsTime       // Time of the last update, to decay from
+	ip      net.IP               // IP the node was last seen on
+	reports map[Behaviour]uint64 // Number of reports per kind of conduct
+}
+
+// reputation tracks the conduct of remote nodes, banning the misbehaving ones
+// for an exponentially increasing time. Bans are persisted in the node database
+// and survive restarts, scores are kept in memory only.
+type reputation struct {
+	db    *enode.DB
+	clock mclock.Clock     // Clock to decay scores with
+	now   func() time.Time // Wall clock to time bans with, persisted across restarts
+
+	peers map[enode.ID]*peerReputation
+	bans  map[enode.ID]*enode.Ban
+	ips   map[string]map[enode.ID]*enode.Ban // Bans indexed by the IP they cover
+	lock  sync.Mutex
+}
+
+// newReputation creates a reputation tracker, loading the bans stored in the
+// node database.
+func newReputation(db *enode.DB, clock mclock.Clock) *reputation {
+	r := &reputation{
+		db:    db,
+		clock: clock,
+		now:   time.Now,
+		peers: make(map[enode.ID]*peerReputation),
+		bans:  make(map[enode.ID]*enode.Ban),
+		ips:   make(map[string]map[enode.ID]*enode.Ban),
+	}
+	for _, ban := range db.Bans() {
+		r.addBan(ban)
+	}
+	r.pruneBans()
+	return r
+}
+
+// addBan tracks a ban, indexing it by its IP. The lock must be held by the
+// caller.
+func (r *reputation) addBan(ban *enode.Ban) {
+	r.bans[ban.ID] = ban
+	if ban.IP != nil {
+		ip := ban.IP.String()
+		if r.ips[ip] == nil {
+			r.ips[ip] = make(map[enode.ID]*enode.Ban)
+		}
+		r.ips[ip][ban.ID] = ban
+	}
+}
+
+// removeBan stops tracking the ban of a node, returning it. The lock must be
+// held by the caller.
+func (r *reputation) removeBan(id enode.ID) *enode.Ban {
+	ban := r.bans[id]
+	if ban == nil {
+		return nil
+	}
+	delete(r.bans, id)
+	if ban.IP != nil {
+		ip := ban.IP.String()
+		if delete(r.ips[ip], id); len(r.ips[ip]) == 0 {
+			delete(r.ips, ip)
+		}
+	}
+	return ban
+}
+
+// pruneBans forgets the bans expired for longer than banForgetTime, resetting
+// the backoff of the respective nodes. The lock must be held by the caller.
+func (r *reputation) pruneBans() {
+	now := r.now()
+	for id, ban := range r.bans {
+		if now.Sub(ban.Until) <= banForgetTime {
+			continue
+		}
+		r.removeBan(id)
+		if err := r.db.DeleteBan(id); err != nil {
+			log.Warn("Failed to delete peer ban", "id", id, "err", err)
+		}
+	}
+}
+
+// peer retrieves the score of a node, decayed to the current time.
+func (r *reputation) peer(id enode.ID) *peerReputation {
+	now := r.clock.Now()
+
+	p := r.peers[id]
+	if p == nil {
+		p = &peerReputation{updated: now, reports: make(map[Behaviour]uint64)}
+		r.peers[id] = p
+	}
+	if elapsed := time.Duration(now - p.updated); elapsed > 0 {
+		p.score *= math.Pow(0.5, float64(elapsed)/float64(reputationHalfLife))
+		p.updated = now
+	}
+	return p
+}
+
+// report records a conduct of a node, returning whether the node got banned as
+// a result. Nodes not bannable are scored, but never banned automatically.
+func (r *reputation) report(id enode.ID, ip net.IP, b Behaviour, bannable bool) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if len(r.peers) >= maxTrackedPeers {
+		r.prune()
+	}
+	p := r.peer(id)
+	if ip != nil {
+		p.ip = ip
+	}
+	p.reports[b]++
+	p.score = math.Min(p.score+behaviourScores[b], maxReputation)
+	if p.score > banThreshold || !bannable {
+		return false
+	}
+	p.score = 0
+
+	ban := r.ban(id, ip, 0)
+	log.Debug("Banned misbehaving peer", "id", id, "ip", ban.IP, "behaviour", b, "count", ban.Count, "until", ban.Until)
+	return true
+}
+
+// prune forgets the scores that decayed back to neutral, along with the bans
+// long expired.
+func (r *reputation) prune() {
+	for id := range r.peers {
+		if p := r.peer(id); math.Abs(p.score) < 1 {
+			delete(r.peers, id)
+		}
+	}
+	r.pruneBans()
+}
+
+// ban refuses connections from a node until the given duration passes. A zero
+// duration bans the node for twice as long as its previous ban. The lock must
+// be held by the caller.
+func (r *reputation) ban(id enode.ID, ip net.IP, duration time.Duration) *enode.Ban {
+	r.pruneBans()
+
+	ban := r.removeBan(id)
+	if ban == nil {
+		ban = &enode.Ban{ID: id}
+	}
+	if ip == nil {
+		if p := r.peers[id]; p != nil {
+			ip = p.ip
+		}
+	}
+	if ip != nil {
+		ban.IP = ip
+	}
+	ban.Count++
+	if duration == 0 {
+		duration = baseBanDuration
+		for i := uint64(1); i < ban.Count && duration < maxBanDuration; i++ {
+			duration *= 2
+		}
+		if duration > maxBanDuration {
+			duration = maxBanDuration
+		}
+	}
+	ban.Until = r.now().Add(duration)
+	r.addBan(ban)
+
+	if err := r.db.UpdateBan(ban); err != nil {
+		log.Warn("Failed to store peer ban", "id", id, "err", err)
+	}
+	return ban
+}
+
+// unban lifts the ban of a node and resets its score, returning whether it was
+// banned.
+func (r *reputation) unban(id enode.ID) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.peers, id)
+	ban := r.removeBan(id)
+	if ban == nil {
+		return false
+	}
+	if err := r.db.DeleteBan(id); err != nil {
+		log.Warn("Failed to delete peer ban", "id", id, "err", err)
+	}
+	return r.now().Before(ban.Until)
+}
+
+// banned returns whether connections from the node are currently refused.
+func (r *reputation) banned(id enode.ID) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	ban := r.bans[id]
+	return ban != nil && r.now().Before(ban.Until)
+}
+
+// bannedIP returns whether connections from the IP are currently refused.
+func (r *reputation) bannedIP(ip net.IP) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := r.now()
+	for _, ban := range r.ips[ip.String()] {
+		if now.Before(ban.Until) {
+			return true
+		}
+	}
+	return false
+}
+
+// scores retrieves the reputation of all tracked and banned nodes.
+func (r *reputation) scores() []*PeerScore {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var (
+		now    = r.now()
+		scores = make(map[enode.ID]*PeerScore)
+	)
+	for id := range r.peers {
+		p := r.peer(id)
+		score := &PeerScore{ID: id.String(), Score: p.score, Reports: make(map[string]uint64)}
+		if p.ip != nil {
+			score.IP = p.ip.String()
+		}
+		for b, count := range p.reports {
+			score.Reports[b.String()] = count
+		}
+		scores[id] = score
+	}
+	for id, ban := range r.bans {
+		score := scores[id]
+		if score == nil {
+			score = &PeerScore{ID: id.String()}
+			scores[id] = score
+		}
+		if score.IP == "" && ban.IP != nil {
+			score.IP = ban.IP.String()
+		}
+		score.Bans = ban.Count
+		if now.Before(ban.Until) {
+			until := ban.Until
+			score.BannedUntil = &until
+		}
+	}
+	list := make([]*PeerScore, 0, len(scores))
+	for _, score := range scores {
+		list = append(list, score)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
@@ -0,0 +1,145 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/CortexFoundation/CortexTheseus/common/mclock"
+	"github.com/CortexFoundation/CortexTheseus/p2p/enode"
+)
+
+// Tests that misbehaving peers get banned with an exponential backoff, and that
+// the bans survive reloading the node database.
+func TestReputationBanBackoff(t *testing.T) {
+	db, _ := enode.OpenDB("")
+	defer db.Close()
+
+	var (
+		clock = new(mclock.Simulated)
+		now   = time.Now().Truncate(time.Second)
+		id    = enode.ID{1}
+		ip    = net.IP{10, 0, 0, 1}
+	)
+	r := newReputation(db, clock)
+	r.now = func() time.Time { return now }
+
+	// A single invalid response must not ban, a few of them must
+	if r.report(id, ip, InvalidResponse, true) {
+		t.Fatalf("peer banned after a single invalid response")
+	}
+	for i := 0; i < 4; i++ {
+		r.report(id, ip, InvalidResponse, true)
+	}
+	if !r.banned(id) || !r.bannedIP(ip) {
+		t.Fatalf("peer not banned: id %v, ip %v", r.banned(id), r.bannedIP(ip))
+	}
+	if until := r.bans[id].Until; until != now.Add(baseBanDuration) {
+		t.Fatalf("first ban expiry mismatch: have %v, want %v", until, now.Add(baseBanDuration))
+	}
+	// Once expired, a second ban should last twice as long
+	now = now.Add(baseBanDuration + time.Second)
+	if r.banned(id) || r.bannedIP(ip) {
+		t.Fatalf("expired ban still in effect")
+	}
+	if !r.report(id, ip, InvalidSeal, true) {
+		t.Fatalf("peer not banned after an invalid seal")
+	}
+	if until := r.bans[id].Until; until != now.Add(2*baseBanDuration) {
+		t.Fatalf("second ban expiry mismatch: have %v, want %v", until, now.Add(2*baseBanDuration))
+	}
+	// Reloading the database should retain the ban
+	r = newReputation(db, clock)
+	r.now = func() time.Time { return now }
+	if !r.banned(id) || !r.bannedIP(ip) {
+		t.Fatalf("ban lost after reload")
+	}
+	if !r.unban(id) {
+		t.Fatalf("unban reported no active ban")
+	}
+	if r.banned(id) || db.Ban(id) != nil {
+		t.Fatalf("ban still in effect after unban")
+	}
+}
+
+// Tests that scores decay back to neutral and that non-bannable peers are
+// scored without being banned.
+func TestReputationDecay(t *testing.T) {
+	db, _ := enode.OpenDB("")
+	defer db.Close()
+
+	clock := new(mclock.Simulated)
+	r := newReputation(db, clock)
+
+	r.report(enode.ID{1}, nil, BadBlock, true)
+	clock.Run(reputationHalfLife)
+	if score := r.scores()[0].Score; score != behaviourScores[BadBlock]/2 {
+		t.Fatalf("decayed score mismatch: have %v, want %v", score, behaviourScores[BadBlock]/2)
+	}
+	if r.report(enode.ID{2}, nil, InvalidSeal, false) || r.banned(enode.ID{2}) {
+		t.Fatalf("non-bannable peer banned")
+	}
+	for i := 0; i < 2*maxReputation; i++ {
+		r.report(enode.ID{3}, nil, UsefulResponse, true)
+	}
+	if score := r.scores()[2].Score; score != maxReputation {
+		t.Fatalf("capped score mismatch: have %v, want %v", score, maxReputation)
+	}
+}
+
+// Tests that long expired bans are forgotten while running, and that bans are
+// looked up by the IP they were last issued for.
+func TestReputationBanPruning(t *testing.T) {
+	db, _ := enode.OpenDB("")
+	defer db.Close()
+
+	var (
+		clock = new(mclock.Simulated)
+		now   = time.Now().Truncate(time.Second)
+		ip1   = net.IP{10, 0, 0, 1}
+		ip2   = net.IP{10, 0, 0, 2}
+	)
+	r := newReputation(db, clock)
+	r.now = func() time.Time { return now }
+
+	r.lock.Lock()
+	r.ban(enode.ID{1}, ip1, time.Hour)
+	r.ban(enode.ID{1}, ip2, time.Hour)
+	r.lock.Unlock()
+
+	if r.bannedIP(ip1) || !r.bannedIP(ip2) {
+		t.Fatalf("ip index mismatch: %v banned %v, %v banned %v", ip1, r.bannedIP(ip1), ip2, r.bannedIP(ip2))
+	}
+	// A ban issued after the first one is long expired must prune it
+	now = now.Add(time.Hour + banForgetTime + time.Second)
+
+	r.lock.Lock()
+	ban := r.ban(enode.ID{2}, ip1, time.Hour)
+	r.lock.Unlock()
+
+	if ban.Count != 1 {
+		t.Fatalf("ban count mismatch: have %d, want 1", ban.Count)
+	}
+	if r.bans[enode.ID{1}] != nil || db.Ban(enode.ID{1}) != nil {
+		t.Fatalf("expired ban not forgotten")
+	}
+	if r.bannedIP(ip2) || len(r.ips) != 1 {
+		t.Fatalf("expired ban still indexed: %v", r.ips)
+	}
+}
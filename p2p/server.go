@@ -85,12 +85,18 @@ type Config struct {
 	// Setting DialRatio to zero defaults it to 3.
 	DialRatio int `toml:",omitempty"`
 
+	// ProtocolDialRatios weighs the dial candidates found by the discovery sources
+	// of each protocol, keyed by protocol name, against the random nodes of the
+	// discovery tables. A weight of 3 takes three candidates from the protocol
+	// for every table node. Protocols which are not listed have a weight of 1.
+	ProtocolDialRatios map[string]int `toml:",omitempty"`
+
 	// NoDiscovery can be used to disable the peer discovery mechanism.
 	// Disabling is useful for protocol debugging (manual topology).
 	NoDiscovery bool
 
-	// DiscoveryV5 specifies whether the new topic-discovery based V5 discovery
-	// protocol should be started or not.
+	// DiscoveryV5 specifies whether the V5 discovery protocol should be started
+	// or not. When V4 discovery is running too, both share the UDP socket.
 	DiscoveryV5 bool `toml:",omitempty"`
 
 	// Name sets the node name of this server.
@@ -178,12 +184,14 @@ type Server struct {
 	peerFeed     event.Feed
 	log          log.Logger
 
-	nodedb    *enode.DB
-	localnode *enode.LocalNode
-	ntab      *discover.UDPv4
-	DiscV5    *discover.UDPv5
-	discmix   *enode.FairMix
-	dialsched *dialScheduler
+	nodedb     *enode.DB
+	reputation *reputation
+	localnode  *enode.LocalNode
+	ntab       *discover.UDPv4
+	DiscV5     *discover.UDPv5
+	discmix    *enode.FairMix
+	dialRatios map[string]int
+	dialsched  *dialScheduler
 
 	// Channels into the run loop.
 	quit                    chan struct{}
@@ -353,6 +361,83 @@ func (srv *Server) RemovePeer(node *enode.Node) {
 	}
 }
 
+// BanPeer refuses connections from a node, by its ID and by the given IP, for
+// the given duration. A zero duration bans the node for twice as long as its
+// previous ban. The node is disconnected if it's currently connected.
+func (srv *Server) BanPeer(id enode.ID, ip net.IP, duration time.Duration) (time.Time, error) {
+	rep := srv.peerReputation()
+	if rep == nil {
+		return time.Time{}, errServerStopped
+	}
+	rep.lock.Lock()
+	ban := rep.ban(id, ip, duration)
+	until := ban.Until
+	rep.lock.Unlock()
+
+	srv.doPeerOp(func(peers map[enode.ID]*Peer) {
+		if peer := peers[id]; peer != nil {
+			peer.Disconnect(DiscUselessPeer)
+		}
+	})
+	return until, nil
+}
+
+// UnbanPeer lifts the ban of a node and resets its reputation, returning whether
+// it was banned.
+func (srv *Server) UnbanPeer(id enode.ID) (bool, error) {
+	rep := srv.peerReputation()
+	if rep == nil {
+		return false, errServerStopped
+	}
+	return rep.unban(id), nil
+}
+
+// PeerScores retrieves the reputation of all the remote nodes whose conduct was
+// reported, along with all the banned ones.
+func (srv *Server) PeerScores() []*PeerScore {
+	rep := srv.peerReputation()
+	if rep == nil {
+		return nil
+	}
+	return rep.scores()
+}
+
+// peerReputation retrieves the reputation tracker, which is only created when
+// the server starts.
+func (srv *Server) peerReputation() *reputation {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	return srv.reputation
+}
+
+// DiscoveryStats reports the health of the node discovery tables.
+type DiscoveryStats struct {
+	V4         *discover.TableStats `json:"v4"`         // Nil if V4 discovery is not running
+	V5         *discover.TableStats `json:"v5"`         // Nil if V5 discovery is not running
+	DialRatios map[string]int       `json:"dialRatios"` // Weights of the protocol dial candidate sources
+}
+
+// DiscoveryStats retrieves the health of the running discovery tables.
+func (srv *Server) DiscoveryStats() *DiscoveryStats {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	stats := &DiscoveryStats{DialRatios: make(map[string]int)}
+	if !srv.running {
+		return stats
+	}
+	if srv.ntab != nil {
+		stats.V4 = srv.ntab.TableStats()
+	}
+	if srv.DiscV5 != nil {
+		stats.V5 = srv.DiscV5.TableStats()
+	}
+	for name, ratio := range srv.dialRatios {
+		stats.DialRatios[name] = ratio
+	}
+	return stats
+}
+
 // AddTrustedPeer adds the given node to a reserved whitelist which allows the
 // node to always connect, even if the slot are full.
 func (srv *Server) AddTrustedPeer(node *enode.Node) {
@@ -503,6 +588,7 @@ func (srv *Server) setupLocalNode() error {
 		return err
 	}
 	srv.nodedb = db
+	srv.reputation = newReputation(db, srv.clock)
 	srv.localnode = enode.NewLocalNode(db, srv.PrivateKey)
 	srv.localnode.SetFallbackIP(net.IP{127, 0, 0, 1})
 	// TODO: check conflicts
@@ -536,11 +622,15 @@ func (srv *Server) setupDiscovery() error {
 	srv.discmix = enode.NewFairMix(discmixTimeout)
 
 	// Add protocol-specific discovery sources.
-	added := make(map[string]bool)
+	srv.dialRatios = make(map[string]int)
 	for _, proto := range srv.Protocols {
-		if proto.DialCandidates != nil && !added[proto.Name] {
-			srv.discmix.AddSource(proto.DialCandidates)
-			added[proto.Name] = true
+		if _, added := srv.dialRatios[proto.Name]; proto.DialCandidates != nil && !added {
+			ratio := srv.ProtocolDialRatios[proto.Name]
+			if ratio < 1 {
+				ratio = 1
+			}
+			srv.discmix.AddWeightedSource(proto.DialCandidates, ratio)
+			srv.dialRatios[proto.Name] = ratio
 		}
 	}
 
@@ -810,6 +900,8 @@ func (srv *Server) postHandshakeChecks(peers map[enode.ID]*Peer, inboundCount in
 		return DiscAlreadyConnected
 	case c.node.ID() == srv.localnode.ID():
 		return DiscSelf
+	case srv.reputation.banned(c.node.ID()):
+		return DiscUselessPeer
 	default:
 		return nil
 	}
@@ -905,6 +997,10 @@ func (srv *Server) checkInboundConn(fd net.Conn, remoteIP net.IP) error {
 	if srv.NetRestrict != nil && !srv.NetRestrict.Contains(remoteIP) {
 		return fmt.Errorf("not whitelisted in NetRestrict")
 	}
+	// Reject peers banned for misbehaving.
+	if srv.reputation.bannedIP(remoteIP) {
+		return fmt.Errorf("banned")
+	}
 	// Reject Internet peers that try too often.
 	now := srv.clock.Now()
 	srv.inboundHistory.expire(now, nil)
@@ -1014,6 +1110,7 @@ func (srv *Server) checkpoint(c *conn, stage chan<- *conn) error {
 
 func (srv *Server) launchPeer(c *conn) *Peer {
 	p := newPeer(srv.log, c, srv.Protocols)
+	p.reputation = srv.reputation
 	if srv.EnableMsgEvents {
 		// If message events are enabled, pass the peerFeed
 		// to the peer.
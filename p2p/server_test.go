@@ -619,3 +619,41 @@ func syncAddPeer(srv *Server, node *enode.Node) bool {
 		}
 	}
 }
+
+// This test checks that V4 and V5 discovery run side by side on a shared socket
+// and report the health of their tables.
+func TestServerDiscoveryStats(t *testing.T) {
+	protocols := []Protocol{
+		{Name: "a", Version: 1, DialCandidates: enode.IterNodes(nil)},
+		{Name: "a", Version: 2, DialCandidates: enode.IterNodes(nil)},
+		{Name: "b", Version: 1, DialCandidates: enode.IterNodes(nil)},
+		{Name: "c", Version: 1},
+	}
+	srv := &Server{
+		Config: Config{
+			PrivateKey:         newkey(),
+			MaxPeers:           10,
+			ListenAddr:         "127.0.0.1:0",
+			NoDial:             true,
+			DiscoveryV5:        true,
+			Protocols:          protocols,
+			ProtocolDialRatios: map[string]int{"a": 3},
+			Logger:             testlog.Logger(t, log.LvlTrace),
+		},
+	}
+	if stats := srv.DiscoveryStats(); stats.V4 != nil || stats.V5 != nil {
+		t.Fatalf("stopped server reports tables: %+v", stats)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("could not start: %v", err)
+	}
+	defer srv.Stop()
+
+	stats := srv.DiscoveryStats()
+	if stats.V4 == nil || stats.V5 == nil {
+		t.Fatalf("missing discovery tables: v4 %v, v5 %v", stats.V4, stats.V5)
+	}
+	if want := map[string]int{"a": 3, "b": 1}; !reflect.DeepEqual(stats.DialRatios, want) {
+		t.Fatalf("wrong dial ratios: have %v, want %v", stats.DialRatios, want)
+	}
+}
@@ -205,6 +205,18 @@ func (c *Client) DisconnectNode(nodeID, peerID string) error {
 	return c.Delete(fmt.Sprintf("/nodes/%s/conn/%s", nodeID, peerID))
 }
 
+// GetScenarios returns the names of the scenarios the server can run
+func (c *Client) GetScenarios() ([]string, error) {
+	var names []string
+	return names, c.Get("/scenarios", &names)
+}
+
+// RunScenario runs a scenario with the given parameters, decoding the
+// resulting report into "out"
+func (c *Client) RunScenario(name string, params, out interface{}) error {
+	return c.Post(fmt.Sprintf("/scenarios/%s", name), params, out)
+}
+
 // RPCClient returns an RPC client connected to a node
 func (c *Client) RPCClient(ctx context.Context, nodeID string) (*rpc.Client, error) {
 	baseURL := strings.Replace(c.URL, "http", "ws", 1)
@@ -284,6 +296,8 @@ func NewServer(network *Network) *Server {
 	s.POST("/mocker/start", s.StartMocker)
 	s.POST("/mocker/stop", s.StopMocker)
 	s.GET("/mocker", s.GetMockers)
+	s.GET("/scenarios", s.GetScenarios)
+	s.POST("/scenarios/:scenario", s.RunScenario)
 	s.POST("/reset", s.ResetNetwork)
 	s.GET("/events", s.StreamNetworkEvents)
 	s.GET("/snapshot", s.CreateSnapshot)
@@ -371,6 +385,36 @@ func (s *Server) GetMockers(w http.ResponseWriter, req *http.Request) {
 	s.JSON(w, http.StatusOK, list)
 }
 
+// GetScenarios returns a list of available scenarios
+func (s *Server) GetScenarios(w http.ResponseWriter, req *http.Request) {
+	s.JSON(w, http.StatusOK, GetScenarioList())
+}
+
+// RunScenario runs a scenario against the network with the parameters given in
+// the request body, responding with the scenario report once it finishes
+func (s *Server) RunScenario(w http.ResponseWriter, req *http.Request) {
+	name := req.Context().Value("scenario").(string)
+	scenarioFn := LookupScenario(name)
+	if scenarioFn == nil {
+		http.Error(w, fmt.Sprintf("unknown scenario %q", name), http.StatusNotFound)
+		return
+	}
+	params, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(bytes.TrimSpace(params)) == 0 {
+		params = []byte("{}")
+	}
+	report, err := scenarioFn(req.Context(), s.network, params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.JSON(w, http.StatusOK, report)
+}
+
 // ResetNetwork resets all properties of a network to its initial (empty) state
 func (s *Server) ResetNetwork(w http.ResponseWriter, req *http.Request) {
 	s.network.Reset()
@@ -737,6 +781,10 @@ func (s *Server) wrapHandler(handler http.HandlerFunc) httprouter.Handle {
 			ctx = context.WithValue(ctx, "peer", peer)
 		}
 
+		if name := params.ByName("scenario"); name != "" {
+			ctx = context.WithValue(ctx, "scenario", name)
+		}
+
 		handler(w, req.WithContext(ctx))
 	}
 }
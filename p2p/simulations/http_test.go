@@ -18,6 +18,7 @@ package simulations
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"math/rand"
@@ -589,6 +590,62 @@ func TestHTTPNodeRPC(t *testing.T) {
 	}
 }
 
+// TestHTTPScenario tests listing and running scenarios via the HTTP API
+func TestHTTPScenario(t *testing.T) {
+	// start the server
+	_, s := testHTTPServer(t)
+	defer s.Close()
+
+	client := NewClient(s.URL)
+	startTestNetwork(t, client)
+
+	// register a scenario which reports the number of up nodes
+	type countParams struct {
+		Offset int `json:"offset"`
+	}
+	RegisterScenario("test-count", func(ctx context.Context, net *Network, params json.RawMessage) (interface{}, error) {
+		var p countParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		up := 0
+		for _, node := range net.GetNodes() {
+			if node.Up() {
+				up++
+			}
+		}
+		return up + p.Offset, nil
+	})
+	defer func() {
+		scenarioMu.Lock()
+		delete(scenarioList, "test-count")
+		scenarioMu.Unlock()
+	}()
+
+	names, err := client.GetScenarios()
+	if err != nil {
+		t.Fatalf("error listing scenarios: %s", err)
+	}
+	found := false
+	for _, name := range names {
+		found = found || name == "test-count"
+	}
+	if !found {
+		t.Fatalf("scenario not listed: %v", names)
+	}
+
+	var count int
+	if err := client.RunScenario("test-count", &countParams{Offset: 1}, &count); err != nil {
+		t.Fatalf("error running scenario: %s", err)
+	}
+	if count != 3 {
+		t.Fatalf("expected scenario report 3, got %d", count)
+	}
+	if err := client.RunScenario("test-missing", nil, nil); err == nil {
+		t.Fatal("expected error running unknown scenario")
+	}
+}
+
 // TestHTTPSnapshot tests creating and loading network snapshots
 func TestHTTPSnapshot(t *testing.T) {
 	// start the server
@@ -0,0 +1,69 @@
+// Copyright 2017 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulations
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ScenarioFunc runs a scenario against the nodes of a network and returns a
+// report of its measurements, which is sent to API clients encoded as JSON.
+// The params are the JSON encoded scenario parameters given by the client.
+type ScenarioFunc func(ctx context.Context, net *Network, params json.RawMessage) (interface{}, error)
+
+var (
+	scenarioList = make(map[string]ScenarioFunc)
+	scenarioMu   sync.RWMutex
+)
+
+// RegisterScenario makes a scenario available to the simulation API under the
+// given name. It panics if the name is already taken.
+func RegisterScenario(name string, fn ScenarioFunc) {
+	scenarioMu.Lock()
+	defer scenarioMu.Unlock()
+
+	if _, exists := scenarioList[name]; exists {
+		panic(fmt.Sprintf("scenario already registered: %q", name))
+	}
+	scenarioList[name] = fn
+}
+
+// LookupScenario returns the scenario registered under the given name, or nil
+// if there is none.
+func LookupScenario(name string) ScenarioFunc {
+	scenarioMu.RLock()
+	defer scenarioMu.RUnlock()
+
+	return scenarioList[name]
+}
+
+// GetScenarioList returns the sorted names of all registered scenarios.
+func GetScenarioList() []string {
+	scenarioMu.RLock()
+	defer scenarioMu.RUnlock()
+
+	list := make([]string, 0, len(scenarioList))
+	for name := range scenarioList {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
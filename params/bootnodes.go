@@ -53,11 +53,17 @@ var DoloresBootnodes = []string{
 //const dnsPrefix = "enrtree://AKLET737XA6CY7T4QAPFJCUZRZ46EFAGZIV6LOAGKTG45RRZSUUWI@"
 const dnsPrefix = "enrtree://AKLET737XA6CY7T4QAPFJCUZRZ46EFAGZIV6LOAGKTG45RRZSUUWI@"
 
+// KnownDNSNetwork returns the address of a public DNS-based node list for the given
+// genesis hash and protocol, or the empty string if no list is published for the
+// network. The tree key above only signs the mainnet lists, the testnets have
+// none published yet.
 func KnownDNSNetwork(genesis common.Hash, protocol string) string {
 	var net string
 	switch genesis {
 	case MainnetGenesisHash:
 		net = "mainnet"
+	default:
+		return ""
 	}
 	return dnsPrefix + protocol + "." + net + ".coinbag.org"
 }
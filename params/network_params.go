@@ -28,7 +28,20 @@ const (
 	// considered probably final and its rotated bits are calculated.
 	BloomConfirms = 256
 
+	// CHTFrequency is the block frequency for creating CHTs
 	CHTFrequency = 32768
+
+	// BloomTrieFrequency is the block frequency for creating bloom tries, each
+	// of which aggregates the bloom bit sections of the server.
+	BloomTrieFrequency = 32768
+
+	// HelperTrieConfirmations is the number of confirmations before a client is
+	// expected to have the given HelperTrie available.
+	HelperTrieConfirmations = 2048
+
+	// HelperTrieProcessConfirmations is the number of confirmations before a
+	// HelperTrie is generated.
+	HelperTrieProcessConfirmations = 256
 	/*
 		Check section:60 1998847
 		Check section:61 2031615
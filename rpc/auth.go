@@ -0,0 +1,69 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import (
+	"context"
+	"fmt"
+)
+
+// Authorizer decides whether the client behind a connection may invoke a method.
+// Transports attach it to the connection context with WithAuthorizer, and the
+// server consults it before executing every call or subscription.
+type Authorizer interface {
+	// Authorize returns a non-nil error if the method in the given namespace
+	// must not be executed.
+	Authorize(namespace, method string) error
+}
+
+type authorizerContextKey struct{}
+
+// WithAuthorizer returns a copy of ctx which restricts the methods that calls
+// served under it may invoke.
+func WithAuthorizer(ctx context.Context, auth Authorizer) context.Context {
+	return context.WithValue(ctx, authorizerContextKey{}, auth)
+}
+
+// authorizerFromContext retrieves the authorizer of a connection, if any.
+func authorizerFromContext(ctx context.Context) Authorizer {
+	auth, _ := ctx.Value(authorizerContextKey{}).(Authorizer)
+	return auth
+}
+
+// Authorize checks a call of method in the given namespace, made while serving
+// the request of ctx, against the authorizer of its connection, if any. It lets
+// handlers serving requests outside of a Server apply the same restrictions.
+func Authorize(ctx context.Context, namespace, method string) error {
+	if auth := authorizerFromContext(ctx); auth != nil {
+		if err := auth.Authorize(namespace, method); err != nil {
+			return &accessDeniedError{method: method, err: err}
+		}
+	}
+	return nil
+}
+
+// accessDeniedError is returned for calls rejected by the connection authorizer.
+type accessDeniedError struct {
+	method string
+	err    error
+}
+
+func (e *accessDeniedError) ErrorCode() int { return -32001 }
+
+func (e *accessDeniedError) Error() string {
+	return fmt.Sprintf("access to method %s denied: %v", e.method, e.err)
+}
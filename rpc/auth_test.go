@@ -0,0 +1,90 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testAuthorizer only allows calls into the "test" namespace.
+type testAuthorizer struct{}
+
+func (testAuthorizer) Authorize(namespace, method string) error {
+	if namespace != "test" {
+		return errors.New("forbidden")
+	}
+	return nil
+}
+
+// withTestAuthorizer attaches the test authorizer to every request.
+func withTestAuthorizer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(WithAuthorizer(r.Context(), testAuthorizer{})))
+	})
+}
+
+func checkAuthorizer(t *testing.T, client *Client) {
+	var result echoResult
+	if err := client.Call(&result, "test_echo", "hello", 10, &echoArgs{"world"}); err != nil {
+		t.Fatalf("allowed call failed: %v", err)
+	}
+	var n int
+	err := client.Call(&n, "nftest_echo", 1)
+	if err == nil {
+		t.Fatal("denied call succeeded")
+	}
+	if rpcErr, ok := err.(Error); !ok || rpcErr.ErrorCode() != -32001 {
+		t.Fatalf("wrong error for denied call: %v", err)
+	}
+}
+
+func TestAuthorizerHTTP(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer()
+	defer srv.Stop()
+	httpsrv := httptest.NewServer(withTestAuthorizer(srv))
+	defer httpsrv.Close()
+
+	client, err := DialHTTP(httpsrv.URL)
+	if err != nil {
+		t.Fatalf("can't dial: %v", err)
+	}
+	defer client.Close()
+	checkAuthorizer(t, client)
+}
+
+func TestAuthorizerWebsocket(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer()
+	defer srv.Stop()
+	httpsrv := httptest.NewServer(withTestAuthorizer(srv.WebsocketHandler([]string{"*"})))
+	defer httpsrv.Close()
+
+	client, err := DialWebsocket(context.Background(), "ws:"+strings.TrimPrefix(httpsrv.URL, "http:"), "")
+	if err != nil {
+		t.Fatalf("can't dial: %v", err)
+	}
+	defer client.Close()
+	checkAuthorizer(t, client)
+}
@@ -61,6 +61,7 @@ type handler struct {
 	conn           jsonWriter                     // where responses will be sent
 	log            log.Logger
 	allowSubscribe bool
+	limiter        *limiter // resource limits of the serving Server, nil if unlimited
 
 	subLock    sync.Mutex
 	serverSubs map[ID]*Subscription
@@ -84,6 +85,7 @@ func newHandler(connCtx context.Context, conn jsonWriter, idgen func() ID, reg *
 		allowSubscribe: true,
 		serverSubs:     make(map[ID]*Subscription),
 		log:            log.Root(),
+		limiter:        limiterFromContext(connCtx),
 	}
 	if conn.remoteAddr() != "" {
 		h.log = h.log.New("conn", conn.remoteAddr())
@@ -101,6 +103,17 @@ func (h *handler) handleBatch(msgs []*jsonrpcMessage) {
 		})
 		return
 	}
+	if h.limiter != nil && h.limiter.limits.BatchItems > 0 && len(msgs) > h.limiter.limits.BatchItems {
+		batchLimitedCounter.Inc(1)
+		h.startCallProc(func(cp *callProc) {
+			answers := make([]*jsonrpcMessage, 0, len(msgs))
+			for _, msg := range msgs {
+				answers = append(answers, msg.errorResponse(&limitExceededError{"batch too large"}))
+			}
+			h.conn.writeJSON(cp.ctx, answers)
+		})
+		return
+	}
 
 	// Handle non-call messages first:
 	calls := make([]*jsonrpcMessage, 0, len(msgs))
@@ -114,10 +127,14 @@ func (h *handler) handleBatch(msgs []*jsonrpcMessage) {
 	}
 	// Process calls on a goroutine because they may block indefinitely:
 	h.startCallProc(func(cp *callProc) {
-		answers := make([]*jsonrpcMessage, 0, len(msgs))
+		var (
+			answers = make([]*jsonrpcMessage, 0, len(msgs))
+			size    int
+		)
 		for _, msg := range calls {
 			if answer := h.handleCallMsg(cp, msg); answer != nil {
-				answers = append(answers, answer)
+				size += len(answer.Result)
+				answers = append(answers, h.limitResponse(msg, answer, size))
 			}
 		}
 		h.addSubscriptions(cp.notifiers)
@@ -139,7 +156,7 @@ func (h *handler) handleMsg(msg *jsonrpcMessage) {
 		answer := h.handleCallMsg(cp, msg)
 		h.addSubscriptions(cp.notifiers)
 		if answer != nil {
-			h.conn.writeJSON(cp.ctx, answer)
+			h.conn.writeJSON(cp.ctx, h.limitResponse(msg, answer, len(answer.Result)))
 		}
 		for _, n := range cp.notifiers {
 			n.activate()
@@ -147,6 +164,16 @@ func (h *handler) handleMsg(msg *jsonrpcMessage) {
 	})
 }
 
+// limitResponse replaces the answer to msg with an error if the total size of
+// the response including this answer exceeds the configured limit.
+func (h *handler) limitResponse(msg, answer *jsonrpcMessage, size int) *jsonrpcMessage {
+	if h.limiter == nil || h.limiter.limits.ResponseSize <= 0 || size <= h.limiter.limits.ResponseSize {
+		return answer
+	}
+	responseLimitedCounter.Inc(1)
+	return msg.errorResponse(&limitExceededError{"response too large"})
+}
+
 // close cancels all requests except for inflightReq and waits for
 // call goroutines to shut down.
 func (h *handler) close(err error, inflightReq *requestOp) {
@@ -317,6 +344,16 @@ func (h *handler) handleCallMsg(ctx *callProc, msg *jsonrpcMessage) *jsonrpcMess
 
 // handleCall processes method calls.
 func (h *handler) handleCall(cp *callProc, msg *jsonrpcMessage) *jsonrpcMessage {
+	if auth := authorizerFromContext(cp.ctx); auth != nil && !msg.isUnsubscribe() {
+		if err := auth.Authorize(msg.namespace(), msg.Method); err != nil {
+			return msg.errorResponse(&accessDeniedError{method: msg.Method, err: err})
+		}
+	}
+	if h.limiter != nil {
+		if err := h.limiter.allow(clientID(cp.ctx, h.conn.remoteAddr()), msg); err != nil {
+			return msg.errorResponse(err)
+		}
+	}
 	if msg.isSubscribe() {
 		return h.handleSubscribe(cp, msg)
 	}
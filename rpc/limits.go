@@ -0,0 +1,288 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// limiterIdleTimeout is the time after which the rate limiter state of an
+	// inactive client is dropped.
+	limiterIdleTimeout = 5 * time.Minute
+
+	// limiterSweepInterval is the minimum time between two sweeps of idle
+	// client rate limiters.
+	limiterSweepInterval = time.Minute
+)
+
+// DefaultExpensiveMethods is the list of methods charged against the expensive
+// call budget if no other list is configured. A trailing '*' matches any
+// method with the given prefix.
+var DefaultExpensiveMethods = []string{
+	"ctxc_call",
+	"ctxc_estimateGas",
+	"debug_trace*",
+	"*infer*",
+}
+
+// Limits configures the resource limits a Server enforces on its clients. The
+// zero value of any field disables the respective limit.
+type Limits struct {
+	BatchItems   int // Maximum number of requests in a batch
+	ResponseSize int // Maximum size in bytes of the response to a request or batch
+
+	RequestRate  float64 // Sustained requests per second allowed for a single client
+	RequestBurst int     // Maximum burst of requests allowed for a single client
+
+	ExpensiveRate    float64  // Sustained expensive calls per second allowed for a single client
+	ExpensiveBurst   int      // Maximum burst of expensive calls allowed for a single client
+	ExpensiveMethods []string // Methods counted as expensive, DefaultExpensiveMethods if nil
+}
+
+// limiter enforces the request rate limits per client. Clients are identified by
+// the ID set with WithClientID or otherwise by their remote IP address. Requests
+// without either, such as those from the IPC and in-process endpoints, are never
+// throttled.
+type limiter struct {
+	limits    Limits
+	expensive []string
+
+	lock      sync.Mutex
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+}
+
+// clientLimiter is the rate limiter state of a single client.
+type clientLimiter struct {
+	requests  *rate.Limiter
+	expensive *rate.Limiter
+	lastSeen  time.Time
+}
+
+func newLimiter(limits Limits) *limiter {
+	l := &limiter{
+		limits:    limits,
+		expensive: limits.ExpensiveMethods,
+		clients:   make(map[string]*clientLimiter),
+		lastSweep: time.Now(),
+	}
+	if l.expensive == nil {
+		l.expensive = DefaultExpensiveMethods
+	}
+	return l
+}
+
+// allow reports whether the client may execute the given method now, returning
+// the limit error if not.
+func (l *limiter) allow(client string, msg *jsonrpcMessage) error {
+	return l.charge(client, true, l.limits.ExpensiveRate > 0 && l.isExpensive(msg))
+}
+
+// charge takes a request and/or an expensive call from the budget of client,
+// returning the limit error if either is exhausted.
+func (l *limiter) charge(client string, request, expensive bool) error {
+	if client == "" || (l.limits.RequestRate <= 0 && l.limits.ExpensiveRate <= 0) {
+		return nil
+	}
+	now := time.Now()
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if now.Sub(l.lastSweep) > limiterSweepInterval {
+		for id, c := range l.clients {
+			if now.Sub(c.lastSeen) > limiterIdleTimeout {
+				delete(l.clients, id)
+			}
+		}
+		l.lastSweep = now
+	}
+	c := l.clients[client]
+	if c == nil {
+		c = &clientLimiter{
+			requests:  newRateLimiter(l.limits.RequestRate, l.limits.RequestBurst),
+			expensive: newRateLimiter(l.limits.ExpensiveRate, l.limits.ExpensiveBurst),
+		}
+		l.clients[client] = c
+	}
+	c.lastSeen = now
+
+	if request && c.requests != nil && !c.requests.AllowN(now, 1) {
+		rateLimitedCounter.Inc(1)
+		return &limitExceededError{"request rate limit exceeded"}
+	}
+	if expensive && c.expensive != nil && !c.expensive.AllowN(now, 1) {
+		expensiveLimitedCounter.Inc(1)
+		return &limitExceededError{"expensive call rate limit exceeded"}
+	}
+	return nil
+}
+
+// isExpensive reports whether the method called by msg is charged against the
+// expensive call budget.
+func (l *limiter) isExpensive(msg *jsonrpcMessage) bool {
+	method := msg.Method
+	if elem := strings.SplitN(method, serviceMethodSeparator, 2); len(elem) == 2 {
+		method = msg.namespace() + serviceMethodSeparator + elem[1]
+	}
+	return l.isExpensiveMethod(method)
+}
+
+// isExpensiveMethod reports whether calls of the given method are charged
+// against the expensive call budget.
+func (l *limiter) isExpensiveMethod(method string) bool {
+	for _, pattern := range l.expensive {
+		if matchMethod(pattern, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchMethod matches a method name against a pattern, which may start and/or
+// end with a '*' wildcard.
+func matchMethod(pattern, method string) bool {
+	prefix, suffix := strings.HasPrefix(pattern, "*"), strings.HasSuffix(pattern, "*")
+	pattern = strings.Trim(pattern, "*")
+	switch {
+	case prefix && suffix:
+		return strings.Contains(method, pattern)
+	case prefix:
+		return strings.HasSuffix(method, pattern)
+	case suffix:
+		return strings.HasPrefix(method, pattern)
+	default:
+		return method == pattern
+	}
+}
+
+// newRateLimiter creates a token bucket limiter, or returns nil if the rate
+// is not limited.
+func newRateLimiter(limit float64, burst int) *rate.Limiter {
+	if limit <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(limit), burst)
+}
+
+type clientIDContextKey struct{}
+
+// WithClientID returns a copy of ctx identifying the client of all calls served
+// under it by id, e.g. the subject of an authentication token. Rate limits are
+// tracked per client ID instead of per remote address if one is set.
+func WithClientID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, clientIDContextKey{}, id)
+}
+
+// clientID returns the identifier used to track the rate limits of a client.
+func clientID(ctx context.Context, remote string) string {
+	if id, ok := ctx.Value(clientIDContextKey{}).(string); ok && id != "" {
+		return "id:" + id
+	}
+	if remote == "" {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		return "ip:" + host
+	}
+	return "ip:" + remote
+}
+
+type limiterContextKey struct{}
+
+// limiterFromContext retrieves the limiter of the serving Server, if any.
+func limiterFromContext(ctx context.Context) *limiter {
+	l, _ := ctx.Value(limiterContextKey{}).(*limiter)
+	return l
+}
+
+// LimitHandler returns a handler enforcing the limits of the server on plain HTTP
+// requests that do not pass through it, such as GraphQL queries. Every request
+// is charged against the same request rate budget of its client as its RPC calls
+// and rejected if its response exceeds the response size limit. Calls made while
+// serving a request can be charged against the expensive call budget with
+// CheckExpensive. Without limits configured, next is returned unchanged.
+func (s *Server) LimitHandler(next http.Handler) http.Handler {
+	l := s.limiter
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := clientID(r.Context(), r.RemoteAddr)
+		if err := l.charge(client, true, false); err != nil {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), limitedRequestKey{}, &limitedRequest{l, client}))
+		if l.limits.ResponseSize <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		lw := &limitedResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(lw, r)
+		if lw.buf.Len() > l.limits.ResponseSize {
+			responseLimitedCounter.Inc(1)
+			http.Error(w, "response too large", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(lw.status)
+		w.Write(lw.buf.Bytes())
+	})
+}
+
+// CheckExpensive charges a call of method made while serving the request of
+// ctx against the expensive call budget of its client, returning the limit
+// error if the budget is exhausted. It does nothing for requests not served
+// through LimitHandler.
+func CheckExpensive(ctx context.Context, method string) error {
+	req, ok := ctx.Value(limitedRequestKey{}).(*limitedRequest)
+	if !ok || !req.limiter.isExpensiveMethod(method) {
+		return nil
+	}
+	return req.limiter.charge(req.client, false, true)
+}
+
+type limitedRequestKey struct{}
+
+// limitedRequest is the limiter state of a request served by LimitHandler.
+type limitedRequest struct {
+	limiter *limiter
+	client  string
+}
+
+// limitedResponseWriter buffers a response until its size can be checked.
+type limitedResponseWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (w *limitedResponseWriter) WriteHeader(status int) { w.status = status }
+
+func (w *limitedResponseWriter) Write(b []byte) (int, error) { return w.buf.Write(b) }
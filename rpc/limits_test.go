@@ -0,0 +1,218 @@
+// Copyright 2021 The CortexTheseus Authors
+// This file is part of the CortexTheseus library.
+//
+// The CortexTheseus library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The CortexTheseus library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the CortexTheseus library. If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newLimitedTestClient starts a HTTP test server with the given limits and
+// connects to it.
+func newLimitedTestClient(t *testing.T, limits Limits) (*Client, func()) {
+	srv := newTestServer()
+	srv.SetLimits(limits)
+	httpsrv := httptest.NewServer(srv)
+
+	client, err := DialHTTP(httpsrv.URL)
+	if err != nil {
+		t.Fatalf("can't dial: %v", err)
+	}
+	return client, func() {
+		client.Close()
+		httpsrv.Close()
+		srv.Stop()
+	}
+}
+
+// checkLimitError checks that err is a limit exceeded error.
+func checkLimitError(t *testing.T, err error, message string) {
+	t.Helper()
+
+	rpcErr, ok := err.(Error)
+	if !ok || rpcErr.ErrorCode() != -32005 || !strings.Contains(err.Error(), message) {
+		t.Fatalf("wrong error: have %v, want %q", err, message)
+	}
+}
+
+func TestBatchLimit(t *testing.T) {
+	client, stop := newLimitedTestClient(t, Limits{BatchItems: 2})
+	defer stop()
+
+	batch := []BatchElem{
+		{Method: "test_echo", Args: []interface{}{"a", 1, &echoArgs{}}, Result: new(echoResult)},
+		{Method: "test_echo", Args: []interface{}{"b", 2, &echoArgs{}}, Result: new(echoResult)},
+	}
+	if err := client.BatchCall(batch); err != nil {
+		t.Fatalf("batch within the limit failed: %v", err)
+	}
+	for i, elem := range batch {
+		if elem.Error != nil {
+			t.Fatalf("batch element %d failed: %v", i, elem.Error)
+		}
+	}
+	batch = append(batch, BatchElem{Method: "test_echo", Args: []interface{}{"c", 3, &echoArgs{}}, Result: new(echoResult)})
+	if err := client.BatchCall(batch); err != nil {
+		t.Fatalf("batch exceeding the limit failed: %v", err)
+	}
+	for _, elem := range batch {
+		checkLimitError(t, elem.Error, "batch too large")
+	}
+}
+
+func TestResponseLimit(t *testing.T) {
+	client, stop := newLimitedTestClient(t, Limits{ResponseSize: 100})
+	defer stop()
+
+	var result echoResult
+	if err := client.Call(&result, "test_echo", "short", 1, &echoArgs{}); err != nil {
+		t.Fatalf("small response failed: %v", err)
+	}
+	err := client.Call(&result, "test_echo", strings.Repeat("x", 200), 1, &echoArgs{})
+	checkLimitError(t, err, "response too large")
+}
+
+func TestRateLimit(t *testing.T) {
+	client, stop := newLimitedTestClient(t, Limits{RequestRate: 0.001, RequestBurst: 2})
+	defer stop()
+
+	var result echoResult
+	for i := 0; i < 2; i++ {
+		if err := client.Call(&result, "test_echo", "x", i, &echoArgs{}); err != nil {
+			t.Fatalf("call %d within the burst failed: %v", i, err)
+		}
+	}
+	err := client.Call(&result, "test_echo", "x", 2, &echoArgs{})
+	checkLimitError(t, err, "request rate limit exceeded")
+}
+
+func TestExpensiveRateLimit(t *testing.T) {
+	client, stop := newLimitedTestClient(t, Limits{
+		ExpensiveRate:    0.001,
+		ExpensiveBurst:   1,
+		ExpensiveMethods: []string{"test_echo*"},
+	})
+	defer stop()
+
+	var result echoResult
+	if err := client.Call(&result, "test_echo", "x", 0, &echoArgs{}); err != nil {
+		t.Fatalf("first expensive call failed: %v", err)
+	}
+	err := client.Call(&result, "test_echoWithCtx", "x", 1, &echoArgs{})
+	checkLimitError(t, err, "expensive call rate limit exceeded")
+
+	// Cheap calls must not be affected by the expensive budget
+	var str string
+	if err := client.Call(&str, "test_rets"); err != nil {
+		t.Fatalf("cheap call failed: %v", err)
+	}
+}
+
+// Tests that clients identified by ID are throttled independently.
+func TestRateLimitClientID(t *testing.T) {
+	l := newLimiter(Limits{RequestRate: 0.001, RequestBurst: 1})
+	msg := &jsonrpcMessage{Method: "test_echo"}
+
+	alice := clientID(WithClientID(context.Background(), "alice"), "127.0.0.1:1000")
+	bob := clientID(WithClientID(context.Background(), "bob"), "127.0.0.1:1000")
+	if alice == bob {
+		t.Fatalf("client IDs collide: %s", alice)
+	}
+	if err := l.allow(alice, msg); err != nil {
+		t.Fatalf("first call of alice failed: %v", err)
+	}
+	if err := l.allow(alice, msg); err == nil {
+		t.Fatal("second call of alice succeeded")
+	}
+	if err := l.allow(bob, msg); err != nil {
+		t.Fatalf("first call of bob failed: %v", err)
+	}
+	// Requests without client identity are never throttled
+	for i := 0; i < 3; i++ {
+		if err := l.allow(clientID(context.Background(), ""), msg); err != nil {
+			t.Fatalf("local call %d failed: %v", i, err)
+		}
+	}
+}
+
+// Tests that plain HTTP requests served through LimitHandler are charged against
+// the same budgets as RPC calls.
+func TestLimitHandler(t *testing.T) {
+	server := NewServer()
+	defer server.Stop()
+	server.SetLimits(Limits{
+		ResponseSize:     64,
+		RequestRate:      0.001,
+		RequestBurst:     4,
+		ExpensiveRate:    0.001,
+		ExpensiveBurst:   1,
+		ExpensiveMethods: []string{"ctxc_call"},
+	})
+	handler := server.LimitHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := CheckExpensive(r.Context(), r.URL.Query().Get("method")); err != nil {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(r.URL.Query().Get("reply")))
+	}))
+	serve := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/?"+query, nil)
+		req.RemoteAddr = "127.0.0.1:1000"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	// The handler must share the budgets of the server's RPC clients
+	if err := server.limiter.charge("ip:127.0.0.1", true, false); err != nil {
+		t.Fatalf("RPC request throttled: %v", err)
+	}
+	if rec := serve("method=ctxc_call&reply=ok"); rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+		t.Fatalf("first expensive request failed: %d %s", rec.Code, rec.Body)
+	}
+	if rec := serve("method=ctxc_call"); rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), "expensive call rate limit exceeded") {
+		t.Fatalf("second expensive request not throttled: %d %s", rec.Code, rec.Body)
+	}
+	if rec := serve("reply=" + strings.Repeat("x", 100)); rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "response too large") {
+		t.Fatalf("large response not rejected: %d %s", rec.Code, rec.Body)
+	}
+	if rec := serve("reply=ok"); rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), "request rate limit exceeded") {
+		t.Fatalf("request beyond the burst not throttled: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestMatchMethod(t *testing.T) {
+	tests := []struct {
+		pattern, method string
+		match           bool
+	}{
+		{"ctxc_call", "ctxc_call", true},
+		{"ctxc_call", "ctxc_callMany", false},
+		{"debug_trace*", "debug_traceTransaction", true},
+		{"debug_trace*", "debug_getBadBlocks", false},
+		{"*infer*", "ctxc_inferByInfoHash", true},
+		{"*infer*", "ctxc_getBalance", false},
+		{"*_call", "ctxc_call", true},
+	}
+	for _, test := range tests {
+		if match := matchMethod(test.pattern, test.method); match != test.match {
+			t.Errorf("matchMethod(%q, %q) = %v, want %v", test.pattern, test.method, match, test.match)
+		}
+	}
+}
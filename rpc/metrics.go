@@ -27,6 +27,11 @@ var (
 	successfulRequestGauge = metrics.NewRegisteredGauge("rpc/success", nil)
 	failedReqeustGauge     = metrics.NewRegisteredGauge("rpc/failure", nil)
 	rpcServingTimer        = metrics.NewRegisteredTimer("rpc/duration/all", nil)
+
+	batchLimitedCounter     = metrics.NewRegisteredCounter("rpc/limited/batch", nil)
+	responseLimitedCounter  = metrics.NewRegisteredCounter("rpc/limited/response", nil)
+	rateLimitedCounter      = metrics.NewRegisteredCounter("rpc/limited/rate", nil)
+	expensiveLimitedCounter = metrics.NewRegisteredCounter("rpc/limited/expensive", nil)
 )
 
 func newRPCServingTimer(method string, valid bool) metrics.Timer {
@@ -46,6 +46,7 @@ type Server struct {
 	idgen    func() ID
 	run      int32
 	codecs   mapset.Set
+	limiter  *limiter
 }
 
 // NewServer creates a new server instance with no registered handlers.
@@ -66,12 +67,32 @@ func (s *Server) RegisterName(name string, receiver interface{}) error {
 	return s.services.registerName(name, receiver)
 }
 
+// SetLimits configures the resource limits enforced on the clients of the server.
+// It must be called before the server starts serving requests.
+func (s *Server) SetLimits(limits Limits) {
+	s.limiter = newLimiter(limits)
+}
+
+// connContext attaches the server wide state needed by connection handlers.
+func (s *Server) connContext(ctx context.Context) context.Context {
+	if s.limiter != nil {
+		ctx = context.WithValue(ctx, limiterContextKey{}, s.limiter)
+	}
+	return ctx
+}
+
 // ServeCodec reads incoming requests from codec, calls the appropriate callback and writes
 // the response back using the given codec. It will block until the codec is closed or the
 // server is stopped. In either case the codec is closed.
 //
 // Note that codec options are no longer supported.
 func (s *Server) ServeCodec(codec ServerCodec, options CodecOption) {
+	s.serveCodec(context.Background(), codec)
+}
+
+// serveCodec serves the codec like ServeCodec, deriving the context of all
+// calls on the connection from ctx.
+func (s *Server) serveCodec(ctx context.Context, codec ServerCodec) {
 	defer codec.close()
 
 	// Don't serve if server is stopped.
@@ -83,7 +104,7 @@ func (s *Server) ServeCodec(codec ServerCodec, options CodecOption) {
 	s.codecs.Add(codec)
 	defer s.codecs.Remove(codec)
 
-	c := initClient(codec, s.idgen, &s.services)
+	c := initClient(s.connContext(ctx), codec, s.idgen, &s.services)
 	<-codec.closed()
 	c.Close()
 }
@@ -97,7 +118,7 @@ func (s *Server) serveSingleRequest(ctx context.Context, codec ServerCodec) {
 		return
 	}
 
-	h := newHandler(ctx, codec, s.idgen, &s.services)
+	h := newHandler(s.connContext(ctx), codec, s.idgen, &s.services)
 	h.allowSubscribe = false
 	defer h.close(io.EOF, nil)
 
@@ -58,8 +58,17 @@ func (s *Server) WebsocketHandler(allowedOrigins []string) http.Handler {
 			log.Debug("WebSocket upgrade failed", "err", err)
 			return
 		}
+		// Only the client credentials are carried over from the upgrade request,
+		// the connection outlives the request context.
+		ctx := context.Background()
+		if auth := authorizerFromContext(r.Context()); auth != nil {
+			ctx = WithAuthorizer(ctx, auth)
+		}
+		if id, ok := r.Context().Value(clientIDContextKey{}).(string); ok {
+			ctx = WithClientID(ctx, id)
+		}
 		codec := newWebsocketCodec(conn)
-		s.ServeCodec(codec, 0)
+		s.serveCodec(ctx, codec)
 	})
 }
 